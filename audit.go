@@ -0,0 +1,213 @@
+package reflektor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditOp names the operation an AuditEvent records.
+type AuditOp string
+
+const (
+	// AuditLoad is a library or script load, successful or not.
+	AuditLoad AuditOp = "load"
+	// AuditCall is a call through the Call and CallExport families.
+	AuditCall AuditOp = "call"
+	// AuditStart is an export started with StartExport. The event is
+	// recorded once the export has started, not when it returns.
+	AuditStart AuditOp = "start"
+	// AuditEntry is a static-PIE entry point started with StartEntry.
+	AuditEntry AuditOp = "entry"
+	// AuditClose is a library being closed.
+	AuditClose AuditOp = "close"
+)
+
+// AuditEvent records one reflektor operation.
+type AuditEvent struct {
+	// Seq numbers the events of an audit log from 1, so gaps show.
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Op   AuditOp   `json:"op"`
+	// Payload is the hex SHA-256 of the payload bytes handed to the load:
+	// the image as passed to LoadLibraryWithOptions, or the unpacked image
+	// for reader loads and the decrypted one for sealed loads.
+	Payload string `json:"payload,omitempty"`
+	// Export is the export called or started.
+	Export string    `json:"export,omitempty"`
+	Args   []uintptr `json:"args,omitempty"`
+	// Result is what a call returned. Calls that failed, or that
+	// CallContext stopped waiting for, have a zero Result.
+	Result CallResult `json:"result"`
+	// Err is the operation's error, empty when it succeeded.
+	Err string `json:"err,omitempty"`
+	// MAC chains the event to the one before it when the log has a key:
+	// HMAC-SHA256 over the previous event's MAC and this event without its
+	// MAC. VerifyAuditChain checks it.
+	MAC []byte `json:"mac,omitempty"`
+}
+
+// AuditSink receives the events of an audit log in order. Record is called
+// with the log's lock held, so sinks see one event at a time, and it should
+// return promptly: the operation being recorded waits for it.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to AuditSink.
+type AuditSinkFunc func(event AuditEvent)
+
+// Record calls f(event).
+func (f AuditSinkFunc) Record(event AuditEvent) {
+	f(event)
+}
+
+// auditLog is the process's audit log, installed by SetAuditLog.
+type auditLog struct {
+	mu   sync.Mutex
+	sink AuditSink
+	key  []byte
+	seq  uint64
+	prev []byte
+}
+
+var currentAuditLog atomic.Pointer[auditLog]
+
+// SetAuditLog sends an event for every load, call, and close made through
+// reflektor in the process to sink, from now on, for post-engagement
+// reporting and compliance. With a non-empty key the events are HMAC-chained
+// (see AuditEvent.MAC). A nil sink stops auditing. Each call starts a new log
+// numbered from 1.
+func SetAuditLog(sink AuditSink, key []byte) {
+	if sink == nil {
+		currentAuditLog.Store(nil)
+		return
+	}
+	currentAuditLog.Store(&auditLog{sink: sink, key: bytes.Clone(key)})
+}
+
+// auditing reports whether an audit log is installed.
+func auditing() bool {
+	return currentAuditLog.Load() != nil
+}
+
+// auditDigest returns the hex SHA-256 of data, or "" when nothing is audited.
+func auditDigest(data []byte) string {
+	if !auditing() {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit numbers, timestamps, and chains event with err, and sends it to
+// the audit log, if there is one.
+func recordAudit(event AuditEvent, err error) {
+	log := currentAuditLog.Load()
+	if log == nil {
+		return
+	}
+	if err != nil {
+		event.Err = err.Error()
+	}
+	event.Args = append([]uintptr(nil), event.Args...)
+	event.Time = time.Now().UTC()
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.seq++
+	event.Seq = log.seq
+	if len(log.key) != 0 {
+		event.MAC = auditMAC(log.key, log.prev, event)
+		log.prev = event.MAC
+	}
+	log.sink.Record(event)
+}
+
+// auditMAC computes event's chained MAC from the previous event's.
+func auditMAC(key, prev []byte, event AuditEvent) []byte {
+	event.MAC = nil
+	data, err := json.Marshal(event)
+	if err != nil {
+		// AuditEvent has no fields json cannot encode.
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prev)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// ErrAuditChain is returned by VerifyAuditChain when events were altered,
+// dropped, or reordered.
+var ErrAuditChain = errors.New("reflektor: audit chain broken")
+
+// VerifyAuditChain checks that events are an unaltered run of an HMAC-chained
+// audit log recorded with key, starting at its first event.
+func VerifyAuditChain(events []AuditEvent, key []byte) error {
+	var prev []byte
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			return fmt.Errorf("%w: event %d has sequence number %d", ErrAuditChain, i, event.Seq)
+		}
+		want := auditMAC(key, prev, event)
+		if !hmac.Equal(event.MAC, want) {
+			return fmt.Errorf("%w: event %d has the wrong MAC", ErrAuditChain, event.Seq)
+		}
+		prev = event.MAC
+	}
+	return nil
+}
+
+// AuditWriter is an AuditSink that appends events to a writer as JSON lines,
+// which ReadAuditLog reads back.
+type AuditWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewAuditWriter returns an AuditWriter appending to w.
+func NewAuditWriter(w io.Writer) *AuditWriter {
+	return &AuditWriter{enc: json.NewEncoder(w)}
+}
+
+// Record appends event. After a write fails, later events are dropped; Err
+// reports the failure.
+func (writer *AuditWriter) Record(event AuditEvent) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if writer.err == nil {
+		writer.err = writer.enc.Encode(event)
+	}
+}
+
+// Err returns the first error writing an event, if any.
+func (writer *AuditWriter) Err() error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.err
+}
+
+// ReadAuditLog decodes the events an AuditWriter wrote to r.
+func ReadAuditLog(r io.Reader) ([]AuditEvent, error) {
+	dec := json.NewDecoder(r)
+	var events []AuditEvent
+	for {
+		var event AuditEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, fmt.Errorf("reflektor: read audit log: %w", err)
+		}
+		events = append(events, event)
+	}
+}
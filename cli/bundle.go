@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/memmod"
+	"github.com/spf13/cobra"
+)
+
+var bundleOutput string
+
+// bundleFormatOS maps an image format to the GOOS that loads it.
+var bundleFormatOS = map[string]string{"elf": "linux", "pe": "windows", "macho": "darwin"}
+
+var bundleCmd = &cobra.Command{
+	Use:          "bundle [goos/goarch=]<image>...",
+	Short:        "Combine per-platform images into one payload; targets are read from each image unless given",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slices := make([]compress.BundleSlice, 0, len(args))
+		for _, arg := range args {
+			target, path, found := strings.Cut(arg, "=")
+			if !found {
+				target, path = "", arg
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read image: %w", err)
+			}
+			if target == "" {
+				if target, err = imageTarget(data); err != nil {
+					return fmt.Errorf("%s: %w; name the target as goos/goarch=%s", path, err, path)
+				}
+			}
+			slices = append(slices, compress.BundleSlice{Target: target, Image: data})
+		}
+
+		bundle, err := compress.Bundle(slices)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(bundleOutput, bundle, 0o644); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		for _, slice := range slices {
+			fmt.Fprintf(out, "  %s\t%d bytes\n", slice.Target, len(slice.Image))
+		}
+		fmt.Fprintf(out, "%s: %d slices, %d bytes\n", bundleOutput, len(slices), len(bundle))
+		return nil
+	},
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "bundle.bin", "Path for the bundle")
+	rootCmd.AddCommand(bundleCmd)
+}
+
+// imageTarget reads the goos/goarch an image is built for from its headers,
+// unpacking it first if it is packed.
+func imageTarget(data []byte) (string, error) {
+	image, err := compress.Unpack(data, 0)
+	if err != nil {
+		return "", err
+	}
+	info, err := memmod.InspectHeaders(image)
+	if err != nil {
+		return "", err
+	}
+	goos, ok := bundleFormatOS[info.Format]
+	if !ok {
+		return "", fmt.Errorf("no target for %s images", info.Format)
+	}
+	return goos + "/" + info.Arch, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// Exit statuses, so scripts wrapping the CLI can branch on $? rather than
+// parse error text. They are part of the CLI's interface: a number never
+// changes meaning, and new failure classes get new numbers.
+const (
+	exitOK = 0
+	// exitFailure is any failure without a class of its own.
+	exitFailure = 1
+	// exitCrash is a payload that crashed. A fault in payload code on linux
+	// or darwin takes the process down through the Go runtime, which exits
+	// with this status itself; an export the loader caught faulting reports
+	// it too.
+	exitCrash = 2
+	// exitForeignPlatform is a payload built for another architecture or
+	// operating system, or a bundle with no slice for this one.
+	exitForeignPlatform = 3
+	// exitSymbolNotFound is an export that does not exist or an import the
+	// payload needs that could not be bound.
+	exitSymbolNotFound = 4
+	// exitDyld is a darwin dyld this loader does not know how to drive.
+	exitDyld = 5
+)
+
+// exitStatus returns the exit status that reports err.
+func exitStatus(err error) int {
+	var loaderErr *memmod.LoaderError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, reflektor.ErrExportException), errors.Is(err, reflektor.ErrExportKilled):
+		return exitCrash
+	case errors.Is(err, reflektor.ErrForeignPlatform), errors.Is(err, compress.ErrNoBundleSlice):
+		return exitForeignPlatform
+	case errors.Is(err, reflektor.ErrSymbolNotFound):
+		return exitSymbolNotFound
+	case errors.As(err, &loaderErr):
+		switch loaderErr.Stage {
+		case memmod.LoaderStageDyldCache, memmod.LoaderStageRuntimeAPI, memmod.LoaderStageSymbols:
+			return exitDyld
+		}
+	}
+	return exitFailure
+}
@@ -0,0 +1,20 @@
+module github.com/sliverarmory/reflektor/cli
+
+go 1.25.6
+
+require (
+	github.com/sliverarmory/reflektor v0.0.0-00010101000000-000000000000
+	github.com/spf13/cobra v1.10.2
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/klauspost/compress v1.20.1 // indirect
+	github.com/spf13/pflag v1.0.9 // indirect
+	github.com/ulikunitz/xz v0.5.15 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/crypto v0.43.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+)
+
+replace github.com/sliverarmory/reflektor => ../
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sliverarmory/reflektor/demangle"
+	"github.com/sliverarmory/reflektor/memmod"
+	"github.com/spf13/cobra"
+)
+
+var demangleExports bool
+
+var inspectCmd = &cobra.Command{
+	Use:          "inspect <image>",
+	Short:        "Print an image's format, architecture, size report, overlay, sections, imports, and exports",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := inspectFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "format:  %s\narch:    %s\n", info.Format, info.Arch)
+		fmt.Fprintf(out, "size:    %d bytes (~%d compressed, %.0f%%)\n", info.Size, info.CompressedSize, 100*float64(info.CompressedSize)/float64(max(info.Size, 1)))
+		fmt.Fprintf(out, "mapped:  %#x bytes\n", info.ImageSize)
+		if info.Entry != 0 {
+			fmt.Fprintf(out, "entry:   base+%#x\n", info.Entry)
+		} else {
+			fmt.Fprintln(out, "entry:   none")
+		}
+		fmt.Fprintf(out, "entropy: %.2f bits/byte\nstrings: %d\n", info.Entropy, info.Strings)
+		if info.Debug {
+			fmt.Fprintln(out, "debug:   yes (debug sections or symbol table present; see `reflektor strip`)")
+		} else {
+			fmt.Fprintln(out, "debug:   no")
+		}
+		if info.Encrypted {
+			fmt.Fprintln(out, "encrypted: yes (FairPlay; this image cannot be loaded)")
+		}
+		if info.Overlay != 0 {
+			fmt.Fprintf(out, "overlay: %d bytes at offset %#x (data appended after the image)\n", info.Overlay, info.OverlayOffset)
+		}
+
+		fmt.Fprintln(out, "\nsections:")
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, section := range info.Sections {
+			fmt.Fprintf(w, "  %s\t%#x\t%#x\t%.2f\n", section.Name, section.Address, section.Size, section.Entropy)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "\nimports:")
+		for _, name := range info.Imports {
+			fmt.Fprintf(out, "  %s\n", name)
+		}
+		fmt.Fprintln(out, "\nexports:")
+		return writeExports(out, info, "  ")
+	},
+}
+
+var exportsCmd = &cobra.Command{
+	Use:          "exports <image>",
+	Short:        "List an image's exported symbols",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := inspectFile(args[0])
+		if err != nil {
+			return err
+		}
+		return writeExports(cmd.OutOrStdout(), info, "")
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{inspectCmd, exportsCmd} {
+		cmd.Flags().BoolVarP(&demangleExports, "demangle", "C", false, "Print C++ and Swift export names demangled")
+	}
+	rootCmd.AddCommand(inspectCmd, exportsCmd)
+}
+
+func inspectFile(path string) (*memmod.ImageInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read image: %w", err)
+	}
+	return memmod.InspectImage(data)
+}
+
+// writeExports prints one export per line; PE exports lead with their ordinal
+// and forwarded exports end with their target. With --demangle, mangled names
+// are printed as their source spells them.
+func writeExports(out io.Writer, info *memmod.ImageInfo, indent string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, export := range info.Exports {
+		name := export.Name
+		switch {
+		case name == "":
+			name = "(ordinal only)"
+		case demangleExports:
+			name = demangle.Demangle(name)
+		}
+		switch {
+		case info.Format != "pe":
+			fmt.Fprintf(w, "%s%s\t%#x\n", indent, name, export.Address)
+		case export.Forwarder != "":
+			fmt.Fprintf(w, "%s%d\t%s\t-> %s\n", indent, export.Ordinal, name, export.Forwarder)
+		default:
+			fmt.Fprintf(w, "%s%d\t%s\t%#x\n", indent, export.Ordinal, name, export.Address)
+		}
+	}
+	return w.Flush()
+}
@@ -5,7 +5,5 @@ import (
 )
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
-	}
+	os.Exit(exitStatus(rootCmd.Execute()))
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/spf13/cobra"
+)
+
+var (
+	packFormat string
+	packAPLib  bool
+	packKey    string
+	packCipher string
+	packOutput string
+)
+
+var packCmd = &cobra.Command{
+	Use:          "pack <image>",
+	Short:        "Pack an image as AP32 or zstd, optionally sealed with a key, for the loader to run",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("read image: %w", err)
+		}
+		codec := compress.Codec(strings.ToLower(packFormat))
+		if packAPLib {
+			if cmd.Flags().Changed("format") && codec != compress.CodecAP32 {
+				return fmt.Errorf("--aplib conflicts with --format %s", packFormat)
+			}
+			codec = compress.CodecAP32
+		}
+		packed, err := compress.Pack(data, codec)
+		if err != nil {
+			return err
+		}
+		detail := string(codec)
+		if packKey != "" {
+			key, err := parseKey(packKey)
+			if err != nil {
+				return err
+			}
+			scheme := reflektor.CipherScheme(packCipher)
+			if packed, err = reflektor.SealLibrary(packed, key, scheme); err != nil {
+				return err
+			}
+			detail += ", " + string(scheme)
+		}
+
+		output := packOutput
+		if output == "" {
+			output = args[0] + ".packed"
+		}
+		if err := os.WriteFile(output, packed, 0o644); err != nil {
+			return fmt.Errorf("write packed image: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %d -> %d bytes (%s)\n", output, len(data), len(packed), detail)
+		return nil
+	},
+}
+
+func init() {
+	packCmd.Flags().StringVar(&packFormat, "format", string(compress.CodecAP32), "Packing format: ap32 or zstd")
+	packCmd.Flags().BoolVar(&packAPLib, "aplib", false, "Pack as aPLib AP32; the same as --format ap32")
+	packCmd.Flags().StringVar(&packKey, "key", "", "Hex-encoded 32-byte key to seal the packed image with")
+	packCmd.Flags().StringVar(&packCipher, "cipher", string(reflektor.CipherAES256GCM), "Cipher used with --key: aes-256-gcm or chacha20-poly1305")
+	packCmd.Flags().StringVarP(&packOutput, "output", "o", "", "Path for the packed image (default <image>.packed)")
+	rootCmd.AddCommand(packCmd)
+}
+
+// parseKey decodes a --key value.
+func parseKey(value string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("--key: %w", err)
+	}
+	return key, nil
+}
@@ -1,31 +1,64 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/memmod"
 	"github.com/spf13/cobra"
 )
 
 var (
-	callExport string
+	callExport  string
+	callArgs    string
+	printReturn bool
+	runKey      string
+	runCipher   string
+	runBase64   bool
 )
 
 var rootCmd = &cobra.Command{
-	Use:          "reflektor <shared library>",
+	Use:          "reflektor <shared library | ->",
 	Short:        "Load a shared library and call an exported function without writing to disk",
 	Args:         cobra.ExactArgs(1),
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		library, err := reflektor.LoadLibraryFile(args[0])
+		arena := memmod.NewArena()
+		defer arena.Release()
+		values, err := parseCallArgs(callArgs, arena)
+		if err != nil {
+			return err
+		}
+
+		library, err := loadLibrary(args[0])
 		if err != nil {
 			return err
 		}
 		defer library.Close()
 
-		if err := library.CallExport(callExport); err != nil {
+		result, _, err := library.CallContext(context.Background(), callExport, values...)
+		if err != nil {
 			return err
 		}
+		if printReturn {
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "return: %d (%#x)\n", result.Value, result.Value)
+			if result.Errno != 0 {
+				fmt.Fprintf(out, "errno:  %d (%v)\n", uintptr(result.Errno), result.Errno)
+			} else {
+				fmt.Fprintln(out, "errno:  0")
+			}
+			fmt.Fprintf(out, "thread: %d\n", result.ThreadID)
+			return nil
+		}
 		fmt.Fprintln(cmd.OutOrStdout(), "ok")
 		return nil
 	},
@@ -33,4 +66,86 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.Flags().StringVar(&callExport, "call-export", "StartW", "Entry symbol to resolve in the shared library")
+	rootCmd.Flags().StringVar(&callArgs, "args", "", `Comma-separated export arguments: integers (1337, 0xdeadbeef, -1), "str:" for a NUL-terminated string, "wstr:" for a UTF-16 one`)
+	rootCmd.Flags().BoolVar(&printReturn, "print-return", false, "Print the export's raw return value instead of ok")
+	rootCmd.Flags().StringVar(&runKey, "key", "", "Hex-encoded 32-byte key the shared library was sealed with (see pack)")
+	rootCmd.Flags().StringVar(&runCipher, "cipher", string(reflektor.CipherAES256GCM), "Cipher used with --key: aes-256-gcm or chacha20-poly1305")
+	rootCmd.Flags().BoolVar(&runBase64, "b64", false, "The shared library is base64-encoded")
+}
+
+// loadLibrary loads path, or standard input when path is "-", decoding it
+// when --b64 is set and decrypting it when --key is set.
+func loadLibrary(path string) (*reflektor.Library, error) {
+	if path != "-" && !runBase64 && runKey == "" {
+		return reflektor.LoadLibraryFile(path)
+	}
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("read library file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	if runBase64 {
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	if runKey == "" {
+		return reflektor.LoadLibraryFromReader(r)
+	}
+	key, err := parseKey(runKey)
+	if err != nil {
+		return nil, err
+	}
+	return reflektor.LoadEncryptedLibraryFromReader(r, key, reflektor.CipherScheme(runCipher))
+}
+
+// parseCallArgs turns the --args list into call arguments. String arguments
+// are copied into arena and passed as pointers to them, so they stay put
+// until the arena is released.
+func parseCallArgs(list string, arena *memmod.Arena) ([]uintptr, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	fields := strings.Split(list, ",")
+	if len(fields) > memmod.MaxCallArgs {
+		return nil, fmt.Errorf("--args: %d arguments given, exports take at most %d", len(fields), memmod.MaxCallArgs)
+	}
+	var values []uintptr
+	for _, field := range fields {
+		var (
+			value uintptr
+			err   error
+		)
+		if s, ok := strings.CutPrefix(field, "str:"); ok {
+			value, err = arena.CString(s)
+		} else if s, ok := strings.CutPrefix(field, "wstr:"); ok {
+			var buf []byte
+			for _, unit := range utf16.Encode([]rune(s)) {
+				buf = append(buf, byte(unit), byte(unit>>8))
+			}
+			value, err = arena.Bytes(append(buf, 0, 0), 2)
+		} else {
+			value, err = parseIntArg(strings.TrimSpace(field))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("--args: %q: %w", field, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// parseIntArg accepts any integer strconv understands with base prefixes.
+// Negative values are passed in two's complement.
+func parseIntArg(field string) (uintptr, error) {
+	if value, err := strconv.ParseUint(field, 0, 64); err == nil {
+		return uintptr(value), nil
+	}
+	value, err := strconv.ParseInt(field, 0, 64)
+	if err != nil {
+		return 0, errors.New("not an integer or str:/wstr: string")
+	}
+	return uintptr(value), nil
 }
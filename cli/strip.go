@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sliverarmory/reflektor/memmod"
+	"github.com/spf13/cobra"
+)
+
+var stripOutput string
+
+var stripCmd = &cobra.Command{
+	Use:          "strip <image>",
+	Short:        "Remove debug sections, local symbols, and code signatures from an image",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("read image: %w", err)
+		}
+		stripped, err := memmod.StripImage(data)
+		if err != nil {
+			return err
+		}
+
+		output := stripOutput
+		if output == "" {
+			output = args[0] + ".stripped"
+		}
+		if err := os.WriteFile(output, stripped, 0o644); err != nil {
+			return fmt.Errorf("write stripped image: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %d -> %d bytes\n", output, len(data), len(stripped))
+		return nil
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:          "validate <image>",
+	Short:        "Check that an image is structurally sound",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("read image: %w", err)
+		}
+		if err := memmod.ValidateImage(data); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "ok")
+		return writeEntryPoints(cmd.OutOrStdout(), data)
+	},
+}
+
+func init() {
+	stripCmd.Flags().StringVarP(&stripOutput, "output", "o", "", "Path for the stripped image (default <image>.stripped)")
+	rootCmd.AddCommand(stripCmd, validateCmd)
+}
+
+// writeEntryPoints lists what is still callable in a PE image whose exports
+// are stripped or unnamed, so there is no export name to pass to CallExport.
+func writeEntryPoints(out io.Writer, data []byte) error {
+	if !bytes.HasPrefix(data, []byte("MZ")) {
+		return nil
+	}
+	info, err := memmod.InspectImage(data)
+	if err != nil {
+		return err
+	}
+	for _, export := range info.Exports {
+		if export.Name != "" {
+			return nil
+		}
+	}
+	points, err := memmod.PEEntryPoints(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "\nno named exports; entry points:")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, point := range points {
+		detail := ""
+		if point.Kind == memmod.EntryPointOrdinal {
+			detail = fmt.Sprintf("ordinal %d", point.Ordinal)
+		}
+		if point.Unwind {
+			detail = strings.TrimSpace(detail + " (in .pdata)")
+		}
+		if detail == "" {
+			fmt.Fprintf(w, "  %s\t%#x\n", point.Kind, point.Address)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%#x\t%s\n", point.Kind, point.Address, detail)
+	}
+	return w.Flush()
+}
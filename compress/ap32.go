@@ -0,0 +1,281 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// AP32HeaderSize is the size of the header PackAP32 writes.
+const AP32HeaderSize = 24
+
+var ap32Tag = []byte("AP32")
+
+// IsAP32 reports whether data starts with an AP32 header.
+func IsAP32(data []byte) bool {
+	return bytes.HasPrefix(data, ap32Tag)
+}
+
+// DepackAP32 unpacks an AP32-packed payload into a new slice. The payload is
+// the 24-byte header aPLib's aPsafe_pack writes, followed by the aPLib
+// bitstream:
+//
+//	offset size field
+//	0      4    tag "AP32"
+//	4      4    header size (24)
+//	8      4    packed size
+//	12     4    CRC-32 of the packed data
+//	16     4    original size
+//	20     4    CRC-32 of the original data
+//
+// All fields are little-endian and the checksums are IEEE CRC-32. maxSize
+// bounds the unpacked size; zero means MaxUnpackedSize. Every read and
+// back-reference is bounds checked, so malformed input returns ErrCorrupt
+// rather than reading or writing out of range.
+func DepackAP32(data []byte, maxSize uint64) ([]byte, error) {
+	if !IsAP32(data) {
+		return nil, errors.New("compress: missing AP32 tag")
+	}
+	if len(data) < AP32HeaderSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	headerSize := uint64(binary.LittleEndian.Uint32(data[4:]))
+	packedSize := uint64(binary.LittleEndian.Uint32(data[8:]))
+	packedCRC := binary.LittleEndian.Uint32(data[12:])
+	origSize := uint64(binary.LittleEndian.Uint32(data[16:]))
+	origCRC := binary.LittleEndian.Uint32(data[20:])
+
+	if headerSize < AP32HeaderSize || headerSize+packedSize > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: header size %d and packed size %d exceed %d bytes", ErrCorrupt, headerSize, packedSize, len(data))
+	}
+	if limit := sizeLimit(maxSize); origSize > limit {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrSizeLimit, origSize, limit)
+	}
+	packed := data[headerSize : headerSize+packedSize]
+	if crc32.ChecksumIEEE(packed) != packedCRC {
+		return nil, fmt.Errorf("%w: packed data checksum mismatch", ErrCorrupt)
+	}
+
+	out, err := depack(packed, int(origSize))
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(out) != origCRC {
+		return nil, fmt.Errorf("%w: unpacked data checksum mismatch", ErrCorrupt)
+	}
+	return out, nil
+}
+
+// depacker reads an aPLib bitstream. Tag bytes carrying the control bits are
+// interleaved with literal and offset bytes in the order they are needed.
+type depacker struct {
+	src      []byte
+	pos      int
+	tag      byte
+	bitcount int
+	out      []byte
+}
+
+func (d *depacker) byte() (byte, error) {
+	if d.pos >= len(d.src) {
+		return 0, fmt.Errorf("%w: packed data ends early", ErrCorrupt)
+	}
+	b := d.src[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *depacker) bit() (uint32, error) {
+	if d.bitcount == 0 {
+		tag, err := d.byte()
+		if err != nil {
+			return 0, err
+		}
+		d.tag = tag
+		d.bitcount = 8
+	}
+	d.bitcount--
+	bit := uint32(d.tag >> 7)
+	d.tag <<= 1
+	return bit, nil
+}
+
+func (d *depacker) gamma() (uint32, error) {
+	result := uint32(1)
+	for {
+		bit, err := d.bit()
+		if err != nil {
+			return 0, err
+		}
+		if result > math.MaxInt32>>1 {
+			return 0, fmt.Errorf("%w: gamma code overflows", ErrCorrupt)
+		}
+		result = result<<1 + bit
+		more, err := d.bit()
+		if err != nil {
+			return 0, err
+		}
+		if more == 0 {
+			return result, nil
+		}
+	}
+}
+
+// copyMatch appends length bytes starting offset bytes back. Source and
+// destination may overlap, as in the reference depacker.
+func (d *depacker) copyMatch(offset, length uint32) error {
+	if offset == 0 || uint64(offset) > uint64(len(d.out)) {
+		return fmt.Errorf("%w: match offset %d outside %d bytes of output", ErrCorrupt, offset, len(d.out))
+	}
+	if uint64(length) > uint64(cap(d.out)-len(d.out)) {
+		return fmt.Errorf("%w: match runs past the original size", ErrCorrupt)
+	}
+	for range length {
+		d.out = append(d.out, d.out[len(d.out)-int(offset)])
+	}
+	return nil
+}
+
+func (d *depacker) literal(b byte) error {
+	if len(d.out) == cap(d.out) {
+		return fmt.Errorf("%w: literal past the original size", ErrCorrupt)
+	}
+	d.out = append(d.out, b)
+	return nil
+}
+
+func depack(src []byte, size int) ([]byte, error) {
+	d := &depacker{src: src, out: make([]byte, 0, size)}
+	if size == 0 {
+		if len(src) != 0 {
+			return nil, fmt.Errorf("%w: packed data for an empty payload", ErrCorrupt)
+		}
+		return d.out, nil
+	}
+
+	first, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	d.out = append(d.out, first)
+
+	var (
+		r0  uint32
+		lwm bool
+	)
+	for {
+		bit, err := d.bit()
+		if err != nil {
+			return nil, err
+		}
+		if bit == 0 {
+			b, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if err := d.literal(b); err != nil {
+				return nil, err
+			}
+			lwm = false
+			continue
+		}
+
+		if bit, err = d.bit(); err != nil {
+			return nil, err
+		}
+		if bit == 0 {
+			// Gamma-coded match, or a repeat of the last offset.
+			hi, err := d.gamma()
+			if err != nil {
+				return nil, err
+			}
+			if !lwm && hi == 2 {
+				length, err := d.gamma()
+				if err != nil {
+					return nil, err
+				}
+				if err := d.copyMatch(r0, length); err != nil {
+					return nil, err
+				}
+			} else {
+				if lwm {
+					hi -= 2
+				} else {
+					hi -= 3
+				}
+				lo, err := d.byte()
+				if err != nil {
+					return nil, err
+				}
+				offset := hi<<8 + uint32(lo)
+				length, err := d.gamma()
+				if err != nil {
+					return nil, err
+				}
+				if offset >= 32000 {
+					length++
+				}
+				if offset >= 1280 {
+					length++
+				}
+				if offset < 128 {
+					length += 2
+				}
+				if err := d.copyMatch(offset, length); err != nil {
+					return nil, err
+				}
+				r0 = offset
+			}
+			lwm = true
+			continue
+		}
+
+		if bit, err = d.bit(); err != nil {
+			return nil, err
+		}
+		if bit == 0 {
+			// 7-bit offset with a 2 or 3 byte length; offset 0 ends the stream.
+			b, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			offset, length := uint32(b>>1), uint32(2+b&1)
+			if offset == 0 {
+				break
+			}
+			if err := d.copyMatch(offset, length); err != nil {
+				return nil, err
+			}
+			r0 = offset
+			lwm = true
+			continue
+		}
+
+		// Single byte from a 4-bit offset, or a zero byte.
+		var offset uint32
+		for range 4 {
+			bit, err := d.bit()
+			if err != nil {
+				return nil, err
+			}
+			offset = offset<<1 + bit
+		}
+		if offset == 0 {
+			err = d.literal(0)
+		} else {
+			err = d.copyMatch(offset, 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		lwm = false
+	}
+
+	if len(d.out) != size {
+		return nil, fmt.Errorf("%w: unpacked %d bytes, header records %d", ErrCorrupt, len(d.out), size)
+	}
+	return d.out, nil
+}
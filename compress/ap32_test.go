@@ -0,0 +1,88 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestPackDepackAP32RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	rng.Read(random)
+	executable, err := os.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("read test binary: %v", err)
+	}
+
+	inputs := map[string][]byte{
+		"empty":      nil,
+		"one byte":   {0x7f},
+		"zeros":      make([]byte, 100000),
+		"text":       bytes.Repeat([]byte("reflektor loads libraries from memory. "), 500),
+		"random":     random,
+		"executable": executable[:min(len(executable), 1<<20)],
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			packed, err := PackAP32(data)
+			if err != nil {
+				t.Fatalf("PackAP32: %v", err)
+			}
+			if !IsAP32(packed) {
+				t.Fatal("packed data has no AP32 tag")
+			}
+			got, err := DepackAP32(packed, 0)
+			if err != nil {
+				t.Fatalf("DepackAP32: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestDepackAP32RejectsBadInput(t *testing.T) {
+	data := bytes.Repeat([]byte("abcdefgh"), 1000)
+	packed, err := PackAP32(data)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+
+	if _, err := DepackAP32(packed, uint64(len(data)-1)); !errors.Is(err, ErrSizeLimit) {
+		t.Fatalf("size limit: err = %v, want ErrSizeLimit", err)
+	}
+	if _, err := DepackAP32(packed[:len(packed)-1], 0); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("truncated: err = %v, want ErrCorrupt", err)
+	}
+
+	flipped := bytes.Clone(packed)
+	flipped[len(flipped)-2] ^= 0x40
+	if _, err := DepackAP32(flipped, 0); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("flipped packed byte: err = %v, want ErrCorrupt", err)
+	}
+
+	// A stream that passes its own checksum but decodes badly must still
+	// fail cleanly: a back-reference before the start of the output.
+	stream := []byte{'A', 0xc0, 0x7e, 0x00}
+	forged := make([]byte, AP32HeaderSize, AP32HeaderSize+len(stream))
+	copy(forged, "AP32")
+	binary.LittleEndian.PutUint32(forged[4:], AP32HeaderSize)
+	binary.LittleEndian.PutUint32(forged[8:], uint32(len(stream)))
+	binary.LittleEndian.PutUint32(forged[12:], crc32.ChecksumIEEE(stream))
+	binary.LittleEndian.PutUint32(forged[16:], 4)
+	forged = append(forged, stream...)
+	if _, err := DepackAP32(forged, 0); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("offset before start: err = %v, want ErrCorrupt", err)
+	}
+
+	plain := []byte("\x7fELF")
+	if got, err := Unpack(plain, 0); err != nil || &got[0] != &plain[0] {
+		t.Fatalf("Unpack on unpacked data = %p, %v; want the input back", got, err)
+	}
+}
@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// CodecBundle is a multi-platform bundle written by Bundle: one image per
+// GOOS/GOARCH target, of which Unpack keeps the one for the running platform.
+const CodecBundle Codec = "bundle"
+
+// ErrNoBundleSlice is returned when a bundle has no image for the running
+// platform.
+var ErrNoBundleSlice = errors.New("compress: bundle has no slice for this platform")
+
+// A bundle is bundleMagic, a little-endian uint32 slice count, and then one
+// bundleEntrySize-byte entry per slice: the target, NUL-padded to
+// bundleTargetSize bytes, and the slice's offset from the start of the bundle
+// and its size, both uint64. The slices follow the table.
+var bundleMagic = []byte("RFKBNDL1")
+
+const (
+	bundleTargetSize = 16
+	bundleEntrySize  = bundleTargetSize + 16
+	bundleHeaderSize = 12
+	// maxBundleSlices bounds the table so a corrupt count cannot make
+	// BundleSlices allocate much.
+	maxBundleSlices = 64
+)
+
+// BundleSlice is one target's image in a bundle.
+type BundleSlice struct {
+	// Target is "goos/goarch", such as "linux/amd64".
+	Target string
+	// Image is the target's image. It may itself be packed, in which case
+	// Unpack unpacks it once it has been picked out.
+	Image []byte
+}
+
+// Bundle builds a bundle holding each slice, so one payload loads on every
+// platform it has an image for. Targets must be distinct.
+func Bundle(slices []BundleSlice) ([]byte, error) {
+	if len(slices) == 0 || len(slices) > maxBundleSlices {
+		return nil, fmt.Errorf("compress: a bundle holds 1 to %d slices, not %d", maxBundleSlices, len(slices))
+	}
+	offset := uint64(bundleHeaderSize + len(slices)*bundleEntrySize)
+	size := offset
+	for _, slice := range slices {
+		size += uint64(len(slice.Image))
+	}
+	out := make([]byte, offset, size)
+	copy(out, bundleMagic)
+	binary.LittleEndian.PutUint32(out[len(bundleMagic):], uint32(len(slices)))
+
+	seen := make(map[string]bool)
+	for i, slice := range slices {
+		if err := checkBundleTarget(slice.Target); err != nil {
+			return nil, err
+		}
+		if seen[slice.Target] {
+			return nil, fmt.Errorf("compress: bundle target %s given twice", slice.Target)
+		}
+		seen[slice.Target] = true
+
+		entry := out[bundleHeaderSize+i*bundleEntrySize:]
+		copy(entry, slice.Target)
+		binary.LittleEndian.PutUint64(entry[bundleTargetSize:], uint64(len(out)))
+		binary.LittleEndian.PutUint64(entry[bundleTargetSize+8:], uint64(len(slice.Image)))
+		out = append(out, slice.Image...)
+	}
+	return out, nil
+}
+
+// BundleSlices lists the slices of a bundle in the order they were added.
+// The images alias data.
+func BundleSlices(data []byte) ([]BundleSlice, error) {
+	if !bytes.HasPrefix(data, bundleMagic) || len(data) < bundleHeaderSize {
+		return nil, fmt.Errorf("%w: not a bundle", ErrCorrupt)
+	}
+	count := binary.LittleEndian.Uint32(data[len(bundleMagic):])
+	if count == 0 || count > maxBundleSlices || uint64(len(data)) < bundleHeaderSize+uint64(count)*bundleEntrySize {
+		return nil, fmt.Errorf("%w: bundle table of %d slices does not fit", ErrCorrupt, count)
+	}
+
+	slices := make([]BundleSlice, count)
+	for i := range slices {
+		entry := data[bundleHeaderSize+i*bundleEntrySize:]
+		target, _, _ := bytes.Cut(entry[:bundleTargetSize], []byte{0})
+		offset := binary.LittleEndian.Uint64(entry[bundleTargetSize:])
+		size := binary.LittleEndian.Uint64(entry[bundleTargetSize+8:])
+		if offset > uint64(len(data)) || size > uint64(len(data))-offset {
+			return nil, fmt.Errorf("%w: bundle slice %s runs past the end", ErrCorrupt, target)
+		}
+		slices[i] = BundleSlice{Target: string(target), Image: data[offset : offset+size]}
+	}
+	return slices, nil
+}
+
+// unbundle returns a copy of the slice for the running platform, so the
+// bundle can be zeroed without touching it.
+func unbundle(data []byte, limit uint64) ([]byte, error) {
+	slices, err := BundleSlices(data)
+	if err != nil {
+		return nil, err
+	}
+	target := runtime.GOOS + "/" + runtime.GOARCH
+	for _, slice := range slices {
+		if slice.Target != target {
+			continue
+		}
+		if uint64(len(slice.Image)) > limit {
+			return nil, fmt.Errorf("%w: %s slice is %d bytes, limit %d", ErrSizeLimit, target, len(slice.Image), limit)
+		}
+		return bytes.Clone(slice.Image), nil
+	}
+	targets := make([]string, len(slices))
+	for i, slice := range slices {
+		targets[i] = slice.Target
+	}
+	return nil, fmt.Errorf("%w: want %s, have %s", ErrNoBundleSlice, target, strings.Join(targets, ", "))
+}
+
+func checkBundleTarget(target string) error {
+	goos, goarch, ok := strings.Cut(target, "/")
+	if !ok || goos == "" || goarch == "" || strings.ContainsAny(target, "\x00 ") || len(target) > bundleTargetSize {
+		return fmt.Errorf("compress: bundle target %q is not goos/goarch", target)
+	}
+	return nil
+}
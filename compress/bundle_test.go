@@ -0,0 +1,63 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestBundleSelectsTheRunningPlatform(t *testing.T) {
+	here := runtime.GOOS + "/" + runtime.GOARCH
+	data := bytes.Repeat([]byte("\x7fELF bundled payload "), 2000)
+	packed, err := PackAP32(data)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	bundle, err := Bundle([]BundleSlice{
+		{Target: "plan9/mips", Image: []byte("elsewhere")},
+		{Target: here, Image: packed},
+	})
+	if err != nil {
+		t.Fatalf("Bundle: %v", err)
+	}
+
+	slices, err := BundleSlices(bundle)
+	if err != nil || len(slices) != 2 || slices[0].Target != "plan9/mips" || slices[1].Target != here || !bytes.Equal(slices[1].Image, packed) {
+		t.Fatalf("BundleSlices = %+v, %v", slices, err)
+	}
+	// The slice is itself packed, and is unpacked in turn.
+	got, err := Unpack(bundle, 0)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Unpack = %d bytes, %v; want the %s slice unpacked", len(got), err, here)
+	}
+	if _, err := Unpack(bundle, uint64(len(packed)-1)); !errors.Is(err, ErrSizeLimit) {
+		t.Fatalf("Unpack under a small limit: err = %v, want ErrSizeLimit", err)
+	}
+
+	elsewhere, err := Bundle([]BundleSlice{{Target: "plan9/mips", Image: []byte("elsewhere")}})
+	if err != nil {
+		t.Fatalf("Bundle: %v", err)
+	}
+	if _, err := Unpack(elsewhere, 0); !errors.Is(err, ErrNoBundleSlice) {
+		t.Fatalf("Unpack without a %s slice: err = %v, want ErrNoBundleSlice", here, err)
+	}
+
+	for name, slices := range map[string][]BundleSlice{
+		"empty":     nil,
+		"duplicate": {{Target: here, Image: data}, {Target: here, Image: data}},
+		"no arch":   {{Target: runtime.GOOS, Image: data}},
+		"too long":  {{Target: "linux/amd64-with-a-suffix", Image: data}},
+	} {
+		if _, err := Bundle(slices); err == nil {
+			t.Errorf("Bundle(%s) succeeded", name)
+		}
+	}
+
+	// A slice that runs past the end of the bundle is corrupt.
+	binary.LittleEndian.PutUint64(bundle[bundleHeaderSize+bundleEntrySize+bundleTargetSize+8:], uint64(len(bundle)))
+	if _, err := Unpack(bundle, 0); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Unpack with a truncated slice: err = %v, want ErrCorrupt", err)
+	}
+}
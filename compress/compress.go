@@ -0,0 +1,173 @@
+// Package compress unpacks packed payloads before they reach a loader, so
+// the same packed payload loads on every platform. It recognizes aPLib's
+// AP32 format, zstd frames, XZ and LZMA streams, and multi-platform bundles
+// by their leading bytes, along with any format added with RegisterTransform.
+//
+// AP32 is always built. The zstd codec is built only with the reflektor_zstd
+// build tag and the XZ and LZMA decoders only with reflektor_xz;
+// reflektor_full builds both. Payloads in a format that was left out are
+// still recognized and fail with ErrCodecNotBuilt.
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MaxUnpackedSize is the unpacked size limit used when the caller sets none.
+const MaxUnpackedSize = math.MaxInt32
+
+var (
+	// ErrCorrupt is returned when packed data fails its checksums or
+	// decodes to something other than the size its header records.
+	ErrCorrupt = errors.New("compress: corrupt packed data")
+	// ErrSizeLimit is returned when packed data unpacks past the caller's
+	// limit. Formats that record the unpacked size are checked before
+	// anything is allocated.
+	ErrSizeLimit = errors.New("compress: packed data unpacks past size limit")
+	// ErrCodecNotBuilt is returned for payloads packed with a codec that
+	// build tags left out of this binary.
+	ErrCodecNotBuilt = errors.New("compress: codec not built")
+)
+
+// Codec names a packed payload format.
+type Codec string
+
+const (
+	// CodecNone is data that is not packed.
+	CodecNone Codec = ""
+	// CodecAP32 is aPLib's safe format; see DepackAP32.
+	CodecAP32 Codec = "ap32"
+	// CodecZstd is one or more zstd frames.
+	CodecZstd Codec = "zstd"
+	// CodecXZ is an XZ stream, as written by xz.
+	CodecXZ Codec = "xz"
+	// CodecLZMA is a legacy .lzma stream, as written by xz --format=lzma or
+	// lzma_alone. Having no magic, it is recognized by the default
+	// properties byte (lc=3, lp=0, pb=2) and a plausible dictionary size.
+	CodecLZMA Codec = "lzma"
+)
+
+// Codecs lists the formats Unpack can unpack in this build, registered
+// transforms included.
+func Codecs() []Codec {
+	codecs := []Codec{CodecAP32}
+	if zstdBuilt {
+		codecs = append(codecs, CodecZstd)
+	}
+	if xzBuilt {
+		codecs = append(codecs, CodecXZ, CodecLZMA)
+	}
+	codecs = append(codecs, CodecBundle)
+	return append(codecs, registeredCodecs()...)
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// lzmaHeaderSize is the properties byte, the dictionary size, and the
+// unpacked size of a .lzma stream.
+const lzmaHeaderSize = 13
+
+// Detect identifies the packed format of data from its leading bytes.
+func Detect(data []byte) Codec {
+	switch {
+	case IsAP32(data):
+		return CodecAP32
+	case bytes.HasPrefix(data, zstdMagic):
+		return CodecZstd
+	case bytes.HasPrefix(data, xzMagic):
+		return CodecXZ
+	case isLZMA(data):
+		return CodecLZMA
+	case bytes.HasPrefix(data, bundleMagic):
+		return CodecBundle
+	}
+	if t, ok := lookupTransform(data); ok {
+		return t.codec
+	}
+	return CodecNone
+}
+
+func isLZMA(data []byte) bool {
+	if len(data) < lzmaHeaderSize || data[0] != 0x5d {
+		return false
+	}
+	// Encoders pick dictionary sizes of 2^n or 2^n + 2^(n-1), at least 4 KiB.
+	dictSize := binary.LittleEndian.Uint32(data[1:])
+	rest := dictSize & (dictSize - 1)
+	return dictSize >= 4096 && (rest == 0 || rest&(rest-1) == 0 && dictSize == rest+rest>>1)
+}
+
+// Unpack returns data unpacked when Detect recognizes its format and data
+// itself otherwise. Layers are peeled until the result is in no known
+// format, so a payload packed with a registered transform and then AP32
+// unpacks in one call. maxSize bounds each unpacked layer; zero means
+// MaxUnpackedSize.
+func Unpack(data []byte, maxSize uint64) ([]byte, error) {
+	out, err := unpackLayer(data, maxSize)
+	if err != nil || Detect(out) == CodecNone {
+		return out, err
+	}
+	return unpackRest(out, maxSize)
+}
+
+// unpackRest peels the layers under the first, zeroing each intermediate
+// buffer once the next layer has been taken out of it.
+func unpackRest(data []byte, maxSize uint64) ([]byte, error) {
+	for layer := 1; Detect(data) != CodecNone; layer++ {
+		if layer == maxLayers {
+			clear(data)
+			return nil, fmt.Errorf("%w: more than %d packing layers", ErrCorrupt, maxLayers)
+		}
+		out, err := unpackLayer(data, maxSize)
+		clear(data)
+		if err != nil {
+			return nil, err
+		}
+		data = out
+	}
+	return data, nil
+}
+
+// unpackLayer unpacks the outermost layer of data.
+func unpackLayer(data []byte, maxSize uint64) ([]byte, error) {
+	limit := sizeLimit(maxSize)
+	switch codec := Detect(data); codec {
+	case CodecNone:
+		return data, nil
+	case CodecAP32:
+		return DepackAP32(data, maxSize)
+	case CodecZstd:
+		if !zstdBuilt {
+			return nil, fmt.Errorf("%w: %s", ErrCodecNotBuilt, codec)
+		}
+		return unpackZstd(data, limit)
+	case CodecXZ, CodecLZMA:
+		if !xzBuilt {
+			return nil, fmt.Errorf("%w: %s", ErrCodecNotBuilt, codec)
+		}
+		if codec == CodecLZMA {
+			if size := binary.LittleEndian.Uint64(data[5:]); size != math.MaxUint64 && size > limit {
+				return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrSizeLimit, size, limit)
+			}
+		}
+		return unpackXZ(data, codec, limit)
+	case CodecBundle:
+		return unbundle(data, limit)
+	}
+	t, _ := lookupTransform(data)
+	return t.apply(data, limit)
+}
+
+func sizeLimit(maxSize uint64) uint64 {
+	if maxSize == 0 || maxSize > MaxUnpackedSize {
+		return MaxUnpackedSize
+	}
+	return maxSize
+}
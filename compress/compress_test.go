@@ -0,0 +1,227 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"slices"
+	"testing"
+	"testing/iotest"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func packWith(t *testing.T, codec Codec, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch codec {
+	case CodecAP32:
+		packed, err := PackAP32(data)
+		if err != nil {
+			t.Fatalf("PackAP32: %v", err)
+		}
+		return packed
+	case CodecZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatalf("zstd.NewWriter: %v", err)
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(data, nil)
+	case CodecXZ:
+		w, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("xz.NewWriter: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("xz write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("xz close: %v", err)
+		}
+	case CodecLZMA:
+		w, err := lzma.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("lzma.NewWriter: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("lzma write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("lzma close: %v", err)
+		}
+	case CodecBundle:
+		bundle, err := Bundle([]BundleSlice{
+			{Target: "plan9/mips", Image: []byte("not this one")},
+			{Target: runtime.GOOS + "/" + runtime.GOARCH, Image: data},
+		})
+		if err != nil {
+			t.Fatalf("Bundle: %v", err)
+		}
+		return bundle
+	default:
+		t.Fatalf("no packer for %q", codec)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackRecognizesEveryCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("\x7fELF reflektor payload bytes "), 4000)
+	for _, codec := range []Codec{CodecAP32, CodecZstd, CodecXZ, CodecLZMA, CodecBundle} {
+		t.Run(string(codec), func(t *testing.T) {
+			packed := packWith(t, codec, data)
+			if got := Detect(packed); got != codec {
+				t.Fatalf("Detect = %q, want %q", got, codec)
+			}
+			if !slices.Contains(Codecs(), codec) {
+				if _, err := Unpack(packed, 0); !errors.Is(err, ErrCodecNotBuilt) {
+					t.Fatalf("Unpack with the codec left out: err = %v, want ErrCodecNotBuilt", err)
+				}
+				return
+			}
+			got, err := Unpack(packed, 0)
+			if err != nil {
+				t.Fatalf("Unpack: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("Unpack returned %d bytes, want %d", len(got), len(data))
+			}
+			if _, err := Unpack(packed, uint64(len(data)-1)); !errors.Is(err, ErrSizeLimit) {
+				t.Fatalf("Unpack over the limit: err = %v, want ErrSizeLimit", err)
+			}
+		})
+	}
+}
+
+func TestPackRoundTrips(t *testing.T) {
+	data := bytes.Repeat([]byte("\x7fELF packed by reflektor pack "), 3000)
+	for _, codec := range []Codec{CodecAP32, CodecZstd} {
+		packed, err := Pack(data, codec)
+		if !slices.Contains(Codecs(), codec) {
+			if !errors.Is(err, ErrCodecNotBuilt) {
+				t.Errorf("Pack(%s) with the codec left out: err = %v, want ErrCodecNotBuilt", codec, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Pack(%s): %v", codec, err)
+		}
+		if got := Detect(packed); got != codec {
+			t.Fatalf("Detect(Pack(%s)) = %q", codec, got)
+		}
+		got, err := Unpack(packed, 0)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Unpack(Pack(%s)) = %d bytes, %v; want the input back", codec, len(got), err)
+		}
+	}
+	if _, err := Pack(data, CodecXZ); err == nil {
+		t.Fatal("Pack(xz) succeeded; only ap32 and zstd can be written")
+	}
+}
+
+func TestDetectLeavesImagesAlone(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"elf":   []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00"),
+		"pe":    []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff"),
+		"macho": {0xcf, 0xfa, 0xed, 0xfe, 0x0c, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0},
+		"lua":   []byte("]] -- not a properties byte followed by a dictionary size"),
+		"empty": nil,
+	} {
+		if codec := Detect(data); codec != CodecNone {
+			t.Errorf("Detect(%s) = %q, want none", name, codec)
+		}
+		if got, err := Unpack(data, 0); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Unpack(%s) = %d bytes, %v; want the input back", name, len(got), err)
+		}
+	}
+}
+
+func TestUnpackReaderStreamsEveryCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("\x7fELF streamed payload "), 20000)
+	for _, codec := range append([]Codec{CodecNone}, Codecs()...) {
+		t.Run(codecName(codec), func(t *testing.T) {
+			packed := data
+			if codec != CodecNone {
+				packed = packWith(t, codec, data)
+			}
+			for _, hint := range []int{0, len(data)} {
+				got, detected, err := UnpackReaderCodec(iotest.HalfReader(bytes.NewReader(packed)), hint, 0)
+				if err != nil {
+					t.Fatalf("UnpackReaderCodec(hint %d): %v", hint, err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("UnpackReaderCodec(hint %d) returned %d bytes, want %d", hint, len(got), len(data))
+				}
+				if detected != codec {
+					t.Fatalf("UnpackReaderCodec(hint %d) reported %q, want %q", hint, detected, codec)
+				}
+			}
+			if _, err := UnpackReader(bytes.NewReader(packed), 0, uint64(len(data)-1)); !errors.Is(err, ErrSizeLimit) {
+				t.Fatalf("UnpackReader over the limit: err = %v, want ErrSizeLimit", err)
+			}
+		})
+	}
+}
+
+// xorTransform is a stand-in for a proprietary obfuscator.
+func xorTransform(data []byte, limit uint64) ([]byte, error) {
+	if uint64(len(data)) > limit {
+		return nil, ErrSizeLimit
+	}
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ 0x5a
+	}
+	return out, nil
+}
+
+func TestRegisteredTransformRunsInThePipeline(t *testing.T) {
+	const codec Codec = "test-xor"
+	magic := []byte("RFKX")
+	RegisterTransform(codec, magic, xorTransform)
+
+	data := bytes.Repeat([]byte("\x7fELF under two layers "), 2000)
+	packed, err := PackAP32(data)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	obfuscated, _ := xorTransform(packed, MaxUnpackedSize)
+	payload := append(slices.Clone(magic), obfuscated...)
+
+	if got := Detect(payload); got != codec {
+		t.Fatalf("Detect = %q, want %q", got, codec)
+	}
+	if !slices.Contains(Codecs(), codec) {
+		t.Fatalf("Codecs() = %v, want %s listed", Codecs(), codec)
+	}
+	got, err := Unpack(payload, 0)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Unpack = %d bytes, %v; want the input back", len(got), err)
+	}
+	got, err = UnpackReader(iotest.HalfReader(bytes.NewReader(payload)), 0, 0)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("UnpackReader = %d bytes, %v; want the input back", len(got), err)
+	}
+	if _, err := Unpack(payload, uint64(len(obfuscated)-1)); !errors.Is(err, ErrSizeLimit) {
+		t.Fatalf("Unpack under a small limit: err = %v, want ErrSizeLimit", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering the same magic twice did not panic")
+		}
+	}()
+	RegisterTransform("test-xor-again", magic, xorTransform)
+}
+
+func TestUnpackStopsAtLayerLimit(t *testing.T) {
+	magic := []byte("RFKLOOP")
+	RegisterTransform("test-loop", magic, func(data []byte, limit uint64) ([]byte, error) {
+		return append(slices.Clone(magic), data...), nil
+	})
+	if _, err := Unpack(append(slices.Clone(magic), "payload"...), 0); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Unpack of a self-wrapping payload: err = %v, want ErrCorrupt", err)
+	}
+}
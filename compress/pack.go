@@ -0,0 +1,258 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+const (
+	// packWindow bounds how far back the packer looks for matches.
+	packWindow = 1 << 16
+	// packMaxMatch bounds the length of a single match.
+	packMaxMatch = 1 << 16
+	// packChainDepth bounds how many earlier positions are tried per byte.
+	packChainDepth = 64
+	// packLazyLimit is the match length past which the packer takes a match
+	// without checking whether the next byte starts a longer one.
+	packLazyLimit = 32
+)
+
+// Pack packs data with codec, producing a payload Unpack and the loaders
+// recognize. AP32 and zstd can be written; zstd fails with ErrCodecNotBuilt
+// in builds that leave it out.
+func Pack(data []byte, codec Codec) ([]byte, error) {
+	switch codec {
+	case CodecAP32:
+		return PackAP32(data)
+	case CodecZstd:
+		return PackZstd(data)
+	}
+	return nil, fmt.Errorf("compress: cannot pack %q; use ap32 or zstd", codec)
+}
+
+// PackZstd packs data as a single zstd frame that records its unpacked size,
+// so UnpackReader can size its buffer before decoding.
+func PackZstd(data []byte) ([]byte, error) {
+	if !zstdBuilt {
+		return nil, fmt.Errorf("%w: %s", ErrCodecNotBuilt, CodecZstd)
+	}
+	return packZstd(data)
+}
+
+// PackAP32 packs data into the AP32 format DepackAP32 reads, with no
+// external tools. It parses lazily, looking one byte ahead for a longer
+// match, and favors speed over ratio; any aPLib-compatible packer producing
+// the same header works with the loaders. Inputs of 4 GiB or more cannot be
+// described by the header and are rejected with ErrSizeLimit.
+func PackAP32(data []byte) ([]byte, error) {
+	if uint64(len(data)) > math.MaxUint32 {
+		return nil, ErrSizeLimit
+	}
+	var packed []byte
+	if len(data) != 0 {
+		packed = pack(data)
+	}
+	out := make([]byte, AP32HeaderSize, AP32HeaderSize+len(packed))
+	copy(out, ap32Tag)
+	binary.LittleEndian.PutUint32(out[4:], AP32HeaderSize)
+	binary.LittleEndian.PutUint32(out[8:], uint32(len(packed)))
+	binary.LittleEndian.PutUint32(out[12:], crc32.ChecksumIEEE(packed))
+	binary.LittleEndian.PutUint32(out[16:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(out[20:], crc32.ChecksumIEEE(data))
+	return append(out, packed...), nil
+}
+
+// packer writes an aPLib bitstream. A tag byte is reserved in the output the
+// moment its first bit is written, which is where the depacker expects it.
+type packer struct {
+	out    []byte
+	tagPos int
+	bits   int
+}
+
+func (p *packer) bit(b uint32) {
+	if p.bits == 0 {
+		p.tagPos = len(p.out)
+		p.out = append(p.out, 0)
+		p.bits = 8
+	}
+	p.bits--
+	p.out[p.tagPos] |= byte(b&1) << p.bits
+}
+
+func (p *packer) gamma(v uint32) {
+	// v >= 2: every bit after the leading one, each followed by a flag
+	// saying whether another bit follows.
+	top := 31
+	for v>>top == 0 {
+		top--
+	}
+	for i := top - 1; i >= 0; i-- {
+		p.bit(v >> i)
+		if i > 0 {
+			p.bit(1)
+		} else {
+			p.bit(0)
+		}
+	}
+}
+
+// minLongMatch is the shortest match a gamma-coded offset can express.
+func minLongMatch(offset int) int {
+	switch {
+	case offset < 128:
+		return 4
+	case offset < 1280:
+		return 2
+	case offset < 32000:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func pack(data []byte) []byte {
+	p := &packer{out: make([]byte, 0, len(data)/2+16)}
+	p.out = append(p.out, data[0])
+
+	head := make(map[uint32]int)
+	prev := make([]int, len(data))
+	hashed := 0
+	// hashTo chains every position before end into head and prev.
+	hashTo := func(end int) {
+		for ; hashed < end; hashed++ {
+			if hashed+3 > len(data) {
+				continue
+			}
+			key := uint32(data[hashed]) | uint32(data[hashed+1])<<8 | uint32(data[hashed+2])<<16
+			if j, ok := head[key]; ok {
+				prev[hashed] = j
+			} else {
+				prev[hashed] = -1
+			}
+			head[key] = hashed
+		}
+	}
+	matchLen := func(i, j, limit int) int {
+		n := 0
+		for i+n < len(data) && n < limit && data[j+n] == data[i+n] {
+			n++
+		}
+		return n
+	}
+	hashTo(1)
+
+	longest := func(i int) (bestLen, bestOff int) {
+		if i+3 <= len(data) {
+			key := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16
+			j, ok := head[key]
+			for depth := 0; ok && j >= 0 && i-j <= packWindow && depth < packChainDepth; depth++ {
+				if n := matchLen(i, j, packMaxMatch); n > bestLen && n >= minLongMatch(i-j) {
+					bestLen, bestOff = n, i-j
+				}
+				j = prev[j]
+			}
+		}
+		for off := 1; off < 128 && off <= i; off++ {
+			if n := matchLen(i, i-off, 3); n >= 2 && n > bestLen {
+				bestLen, bestOff = n, off
+			}
+		}
+		return bestLen, bestOff
+	}
+
+	var (
+		r0  int
+		lwm bool
+	)
+	for i := 1; i < len(data); {
+		bestLen, bestOff := longest(i)
+		// Lazy parsing: a literal here pays for itself when the match that
+		// starts on the next byte is clearly longer.
+		if bestLen >= 2 && bestLen < packLazyLimit && i+1 < len(data) {
+			hashTo(i + 1)
+			if next, _ := longest(i + 1); next > bestLen+1 {
+				bestLen = 0
+			}
+		}
+		repLen := 0
+		if !lwm && r0 != 0 && r0 <= i {
+			repLen = matchLen(i, i-r0, packMaxMatch)
+		}
+
+		var length int
+		switch {
+		case repLen >= 2 && repLen >= bestLen:
+			p.bit(1)
+			p.bit(0)
+			p.gamma(2)
+			p.gamma(uint32(repLen))
+			length = repLen
+			lwm = true
+		case bestLen >= 2 && bestLen <= 3 && bestOff < 128:
+			p.bit(1)
+			p.bit(1)
+			p.bit(0)
+			p.out = append(p.out, byte(bestOff<<1|(bestLen-2)))
+			length, r0 = bestLen, bestOff
+			lwm = true
+		case bestLen >= 2:
+			p.bit(1)
+			p.bit(0)
+			hi := uint32(bestOff>>8) + 3
+			if lwm {
+				hi = uint32(bestOff>>8) + 2
+			}
+			p.gamma(hi)
+			p.out = append(p.out, byte(bestOff))
+			encoded := bestLen
+			if bestOff >= 32000 {
+				encoded--
+			}
+			if bestOff >= 1280 {
+				encoded--
+			}
+			if bestOff < 128 {
+				encoded -= 2
+			}
+			p.gamma(uint32(encoded))
+			length, r0 = bestLen, bestOff
+			lwm = true
+		default:
+			length = 1
+			lwm = false
+			short := 0
+			for off := 1; off < 16 && off <= i; off++ {
+				if data[i-off] == data[i] {
+					short = off
+					break
+				}
+			}
+			if data[i] == 0 || short != 0 {
+				if data[i] == 0 {
+					short = 0
+				}
+				p.bit(1)
+				p.bit(1)
+				p.bit(1)
+				for b := 3; b >= 0; b-- {
+					p.bit(uint32(short >> b))
+				}
+			} else {
+				p.bit(0)
+				p.out = append(p.out, data[i])
+			}
+		}
+		i += length
+		hashTo(i)
+	}
+
+	// End of stream: a 7-bit match with offset zero.
+	p.bit(1)
+	p.bit(1)
+	p.bit(0)
+	p.out = append(p.out, 0)
+	return p.out
+}
@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// zstdHeaderMax is the largest zstd frame header: magic, descriptor, window
+// descriptor, dictionary ID, and content size.
+const zstdHeaderMax = 18
+
+// UnpackReader reads a payload from r and unpacks it as Unpack does. zstd,
+// XZ, and LZMA streams are decoded as they are read, so only the unpacked
+// payload is ever held whole; AP32, bundles, and registered transforms need
+// their packed data in full and it is read first. Layers under the first are
+// unpacked in memory. sizeHint is how many bytes r is expected to yield, such as a file's
+// size, and lets the buffer for an unpacked or AP32 payload be allocated
+// once; zero is fine. maxSize
+// bounds the result as in Unpack.
+//
+// Buffers outgrown while reading are zeroed before they are dropped, so the
+// only copy of the payload left in memory is the one returned.
+func UnpackReader(r io.Reader, sizeHint int, maxSize uint64) ([]byte, error) {
+	out, _, err := UnpackReaderCodec(r, sizeHint, maxSize)
+	return out, err
+}
+
+// UnpackReaderCodec is like UnpackReader but also reports the codec of the
+// outermost layer, or CodecNone when the payload was not packed.
+func UnpackReaderCodec(r io.Reader, sizeHint int, maxSize uint64) ([]byte, Codec, error) {
+	limit := sizeLimit(maxSize)
+	br := bufio.NewReaderSize(r, 64<<10)
+	head, err := br.Peek(max(AP32HeaderSize, lzmaHeaderSize, zstdHeaderMax, longestMagic()))
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, CodecNone, err
+	}
+
+	codec := Detect(head)
+	if codec == CodecNone {
+		out, err := readAll(br, sizeHint, limit, codec)
+		return out, codec, err
+	}
+	out, err := streamLayer(br, head, codec, sizeHint, maxSize)
+	if err != nil {
+		return nil, codec, err
+	}
+	out, err = unpackRest(out, maxSize)
+	return out, codec, err
+}
+
+// streamLayer unpacks the outermost layer of br, whose leading bytes are
+// head, as codec.
+func streamLayer(br *bufio.Reader, head []byte, codec Codec, sizeHint int, maxSize uint64) ([]byte, error) {
+	limit := sizeLimit(maxSize)
+	switch codec {
+	case CodecAP32:
+		packed, err := readAll(br, sizeHint, MaxUnpackedSize, codec)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(packed)
+		return DepackAP32(packed, maxSize)
+	case CodecBundle:
+		packed, err := readAll(br, sizeHint, MaxUnpackedSize, codec)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(packed)
+		return unbundle(packed, limit)
+	case CodecZstd:
+		if !zstdBuilt {
+			return nil, fmt.Errorf("%w: %s", ErrCodecNotBuilt, codec)
+		}
+		return streamZstd(br, head, limit)
+	case CodecXZ, CodecLZMA:
+		if !xzBuilt {
+			return nil, fmt.Errorf("%w: %s", ErrCodecNotBuilt, codec)
+		}
+		hint := 0
+		if codec == CodecLZMA {
+			size := binary.LittleEndian.Uint64(head[5:])
+			if size != math.MaxUint64 && size > limit {
+				return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrSizeLimit, size, limit)
+			}
+			if size != math.MaxUint64 {
+				hint = int(size)
+			}
+		}
+		reader, err := newXZReader(br, codec)
+		if err != nil {
+			return nil, err
+		}
+		return readAll(reader, hint, limit, codec)
+	}
+	packed, err := readAll(br, sizeHint, MaxUnpackedSize, codec)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(packed)
+	t, ok := lookupTransform(packed)
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown codec %q", codec)
+	}
+	return t.apply(packed, limit)
+}
+
+// readAll reads r to its end into a buffer of sizeHint bytes, growing it as
+// needed and zeroing each outgrown buffer, and fails once it passes limit.
+func readAll(r io.Reader, sizeHint int, limit uint64, codec Codec) ([]byte, error) {
+	// One byte past the hint lets an exact hint reach EOF without growing.
+	buf := make([]byte, 0, min(uint64(max(sizeHint, 512-1)), limit)+1)
+	for {
+		if len(buf) == cap(buf) {
+			if uint64(len(buf)) > limit {
+				clear(buf)
+				return nil, fmt.Errorf("%w: %s data, limit %d", ErrSizeLimit, codecName(codec), limit)
+			}
+			grown := make([]byte, len(buf), min(uint64(2*cap(buf)), limit+1))
+			copy(grown, buf)
+			clear(buf)
+			buf = grown
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if errors.Is(err, io.EOF) {
+			if uint64(len(buf)) > limit {
+				clear(buf)
+				return nil, fmt.Errorf("%w: %s data, limit %d", ErrSizeLimit, codecName(codec), limit)
+			}
+			return buf, nil
+		}
+		if err != nil {
+			clear(buf)
+			if codec == CodecNone {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s: %w", ErrCorrupt, codec, err)
+		}
+	}
+}
+
+func codecName(codec Codec) string {
+	if codec == CodecNone {
+		return "unpacked"
+	}
+	return string(codec)
+}
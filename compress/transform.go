@@ -0,0 +1,105 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Transform unwraps a payload in a registered format. data is the payload
+// with its magic removed. The result must not exceed limit bytes; a
+// transform that can tell up front should fail with ErrSizeLimit before
+// allocating. A transform may return a payload that is itself packed, which
+// is unpacked in turn.
+type Transform func(data []byte, limit uint64) ([]byte, error)
+
+// maxLayers bounds how many packing layers Unpack and UnpackReader peel, so
+// a payload that unpacks to itself cannot loop forever.
+const maxLayers = 8
+
+type transform struct {
+	codec Codec
+	magic []byte
+	fn    Transform
+}
+
+var (
+	transformsMu sync.RWMutex
+	transforms   []transform
+)
+
+// RegisterTransform makes Detect recognize payloads that begin with magic
+// as codec, and makes Unpack, UnpackReader, and so every loader, unwrap them
+// with fn. Proprietary packers and obfuscators plug in this way without
+// changes to the loaders. Built-in formats are recognized first. It panics
+// if codec or magic is empty, if fn is nil, or if codec or magic is already
+// registered. Call it from an init function or before loading payloads.
+func RegisterTransform(codec Codec, magic []byte, fn Transform) {
+	if codec == CodecNone || len(magic) == 0 || fn == nil {
+		panic("compress: RegisterTransform needs a codec name, a magic, and a transform")
+	}
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+
+	for _, t := range transforms {
+		if t.codec == codec || bytes.Equal(t.magic, magic) {
+			panic(fmt.Sprintf("compress: RegisterTransform called twice for %s", codec))
+		}
+	}
+	if slices.Contains([]Codec{CodecAP32, CodecZstd, CodecXZ, CodecLZMA, CodecBundle}, codec) {
+		panic(fmt.Sprintf("compress: %s is a built-in codec", codec))
+	}
+	transforms = append(transforms, transform{codec: codec, magic: bytes.Clone(magic), fn: fn})
+}
+
+// registeredCodecs lists the codecs added with RegisterTransform.
+func registeredCodecs() []Codec {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	codecs := make([]Codec, 0, len(transforms))
+	for _, t := range transforms {
+		codecs = append(codecs, t.codec)
+	}
+	return codecs
+}
+
+// lookupTransform returns the registered transform whose magic data starts
+// with.
+func lookupTransform(data []byte) (transform, bool) {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	for _, t := range transforms {
+		if bytes.HasPrefix(data, t.magic) {
+			return t, true
+		}
+	}
+	return transform{}, false
+}
+
+// longestMagic is how many leading bytes Detect may need to see.
+func longestMagic() int {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	n := 0
+	for _, t := range transforms {
+		n = max(n, len(t.magic))
+	}
+	return n
+}
+
+// apply runs a registered transform and holds it to limit.
+func (t transform) apply(data []byte, limit uint64) ([]byte, error) {
+	out, err := t.fn(data[len(t.magic):], limit)
+	if err != nil {
+		return nil, fmt.Errorf("compress: %s: %w", t.codec, err)
+	}
+	if uint64(len(out)) > limit {
+		clear(out)
+		return nil, fmt.Errorf("%w: %s data, limit %d", ErrSizeLimit, t.codec, limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,39 @@
+//go:build reflektor_xz || reflektor_full
+
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const xzBuilt = true
+
+func unpackXZ(data []byte, codec Codec, limit uint64) ([]byte, error) {
+	reader, err := newXZReader(bytes.NewReader(data), codec)
+	if err != nil {
+		return nil, err
+	}
+	return readAll(reader, 0, limit, codec)
+}
+
+// newXZReader decodes an XZ or LZMA stream as it is read from r.
+func newXZReader(r io.Reader, codec Codec) (io.Reader, error) {
+	var (
+		reader io.Reader
+		err    error
+	)
+	if codec == CodecLZMA {
+		reader, err = lzma.NewReader(r)
+	} else {
+		reader, err = xz.NewReader(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCorrupt, codec, err)
+	}
+	return reader, nil
+}
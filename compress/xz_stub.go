@@ -0,0 +1,17 @@
+//go:build !reflektor_xz && !reflektor_full
+
+package compress
+
+import "io"
+
+const xzBuilt = false
+
+func unpackXZ(data []byte, codec Codec, limit uint64) ([]byte, error) {
+	_, _, _ = data, codec, limit
+	return nil, ErrCodecNotBuilt
+}
+
+func newXZReader(r io.Reader, codec Codec) (io.Reader, error) {
+	_, _ = r, codec
+	return nil, ErrCodecNotBuilt
+}
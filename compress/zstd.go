@@ -0,0 +1,60 @@
+//go:build reflektor_zstd || reflektor_full
+
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const zstdBuilt = true
+
+func unpackZstd(data []byte, limit uint64) ([]byte, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(limit))
+	if err != nil {
+		return nil, fmt.Errorf("compress: zstd: %w", err)
+	}
+	defer decoder.Close()
+	out, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, fmt.Errorf("%w: zstd frame, limit %d", ErrSizeLimit, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: zstd: %w", ErrCorrupt, err)
+	}
+	return out, nil
+}
+
+// packZstd writes data as one zstd frame at the best compression level.
+// The frame header records the unpacked size.
+func packZstd(data []byte) ([]byte, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("compress: zstd: %w", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(data, nil), nil
+}
+
+// streamZstd decodes zstd frames as they are read from r. head is the start
+// of the stream, used for the unpacked size a frame header may record.
+func streamZstd(r io.Reader, head []byte, limit uint64) ([]byte, error) {
+	hint := 0
+	var header zstd.Header
+	if header.Decode(head) == nil && header.HasFCS && header.FrameContentSize <= limit {
+		hint = int(header.FrameContentSize)
+	}
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(limit))
+	if err != nil {
+		return nil, fmt.Errorf("compress: zstd: %w", err)
+	}
+	defer decoder.Close()
+	out, err := readAll(decoder, hint, limit, CodecZstd)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, fmt.Errorf("%w: zstd frame, limit %d", ErrSizeLimit, limit)
+	}
+	return out, err
+}
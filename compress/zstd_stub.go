@@ -0,0 +1,22 @@
+//go:build !reflektor_zstd && !reflektor_full
+
+package compress
+
+import "io"
+
+const zstdBuilt = false
+
+func unpackZstd(data []byte, limit uint64) ([]byte, error) {
+	_, _ = data, limit
+	return nil, ErrCodecNotBuilt
+}
+
+func packZstd(data []byte) ([]byte, error) {
+	_ = data
+	return nil, ErrCodecNotBuilt
+}
+
+func streamZstd(r io.Reader, head []byte, limit uint64) ([]byte, error) {
+	_, _, _ = r, head, limit
+	return nil, ErrCodecNotBuilt
+}
@@ -0,0 +1,498 @@
+// Package contextual is a context-first API for loading payloads from
+// memory. Every operation takes a context and loads and calls return
+// structured reports. All platform-specific behavior is configured through
+// Options.
+//
+// It is built on github.com/sliverarmory/reflektor, whose functions keep
+// working unchanged, and shares its Options, errors, and formats.
+// Library.Underlying gives access to the reflektor.Library a Library wraps.
+//
+// Native code cannot be interrupted. A context bounds how long the caller
+// waits. A load the context gives up on keeps going, and what it loads is
+// released once it finishes. A call keeps going, stays registered as in
+// flight, and Close waits for it.
+package contextual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// Options, formats, and errors are shared with the reflektor package so both
+// APIs configure and report the same behavior.
+type (
+	Options            = reflektor.Options
+	ThreadOptions      = reflektor.ThreadOptions
+	SymbolFilter       = reflektor.SymbolFilter
+	Symbol             = reflektor.Symbol
+	Export             = reflektor.Export
+	RelocationCheck    = reflektor.RelocationCheck
+	RelocationMismatch = reflektor.RelocationMismatch
+	ResourceUsage      = reflektor.ResourceUsage
+	ImportResolver     = reflektor.ImportResolver
+	DllMainReasons     = reflektor.DllMainReasons
+	CipherScheme       = reflektor.CipherScheme
+	Format             = reflektor.Format
+	Backend            = reflektor.Backend
+	Info               = reflektor.Info
+	FeatureSet         = reflektor.FeatureSet
+	ShellcodeOptions   = reflektor.ShellcodeOptions
+	ExportHandle       = reflektor.ExportHandle
+	ExportStatusError  = reflektor.ExportStatusError
+)
+
+const (
+	SymbolsLocal   = reflektor.SymbolsLocal
+	SymbolsHidden  = reflektor.SymbolsHidden
+	SymbolsObjects = reflektor.SymbolsObjects
+
+	DllMainAttach = reflektor.DllMainAttach
+	DllMainDetach = reflektor.DllMainDetach
+	DllMainNone   = reflektor.DllMainNone
+
+	CipherAES256GCM        = reflektor.CipherAES256GCM
+	CipherChaCha20Poly1305 = reflektor.CipherChaCha20Poly1305
+)
+
+var (
+	ErrLibraryClosed     = reflektor.ErrLibraryClosed
+	ErrUnsupportedFormat = reflektor.ErrUnsupportedFormat
+	ErrEncryptedImage    = reflektor.ErrEncryptedImage
+	ErrImageTooLarge     = reflektor.ErrImageTooLarge
+	ErrMappingBudget     = reflektor.ErrMappingBudget
+	ErrTLSUnsupported    = reflektor.ErrTLSUnsupported
+	ErrForeignPlatform   = reflektor.ErrForeignPlatform
+	ErrSymbolNotFound    = reflektor.ErrSymbolNotFound
+	ErrDecrypt           = reflektor.ErrDecrypt
+	ErrNotBuilt          = reflektor.ErrNotBuilt
+	ErrExportKilled      = reflektor.ErrExportKilled
+	ErrKillUnsupported   = reflektor.ErrKillUnsupported
+	// ErrScriptOptions is returned when non-zero Options are given for a
+	// Lua script, which has no load-time settings.
+	ErrScriptOptions = errors.New("reflektor: options do not apply to scripts")
+)
+
+// LoadReport describes a completed load.
+type LoadReport struct {
+	Info
+	// Dependencies lists the libraries a native image names, in the order
+	// the image lists them.
+	Dependencies []string
+	// Duration is how long mapping, linking, and initialization took.
+	Duration time.Duration
+}
+
+// CallReport describes an export call.
+type CallReport struct {
+	// Value is the raw integer return register. Exports returning narrower
+	// types leave the upper bits undefined, so truncate before use.
+	Value uintptr
+	// Errno is errno on unix or GetLastError on windows, captured on the
+	// calling thread right after the export returned.
+	Errno syscall.Errno
+	// Running is set when ctx ended the wait before the export returned.
+	// Value and Errno are then zero.
+	Running bool
+	// Duration is how long the caller waited.
+	Duration time.Duration
+}
+
+// Library is a loaded payload.
+type Library struct {
+	library *reflektor.Library
+	report  LoadReport
+}
+
+// await runs load on its own goroutine and waits for it or for ctx. Loads
+// run constructors, which cannot be interrupted, so a load ctx gives up on
+// keeps going and discard releases what it loaded once it finishes.
+func await[T any](ctx context.Context, load func() (T, error), discard func(T)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	type outcome struct {
+		value T
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		value, err := load()
+		done <- outcome{value, err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.value, out.err
+	case <-ctx.Done():
+		go func() {
+			if out := <-done; out.err == nil {
+				discard(out.value)
+			}
+		}()
+		return zero, ctx.Err()
+	}
+}
+
+// loadLibrary runs load under ctx, as await does, and returns the library
+// with its report.
+func loadLibrary(ctx context.Context, load func() (*Library, error)) (*Library, LoadReport, error) {
+	library, err := await(ctx, load, func(library *Library) { _ = library.library.Close() })
+	if err != nil {
+		return nil, LoadReport{}, err
+	}
+	return library, library.report, nil
+}
+
+// Load loads a native image or Lua script from memory with the backend for
+// its detected format, unpacking packed payloads first. Scripts only accept
+// the zero Options. When ctx ends before the load finishes, Load returns
+// ctx's error; the load keeps going, since constructors cannot be
+// interrupted, and the payload is closed once it is loaded.
+func Load(ctx context.Context, data []byte, opts Options) (*Library, LoadReport, error) {
+	start := time.Now()
+	return loadLibrary(ctx, func() (*Library, error) {
+		return load(data, opts, start)
+	})
+}
+
+func load(data []byte, opts Options, start time.Time) (*Library, error) {
+	packed := compress.Detect(data) != compress.CodecNone
+	image, err := compress.Unpack(data, opts.MaxImageSize)
+	if err != nil {
+		return nil, unpackError(err)
+	}
+	var (
+		library *reflektor.Library
+		deps    []string
+	)
+	switch format := reflektor.DetectFormat(image); format {
+	case reflektor.FormatELF, reflektor.FormatPE, reflektor.FormatMachO:
+		deps, err = memmod.ImportedLibraries(image)
+		if err != nil {
+			return nil, fmt.Errorf("reflektor: read dependencies: %w", err)
+		}
+		if packed {
+			// reflektor only sees the unpacked copy, so it cannot tell
+			// the copy is its own to zero.
+			defer clear(image)
+		}
+		library, err = reflektor.LoadLibraryWithOptions(image, opts)
+		if err == nil && opts.ZeroInput {
+			clear(data)
+		}
+	case reflektor.FormatLua:
+		if !isZeroOptions(opts) {
+			return nil, ErrScriptOptions
+		}
+		library, err = reflektor.LoadScript(image)
+	case reflektor.FormatUnknown:
+		return nil, fmt.Errorf("%w: unrecognized payload", ErrUnsupportedFormat)
+	default:
+		return nil, fmt.Errorf("%w: no %s backend is available", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newLibrary(library, deps, packed, start), nil
+}
+
+// unpackError wraps a compress.Unpack failure the way the reflektor package
+// does, so a size limit matches ErrImageTooLarge and a codec left out of the
+// build matches ErrNotBuilt.
+func unpackError(err error) error {
+	switch {
+	case errors.Is(err, compress.ErrSizeLimit):
+		return fmt.Errorf("reflektor: unpack payload: %w: %w", ErrImageTooLarge, err)
+	case errors.Is(err, compress.ErrCodecNotBuilt):
+		return fmt.Errorf("reflektor: unpack payload: %w: %w", ErrNotBuilt, err)
+	}
+	return fmt.Errorf("reflektor: unpack payload: %w", err)
+}
+
+// LoadFile reads path and loads it like Load. Files with a .lua extension
+// are always treated as scripts.
+func LoadFile(ctx context.Context, path string, opts Options) (*Library, LoadReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, LoadReport{}, fmt.Errorf("reflektor: read library file: %w", err)
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".lua") {
+		return Load(ctx, data, opts)
+	}
+	if !isZeroOptions(opts) {
+		return nil, LoadReport{}, ErrScriptOptions
+	}
+	start := time.Now()
+	return loadLibrary(ctx, func() (*Library, error) {
+		library, err := reflektor.LoadScript(data)
+		if err != nil {
+			return nil, err
+		}
+		return newLibrary(library, nil, false, start), nil
+	})
+}
+
+// LoadReader loads a native image read from r, unpacking packed images as
+// they are read, as reflektor.LoadLibraryFromReader does. When ctx ends first
+// the load keeps reading from r.
+func LoadReader(ctx context.Context, r io.Reader, opts Options) (*Library, LoadReport, error) {
+	start := time.Now()
+	return loadLibrary(ctx, func() (*Library, error) {
+		library, err := reflektor.LoadLibraryFromReaderWithOptions(r, opts)
+		if err != nil {
+			return nil, err
+		}
+		return newLibrary(library, library.Info().Imports, false, start), nil
+	})
+}
+
+// LoadEncrypted decrypts a sealed native image and loads it like Load. See
+// reflektor.LoadEncryptedLibrary for the sealed format.
+func LoadEncrypted(ctx context.Context, data, key []byte, scheme CipherScheme, opts Options) (*Library, LoadReport, error) {
+	start := time.Now()
+	return loadLibrary(ctx, func() (*Library, error) {
+		library, err := reflektor.LoadEncryptedLibraryWithOptions(data, key, scheme, opts)
+		if err != nil {
+			return nil, err
+		}
+		return newLibrary(library, library.Info().Imports, false, start), nil
+	})
+}
+
+// LoadSet loads a group of native images whose dependencies on each other
+// are satisfied from memory. See reflektor.LoadLibrarySet.
+func LoadSet(ctx context.Context, images map[string][]byte, opts Options) (map[string]*Library, error) {
+	start := time.Now()
+	load := func() (map[string]*Library, error) {
+		libraries, err := reflektor.LoadLibrarySetWithOptions(images, opts)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]*Library, len(libraries))
+		for name, library := range libraries {
+			out[name] = newLibrary(library, library.Info().Imports, false, start)
+		}
+		return out, nil
+	}
+	return await(ctx, load, func(libraries map[string]*Library) {
+		for _, library := range libraries {
+			_ = library.library.Close()
+		}
+	})
+}
+
+// newLibrary wraps library. packed is set when the payload was unpacked here
+// rather than by the reflektor package, which then could not tell.
+func newLibrary(library *reflektor.Library, deps []string, packed bool, start time.Time) *Library {
+	report := LoadReport{Info: library.Info(), Dependencies: deps, Duration: time.Since(start)}
+	report.Packed = report.Packed || packed
+	return &Library{library: library, report: report}
+}
+
+// Code is position-independent code loaded with LoadShellcode.
+type Code struct {
+	code *reflektor.Code
+}
+
+// LoadShellcode maps position-independent code from memory, as
+// reflektor.LoadShellcodeWithOptions does. ctx bounds the wait as in Load.
+func LoadShellcode(ctx context.Context, data []byte, opts ShellcodeOptions) (*Code, error) {
+	load := func() (*Code, error) {
+		code, err := reflektor.LoadShellcodeWithOptions(data, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &Code{code: code}, nil
+	}
+	return await(ctx, load, func(code *Code) { _ = code.code.Close() })
+}
+
+// Underlying returns the reflektor.Code this one wraps.
+func (code *Code) Underlying() *reflektor.Code {
+	return code.code
+}
+
+// Base returns the address the code was mapped at.
+func (code *Code) Base() uintptr {
+	return code.code.Base()
+}
+
+// Entry returns the address Call enters the code at.
+func (code *Code) Entry() uintptr {
+	return code.code.Entry()
+}
+
+// Call runs the code from its entry point with arg on a goroutine of its
+// own. When ctx ends the wait first, the report has Running set and the
+// error wraps ctx's error; the code keeps running, and Close waits for it.
+func (code *Code) Call(ctx context.Context, arg uintptr) (CallReport, error) {
+	if err := ctx.Err(); err != nil {
+		return CallReport{}, err
+	}
+	start := time.Now()
+	type outcome struct {
+		result reflektor.CallResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := code.code.Call(arg)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		return CallReport{Value: out.result.Value, Errno: out.result.Errno, Duration: time.Since(start)}, out.err
+	case <-ctx.Done():
+		return CallReport{Running: true, Duration: time.Since(start)}, fmt.Errorf("reflektor: call shellcode: %w", ctx.Err())
+	}
+}
+
+// Close unmaps the code once calls in progress have returned, and rejects
+// calls made after it. When ctx ends the wait first, Close returns ctx's
+// error and the code is still unmapped once those calls return.
+func (code *Code) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		_ = code.code.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Features reports the capabilities compiled into this build.
+func Features() FeatureSet {
+	return reflektor.Features()
+}
+
+func isZeroOptions(opts Options) bool {
+	return reflect.ValueOf(opts).IsZero()
+}
+
+// Underlying returns the reflektor.Library this one wraps.
+func (library *Library) Underlying() *reflektor.Library {
+	return library.library
+}
+
+// Info reports the payload's format and the backend running it.
+func (library *Library) Info() Info {
+	return library.report.Info
+}
+
+// Report returns the report of the load that produced the library.
+func (library *Library) Report() LoadReport {
+	return library.report
+}
+
+// Call calls an export with up to memmod.MaxCallArgs integer or pointer
+// arguments. When ctx ends the wait first, the report has Running set and
+// the error wraps ctx's error.
+func (library *Library) Call(ctx context.Context, name string, args ...uintptr) (CallReport, error) {
+	start := time.Now()
+	result, running, err := library.library.CallContext(ctx, name, args...)
+	return CallReport{Value: result.Value, Errno: result.Errno, Running: running, Duration: time.Since(start)}, err
+}
+
+// CallExportStatus calls a zero-argument export that returns a C int status,
+// zero meaning success, as reflektor.Library.CallExportStatus does: a
+// non-zero status is also reported as an *ExportStatusError. When ctx ends
+// the wait first, the report has Running set and the status is zero.
+func (library *Library) CallExportStatus(ctx context.Context, name string) (int, CallReport, error) {
+	report, err := library.Call(ctx, name)
+	if err != nil {
+		return 0, report, err
+	}
+	status := int(int32(report.Value))
+	if status != 0 {
+		return status, report, &ExportStatusError{Name: name, Status: status, Errno: report.Errno}
+	}
+	return 0, report, nil
+}
+
+// StartExport calls a zero-argument export in the background, as
+// reflektor.Library.StartExport does, and returns a handle whose Wait takes a
+// context.
+func (library *Library) StartExport(ctx context.Context, name string) (*ExportHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return library.library.StartExport(name)
+}
+
+// Exports lists the payload's callable exports, sorted by name.
+func (library *Library) Exports(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return library.library.Exports()
+}
+
+// Symbols describes the symbols of a native linux image, including those
+// Options.Symbols adds.
+func (library *Library) Symbols(ctx context.Context) ([]Symbol, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return library.library.Symbols()
+}
+
+// FindExport returns the exports whose name or demangled name matches the
+// regular expression pattern, as reflektor.Library.FindExport does.
+func (library *Library) FindExport(ctx context.Context, pattern string) ([]Export, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return library.library.FindExport(pattern)
+}
+
+// ProcAddress returns the address of one of the payload's own exports, or of
+// ordinal N when name is "#N", as reflektor.Library.ProcAddress does.
+func (library *Library) ProcAddress(ctx context.Context, name string) (uintptr, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return library.library.ProcAddress(name)
+}
+
+// FindSymbol resolves name from the payload's point of view, as
+// reflektor.Library.FindSymbol does.
+func (library *Library) FindSymbol(ctx context.Context, name string) (uintptr, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return library.library.FindSymbol(name)
+}
+
+// StartEntry starts a static-PIE executable's entry point on a new native
+// thread (linux only). See reflektor.Library.StartEntry.
+func (library *Library) StartEntry(ctx context.Context, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return library.library.StartEntry(args...)
+}
+
+// Close waits for in-flight calls and releases the payload. When ctx ends
+// the wait first, the library stays loaded, rejects new calls, and Close
+// may be retried.
+func (library *Library) Close(ctx context.Context) error {
+	return library.library.CloseContext(ctx)
+}
@@ -0,0 +1,77 @@
+//go:build reflektor_lua || reflektor_full
+
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/contextual"
+)
+
+func TestLoadScriptWithContext(t *testing.T) {
+	t.Setenv("REFLEKTOR_MARKER", filepath.Join(t.TempDir(), "marker.txt"))
+	ctx := context.Background()
+
+	lib, report, err := contextual.LoadFile(ctx, filepath.Join("..", "testdata", "lua", "basic.lua"), contextual.Options{})
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if report.Format != "lua" || report.Backend != "luamod" || report.Dependencies != nil {
+		t.Fatalf("unexpected load report: %+v", report)
+	}
+	call, err := lib.Call(ctx, "Add", 40, 2)
+	if err != nil || call.Value != 42 || call.Running {
+		t.Fatalf("Call(Add, 40, 2) = %+v, %v", call, err)
+	}
+	var statusErr *contextual.ExportStatusError
+	if status, _, err := lib.CallExportStatus(ctx, "Answer"); status != 42 || !errors.As(err, &statusErr) {
+		t.Fatalf("CallExportStatus(Answer) = %d, %v; want 42 and an ExportStatusError", status, err)
+	}
+	handle, err := lib.StartExport(ctx, "Answer")
+	if err != nil {
+		t.Fatalf("StartExport: %v", err)
+	}
+	if result, err := handle.Wait(ctx); err != nil || result.Value != 42 {
+		t.Fatalf("Wait = %+v, %v", result, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := lib.Call(cancelled, "Add", 1, 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Call with a cancelled context: err = %v, want context.Canceled", err)
+	}
+	if err := lib.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := lib.Call(ctx, "Add", 1, 2); !errors.Is(err, contextual.ErrLibraryClosed) {
+		t.Fatalf("Call after Close: err = %v, want ErrLibraryClosed", err)
+	}
+
+	source, err := os.ReadFile(filepath.Join("..", "testdata", "lua", "basic.lua"))
+	if err != nil {
+		t.Fatalf("read script: %v", err)
+	}
+	if _, _, err := contextual.Load(ctx, source, contextual.Options{LockMemory: true}); !errors.Is(err, contextual.ErrScriptOptions) {
+		t.Fatalf("Load script with options: err = %v, want ErrScriptOptions", err)
+	}
+	packed, err := compress.PackAP32(source)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	lib, report, err = contextual.Load(ctx, packed, contextual.Options{})
+	if err != nil {
+		t.Fatalf("Load(packed): %v", err)
+	}
+	if !report.Packed || !lib.Info().Packed {
+		t.Fatalf("Load(packed) report = %+v, want Packed", report)
+	}
+	_ = lib.Close(ctx)
+	if _, _, err := contextual.Load(cancelled, source, contextual.Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Load with a cancelled context: err = %v, want context.Canceled", err)
+	}
+}
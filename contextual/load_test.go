@@ -0,0 +1,33 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/contextual"
+)
+
+func TestLoadUnpackErrors(t *testing.T) {
+	ctx := context.Background()
+
+	packed, err := compress.PackAP32(bytes.Repeat([]byte("\x7fELF"), 1024))
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	if _, _, err := contextual.Load(ctx, packed, contextual.Options{MaxImageSize: 16}); !errors.Is(err, contextual.ErrImageTooLarge) {
+		t.Fatalf("Load past MaxImageSize: err = %v, want ErrImageTooLarge", err)
+	}
+
+	if slices.Contains(reflektor.Features().Compression, string(compress.CodecZstd)) {
+		return
+	}
+	zstd := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00, 0x00, 0x00}
+	if _, _, err := contextual.Load(ctx, zstd, contextual.Options{}); !errors.Is(err, contextual.ErrNotBuilt) {
+		t.Fatalf("Load zstd without the zstd codec: err = %v, want ErrNotBuilt", err)
+	}
+}
@@ -0,0 +1,46 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/sliverarmory/reflektor/contextual"
+)
+
+func TestLoadShellcodeWithContext(t *testing.T) {
+	var code []byte
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64", "darwin/amd64":
+		code = []byte{0x48, 0x8d, 0x47, 0x01, 0xc3} // lea rax, [rdi+1]; ret
+	case "windows/amd64":
+		code = []byte{0x48, 0x8d, 0x41, 0x01, 0xc3} // lea rax, [rcx+1]; ret
+	case "linux/arm64", "darwin/arm64", "windows/arm64":
+		code = []byte{0x00, 0x04, 0x00, 0x91, 0xc0, 0x03, 0x5f, 0xd6} // add x0, x0, #1; ret
+	default:
+		t.Skipf("no test shellcode for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	ctx := context.Background()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := contextual.LoadShellcode(cancelled, code, contextual.ShellcodeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LoadShellcode with a cancelled context: err = %v, want context.Canceled", err)
+	}
+
+	shellcode, err := contextual.LoadShellcode(ctx, code, contextual.ShellcodeOptions{})
+	if err != nil {
+		t.Fatalf("LoadShellcode: %v", err)
+	}
+	call, err := shellcode.Call(ctx, 41)
+	if err != nil || call.Value != 42 || call.Running {
+		t.Fatalf("Call(41) = %+v, %v", call, err)
+	}
+	if err := shellcode.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := shellcode.Call(ctx, 41); err == nil {
+		t.Fatal("Call succeeded after Close")
+	}
+}
@@ -0,0 +1,42 @@
+// Package demangle turns mangled C++ and Swift symbol names into the
+// human-readable names their source uses, so exports like _ZN5agent5startEv
+// can be found as agent::start().
+//
+// C++ names use the Itanium ABI that GCC and Clang share on linux and darwin
+// and are rendered the way c++filt renders them. The common subset is
+// supported: nested and local names, templates, constructors, destructors,
+// operators, and the usual parameter types. Names using expressions or
+// other rarely exported constructs are left alone.
+//
+// Swift names are reduced to the dotted path of the entity and its contexts
+// (module.Type.member); the types in a signature are not rendered.
+package demangle
+
+import "strings"
+
+// Demangle returns the demangled form of name, or name itself when it is not
+// mangled or uses constructs this package does not decode. A leading
+// underscore added by the darwin toolchain is accepted.
+func Demangle(name string) string {
+	if out, ok := Try(name); ok {
+		return out
+	}
+	return name
+}
+
+// Try is like Demangle but reports whether name was demangled.
+func Try(name string) (string, bool) {
+	switch {
+	case strings.HasPrefix(name, "_Z"):
+		return demangleItanium(name[2:])
+	case strings.HasPrefix(name, "__Z"):
+		return demangleItanium(name[3:])
+	}
+	trimmed := strings.TrimPrefix(name, "_")
+	for _, prefix := range swiftPrefixes {
+		if rest, ok := strings.CutPrefix(trimmed, prefix); ok {
+			return demangleSwift(rest)
+		}
+	}
+	return name, false
+}
@@ -0,0 +1,61 @@
+package demangle
+
+import "testing"
+
+func TestDemangle(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		// Expected output matches c++filt.
+		{"_ZN5agent5startEv", "agent::start()"},
+		{"__ZN5agent5startEv", "agent::start()"},
+		{"_Z3fooi", "foo(int)"},
+		{"_ZN5agent7counterE", "agent::counter"},
+		{"_ZNK1a3getEv", "a::get() const"},
+		{"_ZN1AC2Ev", "A::A()"},
+		{"_ZN1AD1Ev", "A::~A()"},
+		{"_ZN1AplERKS_", "A::operator+(A const&)"},
+		{"_ZN1AcviEv", "A::operator int()"},
+		{"_Z1fPFviE", "f(void (*)(int))"},
+		{"_Z1fM1AFviE", "f(void (A::*)(int))"},
+		{"_Z1fRA10_i", "f(int (&) [10])"},
+		{"_Z1fIiEvT_", "void f<int>(int)"},
+		{"_Z1fILb1EEvv", "void f<true>()"},
+		{"_Z1fIJidEEvDpT_", "void f<int, double>(int, double)"},
+		{"_ZN1A1BIiE1fIcEEvT_", "void A::B<int>::f<char>(char)"},
+		{"_ZN12_GLOBAL__N_13fooEv", "(anonymous namespace)::foo()"},
+		{"_ZZ4mainENKUlvE_clEv", "main::{lambda()#1}::operator()() const"},
+		{"_ZN4main3fooB5cxx11Ev", "main::foo[abi:cxx11]()"},
+		{"_ZNSt6vectorIiSaIiEE9push_backERKi", "std::vector<int, std::allocator<int> >::push_back(int const&)"},
+		{"_ZNSt3mapIiiSt4lessIiESaISt4pairIKiiEEEixERS3_", "std::map<int, int, std::less<int>, std::allocator<std::pair<int const, int> > >::operator[](int const&)"},
+		{"_ZNKSt8functionIFviEEclEi", "std::function<void (int)>::operator()(int) const"},
+		{"_Z3barv.cold", "bar() [clone .cold]"},
+
+		{"$s5agent5startyyF", "agent.start"},
+		{"_$s4main3FooV3baryyF", "main.Foo.bar"},
+		{"$s4main3FooV5countSivg", "main.Foo.count"},
+		{"$s4main3foo1xS2i_tF", "main.foo"},
+		{"$sSi4mainE6doubleSiyF", "Swift.Int.double"},
+		{"$s12SwiftService0B6ClientC5startyyF", "SwiftService.ServiceClient.start"},
+		{"$s4main3Foo33_0123456789ABCDEF0123456789ABCDEFLLC3runyyF", "main.Foo.run"},
+
+		{"StartW", "StartW"},
+		{"_Z", "_Z"},
+		{"_ZN5agent5start", "_ZN5agent5start"},
+		{"_ZS_", "_ZS_"},
+	}
+	for _, test := range tests {
+		if got := Demangle(test.name); got != test.want {
+			t.Errorf("Demangle(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTryReportsUnmangledNames(t *testing.T) {
+	for _, name := range []string{"", "main", "_ZN", "$s", "_Z1fX1xE"} {
+		if out, ok := Try(name); ok {
+			t.Errorf("Try(%q) = %q, true; want false", name, out)
+		}
+	}
+}
@@ -0,0 +1,732 @@
+package demangle
+
+import (
+	"strconv"
+	"strings"
+)
+
+// declarator marks where a pointer, reference, or qualifier goes inside a
+// function or array type, as in void (*)(int). It is removed from the output.
+const declarator = "\x01"
+
+// errUnsupported aborts a demangle; it is recovered by demangleItanium.
+type errUnsupported struct{}
+
+var builtinTypes = map[byte]string{
+	'v': "void",
+	'w': "wchar_t",
+	'b': "bool",
+	'c': "char",
+	'a': "signed char",
+	'h': "unsigned char",
+	's': "short",
+	't': "unsigned short",
+	'i': "int",
+	'j': "unsigned int",
+	'l': "long",
+	'm': "unsigned long",
+	'x': "long long",
+	'y': "unsigned long long",
+	'n': "__int128",
+	'o': "unsigned __int128",
+	'f': "float",
+	'd': "double",
+	'e': "long double",
+	'g': "__float128",
+	'z': "...",
+}
+
+var extendedTypes = map[byte]string{
+	'n': "decltype(nullptr)",
+	'i': "char32_t",
+	's': "char16_t",
+	'u': "char8_t",
+	'a': "auto",
+	'c': "decltype(auto)",
+}
+
+var operators = map[string]string{
+	"nw": "new", "na": "new[]", "dl": "delete", "da": "delete[]",
+	"ps": "+", "ng": "-", "ad": "&", "de": "*", "co": "~",
+	"pl": "+", "mi": "-", "ml": "*", "dv": "/", "rm": "%",
+	"an": "&", "or": "|", "eo": "^", "aS": "=",
+	"pL": "+=", "mI": "-=", "mL": "*=", "dV": "/=", "rM": "%=",
+	"aN": "&=", "oR": "|=", "eO": "^=",
+	"ls": "<<", "rs": ">>", "lS": "<<=", "rS": ">>=",
+	"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=", "ss": "<=>",
+	"nt": "!", "aa": "&&", "oo": "||", "pp": "++", "mm": "--",
+	"cm": ",", "pm": "->*", "pt": "->", "cl": "()", "ix": "[]", "qu": "?",
+}
+
+var standardSubstitutions = map[byte]string{
+	'a': "std::allocator",
+	'b': "std::basic_string",
+	's': "std::string",
+	'i': "std::istream",
+	'o': "std::ostream",
+	'd': "std::iostream",
+}
+
+// nameInfo describes a parsed name, which decides how the function type
+// after it is read.
+type nameInfo struct {
+	// template is set when the name ends in template arguments, so a
+	// function's return type is mangled before its parameters.
+	template bool
+	// noReturn is set for constructors, destructors, and conversion
+	// operators, whose return type is never mangled.
+	noReturn bool
+	// quals are the cv- and ref-qualifiers of a member function.
+	quals string
+}
+
+type itanium struct {
+	s    string
+	pos  int
+	subs []string
+	// params are the template arguments T_ parameters refer to: those of
+	// the last template read in a function's own name.
+	params []string
+}
+
+func demangleItanium(s string) (out string, ok bool) {
+	d := &itanium{s: s}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, unsupported := r.(errUnsupported); !unsupported {
+				panic(r)
+			}
+			out, ok = "", false
+		}
+	}()
+	out = d.encoding()
+	if d.pos < len(d.s) {
+		if d.s[d.pos] != '.' {
+			return "", false
+		}
+		// Compiler clones such as .cold or .constprop.0.
+		out += " [clone " + d.s[d.pos:] + "]"
+	}
+	return undeclared.Replace(out), true
+}
+
+// undeclared drops the parentheses of function and array types that no
+// operator was placed in, then the remaining markers.
+var undeclared = strings.NewReplacer("("+declarator+") ", "", "("+declarator+")", "", declarator, "")
+
+func (d *itanium) fail() {
+	panic(errUnsupported{})
+}
+
+func (d *itanium) peek() byte {
+	if d.pos >= len(d.s) {
+		return 0
+	}
+	return d.s[d.pos]
+}
+
+func (d *itanium) peekAt(offset int) byte {
+	if d.pos+offset >= len(d.s) {
+		return 0
+	}
+	return d.s[d.pos+offset]
+}
+
+func (d *itanium) next() byte {
+	if d.pos >= len(d.s) {
+		d.fail()
+	}
+	c := d.s[d.pos]
+	d.pos++
+	return c
+}
+
+func (d *itanium) expect(c byte) {
+	if d.next() != c {
+		d.fail()
+	}
+}
+
+func (d *itanium) atEnd() bool {
+	c := d.peek()
+	return c == 0 || c == 'E' || c == '.'
+}
+
+func (d *itanium) number() int {
+	start := d.pos
+	for d.pos < len(d.s) && d.s[d.pos] >= '0' && d.s[d.pos] <= '9' {
+		d.pos++
+	}
+	n, err := strconv.Atoi(d.s[start:d.pos])
+	if err != nil {
+		d.fail()
+	}
+	return n
+}
+
+// encoding reads a function or data name and, for functions, the parameter
+// types that follow it.
+func (d *itanium) encoding() string {
+	name, info := d.name(true)
+	if d.atEnd() {
+		return name
+	}
+	ret := ""
+	if info.template && !info.noReturn {
+		ret = d.typ() + " "
+	}
+	return ret + name + "(" + d.parameters() + ")" + info.quals
+}
+
+// parameters reads a bare function type up to the end of the encoding.
+func (d *itanium) parameters() string {
+	if d.peek() == 'v' && (d.peekAt(1) == 0 || d.peekAt(1) == 'E' || d.peekAt(1) == '.') {
+		d.pos++
+		return ""
+	}
+	var params []string
+	for !d.atEnd() {
+		params = append(params, d.typ())
+	}
+	if len(params) == 0 {
+		d.fail()
+	}
+	return strings.Join(params, ", ")
+}
+
+func (d *itanium) name(outer bool) (string, nameInfo) {
+	switch c := d.peek(); {
+	case c == 'N':
+		return d.nested(outer)
+	case c == 'Z':
+		return d.local()
+	case c == 'S' && d.peekAt(1) == 't':
+		d.pos += 2
+		name, info := d.unqualified("")
+		name = "std::" + name
+		if d.peek() == 'I' {
+			d.subs = append(d.subs, name)
+			name += d.templateArgs(outer)
+			info.template = true
+		}
+		return name, info
+	case c == 'S':
+		name := d.substitution()
+		if d.peek() != 'I' {
+			d.fail()
+		}
+		return name + d.templateArgs(outer), nameInfo{template: true}
+	default:
+		name, info := d.unqualified("")
+		if d.peek() == 'I' {
+			d.subs = append(d.subs, name)
+			name += d.templateArgs(outer)
+			info.template = true
+		}
+		return name, info
+	}
+}
+
+func (d *itanium) nested(outer bool) (string, nameInfo) {
+	d.expect('N')
+	quals := d.qualifiers()
+	switch d.peek() {
+	case 'R':
+		d.pos++
+		quals += " &"
+	case 'O':
+		d.pos++
+		quals += " &&"
+	}
+
+	var (
+		prefix string
+		info   nameInfo
+	)
+	for d.peek() != 'E' {
+		info = nameInfo{}
+		switch c := d.peek(); {
+		case c == 'S' && d.peekAt(1) == 't':
+			d.pos += 2
+			prefix = "std"
+			continue
+		case c == 'S':
+			if prefix != "" {
+				d.fail()
+			}
+			prefix = d.substitution()
+			continue
+		case c == 'I':
+			if prefix == "" {
+				d.fail()
+			}
+			prefix += d.templateArgs(outer)
+			info.template = true
+		case c == 'T':
+			if prefix != "" {
+				d.fail()
+			}
+			prefix = d.templateParam()
+		default:
+			var name string
+			name, info = d.unqualified(prefix)
+			if prefix != "" {
+				name = prefix + "::" + name
+			}
+			prefix = name
+		}
+		if d.peek() != 'E' {
+			d.subs = append(d.subs, prefix)
+		}
+	}
+	d.pos++
+	if prefix == "" {
+		d.fail()
+	}
+	info.quals = quals
+	return prefix, info
+}
+
+// local reads Z <function encoding> E <entity> [<discriminator>].
+func (d *itanium) local() (string, nameInfo) {
+	d.expect('Z')
+	function := d.encoding()
+	d.expect('E')
+	if d.peek() == 's' {
+		d.pos++
+		d.discriminator()
+		return function + "::string literal", nameInfo{}
+	}
+	entity, info := d.name(false)
+	d.discriminator()
+	return function + "::" + entity, info
+}
+
+func (d *itanium) discriminator() {
+	if d.peek() != '_' {
+		return
+	}
+	d.pos++
+	if d.peek() == '_' {
+		d.pos++
+		d.number()
+		d.expect('_')
+		return
+	}
+	d.number()
+}
+
+// unqualified reads one component of a name. prefix is the enclosing scope,
+// which constructors and destructors are named after.
+func (d *itanium) unqualified(prefix string) (string, nameInfo) {
+	var (
+		name string
+		info nameInfo
+	)
+	switch c := d.peek(); {
+	case c >= '0' && c <= '9':
+		name = d.sourceName()
+	case c == 'L':
+		d.pos++
+		name = d.sourceName()
+		d.discriminator()
+	case c == 'C' || c == 'D':
+		if kind := d.peekAt(1); prefix == "" || kind < '0' || kind > '5' {
+			d.fail()
+		}
+		d.pos += 2
+		name = baseName(prefix)
+		if c == 'D' {
+			name = "~" + name
+		}
+		info.noReturn = true
+	case c == 'U':
+		name = d.unnamed()
+	case c >= 'a' && c <= 'z':
+		name, info.noReturn = d.operator()
+	default:
+		d.fail()
+	}
+	for d.peek() == 'B' {
+		d.pos++
+		name += "[abi:" + d.sourceName() + "]"
+	}
+	return name, info
+}
+
+func (d *itanium) sourceName() string {
+	n := d.number()
+	if n <= 0 || d.pos+n > len(d.s) {
+		d.fail()
+	}
+	name := d.s[d.pos : d.pos+n]
+	d.pos += n
+	if strings.HasPrefix(name, "_GLOBAL_") && len(name) > 9 && name[9] == 'N' {
+		return "(anonymous namespace)"
+	}
+	return name
+}
+
+// unnamed reads an unnamed type (Ut) or a closure type (Ul).
+func (d *itanium) unnamed() string {
+	d.expect('U')
+	switch d.next() {
+	case 't':
+		return "{unnamed type#" + d.sequence() + "}"
+	case 'l':
+		params := d.parameters()
+		d.expect('E')
+		return "{lambda(" + params + ")#" + d.sequence() + "}"
+	}
+	d.fail()
+	return ""
+}
+
+// sequence reads the [<number>] _ numbering of unnamed types, where _ is the
+// first.
+func (d *itanium) sequence() string {
+	n := 1
+	if d.peek() != '_' {
+		n = d.number() + 2
+	}
+	d.expect('_')
+	return strconv.Itoa(n)
+}
+
+func (d *itanium) operator() (string, bool) {
+	if d.pos+2 > len(d.s) {
+		d.fail()
+	}
+	code := d.s[d.pos : d.pos+2]
+	d.pos += 2
+	switch code {
+	case "cv":
+		return "operator " + d.typ(), true
+	case "li":
+		return "operator\"\" " + d.sourceName(), false
+	}
+	op, ok := operators[code]
+	if !ok {
+		d.fail()
+	}
+	if op[0] >= 'a' && op[0] <= 'z' {
+		return "operator " + op, false
+	}
+	return "operator" + op, false
+}
+
+// qualifiers reads r, V, and K cv-qualifiers and renders them the way
+// c++filt orders them.
+func (d *itanium) qualifiers() string {
+	var restrict, volatile, konst bool
+	for {
+		switch d.peek() {
+		case 'r':
+			restrict = true
+		case 'V':
+			volatile = true
+		case 'K':
+			konst = true
+		default:
+			var out string
+			if konst {
+				out += " const"
+			}
+			if volatile {
+				out += " volatile"
+			}
+			if restrict {
+				out += " restrict"
+			}
+			return out
+		}
+		d.pos++
+	}
+}
+
+func (d *itanium) typ() string {
+	c := d.peek()
+	if builtin, ok := builtinTypes[c]; ok {
+		d.pos++
+		return builtin
+	}
+
+	var t string
+	switch {
+	case c == 'u':
+		d.pos++
+		t = d.sourceName()
+	case c == 'D':
+		d.pos++
+		switch next := d.next(); next {
+		case 'p':
+			// A pack expansion. Packs are substituted already joined, so
+			// the expansion is the pack itself.
+			t = d.typ()
+		default:
+			extended, ok := extendedTypes[next]
+			if !ok {
+				d.fail()
+			}
+			return extended
+		}
+	case c == 'r' || c == 'V' || c == 'K':
+		quals := d.qualifiers()
+		t = qualify(d.typ(), quals)
+	case c == 'P':
+		d.pos++
+		t = declare(d.typ(), "*")
+	case c == 'R':
+		d.pos++
+		t = declare(d.typ(), "&")
+	case c == 'O':
+		d.pos++
+		t = declare(d.typ(), "&&")
+	case c == 'F':
+		t = d.functionType()
+	case c == 'A':
+		d.pos++
+		size := ""
+		if d.peek() != '_' {
+			size = strconv.Itoa(d.number())
+		}
+		d.expect('_')
+		t = d.typ() + " (" + declarator + ") [" + size + "]"
+	case c == 'M':
+		d.pos++
+		class := d.typ()
+		t = declare(d.typ(), class+"::*")
+	case c == 'T':
+		t = d.templateParam()
+		if d.peek() == 'I' {
+			d.subs = append(d.subs, t)
+			t += d.templateArgs(false)
+		}
+	case c == 'S' && d.peekAt(1) == 't':
+		d.pos += 2
+		name, _ := d.unqualified("")
+		t = "std::" + name
+		if d.peek() == 'I' {
+			d.subs = append(d.subs, t)
+			t += d.templateArgs(false)
+		}
+	case c == 'S':
+		t = d.substitution()
+		if d.peek() != 'I' {
+			return t
+		}
+		t += d.templateArgs(false)
+	case c == 'N' || c == 'Z' || (c >= '0' && c <= '9'):
+		t, _ = d.name(false)
+	default:
+		d.fail()
+	}
+	d.subs = append(d.subs, t)
+	return t
+}
+
+// functionType reads F [Y] <return type> <parameters> [<ref-qualifier>] E.
+func (d *itanium) functionType() string {
+	d.expect('F')
+	if d.peek() == 'Y' {
+		d.pos++
+	}
+	ret := d.typ()
+	var params []string
+	quals := ""
+	for d.peek() != 'E' {
+		switch {
+		case d.peek() == 'R' && d.peekAt(1) == 'E':
+			d.pos++
+			quals = " &"
+		case d.peek() == 'O' && d.peekAt(1) == 'E':
+			d.pos++
+			quals = " &&"
+		default:
+			params = append(params, d.typ())
+		}
+	}
+	d.pos++
+	if len(params) == 1 && params[0] == "void" {
+		params = nil
+	}
+	return ret + " (" + declarator + ")(" + strings.Join(params, ", ") + ")" + quals
+}
+
+func (d *itanium) templateParam() string {
+	d.expect('T')
+	n := 0
+	if d.peek() != '_' {
+		n = d.number() + 1
+	}
+	d.expect('_')
+	if n >= len(d.params) {
+		d.fail()
+	}
+	return d.params[n]
+}
+
+// templateArgs reads I <arg>+ E. The arguments of an outer name become the
+// ones T_ parameters refer to.
+func (d *itanium) templateArgs(outer bool) string {
+	d.expect('I')
+	var args []string
+	for d.peek() != 'E' {
+		args = append(args, d.templateArg())
+	}
+	d.pos++
+	if outer {
+		d.params = args
+	}
+	out := "<" + strings.Join(args, ", ")
+	if strings.HasSuffix(out, ">") {
+		out += " "
+	}
+	return out + ">"
+}
+
+func (d *itanium) templateArg() string {
+	switch d.peek() {
+	case 'L':
+		return d.literal()
+	case 'J':
+		d.pos++
+		var args []string
+		for d.peek() != 'E' {
+			args = append(args, d.templateArg())
+		}
+		d.pos++
+		return strings.Join(args, ", ")
+	case 'X':
+		d.fail()
+	}
+	return d.typ()
+}
+
+// literal reads L <type> <value> E for integer and boolean arguments.
+func (d *itanium) literal() string {
+	d.expect('L')
+	kind := d.next()
+	negative := d.peek() == 'n'
+	if negative {
+		d.pos++
+	}
+	value := strconv.Itoa(d.number())
+	if negative {
+		value = "-" + value
+	}
+	d.expect('E')
+	switch kind {
+	case 'b':
+		switch value {
+		case "0":
+			return "false"
+		case "1":
+			return "true"
+		}
+	case 'i':
+		return value
+	case 'j':
+		return value + "u"
+	case 'l':
+		return value + "l"
+	case 'm':
+		return value + "ul"
+	case 'x':
+		return value + "ll"
+	case 'y':
+		return value + "ull"
+	}
+	builtin, ok := builtinTypes[kind]
+	if !ok {
+		d.fail()
+	}
+	return "(" + builtin + ")" + value
+}
+
+// substitution reads S_, S <seq-id> _, or a standard abbreviation other than
+// St, which callers handle.
+func (d *itanium) substitution() string {
+	d.expect('S')
+	c := d.peek()
+	if standard, ok := standardSubstitutions[c]; ok {
+		d.pos++
+		return standard
+	}
+	n := 0
+	if c != '_' {
+		start := d.pos
+		for isSeqDigit(d.peek()) {
+			d.pos++
+		}
+		if d.pos == start {
+			d.fail()
+		}
+		seq, err := strconv.ParseUint(d.s[start:d.pos], 36, 32)
+		if err != nil {
+			d.fail()
+		}
+		n = int(seq) + 1
+	}
+	d.expect('_')
+	if n >= len(d.subs) {
+		d.fail()
+	}
+	return d.subs[n]
+}
+
+func isSeqDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z'
+}
+
+// declare applies a pointer, reference, or pointer-to-member operator,
+// placing it inside a function or array type's parentheses.
+func declare(t, op string) string {
+	if strings.Contains(t, declarator) {
+		return strings.Replace(t, declarator, op+declarator, 1)
+	}
+	return t + op
+}
+
+func qualify(t, quals string) string {
+	if strings.Contains(t, declarator) {
+		return strings.Replace(t, declarator, quals+declarator, 1)
+	}
+	return t + quals
+}
+
+// baseName is the last component of a scope without its template arguments,
+// which is what constructors and destructors are named.
+func baseName(scope string) string {
+	name := scope
+	if strings.HasSuffix(name, ">") {
+		depth := 0
+	args:
+		for i := len(name) - 1; i >= 0; i-- {
+			switch name[i] {
+			case '>':
+				depth++
+			case '<':
+				depth--
+				if depth == 0 {
+					name = strings.TrimSuffix(name[:i], " ")
+					break args
+				}
+			}
+		}
+	}
+	depth := 0
+	for i := len(name) - 1; i > 0; i-- {
+		switch name[i] {
+		case '>', ')':
+			depth++
+		case '<', '(':
+			depth--
+		case ':':
+			if depth == 0 && name[i-1] == ':' {
+				return name[i+1:]
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,210 @@
+package demangle
+
+import (
+	"strconv"
+	"strings"
+)
+
+// swiftPrefixes start Swift 5 symbols, after the leading underscore darwin
+// adds has been removed. $e is the Embedded Swift variant.
+var swiftPrefixes = []string{"$s", "$S", "$e"}
+
+// swiftStandardTypes are the S<letter> shorthands for standard library types.
+var swiftStandardTypes = map[byte]string{
+	'a': "Array",
+	'b': "Bool",
+	'D': "Dictionary",
+	'd': "Double",
+	'f': "Float",
+	'h': "Set",
+	'i': "Int",
+	'J': "Character",
+	'N': "ClosedRange",
+	'n': "Range",
+	'O': "ObjectIdentifier",
+	'P': "UnsafePointer",
+	'p': "UnsafeMutablePointer",
+	'q': "Optional",
+	'R': "UnsafeBufferPointer",
+	'r': "UnsafeMutableBufferPointer",
+	'S': "String",
+	's': "Substring",
+	'u': "UInt",
+	'V': "UnsafeRawPointer",
+	'v': "UnsafeMutableRawPointer",
+	'W': "UnsafeRawBufferPointer",
+	'w': "UnsafeMutableRawBufferPointer",
+}
+
+// swiftMaxWords is how many words an identifier can refer back to.
+const swiftMaxWords = 26
+
+type swift struct {
+	s     string
+	pos   int
+	words []string
+}
+
+// demangleSwift reads the context chain at the start of a Swift symbol: a
+// module, the nominal types and extensions inside it, and the member they
+// end in. Everything after the member (its signature and the entity kind)
+// is skipped.
+func demangleSwift(s string) (string, bool) {
+	d := &swift{s: s}
+	var path []string
+	switch c := d.peek(); {
+	case c == 's':
+		d.pos++
+		path = append(path, "Swift")
+	case c == 'S':
+		name, ok := swiftStandardTypes[d.peekAt(1)]
+		if !ok {
+			return "", false
+		}
+		d.pos += 2
+		path = append(path, "Swift", name)
+	default:
+		module, ok := d.identifier()
+		if !ok {
+			return "", false
+		}
+		path = append(path, module)
+	}
+
+	for isDigit(d.peek()) {
+		name, ok := d.identifier()
+		if !ok {
+			return "", false
+		}
+		d.privateDiscriminator()
+		switch d.peek() {
+		case 'C', 'V', 'O', 'P':
+			d.pos++
+			path = append(path, name)
+		case 'E':
+			// name is the module declaring an extension of the context.
+			d.pos++
+		default:
+			return strings.Join(append(path, name), "."), true
+		}
+	}
+	return strings.Join(path, "."), true
+}
+
+func (d *swift) peek() byte {
+	return d.peekAt(0)
+}
+
+func (d *swift) peekAt(offset int) byte {
+	if d.pos+offset >= len(d.s) {
+		return 0
+	}
+	return d.s[d.pos+offset]
+}
+
+// privateDiscriminator skips the identifier and LL that follow the name of
+// a private declaration.
+func (d *swift) privateDiscriminator() {
+	start, words := d.pos, len(d.words)
+	if !isDigit(d.peek()) {
+		return
+	}
+	if _, ok := d.identifier(); ok && d.peek() == 'L' && d.peekAt(1) == 'L' {
+		d.pos += 2
+		return
+	}
+	d.pos, d.words = start, d.words[:words]
+}
+
+func (d *swift) natural() (int, bool) {
+	start := d.pos
+	for isDigit(d.peek()) {
+		d.pos++
+	}
+	n, err := strconv.Atoi(d.s[start:d.pos])
+	return n, err == nil
+}
+
+// identifier reads a length-prefixed identifier. A leading 0 means the
+// identifier mixes literal pieces with references to words of earlier
+// identifiers: a lowercase letter refers to a word and more follow, an
+// uppercase letter to the last one, and a 0 ends an identifier that ends in
+// a reference. Punycoded (00) identifiers are not decoded.
+func (d *swift) identifier() (string, bool) {
+	substituted := false
+	if d.peek() == '0' {
+		d.pos++
+		if d.peek() == '0' {
+			return "", false
+		}
+		substituted = true
+	}
+	var out strings.Builder
+	for {
+		for substituted {
+			c := d.peek()
+			var index int
+			switch {
+			case c >= 'a' && c <= 'z':
+				index = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				index = int(c - 'A')
+				substituted = false
+			default:
+				goto literal
+			}
+			if index >= len(d.words) {
+				return "", false
+			}
+			d.pos++
+			out.WriteString(d.words[index])
+		}
+	literal:
+		if d.peek() == '0' {
+			d.pos++
+			break
+		}
+		n, ok := d.natural()
+		if !ok || n <= 0 || d.pos+n > len(d.s) {
+			return "", false
+		}
+		piece := d.s[d.pos : d.pos+n]
+		d.pos += n
+		d.addWords(piece)
+		out.WriteString(piece)
+		if !substituted {
+			break
+		}
+	}
+	return out.String(), true
+}
+
+// addWords records the words of an identifier piece for later references. A
+// word starts at any character other than a digit or underscore and ends at
+// an underscore or where a lowercase letter is followed by an uppercase one.
+func (d *swift) addWords(piece string) {
+	start := -1
+	for i := 0; i <= len(piece); i++ {
+		var c byte
+		if i < len(piece) {
+			c = piece[i]
+		}
+		if start >= 0 && (c == '_' || c == 0 || !isUpper(piece[i-1]) && isUpper(c)) {
+			if i-start >= 2 && len(d.words) < swiftMaxWords {
+				d.words = append(d.words, piece[start:i])
+			}
+			start = -1
+		}
+		if start < 0 && c != 0 && c != '_' && !isDigit(c) {
+			start = i
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
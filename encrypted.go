@@ -0,0 +1,180 @@
+package reflektor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sliverarmory/reflektor/compress"
+)
+
+// CipherScheme names the AEAD an encrypted payload is sealed with.
+type CipherScheme string
+
+const (
+	// CipherAES256GCM is AES-256 in GCM mode with a 12-byte nonce and a
+	// 32-byte key.
+	CipherAES256GCM CipherScheme = "aes-256-gcm"
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305 (RFC 8439) with a 12-byte
+	// nonce and a 32-byte key. It is built only with the reflektor_chacha or
+	// reflektor_full build tag.
+	CipherChaCha20Poly1305 CipherScheme = "chacha20-poly1305"
+)
+
+// ErrDecrypt is returned when an encrypted payload fails authentication,
+// typically because the key or scheme is wrong or the data was modified.
+var ErrDecrypt = errors.New("reflektor: payload failed to decrypt")
+
+// LoadEncryptedLibrary decrypts a sealed shared library image and loads it
+// from memory. data is the nonce followed by the ciphertext and its
+// authentication tag, as produced by cipher.AEAD.Seal(nonce, nonce, image,
+// nil). The plaintext is zeroed as soon as the image is mapped, or when the
+// load fails, so it does not linger in the Go heap.
+func LoadEncryptedLibrary(data, key []byte, scheme CipherScheme) (*Library, error) {
+	return LoadEncryptedLibraryWithOptions(data, key, scheme, Options{})
+}
+
+// LoadEncryptedLibraryWithOptions is like LoadEncryptedLibrary but loads the
+// decrypted image using opts. ZeroInput applies to data, the sealed payload.
+func LoadEncryptedLibraryWithOptions(data, key []byte, scheme CipherScheme, opts Options) (*Library, error) {
+	aead, err := newAEAD(key, scheme)
+	if err != nil {
+		return nil, err
+	}
+	image, err := openSealed(aead, data, false)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(image)
+
+	zeroInput := opts.ZeroInput
+	opts.ZeroInput = false
+	library, err := LoadLibraryWithOptions(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	library.info.Encrypted = true
+	if zeroInput {
+		clear(data)
+	}
+	return library, nil
+}
+
+// LoadEncryptedLibraryFromReader reads a sealed image from r, decrypts it in
+// place, and loads it, so the payload is held in memory once rather than
+// sealed and decrypted side by side. The buffer is zeroed once the image is
+// mapped, or when the load fails.
+func LoadEncryptedLibraryFromReader(r io.Reader, key []byte, scheme CipherScheme) (*Library, error) {
+	return LoadEncryptedLibraryFromReaderWithOptions(r, key, scheme, Options{})
+}
+
+// LoadEncryptedLibraryFromReaderWithOptions is like
+// LoadEncryptedLibraryFromReader but loads the decrypted image using opts.
+// ZeroInput has no effect: the buffer belongs to the loader and is always
+// zeroed.
+func LoadEncryptedLibraryFromReaderWithOptions(r io.Reader, key []byte, scheme CipherScheme, opts Options) (*Library, error) {
+	aead, err := newAEAD(key, scheme)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(readerSize(r) + bytes.MinRead)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("reflektor: read encrypted payload: %w", err)
+	}
+	data := buf.Bytes()
+	defer clear(data)
+	image, err := openSealed(aead, data, true)
+	if err != nil {
+		return nil, err
+	}
+	opts.ZeroInput = false
+	library, err := LoadLibraryWithOptions(image, opts)
+	if err != nil {
+		return nil, err
+	}
+	library.info.Encrypted = true
+	return library, nil
+}
+
+// SealLibrary seals an image for LoadEncryptedLibrary under key with a fresh
+// random nonce. image may be packed (see the compress package); the loaders
+// unpack it once it is decrypted.
+func SealLibrary(image, key []byte, scheme CipherScheme) ([]byte, error) {
+	aead, err := newAEAD(key, scheme)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(image)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("reflektor: generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, image, nil), nil
+}
+
+// SealedTransform returns a compress.Transform that opens payloads sealed
+// under key, such as SealLibrary's output. Registered under a magic with
+// compress.RegisterTransform, it lets the plain loaders take sealed payloads
+// that carry the magic in front, with decryption running in the same
+// pipeline as unpacking:
+//
+//	open, err := reflektor.SealedTransform(key, reflektor.CipherAES256GCM)
+//	...
+//	compress.RegisterTransform("sealed", []byte("RFKS"), open)
+func SealedTransform(key []byte, scheme CipherScheme) (compress.Transform, error) {
+	aead, err := newAEAD(key, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte, limit uint64) ([]byte, error) {
+		if size := len(data) - aead.NonceSize() - aead.Overhead(); size > 0 && uint64(size) > limit {
+			return nil, fmt.Errorf("%w: %d bytes, limit %d", compress.ErrSizeLimit, size, limit)
+		}
+		return openSealed(aead, data, false)
+	}, nil
+}
+
+// openSealed decrypts data, the nonce followed by the ciphertext and its
+// tag. With inPlace set the plaintext overwrites data; otherwise it goes in
+// a new buffer and data is left as it was.
+func openSealed(aead cipher.AEAD, data []byte, inPlace bool) ([]byte, error) {
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("reflektor: encrypted payload is shorter than its nonce and tag")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	dst := sealed[:0]
+	if !inPlace {
+		dst = make([]byte, 0, len(sealed)-aead.Overhead())
+	}
+	image, err := aead.Open(dst, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return image, nil
+}
+
+func newAEAD(key []byte, scheme CipherScheme) (cipher.AEAD, error) {
+	switch scheme {
+	case CipherAES256GCM:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("reflektor: %s needs a 32-byte key, got %d bytes", scheme, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("reflektor: %s: %w", scheme, err)
+		}
+		return cipher.NewGCM(block)
+	case CipherChaCha20Poly1305:
+		aead, err := newChaCha20Poly1305(key)
+		if err != nil {
+			return nil, fmt.Errorf("reflektor: %s: %w", scheme, err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("reflektor: unknown cipher scheme %q", scheme)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build reflektor_chacha || reflektor_full
+
+package reflektor
+
+import (
+	"crypto/cipher"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const chachaBuilt = true
+
+func newChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	return chacha20poly1305.New(key)
+}
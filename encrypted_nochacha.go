@@ -0,0 +1,12 @@
+//go:build !reflektor_chacha && !reflektor_full
+
+package reflektor
+
+import "crypto/cipher"
+
+const chachaBuilt = false
+
+func newChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	_ = key
+	return nil, ErrNotBuilt
+}
@@ -0,0 +1,68 @@
+package reflektor
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// ErrNotBuilt is returned when a payload needs a backend or cipher that build
+// tags left out of this binary. Features lists what was built.
+var ErrNotBuilt = errors.New("reflektor: not built into this binary")
+
+// FeatureSet describes what this build of reflektor can do. It depends on the
+// target platform and build tags: the Lua backend, zstd, XZ and LZMA, and
+// ChaCha20-Poly1305 are present only in builds that opt in with
+// reflektor_lua, reflektor_zstd, reflektor_xz, reflektor_chacha, or
+// reflektor_full. Embedders can branch on it at startup rather than
+// discovering a missing capability when a call fails.
+type FeatureSet struct {
+	// NativeLoader is set when native images can be mapped on this GOOS
+	// and GOARCH. Without it only scripts load.
+	NativeLoader bool
+	// Cgo is set when the package was built with cgo. On linux, export
+	// calls then run through a C shim that restores the floating-point
+	// environment, and native threads are available.
+	Cgo bool
+	// NativeThreads is set when Options.Thread is supported: on windows,
+	// and on linux with cgo.
+	NativeThreads bool
+	// Backends lists the backends Open dispatches to.
+	Backends []Backend
+	// CLRHost is set when .NET assemblies can be hosted. No CLR host is
+	// built yet, so Open rejects FormatCLR.
+	CLRHost bool
+	// Injectors is set when payloads can be loaded into other processes.
+	// reflektor only loads into the calling process.
+	Injectors bool
+	// Compression lists the packed payload formats the loaders unpack, as
+	// compress.Codec names.
+	Compression []string
+	// Ciphers lists the schemes LoadEncryptedLibrary accepts.
+	Ciphers []CipherScheme
+}
+
+// Features reports the capabilities compiled into this build.
+func Features() FeatureSet {
+	features := FeatureSet{
+		NativeLoader: memmod.Supported,
+		Cgo:          cgoEnabled,
+		Ciphers:      []CipherScheme{CipherAES256GCM},
+	}
+	for _, codec := range compress.Codecs() {
+		features.Compression = append(features.Compression, string(codec))
+	}
+	if chachaBuilt {
+		features.Ciphers = append(features.Ciphers, CipherChaCha20Poly1305)
+	}
+	if memmod.Supported {
+		features.Backends = append(features.Backends, BackendNative)
+		features.NativeThreads = runtime.GOOS == "windows" || runtime.GOOS == "linux" && cgoEnabled
+	}
+	if luaBuilt {
+		features.Backends = append(features.Backends, BackendLua)
+	}
+	return features
+}
@@ -0,0 +1,5 @@
+//go:build !cgo
+
+package reflektor
+
+const cgoEnabled = false
@@ -3,11 +3,9 @@ module github.com/sliverarmory/reflektor
 go 1.25.6
 
 require (
-	github.com/spf13/cobra v1.10.2
+	github.com/klauspost/compress v1.20.1
+	github.com/ulikunitz/xz v0.5.15
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.43.0
 	golang.org/x/sys v0.41.0
 )
-
-require (
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.9 // indirect
-)
@@ -0,0 +1,132 @@
+package reflektor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Group is a set of interdependent libraries loaded and torn down as a unit,
+// such as the modules of a plugin bundle. Its exports are the union of its
+// members' exports.
+type Group struct {
+	name    string
+	members map[string]*Library
+	// order is the load order, dependencies first.
+	order []string
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// LoadGroup loads images as a group named name, keyed and bound to each
+// other as in LoadLibrarySet. If any image fails to load, the ones already
+// loaded are closed and no group is returned.
+func LoadGroup(name string, images map[string][]byte) (*Group, error) {
+	return LoadGroupWithOptions(name, images, Options{})
+}
+
+// LoadGroupWithOptions is like LoadGroup but loads every image with opts.
+func LoadGroupWithOptions(name string, images map[string][]byte, opts Options) (*Group, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("reflektor: group %s has no images", name)
+	}
+	members, order, err := loadLibrarySet(images, opts)
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: group %s: %w", name, err)
+	}
+	return &Group{name: name, members: members, order: order}, nil
+}
+
+// Name returns the name the group was loaded under.
+func (group *Group) Name() string {
+	return group.name
+}
+
+// Members returns the keys of the group's libraries in the order they were
+// loaded, dependencies first.
+func (group *Group) Members() []string {
+	return slices.Clone(group.order)
+}
+
+// Library returns the member loaded under key, or nil. It is closed with the
+// group and must not be closed on its own.
+func (group *Group) Library(key string) *Library {
+	return group.members[key]
+}
+
+// Exports returns the union of the members' exports, sorted and without
+// duplicates.
+func (group *Group) Exports() ([]string, error) {
+	var out []string
+	for _, key := range group.order {
+		exports, err := group.members[key].Exports()
+		if err != nil {
+			return nil, fmt.Errorf("reflektor: group %s: %s: %w", group.name, key, err)
+		}
+		out = append(out, exports...)
+	}
+	sort.Strings(out)
+	return slices.Compact(out), nil
+}
+
+// Owner returns the key of the member that exports name. Members are
+// searched in load order, so when several export the same name the one
+// loaded first wins, as it does for the imports of later members.
+func (group *Group) Owner(name string) (string, error) {
+	for _, key := range group.order {
+		if _, err := group.members[key].ProcAddress(name); err == nil {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("reflektor: group %s: no member exports %q", group.name, name)
+}
+
+// ProcAddress returns the address of the export name from the member that
+// owns it (see Owner).
+func (group *Group) ProcAddress(name string) (uintptr, error) {
+	key, err := group.Owner(name)
+	if err != nil {
+		return 0, err
+	}
+	return group.members[key].ProcAddress(name)
+}
+
+// CallExport calls the export name on the member that owns it.
+func (group *Group) CallExport(name string) error {
+	key, err := group.Owner(name)
+	if err != nil {
+		return err
+	}
+	return group.members[key].CallExport(name)
+}
+
+// Call calls the export name with args on the member that owns it and
+// returns its raw return value.
+func (group *Group) Call(name string, args ...uintptr) (uintptr, error) {
+	key, err := group.Owner(name)
+	if err != nil {
+		return 0, err
+	}
+	return group.members[key].Call(name, args...)
+}
+
+// Close closes every member in reverse load order, so no library is
+// unmapped while a member bound to it is still loaded. It is safe to call
+// more than once and returns the members' errors joined.
+func (group *Group) Close() error {
+	group.closeOnce.Do(func() {
+		var errs []error
+		for _, key := range slices.Backward(group.order) {
+			if err := group.members[key].Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			group.closeErr = fmt.Errorf("reflektor: close group %s: %w", group.name, err)
+		}
+	})
+	return group.closeErr
+}
@@ -0,0 +1,160 @@
+package reflektor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+var (
+	// ErrKillUnsupported is returned by ExportHandle.Kill when the export
+	// runs somewhere it cannot be stopped from outside.
+	ErrKillUnsupported = errors.New("reflektor: export cannot be killed")
+	// ErrExportKilled is what ExportHandle.Wait reports for an export that
+	// Kill stopped.
+	ErrExportKilled = errors.New("reflektor: export was killed")
+)
+
+// killableStarter is implemented by modules that can end the native thread
+// an export runs on.
+type killableStarter interface {
+	StartKillableExportThread(name string, opts memmod.ThreadOptions) (func() memmod.CallResult, func() error, error)
+}
+
+// ExportHandle tracks an export started with StartExport.
+type ExportHandle struct {
+	name string
+	done chan struct{}
+	kill func() error
+
+	// mu orders Kill against finish: the export's result is decided under
+	// it, so a kill that succeeds is always reported.
+	mu       sync.Mutex
+	killed   bool
+	finished bool
+	result   CallResult
+	err      error
+}
+
+// StartExport calls a zero-argument export in the background and returns as
+// soon as it is started, for exports such as StartW that may never return.
+// The export runs as CallExportResult would run it: on the library's
+// dedicated thread with Options.SingleThreaded, on a new native thread with
+// Options.Thread (Detached has no effect here), and on its own goroutine
+// otherwise. It stays registered as in flight until it returns, so Close
+// waits for it; use CloseWithTimeout or CloseContext to bound that wait.
+func (library *Library) StartExport(name string) (*ExportHandle, error) {
+	handle, err := library.startExport(name)
+	recordAudit(AuditEvent{Op: AuditStart, Payload: library.digest, Export: name}, err)
+	return handle, err
+}
+
+func (library *Library) startExport(name string) (*ExportHandle, error) {
+	module, err := library.acquireCall(name)
+	if err != nil {
+		return nil, err
+	}
+	handle := &ExportHandle{name: name, done: make(chan struct{})}
+
+	if library.native == nil {
+		go func() {
+			handle.finish(library.callAcquired(module, name, nil))
+		}()
+		return handle, nil
+	}
+
+	var wait func() memmod.CallResult
+	if starter, ok := module.(killableStarter); ok {
+		wait, handle.kill, err = starter.StartKillableExportThread(name, *library.native)
+	} else {
+		wait, err = module.StartExportThread(name, *library.native)
+	}
+	if err != nil {
+		library.release()
+		return nil, fmt.Errorf("reflektor: start export %q: %w", name, err)
+	}
+	go func() {
+		defer library.release()
+		result := wait()
+		// The native thread restores its own signal mask.
+		if err := library.restoreSignalHandlers(); err != nil {
+			handle.finish(CallResult{}, fmt.Errorf("reflektor: call export %q: %w", name, err))
+			return
+		}
+		handle.finish(nativeThreadResult(name, result))
+	}()
+	return handle, nil
+}
+
+func (handle *ExportHandle) finish(result CallResult, err error) {
+	handle.mu.Lock()
+	if handle.killed {
+		result, err = CallResult{}, fmt.Errorf("reflektor: call export %q: %w", handle.name, ErrExportKilled)
+	}
+	handle.result, handle.err = result, err
+	handle.finished = true
+	handle.mu.Unlock()
+	close(handle.done)
+}
+
+// Name returns the export the handle tracks.
+func (handle *ExportHandle) Name() string {
+	return handle.name
+}
+
+// Running reports whether the export has not returned yet.
+func (handle *ExportHandle) Running() bool {
+	select {
+	case <-handle.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Done returns a channel that is closed once the export returns.
+func (handle *ExportHandle) Done() <-chan struct{} {
+	return handle.done
+}
+
+// Wait blocks until the export returns and reports its result as
+// CallExportResult does, or until ctx is done, which leaves the export
+// running and returns ctx's error. Wait may be called any number of times.
+func (handle *ExportHandle) Wait(ctx context.Context) (CallResult, error) {
+	select {
+	case <-handle.done:
+	case <-ctx.Done():
+		return CallResult{}, ctx.Err()
+	}
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return handle.result, handle.err
+}
+
+// Kill stops the export on a best-effort basis; Wait then reports
+// ErrExportKilled. Only a windows export started on a native thread
+// (Options.Thread) can be stopped, with TerminateThread, which leaves any
+// locks or heap state the thread held as they were. Elsewhere Kill returns
+// ErrKillUnsupported and the export keeps running. Killing an export that
+// has returned does nothing.
+func (handle *ExportHandle) Kill() error {
+	if !handle.Running() {
+		return nil
+	}
+	if handle.kill == nil {
+		return ErrKillUnsupported
+	}
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	if handle.finished {
+		return nil
+	}
+	if err := handle.kill(); err != nil {
+		return fmt.Errorf("reflektor: kill export %q: %w", handle.name, err)
+	}
+	handle.killed = true
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package loadertest soak-tests the loader: it loads, calls, and frees a
+// payload over and over while watching the process for what each cycle
+// leaves behind, so regressions in the unload paths, which ordinary tests
+// exercise once at most, show up as steady growth before a release does.
+//
+//	report, err := loadertest.Soak(payload, 500)
+//	if err == nil {
+//		err = report.Check(loadertest.DefaultLimits)
+//	}
+package loadertest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/sliverarmory/reflektor"
+)
+
+// Usage is a measurement of the process. A zero field was not measured on
+// this platform.
+type Usage struct {
+	// RSS is the resident set size in bytes.
+	RSS uint64
+	// Handles counts open file descriptors, or handles on windows.
+	Handles int
+	// Threads counts the process's OS threads.
+	Threads int
+	// MappedBytes is reflektor.MappedBytes: the address space held by
+	// loaded images.
+	MappedBytes uint64
+}
+
+// Options tunes SoakWithOptions. The zero value matches Soak.
+type Options struct {
+	// Load is passed to reflektor.LoadLibraryWithOptions each cycle.
+	Load reflektor.Options
+	// Export, when set, is called with Args once per cycle, between the
+	// load and the Close.
+	Export string
+	Args   []uintptr
+	// Warmup is how many cycles run before the baseline is measured, so
+	// that one-time costs such as dependencies the first load opens, or
+	// the loader's own caches, are not counted as leaks. Zero uses
+	// DefaultWarmup.
+	Warmup int
+}
+
+// DefaultWarmup is the warmup Soak uses.
+const DefaultWarmup = 3
+
+// Report is the result of a soak run.
+type Report struct {
+	// Iterations counts the measured cycles, after the warmup.
+	Iterations int
+	// Before is measured after the warmup and After once the last cycle
+	// has closed its library; both after a garbage collection.
+	Before Usage
+	After  Usage
+	// PeakRSS is the largest RSS seen between cycles.
+	PeakRSS uint64
+}
+
+// Limits bounds the growth Report.Check accepts over a run. The allocator
+// and the Go runtime keep some memory and threads they used, so a little
+// growth is not a leak; growth proportional to the cycle count is.
+type Limits struct {
+	RSSGrowth    uint64
+	HandleGrowth int
+	ThreadGrowth int
+	// MappedGrowth is normally zero: every Close hands back its image's
+	// address space.
+	MappedGrowth uint64
+}
+
+// DefaultLimits allows 16 MiB of RSS and a few handles and threads of growth
+// over a run, and none in reflektor.MappedBytes, which every Close must
+// return to where it was.
+var DefaultLimits = Limits{
+	RSSGrowth:    16 << 20,
+	HandleGrowth: 4,
+	ThreadGrowth: 4,
+}
+
+// ErrLeak is returned by Report.Check when a run grew past its limits.
+var ErrLeak = errors.New("loadertest: resources grew over the run")
+
+// Soak loads lib, closes it, and repeats, iterations times after a warmup of
+// DefaultWarmup cycles, and reports how the process's usage changed.
+func Soak(lib []byte, iterations int) (*Report, error) {
+	return SoakWithOptions(lib, iterations, Options{})
+}
+
+// SoakWithOptions is like Soak but loads and calls lib as opts says. It
+// stops at the first cycle that fails to load, call, or close, and returns
+// that error with the cycle's number.
+func SoakWithOptions(lib []byte, iterations int, opts Options) (*Report, error) {
+	if iterations <= 0 {
+		return nil, errors.New("loadertest: iterations must be positive")
+	}
+	warmup := opts.Warmup
+	if warmup <= 0 {
+		warmup = DefaultWarmup
+	}
+	for i := 0; i < warmup; i++ {
+		if err := cycle(lib, opts); err != nil {
+			return nil, fmt.Errorf("loadertest: warmup cycle %d: %w", i, err)
+		}
+	}
+
+	report := &Report{Iterations: iterations}
+	before, err := measure()
+	if err != nil {
+		return nil, err
+	}
+	report.Before, report.PeakRSS = before, before.RSS
+	for i := 0; i < iterations; i++ {
+		if err := cycle(lib, opts); err != nil {
+			return report, fmt.Errorf("loadertest: cycle %d: %w", i, err)
+		}
+		if rss, err := residentBytes(); err == nil {
+			report.PeakRSS = max(report.PeakRSS, rss)
+		}
+	}
+	if report.After, err = measure(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// cycle loads lib, calls the export opts names, and closes it. The image is
+// copied because options such as ZeroInput clear the buffer.
+func cycle(lib []byte, opts Options) error {
+	library, err := reflektor.LoadLibraryWithOptions(append([]byte(nil), lib...), opts.Load)
+	if err != nil {
+		return err
+	}
+	var callErr error
+	if opts.Export != "" {
+		_, callErr = library.Call(opts.Export, opts.Args...)
+	}
+	if err := library.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	return callErr
+}
+
+// measure collects garbage, returns freed memory to the OS, and measures the
+// process.
+func measure() (Usage, error) {
+	runtime.GC()
+	debug.FreeOSMemory()
+	usage, err := processUsage()
+	if err != nil {
+		return Usage{}, fmt.Errorf("loadertest: measure process: %w", err)
+	}
+	usage.MappedBytes = reflektor.MappedBytes()
+	return usage, nil
+}
+
+// Check returns an error wrapping ErrLeak that lists every measurement that
+// grew past limits over the run. Measurements not taken on this platform
+// are not checked.
+func (report *Report) Check(limits Limits) error {
+	var leaks []string
+	if report.Before.RSS != 0 && report.After.RSS > report.Before.RSS+limits.RSSGrowth {
+		leaks = append(leaks, fmt.Sprintf("RSS grew by %d bytes", report.After.RSS-report.Before.RSS))
+	}
+	if report.Before.Handles != 0 && report.After.Handles > report.Before.Handles+limits.HandleGrowth {
+		leaks = append(leaks, fmt.Sprintf("handles grew by %d", report.After.Handles-report.Before.Handles))
+	}
+	if report.Before.Threads != 0 && report.After.Threads > report.Before.Threads+limits.ThreadGrowth {
+		leaks = append(leaks, fmt.Sprintf("threads grew by %d", report.After.Threads-report.Before.Threads))
+	}
+	if report.After.MappedBytes > report.Before.MappedBytes+limits.MappedGrowth {
+		leaks = append(leaks, fmt.Sprintf("mapped images grew by %d bytes", report.After.MappedBytes-report.Before.MappedBytes))
+	}
+	if len(leaks) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w over %d cycles: %s", ErrLeak, report.Iterations, strings.Join(leaks, ", "))
+}
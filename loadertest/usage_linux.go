@@ -0,0 +1,52 @@
+package loadertest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// processUsage reads the process's RSS from /proc/self/statm, its thread
+// count from /proc/self/status, and its descriptors from /proc/self/fd.
+func processUsage() (Usage, error) {
+	rss, err := residentBytes()
+	if err != nil {
+		return Usage{}, err
+	}
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return Usage{}, err
+	}
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return Usage{}, err
+	}
+	threads := 0
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		if value, ok := bytes.CutPrefix(line, []byte("Threads:")); ok {
+			threads, err = strconv.Atoi(string(bytes.TrimSpace(value)))
+			if err != nil {
+				return Usage{}, fmt.Errorf("parse /proc/self/status: %w", err)
+			}
+		}
+	}
+	// Reading the directory held one descriptor open.
+	return Usage{RSS: rss, Handles: len(fds) - 1, Threads: threads}, nil
+}
+
+func residentBytes() (uint64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := bytes.Fields(statm)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("parse /proc/self/statm: %q", statm)
+	}
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse /proc/self/statm: %w", err)
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package loadertest
+
+import "errors"
+
+// processUsage measures nothing: only reflektor.MappedBytes is tracked here.
+func processUsage() (Usage, error) {
+	return Usage{}, nil
+}
+
+func residentBytes() (uint64, error) {
+	return 0, errors.New("RSS is not measured on this platform")
+}
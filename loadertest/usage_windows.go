@@ -0,0 +1,72 @@
+package loadertest
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                    = windows.NewLazySystemDLL("kernel32.dll")
+	procK32GetProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessHandleCount   = kernel32.NewProc("GetProcessHandleCount")
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// processUsage reports the working set as RSS, the process's handle count,
+// and its threads from a toolhelp snapshot.
+func processUsage() (Usage, error) {
+	rss, err := residentBytes()
+	if err != nil {
+		return Usage{}, err
+	}
+	var handles uint32
+	if r1, _, e1 := procGetProcessHandleCount.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&handles))); r1 == 0 {
+		return Usage{}, fmt.Errorf("GetProcessHandleCount: %w", e1)
+	}
+	threads, err := threadCount()
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{RSS: rss, Handles: int(handles), Threads: threads}, nil
+}
+
+func residentBytes() (uint64, error) {
+	counters := processMemoryCounters{Cb: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	if r1, _, e1 := procK32GetProcessMemoryInfo.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Cb)); r1 == 0 {
+		return 0, fmt.Errorf("GetProcessMemoryInfo: %w", e1)
+	}
+	return uint64(counters.WorkingSetSize), nil
+}
+
+func threadCount() (int, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return 0, fmt.Errorf("CreateToolhelp32Snapshot: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	pid := windows.GetCurrentProcessId()
+	count := 0
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID == pid {
+			count++
+		}
+	}
+	return count, nil
+}
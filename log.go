@@ -0,0 +1,21 @@
+package reflektor
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// currentLogger is the process's load logger, installed by SetLogger.
+var currentLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sends a debug-level record to logger, from now on, as every
+// native load in the process reaches each stage: unpacking, picking a fat
+// Mach-O slice, mapping, relocation, loading dependencies, and running
+// initializers. Each record has a "stage" attribute naming the stage (see
+// memmod.LoadOptions.Logger) and the stage's details, such as the base
+// address or the number of relocations applied, so a load that fails on a
+// locked-down host can be followed without a rebuild. A nil logger stops
+// logging.
+func SetLogger(logger *slog.Logger) {
+	currentLogger.Store(logger)
+}
@@ -0,0 +1,201 @@
+// Package luamod runs Lua script payloads in-process. It is the fallback
+// backend for hosts where mapping native code is impossible: a script's
+// global functions play the role of exports.
+package luamod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// HostAPIName is the global table through which scripts reach the host.
+const HostAPIName = "reflektor"
+
+// Module is a loaded Lua script. Lua states are not thread-safe, so calls are
+// serialized.
+type Module struct {
+	mu     sync.Mutex
+	state  *lua.LState
+	stderr io.Writer
+}
+
+// LoadScript compiles and runs the top-level chunk of a Lua script, the
+// script's equivalent of library constructors. Only the base, table, string,
+// and math libraries are opened, plus the host API table:
+//
+//	reflektor.os, reflektor.arch     GOOS and GOARCH
+//	reflektor.log(...)               write a line to stderr
+//	reflektor.getenv(name)           string or nil
+//	reflektor.sleep(ms)              pause the calling thread
+//	reflektor.read_file(path)        string, or nil and an error message
+//	reflektor.write_file(path, data) true, or nil and an error message
+//
+// A first line starting with '#', such as a shebang, is skipped as the lua
+// interpreter skips it.
+func LoadScript(source []byte) (*Module, error) {
+	if len(source) == 0 {
+		return nil, errors.New("empty script")
+	}
+
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := state.CallByParam(lua.P{Fn: state.NewFunction(lib.open), Protect: true}, lua.LString(lib.name)); err != nil {
+			state.Close()
+			return nil, fmt.Errorf("open lua library %s: %w", lib.name, err)
+		}
+	}
+
+	module := &Module{state: state, stderr: os.Stderr}
+	state.SetGlobal(HostAPIName, module.hostAPI())
+	chunk := string(source)
+	if chunk[0] == '#' {
+		// Keep the newline so error messages report the right line numbers.
+		if i := strings.IndexByte(chunk, '\n'); i >= 0 {
+			chunk = chunk[i:]
+		} else {
+			chunk = ""
+		}
+	}
+	if err := state.DoString(chunk); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("run script: %w", err)
+	}
+	return module, nil
+}
+
+// Free closes the Lua state. Calls made afterwards fail.
+func (module *Module) Free() {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.state != nil {
+		module.state.Close()
+		module.state = nil
+	}
+}
+
+// Call calls a global function, passing args as Lua numbers, and converts its
+// first return value to an integer: numbers are truncated, true is 1, and nil
+// or false is 0.
+func (module *Module) Call(name string, args ...uintptr) (uintptr, error) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.state == nil {
+		return 0, errors.New("script is closed")
+	}
+	fn, ok := module.state.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return 0, fmt.Errorf("script function %q not found", name)
+	}
+	params := make([]lua.LValue, len(args))
+	for i, arg := range args {
+		params[i] = lua.LNumber(int64(arg))
+	}
+	if err := module.state.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, params...); err != nil {
+		return 0, err
+	}
+	ret := module.state.Get(-1)
+	module.state.Pop(1)
+
+	switch v := ret.(type) {
+	case lua.LNumber:
+		return uintptr(int64(v)), nil
+	case lua.LBool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case *lua.LNilType:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("script function %q returned unsupported %s value", name, ret.Type())
+	}
+}
+
+// Exports returns the sorted names of the global functions the script
+// defines; library and host API functions are not included.
+func (module *Module) Exports() ([]string, error) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.state == nil {
+		return nil, errors.New("script is closed")
+	}
+	var names []string
+	module.state.G.Global.ForEach(func(key, value lua.LValue) {
+		name, ok := key.(lua.LString)
+		if fn, isFn := value.(*lua.LFunction); ok && isFn && !fn.IsG {
+			names = append(names, string(name))
+		}
+	})
+	sort.Strings(names)
+	return names, nil
+}
+
+func (module *Module) hostAPI() *lua.LTable {
+	state := module.state
+	api := state.NewTable()
+	api.RawSetString("os", lua.LString(runtime.GOOS))
+	api.RawSetString("arch", lua.LString(runtime.GOARCH))
+	state.SetFuncs(api, map[string]lua.LGFunction{
+		"log": func(L *lua.LState) int {
+			parts := make([]string, L.GetTop())
+			for i := range parts {
+				parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+			}
+			fmt.Fprintln(module.stderr, strings.Join(parts, "\t"))
+			return 0
+		},
+		"getenv": func(L *lua.LState) int {
+			value, ok := os.LookupEnv(L.CheckString(1))
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(lua.LString(value))
+			return 1
+		},
+		"sleep": func(L *lua.LState) int {
+			time.Sleep(time.Duration(L.CheckInt64(1)) * time.Millisecond)
+			return 0
+		},
+		"read_file": func(L *lua.LState) int {
+			data, err := os.ReadFile(L.CheckString(1))
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(data))
+			return 1
+		},
+		"write_file": func(L *lua.LState) int {
+			if err := os.WriteFile(L.CheckString(1), []byte(L.CheckString(2)), 0o600); err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LTrue)
+			return 1
+		},
+	})
+	return api
+}
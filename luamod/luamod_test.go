@@ -0,0 +1,59 @@
+package luamod
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadScriptAndCall(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("..", "testdata", "lua", "basic.lua"))
+	if err != nil {
+		t.Fatalf("read script: %v", err)
+	}
+	markerPath := filepath.Join(t.TempDir(), "marker.txt")
+	t.Setenv("REFLEKTOR_MARKER", markerPath)
+
+	module, err := LoadScript(source)
+	if err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	exports, err := module.Exports()
+	if err != nil {
+		t.Fatalf("Exports: %v", err)
+	}
+	if got := strings.Join(exports, ","); got != "Add,Answer,Fail,StartW" {
+		t.Fatalf("unexpected exports: %s", got)
+	}
+
+	if value, err := module.Call("StartW"); err != nil || value != 0 {
+		t.Fatalf("Call(StartW) = %d, %v", value, err)
+	}
+	got, err := os.ReadFile(markerPath)
+	if err != nil {
+		t.Fatalf("read marker: %v", err)
+	}
+	if string(got) != "ok" {
+		t.Fatalf("unexpected marker content: got=%q want=%q", got, "ok")
+	}
+
+	if value, err := module.Call("Answer"); err != nil || value != 42 {
+		t.Fatalf("Call(Answer) = %d, %v", value, err)
+	}
+	if value, err := module.Call("Add", 40, 2); err != nil || value != 42 {
+		t.Fatalf("Call(Add, 40, 2) = %d, %v", value, err)
+	}
+	if _, err := module.Call("Fail"); err == nil || !strings.Contains(err.Error(), "payload failure") {
+		t.Fatalf("Call(Fail) error = %v", err)
+	}
+	if _, err := module.Call("Missing"); err == nil {
+		t.Fatal("Call(Missing) succeeded")
+	}
+
+	module.Free()
+	if _, err := module.Call("Answer"); err == nil {
+		t.Fatal("Call after Free succeeded")
+	}
+}
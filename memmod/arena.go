@@ -0,0 +1,171 @@
+package memmod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// arenaChunkSize is the size of the mappings an arena allocates from.
+	// Larger allocations get a mapping of their own.
+	arenaChunkSize = 64 << 10
+	// maxPooledArenas caps how many released arenas are kept for reuse.
+	maxPooledArenas = 8
+)
+
+// nativeArena hands out memory outside the Go heap for the buffers whose
+// addresses are passed to native code: C strings, option structs, argument
+// blocks. The collector never moves or frees it, so native code may use an
+// address for as long as the arena lives, without runtime.KeepAlive on the
+// Go side. Memory comes back zeroed. An arena is not safe for concurrent use;
+// take one with getArena and give it back with release when the native code
+// is done with its buffers.
+type nativeArena struct {
+	chunks [][]byte
+	// used is how much of the last chunk is allocated.
+	used uintptr
+}
+
+var arenaPool struct {
+	mu   sync.Mutex
+	free []*nativeArena
+}
+
+// getArena returns an empty arena, reusing a released one when it can.
+func getArena() *nativeArena {
+	arenaPool.mu.Lock()
+	defer arenaPool.mu.Unlock()
+	if n := len(arenaPool.free); n != 0 {
+		arena := arenaPool.free[n-1]
+		arenaPool.free = arenaPool.free[:n-1]
+		return arena
+	}
+	return &nativeArena{}
+}
+
+// release frees everything allocated from the arena. The arena keeps its
+// first chunk, cleared, and goes back to the pool; the caller must not use
+// it or any of its memory again.
+func (arena *nativeArena) release() {
+	keep := 0
+	if len(arena.chunks) != 0 && len(arena.chunks[0]) == arenaChunkSize {
+		keep = 1
+		if len(arena.chunks) == 1 {
+			clear(arena.chunks[0][:arena.used])
+		} else {
+			clear(arena.chunks[0])
+		}
+	}
+	for _, chunk := range arena.chunks[keep:] {
+		unmapArenaChunk(chunk)
+	}
+	arena.chunks = arena.chunks[:keep]
+	arena.used = 0
+
+	arenaPool.mu.Lock()
+	defer arenaPool.mu.Unlock()
+	if len(arenaPool.free) < maxPooledArenas {
+		arenaPool.free = append(arenaPool.free, arena)
+		return
+	}
+	for _, chunk := range arena.chunks {
+		unmapArenaChunk(chunk)
+	}
+	arena.chunks = nil
+}
+
+// alloc returns size zeroed bytes aligned to align, which must be a power of
+// two no larger than the page size.
+func (arena *nativeArena) alloc(size, align uintptr) (uintptr, error) {
+	if align == 0 || align&(align-1) != 0 || align > uintptr(os.Getpagesize()) {
+		return 0, fmt.Errorf("invalid arena alignment %d", align)
+	}
+	if size == 0 {
+		size = 1
+	}
+	if n := len(arena.chunks); n != 0 {
+		chunk := arena.chunks[n-1]
+		base := uintptr(unsafe.Pointer(&chunk[0]))
+		offset := (base+arena.used+align-1)&^(align-1) - base
+		if offset <= uintptr(len(chunk)) && size <= uintptr(len(chunk))-offset {
+			arena.used = offset + size
+			return base + offset, nil
+		}
+	}
+	chunkSize := uintptr(arenaChunkSize)
+	if size > chunkSize {
+		page := uintptr(os.Getpagesize())
+		chunkSize = (size + page - 1) &^ (page - 1)
+	}
+	chunk, err := mapArenaChunk(chunkSize)
+	if err != nil {
+		return 0, fmt.Errorf("map arena chunk: %w", err)
+	}
+	arena.chunks = append(arena.chunks, chunk)
+	arena.used = size
+	return uintptr(unsafe.Pointer(&chunk[0])), nil
+}
+
+// cString returns s as a NUL-terminated C string in the arena.
+func (arena *nativeArena) cString(s string) (uintptr, error) {
+	if strings.ContainsRune(s, '\x00') {
+		return 0, errors.New("string contains NUL")
+	}
+	addr, err := arena.alloc(uintptr(len(s))+1, 1)
+	if err != nil {
+		return 0, err
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(addr)), len(s)), s)
+	return addr, nil
+}
+
+// Arena is memory outside the Go heap for buffers an embedder passes to
+// exports, such as string arguments: native code may keep using an address
+// until Release, and the collector never moves or frees it. Like the arenas
+// the loader uses internally, it is not safe for concurrent use.
+type Arena struct {
+	arena *nativeArena
+}
+
+// NewArena returns an empty arena. Release it once the exports given its
+// buffers are done with them.
+func NewArena() *Arena {
+	return &Arena{arena: getArena()}
+}
+
+// CString copies s into the arena as a NUL-terminated C string and returns
+// its address.
+func (arena *Arena) CString(s string) (uintptr, error) {
+	if arena.arena == nil {
+		return 0, errors.New("arena has been released")
+	}
+	return arena.arena.cString(s)
+}
+
+// Bytes copies data into the arena at an address aligned to align, which
+// must be a power of two no larger than the page size, and returns the
+// address.
+func (arena *Arena) Bytes(data []byte, align uintptr) (uintptr, error) {
+	if arena.arena == nil {
+		return 0, errors.New("arena has been released")
+	}
+	addr, err := arena.arena.alloc(uintptr(len(data)), align)
+	if err != nil {
+		return 0, err
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(addr)), len(data)), data)
+	return addr, nil
+}
+
+// Release frees everything allocated from the arena. Addresses it handed out
+// must not be used again. Releasing it twice does nothing.
+func (arena *Arena) Release() {
+	if arena.arena != nil {
+		arena.arena.release()
+		arena.arena = nil
+	}
+}
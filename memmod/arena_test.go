@@ -0,0 +1,79 @@
+//go:build (linux && (386 || amd64 || arm64)) || (darwin && (amd64 || arm64))
+
+package memmod
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestNativeArena(t *testing.T) {
+	arena := getArena()
+	str, err := arena.cString("reflektor")
+	if err != nil {
+		t.Fatalf("cString: %v", err)
+	}
+	if got := unsafe.Slice((*byte)(unsafe.Pointer(str)), 10); string(got) != "reflektor\x00" {
+		t.Fatalf("cString(%q) wrote %q", "reflektor", got)
+	}
+	if _, err := arena.cString("a\x00b"); err == nil {
+		t.Fatal("cString accepted a string containing NUL")
+	}
+
+	aligned, err := arena.alloc(24, 16)
+	if err != nil {
+		t.Fatalf("alloc: %v", err)
+	}
+	if aligned%16 != 0 {
+		t.Fatalf("alloc(24, 16) = %#x, not 16-byte aligned", aligned)
+	}
+	large, err := arena.alloc(arenaChunkSize+1, 8)
+	if err != nil {
+		t.Fatalf("alloc larger than a chunk: %v", err)
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(large)), arenaChunkSize+1)
+	buf[0], buf[len(buf)-1] = 1, 1
+	if _, err := arena.alloc(8, 3); err == nil {
+		t.Fatal("alloc accepted an alignment that is not a power of two")
+	}
+
+	// Memory handed out again after a release comes back zeroed.
+	*(*uint64)(unsafe.Pointer(aligned)) = ^uint64(0)
+	arena.release()
+	arena = getArena()
+	defer arena.release()
+	for i := 0; i < 4; i++ {
+		addr, err := arena.alloc(64, 16)
+		if err != nil {
+			t.Fatalf("alloc after release: %v", err)
+		}
+		for _, b := range unsafe.Slice((*byte)(unsafe.Pointer(addr)), 64) {
+			if b != 0 {
+				t.Fatalf("alloc after release returned memory that is not zeroed at %#x", addr)
+			}
+		}
+	}
+}
+
+func TestArena(t *testing.T) {
+	arena := NewArena()
+	str, err := arena.CString("hello")
+	if err != nil {
+		t.Fatalf("CString: %v", err)
+	}
+	if got := unsafe.Slice((*byte)(unsafe.Pointer(str)), 6); string(got) != "hello\x00" {
+		t.Fatalf("CString(%q) wrote %q", "hello", got)
+	}
+	wide, err := arena.Bytes([]byte{'h', 0, 'i', 0, 0, 0}, 2)
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	if wide%2 != 0 || string(unsafe.Slice((*byte)(unsafe.Pointer(wide)), 6)) != "h\x00i\x00\x00\x00" {
+		t.Fatalf("Bytes wrote %q at %#x", unsafe.Slice((*byte)(unsafe.Pointer(wide)), 6), wide)
+	}
+	arena.Release()
+	arena.Release()
+	if _, err := arena.CString("again"); err == nil {
+		t.Fatal("CString succeeded after Release")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build (linux && (386 || amd64 || arm64)) || (darwin && (amd64 || arm64))
+
+package memmod
+
+import "golang.org/x/sys/unix"
+
+// mapArenaChunk maps size bytes of private, anonymous read-write memory.
+func mapArenaChunk(size uintptr) ([]byte, error) {
+	return unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+}
+
+func unmapArenaChunk(chunk []byte) {
+	_ = unix.Munmap(chunk)
+}
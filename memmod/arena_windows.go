@@ -0,0 +1,22 @@
+//go:build windows
+
+package memmod
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mapArenaChunk commits size bytes of read-write memory.
+func mapArenaChunk(size uintptr) ([]byte, error) {
+	addr, err := windows.VirtualAlloc(0, size, windows.MEM_RESERVE|windows.MEM_COMMIT, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*byte)(a2p(addr)), size), nil
+}
+
+func unmapArenaChunk(chunk []byte) {
+	_ = windows.VirtualFree(uintptr(unsafe.Pointer(&chunk[0])), 0, windows.MEM_RELEASE)
+}
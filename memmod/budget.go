@@ -0,0 +1,55 @@
+package memmod
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrImageTooLarge is returned when an image's address span exceeds
+	// LoadOptions.MaxImageSize.
+	ErrImageTooLarge = errors.New("image exceeds maximum image size")
+	// ErrMappingBudget is returned when mapping an image would take the
+	// address space held by all loaded images past
+	// LoadOptions.MaxTotalMappedBytes.
+	ErrMappingBudget = errors.New("image exceeds mapping budget")
+)
+
+// mappedBytes tracks the address space held by every image this package has
+// mapped and not yet released, across all modules in the process.
+var mappedBytes struct {
+	sync.Mutex
+	total uint64
+}
+
+// MappedBytes returns the address space currently held by images loaded
+// through this package and not yet freed.
+func MappedBytes() uint64 {
+	mappedBytes.Lock()
+	defer mappedBytes.Unlock()
+	return mappedBytes.total
+}
+
+// reserveMapping charges size bytes of address space against the limits in
+// opts. Every successful reservation must be undone with releaseMapping once
+// the mapping is gone.
+func reserveMapping(size uint64, opts LoadOptions) error {
+	if opts.MaxImageSize != 0 && size > opts.MaxImageSize {
+		return fmt.Errorf("%w: span %#x, limit %#x", ErrImageTooLarge, size, opts.MaxImageSize)
+	}
+
+	mappedBytes.Lock()
+	defer mappedBytes.Unlock()
+	if opts.MaxTotalMappedBytes != 0 && (size > opts.MaxTotalMappedBytes || mappedBytes.total > opts.MaxTotalMappedBytes-size) {
+		return fmt.Errorf("%w: span %#x with %#x already mapped, limit %#x", ErrMappingBudget, size, mappedBytes.total, opts.MaxTotalMappedBytes)
+	}
+	mappedBytes.total += size
+	return nil
+}
+
+func releaseMapping(size uint64) {
+	mappedBytes.Lock()
+	defer mappedBytes.Unlock()
+	mappedBytes.total -= size
+}
@@ -0,0 +1,31 @@
+package memmod
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReserveMapping(t *testing.T) {
+	before := MappedBytes()
+	if err := reserveMapping(0x3000, LoadOptions{MaxImageSize: 0x2000}); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("reserveMapping over MaxImageSize = %v, want ErrImageTooLarge", err)
+	}
+
+	limit := before + 0x3000
+	if err := reserveMapping(0x2000, LoadOptions{MaxTotalMappedBytes: limit}); err != nil {
+		t.Fatalf("reserveMapping within budget: %v", err)
+	}
+	if err := reserveMapping(0x2000, LoadOptions{MaxTotalMappedBytes: limit}); !errors.Is(err, ErrMappingBudget) {
+		t.Fatalf("reserveMapping over budget = %v, want ErrMappingBudget", err)
+	}
+	if err := reserveMapping(^uint64(0), LoadOptions{MaxTotalMappedBytes: limit}); !errors.Is(err, ErrMappingBudget) {
+		t.Fatalf("reserveMapping with overflowing size = %v, want ErrMappingBudget", err)
+	}
+	if err := reserveMapping(0x1000, LoadOptions{MaxTotalMappedBytes: limit}); err != nil {
+		t.Fatalf("reserveMapping up to the budget: %v", err)
+	}
+	releaseMapping(0x3000)
+	if got := MappedBytes(); got != before {
+		t.Fatalf("MappedBytes after release = %#x, want %#x", got, before)
+	}
+}
@@ -0,0 +1,61 @@
+package memmod
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// CallResult is the outcome of a native export call.
+type CallResult struct {
+	// Value is the raw integer return register. Callees returning narrower
+	// types leave the upper bits undefined.
+	Value uintptr
+	// Errno is the error state the callee left behind, captured on the calling
+	// thread immediately after it returns: errno on unix (cleared before the
+	// call) and GetLastError on windows. It is zero when the platform cannot
+	// observe it.
+	Errno syscall.Errno
+	// Exception is the code of the exception that ended the call, for
+	// windows native threads started with ThreadOptions.CatchExceptions.
+	// It is zero when the export returned.
+	Exception uint32
+	// ThreadID is the operating system id of the thread the call ran on:
+	// the tid on linux, the mach thread id on darwin, and the thread id on
+	// windows.
+	ThreadID uint64
+	// Duration is how long the call ran, wall clock. For native threads it
+	// spans the thread, not just the export.
+	Duration time.Duration
+}
+
+// MaxCallArgs is the largest number of integer or pointer arguments
+// CallExportArgs passes to an export. Six fits in registers on every
+// supported 64-bit ABI except windows x64, where SyscallN spills the rest.
+const MaxCallArgs = 6
+
+// measureCall runs call with the goroutine locked to its thread and records
+// the thread and how long the call took.
+func measureCall(call func() CallResult) CallResult {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	id := currentThreadID()
+	start := time.Now()
+	result := call()
+	result.Duration = time.Since(start)
+	result.ThreadID = id
+	return result
+}
+
+// callArgs pads args to MaxCallArgs. Unused slots are zero, which callees that
+// take fewer parameters ignore.
+func callArgs(args []uintptr) ([MaxCallArgs]uintptr, error) {
+	var padded [MaxCallArgs]uintptr
+	if len(args) > MaxCallArgs {
+		return padded, fmt.Errorf("too many arguments: %d (max %d)", len(args), MaxCallArgs)
+	}
+	copy(padded[:], args)
+	return padded, nil
+}
@@ -0,0 +1,94 @@
+package memmod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var errCodeFreed = errors.New("code has been freed")
+
+// CodeOptions tunes LoadCode. The zero value runs the code from its first
+// byte.
+type CodeOptions struct {
+	// Entry is the offset of the entry point from the start of the code.
+	Entry uintptr
+
+	// MaxTotalMappedBytes, when non-zero, rejects the code with
+	// ErrMappingBudget if mapping it would take MappedBytes past this many
+	// bytes, as LoadOptions.MaxTotalMappedBytes does for images.
+	MaxTotalMappedBytes uint64
+}
+
+// Code is a blob of position-independent code mapped by LoadCode. Unlike an
+// image it has no headers, relocations, or imports: its bytes are copied as
+// they are into memory that is then made read-execute.
+type Code struct {
+	mu    sync.RWMutex
+	base  uintptr
+	size  uintptr
+	entry uintptr
+	freed bool
+}
+
+// LoadCode maps data as position-independent code.
+func LoadCode(data []byte, opts CodeOptions) (*Code, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty code")
+	}
+	if opts.Entry >= uintptr(len(data)) {
+		return nil, fmt.Errorf("entry offset %#x is outside the %#x bytes of code", opts.Entry, len(data))
+	}
+	page := uintptr(os.Getpagesize())
+	size := (uintptr(len(data)) + page - 1) &^ (page - 1)
+	if err := reserveMapping(uint64(size), LoadOptions{MaxTotalMappedBytes: opts.MaxTotalMappedBytes}); err != nil {
+		return nil, err
+	}
+	base, err := mapCode(data, size)
+	if err != nil {
+		releaseMapping(uint64(size))
+		return nil, err
+	}
+	return &Code{base: base, size: size, entry: base + opts.Entry}, nil
+}
+
+// Base returns the address the code was mapped at.
+func (code *Code) Base() uintptr {
+	return code.base
+}
+
+// Size returns the number of bytes mapped for the code, rounded up to whole
+// pages.
+func (code *Code) Size() uintptr {
+	return code.size
+}
+
+// Entry returns the address Call jumps to.
+func (code *Code) Entry() uintptr {
+	return code.entry
+}
+
+// Call calls the code's entry point on the calling thread, with arg as its
+// only integer or pointer argument, and returns its raw return value and
+// errno. Free waits for calls in progress.
+func (code *Code) Call(arg uintptr) (CallResult, error) {
+	code.mu.RLock()
+	defer code.mu.RUnlock()
+	if code.freed {
+		return CallResult{}, errCodeFreed
+	}
+	return callCode(code.entry, arg), nil
+}
+
+// Free unmaps the code. Freeing it again does nothing.
+func (code *Code) Free() {
+	code.mu.Lock()
+	defer code.mu.Unlock()
+	if code.freed {
+		return
+	}
+	code.freed = true
+	unmapCode(code.base, code.size)
+	releaseMapping(uint64(code.size))
+}
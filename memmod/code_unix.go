@@ -0,0 +1,30 @@
+//go:build (linux && (386 || amd64 || arm64)) || (darwin && (amd64 || arm64))
+
+package memmod
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapCode copies data into a fresh anonymous mapping of size bytes and makes
+// it read-execute.
+func mapCode(data []byte, size uintptr) (uintptr, error) {
+	addr, err := unix.MmapPtr(-1, 0, nil, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return 0, fmt.Errorf("map code: %w", err)
+	}
+	mapping := unsafe.Slice((*byte)(addr), size)
+	copy(mapping, data)
+	if err := unix.Mprotect(mapping, unix.PROT_READ|unix.PROT_EXEC); err != nil {
+		_ = unix.MunmapPtr(addr, size)
+		return 0, fmt.Errorf("protect code: %w", err)
+	}
+	return uintptr(addr), nil
+}
+
+func unmapCode(base, size uintptr) {
+	_ = unix.MunmapPtr(unsafe.Pointer(base), size)
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package memmod
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mapCode copies data into fresh committed memory of size bytes and makes it
+// read-execute.
+func mapCode(data []byte, size uintptr) (uintptr, error) {
+	addr, err := windows.VirtualAlloc(0, size, windows.MEM_RESERVE|windows.MEM_COMMIT, windows.PAGE_READWRITE)
+	if err != nil {
+		return 0, fmt.Errorf("allocate code: %w", err)
+	}
+	copy(unsafe.Slice((*byte)(a2p(addr)), size), data)
+	var old uint32
+	if err := windows.VirtualProtect(addr, size, windows.PAGE_EXECUTE_READ, &old); err != nil {
+		_ = windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+		return 0, fmt.Errorf("protect code: %w", err)
+	}
+	return addr, nil
+}
+
+func unmapCode(base, size uintptr) {
+	_ = windows.VirtualFree(base, 0, windows.MEM_RELEASE)
+}
+
+// callCode calls fn with arg on the calling thread and returns its raw
+// return value and GetLastError.
+func callCode(fn, arg uintptr) CallResult {
+	return measureCall(func() CallResult {
+		value, _, lastErr := syscall.SyscallN(fn, arg)
+		return CallResult{Value: value, Errno: lastErr}
+	})
+}
@@ -0,0 +1,25 @@
+package memmod
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sliverarmory/reflektor/compress"
+)
+
+// depackImage unpacks a packed image (AP32, zstd, XZ, or LZMA) so every loader
+// accepts the same packed payloads, and returns any other image unchanged.
+// MaxImageSize, when set, also bounds the unpacked size.
+func depackImage(data []byte, opts LoadOptions) ([]byte, error) {
+	image, err := compress.Unpack(data, opts.MaxImageSize)
+	if errors.Is(err, compress.ErrSizeLimit) {
+		return nil, fmt.Errorf("%w: %w", ErrImageTooLarge, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unpack image: %w", err)
+	}
+	if len(image) != len(data) {
+		trace(opts.Logger, stageUnpack, "unpacked image", "packed", len(data), "size", len(image))
+	}
+	return image, nil
+}
@@ -0,0 +1,87 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// ImportedLibraries lists the libraries an ELF, PE, or Mach-O image depends
+// on, in the order the image names them: DT_NEEDED entries, the DLLs of the
+// PE import directory, or LC_LOAD_DYLIB and related commands. Names are
+// returned as the image spells them. For fat Mach-O files the slice for the
+// current architecture is used when present, otherwise the first slice.
+// Packed images are unpacked first.
+func ImportedLibraries(data []byte) ([]string, error) {
+	data, err := depackImage(data, LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse ELF image: %w", err)
+		}
+		defer f.Close()
+		return elfImportedLibraries(f)
+	case bytes.HasPrefix(data, []byte("MZ")):
+		f, err := pe.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse PE image: %w", err)
+		}
+		defer f.Close()
+		return peImportedLibraries(f)
+	}
+	if fat, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
+		defer fat.Close()
+		if len(fat.Arches) == 0 {
+			return nil, errors.New("fat Mach-O image has no slices")
+		}
+		arch := fat.Arches[0]
+		for _, candidate := range fat.Arches {
+			if machOArch(candidate.Cpu) == runtime.GOARCH {
+				arch = candidate
+				break
+			}
+		}
+		return arch.File.ImportedLibraries()
+	}
+	if f, err := macho.NewFile(bytes.NewReader(data)); err == nil {
+		defer f.Close()
+		return f.ImportedLibraries()
+	}
+	return nil, errors.New("unrecognized image format")
+}
+
+func elfImportedLibraries(f *elf.File) ([]string, error) {
+	libs, err := f.ImportedLibraries()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("read ELF dependencies: %w", err)
+	}
+	return libs, nil
+}
+
+func peImportedLibraries(f *pe.File) ([]string, error) {
+	// debug/pe reports imports as "symbol:dll" and leaves ImportedLibraries
+	// unimplemented.
+	symbols, err := f.ImportedSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("read PE imports: %w", err)
+	}
+	var libs []string
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		_, dll, ok := strings.Cut(symbol, ":")
+		if ok && !seen[strings.ToLower(dll)] {
+			seen[strings.ToLower(dll)] = true
+			libs = append(libs, dll)
+		}
+	}
+	return libs, nil
+}
@@ -0,0 +1,177 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// EntryPointKind says where an EntryPoint was found.
+type EntryPointKind string
+
+const (
+	// EntryPointImage is the optional header's AddressOfEntryPoint, the
+	// DllMain of a DLL.
+	EntryPointImage EntryPointKind = "entry"
+	// EntryPointTLSCallback is a TLS callback, which the loader runs before
+	// the image entry point.
+	EntryPointTLSCallback EntryPointKind = "tls-callback"
+	// EntryPointOrdinal is an export with an ordinal and no name. Reflective
+	// loaders conventionally export theirs as ordinal 1.
+	EntryPointOrdinal EntryPointKind = "ordinal"
+)
+
+// maxTLSCallbacks bounds the TLS callback walk in case the array is not
+// terminated.
+const maxTLSCallbacks = 64
+
+// EntryPoint is a callable address in a PE image found without relying on
+// named exports.
+type EntryPoint struct {
+	Kind EntryPointKind
+	// Address is the entry point's RVA.
+	Address uint64
+	// Ordinal is the export ordinal for EntryPointOrdinal.
+	Ordinal uint16
+	// Unwind is set when Address starts a function listed in the exception
+	// directory (.pdata), which confirms it is code on amd64 and arm64
+	// images. 386 images have no such directory.
+	Unwind bool
+}
+
+// PEEntryPoints lists the entry points of a PE image that remain callable
+// when its export table is stripped or has no names: the image entry point,
+// TLS callbacks, and ordinal-only exports. It is a best-effort aid for
+// deciding what to call in a stripped payload; an address being listed does
+// not mean it is safe to call with no arguments.
+func PEEntryPoints(data []byte) ([]EntryPoint, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse PE image: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		entry     uint32
+		imageBase uint64
+		dirs      []pe.DataDirectory
+		ptrSize   uint32
+	)
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		entry, imageBase, ptrSize = header.AddressOfEntryPoint, uint64(header.ImageBase), 4
+		dirs = header.DataDirectory[:min(header.NumberOfRvaAndSizes, uint32(len(header.DataDirectory)))]
+	case *pe.OptionalHeader64:
+		entry, imageBase, ptrSize = header.AddressOfEntryPoint, header.ImageBase, 8
+		dirs = header.DataDirectory[:min(header.NumberOfRvaAndSizes, uint32(len(header.DataDirectory)))]
+	default:
+		return nil, errors.New("PE image has no optional header")
+	}
+	dir := func(index int) pe.DataDirectory {
+		if index < len(dirs) {
+			return dirs[index]
+		}
+		return pe.DataDirectory{}
+	}
+
+	var points []EntryPoint
+	if entry != 0 {
+		points = append(points, EntryPoint{Kind: EntryPointImage, Address: uint64(entry)})
+	}
+
+	if tls := dir(pe.IMAGE_DIRECTORY_ENTRY_TLS); tls.VirtualAddress != 0 {
+		// AddressOfCallBacks is the fourth pointer-sized field of
+		// IMAGE_TLS_DIRECTORY and, like the callbacks, a VA.
+		raw, err := readPERVA(f, tls.VirtualAddress+3*ptrSize, ptrSize)
+		if err != nil {
+			return nil, fmt.Errorf("read PE TLS directory: %w", err)
+		}
+		if va := readPEPointer(raw, ptrSize); va > imageBase {
+			slot := uint32(va - imageBase)
+			for i := uint32(0); i < maxTLSCallbacks; i++ {
+				raw, err := readPERVA(f, slot+i*ptrSize, ptrSize)
+				if err != nil {
+					return nil, fmt.Errorf("read PE TLS callbacks: %w", err)
+				}
+				callback := readPEPointer(raw, ptrSize)
+				if callback <= imageBase {
+					break
+				}
+				points = append(points, EntryPoint{Kind: EntryPointTLSCallback, Address: callback - imageBase})
+			}
+		}
+	}
+
+	if exportDir := dir(pe.IMAGE_DIRECTORY_ENTRY_EXPORT); exportDir.VirtualAddress != 0 && exportDir.Size != 0 {
+		exports, err := readPEExports(f, exportDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, export := range exports {
+			if export.Name == "" && export.Forwarder == "" {
+				points = append(points, EntryPoint{Kind: EntryPointOrdinal, Address: export.Address, Ordinal: export.Ordinal})
+			}
+		}
+	}
+
+	starts, err := peFunctionStarts(f, dir(pe.IMAGE_DIRECTORY_ENTRY_EXCEPTION))
+	if err != nil {
+		return nil, err
+	}
+	for i := range points {
+		points[i].Unwind = starts[uint32(points[i].Address)]
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Address < points[j].Address })
+	return points, nil
+}
+
+// peFunctionStarts returns the begin RVAs of the RUNTIME_FUNCTION entries in
+// the exception directory of an amd64 or arm64 image.
+func peFunctionStarts(f *pe.File, dir pe.DataDirectory) (map[uint32]bool, error) {
+	var entrySize uint32
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		entrySize = 12
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		entrySize = 8
+	default:
+		return nil, nil
+	}
+	if dir.VirtualAddress == 0 || dir.Size < entrySize {
+		return nil, nil
+	}
+	raw, err := readPERVA(f, dir.VirtualAddress, dir.Size-dir.Size%entrySize)
+	if err != nil {
+		return nil, fmt.Errorf("read PE exception directory: %w", err)
+	}
+	starts := make(map[uint32]bool, len(raw)/int(entrySize))
+	for off := 0; off+int(entrySize) <= len(raw); off += int(entrySize) {
+		starts[binary.LittleEndian.Uint32(raw[off:])] = true
+	}
+	return starts, nil
+}
+
+func readPEPointer(raw []byte, ptrSize uint32) uint64 {
+	if ptrSize == 4 {
+		return uint64(binary.LittleEndian.Uint32(raw))
+	}
+	return binary.LittleEndian.Uint64(raw)
+}
+
+// readPERVA reads size bytes at rva from the section that holds them.
+func readPERVA(f *pe.File, rva uint32, size uint32) ([]byte, error) {
+	for _, section := range f.Sections {
+		if rva < section.VirtualAddress || uint64(rva)+uint64(size) > uint64(section.VirtualAddress)+uint64(section.Size) {
+			continue
+		}
+		out := make([]byte, size)
+		if _, err := section.ReadAt(out, int64(rva-section.VirtualAddress)); err != nil {
+			return nil, fmt.Errorf("read PE data at RVA %#x: %w", rva, err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("PE RVA %#x is outside all sections", rva)
+}
@@ -0,0 +1,96 @@
+package memmod
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// buildStrippedPE returns a minimal amd64 DLL with no named exports: an entry
+// point at RVA 0x1000, a TLS callback at 0x1010, and an ordinal-only export
+// at 0x1020. The exception directory lists the entry point and the export.
+func buildStrippedPE() []byte {
+	const (
+		imageBase   = 0x180000000
+		optional    = 0x58
+		sectionHdr  = optional + 240
+		fileOffset  = 0x200
+		sectionRVA  = 0x1000
+		sectionSize = 0x200
+	)
+	le := binary.LittleEndian
+	data := make([]byte, fileOffset+sectionSize)
+
+	copy(data, "MZ")
+	le.PutUint32(data[0x3c:], 0x40)
+	copy(data[0x40:], "PE\x00\x00")
+	le.PutUint16(data[0x44:], 0x8664)
+	le.PutUint16(data[0x46:], 1)
+	le.PutUint16(data[0x54:], 240)
+	le.PutUint16(data[0x56:], 0x2022)
+
+	le.PutUint16(data[optional:], 0x20b)
+	le.PutUint32(data[optional+16:], sectionRVA)
+	le.PutUint64(data[optional+24:], imageBase)
+	le.PutUint32(data[optional+32:], 0x200)
+	le.PutUint32(data[optional+36:], 0x200)
+	le.PutUint32(data[optional+56:], sectionRVA+sectionSize)
+	le.PutUint32(data[optional+60:], fileOffset)
+	le.PutUint32(data[optional+108:], 16)
+	directory := func(index int, rva, size uint32) {
+		le.PutUint32(data[optional+112+index*8:], rva)
+		le.PutUint32(data[optional+116+index*8:], size)
+	}
+	directory(0, sectionRVA+0x100, 40)
+	directory(3, sectionRVA+0x40, 24)
+	directory(9, sectionRVA+0x80, 40)
+
+	copy(data[sectionHdr:], ".text")
+	le.PutUint32(data[sectionHdr+8:], sectionSize)
+	le.PutUint32(data[sectionHdr+12:], sectionRVA)
+	le.PutUint32(data[sectionHdr+16:], sectionSize)
+	le.PutUint32(data[sectionHdr+20:], fileOffset)
+	le.PutUint32(data[sectionHdr+36:], 0x60000020)
+
+	section := data[fileOffset:]
+	for _, rva := range []uint32{0x00, 0x10, 0x20} {
+		section[rva] = 0xc3
+	}
+	// RUNTIME_FUNCTION entries for the entry point and the export.
+	le.PutUint32(section[0x40:], sectionRVA)
+	le.PutUint32(section[0x44:], sectionRVA+1)
+	le.PutUint32(section[0x4c:], sectionRVA+0x20)
+	le.PutUint32(section[0x50:], sectionRVA+0x21)
+	// IMAGE_TLS_DIRECTORY64.AddressOfCallBacks and a terminated callback array.
+	le.PutUint64(section[0x98:], imageBase+sectionRVA+0xc0)
+	le.PutUint64(section[0xc0:], imageBase+sectionRVA+0x10)
+	// IMAGE_EXPORT_DIRECTORY with one function and no names.
+	le.PutUint32(section[0x110:], 1)
+	le.PutUint32(section[0x114:], 1)
+	le.PutUint32(section[0x11c:], sectionRVA+0x140)
+	le.PutUint32(section[0x140:], sectionRVA+0x20)
+	return data
+}
+
+func TestPEEntryPoints(t *testing.T) {
+	image := buildStrippedPE()
+	if err := ValidateImage(image); err != nil {
+		t.Fatalf("ValidateImage: %v", err)
+	}
+	points, err := PEEntryPoints(image)
+	if err != nil {
+		t.Fatalf("PEEntryPoints: %v", err)
+	}
+	want := []EntryPoint{
+		{Kind: EntryPointImage, Address: 0x1000, Unwind: true},
+		{Kind: EntryPointTLSCallback, Address: 0x1010},
+		{Kind: EntryPointOrdinal, Address: 0x1020, Ordinal: 1, Unwind: true},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Fatalf("PEEntryPoints = %+v, want %+v", points, want)
+	}
+
+	if _, err := PEEntryPoints([]byte("not an image")); err == nil {
+		t.Fatal("PEEntryPoints accepted garbage")
+	}
+}
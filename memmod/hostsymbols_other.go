@@ -0,0 +1,6 @@
+//go:build !(linux && (386 || amd64 || arm64))
+
+package memmod
+
+// ResetHostSymbols is a no-op: only the linux loader caches host symbols.
+func ResetHostSymbols() {}
@@ -0,0 +1,503 @@
+package memmod
+
+import (
+	"bytes"
+	"compress/flate"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ImageInfo describes an image file without loading it. It is built by
+// parsing the file in pure Go, so it works for any format on any host.
+type ImageInfo struct {
+	// Format is "elf", "pe", or "macho".
+	Format string
+	// Arch uses GOARCH names ("386", "amd64", "arm", "arm64") and falls back
+	// to the format's own machine name.
+	Arch     string
+	Sections []SectionInfo
+	Exports  []ExportInfo
+	// Imports lists the libraries the image links against, as named in its
+	// headers (DT_NEEDED entries, import descriptors, LC_LOAD_DYLIB paths).
+	Imports []string
+	// ImageSize is the address span the image occupies once mapped, and
+	// Entry the offset of its entry point from the load base, or zero when
+	// it has none.
+	ImageSize uint64
+	Entry     uint64
+
+	// Size is the file size in bytes and CompressedSize an estimate of it
+	// after DEFLATE compression.
+	Size           int
+	CompressedSize int
+	// Entropy is the Shannon entropy of the whole file in bits per byte.
+	// Values close to 8 usually mean packed or encrypted content.
+	Entropy float64
+	// Strings counts runs of at least four printable ASCII characters, the
+	// same runs strings(1) reports by default.
+	Strings int
+	// Debug is set when the image carries debug sections or a static symbol
+	// table, as debug builds do.
+	Debug bool
+	// Encrypted is set for FairPlay-encrypted Mach-O slices, which cannot be
+	// loaded.
+	Encrypted bool
+	// Overlay is the size of the data appended after the image, which starts
+	// at file offset OverlayOffset; see ImageOverlay. Both are zero when
+	// there is none.
+	Overlay       int
+	OverlayOffset int
+}
+
+// SectionInfo is one section of an image.
+type SectionInfo struct {
+	Name    string
+	Address uint64
+	Size    uint64
+	// Entropy is the Shannon entropy of the section's file data in bits per
+	// byte; zero for sections with no file data.
+	Entropy float64
+}
+
+// ExportInfo is one exported symbol. Names are reported as stored in the
+// image, so Mach-O C symbols keep their leading underscore.
+type ExportInfo struct {
+	Name string
+	// Address is the symbol's virtual address (ELF, Mach-O) or RVA (PE).
+	Address uint64
+	// Ordinal is the PE export ordinal; zero for other formats.
+	Ordinal uint16
+	// Forwarder is the "dll.name" target of a forwarded PE export.
+	Forwarder string
+}
+
+// InspectImage parses an ELF, PE, or Mach-O image and lists its sections and
+// exports. For fat Mach-O files the slice for the current architecture is
+// used when present, otherwise the first slice.
+func InspectImage(data []byte) (*ImageInfo, error) {
+	info, err := InspectHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+	info.Size = len(data)
+	info.CompressedSize = compressedSize(data)
+	info.Entropy = entropy(data)
+	info.Strings = countStrings(data)
+	if overlay, err := ImageOverlay(data); err == nil && len(overlay) != 0 {
+		info.Overlay, info.OverlayOffset = len(overlay), len(data)-len(overlay)
+	}
+	return info, nil
+}
+
+// InspectHeaders is like InspectImage but skips the whole-file statistics:
+// Size, CompressedSize, Entropy, Strings, and the overlay are left zero. It
+// is cheap enough to run on every load.
+func InspectHeaders(data []byte) (*ImageInfo, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return inspectELF(data)
+	case bytes.HasPrefix(data, []byte("MZ")):
+		return inspectPE(data)
+	}
+	if fat, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
+		defer fat.Close()
+		if len(fat.Arches) == 0 {
+			return nil, errors.New("fat Mach-O image has no slices")
+		}
+		arch := fat.Arches[0]
+		for _, candidate := range fat.Arches {
+			if machOArch(candidate.Cpu) == runtime.GOARCH {
+				arch = candidate
+				break
+			}
+		}
+		return inspectMachO(arch.File)
+	}
+	if f, err := macho.NewFile(bytes.NewReader(data)); err == nil {
+		defer f.Close()
+		return inspectMachO(f)
+	}
+	return nil, errors.New("unrecognized image format")
+}
+
+func inspectELF(data []byte) (*ImageInfo, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse ELF image: %w", err)
+	}
+	defer f.Close()
+
+	info := &ImageInfo{Format: "elf", Arch: elfArch(f.Machine)}
+	for _, section := range f.Sections {
+		if section.Name == "" {
+			continue
+		}
+		entry := SectionInfo{Name: section.Name, Address: section.Addr, Size: section.Size}
+		if section.Type != elf.SHT_NOBITS && section.Offset+section.FileSize <= uint64(len(data)) {
+			entry.Entropy = entropy(data[section.Offset : section.Offset+section.FileSize])
+		}
+		info.Sections = append(info.Sections, entry)
+		info.Debug = info.Debug || section.Name == ".symtab" || isDebugSection(section.Name)
+	}
+
+	symbols, err := f.DynamicSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("read ELF dynamic symbols: %w", err)
+	}
+	for _, sym := range symbols {
+		bind := elf.ST_BIND(sym.Info)
+		if sym.Name == "" || sym.Section == elf.SHN_UNDEF || (bind != elf.STB_GLOBAL && bind != elf.STB_WEAK) {
+			continue
+		}
+		info.Exports = append(info.Exports, ExportInfo{Name: sym.Name, Address: sym.Value})
+	}
+	sortExports(info.Exports)
+
+	if info.Imports, err = elfImportedLibraries(f); err != nil {
+		return nil, err
+	}
+	// Segments are mapped whole pages at a time, as the loader does.
+	page := uint64(os.Getpagesize())
+	low, high := uint64(math.MaxUint64), uint64(0)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			low = min(low, prog.Vaddr&^(page-1))
+			high = max(high, (prog.Vaddr+prog.Memsz+page-1)&^(page-1))
+		}
+	}
+	if high > low {
+		info.ImageSize = high - low
+		if f.Entry >= low && f.Entry < high {
+			info.Entry = f.Entry - low
+		}
+	}
+	return info, nil
+}
+
+// machOLoadMain is LC_MAIN, which debug/macho does not decode.
+const machOLoadMain = 0x80000028
+
+func inspectMachO(f *macho.File) (*ImageInfo, error) {
+	const (
+		nStab = 0xe0
+		nExt  = 0x01
+		nType = 0x0e
+		nSect = 0x0e
+
+		sectionType    = 0xff
+		zerofill       = 0x1
+		gbZerofill     = 0xc
+		threadZerofill = 0x12
+	)
+
+	info := &ImageInfo{Format: "macho", Arch: machOArch(f.Cpu), Encrypted: machOEncryption(f) != nil}
+	for _, section := range f.Sections {
+		entry := SectionInfo{Name: section.Seg + "," + section.Name, Address: section.Addr, Size: section.Size}
+		switch section.Flags & sectionType {
+		case zerofill, gbZerofill, threadZerofill:
+		default:
+			if data, err := section.Data(); err == nil {
+				entry.Entropy = entropy(data)
+			}
+		}
+		info.Sections = append(info.Sections, entry)
+		info.Debug = info.Debug || section.Seg == "__DWARF"
+	}
+	if f.Symtab != nil {
+		for _, sym := range f.Symtab.Syms {
+			info.Debug = info.Debug || sym.Type&nStab != 0
+			if sym.Name == "" || sym.Type&nExt == 0 || sym.Type&nType != nSect {
+				continue
+			}
+			info.Exports = append(info.Exports, ExportInfo{Name: sym.Name, Address: sym.Value})
+		}
+	}
+	sortExports(info.Exports)
+
+	imports, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, fmt.Errorf("read Mach-O imported libraries: %w", err)
+	}
+	info.Imports = imports
+	low, high := uint64(math.MaxUint64), uint64(0)
+	for _, load := range f.Loads {
+		// __PAGEZERO reserves address space the loader does not map.
+		if seg, ok := load.(*macho.Segment); ok && seg.Memsz != 0 && seg.Name != "__PAGEZERO" {
+			low, high = min(low, seg.Addr), max(high, seg.Addr+seg.Memsz)
+		}
+		// LC_MAIN's entryoff is relative to the start of __TEXT, which is
+		// also where the mapped image begins.
+		if raw := load.Raw(); len(raw) >= 16 && f.ByteOrder.Uint32(raw) == machOLoadMain {
+			info.Entry = f.ByteOrder.Uint64(raw[8:])
+		}
+	}
+	if high > low {
+		info.ImageSize = high - low
+	}
+	return info, nil
+}
+
+func inspectPE(data []byte) (*ImageInfo, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse PE image: %w", err)
+	}
+	defer f.Close()
+
+	info := &ImageInfo{Format: "pe", Arch: peArch(f.Machine), Debug: f.FileHeader.NumberOfSymbols != 0}
+	for _, section := range f.Sections {
+		entry := SectionInfo{Name: section.Name, Address: uint64(section.VirtualAddress), Size: uint64(section.VirtualSize)}
+		if data, err := section.Data(); err == nil {
+			entry.Entropy = entropy(data)
+		}
+		info.Sections = append(info.Sections, entry)
+		info.Debug = info.Debug || (isDebugSection(section.Name) && section.Size != 0)
+	}
+
+	if info.Imports, err = peImportedLibraries(f); err != nil {
+		return nil, err
+	}
+
+	var exportDir pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		info.ImageSize, info.Entry = uint64(header.SizeOfImage), uint64(header.AddressOfEntryPoint)
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_EXPORT {
+			exportDir = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_EXPORT]
+		}
+	case *pe.OptionalHeader64:
+		info.ImageSize, info.Entry = uint64(header.SizeOfImage), uint64(header.AddressOfEntryPoint)
+		if header.NumberOfRvaAndSizes > pe.IMAGE_DIRECTORY_ENTRY_EXPORT {
+			exportDir = header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_EXPORT]
+		}
+	}
+	if exportDir.VirtualAddress == 0 || exportDir.Size == 0 {
+		return info, nil
+	}
+
+	exports, err := readPEExports(f, exportDir)
+	if err != nil {
+		return nil, err
+	}
+	info.Exports = exports
+	return info, nil
+}
+
+// peExportDirectory mirrors IMAGE_EXPORT_DIRECTORY for hosts other than
+// windows.
+type peExportDirectory struct {
+	Characteristics       uint32
+	TimeDateStamp         uint32
+	MajorVersion          uint16
+	MinorVersion          uint16
+	Name                  uint32
+	Base                  uint32
+	NumberOfFunctions     uint32
+	NumberOfNames         uint32
+	AddressOfFunctions    uint32
+	AddressOfNames        uint32
+	AddressOfNameOrdinals uint32
+}
+
+// readPEExports walks the export directory, including exports that only have
+// an ordinal and exports forwarded to another DLL.
+func readPEExports(f *pe.File, dir pe.DataDirectory) ([]ExportInfo, error) {
+	readString := func(rva uint32) (string, error) {
+		for _, section := range f.Sections {
+			if rva < section.VirtualAddress || rva >= section.VirtualAddress+section.Size {
+				continue
+			}
+			data, err := section.Data()
+			if err != nil {
+				return "", err
+			}
+			rest := data[rva-section.VirtualAddress:]
+			if end := bytes.IndexByte(rest, 0); end >= 0 {
+				rest = rest[:end]
+			}
+			return string(rest), nil
+		}
+		return "", fmt.Errorf("PE RVA %#x is outside all sections", rva)
+	}
+
+	raw, err := readPERVA(f, dir.VirtualAddress, 40)
+	if err != nil {
+		return nil, fmt.Errorf("read PE export directory: %w", err)
+	}
+	var header peExportDirectory
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("decode PE export directory: %w", err)
+	}
+	if header.NumberOfFunctions > 0xffff || header.NumberOfNames > header.NumberOfFunctions {
+		return nil, fmt.Errorf("PE export directory is corrupt (functions=%d names=%d)", header.NumberOfFunctions, header.NumberOfNames)
+	}
+
+	functions, err := readPERVA(f, header.AddressOfFunctions, header.NumberOfFunctions*4)
+	if err != nil {
+		return nil, fmt.Errorf("read PE export address table: %w", err)
+	}
+	names := make(map[uint32]string, header.NumberOfNames)
+	if header.NumberOfNames > 0 {
+		nameRVAs, err := readPERVA(f, header.AddressOfNames, header.NumberOfNames*4)
+		if err != nil {
+			return nil, fmt.Errorf("read PE export name table: %w", err)
+		}
+		ordinals, err := readPERVA(f, header.AddressOfNameOrdinals, header.NumberOfNames*2)
+		if err != nil {
+			return nil, fmt.Errorf("read PE export ordinal table: %w", err)
+		}
+		for i := uint32(0); i < header.NumberOfNames; i++ {
+			name, err := readString(binary.LittleEndian.Uint32(nameRVAs[i*4:]))
+			if err != nil {
+				return nil, fmt.Errorf("read PE export name: %w", err)
+			}
+			names[uint32(binary.LittleEndian.Uint16(ordinals[i*2:]))] = name
+		}
+	}
+
+	var exports []ExportInfo
+	for i := uint32(0); i < header.NumberOfFunctions; i++ {
+		rva := binary.LittleEndian.Uint32(functions[i*4:])
+		if rva == 0 {
+			continue
+		}
+		export := ExportInfo{Name: names[i], Address: uint64(rva), Ordinal: uint16(header.Base + i)}
+		if rva >= dir.VirtualAddress && rva < dir.VirtualAddress+dir.Size {
+			if export.Forwarder, err = readString(rva); err != nil {
+				return nil, fmt.Errorf("read PE export forwarder: %w", err)
+			}
+		}
+		exports = append(exports, export)
+	}
+	sortExports(exports)
+	return exports, nil
+}
+
+// sortExports orders exports by name, with ordinal-only exports last in
+// ordinal order.
+func sortExports(exports []ExportInfo) {
+	sort.Slice(exports, func(i, j int) bool {
+		a, b := exports[i], exports[j]
+		if (a.Name == "") != (b.Name == "") {
+			return b.Name == ""
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Ordinal < b.Ordinal
+	})
+}
+
+func isDebugSection(name string) bool {
+	return strings.HasPrefix(name, ".debug") || strings.HasPrefix(name, ".zdebug")
+}
+
+// entropy returns the Shannon entropy of data in bits per byte.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// compressedSize returns the size of data after DEFLATE at the default level.
+func compressedSize(data []byte) int {
+	var counter byteCounter
+	w, err := flate.NewWriter(&counter, flate.DefaultCompression)
+	if err != nil {
+		return 0
+	}
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return int(counter)
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// countStrings counts runs of four or more printable ASCII characters.
+func countStrings(data []byte) int {
+	const minLength = 4
+	count, run := 0, 0
+	for _, b := range data {
+		if (b >= 0x20 && b < 0x7f) || b == '\t' {
+			run++
+			continue
+		}
+		if run >= minLength {
+			count++
+		}
+		run = 0
+	}
+	if run >= minLength {
+		count++
+	}
+	return count
+}
+
+func elfArch(machine elf.Machine) string {
+	switch machine {
+	case elf.EM_386:
+		return "386"
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_AARCH64:
+		return "arm64"
+	}
+	return machine.String()
+}
+
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "386"
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "amd64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	}
+	return fmt.Sprintf("pe-machine-%#x", machine)
+}
+
+func machOArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		return "arm64"
+	}
+	return cpu.String()
+}
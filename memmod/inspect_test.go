@@ -0,0 +1,63 @@
+package memmod
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestInspectImageReadsTestBinary(t *testing.T) {
+	wantFormat := map[string]string{"linux": "elf", "windows": "pe", "darwin": "macho"}[runtime.GOOS]
+	if wantFormat == "" {
+		t.Skipf("no image format expectation for %s", runtime.GOOS)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("read %s: %v", exe, err)
+	}
+
+	info, err := InspectImage(data)
+	if err != nil {
+		t.Fatalf("InspectImage: %v", err)
+	}
+	if info.Format != wantFormat || info.Arch != runtime.GOARCH {
+		t.Fatalf("unexpected image kind: got %s/%s, want %s/%s", info.Format, info.Arch, wantFormat, runtime.GOARCH)
+	}
+	if len(info.Sections) == 0 {
+		t.Fatal("no sections found")
+	}
+	if info.Size != len(data) || info.CompressedSize <= 0 || info.CompressedSize >= info.Size {
+		t.Fatalf("unexpected size report: size=%d compressed=%d", info.Size, info.CompressedSize)
+	}
+	// The test binary is an executable, so it has an entry point inside its
+	// mapped span.
+	if info.ImageSize == 0 || info.Entry == 0 || info.Entry >= info.ImageSize {
+		t.Fatalf("unexpected layout report: image size=%#x entry=%#x", info.ImageSize, info.Entry)
+	}
+	if info.Entropy <= 0 || info.Entropy > 8 || info.Strings == 0 {
+		t.Fatalf("unexpected content report: entropy=%.2f strings=%d", info.Entropy, info.Strings)
+	}
+	if _, err := InspectImage([]byte("not an image")); err == nil {
+		t.Fatal("InspectImage accepted garbage")
+	}
+}
+
+func TestEntropyAndStrings(t *testing.T) {
+	if got := entropy(make([]byte, 64)); got != 0 {
+		t.Fatalf("entropy(zeros) = %f, want 0", got)
+	}
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if got := entropy(all); got != 8 {
+		t.Fatalf("entropy(all bytes) = %f, want 8", got)
+	}
+	if got := countStrings([]byte("abc\x00abcd\x00\x01hello world\xffxyz")); got != 2 {
+		t.Fatalf("countStrings = %d, want 2", got)
+	}
+}
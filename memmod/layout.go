@@ -0,0 +1,174 @@
+package memmod
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"fmt"
+	"sort"
+)
+
+// LayoutProblem names what is wrong with an image's segment or section
+// layout.
+type LayoutProblem string
+
+const (
+	// LayoutOutOfFile means a segment's file range runs past the end of the
+	// image.
+	LayoutOutOfFile LayoutProblem = "file range outside image"
+	// LayoutFileSize means a segment has more file bytes than memory to hold
+	// them.
+	LayoutFileSize LayoutProblem = "file size exceeds memory size"
+	// LayoutMisaligned means an ELF segment's address and file offset are not
+	// congruent modulo its alignment.
+	LayoutMisaligned LayoutProblem = "address and offset not congruent"
+	// LayoutOverlap means two segments claim the same addresses.
+	LayoutOverlap LayoutProblem = "overlapping address ranges"
+	// LayoutProtection means two segments with different protections share
+	// a page, so one of them would end up with the wrong protection.
+	LayoutProtection LayoutProblem = "conflicting protections on a shared page"
+	// LayoutHeaders means a section would be copied over the image headers.
+	LayoutHeaders LayoutProblem = "overlaps image headers"
+)
+
+// LayoutError reports an image whose layout is unsafe to map. Loaders check
+// the layout before writing anything, so a malformed image cannot steer
+// copies outside the mapping or over memory another segment owns.
+type LayoutError struct {
+	// Format is "elf", "pe", or "macho".
+	Format  string
+	Problem LayoutProblem
+	// Index is the program header, section, or segment index at fault, and
+	// Other the index it conflicts with, or -1.
+	Index int
+	Other int
+	// Addr and Size describe the offending range: a virtual address range
+	// for address problems and a file range for LayoutOutOfFile.
+	Addr uint64
+	Size uint64
+}
+
+func (e *LayoutError) Error() string {
+	kind := map[string]string{"elf": "ELF segment", "pe": "PE section", "macho": "Mach-O segment"}[e.Format]
+	if kind == "" {
+		kind = e.Format + " segment"
+	}
+	msg := fmt.Sprintf("%s %d [%#x, %#x): %s", kind, e.Index, e.Addr, e.Addr+e.Size, e.Problem)
+	if e.Other >= 0 {
+		msg += fmt.Sprintf(" with %d", e.Other)
+	}
+	return msg
+}
+
+// layoutSegment is one mapped range in format-neutral form. prot holds the
+// format's own protection bits and is only compared for equality.
+type layoutSegment struct {
+	index    int
+	fileOff  uint64
+	fileSize uint64
+	addr     uint64
+	memSize  uint64
+	prot     uint32
+}
+
+// checkLayout rejects segments whose file data lies outside the image, that
+// overlap each other or the first headerEnd bytes of the address space, or,
+// when pageSize is non-zero, that share a page with a segment of different
+// protection.
+func checkLayout(format string, segments []layoutSegment, fileSize, headerEnd, pageSize uint64) error {
+	fail := func(problem LayoutProblem, s layoutSegment, other int, addr, size uint64) error {
+		return &LayoutError{Format: format, Problem: problem, Index: s.index, Other: other, Addr: addr, Size: size}
+	}
+	for _, s := range segments {
+		if s.fileSize != 0 && (s.fileOff > fileSize || s.fileSize > fileSize-s.fileOff) {
+			return fail(LayoutOutOfFile, s, -1, s.fileOff, s.fileSize)
+		}
+		// Segments with no memory extent, such as Go's Mach-O __DWARF, are
+		// never mapped.
+		if s.memSize != 0 && s.fileSize > s.memSize {
+			return fail(LayoutFileSize, s, -1, s.addr, s.memSize)
+		}
+		if s.addr+s.memSize < s.addr {
+			return fail(LayoutOverlap, s, -1, s.addr, s.memSize)
+		}
+		if s.memSize != 0 && s.addr < headerEnd {
+			return fail(LayoutHeaders, s, -1, s.addr, s.memSize)
+		}
+	}
+
+	sorted := make([]layoutSegment, 0, len(segments))
+	for _, s := range segments {
+		if s.memSize != 0 {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].addr < sorted[j].addr })
+	for i := 1; i < len(sorted); i++ {
+		prev, s := sorted[i-1], sorted[i]
+		if s.addr < prev.addr+prev.memSize {
+			return fail(LayoutOverlap, s, prev.index, s.addr, s.memSize)
+		}
+		if pageSize != 0 && s.prot != prev.prot && alignDown64(s.addr, pageSize) < alignUp64(prev.addr+prev.memSize, pageSize) {
+			return fail(LayoutProtection, s, prev.index, s.addr, s.memSize)
+		}
+	}
+	return nil
+}
+
+// checkELFLayout validates the PT_LOAD segments of an ELF image for a host
+// with the given page size.
+func checkELFLayout(progs []*elf.Prog, fileSize, pageSize uint64) error {
+	var segments []layoutSegment
+	for i, p := range progs {
+		if p.Type != elf.PT_LOAD || p.Memsz == 0 {
+			continue
+		}
+		if p.Align > 1 && p.Vaddr%p.Align != p.Off%p.Align {
+			return &LayoutError{Format: "elf", Problem: LayoutMisaligned, Index: i, Other: -1, Addr: p.Vaddr, Size: p.Memsz}
+		}
+		segments = append(segments, layoutSegment{
+			index:    i,
+			fileOff:  p.Off,
+			fileSize: p.Filesz,
+			addr:     p.Vaddr,
+			memSize:  p.Memsz,
+			prot:     uint32(p.Flags),
+		})
+	}
+	return checkLayout("elf", segments, fileSize, 0, pageSize)
+}
+
+// checkMachOLayout validates the segments of a thin Mach-O image.
+func checkMachOLayout(f *macho.File, fileSize uint64) error {
+	var segments []layoutSegment
+	index := 0
+	for _, load := range f.Loads {
+		segment, ok := load.(*macho.Segment)
+		if !ok {
+			continue
+		}
+		segments = append(segments, layoutSegment{
+			index:    index,
+			fileOff:  segment.Offset,
+			fileSize: segment.Filesz,
+			addr:     segment.Addr,
+			memSize:  segment.Memsz,
+			prot:     segment.Prot,
+		})
+		index++
+	}
+	return checkLayout("macho", segments, fileSize, 0, 0)
+}
+
+func alignDown64(v, a uint64) uint64 {
+	if a == 0 {
+		return v
+	}
+	return v &^ (a - 1)
+}
+
+func alignUp64(v, a uint64) uint64 {
+	if a == 0 {
+		return v
+	}
+	return (v + (a - 1)) &^ (a - 1)
+}
@@ -0,0 +1,100 @@
+package memmod
+
+import (
+	"debug/elf"
+	"errors"
+	"testing"
+)
+
+func TestCheckLayout(t *testing.T) {
+	const page = 0x1000
+	text := layoutSegment{index: 0, fileOff: 0, fileSize: 0x800, addr: 0, memSize: 0x800, prot: 5}
+	data := layoutSegment{index: 1, fileOff: 0x1000, fileSize: 0x200, addr: 0x1000, memSize: 0x400, prot: 6}
+
+	tests := []struct {
+		name      string
+		segments  []layoutSegment
+		fileSize  uint64
+		headerEnd uint64
+		want      LayoutProblem
+	}{
+		{name: "valid", segments: []layoutSegment{text, data}, fileSize: 0x1200},
+		{
+			name:     "out of file",
+			segments: []layoutSegment{text, data},
+			fileSize: 0x1100,
+			want:     LayoutOutOfFile,
+		},
+		{
+			name:     "offset overflow",
+			segments: []layoutSegment{{index: 0, fileOff: ^uint64(0), fileSize: 2, memSize: 2}},
+			fileSize: 0x1000,
+			want:     LayoutOutOfFile,
+		},
+		{
+			name:     "file size",
+			segments: []layoutSegment{{index: 0, fileSize: 0x800, memSize: 0x400}},
+			fileSize: 0x1000,
+			want:     LayoutFileSize,
+		},
+		{
+			name:     "address overflow",
+			segments: []layoutSegment{{index: 0, addr: ^uint64(0) - 0x10, memSize: 0x100}},
+			fileSize: 0x1000,
+			want:     LayoutOverlap,
+		},
+		{
+			name:     "overlap",
+			segments: []layoutSegment{text, {index: 1, fileOff: 0x1000, fileSize: 0x200, addr: 0x400, memSize: 0x400, prot: 5}},
+			fileSize: 0x1200,
+			want:     LayoutOverlap,
+		},
+		{
+			name:     "shared page",
+			segments: []layoutSegment{text, {index: 1, fileOff: 0x1000, fileSize: 0x200, addr: 0xc00, memSize: 0x400, prot: 6}},
+			fileSize: 0x1200,
+			want:     LayoutProtection,
+		},
+		{
+			name:      "headers",
+			segments:  []layoutSegment{{index: 0, fileOff: 0x200, fileSize: 0x200, addr: 0x100, memSize: 0x200}},
+			fileSize:  0x400,
+			headerEnd: 0x400,
+			want:      LayoutHeaders,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLayout("elf", tt.segments, tt.fileSize, tt.headerEnd, page)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("checkLayout: %v", err)
+				}
+				return
+			}
+			var layoutErr *LayoutError
+			if !errors.As(err, &layoutErr) {
+				t.Fatalf("checkLayout = %v, want *LayoutError", err)
+			}
+			if layoutErr.Problem != tt.want {
+				t.Fatalf("checkLayout problem = %q, want %q (%v)", layoutErr.Problem, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCheckELFLayoutCongruence(t *testing.T) {
+	progs := []*elf.Prog{
+		{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_X, Off: 0, Vaddr: 0, Filesz: 0x800, Memsz: 0x800, Align: 0x1000}},
+		{ProgHeader: elf.ProgHeader{Type: elf.PT_LOAD, Flags: elf.PF_R | elf.PF_W, Off: 0x1010, Vaddr: 0x2000, Filesz: 0x100, Memsz: 0x100, Align: 0x1000}},
+	}
+	var layoutErr *LayoutError
+	if err := checkELFLayout(progs, 0x2000, 0x1000); !errors.As(err, &layoutErr) || layoutErr.Problem != LayoutMisaligned || layoutErr.Index != 1 {
+		t.Fatalf("checkELFLayout = %v, want misaligned segment 1", err)
+	}
+
+	progs[1].Vaddr = 0x2010
+	if err := checkELFLayout(progs, 0x2000, 0x1000); err != nil {
+		t.Fatalf("checkELFLayout: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package memmod
+
+import "fmt"
+
+// LoaderStage names the step of an in-memory load that failed.
+type LoaderStage string
+
+const (
+	// LoaderStageImage is parsing the image and laying out its segments.
+	LoaderStageImage LoaderStage = "parse the image"
+	// LoaderStageDyldCache is finding libdyld and dyld in the shared cache.
+	LoaderStageDyldCache LoaderStage = "locate dyld cache images"
+	// LoaderStageRuntimeAPI is finding dyld's RuntimeState.
+	LoaderStageRuntimeAPI LoaderStage = "resolve the dyld runtime API"
+	// LoaderStageSymbols is resolving the dyld internals the loader calls;
+	// Detail lists the missing ones. A dyld this loader does not know fails
+	// here.
+	LoaderStageSymbols LoaderStage = "resolve required dyld symbols"
+	// LoaderStageMapping is reserving, mapping, or protecting memory.
+	LoaderStageMapping LoaderStage = "map the image"
+	// LoaderStageRegister is creating the image's dyld loader and adding it
+	// to dyld's loaded list.
+	LoaderStageRegister LoaderStage = "register the image with dyld"
+	// LoaderStageLink is loading dependents and applying fixups.
+	LoaderStageLink LoaderStage = "load dependents or apply fixups"
+)
+
+// LoaderError is returned, wrapped, when a platform loader fails partway
+// through a load. Use errors.As to tell the stages apart.
+type LoaderError struct {
+	Stage LoaderStage
+	// Detail is what the platform reported, such as a dyld diagnostics
+	// message; it may be empty.
+	Detail string
+	// Platform is the GOOS of the loader.
+	Platform string
+}
+
+func (e *LoaderError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s loader failed to %s", e.Platform, e.Stage)
+	}
+	return fmt.Sprintf("%s loader failed to %s: %s", e.Platform, e.Stage, e.Detail)
+}
@@ -0,0 +1,22 @@
+package memmod
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestLoaderError(t *testing.T) {
+	err := fmt.Errorf("load Mach-O image: %w", &LoaderError{Stage: LoaderStageSymbols, Detail: "Loader::applyFixups", Platform: "darwin"})
+
+	var loaderErr *LoaderError
+	if !errors.As(err, &loaderErr) || loaderErr.Stage != LoaderStageSymbols {
+		t.Fatalf("errors.As(%v) = %+v, want stage %q", err, loaderErr, LoaderStageSymbols)
+	}
+	if got, want := err.Error(), "load Mach-O image: darwin loader failed to resolve required dyld symbols: Loader::applyFixups"; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+	if got, want := (&LoaderError{Stage: LoaderStageRuntimeAPI, Platform: "darwin"}).Error(), "darwin loader failed to resolve the dyld runtime API"; got != want {
+		t.Fatalf("Error() without detail = %q, want %q", got, want)
+	}
+}
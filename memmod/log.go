@@ -0,0 +1,32 @@
+package memmod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Load stages, the values of the "stage" attribute on the records a
+// LoadOptions.Logger receives.
+const (
+	stageUnpack     = "unpack"
+	stageArch       = "arch"
+	stageMap        = "map"
+	stageRelocate   = "relocate"
+	stageDependency = "dependency"
+	stageInitialize = "initialize"
+)
+
+// trace logs one step of a load to logger at debug level, tagged with stage.
+// A nil logger logs nothing.
+func trace(logger *slog.Logger, stage, msg string, args ...any) {
+	if logger == nil || !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	logger.Debug(msg, append([]any{slog.String("stage", stage)}, args...)...)
+}
+
+// addrAttr formats an address in hex, as debuggers and /proc maps show them.
+func addrAttr(key string, addr uintptr) slog.Attr {
+	return slog.String(key, fmt.Sprintf("%#x", addr))
+}
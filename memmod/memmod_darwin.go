@@ -7,10 +7,14 @@ import (
 	"debug/macho"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -22,37 +26,99 @@ const (
 
 	lcSegment64 = 0x19
 	lcSymtab    = 0x2
+	lcIDDylib   = 0xd
+
+	// sgReadOnly marks a segment dyld makes read-only once fixups are
+	// applied, such as __DATA_CONST and __AUTH_CONST.
+	sgReadOnly = 0x10
 )
 
 var (
 	errDarwinLibraryClosed = errors.New("library is closed")
-	darwinLoaderDetailMu   sync.Mutex
-	darwinLoaderDetail     string
 )
 
 type Module struct {
 	mu     sync.RWMutex
 	image  []byte
+	mapped mappedImage
 	closed bool
+	locked bool
+	// reserved is the span charged against the mapping budget.
+	reserved uint64
+	// dyld is the thread that loaded the image and that unloads it.
+	dyld *dyldThread
 }
 
 // LoadLibrary loads a Mach-O image into the darwin in-memory loader context.
 func LoadLibrary(data []byte) (*Module, error) {
+	return LoadLibraryWithOptions(data, LoadOptions{})
+}
+
+// deterministicLoads numbers the images loaded with LoadOptions.Deterministic
+// and no name, so repeated loads of one image get distinct, stable names.
+var deterministicLoads atomic.Uint64
+
+// LoadLibraryWithOptions is like LoadLibrary. Only the mapping limits,
+// ImagePath, SkipInitializers, Deterministic, and LoadMode in opts apply on
+// darwin.
+//
+// Every dyld call for the image, from mapping and initializers through the
+// terminators and reference drop of Unload, runs on one locked OS thread the
+// module owns for its lifetime. Exports run on the calling thread. Images
+// linked with DarwinSelfFixup are loaded and unloaded on that thread too.
+func LoadLibraryWithOptions(data []byte, opts LoadOptions) (*Module, error) {
 	if len(data) == 0 {
 		return nil, errors.New("empty Mach-O image")
 	}
+	data, err := depackImage(data, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	image, err := selectCurrentArchMachOSlice(data)
+	image, err := selectCurrentArchMachOSlice(data, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	span, err := machOSpan(image)
 	if err != nil {
 		return nil, err
 	}
+	if err := reserveMapping(span, opts); err != nil {
+		return nil, err
+	}
 
 	cloned := make([]byte, len(image))
 	copy(cloned, image)
-	return &Module{image: cloned}, nil
+	path := opts.ImagePath
+	if path == "" {
+		path = machOInstallName(cloned)
+	}
+	if path == "" && opts.Deterministic {
+		path = fmt.Sprintf("memmod-%016x-%d", imageDigest(cloned), deterministicLoads.Add(1))
+	}
+	var (
+		mapped mappedImage
+		thread = newDyldThread()
+	)
+	thread.run(func() {
+		if opts.LoadMode == DarwinSelfFixup {
+			mapped, err = selfFixupLoader(cloned, !opts.SkipInitializers, opts.Logger)
+			if !errors.Is(err, errSelfFixupUnsupported) {
+				return
+			}
+		}
+		mapped, err = memmodLoader(cloned, path, !opts.SkipInitializers, opts.Logger)
+	})
+	if err != nil {
+		thread.stop()
+		releaseMapping(span)
+		return nil, fmt.Errorf("load Mach-O image: %w", err)
+	}
+	return &Module{image: cloned, mapped: mapped, reserved: span, dyld: thread}, nil
 }
 
-// Free releases the in-memory Mach-O bytes.
+// Free is Unload without the error: it runs the image's terminators, drops
+// dyld's reference, and releases the module.
 func (module *Module) Free() {
 	module.mu.Lock()
 	defer module.mu.Unlock()
@@ -60,54 +126,305 @@ func (module *Module) Free() {
 	if module.closed {
 		return
 	}
+	_ = module.unloadLocked()
+}
 
+// Unload runs the image's terminators and drops the reference dyld took on it
+// at load, as dlclose would, then releases the module. The mapping is only
+// unmapped once dyld no longer holds a loader for the image; otherwise it
+// stays in place, and in either case its exports must not be called once
+// Unload returns.
+func (module *Module) Unload() error {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.closed {
+		return errDarwinLibraryClosed
+	}
+	return module.unloadLocked()
+}
+
+func (module *Module) unloadLocked() error {
 	module.closed = true
+	var (
+		unmapped bool
+		err      error
+	)
+	module.dyld.run(func() {
+		unmapped, err = unloadImage(module.mapped)
+	})
+	module.dyld.stop()
+
 	if module.image != nil {
 		for i := range module.image {
 			module.image[i] = 0
 		}
+		if module.locked {
+			_ = unix.Munlock(module.image)
+		}
 		module.image = nil
 	}
+	if unmapped {
+		_ = unix.Munmap(module.mapped.mapping)
+		_ = unix.Munmap(module.mapped.scratch)
+	} else if module.locked && len(module.mapped.mapping) != 0 {
+		_ = unix.Munlock(module.mapped.mapping)
+	}
+	module.mapped = mappedImage{}
+	releaseMapping(module.reserved)
+	module.reserved = 0
+	return err
+}
+
+// RunInitializers runs the initializers of an image loaded with
+// LoadOptions.SkipInitializers, on the thread that loaded it: its
+// constructors and C++ static initializers, and on the dyld path the
+// Objective-C +load methods too. A payload whose work is done entirely in
+// constructors needs no export call after it. Unload runs the image's
+// terminators from then on. It fails if the initializers already ran.
+func (module *Module) RunInitializers() error {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.closed {
+		return errDarwinLibraryClosed
+	}
+	if module.mapped.initialized {
+		return errors.New("initializers already ran")
+	}
+	var err error
+	module.dyld.run(func() {
+		err = initializeImage(&module.mapped, module.image)
+	})
+	return err
+}
+
+// Base returns the address the image was mapped at, or zero once the module
+// is freed.
+func (module *Module) Base() uintptr {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	if module.closed {
+		return 0
+	}
+	return module.mapped.loadAddress
+}
+
+// LockMemory locks the mapped image and the retained image bytes so they are
+// never written to swap. The lock is released by Free.
+func (module *Module) LockMemory() error {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if module.closed {
+		return errDarwinLibraryClosed
+	}
+	if err := unix.Mlock(module.mapped.mapping); err != nil {
+		return fmt.Errorf("mlock image: %w", err)
+	}
+	if err := unix.Mlock(module.image); err != nil {
+		_ = unix.Munlock(module.mapped.mapping)
+		return fmt.Errorf("mlock image: %w", err)
+	}
+	module.locked = true
+	return nil
 }
 
-// CallExport loads the image and invokes the named exported symbol.
+// CallExport invokes the named exported symbol.
 func (module *Module) CallExport(name string) error {
-	symbol, err := normalizeMachOSymbol(name)
+	_, err := module.CallExportResult(name)
+	return err
+}
+
+// CallExportResult invokes the named exported symbol and returns its raw
+// return value and errno.
+func (module *Module) CallExportResult(name string) (CallResult, error) {
+	return module.CallExportArgs(name)
+}
+
+// CallExportArgs is like CallExportResult but passes up to MaxCallArgs integer
+// or pointer arguments to the export.
+func (module *Module) CallExportArgs(name string, args ...uintptr) (CallResult, error) {
+	padded, err := callArgs(args)
 	if err != nil {
-		return err
+		return CallResult{}, err
 	}
 
+	// Hold the read lock across the call so Free cannot run while the export
+	// is executing.
 	module.mu.RLock()
+	defer module.mu.RUnlock()
+	addr, err := module.procAddressByNameLocked(name)
+	if err != nil {
+		return CallResult{}, fmt.Errorf("call export %q: %w", name, err)
+	}
+	return measureCall(func() CallResult { return callEntry(addr, padded) }), nil
+}
+
+// callCode calls fn with arg on the calling thread and returns its raw return
+// value and errno.
+func callCode(fn, arg uintptr) CallResult {
+	return measureCall(func() CallResult { return callEntry(fn, [MaxCallArgs]uintptr{arg}) })
+}
+
+// Exports returns the sorted external symbols the image defines, without the
+// leading underscore of C symbol names.
+func (module *Module) Exports() ([]string, error) {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
 	if module.closed {
-		module.mu.RUnlock()
-		return errDarwinLibraryClosed
+		return nil, errDarwinLibraryClosed
 	}
-	if len(module.image) == 0 {
-		module.mu.RUnlock()
-		return errors.New("library image is empty")
+	return module.exportsLocked()
+}
+
+func (module *Module) exportsLocked() ([]string, error) {
+	f, err := macho.NewFile(bytes.NewReader(module.image))
+	if err != nil {
+		return nil, fmt.Errorf("parse Mach-O image: %w", err)
 	}
-	image := module.image
-	module.mu.RUnlock()
+	defer f.Close()
+	if f.Symtab == nil {
+		return nil, nil
+	}
+
+	const (
+		nExt  = 0x01
+		nType = 0x0e
+		nSect = 0x0e
+	)
+	var names []string
+	for _, sym := range f.Symtab.Syms {
+		if sym.Type&nExt == 0 || sym.Type&nType != nSect || sym.Name == "" {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(sym.Name, "_"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
 
-	rc := memmodLoader(image, symbol)
-	runtime.KeepAlive(image)
+// StartExportThread is not supported by the darwin loader path, which runs
+// exports on the calling thread.
+func (module *Module) StartExportThread(name string, opts ThreadOptions) (func() CallResult, error) {
+	_, _ = name, opts
+	return nil, errors.New("StartExportThread is not supported on darwin; use CallExport")
+}
+
+// StartEntry is not supported by the darwin loader path.
+func (module *Module) StartEntry(argv []string) (func(), error) {
+	_ = argv
+	return nil, errors.New("StartEntry is only supported for static-PIE executables on linux")
+}
 
-	if rc != 0 {
-		return fmt.Errorf("call export %q: %w", name, loaderStatusError(rc))
+// ProcAddressByName returns the address of the named export in the mapped
+// image. The leading underscore of C symbol names is optional.
+func (module *Module) ProcAddressByName(name string) (uintptr, error) {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+	return module.procAddressByNameLocked(name)
+}
+
+func (module *Module) procAddressByNameLocked(name string) (uintptr, error) {
+	if ordinal, ok, err := parseOrdinalName(name); ok {
+		if err != nil {
+			return 0, err
+		}
+		return module.procAddressByOrdinalLocked(ordinal)
 	}
+	symbol, err := normalizeMachOSymbol(name)
+	if err != nil {
+		return 0, err
+	}
+	if module.closed {
+		return 0, errDarwinLibraryClosed
+	}
+	if module.mapped.loadAddress == 0 {
+		return 0, errors.New("library image is not mapped")
+	}
+	addr := findSymbol(module.mapped.loadAddress, symbol, uint64(module.mapped.slide))
+	if addr == 0 {
+		return 0, fmt.Errorf("%w: %q", ErrSymbolNotFound, name)
+	}
+	return addr, nil
+}
+
+// FindSymbol looks name up in the image's own exports, then in each dylib it
+// links against, in load command order. Only dependencies in the dyld shared
+// cache are searched, which covers the system libraries.
+func (module *Module) FindSymbol(name string) (uintptr, error) {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	addr, err := module.procAddressByNameLocked(name)
+	if err == nil || module.closed {
+		return addr, err
+	}
+	symbol, err := normalizeMachOSymbol(name)
+	if err != nil {
+		return 0, err
+	}
+	f, err := macho.NewFile(bytes.NewReader(module.image))
+	if err != nil {
+		return 0, fmt.Errorf("parse Mach-O image: %w", err)
+	}
+	defer f.Close()
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return 0, fmt.Errorf("read Mach-O dependencies: %w", err)
+	}
+	for _, lib := range libs {
+		if addr := resolveLibSystemSymbol(symbol, lib); addr != 0 {
+			return addr, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q is not in the image or its dependencies", ErrSymbolNotFound, name)
+}
+
+// Resource is not supported: Mach-O images have no resource directory.
+func (module *Module) Resource(resType, name string) ([]byte, error) {
+	return nil, errors.New("resources are only supported in PE images")
+}
+
+// Relocations returns nil: dyld applies darwin fixups, so
+// LoadOptions.VerifyRelocations does not apply.
+func (module *Module) Relocations() *RelocationCheck {
 	return nil
 }
 
-// ProcAddressByName is not supported by the darwin loader path.
-func (module *Module) ProcAddressByName(name string) (uintptr, error) {
-	_ = name
-	return 0, errors.New("ProcAddressByName is not supported on darwin; use CallExport")
+// ResourceUsage returns nil: LoadOptions.TrackResources is windows only.
+func (module *Module) ResourceUsage() *ResourceUsage {
+	return nil
+}
+
+// Symbols is not supported by the darwin loader path; use Exports.
+func (module *Module) Symbols() ([]Symbol, error) {
+	return nil, errors.New("Symbols is not supported on darwin; use Exports")
 }
 
-// ProcAddressByOrdinal is not supported by the darwin loader path.
+// ProcAddressByOrdinal returns the address of the export with the given
+// emulated ordinal: Mach-O has no ordinals, so ordinal N is the Nth name of
+// Exports, counting from 1.
 func (module *Module) ProcAddressByOrdinal(ordinal uint16) (uintptr, error) {
-	_ = ordinal
-	return 0, errors.New("ProcAddressByOrdinal is not supported on darwin; use CallExport")
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+	return module.procAddressByOrdinalLocked(ordinal)
+}
+
+func (module *Module) procAddressByOrdinalLocked(ordinal uint16) (uintptr, error) {
+	if module.closed {
+		return 0, errDarwinLibraryClosed
+	}
+	names, err := module.exportsLocked()
+	if err != nil {
+		return 0, err
+	}
+	name, err := emulatedOrdinalName(names, ordinal)
+	if err != nil {
+		return 0, err
+	}
+	return module.procAddressByNameLocked(name)
 }
 
 type dyldCacheHeader struct {
@@ -301,22 +618,49 @@ type dyldCacheDataConstLazyScopedWriter struct {
 type mappedImage struct {
 	mapping     []byte
 	loadAddress uintptr
+	// slide is the difference between the load address and the __TEXT
+	// segment's link-time address.
+	slide uintptr
+	// scratch holds the structures handed to dyld while linking the image.
+	scratch []byte
+	// topLoader is dyld's loader for the image and apis its RuntimeState.
+	topLoader uintptr
+	apis      uintptr
+	// decDlRefCount is RuntimeState::decDlRefCount, or zero when this dyld
+	// does not export it.
+	decDlRefCount uintptr
+	// runInitializers is Loader::runInitializers, kept for images loaded
+	// without their initializers.
+	runInitializers uintptr
+	// initialized is set when the image's initializers ran, and gates its
+	// terminators.
+	initialized bool
+	// selfLinked is set for images DarwinSelfFixup linked, which dyld does
+	// not know. dependencies are their dylibs' dlopen handles, in load
+	// command order, with zero for weak dylibs that were not found.
+	selfLinked   bool
+	dependencies []uintptr
 }
 
-func memmodLoader(bufferRO []byte, entrySymbol string) int {
-	if len(bufferRO) == 0 || entrySymbol == "" {
-		return 1
+// memmodLoader maps bufferRO, registers it with dyld under path, and runs its
+// initializers when initialize is set, logging its progress to logger. dyld
+// keeps a loader that points into the mapping, so the returned image must
+// stay mapped until unloadImage has dropped it. An empty path names the image
+// after its buffer.
+func memmodLoader(bufferRO []byte, path string, initialize bool, logger *slog.Logger) (mappedImage, error) {
+	if len(bufferRO) == 0 {
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "empty image")
 	}
 
 	sharedRegionStart, err := sharedRegionStartAddr()
-	if err != nil || sharedRegionStart == 0 {
-		return 2
+	if err != nil {
+		return mappedImage{}, darwinLoaderError(LoaderStageDyldCache, err.Error())
 	}
 
 	header := (*dyldCacheHeader)(unsafe.Pointer(sharedRegionStart))
 	sfm := (*sharedFileMapping)(unsafe.Pointer(sharedRegionStart + uintptr(header.MappingOffset)))
 	if sfm == nil {
-		return 2
+		return mappedImage{}, darwinLoaderError(LoaderStageDyldCache, "shared cache has no mappings")
 	}
 
 	imagesCount := header.ImagesCountOld
@@ -328,25 +672,24 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 		imagesOffset = header.ImagesOffset
 	}
 	if imagesCount == 0 || imagesOffset == 0 {
-		return 2
+		return mappedImage{}, darwinLoaderError(LoaderStageDyldCache, "shared cache has no image list")
 	}
 
 	slide := uint64(sharedRegionStart) - sfm.Address
 
 	libdyld := findCacheImage(sharedRegionStart, header, "/usr/lib/system/libdyld.dylib", slide)
 	if libdyld == 0 {
-		return 2
+		return mappedImage{}, darwinLoaderError(LoaderStageDyldCache, "/usr/lib/system/libdyld.dylib not found")
 	}
 	dyld := findCacheImage(sharedRegionStart, header, "/usr/lib/dyld", slide)
 	if dyld == 0 {
-		return 2
+		return mappedImage{}, darwinLoaderError(LoaderStageDyldCache, "/usr/lib/dyld not found")
 	}
 
 	apis := resolveDyldRuntimeAPIs(libdyld, slide)
 	if apis == 0 {
-		return 3
+		return mappedImage{}, darwinLoaderError(LoaderStageRuntimeAPI, "")
 	}
-	setDarwinLoaderDetail("")
 
 	buffer := bufferRO
 
@@ -381,6 +724,31 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 			"RuntimeState13incDlRefCount",
 		)
 	}
+	// decDlRefCount is only needed by Unload, so a dyld without it can still
+	// load images.
+	decDlRefCount := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld",
+		"__ZN5dyld412RuntimeState13decDlRefCountEPKNS_6LoaderE",
+	)
+	if decDlRefCount == 0 {
+		decDlRefCount = findFirstMatchingSymbol(uintptr(dyld), slide, "/usr/lib/dyld",
+			"RuntimeState13decDlRefCount",
+		)
+	}
+	// notifyLoad hands new images to the add-image callbacks, which is how
+	// the Swift runtime finds their conformance and type metadata sections,
+	// and to the Objective-C runtime, as dlopen does before initializers run.
+	// Its argument was a dyld3::Array before it became a std::span, so the
+	// name that resolves says which to build. Images without Swift or
+	// Objective-C metadata load without it.
+	notifyLoadSpan := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld",
+		"__ZN5dyld412RuntimeState10notifyLoadERKNSt3__14spanIPKNS_6LoaderELm18446744073709551615EEE",
+	)
+	notifyLoadArray := uintptr(0)
+	if notifyLoadSpan == 0 {
+		notifyLoadArray = findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld",
+			"__ZN5dyld412RuntimeState10notifyLoadERKN5dyld35ArrayIPKNS_6LoaderEEE",
+		)
+	}
 	runInitializers := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld",
 		"__ZNK5dyld46Loader38runInitializersBottomUpPlusUpwardLinksERNS_12RuntimeStateE",
 		"__ZNK5dyld46Loader15runInitializersERNS_12RuntimeStateE",
@@ -494,24 +862,23 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 		missing = append(missing, "Diagnostics::hasError")
 	}
 	if len(missing) != 0 {
-		setDarwinLoaderDetail(strings.Join(missing, ", "))
-		return 4
+		return mappedImage{}, darwinLoaderError(LoaderStageSymbols, strings.Join(missing, ", "))
 	}
-	setDarwinLoaderDetail("")
 
 	memoryManager := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld", "__ZN3lsl13MemoryManager13memoryManagerEv")
 	lockLock := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld", "__ZN3lsl4Lock4lockEv")
 	writeProtect := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld", "__ZN3lsl13MemoryManager12writeProtectEb")
 	lockUnlock := findFirstAvailableSymbol(uintptr(dyld), slide, "/usr/lib/dyld", "__ZN3lsl4Lock6unlockEv")
 
-	mapped, rc := mapMachOImage(buffer)
-	if rc != 0 {
-		return rc
+	mapped, err := mapMachOImage(buffer)
+	if err != nil {
+		return mappedImage{}, err
 	}
+	trace(logger, stageMap, "mapped image", addrAttr("base", mapped.loadAddress), "size", len(mapped.mapping))
 
-	scratch, mapErr := unix.Mmap(-1, 0, dyldScratchSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
-	if mapErr != nil || len(scratch) < dyldScratchSize {
-		return 7
+	scratch, err := unix.Mmap(-1, 0, dyldScratchSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return mappedImage{}, darwinLoaderError(LoaderStageMapping, fmt.Sprintf("dyld scratch space: %v", err))
 	}
 	structspace := uintptr(unsafe.Pointer(&scratch[0]))
 
@@ -543,10 +910,14 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 	loaded := (*loadedVector)(unsafe.Pointer(apis + 32))
 	startLoaderCount := loaded.Size
 
-	entryName, err := cStringBytes(fmt.Sprintf("memmod-%x-%x", uintptr(unsafe.Pointer(&buffer[0])), len(buffer)))
+	if path == "" {
+		path = fmt.Sprintf("memmod-%x-%x", uintptr(unsafe.Pointer(&buffer[0])), len(buffer))
+	}
+	arena := getArena()
+	defer arena.release()
+	entryName, err := arena.cString(path)
 	if err != nil {
-		setDarwinLoaderDetail("failed to build temporary loader name")
-		return 8
+		return mappedImage{}, darwinLoaderError(LoaderStageRegister, "failed to build temporary loader name")
 	}
 
 	enteredWritable := false
@@ -570,7 +941,7 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 		justInTimeLoaderMake2,
 		apis,
 		mapped.loadAddress,
-		cStringPtr(entryName),
+		entryName,
 		uintptr(unsafe.Pointer(fileid)),
 		0,
 		0,
@@ -579,21 +950,12 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 		0,
 		0,
 	)
-	runtime.KeepAlive(entryName)
 	if diagnosticsReady && call1(diagnosticsHasError, uintptr(diag)) != 0 {
-		msg := diagnosticsMessage(diag, diagnosticsErrorMessage)
-		if msg != "" {
-			setDarwinLoaderDetail(fmt.Sprintf("JustInTimeLoader::make returned diagnostics error: %s", msg))
-		} else {
-			setDarwinLoaderDetail("JustInTimeLoader::make returned diagnostics error")
-		}
-		return 8
+		return mappedImage{}, diagnosticsError(LoaderStageRegister, "JustInTimeLoader::make", diagnosticsMessage(diag, diagnosticsErrorMessage))
 	}
 	if topLoader == 0 {
-		setDarwinLoaderDetail("JustInTimeLoader::make returned null loader")
-		return 8
+		return mappedImage{}, darwinLoaderError(LoaderStageRegister, "JustInTimeLoader::make returned null loader")
 	}
-	setDarwinLoaderDetail("")
 	*rtopLoader = topLoader
 	// Mark the top loader as lateLeaveMapped, matching the C loader path.
 	partialFlags := (*uint64)(unsafe.Pointer(topLoader + 16))
@@ -622,52 +984,245 @@ func memmodLoader(bufferRO []byte, entrySymbol string) int {
 	}
 	call4(loadDependents, topLoader, uintptr(diag), apis, uintptr(unsafe.Pointer(depOptions)))
 	if diagnosticsReady && call1(diagnosticsHasError, uintptr(diag)) != 0 {
-		if msg := diagnosticsMessage(diag, diagnosticsErrorMessage); msg != "" {
-			setDarwinLoaderDetail(fmt.Sprintf("Loader::loadDependents reported diagnostics error: %s", msg))
-		} else {
-			setDarwinLoaderDetail("Loader::loadDependents reported diagnostics error")
-		}
-		return 9
+		return mappedImage{}, diagnosticsError(LoaderStageLink, "Loader::loadDependents", diagnosticsMessage(diag, diagnosticsErrorMessage))
 	}
 
 	newLoadersCount := loaded.Size - startLoaderCount
 	if newLoadersCount != 0 {
-		dcd := dyldCacheDataConstLazyScopedWriter{State: apis}
+		dcdAddr, err := arena.alloc(unsafe.Sizeof(dyldCacheDataConstLazyScopedWriter{}), unsafe.Alignof(dyldCacheDataConstLazyScopedWriter{}))
+		if err != nil {
+			return mappedImage{}, darwinLoaderError(LoaderStageLink, "failed to allocate the data-const writer")
+		}
+		dcd := (*dyldCacheDataConstLazyScopedWriter)(unsafe.Pointer(dcdAddr))
+		dcd.State = apis
 		for i := uintptr(0); i < newLoadersCount; i++ {
 			ldr := loadedElement(loaded, startLoaderCount+i)
-			call6(applyFixups, ldr, uintptr(diag), apis, uintptr(unsafe.Pointer(&dcd)), 1, 0)
+			call6(applyFixups, ldr, uintptr(diag), apis, dcdAddr, 1, 0)
 		}
 		if diagnosticsReady && call1(diagnosticsHasError, uintptr(diag)) != 0 {
-			if msg := diagnosticsMessage(diag, diagnosticsErrorMessage); msg != "" {
-				setDarwinLoaderDetail(fmt.Sprintf("Loader::applyFixups reported diagnostics error: %s", msg))
-			} else {
-				setDarwinLoaderDetail("Loader::applyFixups reported diagnostics error")
-			}
-			return 9
+			return mappedImage{}, diagnosticsError(LoaderStageLink, "Loader::applyFixups", diagnosticsMessage(diag, diagnosticsErrorMessage))
 		}
 	}
-
-	setDarwinLoaderDetail("")
+	if err := protectConstSegments(mapped.loadAddress); err != nil {
+		return mappedImage{}, darwinLoaderError(LoaderStageMapping, err.Error())
+	}
+	// The new loaders are the image's own and one per dependency dyld had
+	// not loaded yet.
+	trace(logger, stageRelocate, "dyld linked image", "loaders", newLoadersCount)
+
+	// dladdr, _dyld_get_image_name, and crash reporters find images through
+	// dyld's loaded list, so the image must be on it before any of its code
+	// runs.
+	if !loaderRegistered(apis, topLoader) {
+		return mappedImage{}, darwinLoaderError(LoaderStageRegister, "loader for the image is not registered with dyld")
+	}
+	if newLoadersCount != 0 && (notifyLoadSpan != 0 || notifyLoadArray != 0) {
+		// The loaders are copied out of dyld's list, which a callback that
+		// loads another image can grow and move.
+		ptrSize := unsafe.Sizeof(uintptr(0))
+		list, err := arena.alloc(newLoadersCount*ptrSize, ptrSize)
+		if err != nil {
+			return mappedImage{}, darwinLoaderError(LoaderStageLink, "failed to allocate the new loader list")
+		}
+		for i := uintptr(0); i < newLoadersCount; i++ {
+			*(*uintptr)(unsafe.Pointer(list + i*ptrSize)) = loadedElement(loaded, startLoaderCount+i)
+		}
+		// std::span is {data, size}; dyld3::Array is {elements, allocCount,
+		// usedCount}.
+		words, notifyLoad := uintptr(2), notifyLoadSpan
+		if notifyLoad == 0 {
+			words, notifyLoad = 3, notifyLoadArray
+		}
+		header, err := arena.alloc(words*ptrSize, ptrSize)
+		if err != nil {
+			return mappedImage{}, darwinLoaderError(LoaderStageLink, "failed to allocate the new loader list")
+		}
+		fields := unsafe.Slice((*uintptr)(unsafe.Pointer(header)), words)
+		fields[0] = list
+		for i := uintptr(1); i < words; i++ {
+			fields[i] = newLoadersCount
+		}
+		call2(notifyLoad, apis, header)
+	}
 	call2(incDlRefCount, apis, topLoader)
-	call2(runInitializers, topLoader, apis)
+	if initialize {
+		trace(logger, stageInitialize, "running initializers")
+		call2(runInitializers, topLoader, apis)
+		mapped.initialized = true
+	}
 
 	loadedText := findLoadedTextSegment(mapped.loadAddress)
 	if loadedText == nil {
-		return 10
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "no __TEXT segment in the loaded image")
 	}
 	if mapped.loadAddress < uintptr(loadedText.VMAddr) {
-		return 11
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid loaded image slide")
+	}
+	mapped.slide = mapped.loadAddress - uintptr(loadedText.VMAddr)
+	mapped.scratch = scratch
+	mapped.topLoader = topLoader
+	mapped.apis = apis
+	mapped.decDlRefCount = decDlRefCount
+	mapped.runInitializers = runInitializers
+	return mapped, nil
+}
+
+// initializeImage runs the initializers of an image that was linked without
+// them, with dyld or, for self-linked images, as selfFixupLoader would have.
+func initializeImage(mapped *mappedImage, image []byte) error {
+	if mapped.selfLinked {
+		f, err := macho.NewFile(bytes.NewReader(image))
+		if err != nil {
+			return fmt.Errorf("parse Mach-O image: %w", err)
+		}
+		defer f.Close()
+		if err := runSelfInitializers(f, mapped); err != nil {
+			return err
+		}
+	} else {
+		call2(mapped.runInitializers, mapped.topLoader, mapped.apis)
+	}
+	mapped.initialized = true
+	return nil
+}
+
+// unloadImage runs the image's __mod_term_func terminators, which dyld4
+// leaves to the image, then drops the reference memmodLoader took with
+// incDlRefCount. When that was the last reference, dyld runs the atexit
+// handlers and C++ destructors the image registered and forgets its loader.
+// It reports whether the loader is gone, so the mapping can be released.
+func unloadImage(mapped mappedImage) (bool, error) {
+	if mapped.selfLinked {
+		return true, unloadSelfLinked(mapped)
+	}
+	if mapped.topLoader == 0 {
+		return false, nil
+	}
+
+	if mapped.initialized {
+		runTerminators(mapped)
+	}
+
+	if mapped.decDlRefCount == 0 {
+		return false, errors.New("unload Mach-O image: RuntimeState::decDlRefCount not found; image left loaded")
+	}
+	call2(mapped.decDlRefCount, mapped.apis, mapped.topLoader)
+
+	return !loaderRegistered(mapped.apis, mapped.topLoader), nil
+}
+
+// runTerminators runs the image's __mod_term_func terminators in reverse.
+func runTerminators(mapped mappedImage) {
+	terms, size := findSectionRange(uint64(mapped.loadAddress), "__mod_term_func", uint64(mapped.slide))
+	if terms == 0 {
+		return
+	}
+	stride := unsafe.Sizeof(uintptr(0))
+	for i := uintptr(size) / stride; i > 0; i-- {
+		if fn := *(*uintptr)(unsafe.Pointer(terms + (i-1)*stride)); fn != 0 {
+			call0(fn)
+		}
+	}
+}
+
+// loaderRegistered reports whether ldr is on dyld's list of loaded images.
+func loaderRegistered(apis, ldr uintptr) bool {
+	loaded := (*loadedVector)(unsafe.Pointer(apis + 32))
+	for i := uintptr(0); i < loaded.Size; i++ {
+		if loadedElement(loaded, i) == ldr {
+			return true
+		}
+	}
+	return false
+}
+
+// machOInstallName returns the install name in a dylib's LC_ID_DYLIB, or ""
+// for images without one, such as bundles.
+func machOInstallName(image []byte) string {
+	f, err := macho.NewFile(bytes.NewReader(image))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 12 || f.ByteOrder.Uint32(raw) != lcIDDylib {
+			continue
+		}
+		offset := f.ByteOrder.Uint32(raw[8:])
+		if offset >= uint32(len(raw)) {
+			return ""
+		}
+		return fixedCString(raw[offset:])
+	}
+	return ""
+}
+
+var (
+	errnoLocationOnce sync.Once
+	errnoLocation     uintptr
+
+	threadIDOnce sync.Once
+	threadIDFn   uintptr
+)
+
+// currentThreadID returns the calling thread's system-wide id, as
+// pthread_threadid_np reports it, or zero when libSystem does not export it.
+func currentThreadID() uint64 {
+	threadIDOnce.Do(func() {
+		threadIDFn = resolveLibSystemSymbol("_pthread_threadid_np", "/usr/lib/system/libsystem_pthread.dylib")
+	})
+	if threadIDFn == 0 {
+		return 0
+	}
+	id := new(uint64)
+	call6(threadIDFn, 0, uintptr(unsafe.Pointer(id)), 0, 0, 0, 0)
+	return *id
+}
+
+// callEntry calls fn with args and captures errno through libc's __error. The goroutine
+// stays on one thread so the thread-local errno read belongs to the call.
+func callEntry(fn uintptr, args [MaxCallArgs]uintptr) CallResult {
+	errnoLocationOnce.Do(func() {
+		errnoLocation = resolveLibSystemSymbol("___error",
+			"/usr/lib/system/libsystem_kernel.dylib",
+			"/usr/lib/system/libsystem_c.dylib",
+		)
+	})
+	if errnoLocation == 0 {
+		return CallResult{Value: call6(fn, args[0], args[1], args[2], args[3], args[4], args[5])}
 	}
-	imageSlide := mapped.loadAddress - uintptr(loadedText.VMAddr)
-	addrEntry := findSymbol(mapped.loadAddress, entrySymbol, uint64(imageSlide))
-	if addrEntry == 0 {
-		return 12
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	errno := (*int32)(unsafe.Pointer(call0(errnoLocation)))
+	*errno = 0
+	value := call6(fn, args[0], args[1], args[2], args[3], args[4], args[5])
+	return CallResult{Value: value, Errno: syscall.Errno(*errno)}
+}
+
+// resolveLibSystemSymbol looks up an exported libSystem symbol in the first of
+// paths that defines it, using the dyld shared cache and falling back to the
+// on-disk image.
+func resolveLibSystemSymbol(symbol string, paths ...string) uintptr {
+	sharedRegionStart, err := sharedRegionStartAddr()
+	if err != nil {
+		return 0
 	}
+	header := (*dyldCacheHeader)(unsafe.Pointer(sharedRegionStart))
+	sfm := (*sharedFileMapping)(unsafe.Pointer(sharedRegionStart + uintptr(header.MappingOffset)))
+	slide := uint64(sharedRegionStart) - sfm.Address
 
-	call0(addrEntry)
-	// Keep mapped and scratch memory reachable until after entry returns.
-	runtime.KeepAlive(mapped.mapping)
-	runtime.KeepAlive(scratch)
+	for _, path := range paths {
+		image := findCacheImage(sharedRegionStart, header, path, slide)
+		if image == 0 {
+			continue
+		}
+		if addr := findFirstAvailableSymbol(uintptr(image), slide, path, symbol); addr != 0 {
+			return addr
+		}
+	}
 	return 0
 }
 
@@ -683,14 +1238,14 @@ func sharedRegionStartAddr() (uintptr, error) {
 	return address, nil
 }
 
-func mapMachOImage(data []byte) (mappedImage, int) {
+func mapMachOImage(data []byte) (mappedImage, error) {
 	if len(data) == 0 {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "empty image")
 	}
 
 	f, err := macho.NewFile(bytes.NewReader(data))
 	if err != nil {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, err.Error())
 	}
 	defer f.Close()
 
@@ -721,20 +1276,20 @@ func mapMachOImage(data []byte) (mappedImage, int) {
 		}
 	}
 	if textSeg == nil {
-		return mappedImage{}, 10
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "no __TEXT segment")
 	}
 	if minVM == math.MaxUint64 || maxVM <= minVM {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 	}
 
 	vmSpace := maxVM - minVM
 	if vmSpace == 0 || vmSpace > uint64(math.MaxInt) {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 	}
 
-	mapped, mmapErr := unix.Mmap(-1, 0, int(vmSpace), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
-	if mmapErr != nil || len(mapped) == 0 {
-		return mappedImage{}, 6
+	mapped, err := unix.Mmap(-1, 0, int(vmSpace), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return mappedImage{}, darwinLoaderError(LoaderStageMapping, err.Error())
 	}
 	base := uintptr(unsafe.Pointer(&mapped[0]))
 	imageBase := base - uintptr(minVM)
@@ -744,10 +1299,10 @@ func mapMachOImage(data []byte) (mappedImage, int) {
 			continue
 		}
 		if seg.Offset > uint64(len(data)) || seg.Filesz > uint64(len(data))-seg.Offset {
-			return mappedImage{}, 5
+			return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 		}
 		if seg.Filesz > uint64(math.MaxInt) {
-			return mappedImage{}, 5
+			return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 		}
 		dst := imageBase + uintptr(seg.Addr)
 		sz := int(seg.Filesz)
@@ -770,23 +1325,23 @@ func mapMachOImage(data []byte) (mappedImage, int) {
 		}
 		protLen := pageEnd - pageStart
 		if protLen > uintptr(math.MaxInt) {
-			return mappedImage{}, 6
+			return mappedImage{}, darwinLoaderError(LoaderStageMapping, fmt.Sprintf("segment %s is too large", seg.Name))
 		}
 		protSlice := unsafe.Slice((*byte)(unsafe.Pointer(pageStart)), int(protLen))
 		if err := unix.Mprotect(protSlice, int(seg.Prot)); err != nil {
-			return mappedImage{}, 6
+			return mappedImage{}, darwinLoaderError(LoaderStageMapping, fmt.Sprintf("protect %s: %v", seg.Name, err))
 		}
 	}
 
 	if textSeg.Offset > textSeg.Addr+vmSpace {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 	}
 	loadAddress := imageBase + uintptr(textSeg.Addr) - uintptr(textSeg.Offset)
 	if loadAddress < base || loadAddress >= base+uintptr(len(mapped)) {
-		return mappedImage{}, 5
+		return mappedImage{}, darwinLoaderError(LoaderStageImage, "invalid segment layout")
 	}
 
-	return mappedImage{mapping: mapped, loadAddress: loadAddress}, 0
+	return mappedImage{mapping: mapped, loadAddress: loadAddress}, nil
 }
 
 func alignDown(v, a uintptr) uintptr {
@@ -852,6 +1407,13 @@ func findSection(base uint64, segName string, sectName string, slide uint64) uin
 }
 
 func findSectionAnySegment(base uint64, sectName string, slide uint64) uintptr {
+	addr, _ := findSectionRange(base, sectName, slide)
+	return addr
+}
+
+// findSectionRange is like findSectionAnySegment but also returns the
+// section's size.
+func findSectionRange(base uint64, sectName string, slide uint64) (uintptr, uint64) {
 	mh := (*machHeader64)(unsafe.Pointer(uintptr(base)))
 	lc := uintptr(base) + unsafe.Sizeof(machHeader64{})
 
@@ -863,13 +1425,13 @@ func findSectionAnySegment(base uint64, sectName string, slide uint64) uintptr {
 			for j := uint32(0); j < seg.NSects; j++ {
 				s := (*section64)(unsafe.Pointer(sect + uintptr(j)*unsafe.Sizeof(section64{})))
 				if fixedCString(s.SectName[:]) == sectName {
-					return uintptr(s.Addr + slide)
+					return uintptr(s.Addr + slide), s.Size
 				}
 			}
 		}
 		lc += uintptr(cmd.CmdSize)
 	}
-	return 0
+	return 0, 0
 }
 
 func resolveDyldRuntimeAPIs(libdyld uint64, slide uint64) uintptr {
@@ -1207,6 +1769,39 @@ func findLoadedTextSegment(base uintptr) *segmentCommand64 {
 	return nil
 }
 
+// protectConstSegments makes the segments of the image at base that are
+// flagged SG_READ_ONLY, or named __DATA_CONST or __AUTH_CONST, read-only. They
+// are mapped writable so fixups can be applied, and dyld drops write access
+// once they are, before any initializer runs.
+func protectConstSegments(base uintptr) error {
+	text := findLoadedTextSegment(base)
+	if text == nil {
+		return errors.New("image has no __TEXT segment")
+	}
+	slide := base - uintptr(text.VMAddr)
+	pageSize := uintptr(unix.Getpagesize())
+
+	mh := (*machHeader64)(unsafe.Pointer(base))
+	lc := base + unsafe.Sizeof(machHeader64{})
+	for i := uint32(0); i < mh.NCmds; i++ {
+		cmd := (*loadCommand)(unsafe.Pointer(lc))
+		if cmd.Cmd == lcSegment64 {
+			seg := (*segmentCommand64)(unsafe.Pointer(lc))
+			name := fixedCString(seg.SegName[:])
+			if seg.VMSize != 0 && (seg.Flags&sgReadOnly != 0 || name == "__DATA_CONST" || name == "__AUTH_CONST") {
+				start := alignDown(slide+uintptr(seg.VMAddr), pageSize)
+				end := alignUp(slide+uintptr(seg.VMAddr+seg.VMSize), pageSize)
+				prot := int(seg.InitProt) &^ unix.PROT_WRITE
+				if err := unix.Mprotect(unsafe.Slice((*byte)(unsafe.Pointer(start)), int(end-start)), prot); err != nil {
+					return fmt.Errorf("mprotect %s read-only: %w", name, err)
+				}
+			}
+		}
+		lc += uintptr(cmd.CmdSize)
+	}
+	return nil
+}
+
 func fixedCString(buf []byte) string {
 	end := 0
 	for end < len(buf) && buf[end] != 0 {
@@ -1269,7 +1864,7 @@ func exitWritableDyldStateLock(mm, lockFn, writeProtectFn, unlockFn uintptr) {
 	call1(unlockFn, mm)
 }
 
-func selectCurrentArchMachOSlice(data []byte) ([]byte, error) {
+func selectCurrentArchMachOSlice(data []byte, logger *slog.Logger) ([]byte, error) {
 	cpu, err := currentMachOCPU()
 	if err != nil {
 		return nil, err
@@ -1277,10 +1872,17 @@ func selectCurrentArchMachOSlice(data []byte) ([]byte, error) {
 
 	if fat, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
 		defer fat.Close()
-		for _, arch := range fat.Arches {
-			if arch.Cpu != cpu {
-				continue
+		// Go builds plain arm64 processes, so a plain arm64 slice wins over
+		// an arm64e one; an arm64e-only image still loads, its
+		// authenticated pointers signed with this process's keys.
+		var chosen *macho.FatArch
+		for i := range fat.Arches {
+			arch := &fat.Arches[i]
+			if arch.Cpu == cpu && (chosen == nil || isARM64E(chosen.SubCpu) && !isARM64E(arch.SubCpu)) {
+				chosen = arch
 			}
+		}
+		if arch := chosen; arch != nil {
 			offset := int(arch.Offset)
 			size := int(arch.Size)
 			if offset < 0 || size <= 0 || offset+size > len(data) {
@@ -1290,9 +1892,10 @@ func selectCurrentArchMachOSlice(data []byte) ([]byte, error) {
 			if err := validateThinMachO(slice, cpu); err != nil {
 				return nil, err
 			}
+			trace(logger, stageArch, "selected fat slice", "cpu", cpu.String(), "arm64e", isARM64E(arch.SubCpu), "offset", offset, "size", size, "slices", len(fat.Arches))
 			return slice, nil
 		}
-		return nil, fmt.Errorf("foreign platform: no %s slice in fat Mach-O", cpu)
+		return nil, fmt.Errorf("%w: no %s slice in fat Mach-O", ErrForeignPlatform, cpu)
 	}
 
 	if err := validateThinMachO(data, cpu); err != nil {
@@ -1309,7 +1912,13 @@ func validateThinMachO(data []byte, expectedCPU macho.Cpu) error {
 	defer file.Close()
 
 	if file.Cpu != expectedCPU {
-		return fmt.Errorf("foreign platform (provided: %s, expected: %s)", file.Cpu, expectedCPU)
+		return fmt.Errorf("%w (provided: %s, expected: %s)", ErrForeignPlatform, file.Cpu, expectedCPU)
+	}
+	if err := machOEncryption(file); err != nil {
+		return err
+	}
+	if err := checkMachOLayout(file, uint64(len(data))); err != nil {
+		return err
 	}
 	switch file.Type {
 	case macho.TypeDylib, macho.TypeBundle:
@@ -1319,6 +1928,43 @@ func validateThinMachO(data []byte, expectedCPU macho.Cpu) error {
 	}
 }
 
+// machOSpan returns the address range the mapped segments of a thin Mach-O
+// image cover, as mapped by each loader call.
+func machOSpan(data []byte) (uint64, error) {
+	file, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Mach-O image: %w", err)
+	}
+	defer file.Close()
+
+	var minVM, maxVM uint64 = math.MaxUint64, 0
+	for _, load := range file.Loads {
+		seg, ok := load.(*macho.Segment)
+		if !ok || seg.Memsz == 0 {
+			continue
+		}
+		minVM = min(minVM, seg.Addr)
+		maxVM = max(maxVM, seg.Addr+seg.Memsz)
+	}
+	if maxVM <= minVM {
+		return 0, errors.New("Mach-O image has no mapped segments")
+	}
+	return maxVM - minVM, nil
+}
+
+const (
+	// machOSubtypeMask strips the capability bits from a cpu subtype.
+	machOSubtypeMask = 0x00ffffff
+	// machOSubtypeARM64E is CPU_SUBTYPE_ARM64E.
+	machOSubtypeARM64E = 2
+)
+
+// isARM64E reports whether an arm64 cpu subtype is arm64e, whose images use
+// pointer authentication.
+func isARM64E(subCPU uint32) bool {
+	return subCPU&machOSubtypeMask == machOSubtypeARM64E
+}
+
 func currentMachOCPU() (macho.Cpu, error) {
 	switch runtime.GOARCH {
 	case "arm64":
@@ -1344,68 +1990,16 @@ func normalizeMachOSymbol(name string) (string, error) {
 	return name, nil
 }
 
-func cStringBytes(s string) ([]byte, error) {
-	if strings.ContainsRune(s, '\x00') {
-		return nil, errors.New("string contains NUL")
-	}
-	b := make([]byte, len(s)+1)
-	copy(b, s)
-	return b, nil
+// darwinLoaderError returns a LoaderError for a failure at stage.
+func darwinLoaderError(stage LoaderStage, detail string) error {
+	return &LoaderError{Stage: stage, Detail: detail, Platform: "darwin"}
 }
 
-func cStringPtr(b []byte) uintptr {
-	if len(b) == 0 {
-		return 0
-	}
-	return uintptr(unsafe.Pointer(&b[0]))
-}
-
-func setDarwinLoaderDetail(detail string) {
-	darwinLoaderDetailMu.Lock()
-	defer darwinLoaderDetailMu.Unlock()
-	darwinLoaderDetail = detail
-}
-
-func getDarwinLoaderDetail() string {
-	darwinLoaderDetailMu.Lock()
-	defer darwinLoaderDetailMu.Unlock()
-	return darwinLoaderDetail
-}
-
-func loaderStatusError(code int) error {
-	switch code {
-	case 2:
-		return errors.New("failed to locate required dyld cache images")
-	case 3:
-		return errors.New("failed to resolve dyld runtime API section")
-	case 4:
-		if detail := getDarwinLoaderDetail(); detail != "" {
-			return fmt.Errorf("failed to resolve required dyld symbols: %s", detail)
-		}
-		return errors.New("failed to resolve required dyld symbols")
-	case 5:
-		return errors.New("failed to analyze Mach-O VM layout")
-	case 6:
-		return errors.New("failed to allocate mapped image space")
-	case 7:
-		return errors.New("failed to allocate dyld scratch space")
-	case 8:
-		if detail := getDarwinLoaderDetail(); detail != "" {
-			return fmt.Errorf("failed to create top-level dyld loader: %s", detail)
-		}
-		return errors.New("failed to create top-level dyld loader")
-	case 9:
-		if detail := getDarwinLoaderDetail(); detail != "" {
-			return fmt.Errorf("failed to load dependents or apply fixups: %s", detail)
-		}
-		return errors.New("failed to load dependents or apply fixups")
-	case 10:
-		return errors.New("failed to find __TEXT segment in loaded image")
-	case 11:
-		return errors.New("invalid loaded image slide")
-	case 12:
-		return errors.New("export symbol not found")
-	default:
-		return fmt.Errorf("in-memory dyld loader failed with status %d", code)
+// diagnosticsError returns a LoaderError for a dyld call that reported msg
+// through its Diagnostics.
+func diagnosticsError(stage LoaderStage, call, msg string) error {
+	if msg == "" {
+		return darwinLoaderError(stage, call+" reported a diagnostics error")
 	}
+	return darwinLoaderError(stage, fmt.Sprintf("%s reported a diagnostics error: %s", call, msg))
 }
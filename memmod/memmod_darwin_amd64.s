@@ -12,7 +12,9 @@ TEXT ·cCall10(SB), NOSPLIT, $0-96
 	MOVQ a5+48(FP), R9
 
 	// Keep SysV stack alignment before calling C++ dyld internals:
-	// reserve 32 bytes for stack args (a6-a9) plus 8 bytes padding.
+	// reserve 32 bytes for stack args (a6-a9) plus 8 bytes that hold MXCSR
+	// and the x87 control word, restored after the call because Go code
+	// assumes round-to-nearest and masked exceptions.
 	SUBQ $40, SP
 	MOVQ a6+56(FP), R10
 	MOVQ R10, 0(SP)
@@ -22,9 +24,14 @@ TEXT ·cCall10(SB), NOSPLIT, $0-96
 	MOVQ R10, 16(SP)
 	MOVQ a9+80(FP), R10
 	MOVQ R10, 24(SP)
+	STMXCSR 32(SP)
+	FSTCW 36(SP)
+	CLD
 
 	CALL AX
 
+	LDMXCSR 32(SP)
+	FLDCW 36(SP)
 	ADDQ $40, SP
 	MOVQ AX, ret+88(FP)
 	RET
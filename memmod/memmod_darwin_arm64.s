@@ -17,7 +17,11 @@ TEXT ·cCall10(SB), NOSPLIT, $0-96
 	MOVD a9+80(FP), R11
 	MOVD R10, 0(RSP)
 	MOVD R11, 8(RSP)
+	// R19 is callee-saved and carries FPCR across the call; Go code assumes
+	// round-to-nearest with traps disabled.
+	MRS FPCR, R19
 	BL (R16)
+	MSR R19, FPCR
 	ADD $16, RSP
 	MOVD R0, ret+88(FP)
 	RET
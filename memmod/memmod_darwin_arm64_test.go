@@ -7,3 +7,49 @@ import "testing"
 func TestLoadLibraryAndCallExport_DarwinArm64(t *testing.T) {
 	runDarwinLoadAndCallTest(t, "test1_darwin-arm64.dylib")
 }
+
+func TestARM64EFixupValue_DarwinArm64(t *testing.T) {
+	mapped := mappedImage{loadAddress: 0x100000000, slide: 0x100000000 - 0x4000}
+	targets := []uintptr{0x1a0000000}
+	const addr = 0x100008000
+
+	tests := []struct {
+		name   string
+		raw    uint64
+		format uint16
+		want   uint64
+	}{
+		{"rebase vmaddr", 0x4100 | 0x12<<43, chainedPtrARM64E, 0x100000100 | 0x12<<56},
+		{"rebase offset", 0x100, chainedPtrARM64EUserland, 0x100000100},
+		{"bind", 1<<62 | 0x7fff8<<32, chainedPtrARM64EUserland, 0x1a0000000 - 8},
+		{"bind24", 1<<62 | 16<<32, chainedPtrARM64EUserland24, 0x1a0000010},
+	}
+	for _, tc := range tests {
+		got, err := arm64eFixupValue(tc.raw, addr, tc.format, targets, mapped)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: arm64eFixupValue(%#x) = %#x, want %#x", tc.name, tc.raw, got, tc.want)
+		}
+	}
+
+	// An authenticated rebase with address diversity signs the target with
+	// the blended discriminator; stripping the signature gives it back.
+	raw := uint64(1)<<63 | 2<<49 | 1<<48 | 0x1234<<32 | 0x200
+	got, err := arm64eFixupValue(raw, addr, chainedPtrARM64EUserland, targets, mapped)
+	if err != nil {
+		t.Fatalf("auth rebase: %v", err)
+	}
+	discriminator := uintptr(addr) | 0x1234<<48
+	if want := pacSign(0x100000200, discriminator, 2); uintptr(got) != want {
+		t.Errorf("auth rebase = %#x, want %#x", got, want)
+	}
+	if stripped := pacStrip(uintptr(got)); stripped != 0x100000200 {
+		t.Errorf("auth rebase strips to %#x, want %#x", stripped, 0x100000200)
+	}
+
+	if _, err := arm64eFixupValue(1<<62|5, addr, chainedPtrARM64EUserland, targets, mapped); err == nil {
+		t.Error("bind to a missing import did not fail")
+	}
+}
@@ -2,7 +2,28 @@
 
 package memmod
 
-import _ "unsafe"
+import (
+	"sync"
+	"unsafe"
+)
+
+// Native code runs on the system stack while its goroutine stays _Grunning,
+// so sysmon keeps asking for async preemption with SIGURG. The runtime's
+// handler cannot preempt g0 and returns, but each signal still interrupts
+// the callee mid-call: dyld and libSystem paths that do not retry EINTR fail
+// intermittently under goroutine churn. call10 therefore blocks SIGURG on the
+// thread for the duration of every native call, which covers dyld's loader
+// and initializer calls as well as exports.
+
+const (
+	darwinSIGURG   = 16
+	darwinSIGBLOCK = 1
+)
+
+var (
+	preemptMaskOnce sync.Once
+	preemptSigmask  uintptr
+)
 
 //go:noescape
 func cCall10(fn, a0, a1, a2, a3, a4, a5, a6, a7, a8, a9 uintptr) uintptr
@@ -31,9 +52,28 @@ func call6(fn, a0, a1, a2, a3, a4, a5 uintptr) uintptr {
 }
 
 func call10(fn, a0, a1, a2, a3, a4, a5, a6, a7, a8, a9 uintptr) uintptr {
-	var ret uintptr
+	preemptMaskOnce.Do(func() {
+		_, preemptSigmask = resolveSignalAPIs()
+	})
+	// The sigsets pthread_sigmask reads and writes live in an arena; without
+	// one, or without pthread_sigmask, the call goes ahead unmasked, as before.
+	var ret, sets uintptr
+	if preemptSigmask != 0 {
+		arena := getArena()
+		defer arena.release()
+		if addr, err := arena.alloc(2*unsafe.Sizeof(uint32(0)), unsafe.Alignof(uint32(0))); err == nil {
+			sets = addr
+			*(*uint32)(unsafe.Pointer(sets)) = 1 << (darwinSIGURG - 1)
+		}
+	}
+	urg, prev := sets, sets+unsafe.Sizeof(uint32(0))
 	runtimeSystemstack(func() {
+		masked := sets != 0 &&
+			cCall10(preemptSigmask, darwinSIGBLOCK, urg, prev, 0, 0, 0, 0, 0, 0, 0) == 0
 		ret = cCall10(fn, a0, a1, a2, a3, a4, a5, a6, a7, a8, a9)
+		if masked {
+			cCall10(preemptSigmask, darwinSIGSETMASK, prev, 0, 0, 0, 0, 0, 0, 0, 0)
+		}
 	})
 	return ret
 }
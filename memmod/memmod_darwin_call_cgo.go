@@ -3,6 +3,7 @@
 package memmod
 
 /*
+#include <fenv.h>
 #include <stdint.h>
 
 typedef uintptr_t (*reflektor_fn10_t)(
@@ -15,7 +16,13 @@ static uintptr_t reflektor_call10(
 	uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4,
 	uintptr_t a5, uintptr_t a6, uintptr_t a7, uintptr_t a8, uintptr_t a9
 ) {
-	return ((reflektor_fn10_t)fn)(a0, a1, a2, a3, a4, a5, a6, a7, a8, a9);
+	// Restore the caller's floating-point environment; Go code assumes
+	// round-to-nearest with exceptions masked.
+	fenv_t env;
+	fegetenv(&env);
+	uintptr_t ret = ((reflektor_fn10_t)fn)(a0, a1, a2, a3, a4, a5, a6, a7, a8, a9);
+	fesetenv(&env);
+	return ret;
 }
 */
 import "C"
@@ -0,0 +1,25 @@
+//go:build darwin && (amd64 || arm64) && !cgo
+
+package memmod
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestNativeCallsBlockPreemptionSignal_Darwin(t *testing.T) {
+	_, sigmask := resolveSignalAPIs()
+	if sigmask == 0 {
+		t.Skip("pthread_sigmask not found in the shared cache")
+	}
+
+	// pthread_sigmask is itself called through call10, so the mask it
+	// reports is the one native code runs under.
+	var inside uint32
+	if rc := call4(sigmask, darwinSIGBLOCK, 0, uintptr(unsafe.Pointer(&inside)), 0); rc != 0 {
+		t.Fatalf("pthread_sigmask returned %d", int32(rc))
+	}
+	if inside&(1<<(darwinSIGURG-1)) == 0 {
+		t.Fatalf("signal mask during a native call = %#x, want SIGURG blocked", inside)
+	}
+}
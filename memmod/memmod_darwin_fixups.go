@@ -0,0 +1,589 @@
+//go:build darwin && (amd64 || arm64)
+
+package memmod
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The DarwinSelfFixup load mode links an image without dyld's private loader
+// API: it maps the image as the dyld path does, applies the rebases and binds
+// in LC_DYLD_CHAINED_FIXUPS itself, binding imports with dlopen and dlsym, and
+// runs the initializers. dyld never learns of the image.
+
+const (
+	lcLoadDylib         = 0xc
+	lcLazyLoadDylib     = 0x20
+	lcLoadWeakDylib     = 0x80000018
+	lcReexportDylib     = 0x8000001f
+	lcLoadUpwardDylib   = 0x80000023
+	lcDyldChainedFixups = 0x80000034
+
+	// dyld_chained_fixups_header imports_format values.
+	chainedImport         = 1
+	chainedImportAddend   = 2
+	chainedImportAddend64 = 3
+
+	// dyld_chained_starts_in_segment pointer_format values handled here.
+	// The arm64e formats carry authenticated pointers, which are signed
+	// with this process's keys as they are written.
+	chainedPtrARM64E           = 1
+	chainedPtr64               = 2
+	chainedPtr64Offset         = 6
+	chainedPtrARM64EUserland   = 9
+	chainedPtrARM64EUserland24 = 12
+
+	chainedPtrStartNone  = 0xffff
+	chainedPtrStartMulti = 0x8000
+
+	// Section types, in the low byte of a section's flags.
+	sectionTypeMask       = 0xff
+	sModInitFuncPointers  = 0x9
+	sThreadLocalVariables = 0x13
+	sInitFuncOffsets      = 0x16
+
+	darwinRTLDNow      = 0x2
+	darwinRTLDGlobal   = 0x8
+	darwinRTLDDefault  = ^uintptr(1) // (void *)-2
+	darwinRTLDMainOnly = ^uintptr(4) // (void *)-5
+)
+
+// errSelfFixupUnsupported marks images DarwinSelfFixup leaves to dyld.
+var errSelfFixupUnsupported = errors.New("image needs dyld")
+
+// darwinDlAPI is libdyld's dlopen family.
+type darwinDlAPI struct {
+	dlopen, dlsym, dlclose, dlerror uintptr
+}
+
+var getDarwinDlAPI = sync.OnceValues(func() (darwinDlAPI, error) {
+	const libdyld = "/usr/lib/system/libdyld.dylib"
+	api := darwinDlAPI{
+		dlopen:  resolveLibSystemSymbol("_dlopen", libdyld),
+		dlsym:   resolveLibSystemSymbol("_dlsym", libdyld),
+		dlclose: resolveLibSystemSymbol("_dlclose", libdyld),
+		dlerror: resolveLibSystemSymbol("_dlerror", libdyld),
+	}
+	if api.dlopen == 0 || api.dlsym == 0 || api.dlclose == 0 {
+		return api, errors.New("dlopen, dlsym, or dlclose not found in libdyld")
+	}
+	return api, nil
+})
+
+// chainedImportEntry is one entry of a chained fixups import table.
+type chainedImportEntry struct {
+	// ordinal is the 1-based dylib the symbol comes from, or one of the
+	// special ordinals: 0 for the image itself, -1 for the main executable,
+	// -2 for a flat lookup, and -3 for a weak definition lookup.
+	ordinal int
+	weak    bool
+	name    string
+	addend  uint64
+}
+
+// selfFixupLoader maps image and links it without dyld, running its
+// initializers when initialize is set and logging its progress to logger. It
+// returns an error wrapping errSelfFixupUnsupported, having mapped nothing,
+// for images that need dyld.
+func selfFixupLoader(image []byte, initialize bool, logger *slog.Logger) (mappedImage, error) {
+	f, err := macho.NewFile(bytes.NewReader(image))
+	if err != nil {
+		return mappedImage{}, fmt.Errorf("parse Mach-O image: %w", err)
+	}
+	defer f.Close()
+
+	fixups, err := selfFixupData(f, image)
+	if err != nil {
+		return mappedImage{}, err
+	}
+	api, err := getDarwinDlAPI()
+	if err != nil {
+		return mappedImage{}, fmt.Errorf("%w: %v", errSelfFixupUnsupported, err)
+	}
+
+	mapped, err := mapMachOImage(image)
+	if err != nil {
+		return mappedImage{}, err
+	}
+	trace(logger, stageMap, "mapped image", addrAttr("base", mapped.loadAddress), "size", len(mapped.mapping))
+	mapped.slide = mapped.loadAddress - uintptr(f.Segment("__TEXT").Addr)
+	mapped.selfLinked = true
+	linked := false
+	defer func() {
+		if !linked {
+			closeDependencies(api, mapped.dependencies)
+			_ = unix.Munmap(mapped.mapping)
+			if mapped.scratch != nil {
+				_ = unix.Munmap(mapped.scratch)
+			}
+		}
+	}()
+
+	mapped.dependencies, err = openDependencies(api, f)
+	if err != nil {
+		return mappedImage{}, err
+	}
+	trace(logger, stageDependency, "opened dependencies", "dylibs", len(mapped.dependencies))
+	imports, err := parseChainedImports(fixups)
+	if err != nil {
+		return mappedImage{}, err
+	}
+	targets := make([]uintptr, len(imports))
+	for i, imp := range imports {
+		if targets[i], err = resolveChainedImport(api, mapped, imp); err != nil {
+			return mappedImage{}, err
+		}
+	}
+	if err := applyChainedFixups(fixups, targets, mapped); err != nil {
+		return mappedImage{}, err
+	}
+	trace(logger, stageRelocate, "applied chained fixups", "imports", len(targets))
+	if err := protectConstSegments(mapped.loadAddress); err != nil {
+		return mappedImage{}, err
+	}
+	if initialize {
+		trace(logger, stageInitialize, "running initializers")
+		if err := runSelfInitializers(f, &mapped); err != nil {
+			return mappedImage{}, err
+		}
+		mapped.initialized = true
+	}
+	linked = true
+	return mapped, nil
+}
+
+// selfFixupData returns the image's chained fixups, or an error wrapping
+// errSelfFixupUnsupported when the image uses what only dyld provides.
+func selfFixupData(f *macho.File, image []byte) ([]byte, error) {
+	if f.Segment("__TEXT") == nil {
+		return nil, darwinLoaderError(LoaderStageImage, "no __TEXT segment")
+	}
+	for _, sect := range f.Sections {
+		switch {
+		case sect.Flags&sectionTypeMask == sThreadLocalVariables:
+			return nil, fmt.Errorf("%w: image uses thread-local variables", errSelfFixupUnsupported)
+		case sect.Name == "__objc_imageinfo":
+			return nil, fmt.Errorf("%w: image has Objective-C metadata", errSelfFixupUnsupported)
+		case strings.HasPrefix(sect.Name, "__swift5"):
+			return nil, fmt.Errorf("%w: image has Swift metadata", errSelfFixupUnsupported)
+		case sect.Name == "__gcc_except_tab":
+			return nil, fmt.Errorf("%w: image has C++ exception tables", errSelfFixupUnsupported)
+		}
+	}
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 || f.ByteOrder.Uint32(raw) != lcDyldChainedFixups {
+			continue
+		}
+		offset, size := uint64(f.ByteOrder.Uint32(raw[8:])), uint64(f.ByteOrder.Uint32(raw[12:]))
+		if offset > uint64(len(image)) || size > uint64(len(image))-offset {
+			return nil, errors.New("chained fixups lie outside the image")
+		}
+		return image[offset : offset+size], nil
+	}
+	return nil, fmt.Errorf("%w: image has no chained fixups", errSelfFixupUnsupported)
+}
+
+// parseChainedImports decodes the import table of the chained fixups in data.
+func parseChainedImports(data []byte) ([]chainedImportEntry, error) {
+	if len(data) < 28 {
+		return nil, errors.New("chained fixups header is truncated")
+	}
+	le := binary.LittleEndian
+	importsOffset := uint64(le.Uint32(data[8:]))
+	symbolsOffset := uint64(le.Uint32(data[12:]))
+	count := uint64(le.Uint32(data[16:]))
+	format := le.Uint32(data[20:])
+	if le.Uint32(data) != 0 {
+		return nil, fmt.Errorf("%w: chained fixups version %d", errSelfFixupUnsupported, le.Uint32(data))
+	}
+	if symbolsFormat := le.Uint32(data[24:]); symbolsFormat != 0 {
+		return nil, fmt.Errorf("%w: compressed chained fixup symbols", errSelfFixupUnsupported)
+	}
+
+	var stride uint64
+	switch format {
+	case chainedImport:
+		stride = 4
+	case chainedImportAddend:
+		stride = 8
+	case chainedImportAddend64:
+		stride = 16
+	default:
+		return nil, fmt.Errorf("%w: chained import format %d", errSelfFixupUnsupported, format)
+	}
+	if importsOffset > uint64(len(data)) || count > (uint64(len(data))-importsOffset)/stride {
+		return nil, errors.New("chained import table lies outside the fixups")
+	}
+
+	imports := make([]chainedImportEntry, count)
+	for i := range imports {
+		entry := data[importsOffset+uint64(i)*stride:]
+		var (
+			imp        chainedImportEntry
+			nameOffset uint64
+		)
+		if format == chainedImportAddend64 {
+			v := le.Uint64(entry)
+			imp.ordinal = int(uint16(v))
+			if imp.ordinal > 0xfff0 {
+				imp.ordinal = int(int16(v))
+			}
+			imp.weak = v>>16&1 != 0
+			nameOffset = v >> 32
+			imp.addend = le.Uint64(entry[8:])
+		} else {
+			v := le.Uint32(entry)
+			imp.ordinal = int(uint8(v))
+			if imp.ordinal > 0xf0 {
+				imp.ordinal = int(int8(v))
+			}
+			imp.weak = v>>8&1 != 0
+			nameOffset = uint64(v >> 9)
+			if format == chainedImportAddend {
+				imp.addend = uint64(int64(int32(le.Uint32(entry[4:]))))
+			}
+		}
+		start := symbolsOffset + nameOffset
+		if start >= uint64(len(data)) {
+			return nil, fmt.Errorf("chained import %d names a symbol outside the fixups", i)
+		}
+		end := bytes.IndexByte(data[start:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("chained import %d has an unterminated name", i)
+		}
+		imp.name = string(data[start : start+uint64(end)])
+		imports[i] = imp
+	}
+	return imports, nil
+}
+
+// openDependencies dlopens the dylibs the image links against, in load
+// command order, so that handle i is import ordinal i+1. Weak dylibs that
+// are missing get a zero handle.
+func openDependencies(api darwinDlAPI, f *macho.File) ([]uintptr, error) {
+	arena := getArena()
+	defer arena.release()
+
+	var handles []uintptr
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 12 {
+			continue
+		}
+		cmd := f.ByteOrder.Uint32(raw)
+		switch cmd {
+		case lcLoadDylib, lcLazyLoadDylib, lcLoadWeakDylib, lcReexportDylib, lcLoadUpwardDylib:
+		default:
+			continue
+		}
+		offset := f.ByteOrder.Uint32(raw[8:])
+		if offset >= uint32(len(raw)) {
+			closeDependencies(api, handles)
+			return nil, errors.New("dylib load command has its name outside the command")
+		}
+		path := fixedCString(raw[offset:])
+		cPath, err := arena.cString(path)
+		if err != nil {
+			closeDependencies(api, handles)
+			return nil, fmt.Errorf("dylib %q: %w", path, err)
+		}
+		handle := call2(api.dlopen, cPath, darwinRTLDNow|darwinRTLDGlobal)
+		if handle == 0 && cmd != lcLoadWeakDylib {
+			msg := "not found"
+			if api.dlerror != 0 {
+				if detail := cStringAt(call0(api.dlerror)); detail != "" {
+					msg = detail
+				}
+			}
+			closeDependencies(api, handles)
+			return nil, fmt.Errorf("dlopen %s: %s", path, msg)
+		}
+		handles = append(handles, handle)
+	}
+	return handles, nil
+}
+
+// closeDependencies drops the references openDependencies took.
+func closeDependencies(api darwinDlAPI, handles []uintptr) {
+	for i := len(handles) - 1; i >= 0; i-- {
+		if handles[i] != 0 {
+			call1(api.dlclose, handles[i])
+		}
+	}
+}
+
+// resolveChainedImport returns the address imp binds to, addend included, or
+// zero for a weak import nothing defines.
+func resolveChainedImport(api darwinDlAPI, mapped mappedImage, imp chainedImportEntry) (uintptr, error) {
+	var addr uintptr
+	if imp.ordinal == 0 {
+		addr = findSymbol(mapped.loadAddress, imp.name, uint64(mapped.slide))
+	} else {
+		var handle uintptr
+		switch {
+		case imp.ordinal == -1:
+			handle = darwinRTLDMainOnly
+		case imp.ordinal == -2 || imp.ordinal == -3:
+			handle = darwinRTLDDefault
+		case imp.ordinal >= 1 && imp.ordinal <= len(mapped.dependencies):
+			handle = mapped.dependencies[imp.ordinal-1]
+		default:
+			return 0, fmt.Errorf("import %s has invalid dylib ordinal %d", imp.name, imp.ordinal)
+		}
+		if handle != 0 {
+			arena := getArena()
+			cName, err := arena.cString(strings.TrimPrefix(imp.name, "_"))
+			if err != nil {
+				arena.release()
+				return 0, fmt.Errorf("import %s: %w", imp.name, err)
+			}
+			addr = call2(api.dlsym, handle, cName)
+			arena.release()
+		}
+	}
+	if addr == 0 {
+		if imp.weak {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%w: import %s", ErrSymbolNotFound, imp.name)
+	}
+	return addr + uintptr(imp.addend), nil
+}
+
+// applyChainedFixups walks every pointer chain in the fixups data and writes
+// the rebased or bound value into the mapped image. targets holds the
+// resolved address of each import.
+func applyChainedFixups(data []byte, targets []uintptr, mapped mappedImage) error {
+	le := binary.LittleEndian
+	startsOffset := uint64(le.Uint32(data[4:]))
+	if startsOffset > uint64(len(data))-4 {
+		return errors.New("chained starts lie outside the fixups")
+	}
+	starts := data[startsOffset:]
+	segCount := uint64(le.Uint32(starts))
+	if segCount > (uint64(len(starts))-4)/4 {
+		return errors.New("chained starts table is truncated")
+	}
+
+	mappingStart := uintptr(unsafe.Pointer(&mapped.mapping[0]))
+	mappingEnd := mappingStart + uintptr(len(mapped.mapping))
+	for i := uint64(0); i < segCount; i++ {
+		segOffset := uint64(le.Uint32(starts[4+4*i:]))
+		if segOffset == 0 {
+			continue
+		}
+		if segOffset > uint64(len(starts)) || uint64(len(starts))-segOffset < 22 {
+			return fmt.Errorf("chained starts of segment %d lie outside the fixups", i)
+		}
+		seg := starts[segOffset:]
+		pageSize := uintptr(le.Uint16(seg[4:]))
+		format := le.Uint16(seg[6:])
+		segmentStart := mapped.loadAddress + uintptr(le.Uint64(seg[8:]))
+		pageCount := uint64(le.Uint16(seg[20:]))
+		if pageCount > (uint64(len(seg))-22)/2 {
+			return fmt.Errorf("chained starts of segment %d are truncated", i)
+		}
+		var arm64e bool
+		switch format {
+		case chainedPtr64, chainedPtr64Offset:
+		case chainedPtrARM64E, chainedPtrARM64EUserland, chainedPtrARM64EUserland24:
+			arm64e = true
+		default:
+			return fmt.Errorf("%w: chained pointer format %d", errSelfFixupUnsupported, format)
+		}
+
+		for page := uint64(0); page < pageCount; page++ {
+			start := le.Uint16(seg[22+2*page:])
+			if start == chainedPtrStartNone {
+				continue
+			}
+			if start&chainedPtrStartMulti != 0 {
+				return fmt.Errorf("%w: multiple chain starts in a page", errSelfFixupUnsupported)
+			}
+			addr := segmentStart + uintptr(page)*pageSize + uintptr(start)
+			for {
+				if addr < mappingStart || addr > mappingEnd-8 {
+					return fmt.Errorf("chained fixup at %#x lies outside the image", addr-mapped.loadAddress)
+				}
+				raw := *(*uint64)(unsafe.Pointer(addr))
+				if arm64e {
+					value, err := arm64eFixupValue(raw, addr, format, targets, mapped)
+					if err != nil {
+						return err
+					}
+					*(*uint64)(unsafe.Pointer(addr)) = value
+					// arm64e chains count in 8-byte strides.
+					next := uintptr(raw >> 51 & 0x7ff)
+					if next == 0 {
+						break
+					}
+					addr += next * 8
+					continue
+				}
+				var value uint64
+				if raw>>63 != 0 {
+					ordinal := raw & 0xffffff
+					if ordinal >= uint64(len(targets)) {
+						return fmt.Errorf("chained bind at %#x names import %d of %d", addr-mapped.loadAddress, ordinal, len(targets))
+					}
+					if target := targets[ordinal]; target != 0 {
+						value = uint64(target) + raw>>24&0xff
+					}
+				} else {
+					target := raw & (1<<36 - 1)
+					if format == chainedPtr64 {
+						value = target + uint64(mapped.slide)
+					} else {
+						value = target + uint64(mapped.loadAddress)
+					}
+					value |= (raw >> 36 & 0xff) << 56
+				}
+				*(*uint64)(unsafe.Pointer(addr)) = value
+
+				next := uintptr(raw >> 51 & 0xfff)
+				if next == 0 {
+					break
+				}
+				addr += next * 4
+			}
+		}
+	}
+	return nil
+}
+
+// arm64eFixupValue decodes the arm64e chained pointer raw found at addr and
+// returns the value to write there. Authenticated pointers are signed with
+// the key and discriminator they name, the discriminator blended with addr
+// when they ask for address diversity, as dyld signs them.
+func arm64eFixupValue(raw uint64, addr uintptr, format uint16, targets []uintptr, mapped mappedImage) (uint64, error) {
+	bind, auth := raw>>62&1 != 0, raw>>63 != 0
+	var value uint64
+	switch {
+	case bind:
+		ordinal := raw & 0xffff
+		if format == chainedPtrARM64EUserland24 {
+			ordinal = raw & 0xffffff
+		}
+		if ordinal >= uint64(len(targets)) {
+			return 0, fmt.Errorf("chained bind at %#x names import %d of %d", addr-mapped.loadAddress, ordinal, len(targets))
+		}
+		target := targets[ordinal]
+		if target == 0 {
+			return 0, nil
+		}
+		value = uint64(pacStrip(target))
+		if !auth {
+			// The addend is a signed 19-bit field at bit 32.
+			return value + uint64(int64(raw<<13)>>45), nil
+		}
+	case auth:
+		value = uint64(mapped.loadAddress) + raw&0xffffffff
+	default:
+		target := raw & (1<<43 - 1)
+		if format == chainedPtrARM64E {
+			value = target + uint64(mapped.slide)
+		} else {
+			value = target + uint64(mapped.loadAddress)
+		}
+		return value | (raw>>43&0xff)<<56, nil
+	}
+
+	diversity := raw >> 32 & 0xffff
+	discriminator := diversity
+	if raw>>48&1 != 0 {
+		discriminator = uint64(addr)&(1<<48-1) | diversity<<48
+	}
+	return uint64(pacSign(uintptr(value), uintptr(discriminator), uint8(raw>>49&3))), nil
+}
+
+// programVars mirrors dyld's ProgramVars, the last argument initializers
+// receive.
+type programVars struct {
+	mh       uintptr
+	argc     uintptr
+	argv     uintptr
+	environ  uintptr
+	progname uintptr
+}
+
+// runSelfInitializers runs the image's __mod_init_func and __init_offsets
+// initializers in order, with the arguments dyld passes them: argc, argv,
+// envp, the apple strings, and ProgramVars. The apple strings are empty, and
+// ProgramVars lives in the module's scratch page, as initializers may keep it.
+func runSelfInitializers(f *macho.File, mapped *mappedImage) error {
+	const libdyld = "/usr/lib/system/libdyld.dylib"
+	getArgc := resolveLibSystemSymbol("__NSGetArgc", libdyld, "/usr/lib/system/libsystem_c.dylib")
+	getArgv := resolveLibSystemSymbol("__NSGetArgv", libdyld, "/usr/lib/system/libsystem_c.dylib")
+	getEnviron := resolveLibSystemSymbol("__NSGetEnviron", libdyld, "/usr/lib/system/libsystem_c.dylib")
+	getProgname := resolveLibSystemSymbol("__NSGetProgname", libdyld, "/usr/lib/system/libsystem_c.dylib")
+	if getArgc == 0 || getArgv == 0 || getEnviron == 0 || getProgname == 0 {
+		return errors.New("crt_externs accessors not found in libSystem")
+	}
+
+	scratch, err := unix.Mmap(-1, 0, unix.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return fmt.Errorf("map initializer arguments: %w", err)
+	}
+	mapped.scratch = scratch
+	vars := (*programVars)(unsafe.Pointer(&scratch[0]))
+	*vars = programVars{
+		mh:       mapped.loadAddress,
+		argc:     call0(getArgc),
+		argv:     call0(getArgv),
+		environ:  call0(getEnviron),
+		progname: call0(getProgname),
+	}
+	// The zeroed word after vars is the empty apple array.
+	apple := uintptr(unsafe.Pointer(&scratch[unsafe.Sizeof(programVars{})]))
+	argc := uintptr(*(*int32)(unsafe.Pointer(vars.argc)))
+	argv := *(*uintptr)(unsafe.Pointer(vars.argv))
+	envp := *(*uintptr)(unsafe.Pointer(vars.environ))
+	varsAddr := uintptr(unsafe.Pointer(vars))
+
+	for _, sect := range f.Sections {
+		start := mapped.slide + uintptr(sect.Addr)
+		switch sect.Flags & sectionTypeMask {
+		case sModInitFuncPointers:
+			stride := unsafe.Sizeof(uintptr(0))
+			for i := uintptr(0); i < uintptr(sect.Size)/stride; i++ {
+				if fn := *(*uintptr)(unsafe.Pointer(start + i*stride)); fn != 0 {
+					call6(fn, argc, argv, envp, apple, varsAddr, 0)
+				}
+			}
+		case sInitFuncOffsets:
+			for i := uintptr(0); i < uintptr(sect.Size)/4; i++ {
+				offset := *(*uint32)(unsafe.Pointer(start + i*4))
+				call6(mapped.loadAddress+uintptr(offset), argc, argv, envp, apple, varsAddr, 0)
+			}
+		}
+	}
+	return nil
+}
+
+// unloadSelfLinked runs a self-linked image's terminators and the atexit
+// handlers and C++ destructors it registered, then drops its dylibs.
+func unloadSelfLinked(mapped mappedImage) error {
+	if mapped.initialized {
+		runTerminators(mapped)
+		if finalize := resolveLibSystemSymbol("___cxa_finalize", "/usr/lib/system/libsystem_c.dylib"); finalize != 0 {
+			call1(finalize, mapped.loadAddress)
+		}
+	}
+	api, err := getDarwinDlAPI()
+	if err != nil {
+		return err
+	}
+	closeDependencies(api, mapped.dependencies)
+	return nil
+}
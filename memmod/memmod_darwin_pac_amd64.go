@@ -0,0 +1,14 @@
+//go:build darwin && amd64
+
+package memmod
+
+// pacSign returns value: x86_64 has no pointer authentication, and the amd64
+// loader never selects an arm64e slice.
+func pacSign(value, discriminator uintptr, key uint8) uintptr {
+	return value
+}
+
+// pacStrip returns value unchanged.
+func pacStrip(value uintptr) uintptr {
+	return value
+}
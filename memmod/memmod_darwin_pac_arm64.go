@@ -0,0 +1,16 @@
+//go:build darwin && arm64
+
+package memmod
+
+// pacSign signs value with this process's pointer authentication key (0 IA,
+// 1 IB, 2 DA, 3 DB) and discriminator, as an arm64e image's authenticated
+// pointers must be.
+//
+//go:noescape
+func pacSign(value, discriminator uintptr, key uint8) uintptr
+
+// pacStrip removes a pointer authentication code from value, as dlsym leaves
+// one on the function pointers it returns to arm64e code.
+//
+//go:noescape
+func pacStrip(value uintptr) uintptr
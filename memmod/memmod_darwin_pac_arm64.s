@@ -0,0 +1,38 @@
+//go:build darwin && arm64
+
+#include "textflag.h"
+
+// The PAC instructions are written as words so the file assembles whatever
+// the assembler knows of ARMv8.3.
+
+// func pacSign(value, discriminator uintptr, key uint8) uintptr
+TEXT ·pacSign(SB), NOSPLIT, $0-32
+	MOVD value+0(FP), R0
+	MOVD discriminator+8(FP), R1
+	MOVBU key+16(FP), R2
+	CMP $1, R2
+	BEQ ib
+	CMP $2, R2
+	BEQ da
+	CMP $3, R2
+	BEQ db
+	WORD $0xdac10020 // PACIA X0, X1
+	B done
+ib:
+	WORD $0xdac10420 // PACIB X0, X1
+	B done
+da:
+	WORD $0xdac10820 // PACDA X0, X1
+	B done
+db:
+	WORD $0xdac10c20 // PACDB X0, X1
+done:
+	MOVD R0, ret+24(FP)
+	RET
+
+// func pacStrip(value uintptr) uintptr
+TEXT ·pacStrip(SB), NOSPLIT, $0-16
+	MOVD value+0(FP), R0
+	WORD $0xdac143e0 // XPACI X0
+	MOVD R0, ret+8(FP)
+	RET
@@ -3,13 +3,19 @@
 package memmod
 
 import (
+	"bytes"
+	"debug/macho"
 	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"testing"
 	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 func runDarwinLoadAndCallTest(t *testing.T, dylibName string) {
@@ -90,3 +96,237 @@ func ensureDarwinTestDylib(t *testing.T, dylibName string) string {
 
 	return outPath
 }
+
+func TestProcAddressByName_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	dylibPath := ensureDarwinTestDylib(t, "test1_darwin-"+runtime.GOARCH+".dylib")
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read test dylib (%s): %v", dylibPath, err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	base := module.Base()
+	if base == 0 {
+		t.Fatal("Base is zero for a loaded image")
+	}
+	addr, err := module.ProcAddressByName("StartW")
+	if err != nil {
+		t.Fatalf("ProcAddressByName(StartW): %v", err)
+	}
+	if addr < base || addr >= base+uintptr(len(module.mapped.mapping)) {
+		t.Fatalf("StartW at %#x is outside the image at %#x", addr, base)
+	}
+	if again, err := module.ProcAddressByName("_StartW"); err != nil || again != addr {
+		t.Fatalf("ProcAddressByName(_StartW) = %#x, %v; want %#x", again, err, addr)
+	}
+	if _, err := module.ProcAddressByName("NoSuchExport"); err == nil {
+		t.Fatal("ProcAddressByName found a missing export")
+	}
+
+	module.Free()
+	if _, err := module.ProcAddressByName("StartW"); err == nil {
+		t.Fatal("ProcAddressByName succeeded after Free")
+	}
+}
+
+func TestUnload_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	dylibPath := ensureDarwinTestDylib(t, "test1_darwin-"+runtime.GOARCH+".dylib")
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read test dylib (%s): %v", dylibPath, err)
+	}
+
+	before := MappedBytes()
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	if module.mapped.topLoader == 0 {
+		t.Fatal("module does not retain its dyld loader")
+	}
+
+	if err := module.Unload(); err != nil {
+		t.Fatalf("Unload: %v", err)
+	}
+	if got := MappedBytes(); got != before {
+		t.Fatalf("MappedBytes after Unload = %#x, want %#x", got, before)
+	}
+	if err := module.Unload(); !errors.Is(err, errDarwinLibraryClosed) {
+		t.Fatalf("second Unload = %v, want %v", err, errDarwinLibraryClosed)
+	}
+	if _, err := module.ProcAddressByName("StartW"); err == nil {
+		t.Fatal("ProcAddressByName succeeded after Unload")
+	}
+}
+
+func TestDladdrReportsImage_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	dladdr := resolveLibSystemSymbol("_dladdr", "/usr/lib/system/libdyld.dylib")
+	if dladdr == 0 {
+		t.Skip("dladdr not found in libdyld")
+	}
+	dylibPath := ensureDarwinTestDylib(t, "test1_darwin-"+runtime.GOARCH+".dylib")
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read test dylib (%s): %v", dylibPath, err)
+	}
+
+	const imagePath = "/usr/local/lib/reflektor-test1.dylib"
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{ImagePath: imagePath})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer module.Free()
+
+	addr, err := module.ProcAddressByName("StartW")
+	if err != nil {
+		t.Fatalf("ProcAddressByName(StartW): %v", err)
+	}
+	var info struct {
+		fname, fbase, sname, saddr uintptr
+	}
+	if call2(dladdr, addr, uintptr(unsafe.Pointer(&info))) == 0 {
+		t.Fatal("dladdr does not know the image")
+	}
+	if info.fbase != module.Base() {
+		t.Fatalf("dli_fbase = %#x, want %#x", info.fbase, module.Base())
+	}
+	if got := cStringAt(info.fname); got != imagePath {
+		t.Fatalf("dli_fname = %q, want %q", got, imagePath)
+	}
+}
+
+func TestDataConstReadOnlyAfterFixups_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	dylibPath := ensureDarwinTestDylib(t, "test1_darwin-"+runtime.GOARCH+".dylib")
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read test dylib (%s): %v", dylibPath, err)
+	}
+	f, err := macho.NewFile(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("parse test dylib: %v", err)
+	}
+	defer f.Close()
+	seg := f.Segment("__DATA_CONST")
+	if seg == nil || seg.Memsz == 0 {
+		t.Skip("test dylib has no __DATA_CONST segment")
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	addr := module.mapped.slide + uintptr(seg.Addr)
+	faulted := func() (faulted bool) {
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() { faulted = recover() != nil }()
+		p := (*byte)(unsafe.Pointer(addr))
+		v := *p
+		*p = v
+		return false
+	}()
+	if !faulted {
+		t.Fatalf("__DATA_CONST at %#x is still writable after load", addr)
+	}
+}
+
+func TestSelfFixupLoad_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	dylibPath := ensureDarwinTestDylib(t, "test1_darwin-"+runtime.GOARCH+".dylib")
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read test dylib (%s): %v", dylibPath, err)
+	}
+	markerPath := filepath.Join(t.TempDir(), "reflektor_marker.txt")
+	t.Setenv("REFLEKTOR_MARKER", markerPath)
+
+	before := MappedBytes()
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{LoadMode: DarwinSelfFixup})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	if !module.mapped.selfLinked {
+		module.Free()
+		t.Skip("test dylib fell back to dyld; it has no chained fixups the self-fixup mode handles")
+	}
+	if module.mapped.topLoader != 0 {
+		t.Fatal("self-linked module holds a dyld loader")
+	}
+
+	// StartWStatus binds getenv, fopen, fwrite, and fclose through the
+	// chained fixups.
+	result, err := module.CallExportResult("StartWStatus")
+	if err != nil {
+		t.Fatalf("CallExportResult(StartWStatus): %v", err)
+	}
+	if result.Value != 1337 {
+		t.Fatalf("StartWStatus() = %d, want 1337", result.Value)
+	}
+	if got, err := os.ReadFile(markerPath); err != nil || string(got) != "ok" {
+		t.Fatalf("marker = %q, %v; want %q", got, err, "ok")
+	}
+
+	if err := module.Unload(); err != nil {
+		t.Fatalf("Unload: %v", err)
+	}
+	if got := MappedBytes(); got != before {
+		t.Fatalf("MappedBytes after Unload = %#x, want %#x", got, before)
+	}
+}
+
+// TestSwiftDylib_Darwin loads a Swift dylib whose export needs its protocol
+// conformances registered with the Swift runtime and a task from the
+// concurrency runtime.
+func TestSwiftDylib_Darwin(t *testing.T) {
+	if translated, err := unix.SysctlUint32("sysctl.proc_translated"); err == nil && translated == 1 {
+		t.Skip("darwin/amd64 under Rosetta is not supported by the dyld4-only in-memory loader")
+	}
+	if _, err := exec.LookPath("swiftc"); err != nil {
+		t.Skip("swiftc not found in PATH")
+	}
+
+	dylibPath := filepath.Join(t.TempDir(), "agent_darwin-"+runtime.GOARCH+".dylib")
+	cmd := exec.Command("swiftc", "-emit-library", "-swift-version", "5", "-O",
+		"-o", dylibPath, filepath.Join("..", "testdata", "swift", "agent.swift"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build swift test dylib: %v\n%s", err, out)
+	}
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read swift test dylib: %v", err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	result, err := module.CallExportResult("StartWStatus")
+	if err != nil {
+		t.Fatalf("CallExportResult(StartWStatus): %v", err)
+	}
+	if result.Value != 1337 {
+		t.Fatalf("StartWStatus() = %d, want 1337 (-1 means the conformance was not registered)", int32(result.Value))
+	}
+}
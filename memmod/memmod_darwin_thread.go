@@ -0,0 +1,50 @@
+//go:build darwin && (amd64 || arm64)
+
+package memmod
+
+import "runtime"
+
+// dyldThread is the OS thread a module makes every dyld call on. dyld's
+// runtime state assumes the thread that loaded an image is the one that
+// initializes it and later drops its reference, so the load sequence in
+// memmodLoader and the teardown in unloadImage only ever run here.
+type dyldThread struct {
+	work chan func()
+	done chan struct{}
+}
+
+func newDyldThread() *dyldThread {
+	thread := &dyldThread{
+		work: make(chan func()),
+		done: make(chan struct{}),
+	}
+	go thread.loop()
+	return thread
+}
+
+func (thread *dyldThread) loop() {
+	// Never unlocked: the runtime ends the OS thread with the goroutine
+	// rather than hand dyld-touched thread state to other goroutines.
+	runtime.LockOSThread()
+	defer close(thread.done)
+
+	for fn := range thread.work {
+		fn()
+	}
+}
+
+// run executes fn on the thread and waits for it to return.
+func (thread *dyldThread) run(fn func()) {
+	finished := make(chan struct{})
+	thread.work <- func() {
+		defer close(finished)
+		fn()
+	}
+	<-finished
+}
+
+// stop ends the thread once queued work has completed.
+func (thread *dyldThread) stop() {
+	close(thread.work)
+	<-thread.done
+}
@@ -8,12 +8,15 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -25,21 +28,32 @@ type linuxDynAPI struct {
 	dlerror uintptr
 }
 
-var (
-	linuxAPIOnce sync.Once
-	linuxAPI     linuxDynAPI
-	linuxAPIErr  error
-)
+// linuxAPI caches the host's dlopen, dlsym, and dlerror until
+// ResetHostSymbols drops them.
+var linuxAPI struct {
+	sync.Mutex
+	api  linuxDynAPI
+	err  error
+	done bool
+}
+
+// errnoLocation holds libc's __errno_location, or 0 when there is none, once
+// a build without cgo has looked it up for callNative.
+var errnoLocation atomic.Pointer[uintptr]
 
 const (
 	rtldNow    = 0x2
 	rtldGlobal = 0x100
 
-	// ELF dynamic tags used for runtime initialization hooks.
+	// ELF dynamic tags used for runtime initialization and termination
+	// hooks.
 	dynTagNull        = 0
 	dynTagInit        = 12
+	dynTagFini        = 13
 	dynTagInitArray   = 25
+	dynTagFiniArray   = 26
 	dynTagInitArraySz = 27
+	dynTagFiniArraySz = 28
 	dynTagPreinitArr  = 32
 	dynTagPreinitSz   = 33
 )
@@ -48,8 +62,26 @@ type Module struct {
 	mu       sync.RWMutex
 	mapping  []byte
 	loadBias uintptr
-	symbols  map[string]uintptr
-	closed   bool
+	symbols  map[string]Symbol
+	// needed lists the image's DT_NEEDED libraries in order, for FindSymbol.
+	needed []string
+	closed bool
+	// segments are the page-aligned PT_LOAD ranges within mapping.
+	segments [][]byte
+	// staticPIE is set for static-PIE executables started with StartEntry.
+	staticPIE *staticPIEImage
+	// relocations is the result of LoadOptions.VerifyRelocations.
+	relocations *RelocationCheck
+	// tls is the image's thread-local storage, if it has a PT_TLS segment.
+	tls *moduleTLS
+	// got is the loader-owned GOT of GOT-relative dynamic relocations.
+	got *gotTable
+	// pthreadCalls runs each export call on a new pthread, as
+	// LoadOptions.CallThread asks.
+	pthreadCalls bool
+	// finalizers are the termination functions Free runs, in order, when
+	// LoadOptions.RunFinalizers asked for them.
+	finalizers []uintptr
 }
 
 type mappedELF struct {
@@ -64,26 +96,67 @@ type dynamicInitInfo struct {
 	initArraySz uint64
 	preinitArr  uint64
 	preinitSz   uint64
+	fini        uint64
+	finiArray   uint64
+	finiArraySz uint64
 }
 
 type runtimeELFModule struct {
 	path  string
 	base  uintptr
 	score int
+	// header reports whether the file's first page is mapped readable at
+	// base, so its symbol tables can be read in place.
+	header bool
 }
 
 type symbolResolver struct {
+	ifuncs   ifuncTable
 	api      *linuxDynAPI
 	modules  []runtimeELFModule
 	resolved map[string]uintptr
 	misses   map[string]error
 	opened   map[string]uintptr
+	// searchPaths are tried for dependencies before the default locations.
+	searchPaths []string
+	// hook is the caller's ImportResolver, asked before Resolve.
+	hook ImportResolver
+	// needed is the image's DT_NEEDED list, kept to order modules when
+	// resolution is deterministic.
+	needed        []string
+	deterministic bool
+	// bound records the address each external symbol was bound to, by
+	// dynamic symbol index, for verifyRelocations.
+	bound map[uint32]uintptr
+	// tls is the image's TLS state, which its TLS relocations and its
+	// __tls_get_addr import bind to.
+	tls *moduleTLS
+	// got holds the GOT entries of GOT-relative dynamic relocations, or is
+	// nil when the image has none.
+	got *gotTable
+	// maxMapped is LoadOptions.MaxTotalMappedBytes, which got counts against.
+	maxMapped uint64
+	// musl is set when imports resolve against musl's libc.
+	musl bool
+	// logger is LoadOptions.Logger.
+	logger *slog.Logger
 }
 
 func LoadLibrary(data []byte) (*Module, error) {
+	return LoadLibraryWithOptions(data, LoadOptions{})
+}
+
+// LoadLibraryWithOptions is like LoadLibrary but maps the image according to
+// opts.
+func LoadLibraryWithOptions(data []byte, opts LoadOptions) (*Module, error) {
 	if len(data) == 0 {
 		return nil, errors.New("empty ELF image")
 	}
+	data, err := depackImage(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts = opts.forImage(data)
 
 	f, err := elf.NewFile(bytes.NewReader(data))
 	if err != nil {
@@ -94,39 +167,150 @@ func LoadLibrary(data []byte) (*Module, error) {
 	if err := validateELFHeaders(f); err != nil {
 		return nil, err
 	}
+	if isStaticPIE(f) {
+		return loadStaticPIE(data, f, opts)
+	}
+	if err := checkTLSSupport(f); err != nil {
+		return nil, err
+	}
+	pthreadCalls, err := usePthreadCalls(f, opts.CallThread)
+	if err != nil {
+		return nil, err
+	}
 
-	mapped, err := mapELFImage(data, f)
+	mapped, err := mapELFImage(data, f, opts)
 	if err != nil {
 		return nil, err
 	}
 	cleanup := true
 	defer func() {
 		if cleanup && len(mapped.mapping) != 0 {
-			_ = unix.Munmap(mapped.mapping)
+			unmapImage(mapped.mapping)
 		}
 	}()
 
-	resolver := newSymbolResolver(f)
+	resolver := newSymbolResolver(f, opts)
+	resolver.hook = opts.ImportResolver
+	if template, ok := readTLSTemplate(f); ok {
+		tls, err := newModuleTLS(template, mapped.loadBias, resolver)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if cleanup {
+				tls.free()
+			}
+		}()
+		resolver.tls = tls
+	}
+	defer func() {
+		if cleanup && resolver.got != nil {
+			resolver.got.free()
+		}
+	}()
 	if err := applyDynamicRelocations(mapped, f, resolver); err != nil {
 		return nil, err
 	}
+	if resolver.tls != nil {
+		if err := resolver.tls.start(); err != nil {
+			return nil, fmt.Errorf("set up TLS: %w", err)
+		}
+	}
 
 	if err := applySegmentProtections(mapped); err != nil {
 		return nil, err
 	}
-	if err := runELFInitializers(mapped, f); err != nil {
+	if err := resolver.ifuncs.applyPending(mapped); err != nil {
 		return nil, err
 	}
+	symbols, err := buildExportedSymbolTable(f, mapped.loadBias, &resolver.ifuncs, opts.Symbols)
+	if err != nil {
+		return nil, err
+	}
+	var relocations *RelocationCheck
+	if opts.VerifyRelocations {
+		if relocations, err = verifyRelocations(data, mapped, f, resolver); err != nil {
+			return nil, err
+		}
+	}
+	var finalizers []uintptr
+	if !opts.SkipInitializers {
+		if opts.RunFinalizers {
+			if finalizers, err = collectFinalizers(mapped, f); err != nil {
+				return nil, err
+			}
+		}
+		trace(opts.Logger, stageInitialize, "running initializers", "pthread", pthreadCalls)
+		if err := runInitializers(mapped, f, pthreadCalls, resolver.tls); err != nil {
+			return nil, err
+		}
+	}
 
 	module := &Module{
-		mapping:  mapped.mapping,
-		loadBias: mapped.loadBias,
-		symbols:  buildExportedSymbolTable(f, mapped.loadBias),
+		mapping:      mapped.mapping,
+		loadBias:     mapped.loadBias,
+		symbols:      symbols,
+		needed:       collectNeededLibraries(f),
+		segments:     mapped.segments(),
+		relocations:  relocations,
+		tls:          resolver.tls,
+		got:          resolver.got,
+		pthreadCalls: pthreadCalls,
+		finalizers:   finalizers,
 	}
 	cleanup = false
 	return module, nil
 }
 
+// Base returns the address the lowest PT_LOAD segment was mapped at, or zero
+// once the module is freed.
+func (module *Module) Base() uintptr {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	if module.closed || len(module.mapping) == 0 {
+		return 0
+	}
+	return uintptr(unsafe.Pointer(&module.mapping[0]))
+}
+
+// Resource is not supported: ELF images have no resource directory.
+func (module *Module) Resource(resType, name string) ([]byte, error) {
+	return nil, errors.New("resources are only supported in PE images")
+}
+
+// Relocations returns the result of LoadOptions.VerifyRelocations, or nil
+// when the check was not asked for. Static-PIE executables relocate
+// themselves when started, so they are never checked.
+func (module *Module) Relocations() *RelocationCheck {
+	return module.relocations
+}
+
+// ResourceUsage returns nil: LoadOptions.TrackResources is windows only.
+func (module *Module) ResourceUsage() *ResourceUsage {
+	return nil
+}
+
+// LockMemory faults in and locks the mapped image so it is never written to
+// swap. The lock is released when the image is unmapped. It fails if the
+// image exceeds RLIMIT_MEMLOCK and the process lacks CAP_IPC_LOCK.
+func (module *Module) LockMemory() error {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	if module.closed {
+		return errors.New("library is closed")
+	}
+	// Lock segment by segment: gaps between them may be inaccessible, which
+	// mlock rejects, and locking them would commit memory for nothing.
+	for _, segment := range module.segments {
+		if err := unix.Mlock(segment); err != nil {
+			return fmt.Errorf("mlock image: %w", err)
+		}
+	}
+	return nil
+}
+
 func (module *Module) Free() {
 	module.mu.Lock()
 	defer module.mu.Unlock()
@@ -136,18 +320,158 @@ func (module *Module) Free() {
 	}
 	module.closed = true
 
+	// The write lock waits out export calls, so the image is idle when its
+	// destructors and atexit handlers run.
+	for _, fn := range module.finalizers {
+		_, _ = callImage(fn, [MaxCallArgs]uintptr{}, module.pthreadCalls, module.tls)
+	}
+	module.finalizers = nil
 	if len(module.mapping) != 0 {
-		_ = unix.Munmap(module.mapping)
+		unmapImage(module.mapping)
 		module.mapping = nil
 	}
+	if module.tls != nil {
+		module.tls.free()
+		module.tls = nil
+	}
+	if module.got != nil {
+		module.got.free()
+		module.got = nil
+	}
 	module.symbols = nil
+	module.needed = nil
+	module.segments = nil
 	module.loadBias = 0
 }
 
 func (module *Module) CallExport(name string) error {
+	_, err := module.CallExportResult(name)
+	return err
+}
+
+// CallExportResult calls an exported zero-argument function and returns its
+// raw return value and errno.
+func (module *Module) CallExportResult(name string) (CallResult, error) {
+	return module.CallExportArgs(name)
+}
+
+// CallExportArgs calls an exported function with up to MaxCallArgs integer or
+// pointer arguments and returns its raw return value and errno.
+func (module *Module) CallExportArgs(name string, args ...uintptr) (CallResult, error) {
+	padded, err := callArgs(args)
+	if err != nil {
+		return CallResult{}, err
+	}
+
+	// Hold the read lock for the duration of the call so Free cannot unmap
+	// code that is still executing.
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	addr, err := module.exportAddressLocked(name)
+	if err != nil {
+		return CallResult{}, err
+	}
+	result, err := callImage(addr, padded, module.pthreadCalls, module.tls)
+	if err != nil {
+		return CallResult{}, fmt.Errorf("call export %q: %w", name, err)
+	}
+	return result, nil
+}
+
+// currentThreadID returns the calling thread's tid.
+func currentThreadID() uint64 {
+	return uint64(unix.Gettid())
+}
+
+// usePthreadCalls reports whether the image's initializers and exports run
+// on pthreads of their own under mode. Go c-shared payloads do by default
+// when cgo is available: the loader never starts their runtime, and their
+// cgo glue assumes the thread layout glibc gives a thread it created.
+func usePthreadCalls(f *elf.File, mode CallThreadMode) (bool, error) {
+	switch mode {
+	case CallThreadAuto:
+		return pthreadCallsSupported && isGoImage(f), nil
+	case CallThreadPthread:
+		if !pthreadCallsSupported {
+			return false, errors.New("CallThreadPthread requires cgo on linux")
+		}
+		return true, nil
+	case CallThreadCaller:
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid call thread mode %d", mode)
+	}
+}
+
+// threadPrologue is native code a new thread runs, with arg, before it calls
+// into the image. The zero value runs nothing.
+type threadPrologue struct {
+	fn  uintptr
+	arg uintptr
+}
+
+// callImage calls fn with args on a new pthread, or on the calling thread
+// after entering the image's static TLS block.
+func callImage(fn uintptr, args [MaxCallArgs]uintptr, onPthread bool, tls *moduleTLS) (CallResult, error) {
+	if onPthread {
+		join, err := startNativeThread(fn, args, tls.threadPrologue(), ThreadOptions{})
+		if err != nil {
+			return CallResult{}, err
+		}
+		return join(), nil
+	}
+	return measureCall(func() CallResult {
+		if tls.usesStaticBlock() {
+			tls.enterThread()
+		}
+		return callNative(fn, args)
+	}), nil
+}
+
+// callCode calls fn with arg on the calling thread and returns its raw return
+// value and errno.
+func callCode(fn, arg uintptr) CallResult {
+	return measureCall(func() CallResult { return callNative(fn, [MaxCallArgs]uintptr{arg}) })
+}
+
+// StartExportThread calls an exported zero-argument function on a new native
+// thread configured by opts. The returned wait function blocks until the
+// export returns, reports its return value and errno, and must be called
+// exactly once; Free blocks until then.
+func (module *Module) StartExportThread(name string, opts ThreadOptions) (func() CallResult, error) {
+	module.mu.RLock()
+	addr, err := module.exportAddressLocked(name)
+	if err != nil {
+		module.mu.RUnlock()
+		return nil, err
+	}
+
+	join, err := startNativeThread(addr, [MaxCallArgs]uintptr{}, module.tls.threadPrologue(), opts)
+	if err != nil {
+		module.mu.RUnlock()
+		return nil, fmt.Errorf("start export %q: %w", name, err)
+	}
+	return func() CallResult {
+		defer module.mu.RUnlock()
+		return join()
+	}, nil
+}
+
+func (module *Module) exportAddressLocked(name string) (uintptr, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return errors.New("export name cannot be empty")
+		return 0, errors.New("export name cannot be empty")
+	}
+	if ordinal, ok, err := parseOrdinalName(name); ok {
+		if err != nil {
+			return 0, err
+		}
+		addr, err := module.procAddressByOrdinalLocked(ordinal)
+		if err != nil {
+			return 0, fmt.Errorf("resolve export %q: %w", name, err)
+		}
+		return addr, nil
 	}
 
 	candidates := []string{name}
@@ -162,17 +486,12 @@ func (module *Module) CallExport(name string) error {
 		err  error
 	)
 	for _, candidate := range candidates {
-		addr, err = module.ProcAddressByName(candidate)
+		addr, err = module.procAddressByNameLocked(candidate)
 		if err == nil {
-			break
+			return addr, nil
 		}
 	}
-	if err != nil {
-		return fmt.Errorf("resolve export %q: %w", name, err)
-	}
-
-	_ = cCall0(addr)
-	return nil
+	return 0, fmt.Errorf("resolve export %q: %w", name, err)
 }
 
 func (module *Module) ProcAddressByName(name string) (uintptr, error) {
@@ -183,7 +502,10 @@ func (module *Module) ProcAddressByName(name string) (uintptr, error) {
 
 	module.mu.RLock()
 	defer module.mu.RUnlock()
+	return module.procAddressByNameLocked(name)
+}
 
+func (module *Module) procAddressByNameLocked(name string) (uintptr, error) {
 	if module.closed {
 		return 0, errors.New("library is closed")
 	}
@@ -194,22 +516,116 @@ func (module *Module) ProcAddressByName(name string) (uintptr, error) {
 		return 0, errors.New("symbol table is empty")
 	}
 
-	if addr, ok := module.symbols[name]; ok && addr != 0 {
+	if sym, ok := module.symbols[name]; ok && sym.Address != 0 {
+		return sym.Address, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrSymbolNotFound, name)
+}
+
+// FindSymbol looks name up the way the image's own references resolve: its
+// exports first, then each DT_NEEDED library in the order the image lists
+// them, then the process's global scope (dlsym with RTLD_DEFAULT).
+func (module *Module) FindSymbol(name string) (uintptr, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("symbol name cannot be empty")
+	}
+
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+	if module.closed {
+		return 0, errors.New("library is closed")
+	}
+	if addr, err := module.procAddressByNameLocked(name); err == nil {
 		return addr, nil
 	}
-	return 0, fmt.Errorf("symbol %q not found", name)
+
+	if loaded, err := runtimeModules(); err == nil {
+		for _, dep := range module.needed {
+			for _, candidate := range loaded {
+				if filepath.Base(candidate.path) != filepath.Base(dep) {
+					continue
+				}
+				if addr, err := resolveFromRuntimeModules([]runtimeELFModule{candidate}, name, ""); err == nil {
+					return addr, nil
+				}
+			}
+		}
+	}
+	if api, err := getLinuxDynAPI(); err == nil {
+		if addr, err := resolveWithDLSym(api, name); err == nil {
+			return addr, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q is not in the image, its dependencies, or the global scope", ErrSymbolNotFound, name)
 }
 
+// Exports returns the sorted global function symbol names the image defines,
+// including versioned names with their version suffix stripped, plus whatever
+// LoadOptions.Symbols added.
+func (module *Module) Exports() ([]string, error) {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	if module.closed {
+		return nil, errors.New("library is closed")
+	}
+	return module.exportsLocked(), nil
+}
+
+func (module *Module) exportsLocked() []string {
+	names := make([]string, 0, len(module.symbols))
+	for name := range module.symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Symbols is like Exports but describes each symbol, sorted by name.
+func (module *Module) Symbols() ([]Symbol, error) {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	if module.closed {
+		return nil, errors.New("library is closed")
+	}
+	out := make([]Symbol, 0, len(module.symbols))
+	for _, sym := range module.symbols {
+		out = append(out, sym)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// ProcAddressByOrdinal returns the address of the export with the given
+// emulated ordinal: ELF has no ordinals, so ordinal N is the Nth name of
+// Exports, counting from 1.
 func (module *Module) ProcAddressByOrdinal(ordinal uint16) (uintptr, error) {
-	_ = ordinal
-	return 0, errors.New("ProcAddressByOrdinal is not supported on linux; use ProcAddressByName")
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+	return module.procAddressByOrdinalLocked(ordinal)
 }
 
-func mapELFImage(raw []byte, f *elf.File) (mappedELF, error) {
+func (module *Module) procAddressByOrdinalLocked(ordinal uint16) (uintptr, error) {
+	if module.closed {
+		return 0, errors.New("library is closed")
+	}
+	name, err := emulatedOrdinalName(module.exportsLocked(), ordinal)
+	if err != nil {
+		return 0, err
+	}
+	return module.procAddressByNameLocked(name)
+}
+
+func mapELFImage(raw []byte, f *elf.File, opts LoadOptions) (mappedELF, error) {
 	pageSize := uint64(unix.Getpagesize())
 	if pageSize == 0 {
 		return mappedELF{}, errors.New("invalid page size")
 	}
+	if err := checkELFLayout(f.Progs, uint64(len(raw)), pageSize); err != nil {
+		return mappedELF{}, err
+	}
 
 	var (
 		minVAddr uint64 = ^uint64(0)
@@ -246,27 +662,46 @@ func mapELFImage(raw []byte, f *elf.File) (mappedELF, error) {
 	if err != nil {
 		return mappedELF{}, err
 	}
+	if err := reserveMapping(mapSize, opts); err != nil {
+		return mappedELF{}, err
+	}
 
-	mapping, err := unix.Mmap(-1, 0, mapLen, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	prot, flags := unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON
+	if opts.LazyCommit {
+		prot, flags = unix.PROT_NONE, flags|unix.MAP_NORESERVE
+	}
+	// Without MAP_FIXED the address is a hint; the kernel places the image
+	// elsewhere if the range is taken.
+	addr, err := unix.MmapPtr(-1, 0, unsafe.Pointer(opts.baseHint()), uintptr(mapLen), prot, flags)
 	if err != nil {
+		releaseMapping(mapSize)
 		return mappedELF{}, fmt.Errorf("mmap ELF image: %w", err)
 	}
-	if len(mapping) == 0 {
-		return mappedELF{}, errors.New("mmap ELF image returned empty mapping")
-	}
+	mapping := unsafe.Slice((*byte)(addr), mapLen)
 
 	loadBias := uintptr(unsafe.Pointer(&mapping[0])) - uintptr(minVAddr)
+	if opts.LazyCommit {
+		// Open up only the segment ranges; gaps stay reserved and inaccessible.
+		for _, p := range progs {
+			start := alignDown64(p.Vaddr, pageSize) - minVAddr
+			end := alignUp64(p.Vaddr+p.Memsz, pageSize) - minVAddr
+			if err := unix.Mprotect(mapping[start:end], unix.PROT_READ|unix.PROT_WRITE); err != nil {
+				unmapImage(mapping)
+				return mappedELF{}, fmt.Errorf("mprotect PT_LOAD vaddr=%#x memsz=%#x: %w", p.Vaddr, p.Memsz, err)
+			}
+		}
+	}
 	for _, p := range progs {
 		if p.Filesz == 0 {
 			continue
 		}
 		if p.Off > uint64(len(raw)) || p.Filesz > uint64(len(raw))-p.Off {
-			_ = unix.Munmap(mapping)
+			unmapImage(mapping)
 			return mappedELF{}, fmt.Errorf("segment file range out of bounds off=%#x filesz=%#x", p.Off, p.Filesz)
 		}
 		dstLen, err := u64ToInt(p.Filesz)
 		if err != nil {
-			_ = unix.Munmap(mapping)
+			unmapImage(mapping)
 			return mappedELF{}, err
 		}
 		dst := unsafe.Slice((*byte)(unsafe.Pointer(loadBias+uintptr(p.Vaddr))), dstLen)
@@ -274,6 +709,7 @@ func mapELFImage(raw []byte, f *elf.File) (mappedELF, error) {
 		copy(dst, src)
 	}
 
+	trace(opts.Logger, stageMap, "mapped image", addrAttr("base", uintptr(addr)), "size", mapLen, "segments", len(progs))
 	return mappedELF{
 		mapping:  mapping,
 		loadBias: loadBias,
@@ -294,29 +730,40 @@ func applyDynamicRelocations(mapped mappedELF, f *elf.File, resolver *symbolReso
 		return fmt.Errorf("read dynamic symbol table: %w", err)
 	}
 
-	for _, sec := range relocationSections(f) {
-		data, err := sec.Data()
-		if err != nil {
-			return fmt.Errorf("read relocation section %s: %w", sec.Name, err)
+	sections := relocationSections(f)
+	tables := make([][]relocEntry, len(sections))
+	gotRelocs := 0
+	for i, sec := range sections {
+		if tables[i], err = readRelocations(sec, f.Class); err != nil {
+			return err
 		}
-		if len(data) == 0 {
-			continue
+		for _, entry := range tables[i] {
+			if isGOTRelative(f.Machine, entry.relocType) {
+				gotRelocs++
+			}
 		}
+	}
+	if gotRelocs != 0 {
+		if resolver.got, err = newGOTTable(mapped.mapping, gotRelocs, resolver.maxMapped); err != nil {
+			return err
+		}
+	}
 
-		switch sec.Type {
-		case elf.SHT_RELA:
-			if err := applyRELASection(data, f, mapped, dynSyms, resolver, sec.Name); err != nil {
-				return err
+	applied := 0
+	for i, sec := range sections {
+		for j, entry := range tables[i] {
+			if err := applyOneRelocation(f.Machine, f.Class, mapped, dynSyms, resolver, entry); err != nil {
+				return fmt.Errorf("%s[%d]: %w", sec.Name, j, err)
 			}
-		case elf.SHT_REL:
-			if err := applyRELSection(data, f, mapped, dynSyms, resolver, sec.Name); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unsupported relocation section type %s in %s", sec.Type, sec.Name)
 		}
+		applied += len(tables[i])
 	}
-
+	if resolver.got != nil {
+		if err := resolver.got.seal(); err != nil {
+			return err
+		}
+	}
+	trace(resolver.logger, stageRelocate, "applied relocations", "relocations", applied, "symbols", len(resolver.resolved), "got", gotRelocs)
 	return nil
 }
 
@@ -338,84 +785,82 @@ func relocationSections(f *elf.File) []*elf.Section {
 	return out
 }
 
-func applyRELASection(data []byte, f *elf.File, mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, sectionName string) error {
-	switch f.Class {
-	case elf.ELFCLASS64:
-		const ent = 24
-		if len(data)%ent != 0 {
-			return fmt.Errorf("malformed %s: size %d is not a multiple of %d", sectionName, len(data), ent)
-		}
-		for i := 0; i < len(data); i += ent {
-			off := binary.LittleEndian.Uint64(data[i : i+8])
-			info := binary.LittleEndian.Uint64(data[i+8 : i+16])
-			addend := int64(binary.LittleEndian.Uint64(data[i+16 : i+24]))
-			if err := applyOneRelocation(f.Machine, f.Class, mapped, dynSyms, resolver, uint32(elf.R_SYM64(info)), uint32(elf.R_TYPE64(info)), off, addend, true); err != nil {
-				return fmt.Errorf("%s[%d]: %w", sectionName, i/ent, err)
-			}
-		}
-	case elf.ELFCLASS32:
-		const ent = 12
-		if len(data)%ent != 0 {
-			return fmt.Errorf("malformed %s: size %d is not a multiple of %d", sectionName, len(data), ent)
-		}
-		for i := 0; i < len(data); i += ent {
-			off := uint64(binary.LittleEndian.Uint32(data[i : i+4]))
-			info := binary.LittleEndian.Uint32(data[i+4 : i+8])
-			addend := int64(int32(binary.LittleEndian.Uint32(data[i+8 : i+12])))
-			if err := applyOneRelocation(f.Machine, f.Class, mapped, dynSyms, resolver, elf.R_SYM32(info), elf.R_TYPE32(info), off, addend, true); err != nil {
-				return fmt.Errorf("%s[%d]: %w", sectionName, i/ent, err)
-			}
-		}
+// relocEntry is one entry of a REL or RELA section. REL entries have no
+// addend of their own; theirs is stored in the slot they relocate.
+type relocEntry struct {
+	offset    uint64
+	symIndex  uint32
+	relocType uint32
+	addend    int64
+	hasAddend bool
+}
+
+// readRelocations decodes a REL or RELA section of a little-endian image.
+func readRelocations(sec *elf.Section, class elf.Class) ([]relocEntry, error) {
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("read relocation section %s: %w", sec.Name, err)
+	}
+
+	var ent int
+	switch {
+	case sec.Type == elf.SHT_RELA && class == elf.ELFCLASS64:
+		ent = 24
+	case sec.Type == elf.SHT_RELA && class == elf.ELFCLASS32:
+		ent = 12
+	case sec.Type == elf.SHT_REL && class == elf.ELFCLASS64:
+		ent = 16
+	case sec.Type == elf.SHT_REL && class == elf.ELFCLASS32:
+		ent = 8
+	case sec.Type != elf.SHT_RELA && sec.Type != elf.SHT_REL:
+		return nil, fmt.Errorf("unsupported relocation section type %s in %s", sec.Type, sec.Name)
 	default:
-		return fmt.Errorf("unsupported ELF class in %s: %s", sectionName, f.Class)
+		return nil, fmt.Errorf("unsupported ELF class in %s: %s", sec.Name, class)
+	}
+	if len(data)%ent != 0 {
+		return nil, fmt.Errorf("malformed %s: size %d is not a multiple of %d", sec.Name, len(data), ent)
 	}
-	return nil
-}
 
-func applyRELSection(data []byte, f *elf.File, mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, sectionName string) error {
-	switch f.Class {
-	case elf.ELFCLASS64:
-		const ent = 16
-		if len(data)%ent != 0 {
-			return fmt.Errorf("malformed %s: size %d is not a multiple of %d", sectionName, len(data), ent)
-		}
-		for i := 0; i < len(data); i += ent {
-			off := binary.LittleEndian.Uint64(data[i : i+8])
+	entries := make([]relocEntry, 0, len(data)/ent)
+	for i := 0; i < len(data); i += ent {
+		var entry relocEntry
+		if class == elf.ELFCLASS64 {
 			info := binary.LittleEndian.Uint64(data[i+8 : i+16])
-			if err := applyOneRelocation(f.Machine, f.Class, mapped, dynSyms, resolver, uint32(elf.R_SYM64(info)), uint32(elf.R_TYPE64(info)), off, 0, false); err != nil {
-				return fmt.Errorf("%s[%d]: %w", sectionName, i/ent, err)
+			entry.offset = binary.LittleEndian.Uint64(data[i : i+8])
+			entry.symIndex = uint32(elf.R_SYM64(info))
+			entry.relocType = uint32(elf.R_TYPE64(info))
+			if sec.Type == elf.SHT_RELA {
+				entry.addend = int64(binary.LittleEndian.Uint64(data[i+16 : i+24]))
+				entry.hasAddend = true
 			}
-		}
-	case elf.ELFCLASS32:
-		const ent = 8
-		if len(data)%ent != 0 {
-			return fmt.Errorf("malformed %s: size %d is not a multiple of %d", sectionName, len(data), ent)
-		}
-		for i := 0; i < len(data); i += ent {
-			off := uint64(binary.LittleEndian.Uint32(data[i : i+4]))
+		} else {
 			info := binary.LittleEndian.Uint32(data[i+4 : i+8])
-			if err := applyOneRelocation(f.Machine, f.Class, mapped, dynSyms, resolver, elf.R_SYM32(info), elf.R_TYPE32(info), off, 0, false); err != nil {
-				return fmt.Errorf("%s[%d]: %w", sectionName, i/ent, err)
+			entry.offset = uint64(binary.LittleEndian.Uint32(data[i : i+4]))
+			entry.symIndex = elf.R_SYM32(info)
+			entry.relocType = elf.R_TYPE32(info)
+			if sec.Type == elf.SHT_RELA {
+				entry.addend = int64(int32(binary.LittleEndian.Uint32(data[i+8 : i+12])))
+				entry.hasAddend = true
 			}
 		}
-	default:
-		return fmt.Errorf("unsupported ELF class in %s: %s", sectionName, f.Class)
+		entries = append(entries, entry)
 	}
-	return nil
+	return entries, nil
 }
 
-func applyOneRelocation(machine elf.Machine, class elf.Class, mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, symIndex uint32, relocType uint32, offset uint64, addend int64, hasAddend bool) error {
-	place := mapped.loadBias + uintptr(offset)
+func applyOneRelocation(machine elf.Machine, class elf.Class, mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, entry relocEntry) error {
+	place := mapped.loadBias + uintptr(entry.offset)
 
 	wordSize := 8
 	if class == elf.ELFCLASS32 {
 		wordSize = 4
 	}
 	if !mappedAddressInRange(mapped.mapping, place, wordSize) {
-		return fmt.Errorf("relocation target %#x out of mapped image", offset)
+		return fmt.Errorf("relocation target %#x out of mapped image", entry.offset)
 	}
 
-	if !hasAddend {
+	addend := entry.addend
+	if !entry.hasAddend {
 		switch class {
 		case elf.ELFCLASS64:
 			addend = int64(readU64(place))
@@ -426,114 +871,244 @@ func applyOneRelocation(machine elf.Machine, class elf.Class, mapped mappedELF,
 		}
 	}
 
+	if isTLSRelocation(machine, entry.relocType) {
+		words, err := tlsRelocation(machine, entry.relocType, place, dynSyms, entry.symIndex, addend, resolver.tls)
+		if err != nil {
+			return err
+		}
+		if !mappedAddressInRange(mapped.mapping, place, 8*len(words)) {
+			return fmt.Errorf("relocation target %#x out of mapped image", entry.offset)
+		}
+		for i, word := range words {
+			writeU64(place+uintptr(8*i), word)
+		}
+		return nil
+	}
+
+	if isIRelative(machine, entry.relocType) {
+		// The addend is the resolver; the slot gets what it selects.
+		resolver.ifuncs.pending = append(resolver.ifuncs.pending, ifuncReloc{
+			machine:   machine,
+			place:     place,
+			relocType: entry.relocType,
+			resolver:  uintptr(int64(mapped.loadBias) + addend),
+		})
+		return nil
+	}
+	if isCopyRelocation(machine, entry.relocType) {
+		return applyCopyRelocation(mapped, dynSyms, resolver, entry.symIndex, place)
+	}
+
 	var symValue uintptr
-	if symIndex != 0 {
-		resolved, err := resolveRelocationSymbol(symIndex, dynSyms, mapped.loadBias, resolver)
+	if entry.symIndex != 0 {
+		if sym, ok := dynSymbolByIndex(dynSyms, entry.symIndex); ok && isLocalIFunc(sym) {
+			if isGOTRelative(machine, entry.relocType) {
+				return fmt.Errorf("%s relocation against indirect function %q is not supported", relocTypeName(machine, entry.relocType), sym.Name)
+			}
+			resolver.ifuncs.pending = append(resolver.ifuncs.pending, ifuncReloc{
+				machine:   machine,
+				place:     place,
+				relocType: entry.relocType,
+				resolver:  mapped.loadBias + uintptr(sym.Value),
+				addend:    addend,
+			})
+			return nil
+		}
+		resolved, err := resolveRelocationSymbol(entry.symIndex, dynSyms, mapped.loadBias, resolver)
 		if err != nil {
 			return err
 		}
 		symValue = resolved
 	}
 
+	if isGOTRelative(machine, entry.relocType) {
+		slot, err := resolver.got.slot(symValue)
+		if err != nil {
+			return err
+		}
+		w, err := gotRelocValue(entry.relocType, place, slot, addend)
+		if err != nil {
+			return err
+		}
+		storeReloc(place, w)
+		return nil
+	}
+	return applyReloc(machine, entry.relocType, place, mapped.loadBias, symValue, addend)
+}
+
+// applyCopyRelocation fills the image's own copy of a data object, which
+// executables reserve for objects of their dependencies, with the object's
+// current contents. Unlike ld.so, the loader cannot point the dependency's
+// references at the copy, so each side sees its own object afterwards.
+func applyCopyRelocation(mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, symIndex uint32, place uintptr) error {
+	sym, ok := dynSymbolByIndex(dynSyms, symIndex)
+	if !ok {
+		return fmt.Errorf("copy relocation references invalid symbol index %d", symIndex)
+	}
+	if sym.Name == "" || sym.Size == 0 {
+		return fmt.Errorf("copy relocation for symbol index %d has no name or size", symIndex)
+	}
+	size, err := u64ToInt(sym.Size)
+	if err != nil {
+		return err
+	}
+	if !mappedAddressInRange(mapped.mapping, place, size) {
+		return fmt.Errorf("copy relocation for %q out of mapped image", sym.Name)
+	}
+
+	addr, ok := uintptr(0), false
+	if resolver.hook != nil {
+		addr, ok = resolver.hook(sym.Library, sym.Name)
+	}
+	if !ok {
+		name := sym.Name
+		if sym.HasVersion && sym.Version != "" {
+			name += "@" + sym.Version
+		}
+		if addr, err = resolver.Resolve(name); err != nil {
+			return fmt.Errorf("resolve copy relocation source %q: %w", sym.Name, err)
+		}
+	}
+	if addr == 0 {
+		return fmt.Errorf("resolved copy relocation source %q to nil address", sym.Name)
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(place)), size), unsafe.Slice((*byte)(unsafe.Pointer(addr)), size))
+	resolver.bind(symIndex, addr)
+	return nil
+}
+
+// isCopyRelocation reports whether relocType is a copy relocation.
+func isCopyRelocation(machine elf.Machine, relocType uint32) bool {
 	switch machine {
 	case elf.EM_X86_64:
-		return applyX8664Reloc(relocType, place, mapped.loadBias, symValue, addend)
+		return elf.R_X86_64(relocType) == elf.R_X86_64_COPY
 	case elf.EM_386:
-		return apply386Reloc(relocType, place, mapped.loadBias, symValue, addend)
+		return elf.R_386(relocType) == elf.R_386_COPY
 	case elf.EM_AARCH64:
-		return applyAArch64Reloc(relocType, place, mapped.loadBias, symValue, addend)
+		return elf.R_AARCH64(relocType) == elf.R_AARCH64_COPY
+	}
+	return false
+}
+
+// relocWrite is what a relocation stores at its place: the low size bytes of
+// value. A zero size stores nothing.
+type relocWrite struct {
+	value uint64
+	size  int
+}
+
+// applyReloc computes a relocation and stores the result at place.
+func applyReloc(machine elf.Machine, relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) error {
+	w, err := relocValue(machine, relocType, place, loadBias, symValue, addend)
+	if err != nil {
+		return err
+	}
+	storeReloc(place, w)
+	return nil
+}
+
+// storeReloc stores what a relocation computed at place.
+func storeReloc(place uintptr, w relocWrite) {
+	switch w.size {
+	case 8:
+		writeU64(place, w.value)
+	case 4:
+		writeU32(place, uint32(w.value))
+	}
+}
+
+// relocValue computes what a relocation stores at place without storing it.
+func relocValue(machine elf.Machine, relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) (relocWrite, error) {
+	switch machine {
+	case elf.EM_X86_64:
+		return x8664RelocValue(relocType, place, loadBias, symValue, addend)
+	case elf.EM_386:
+		return i386RelocValue(relocType, place, loadBias, symValue, addend)
+	case elf.EM_AARCH64:
+		return aarch64RelocValue(relocType, place, loadBias, symValue, addend)
 	default:
-		return fmt.Errorf("unsupported machine for relocation: %s", machine)
+		return relocWrite{}, fmt.Errorf("unsupported machine for relocation: %s", machine)
 	}
 }
 
-func applyX8664Reloc(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) error {
+func x8664RelocValue(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) (relocWrite, error) {
 	switch elf.R_X86_64(relocType) {
 	case elf.R_X86_64_NONE:
-		return nil
+		return relocWrite{}, nil
 	case elf.R_X86_64_RELATIVE:
-		writeU64(place, uint64(int64(loadBias)+addend))
-		return nil
-	case elf.R_X86_64_TPOFF64:
-		// Linux TLS local-exec relocation. The pure-Go loader does not provision
-		// module TLS blocks, so we apply S+A and rely on payload/runtime behavior
-		// that does not require a non-zero static TLS offset.
-		writeU64(place, uint64(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(int64(loadBias) + addend), 8}, nil
+	case elf.R_X86_64_IRELATIVE:
+		return relocWrite{uint64(symValue), 8}, nil
+	case elf.R_X86_64_COPY:
+		return relocWrite{}, nil
 	case elf.R_X86_64_JMP_SLOT, elf.R_X86_64_GLOB_DAT, elf.R_X86_64_64:
-		writeU64(place, uint64(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(int64(symValue) + addend), 8}, nil
 	case elf.R_X86_64_32:
 		v := int64(symValue) + addend
 		if v < 0 || v > 0xffffffff {
-			return fmt.Errorf("x86_64 32 relocation overflow: value=%d", v)
+			return relocWrite{}, fmt.Errorf("x86_64 32 relocation overflow: value=%d", v)
 		}
-		writeU32(place, uint32(v))
-		return nil
+		return relocWrite{uint64(uint32(v)), 4}, nil
 	case elf.R_X86_64_32S:
 		v := int64(symValue) + addend
 		if v < -0x80000000 || v > 0x7fffffff {
-			return fmt.Errorf("x86_64 32S relocation overflow: value=%d", v)
+			return relocWrite{}, fmt.Errorf("x86_64 32S relocation overflow: value=%d", v)
 		}
-		writeU32(place, uint32(int32(v)))
-		return nil
+		return relocWrite{uint64(uint32(int32(v))), 4}, nil
 	case elf.R_X86_64_PC32:
 		v := int64(symValue) + addend - int64(place)
 		if v < -0x80000000 || v > 0x7fffffff {
-			return fmt.Errorf("x86_64 PC32 relocation overflow: value=%d", v)
+			return relocWrite{}, fmt.Errorf("x86_64 PC32 relocation overflow: value=%d", v)
 		}
-		writeU32(place, uint32(int32(v)))
-		return nil
+		return relocWrite{uint64(uint32(int32(v))), 4}, nil
 	default:
-		return fmt.Errorf("unsupported x86_64 relocation type: %d", relocType)
+		return relocWrite{}, fmt.Errorf("unsupported x86_64 relocation type: %d", relocType)
 	}
 }
 
-func apply386Reloc(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) error {
+func i386RelocValue(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) (relocWrite, error) {
 	switch elf.R_386(relocType) {
 	case elf.R_386_NONE:
-		return nil
+		return relocWrite{}, nil
 	case elf.R_386_RELATIVE:
-		writeU32(place, uint32(int64(loadBias)+addend))
-		return nil
-	case elf.R_386_TLS_TPOFF:
-		// Linux TLS local-exec relocation; see R_X86_64_TPOFF64 note above.
-		writeU32(place, uint32(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(uint32(int64(loadBias) + addend)), 4}, nil
+	case elf.R_386_IRELATIVE:
+		return relocWrite{uint64(uint32(symValue)), 4}, nil
+	case elf.R_386_COPY:
+		return relocWrite{}, nil
+	case elf.R_386_TLS_TPOFF, elf.R_386_TLS_TPOFF32, elf.R_386_TLS_DTPMOD32, elf.R_386_TLS_DTPOFF32, elf.R_386_TLS_DESC:
+		// Unlike amd64 and arm64, offsets from a missing TLS block land in
+		// glibc's own %gs-based thread data, so refuse rather than corrupt it.
+		return relocWrite{}, fmt.Errorf("%w on linux/386: %s relocation", ErrTLSUnsupported, elf.R_386(relocType))
 	case elf.R_386_JMP_SLOT, elf.R_386_GLOB_DAT:
-		writeU32(place, uint32(symValue))
-		return nil
+		return relocWrite{uint64(uint32(symValue)), 4}, nil
 	case elf.R_386_32, elf.R_386_32PLT:
-		writeU32(place, uint32(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(uint32(int64(symValue) + addend)), 4}, nil
 	case elf.R_386_PC32:
 		v := int64(symValue) + addend - int64(place)
 		if v < -0x80000000 || v > 0x7fffffff {
-			return fmt.Errorf("386 PC32 relocation overflow: value=%d", v)
+			return relocWrite{}, fmt.Errorf("386 PC32 relocation overflow: value=%d", v)
 		}
-		writeU32(place, uint32(int32(v)))
-		return nil
+		return relocWrite{uint64(uint32(int32(v))), 4}, nil
 	default:
-		return fmt.Errorf("unsupported 386 relocation type: %d", relocType)
+		return relocWrite{}, fmt.Errorf("unsupported 386 relocation type: %d", relocType)
 	}
 }
 
-func applyAArch64Reloc(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) error {
+func aarch64RelocValue(relocType uint32, place uintptr, loadBias uintptr, symValue uintptr, addend int64) (relocWrite, error) {
 	switch elf.R_AARCH64(relocType) {
 	case elf.R_AARCH64_NONE:
-		return nil
+		return relocWrite{}, nil
 	case elf.R_AARCH64_RELATIVE:
-		writeU64(place, uint64(int64(loadBias)+addend))
-		return nil
-	case elf.R_AARCH64_TLS_TPREL64:
-		// Linux TLS local-exec relocation; see R_X86_64_TPOFF64 note above.
-		writeU64(place, uint64(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(int64(loadBias) + addend), 8}, nil
+	case elf.R_AARCH64_IRELATIVE:
+		return relocWrite{uint64(symValue), 8}, nil
+	case elf.R_AARCH64_COPY:
+		return relocWrite{}, nil
 	case elf.R_AARCH64_JUMP_SLOT, elf.R_AARCH64_GLOB_DAT, elf.R_AARCH64_ABS64:
-		writeU64(place, uint64(int64(symValue)+addend))
-		return nil
+		return relocWrite{uint64(int64(symValue) + addend), 8}, nil
 	default:
-		return fmt.Errorf("unsupported aarch64 relocation type: %d", relocType)
+		return relocWrite{}, fmt.Errorf("unsupported aarch64 relocation type: %d", relocType)
 	}
 }
 
@@ -546,28 +1121,68 @@ func resolveRelocationSymbol(symIndex uint32, dynSyms []elf.Symbol, loadBias uin
 	if !ok {
 		return 0, fmt.Errorf("relocation references invalid symbol index %d", symIndex)
 	}
-	bind := elf.ST_BIND(sym.Info)
-	if sym.Section == elf.SHN_UNDEF && bind == elf.STB_WEAK {
-		// Undefined weak symbols are optional and resolve to 0 by ELF rules.
-		return 0, nil
+	if addr, ok := imageSymbolValue(sym, loadBias); ok {
+		return addr, nil
 	}
-	if sym.Section != elf.SHN_UNDEF && sym.Value != 0 {
-		return loadBias + uintptr(sym.Value), nil
+	if sym.Name == "__tls_get_addr" && resolver.tls != nil {
+		// The image's TLS blocks are the loader's, not ld.so's.
+		addr := resolver.tls.getAddr()
+		resolver.bind(symIndex, addr)
+		return addr, nil
+	}
+	if sym.Name == "" && elf.ST_BIND(sym.Info) == elf.STB_WEAK {
+		resolver.bind(symIndex, 0)
+		return 0, nil
 	}
 	if sym.Name == "" {
 		return 0, fmt.Errorf("relocation symbol index %d is undefined and unnamed", symIndex)
 	}
 
-	addr, err := resolver.Resolve(sym.Name)
-	if err != nil {
-		return 0, fmt.Errorf("resolve external symbol %q: %w", sym.Name, err)
-	}
-	if addr == 0 {
-		return 0, fmt.Errorf("resolved external symbol %q to nil address", sym.Name)
+	addr, ok := uintptr(0), false
+	if resolver.hook != nil {
+		addr, ok = resolver.hook(sym.Library, sym.Name)
 	}
+	if !ok {
+		// Bind to the version the image was linked against, as ld.so does,
+		// rather than whatever a library's default version is now.
+		name := sym.Name
+		if sym.HasVersion && sym.Version != "" {
+			name += "@" + sym.Version
+		}
+		var err error
+		addr, err = resolver.Resolve(name)
+		switch {
+		case elf.ST_BIND(sym.Info) == elf.STB_WEAK && (err != nil || addr == 0):
+			// Undefined weak symbols bind like any other when something
+			// defines them, as ld.so binds __cxa_finalize, and to 0 by ELF
+			// rules when nothing does.
+			addr = 0
+		case err != nil:
+			return 0, fmt.Errorf("resolve external symbol %q: %w", sym.Name, err)
+		case addr == 0:
+			return 0, fmt.Errorf("resolved external symbol %q to nil address", sym.Name)
+		}
+	}
+	resolver.bind(symIndex, addr)
 	return addr, nil
 }
 
+// bind records what an external symbol was bound to.
+func (resolver *symbolResolver) bind(symIndex uint32, addr uintptr) {
+	if resolver.bound == nil {
+		resolver.bound = make(map[uint32]uintptr)
+	}
+	resolver.bound[symIndex] = addr
+}
+
+// imageSymbolValue returns the address of a symbol the image defines.
+func imageSymbolValue(sym elf.Symbol, loadBias uintptr) (uintptr, bool) {
+	if sym.Section != elf.SHN_UNDEF && sym.Value != 0 {
+		return loadBias + uintptr(sym.Value), true
+	}
+	return 0, false
+}
+
 func dynSymbolByIndex(dynSyms []elf.Symbol, symIndex uint32) (elf.Symbol, bool) {
 	// debug/elf.DynamicSymbols omits the null symbol at dynsym index 0.
 	if symIndex == 0 {
@@ -580,6 +1195,28 @@ func dynSymbolByIndex(dynSyms []elf.Symbol, symIndex uint32) (elf.Symbol, bool)
 	return dynSyms[idx], true
 }
 
+// unmapImage releases a mapping created by mapELFImage and its reservation
+// against the mapping budget.
+func unmapImage(mapping []byte) {
+	if len(mapping) != 0 {
+		_ = unix.MunmapPtr(unsafe.Pointer(&mapping[0]), uintptr(len(mapping)))
+		releaseMapping(uint64(len(mapping)))
+	}
+}
+
+// segments returns the page-aligned range of each PT_LOAD segment.
+func (mapped mappedELF) segments() [][]byte {
+	pageSize := uint64(unix.Getpagesize())
+	base := uintptr(unsafe.Pointer(&mapped.mapping[0]))
+	var out [][]byte
+	for _, p := range mapped.progs {
+		start := mapped.loadBias + uintptr(alignDown64(p.Vaddr, pageSize))
+		end := mapped.loadBias + uintptr(alignUp64(p.Vaddr+p.Memsz, pageSize))
+		out = append(out, mapped.mapping[start-base:end-base])
+	}
+	return out
+}
+
 func applySegmentProtections(mapped mappedELF) error {
 	pageSize := uint64(unix.Getpagesize())
 	if pageSize == 0 {
@@ -611,7 +1248,30 @@ func applySegmentProtections(mapped mappedELF) error {
 	return nil
 }
 
-func runELFInitializers(mapped mappedELF, f *elf.File) error {
+// initCaller calls one initializer with the startup-style arguments.
+type initCaller func(fn, argc, argv, envp uintptr) error
+
+// runInitializers runs the image's initializers on pthreads of their own,
+// or all of them on the calling thread.
+func runInitializers(mapped mappedELF, f *elf.File, onPthread bool, tls *moduleTLS) error {
+	if onPthread {
+		return runELFInitializers(mapped, f, func(fn, argc, argv, envp uintptr) error {
+			_, err := callImage(fn, [MaxCallArgs]uintptr{argc, argv, envp}, true, tls)
+			return err
+		})
+	}
+	if tls.usesStaticBlock() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tls.enterThread()
+	}
+	return runELFInitializers(mapped, f, func(fn, argc, argv, envp uintptr) error {
+		_ = cCall3(fn, argc, argv, envp)
+		return nil
+	})
+}
+
+func runELFInitializers(mapped mappedELF, f *elf.File, call initCaller) error {
 	info, err := parseDynamicInitInfo(f)
 	if err != nil {
 		return err
@@ -619,13 +1279,13 @@ func runELFInitializers(mapped mappedELF, f *elf.File) error {
 	argc, argv, envp := linuxInitCallArgs()
 	skip := collectInitSkipAddrs(f, mapped.loadBias)
 
-	if err := callDynamicInitArray(mapped, f.Class, info.preinitArr, info.preinitSz, "DT_PREINIT_ARRAY", argc, argv, envp, skip); err != nil {
+	if err := callDynamicInitArray(mapped, f.Class, info.preinitArr, info.preinitSz, "DT_PREINIT_ARRAY", argc, argv, envp, skip, call); err != nil {
 		return err
 	}
-	if err := callDynamicInitFn(mapped, uintptr(info.init), "DT_INIT", argc, argv, envp, skip); err != nil {
+	if err := callDynamicInitFn(mapped, uintptr(info.init), "DT_INIT", argc, argv, envp, skip, call); err != nil {
 		return err
 	}
-	if err := callDynamicInitArray(mapped, f.Class, info.initArray, info.initArraySz, "DT_INIT_ARRAY", argc, argv, envp, skip); err != nil {
+	if err := callDynamicInitArray(mapped, f.Class, info.initArray, info.initArraySz, "DT_INIT_ARRAY", argc, argv, envp, skip, call); err != nil {
 		return err
 	}
 	return nil
@@ -671,6 +1331,12 @@ func parseDynamicInitInfo(f *elf.File) (dynamicInitInfo, error) {
 				info.preinitArr = val
 			case dynTagPreinitSz:
 				info.preinitSz = val
+			case dynTagFini:
+				info.fini = val
+			case dynTagFiniArray:
+				info.finiArray = val
+			case dynTagFiniArraySz:
+				info.finiArraySz = val
 			}
 		}
 	case elf.ELFCLASS32:
@@ -695,6 +1361,12 @@ func parseDynamicInitInfo(f *elf.File) (dynamicInitInfo, error) {
 				info.preinitArr = val
 			case dynTagPreinitSz:
 				info.preinitSz = val
+			case dynTagFini:
+				info.fini = val
+			case dynTagFiniArray:
+				info.finiArray = val
+			case dynTagFiniArraySz:
+				info.finiArraySz = val
 			}
 		}
 	default:
@@ -703,7 +1375,7 @@ func parseDynamicInitInfo(f *elf.File) (dynamicInitInfo, error) {
 	return info, nil
 }
 
-func callDynamicInitFn(mapped mappedELF, fn uintptr, source string, argc uintptr, argv uintptr, envp uintptr, skip map[uintptr]struct{}) error {
+func callDynamicInitFn(mapped mappedELF, fn uintptr, source string, argc uintptr, argv uintptr, envp uintptr, skip map[uintptr]struct{}, call initCaller) error {
 	if fn == 0 {
 		return nil
 	}
@@ -714,13 +1386,36 @@ func callDynamicInitFn(mapped mappedELF, fn uintptr, source string, argc uintptr
 	if _, blocked := skip[resolved]; blocked {
 		return nil
 	}
-	_ = cCall3(resolved, argc, argv, envp)
+	if err := call(resolved, argc, argv, envp); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
 	return nil
 }
 
-func callDynamicInitArray(mapped mappedELF, class elf.Class, arrayVAddr uint64, arraySz uint64, source string, argc uintptr, argv uintptr, envp uintptr, skip map[uintptr]struct{}) error {
+func callDynamicInitArray(mapped mappedELF, class elf.Class, arrayVAddr uint64, arraySz uint64, source string, argc uintptr, argv uintptr, envp uintptr, skip map[uintptr]struct{}, call initCaller) error {
+	fns, err := readDynamicFnArray(mapped, class, arrayVAddr, arraySz, source)
+	if err != nil {
+		return err
+	}
+	for i, fn := range fns {
+		if fn == 0 {
+			continue
+		}
+		if _, blocked := skip[fn]; blocked {
+			continue
+		}
+		if err := call(fn, argc, argv, envp); err != nil {
+			return fmt.Errorf("%s[%d]: %w", source, i, err)
+		}
+	}
+	return nil
+}
+
+// readDynamicFnArray returns the functions of an init or fini array, with
+// zero for the empty (0 or -1) entries.
+func readDynamicFnArray(mapped mappedELF, class elf.Class, arrayVAddr uint64, arraySz uint64, source string) ([]uintptr, error) {
 	if arrayVAddr == 0 || arraySz == 0 {
-		return nil
+		return nil, nil
 	}
 
 	entrySize := 8
@@ -728,20 +1423,20 @@ func callDynamicInitArray(mapped mappedELF, class elf.Class, arrayVAddr uint64,
 		entrySize = 4
 	}
 	if arraySz%uint64(entrySize) != 0 {
-		return fmt.Errorf("%s has malformed size %#x for entry size %d", source, arraySz, entrySize)
+		return nil, fmt.Errorf("%s has malformed size %#x for entry size %d", source, arraySz, entrySize)
 	}
 	arrayLen, err := u64ToInt(arraySz)
 	if err != nil {
-		return fmt.Errorf("%s size does not fit in int: %w", source, err)
+		return nil, fmt.Errorf("%s size does not fit in int: %w", source, err)
 	}
 
 	arrayAddr := mapped.loadBias + uintptr(arrayVAddr)
 	if !mappedAddressInRange(mapped.mapping, arrayAddr, arrayLen) {
-		return fmt.Errorf("%s range %#x..%#x is outside mapped image", source, arrayVAddr, arrayVAddr+arraySz)
+		return nil, fmt.Errorf("%s range %#x..%#x is outside mapped image", source, arrayVAddr, arrayVAddr+arraySz)
 	}
 
-	count := int(arraySz / uint64(entrySize))
-	for i := 0; i < count; i++ {
+	fns := make([]uintptr, arrayLen/entrySize)
+	for i := range fns {
 		entryAddr := arrayAddr + uintptr(i*entrySize)
 		var fn uintptr
 		if entrySize == 8 {
@@ -754,18 +1449,50 @@ func callDynamicInitArray(mapped mappedELF, class elf.Class, arrayVAddr uint64,
 		}
 		resolved, ok := normalizeInitFnAddress(mapped, fn)
 		if !ok {
-			return fmt.Errorf("%s[%d] points outside mapped image: %#x", source, i, fn)
+			return nil, fmt.Errorf("%s[%d] points outside mapped image: %#x", source, i, fn)
 		}
-		if _, blocked := skip[resolved]; blocked {
-			continue
+		fns[i] = resolved
+	}
+	return fns, nil
+}
+
+// collectFinalizers returns the image's termination functions in the order
+// ld.so runs them at dlclose: DT_FINI_ARRAY from last to first, then DT_FINI.
+// The compiler's own entry calls __cxa_finalize for the image, which runs
+// the C++ global destructors and atexit handlers it registered.
+func collectFinalizers(mapped mappedELF, f *elf.File) ([]uintptr, error) {
+	info, err := parseDynamicInitInfo(f)
+	if err != nil {
+		return nil, err
+	}
+	fns, err := readDynamicFnArray(mapped, f.Class, info.finiArray, info.finiArraySz, "DT_FINI_ARRAY")
+	if err != nil {
+		return nil, err
+	}
+	var finalizers []uintptr
+	for i := len(fns) - 1; i >= 0; i-- {
+		if fns[i] != 0 {
+			finalizers = append(finalizers, fns[i])
 		}
-		_ = cCall3(resolved, argc, argv, envp)
 	}
-	return nil
+	if info.fini != 0 {
+		fn, ok := normalizeInitFnAddress(mapped, uintptr(info.fini))
+		if !ok {
+			return nil, fmt.Errorf("DT_FINI points outside mapped image: %#x", info.fini)
+		}
+		finalizers = append(finalizers, fn)
+	}
+	return finalizers, nil
+}
+
+// isGoImage reports whether f was built by the Go toolchain, such as a Go
+// c-shared payload.
+func isGoImage(f *elf.File) bool {
+	return f != nil && f.Section(".go.buildinfo") != nil
 }
 
 func collectInitSkipAddrs(f *elf.File, loadBias uintptr) map[uintptr]struct{} {
-	if f == nil || f.Section(".go.buildinfo") == nil {
+	if !isGoImage(f) {
 		return nil
 	}
 
@@ -821,64 +1548,113 @@ func normalizeInitFnAddress(mapped mappedELF, fn uintptr) (uintptr, bool) {
 	return 0, false
 }
 
-func buildExportedSymbolTable(f *elf.File, loadBias uintptr) map[string]uintptr {
-	out := make(map[string]uintptr)
+// buildExportedSymbolTable maps the image's global function names to their
+// addresses. GNU indirect functions map to the implementation their resolver
+// selects, or are left out when ifuncs is nil.
+func buildExportedSymbolTable(f *elf.File, loadBias uintptr, ifuncs *ifuncTable, filter SymbolFilter) (map[string]Symbol, error) {
+	out := make(map[string]Symbol)
 	if dynSyms, err := f.DynamicSymbols(); err == nil {
-		addELFSymbols(out, dynSyms, loadBias)
+		if err := addELFSymbols(out, dynSyms, loadBias, ifuncs, filter); err != nil {
+			return nil, err
+		}
 	}
 	if syms, err := f.Symbols(); err == nil {
-		addELFSymbols(out, syms, loadBias)
+		// Global symbols win over local ones of the same name, and the
+		// symbol table lists locals first.
+		if err := addELFSymbols(out, syms, loadBias, ifuncs, filter&^(SymbolsLocal|SymbolsHidden)); err != nil {
+			return nil, err
+		}
+		if err := addELFSymbols(out, syms, loadBias, ifuncs, filter); err != nil {
+			return nil, err
+		}
 	}
-	return out
+	return out, nil
 }
 
-func addELFSymbols(dst map[string]uintptr, symbols []elf.Symbol, loadBias uintptr) {
+func addELFSymbols(dst map[string]Symbol, symbols []elf.Symbol, loadBias uintptr, ifuncs *ifuncTable, filter SymbolFilter) error {
 	for _, sym := range symbols {
 		if sym.Name == "" || sym.Value == 0 || sym.Section == elf.SHN_UNDEF {
 			continue
 		}
-		bind := elf.ST_BIND(sym.Info)
-		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
-			continue
+		local := false
+		switch elf.ST_VISIBILITY(sym.Other) {
+		case elf.STV_HIDDEN, elf.STV_INTERNAL:
+			if filter&SymbolsHidden == 0 {
+				continue
+			}
+			local = true
+		default:
+			bind := elf.ST_BIND(sym.Info)
+			if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+				if bind != elf.STB_LOCAL || filter&SymbolsLocal == 0 {
+					continue
+				}
+				local = true
+			}
 		}
-		typ := elf.ST_TYPE(sym.Info)
-		if typ != elf.STT_FUNC && typ != elf.STT_NOTYPE {
+		kind := SymbolFunc
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FUNC, elf.STT_NOTYPE:
+		case elf.STT_GNU_IFUNC:
+			if ifuncs == nil {
+				continue
+			}
+		case elf.STT_OBJECT, elf.STT_COMMON:
+			if filter&SymbolsObjects == 0 {
+				continue
+			}
+			kind = SymbolObject
+		default:
 			continue
 		}
 		addr := loadBias + uintptr(sym.Value)
+		if elf.ST_TYPE(sym.Info) == elf.STT_GNU_IFUNC {
+			impl, err := ifuncs.resolve(addr)
+			if err != nil {
+				return fmt.Errorf("resolve ifunc %q: %w", sym.Name, err)
+			}
+			addr = impl
+		}
+		entry := Symbol{Name: sym.Name, Address: addr, Size: sym.Size, Kind: kind, Local: local}
 		if _, ok := dst[sym.Name]; !ok {
-			dst[sym.Name] = addr
+			dst[sym.Name] = entry
 		}
 		if at := strings.IndexByte(sym.Name, '@'); at > 0 {
-			base := sym.Name[:at]
-			if _, ok := dst[base]; !ok {
-				dst[base] = addr
+			entry.Name = sym.Name[:at]
+			if _, ok := dst[entry.Name]; !ok {
+				dst[entry.Name] = entry
 			}
 		}
 	}
+	return nil
 }
 
-func newSymbolResolver(f *elf.File) *symbolResolver {
+func newSymbolResolver(f *elf.File, opts LoadOptions) *symbolResolver {
 	resolver := &symbolResolver{
-		resolved: make(map[string]uintptr),
-		misses:   make(map[string]error),
-		opened:   make(map[string]uintptr),
+		resolved:      make(map[string]uintptr),
+		misses:        make(map[string]error),
+		opened:        make(map[string]uintptr),
+		searchPaths:   opts.SearchPaths,
+		needed:        collectNeededLibraries(f),
+		deterministic: opts.Deterministic,
+		musl:          useMusl(opts.Libc),
+		logger:        opts.Logger,
+		maxMapped:     opts.MaxTotalMappedBytes,
 	}
 	if modules, err := runtimeModules(); err == nil {
-		resolver.modules = modules
+		resolver.setModules(modules)
 	}
 	if api, err := getLinuxDynAPI(); err == nil {
 		resolver.api = api
 	}
 	if f != nil {
-		resolver.primeDependencies(f)
+		resolver.primeDependencies()
 	}
 	return resolver
 }
 
-func (resolver *symbolResolver) primeDependencies(f *elf.File) {
-	libs := collectNeededLibraries(f)
-	libs = append(libs, commonLinuxDependencies()...)
+func (resolver *symbolResolver) primeDependencies() {
+	libs := append(slices.Clone(resolver.needed), resolver.commonDependencies()...)
 	for _, lib := range libs {
 		_ = resolver.ensureLibraryLoaded(lib)
 	}
@@ -933,12 +1709,15 @@ func (resolver *symbolResolver) ensureLibraryLoaded(name string) error {
 	if resolver.hasModule(name) {
 		return nil
 	}
+	if resolver.musl && muslProvides(name) && resolver.hasMuslLibc() {
+		return nil
+	}
 	if resolver.api == nil || resolver.api.dlopen == 0 {
 		return errors.New("dlopen is unavailable")
 	}
 
 	var lastErr error
-	for _, candidate := range dlopenCandidates(name) {
+	for _, candidate := range dlopenCandidates(name, resolver.searchPaths) {
 		if candidate == "" {
 			continue
 		}
@@ -959,6 +1738,7 @@ func (resolver *symbolResolver) ensureLibraryLoaded(name string) error {
 		}
 		resolver.opened[candidate] = handle
 		resolver.opened[name] = handle
+		trace(resolver.logger, stageDependency, "loaded dependency", "library", name, "path", candidate)
 		resolver.refreshModules()
 		if resolver.hasModule(name) || resolver.hasModule(candidate) {
 			return nil
@@ -975,10 +1755,34 @@ func (resolver *symbolResolver) ensureLibraryLoaded(name string) error {
 
 func (resolver *symbolResolver) refreshModules() {
 	if modules, err := runtimeModules(); err == nil {
-		resolver.modules = modules
+		resolver.setModules(modules)
 	}
 }
 
+// setModules records the libraries loaded in the process. runtimeModules
+// puts libc-like paths first, which depends on the host; deterministic
+// resolvers instead put the image's own dependencies first, in DT_NEEDED
+// order, and the rest by path.
+func (resolver *symbolResolver) setModules(modules []runtimeELFModule) {
+	if resolver.deterministic {
+		rank := func(module runtimeELFModule) int {
+			for i, dep := range resolver.needed {
+				if filepath.Base(dep) == filepath.Base(module.path) {
+					return i
+				}
+			}
+			return len(resolver.needed)
+		}
+		sort.SliceStable(modules, func(i, j int) bool {
+			if ri, rj := rank(modules[i]), rank(modules[j]); ri != rj {
+				return ri < rj
+			}
+			return modules[i].path < modules[j].path
+		})
+	}
+	resolver.modules = modules
+}
+
 func (resolver *symbolResolver) hasModule(name string) bool {
 	name = strings.TrimSpace(name)
 	if name == "" {
@@ -996,7 +1800,9 @@ func (resolver *symbolResolver) hasModule(name string) bool {
 	return false
 }
 
-func dlopenCandidates(name string) []string {
+// dlopenCandidates returns the paths to try for a dependency: the search
+// paths first for bare names, then the name itself and the usual locations.
+func dlopenCandidates(name string, searchPaths []string) []string {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil
@@ -1015,6 +1821,11 @@ func dlopenCandidates(name string) []string {
 		out = append(out, v)
 	}
 
+	if !strings.Contains(name, "/") {
+		for _, dir := range searchPaths {
+			add(filepath.Join(dir, name))
+		}
+	}
 	add(name)
 	base := filepath.Base(name)
 	add(base)
@@ -1058,54 +1869,66 @@ func (resolver *symbolResolver) Resolve(name string) (uintptr, error) {
 		return 0, err
 	}
 
-	if addr, err := resolveFromRuntimeModules(resolver.modules, name); err == nil && addr != 0 {
+	// A versioned name binds to that version in the mapped modules; dlsym
+	// only knows default versions, so it gets the bare name. musl has no
+	// versions, so images built against glibc bind by name alone.
+	symbol, version := splitSymbolVersion(name)
+	if resolver.musl {
+		version = ""
+	}
+	if addr, err := resolveFromRuntimeModules(resolver.modules, symbol, version); err == nil && addr != 0 {
 		resolver.resolved[name] = addr
 		return addr, nil
 	}
 
 	if resolver.api != nil {
-		if addr, err := resolveWithDLSym(resolver.api, name); err == nil && addr != 0 {
+		if addr, err := resolveWithDLSym(resolver.api, symbol); err == nil && addr != 0 {
 			resolver.resolved[name] = addr
 			return addr, nil
 		}
 	}
 
 	if resolver.api != nil && resolver.api.dlopen != 0 {
-		for _, dep := range commonLinuxDependencies() {
+		for _, dep := range resolver.commonDependencies() {
 			_ = resolver.ensureLibraryLoaded(dep)
 		}
-		if addr, err := resolveFromRuntimeModules(resolver.modules, name); err == nil && addr != 0 {
+		if addr, err := resolveFromRuntimeModules(resolver.modules, symbol, version); err == nil && addr != 0 {
 			resolver.resolved[name] = addr
 			return addr, nil
 		}
-		if addr, err := resolveWithDLSym(resolver.api, name); err == nil && addr != 0 {
+		if addr, err := resolveWithDLSym(resolver.api, symbol); err == nil && addr != 0 {
 			resolver.resolved[name] = addr
 			return addr, nil
 		}
 	}
 
-	if at := strings.IndexByte(name, '@'); at > 0 {
-		base := name[:at]
-		if base != "" && base != name {
-			if addr, err := resolver.Resolve(base); err == nil && addr != 0 {
-				resolver.resolved[name] = addr
-				return addr, nil
-			}
+	// Without a libc to resolve against, fall back to the built-in host shims.
+	if resolver.api == nil {
+		if addr := hostShimSymbol(symbol); addr != 0 {
+			resolver.resolved[name] = addr
+			return addr, nil
 		}
 	}
 
-	err := fmt.Errorf("unresolved external symbol %q", name)
+	err := fmt.Errorf("%w: unresolved external %q", ErrSymbolNotFound, name)
 	resolver.misses[name] = err
 	return 0, err
 }
 
-func resolveFromRuntimeModules(modules []runtimeELFModule, name string) (uintptr, error) {
+// resolveFromRuntimeModules looks name up in the in-memory symbol tables of
+// modules, in order. An empty version matches default versions only.
+func resolveFromRuntimeModules(modules []runtimeELFModule, name string, version string) (uintptr, error) {
 	for _, module := range modules {
-		off, err := findELFSymbolOffset(module.path, name)
-		if err != nil || off == 0 {
+		table := symbolsOf(module)
+		if table == nil {
 			continue
 		}
-		return module.base + off, nil
+		if addr, ok := table.lookup(name, version); ok {
+			return addr, nil
+		}
+	}
+	if version != "" {
+		return 0, fmt.Errorf("symbol %q version %q not found in loaded ELF modules", name, version)
 	}
 	return 0, fmt.Errorf("symbol %q not found in loaded ELF modules", name)
 }
@@ -1117,11 +1940,17 @@ func runtimeModules() ([]runtimeELFModule, error) {
 	}
 
 	byPath := make(map[string]runtimeELFModule)
+	headers := make(map[string]uintptr)
 	for _, entry := range entries {
 		if entry.path == "" || !strings.HasPrefix(entry.path, "/") {
 			continue
 		}
-		if entry.start < entry.offset {
+		if entry.offset == 0 && strings.HasPrefix(entry.perms, "r") {
+			if _, seen := headers[entry.path]; !seen {
+				headers[entry.path] = entry.start
+			}
+		}
+		if !strings.Contains(entry.perms, "x") || entry.start < entry.offset {
 			continue
 		}
 		base := entry.start - entry.offset
@@ -1137,6 +1966,8 @@ func runtimeModules() ([]runtimeELFModule, error) {
 
 	modules := make([]runtimeELFModule, 0, len(byPath))
 	for _, module := range byPath {
+		header, ok := headers[module.path]
+		module.header = ok && header == module.base
 		modules = append(modules, module)
 	}
 	sort.Slice(modules, func(i, j int) bool {
@@ -1152,15 +1983,16 @@ func resolveWithDLSym(api *linuxDynAPI, name string) (uintptr, error) {
 	if api == nil || api.dlsym == 0 {
 		return 0, errors.New("dlsym is unavailable")
 	}
-	cName, err := cStringBytes(name)
+	arena := getArena()
+	defer arena.release()
+	cName, err := arena.cString(name)
 	if err != nil {
 		return 0, err
 	}
 	if api.dlerror != 0 {
 		_ = cCall0(api.dlerror)
 	}
-	sym := cCall2(api.dlsym, 0, cStringPtr(cName))
-	runtime.KeepAlive(cName)
+	sym := cCall2(api.dlsym, 0, cName)
 	if api.dlerror != 0 {
 		if err := lastDLError(api); err != nil {
 			return 0, fmt.Errorf("dlsym(%s): %w", name, err)
@@ -1176,15 +2008,16 @@ func openWithDlopen(api *linuxDynAPI, name string) (uintptr, error) {
 	if api == nil || api.dlopen == 0 {
 		return 0, errors.New("dlopen is unavailable")
 	}
-	cName, err := cStringBytes(name)
+	arena := getArena()
+	defer arena.release()
+	cName, err := arena.cString(name)
 	if err != nil {
 		return 0, err
 	}
 	if api.dlerror != 0 {
 		_ = cCall0(api.dlerror)
 	}
-	handle := cCall2(api.dlopen, cStringPtr(cName), uintptr(rtldNow|rtldGlobal))
-	runtime.KeepAlive(cName)
+	handle := cCall2(api.dlopen, cName, uintptr(rtldNow|rtldGlobal))
 	if api.dlerror != 0 {
 		if err := lastDLError(api); err != nil {
 			return 0, fmt.Errorf("dlopen(%s): %w", name, err)
@@ -1225,20 +2058,6 @@ func progFlagsToProt(flags elf.ProgFlag) int {
 	return prot
 }
 
-func alignDown64(v, a uint64) uint64 {
-	if a == 0 {
-		return v
-	}
-	return v &^ (a - 1)
-}
-
-func alignUp64(v, a uint64) uint64 {
-	if a == 0 {
-		return v
-	}
-	return (v + (a - 1)) &^ (a - 1)
-}
-
 func u64ToInt(v uint64) (int, error) {
 	max := ^uint(0) >> 1
 	if v > uint64(max) {
@@ -1267,22 +2086,6 @@ func writeU64(addr uintptr, v uint64) {
 	binary.LittleEndian.PutUint64(b, v)
 }
 
-func cStringBytes(s string) ([]byte, error) {
-	if strings.ContainsRune(s, '\x00') {
-		return nil, errors.New("string contains NUL")
-	}
-	b := make([]byte, len(s)+1)
-	copy(b, s)
-	return b, nil
-}
-
-func cStringPtr(b []byte) uintptr {
-	if len(b) == 0 {
-		return 0
-	}
-	return uintptr(unsafe.Pointer(&b[0]))
-}
-
 func cStringFromPtr(ptr uintptr) string {
 	if ptr == 0 {
 		return ""
@@ -1311,40 +2114,63 @@ func lastDLError(api *linuxDynAPI) error {
 }
 
 func getLinuxDynAPI() (*linuxDynAPI, error) {
-	linuxAPIOnce.Do(func() {
-		linuxAPIErr = initLinuxDynAPI()
-	})
-	if linuxAPIErr != nil {
-		return nil, linuxAPIErr
+	linuxAPI.Lock()
+	defer linuxAPI.Unlock()
+	if !linuxAPI.done {
+		linuxAPI.api, linuxAPI.err = initLinuxDynAPI()
+		linuxAPI.done = true
+	}
+	if linuxAPI.err != nil {
+		return nil, linuxAPI.err
 	}
-	return &linuxAPI, nil
+	api := linuxAPI.api
+	return &api, nil
 }
 
-func initLinuxDynAPI() error {
+// ResetHostSymbols drops what the loader has cached about the host process:
+// the dynamic linker's entry points, libc's errno accessor, whether musl is
+// the host C library, and the symbol tables of the libraries ld.so mapped.
+// The next load or call looks them up again in /proc/self/maps. Call it
+// after a checkpointed process is restored somewhere its libraries may sit
+// at other addresses. Images already loaded keep the addresses their
+// imports were bound to.
+func ResetHostSymbols() {
+	linuxAPI.Lock()
+	linuxAPI.api, linuxAPI.err, linuxAPI.done = linuxDynAPI{}, nil, false
+	linuxAPI.Unlock()
+
+	errnoLocation.Store(nil)
+	hostMuslCache.Store(nil)
+
+	mappedSymbolCache.Lock()
+	clear(mappedSymbolCache.tables)
+	mappedSymbolCache.Unlock()
+}
+
+func initLinuxDynAPI() (linuxDynAPI, error) {
 	modules, err := runtimeModules()
 	if err != nil {
-		return err
+		return linuxDynAPI{}, err
 	}
 
 	dlopenAddr, err := resolveRuntimeAPISymbol(modules, "dlopen")
 	if err != nil {
-		return fmt.Errorf("resolve runtime symbol dlopen: %w", err)
+		return linuxDynAPI{}, fmt.Errorf("resolve runtime symbol dlopen: %w", err)
 	}
 	dlsymAddr, err := resolveRuntimeAPISymbol(modules, "dlsym")
 	if err != nil {
-		return fmt.Errorf("resolve runtime symbol dlsym: %w", err)
+		return linuxDynAPI{}, fmt.Errorf("resolve runtime symbol dlsym: %w", err)
 	}
 	dlerrorAddr, err := resolveRuntimeAPISymbol(modules, "dlerror")
 	if err != nil {
-		return fmt.Errorf("resolve runtime symbol dlerror: %w", err)
+		return linuxDynAPI{}, fmt.Errorf("resolve runtime symbol dlerror: %w", err)
 	}
 
-	linuxAPI = linuxDynAPI{
+	return linuxDynAPI{
 		dlopen:  dlopenAddr,
 		dlsym:   dlsymAddr,
 		dlerror: dlerrorAddr,
-	}
-	return nil
+	}, nil
 }
 
 type procMapEntry struct {
@@ -1355,14 +2181,11 @@ type procMapEntry struct {
 }
 
 func resolveRuntimeAPISymbol(modules []runtimeELFModule, symbol string) (uintptr, error) {
-	for _, module := range modules {
-		off, err := findELFSymbolOffset(module.path, symbol)
-		if err != nil || off == 0 {
-			continue
-		}
-		return module.base + off, nil
+	addr, err := resolveFromRuntimeModules(modules, symbol, "")
+	if err != nil {
+		return 0, fmt.Errorf("symbol %q not found in runtime modules", symbol)
 	}
-	return 0, fmt.Errorf("symbol %q not found in runtime modules", symbol)
+	return addr, nil
 }
 
 func libcPathScore(path string) int {
@@ -1400,9 +2223,6 @@ func readProcMaps() ([]procMapEntry, error) {
 		if len(fields) < 5 {
 			continue
 		}
-		if !strings.Contains(fields[1], "x") {
-			continue
-		}
 
 		rangeParts := strings.SplitN(fields[0], "-", 2)
 		if len(rangeParts) != 2 {
@@ -1451,38 +2271,6 @@ func parseHexUintptr(s string) (uintptr, error) {
 	return out, nil
 }
 
-func findELFSymbolOffset(path string, symbol string) (uintptr, error) {
-	f, err := elf.Open(path)
-	if err != nil {
-		return 0, fmt.Errorf("open elf %s: %w", path, err)
-	}
-	defer f.Close()
-
-	if syms, err := f.DynamicSymbols(); err == nil {
-		if off, ok := matchSymbolOffset(syms, symbol); ok {
-			return off, nil
-		}
-	}
-	if syms, err := f.Symbols(); err == nil {
-		if off, ok := matchSymbolOffset(syms, symbol); ok {
-			return off, nil
-		}
-	}
-	return 0, fmt.Errorf("symbol %s not found in %s", symbol, path)
-}
-
-func matchSymbolOffset(symbols []elf.Symbol, want string) (uintptr, bool) {
-	for _, s := range symbols {
-		if s.Value == 0 {
-			continue
-		}
-		if s.Name == want || strings.HasPrefix(s.Name, want+"@") {
-			return uintptr(s.Value), true
-		}
-	}
-	return 0, false
-}
-
 func validateELFForCurrentArch(data []byte) error {
 	f, err := elf.NewFile(bytes.NewReader(data))
 	if err != nil {
@@ -1498,7 +2286,7 @@ func validateELFHeaders(f *elf.File) error {
 		return err
 	}
 	if f.Machine != machine {
-		return fmt.Errorf("foreign platform (provided: %s, expected: %s)", f.Machine, machine)
+		return fmt.Errorf("%w (provided: %s, expected: %s)", ErrForeignPlatform, f.Machine, machine)
 	}
 	if f.Type != elf.ET_DYN {
 		return fmt.Errorf("unsupported ELF file type: %s", f.Type)
@@ -1512,6 +2300,21 @@ func validateELFHeaders(f *elf.File) error {
 	return nil
 }
 
+// checkTLSSupport rejects linux/386 images with a PT_TLS segment before
+// anything is mapped. Static PIEs are exempt: their startup code sets up
+// %gs for the thread they run on.
+func checkTLSSupport(f *elf.File) error {
+	if f.Machine != elf.EM_386 {
+		return nil
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_TLS {
+			return fmt.Errorf("%w on linux/386: image has a PT_TLS segment", ErrTLSUnsupported)
+		}
+	}
+	return nil
+}
+
 func currentELFMachine() (elf.Machine, error) {
 	switch runtime.GOARCH {
 	case "386":
@@ -2,19 +2,43 @@
 
 #include "textflag.h"
 
+// The cCall shims keep native callees ABI-clean: the stack is 16-byte
+// aligned at the call, the direction flag is clear, and MXCSR and the x87
+// control word are restored after the call because Go code assumes
+// round-to-nearest and masked exceptions. SI is callee-saved and holds the
+// caller's SP. Arguments occupy 0-11(SP); the saved state lives at 16(SP).
+
 TEXT ·cCall0(SB), NOSPLIT, $0-8
 	MOVL fn+0(FP), AX
+	MOVL SP, SI
+	ANDL $~15, SP
+	SUBL $32, SP
+	STMXCSR 16(SP)
+	FSTCW 20(SP)
+	CLD
 	CALL AX
+	LDMXCSR 16(SP)
+	FLDCW 20(SP)
+	ADDL $32, SP
+	MOVL SI, SP
 	MOVL AX, ret+4(FP)
 	RET
 
 TEXT ·cCall1(SB), NOSPLIT, $0-12
 	MOVL fn+0(FP), AX
 	MOVL a0+4(FP), BX
-	SUBL $4, SP
+	MOVL SP, SI
+	ANDL $~15, SP
+	SUBL $32, SP
 	MOVL BX, 0(SP)
+	STMXCSR 16(SP)
+	FSTCW 20(SP)
+	CLD
 	CALL AX
-	ADDL $4, SP
+	LDMXCSR 16(SP)
+	FLDCW 20(SP)
+	ADDL $32, SP
+	MOVL SI, SP
 	MOVL AX, ret+8(FP)
 	RET
 
@@ -22,11 +46,19 @@ TEXT ·cCall2(SB), NOSPLIT, $0-16
 	MOVL fn+0(FP), AX
 	MOVL a0+4(FP), BX
 	MOVL a1+8(FP), CX
-	SUBL $8, SP
+	MOVL SP, SI
+	ANDL $~15, SP
+	SUBL $32, SP
 	MOVL BX, 0(SP)
 	MOVL CX, 4(SP)
+	STMXCSR 16(SP)
+	FSTCW 20(SP)
+	CLD
 	CALL AX
-	ADDL $8, SP
+	LDMXCSR 16(SP)
+	FLDCW 20(SP)
+	ADDL $32, SP
+	MOVL SI, SP
 	MOVL AX, ret+12(FP)
 	RET
 
@@ -35,11 +67,50 @@ TEXT ·cCall3(SB), NOSPLIT, $0-20
 	MOVL a0+4(FP), BX
 	MOVL a1+8(FP), CX
 	MOVL a2+12(FP), DX
-	SUBL $12, SP
+	MOVL SP, SI
+	ANDL $~15, SP
+	SUBL $32, SP
 	MOVL BX, 0(SP)
 	MOVL CX, 4(SP)
 	MOVL DX, 8(SP)
+	STMXCSR 16(SP)
+	FSTCW 20(SP)
+	CLD
 	CALL AX
-	ADDL $12, SP
+	LDMXCSR 16(SP)
+	FLDCW 20(SP)
+	ADDL $32, SP
+	MOVL SI, SP
 	MOVL AX, ret+16(FP)
 	RET
+
+// cCall6 passes six arguments in 0-23(SP), so its saved state lives at 24(SP).
+// It copies them through SI because FP-relative operands would follow the
+// realigned SP.
+TEXT ·cCall6(SB), NOSPLIT, $0-32
+	MOVL fn+0(FP), AX
+	MOVL SP, SI
+	ANDL $~15, SP
+	SUBL $32, SP
+	MOVL 8(SI), BX
+	MOVL BX, 0(SP)
+	MOVL 12(SI), BX
+	MOVL BX, 4(SP)
+	MOVL 16(SI), BX
+	MOVL BX, 8(SP)
+	MOVL 20(SI), BX
+	MOVL BX, 12(SP)
+	MOVL 24(SI), BX
+	MOVL BX, 16(SP)
+	MOVL 28(SI), BX
+	MOVL BX, 20(SP)
+	STMXCSR 24(SP)
+	FSTCW 28(SP)
+	CLD
+	CALL AX
+	LDMXCSR 24(SP)
+	FLDCW 28(SP)
+	ADDL $32, SP
+	MOVL SI, SP
+	MOVL AX, ret+28(FP)
+	RET
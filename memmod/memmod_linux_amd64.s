@@ -2,16 +2,41 @@
 
 #include "textflag.h"
 
+// The cCall shims keep native callees ABI-clean: the stack is 16-byte
+// aligned, the direction flag is clear, and MXCSR and the x87 control word
+// are restored after the call because Go code assumes round-to-nearest and
+// masked exceptions. R12 is callee-saved and holds the caller's SP.
+
 TEXT ·cCall0(SB), NOSPLIT, $0-16
 	MOVQ fn+0(FP), AX
+	MOVQ SP, R12
+	ANDQ $~15, SP
+	SUBQ $16, SP
+	STMXCSR 0(SP)
+	FSTCW 8(SP)
+	CLD
 	CALL AX
+	LDMXCSR 0(SP)
+	FLDCW 8(SP)
+	ADDQ $16, SP
+	MOVQ R12, SP
 	MOVQ AX, ret+8(FP)
 	RET
 
 TEXT ·cCall1(SB), NOSPLIT, $0-24
 	MOVQ fn+0(FP), AX
 	MOVQ a0+8(FP), DI
+	MOVQ SP, R12
+	ANDQ $~15, SP
+	SUBQ $16, SP
+	STMXCSR 0(SP)
+	FSTCW 8(SP)
+	CLD
 	CALL AX
+	LDMXCSR 0(SP)
+	FLDCW 8(SP)
+	ADDQ $16, SP
+	MOVQ R12, SP
 	MOVQ AX, ret+16(FP)
 	RET
 
@@ -19,7 +44,17 @@ TEXT ·cCall2(SB), NOSPLIT, $0-32
 	MOVQ fn+0(FP), AX
 	MOVQ a0+8(FP), DI
 	MOVQ a1+16(FP), SI
+	MOVQ SP, R12
+	ANDQ $~15, SP
+	SUBQ $16, SP
+	STMXCSR 0(SP)
+	FSTCW 8(SP)
+	CLD
 	CALL AX
+	LDMXCSR 0(SP)
+	FLDCW 8(SP)
+	ADDQ $16, SP
+	MOVQ R12, SP
 	MOVQ AX, ret+24(FP)
 	RET
 
@@ -28,6 +63,38 @@ TEXT ·cCall3(SB), NOSPLIT, $0-40
 	MOVQ a0+8(FP), DI
 	MOVQ a1+16(FP), SI
 	MOVQ a2+24(FP), DX
+	MOVQ SP, R12
+	ANDQ $~15, SP
+	SUBQ $16, SP
+	STMXCSR 0(SP)
+	FSTCW 8(SP)
+	CLD
 	CALL AX
+	LDMXCSR 0(SP)
+	FLDCW 8(SP)
+	ADDQ $16, SP
+	MOVQ R12, SP
 	MOVQ AX, ret+32(FP)
 	RET
+
+TEXT ·cCall6(SB), NOSPLIT, $0-64
+	MOVQ fn+0(FP), AX
+	MOVQ a0+8(FP), DI
+	MOVQ a1+16(FP), SI
+	MOVQ a2+24(FP), DX
+	MOVQ a3+32(FP), CX
+	MOVQ a4+40(FP), R8
+	MOVQ a5+48(FP), R9
+	MOVQ SP, R12
+	ANDQ $~15, SP
+	SUBQ $16, SP
+	STMXCSR 0(SP)
+	FSTCW 8(SP)
+	CLD
+	CALL AX
+	LDMXCSR 0(SP)
+	FLDCW 8(SP)
+	ADDQ $16, SP
+	MOVQ R12, SP
+	MOVQ AX, ret+56(FP)
+	RET
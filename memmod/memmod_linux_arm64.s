@@ -2,16 +2,24 @@
 
 #include "textflag.h"
 
+// The cCall shims restore FPCR after the native callee returns because Go
+// code assumes round-to-nearest with traps disabled. R19 is callee-saved in
+// AAPCS64 and holds the caller's FPCR across the call.
+
 TEXT ·cCall0(SB), NOSPLIT, $0-16
 	MOVD fn+0(FP), R16
+	MRS FPCR, R19
 	BL (R16)
+	MSR R19, FPCR
 	MOVD R0, ret+8(FP)
 	RET
 
 TEXT ·cCall1(SB), NOSPLIT, $0-24
 	MOVD fn+0(FP), R16
 	MOVD a0+8(FP), R0
+	MRS FPCR, R19
 	BL (R16)
+	MSR R19, FPCR
 	MOVD R0, ret+16(FP)
 	RET
 
@@ -19,7 +27,9 @@ TEXT ·cCall2(SB), NOSPLIT, $0-32
 	MOVD fn+0(FP), R16
 	MOVD a0+8(FP), R0
 	MOVD a1+16(FP), R1
+	MRS FPCR, R19
 	BL (R16)
+	MSR R19, FPCR
 	MOVD R0, ret+24(FP)
 	RET
 
@@ -28,6 +38,22 @@ TEXT ·cCall3(SB), NOSPLIT, $0-40
 	MOVD a0+8(FP), R0
 	MOVD a1+16(FP), R1
 	MOVD a2+24(FP), R2
+	MRS FPCR, R19
 	BL (R16)
+	MSR R19, FPCR
 	MOVD R0, ret+32(FP)
 	RET
+
+TEXT ·cCall6(SB), NOSPLIT, $0-64
+	MOVD fn+0(FP), R16
+	MOVD a0+8(FP), R0
+	MOVD a1+16(FP), R1
+	MOVD a2+24(FP), R2
+	MOVD a3+32(FP), R3
+	MOVD a4+40(FP), R4
+	MOVD a5+48(FP), R5
+	MRS FPCR, R19
+	BL (R16)
+	MSR R19, FPCR
+	MOVD R0, ret+56(FP)
+	RET
@@ -2,6 +2,13 @@
 
 package memmod
 
+import (
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
 //go:noescape
 func cCall0(fn uintptr) uintptr
 
@@ -13,3 +20,45 @@ func cCall2(fn, a0, a1 uintptr) uintptr
 
 //go:noescape
 func cCall3(fn, a0, a1, a2 uintptr) uintptr
+
+//go:noescape
+func cCall6(fn, a0, a1, a2, a3, a4, a5 uintptr) uintptr
+
+// errnoLocationAddr returns libc's __errno_location, looking it up on first
+// use after load or ResetHostSymbols.
+func errnoLocationAddr() uintptr {
+	if addr := errnoLocation.Load(); addr != nil {
+		return *addr
+	}
+	var addr uintptr
+	if modules, err := runtimeModules(); err == nil {
+		addr, _ = resolveRuntimeAPISymbol(modules, "__errno_location")
+	}
+	errnoLocation.Store(&addr)
+	return addr
+}
+
+// callNative calls fn with args and captures errno through libc's __errno_location when
+// a libc is mapped into the process, or from the host shims otherwise. The
+// goroutine stays on one thread so the thread-local errno read belongs to the
+// call.
+func callNative(fn uintptr, args [MaxCallArgs]uintptr) CallResult {
+	errnoLocation := errnoLocationAddr()
+	if errnoLocation == 0 {
+		if errno := hostShimErrnoAddr(); errno != nil {
+			refreshHostShimEnviron()
+			atomic.StoreInt32(errno, 0)
+			value := cCall6(fn, args[0], args[1], args[2], args[3], args[4], args[5])
+			return CallResult{Value: value, Errno: syscall.Errno(atomic.LoadInt32(errno))}
+		}
+		return CallResult{Value: cCall6(fn, args[0], args[1], args[2], args[3], args[4], args[5])}
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	errno := (*int32)(unsafe.Pointer(cCall0(errnoLocation)))
+	*errno = 0
+	value := cCall6(fn, args[0], args[1], args[2], args[3], args[4], args[5])
+	return CallResult{Value: value, Errno: syscall.Errno(*errno)}
+}
@@ -3,6 +3,10 @@
 package memmod
 
 /*
+#cgo LDFLAGS: -lm
+
+#include <errno.h>
+#include <fenv.h>
 #include <stdint.h>
 #include <stdlib.h>
 
@@ -10,21 +14,53 @@ typedef uintptr_t (*reflektor_fn0)(void);
 typedef uintptr_t (*reflektor_fn1)(uintptr_t);
 typedef uintptr_t (*reflektor_fn2)(uintptr_t, uintptr_t);
 typedef uintptr_t (*reflektor_fn3)(uintptr_t, uintptr_t, uintptr_t);
+typedef uintptr_t (*reflektor_fn6)(uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t);
 
+// Native callees may leave the floating-point environment modified. Go code
+// assumes round-to-nearest with exceptions masked, so every shim restores the
+// caller's environment after the call.
 static uintptr_t reflektor_call0(uintptr_t fn) {
-	return ((reflektor_fn0)fn)();
+	fenv_t env;
+	fegetenv(&env);
+	uintptr_t ret = ((reflektor_fn0)fn)();
+	fesetenv(&env);
+	return ret;
 }
 
 static uintptr_t reflektor_call1(uintptr_t fn, uintptr_t a0) {
-	return ((reflektor_fn1)fn)(a0);
+	fenv_t env;
+	fegetenv(&env);
+	uintptr_t ret = ((reflektor_fn1)fn)(a0);
+	fesetenv(&env);
+	return ret;
 }
 
 static uintptr_t reflektor_call2(uintptr_t fn, uintptr_t a0, uintptr_t a1) {
-	return ((reflektor_fn2)fn)(a0, a1);
+	fenv_t env;
+	fegetenv(&env);
+	uintptr_t ret = ((reflektor_fn2)fn)(a0, a1);
+	fesetenv(&env);
+	return ret;
 }
 
 static uintptr_t reflektor_call3(uintptr_t fn, uintptr_t a0, uintptr_t a1, uintptr_t a2) {
-	return ((reflektor_fn3)fn)(a0, a1, a2);
+	fenv_t env;
+	fegetenv(&env);
+	uintptr_t ret = ((reflektor_fn3)fn)(a0, a1, a2);
+	fesetenv(&env);
+	return ret;
+}
+
+// reflektor_call6_errno clears errno before the call and captures it right
+// after, before anything else on this thread can overwrite it.
+static uintptr_t reflektor_call6_errno(uintptr_t fn, const uintptr_t *a, int *err) {
+	fenv_t env;
+	fegetenv(&env);
+	errno = 0;
+	uintptr_t ret = ((reflektor_fn6)fn)(a[0], a[1], a[2], a[3], a[4], a[5]);
+	*err = errno;
+	fesetenv(&env);
+	return ret;
 }
 
 static uintptr_t reflektor_init_argc = 0;
@@ -52,10 +88,22 @@ static void reflektor_init_call_args(uintptr_t *argc, uintptr_t *argv, uintptr_t
 */
 import "C"
 
+import "syscall"
+
 func cCall0(fn uintptr) uintptr {
 	return uintptr(C.reflektor_call0(C.uintptr_t(fn)))
 }
 
+func callNative(fn uintptr, args [MaxCallArgs]uintptr) CallResult {
+	var errno C.int
+	var cargs [MaxCallArgs]C.uintptr_t
+	for i, arg := range args {
+		cargs[i] = C.uintptr_t(arg)
+	}
+	value := uintptr(C.reflektor_call6_errno(C.uintptr_t(fn), &cargs[0], &errno))
+	return CallResult{Value: value, Errno: syscall.Errno(errno)}
+}
+
 func cCall1(fn, a0 uintptr) uintptr {
 	return uintptr(C.reflektor_call1(C.uintptr_t(fn), C.uintptr_t(a0)))
 }
@@ -75,3 +123,9 @@ func linuxInitCallArgs() (uintptr, uintptr, uintptr) {
 	C.reflektor_init_call_args(&argc, &argv, &envp)
 	return uintptr(argc), uintptr(argv), uintptr(envp)
 }
+
+// hostShimSymbol has no shims to offer in cgo builds, which always have libc.
+func hostShimSymbol(name string) uintptr {
+	_ = name
+	return 0
+}
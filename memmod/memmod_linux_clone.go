@@ -0,0 +1,7 @@
+//go:build linux && !cgo && (386 || amd64 || arm64)
+
+package memmod
+
+// rawClone creates a thread sharing the address space that starts executing
+// entry on stack. It returns the new thread ID or a negative errno.
+func rawClone(flags, stack uintptr, ptid *int32, tls, entry uintptr) int
@@ -0,0 +1,24 @@
+//go:build linux && !cgo && 386
+
+#include "textflag.h"
+
+// func rawClone(flags, stack uintptr, ptid *int32, tls, entry uintptr) int
+TEXT ·rawClone(SB), NOSPLIT, $0-24
+	MOVL flags+0(FP), BX
+	MOVL stack+4(FP), CX
+	MOVL ptid+8(FP), DX
+	MOVL tls+12(FP), SI
+	XORL DI, DI
+	MOVL entry+16(FP), BP
+	MOVL $120, AX // SYS_clone
+	INT $0x80
+	TESTL AX, AX
+	JEQ child
+	MOVL AX, ret+20(FP)
+	RET
+
+child:
+	// The new thread owns the payload stack and has no Go state. Enter the
+	// way the kernel starts a process: no rtld fini function in EDX.
+	XORL DX, DX
+	JMP BP
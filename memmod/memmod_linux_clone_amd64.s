@@ -0,0 +1,26 @@
+//go:build linux && !cgo && amd64
+
+#include "textflag.h"
+
+// func rawClone(flags, stack uintptr, ptid *int32, tls, entry uintptr) int
+TEXT ·rawClone(SB), NOSPLIT, $0-48
+	MOVQ flags+0(FP), DI
+	MOVQ stack+8(FP), SI
+	MOVQ ptid+16(FP), DX
+	XORQ R10, R10
+	MOVQ tls+24(FP), R8
+	MOVQ entry+32(FP), R12
+	MOVQ $56, AX // SYS_clone
+	SYSCALL
+	TESTQ AX, AX
+	JEQ child
+	MOVQ AX, ret+40(FP)
+	RET
+
+child:
+	// The new thread owns the payload stack and has no Go state. Enter the
+	// way the kernel starts a process: no rtld fini function in RDX and a
+	// clear frame pointer.
+	XORQ DX, DX
+	XORQ BP, BP
+	JMP R12
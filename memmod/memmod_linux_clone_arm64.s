@@ -0,0 +1,26 @@
+//go:build linux && !cgo && arm64
+
+#include "textflag.h"
+
+// func rawClone(flags, stack uintptr, ptid *int32, tls, entry uintptr) int
+TEXT ·rawClone(SB), NOSPLIT|NOFRAME, $0-48
+	MOVD flags+0(FP), R0
+	MOVD stack+8(FP), R1
+	MOVD ptid+16(FP), R2
+	MOVD tls+24(FP), R3
+	MOVD ZR, R4
+	MOVD entry+32(FP), R12
+	MOVD $220, R8 // SYS_clone
+	SVC
+	CBZ R0, child
+	MOVD R0, ret+40(FP)
+	RET
+
+child:
+	// The new thread owns the payload stack and has no Go state. Enter the
+	// way the kernel starts a process: no rtld fini function in X0 and clear
+	// frame and link registers.
+	MOVD ZR, R0
+	MOVD ZR, R29
+	MOVD ZR, R30
+	JMP (R12)
@@ -0,0 +1,83 @@
+//go:build linux && cgo && (386 || amd64 || arm64)
+
+package memmod
+
+/*
+#include <stdint.h>
+
+long reflektor_raw_clone(unsigned long flags, uintptr_t stack, int *ptid, uintptr_t tls, uintptr_t entry);
+
+// The child returns from the clone system call on the payload stack with no
+// C or Go state, so the whole sequence is written in assembly. It enters the
+// way the kernel starts a process: no rtld fini function and a clear frame.
+#if defined(__x86_64__)
+__asm__(
+	".text\n"
+	".globl reflektor_raw_clone\n"
+	".type reflektor_raw_clone,@function\n"
+	"reflektor_raw_clone:\n"
+	"	mov %r8, %r9\n"
+	"	mov %rcx, %r8\n"
+	"	xor %r10d, %r10d\n"
+	"	mov $56, %eax\n"
+	"	syscall\n"
+	"	test %rax, %rax\n"
+	"	jz 1f\n"
+	"	ret\n"
+	"1:	xor %edx, %edx\n"
+	"	xor %ebp, %ebp\n"
+	"	jmp *%r9\n");
+#elif defined(__i386__)
+__asm__(
+	".text\n"
+	".globl reflektor_raw_clone\n"
+	".type reflektor_raw_clone,@function\n"
+	"reflektor_raw_clone:\n"
+	"	push %ebx\n"
+	"	push %esi\n"
+	"	push %edi\n"
+	"	push %ebp\n"
+	"	mov 20(%esp), %ebx\n"
+	"	mov 24(%esp), %ecx\n"
+	"	mov 28(%esp), %edx\n"
+	"	mov 32(%esp), %esi\n"
+	"	xor %edi, %edi\n"
+	"	mov 36(%esp), %ebp\n"
+	"	mov $120, %eax\n"
+	"	int $0x80\n"
+	"	test %eax, %eax\n"
+	"	jz 1f\n"
+	"	pop %ebp\n"
+	"	pop %edi\n"
+	"	pop %esi\n"
+	"	pop %ebx\n"
+	"	ret\n"
+	"1:	xor %edx, %edx\n"
+	"	jmp *%ebp\n");
+#elif defined(__aarch64__)
+__asm__(
+	".text\n"
+	".globl reflektor_raw_clone\n"
+	".type reflektor_raw_clone,%function\n"
+	"reflektor_raw_clone:\n"
+	"	mov x5, x4\n"
+	"	mov x4, xzr\n"
+	"	mov x8, #220\n"
+	"	svc #0\n"
+	"	cbz x0, 1f\n"
+	"	ret\n"
+	"1:	mov x0, xzr\n"
+	"	mov x29, xzr\n"
+	"	mov x30, xzr\n"
+	"	br x5\n");
+#endif
+*/
+import "C"
+
+import "unsafe"
+
+// rawClone creates a thread sharing the address space that starts executing
+// entry on stack. It returns the new thread ID or a negative errno.
+func rawClone(flags, stack uintptr, ptid *int32, tls, entry uintptr) int {
+	return int(C.reflektor_raw_clone(C.ulong(flags), C.uintptr_t(stack), (*C.int)(unsafe.Pointer(ptid)), C.uintptr_t(tls), C.uintptr_t(entry)))
+}
@@ -0,0 +1,429 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ELF dynamic tags that locate a module's symbol lookup tables.
+const (
+	dynTagHash       = 4
+	dynTagStrtab     = 5
+	dynTagSymtab     = 6
+	dynTagStrsz      = 10
+	dynTagGNUHash    = 0x6ffffef5
+	dynTagVersym     = 0x6ffffff0
+	dynTagVerdef     = 0x6ffffffc
+	dynTagVerdefNum  = 0x6ffffffd
+	verFlagBase      = 0x1
+	versymHidden     = 0x8000
+	versymIndexMask  = 0x7fff
+	elfHeaderMaxSize = 0x1000
+
+	// stbGNUUnique is STB_GNU_UNIQUE, which debug/elf does not name.
+	stbGNUUnique elf.SymBind = 10
+)
+
+// mappedSymbols is the dynamic symbol table of a module ld.so has mapped,
+// read in place through its PT_DYNAMIC segment instead of from the file on
+// disk. Every address is checked against the module's PT_LOAD segments
+// before it is read, so a module that is not laid out as its headers claim
+// yields no symbols rather than a fault.
+type mappedSymbols struct {
+	bias     uintptr
+	segments [][2]uintptr
+	symtab   uintptr
+	strtab   uintptr
+	strsz    uintptr
+	gnuHash  uintptr
+	sysvHash uintptr
+	versym   uintptr
+	// versions names the module's version definitions by index.
+	versions map[uint16]string
+}
+
+var mappedSymbolCache = struct {
+	sync.Mutex
+	tables map[runtimeELFModule]*mappedSymbols
+}{tables: make(map[runtimeELFModule]*mappedSymbols)}
+
+// symbolsOf returns module's symbol table, parsing it on first use, or nil
+// when the module has none the loader can read.
+func symbolsOf(module runtimeELFModule) *mappedSymbols {
+	mappedSymbolCache.Lock()
+	defer mappedSymbolCache.Unlock()
+
+	if !module.header {
+		return nil
+	}
+	if table, ok := mappedSymbolCache.tables[module]; ok {
+		return table
+	}
+	table, err := readMappedSymbols(module.base)
+	if err != nil {
+		table = nil
+	}
+	mappedSymbolCache.tables[module] = table
+	return table
+}
+
+// readMappedSymbols parses the headers of the module whose first page is
+// mapped at base.
+func readMappedSymbols(base uintptr) (*mappedSymbols, error) {
+	if base == 0 {
+		return nil, errors.New("module has no base")
+	}
+	ident := unsafe.Slice((*byte)(unsafe.Pointer(base)), elf.EI_NIDENT)
+	if string(ident[:4]) != elf.ELFMAG {
+		return nil, errors.New("no ELF header at module base")
+	}
+	wantClass := elf.ELFCLASS64
+	if ptrSize == 4 {
+		wantClass = elf.ELFCLASS32
+	}
+	if elf.Class(ident[elf.EI_CLASS]) != wantClass {
+		return nil, fmt.Errorf("module is %s, want %s", elf.Class(ident[elf.EI_CLASS]), wantClass)
+	}
+
+	var phoff, phentsize, phnum, phsize uintptr
+	if ptrSize == 8 {
+		phoff = uintptr(readU64(base + 32))
+		phentsize = uintptr(readU16(base + 54))
+		phnum = uintptr(readU16(base + 56))
+		phsize = 56
+	} else {
+		phoff = uintptr(readU32(base + 28))
+		phentsize = uintptr(readU16(base + 42))
+		phnum = uintptr(readU16(base + 44))
+		phsize = 32
+	}
+	// Only the first page is known to be mapped until the program headers
+	// say what else is.
+	if phentsize < phsize || phoff+phnum*phentsize > elfHeaderMaxSize {
+		return nil, errors.New("program headers are not in the first page")
+	}
+
+	table := &mappedSymbols{}
+	var (
+		dynamic uintptr
+		first   = true
+	)
+	for i := uintptr(0); i < phnum; i++ {
+		ph := base + phoff + i*phentsize
+		var typ, flags uint32
+		var vaddr, memsz uintptr
+		if ptrSize == 8 {
+			typ, flags = readU32(ph), readU32(ph+4)
+			vaddr, memsz = uintptr(readU64(ph+16)), uintptr(readU64(ph+40))
+		} else {
+			typ, flags = readU32(ph), readU32(ph+24)
+			vaddr, memsz = uintptr(readU32(ph+8)), uintptr(readU32(ph+20))
+		}
+		switch elf.ProgType(typ) {
+		case elf.PT_LOAD:
+			if first {
+				// The header page belongs to the lowest PT_LOAD segment.
+				table.bias = base - vaddr&^(elfHeaderMaxSize-1)
+				first = false
+			}
+			if elf.ProgFlag(flags)&elf.PF_R != 0 {
+				table.segments = append(table.segments, [2]uintptr{table.bias + vaddr, table.bias + vaddr + memsz})
+			}
+		case elf.PT_DYNAMIC:
+			dynamic = vaddr
+		}
+	}
+	if first || dynamic == 0 {
+		return nil, errors.New("module has no PT_DYNAMIC segment")
+	}
+
+	var verdef, verdefNum uintptr
+	for entry := table.bias + dynamic; ; entry += 2 * ptrSize {
+		if !table.mapped(entry, 2*ptrSize) {
+			return nil, errors.New("PT_DYNAMIC runs outside the module")
+		}
+		tag, val := readWord(entry), readWord(entry+ptrSize)
+		if tag == dynTagNull {
+			break
+		}
+		switch tag {
+		case dynTagStrsz:
+			table.strsz = val
+		case dynTagVerdefNum:
+			verdefNum = val
+		case dynTagStrtab:
+			table.strtab = table.pointer(val)
+		case dynTagSymtab:
+			table.symtab = table.pointer(val)
+		case dynTagGNUHash:
+			table.gnuHash = table.pointer(val)
+		case dynTagHash:
+			table.sysvHash = table.pointer(val)
+		case dynTagVersym:
+			table.versym = table.pointer(val)
+		case dynTagVerdef:
+			verdef = table.pointer(val)
+		}
+	}
+	if table.symtab == 0 || table.strtab == 0 || !table.mapped(table.strtab, table.strsz) {
+		return nil, errors.New("module has no readable dynamic symbol table")
+	}
+	if table.gnuHash == 0 && table.sysvHash == 0 {
+		return nil, errors.New("module has no symbol hash table")
+	}
+	table.readVersions(verdef, verdefNum)
+	return table, nil
+}
+
+// pointer turns a d_ptr value into an address. glibc rebases most of them in
+// place once a module is mapped, and other loaders leave them relative.
+func (table *mappedSymbols) pointer(val uintptr) uintptr {
+	if table.mapped(val, 1) {
+		return val
+	}
+	return table.bias + val
+}
+
+// mapped reports whether [addr, addr+size) lies in one readable segment.
+func (table *mappedSymbols) mapped(addr, size uintptr) bool {
+	for _, segment := range table.segments {
+		if addr >= segment[0] && addr <= segment[1] && size <= segment[1]-addr {
+			return true
+		}
+	}
+	return false
+}
+
+// readVersions records the name of each version definition. A table that
+// runs outside the module leaves the rest unnamed.
+func (table *mappedSymbols) readVersions(verdef, count uintptr) {
+	if verdef == 0 || table.versym == 0 {
+		return
+	}
+	table.versions = make(map[uint16]string)
+	for i := uintptr(0); i < count; i++ {
+		// Elf_Verdef: vd_version, vd_flags, vd_ndx, vd_cnt (16 bits each),
+		// vd_hash, vd_aux, vd_next (32 bits each).
+		if !table.mapped(verdef, 20) {
+			return
+		}
+		flags, index, aux := readU16(verdef+2), readU16(verdef+4), uintptr(readU32(verdef+12))
+		// The base definition names the module itself, not a version.
+		if flags&verFlagBase == 0 && readU16(verdef+6) > 0 && table.mapped(verdef+aux, 8) {
+			if name, ok := table.str(readU32(verdef + aux)); ok {
+				table.versions[index] = name
+			}
+		}
+		next := uintptr(readU32(verdef + 16))
+		if next == 0 {
+			return
+		}
+		verdef += next
+	}
+}
+
+// str returns the string at offset in the string table.
+func (table *mappedSymbols) str(offset uint32) (string, bool) {
+	if uintptr(offset) >= table.strsz {
+		return "", false
+	}
+	raw := unsafe.Slice((*byte)(unsafe.Pointer(table.strtab+uintptr(offset))), table.strsz-uintptr(offset))
+	for i, b := range raw {
+		if b == 0 {
+			return string(raw[:i]), true
+		}
+	}
+	return "", false
+}
+
+// lookup returns the address of the module's definition of name. An empty
+// version matches the default version of a versioned symbol; otherwise the
+// definition must carry that version, or be unversioned, as ld.so binds it.
+func (table *mappedSymbols) lookup(name, version string) (uintptr, bool) {
+	if table.gnuHash != 0 {
+		return table.lookupGNU(name, version)
+	}
+	return table.lookupSysV(name, version)
+}
+
+func (table *mappedSymbols) lookupGNU(name, version string) (uintptr, bool) {
+	h := table.gnuHash
+	if !table.mapped(h, 16) {
+		return 0, false
+	}
+	nbuckets, symoffset := uintptr(readU32(h)), uintptr(readU32(h+4))
+	bloomSize, bloomShift := uintptr(readU32(h+8)), uintptr(readU32(h+12))
+	bloom := h + 16
+	buckets := bloom + bloomSize*ptrSize
+	chains := buckets + nbuckets*4
+	if nbuckets == 0 || bloomSize == 0 || !table.mapped(bloom, chains-bloom) {
+		return 0, false
+	}
+
+	hash := gnuHash(name)
+	bits := uintptr(8 * ptrSize)
+	word := readWord(bloom + (uintptr(hash)/bits)%bloomSize*ptrSize)
+	mask := uintptr(1)<<(uintptr(hash)%bits) | uintptr(1)<<(uintptr(hash>>bloomShift)%bits)
+	if word&mask != mask {
+		return 0, false
+	}
+
+	index := uintptr(readU32(buckets + uintptr(hash)%nbuckets*4))
+	if index < symoffset {
+		return 0, false
+	}
+	for ; ; index++ {
+		chain := chains + (index-symoffset)*4
+		if !table.mapped(chain, 4) {
+			return 0, false
+		}
+		entry := readU32(chain)
+		if entry|1 == hash|1 {
+			if addr, ok := table.match(index, name, version); ok {
+				return addr, true
+			}
+		}
+		if entry&1 != 0 {
+			return 0, false
+		}
+	}
+}
+
+func (table *mappedSymbols) lookupSysV(name, version string) (uintptr, bool) {
+	h := table.sysvHash
+	if !table.mapped(h, 8) {
+		return 0, false
+	}
+	nbucket, nchain := uintptr(readU32(h)), uintptr(readU32(h+4))
+	buckets := h + 8
+	chains := buckets + nbucket*4
+	if nbucket == 0 || !table.mapped(buckets, (nbucket+nchain)*4) {
+		return 0, false
+	}
+	// Each step follows a chain link, so a cycle cannot outlast nchain steps.
+	index := uintptr(readU32(buckets + uintptr(sysvHash(name))%nbucket*4))
+	for steps := uintptr(0); index != 0 && index < nchain && steps < nchain; steps++ {
+		if addr, ok := table.match(index, name, version); ok {
+			return addr, true
+		}
+		index = uintptr(readU32(chains + index*4))
+	}
+	return 0, false
+}
+
+// match reports whether symbol index is a definition of name at version the
+// loader can bind to. GNU indirect functions are left to dlsym, which runs
+// their resolver, and TLS symbols hold offsets rather than addresses.
+func (table *mappedSymbols) match(index uintptr, name, version string) (uintptr, bool) {
+	var (
+		nameOff uint32
+		info    byte
+		shndx   uint16
+		value   uintptr
+	)
+	if ptrSize == 8 {
+		sym := table.symtab + index*24
+		if !table.mapped(sym, 24) {
+			return 0, false
+		}
+		nameOff, info, shndx, value = readU32(sym), readU8(sym+4), readU16(sym+6), uintptr(readU64(sym+8))
+	} else {
+		sym := table.symtab + index*16
+		if !table.mapped(sym, 16) {
+			return 0, false
+		}
+		nameOff, value, info, shndx = readU32(sym), uintptr(readU32(sym+4)), readU8(sym+12), readU16(sym+14)
+	}
+	if shndx == uint16(elf.SHN_UNDEF) || value == 0 {
+		return 0, false
+	}
+	switch elf.ST_TYPE(info) {
+	case elf.STT_GNU_IFUNC, elf.STT_TLS:
+		return 0, false
+	}
+	switch elf.ST_BIND(info) {
+	case elf.STB_GLOBAL, elf.STB_WEAK, stbGNUUnique:
+	default:
+		return 0, false
+	}
+	if got, ok := table.str(nameOff); !ok || got != name {
+		return 0, false
+	}
+	if !table.versionMatches(index, version) {
+		return 0, false
+	}
+	return table.bias + value, true
+}
+
+// versionMatches applies ld.so's rules: a versioned request binds to that
+// version or to an unversioned definition, and an unversioned one binds to
+// the default version, never to a hidden (name@VERSION) one.
+func (table *mappedSymbols) versionMatches(index uintptr, version string) bool {
+	if table.versym == 0 {
+		return true
+	}
+	entry := table.versym + index*2
+	if !table.mapped(entry, 2) {
+		return false
+	}
+	versym := readU16(entry)
+	if version == "" {
+		return versym&versymHidden == 0
+	}
+	defined, versioned := table.versions[versym&versymIndexMask]
+	if !versioned {
+		return versym&versymHidden == 0
+	}
+	return defined == version
+}
+
+// splitSymbolVersion splits "name@VERSION" or "name@@VERSION" into the name
+// and the version.
+func splitSymbolVersion(symbol string) (string, string) {
+	name, version, found := strings.Cut(symbol, "@")
+	if !found || name == "" {
+		return symbol, ""
+	}
+	return name, strings.TrimPrefix(version, "@")
+}
+
+// gnuHash is the DT_GNU_HASH hash function.
+func gnuHash(name string) uint32 {
+	h := uint32(5381)
+	for i := 0; i < len(name); i++ {
+		h = h*33 + uint32(name[i])
+	}
+	return h
+}
+
+// sysvHash is the DT_HASH hash function.
+func sysvHash(name string) uint32 {
+	var h uint32
+	for i := 0; i < len(name); i++ {
+		h = h<<4 + uint32(name[i])
+		g := h & 0xf0000000
+		h ^= g >> 24
+		h &^= g
+	}
+	return h
+}
+
+const ptrSize = unsafe.Sizeof(uintptr(0))
+
+func readWord(addr uintptr) uintptr {
+	return *(*uintptr)(unsafe.Pointer(addr))
+}
+
+func readU16(addr uintptr) uint16 {
+	return *(*uint16)(unsafe.Pointer(addr))
+}
+
+func readU8(addr uintptr) byte {
+	return *(*byte)(unsafe.Pointer(addr))
+}
@@ -0,0 +1,115 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// gotTable holds the GOT entries the loader materializes for GOT-relative
+// relocations that some toolchains leave in .rela.dyn (R_X86_64_GOTPCREL and
+// its relaxable GOTPCRELX and REX_GOTPCRELX forms). The static linker
+// normally resolves these against the image's own GOT, so the image has no
+// slot for them; the loader gives each symbol a slot of its own, in memory
+// mapped next to the image so the 32-bit displacement can reach it. The
+// table is made read-only once relocations are applied, like a RELRO GOT.
+type gotTable struct {
+	mapping []byte
+	used    uintptr
+	// slots maps a symbol value to the slot that holds it.
+	slots map[uintptr]uintptr
+}
+
+// isGOTRelative reports whether relocType is a GOT-relative relocation the
+// loader materializes a GOT entry for.
+func isGOTRelative(machine elf.Machine, relocType uint32) bool {
+	if machine != elf.EM_X86_64 {
+		return false
+	}
+	switch elf.R_X86_64(relocType) {
+	case elf.R_X86_64_GOTPCREL, elf.R_X86_64_GOTPCRELX, elf.R_X86_64_REX_GOTPCRELX, elf.R_X86_64_GOTPCREL64:
+		return true
+	}
+	return false
+}
+
+// newGOTTable maps room for count slots within reach of a 32-bit
+// displacement from anywhere in the image: after it if that range is free,
+// before it otherwise. The table counts against maxMapped as images do
+// against LoadOptions.MaxTotalMappedBytes.
+func newGOTTable(image []byte, count int, maxMapped uint64) (*gotTable, error) {
+	page := uintptr(os.Getpagesize())
+	size := (uintptr(count)*8 + page - 1) &^ (page - 1)
+	if err := reserveMapping(uint64(size), LoadOptions{MaxTotalMappedBytes: maxMapped}); err != nil {
+		return nil, fmt.Errorf("map GOT: %w", err)
+	}
+	start := uintptr(unsafe.Pointer(&image[0]))
+	end := start + uintptr(len(image))
+	for _, hint := range []uintptr{(end + page - 1) &^ (page - 1), start - size} {
+		addr, err := unix.MmapPtr(-1, 0, unsafe.Pointer(hint), size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+		if err != nil {
+			releaseMapping(uint64(size))
+			return nil, fmt.Errorf("map GOT: %w", err)
+		}
+		lo, hi := min(start, uintptr(addr)), max(end, uintptr(addr)+size)
+		if uint64(hi-lo) <= 0x7fffffff {
+			return &gotTable{mapping: unsafe.Slice((*byte)(addr), size), slots: make(map[uintptr]uintptr)}, nil
+		}
+		_ = unix.MunmapPtr(addr, size)
+	}
+	releaseMapping(uint64(size))
+	return nil, fmt.Errorf("map GOT: no free range within 2GiB of the image at %#x", start)
+}
+
+// slot returns the slot holding value, filling a new one on first use.
+func (got *gotTable) slot(value uintptr) (uintptr, error) {
+	if slot, ok := got.slots[value]; ok {
+		return slot, nil
+	}
+	if got.used+8 > uintptr(len(got.mapping)) {
+		return 0, fmt.Errorf("GOT of %d slots is full", len(got.mapping)/8)
+	}
+	slot := uintptr(unsafe.Pointer(&got.mapping[got.used]))
+	writeU64(slot, uint64(value))
+	got.used += 8
+	got.slots[value] = slot
+	return slot, nil
+}
+
+// seal makes the table read-only.
+func (got *gotTable) seal() error {
+	if err := unix.Mprotect(got.mapping, unix.PROT_READ); err != nil {
+		return fmt.Errorf("protect GOT: %w", err)
+	}
+	return nil
+}
+
+// free unmaps the table and releases its reservation against the mapping
+// budget.
+func (got *gotTable) free() {
+	if len(got.mapping) == 0 {
+		return
+	}
+	_ = unix.MunmapPtr(unsafe.Pointer(&got.mapping[0]), uintptr(len(got.mapping)))
+	releaseMapping(uint64(len(got.mapping)))
+	got.mapping = nil
+	got.slots = nil
+}
+
+// gotRelocValue computes what a GOT-relative relocation stores at place,
+// given the slot that holds its symbol: G + GOT + A - P.
+func gotRelocValue(relocType uint32, place, slot uintptr, addend int64) (relocWrite, error) {
+	v := int64(slot) + addend - int64(place)
+	if elf.R_X86_64(relocType) == elf.R_X86_64_GOTPCREL64 {
+		return relocWrite{uint64(v), 8}, nil
+	}
+	if v < -0x80000000 || v > 0x7fffffff {
+		return relocWrite{}, fmt.Errorf("x86_64 %s relocation overflow: value=%d", elf.R_X86_64(relocType), v)
+	}
+	return relocWrite{uint64(uint32(int32(v))), 4}, nil
+}
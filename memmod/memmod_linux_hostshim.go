@@ -0,0 +1,140 @@
+//go:build linux && !cgo && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The host shims are a minimal C library implemented in assembly. The
+// resolver binds imports to them when the process has no libc mapped, such as
+// a static CGO_ENABLED=0 binary in a scratch container, so simple payloads
+// can run without any host libraries. malloc and calloc carve from a fixed
+// 32 MiB arena allocated when the shims are first bound; free never reclaims
+// anything, so once the arena is used up every later allocation fails with
+// ENOMEM for the life of the process. errno is process-wide rather than
+// per-thread. getenv sees the Go process environment as it was at the start
+// of the current call into native code.
+
+const hostShimHeapBytes = 32 << 20
+
+const (
+	hostShimWrite = iota
+	hostShimMmap
+	hostShimMunmap
+	hostShimGetenv
+	hostShimMalloc
+	hostShimCalloc
+	hostShimFree
+	hostShimErrnoLocation
+	hostShimCount
+)
+
+var hostShimNames = map[string]int{
+	"write":            hostShimWrite,
+	"mmap":             hostShimMmap,
+	"mmap64":           hostShimMmap,
+	"munmap":           hostShimMunmap,
+	"getenv":           hostShimGetenv,
+	"secure_getenv":    hostShimGetenv,
+	"malloc":           hostShimMalloc,
+	"calloc":           hostShimCalloc,
+	"free":             hostShimFree,
+	"__errno_location": hostShimErrnoLocation,
+}
+
+// State shared with the assembly shims.
+var (
+	hostShimErrno    int32
+	hostShimEnviron  uintptr
+	hostShimHeapNext uintptr
+	hostShimHeapEnd  uintptr
+	hostShimHeapSize uintptr
+)
+
+var (
+	hostShimOnce      sync.Once
+	hostShimInstalled atomic.Bool
+	hostShimFuncs     [hostShimCount]uintptr
+	// Keep the Go memory handed to native code reachable.
+	hostShimHeap []byte
+)
+
+// hostShimEnv is the environment the getenv shim searches.
+var hostShimEnv struct {
+	sync.Mutex
+	vars []string
+	// blocks keeps every environment block handed to native code reachable:
+	// a payload may still hold a getenv result from an older one.
+	blocks [][]byte
+	ptrs   [][]uintptr
+}
+
+// hostShimAddrs stores the entry point of each shim into funcs.
+//
+//go:noescape
+func hostShimAddrs(funcs *[hostShimCount]uintptr)
+
+// hostShimSymbol returns the shim implementing name, installing the shims on
+// first use, or 0 if there is none.
+func hostShimSymbol(name string) uintptr {
+	index, ok := hostShimNames[name]
+	if !ok {
+		return 0
+	}
+	hostShimOnce.Do(installHostShims)
+	return hostShimFuncs[index]
+}
+
+// hostShimErrnoAddr returns the shim errno once the shims are in use.
+func hostShimErrnoAddr() *int32 {
+	if !hostShimInstalled.Load() {
+		return nil
+	}
+	return &hostShimErrno
+}
+
+func installHostShims() {
+	hostShimHeap = make([]byte, hostShimHeapBytes)
+	base := uintptr(unsafe.Pointer(&hostShimHeap[0]))
+	hostShimHeapSize = hostShimHeapBytes
+	hostShimHeapNext = (base + 15) &^ 15
+	hostShimHeapEnd = base + hostShimHeapBytes
+
+	refreshHostShimEnviron()
+	hostShimAddrs(&hostShimFuncs)
+	hostShimInstalled.Store(true)
+}
+
+// refreshHostShimEnviron rebuilds the environment the getenv shim searches, as
+// a NULL-terminated array of C strings, when os.Environ has changed since it
+// was last built.
+func refreshHostShimEnviron() {
+	env := os.Environ()
+	hostShimEnv.Lock()
+	defer hostShimEnv.Unlock()
+	if hostShimEnv.blocks != nil && slices.Equal(env, hostShimEnv.vars) {
+		return
+	}
+
+	size := 0
+	for _, kv := range env {
+		size += len(kv) + 1
+	}
+	block := make([]byte, size+1)
+	ptrs := make([]uintptr, 0, len(env)+1)
+	offset := 0
+	for _, kv := range env {
+		ptrs = append(ptrs, uintptr(unsafe.Pointer(&block[offset])))
+		offset += copy(block[offset:], kv) + 1
+	}
+	ptrs = append(ptrs, 0)
+	hostShimEnv.vars = env
+	hostShimEnv.blocks = append(hostShimEnv.blocks, block)
+	hostShimEnv.ptrs = append(hostShimEnv.ptrs, ptrs)
+	atomic.StoreUintptr(&hostShimEnviron, uintptr(unsafe.Pointer(&ptrs[0])))
+}
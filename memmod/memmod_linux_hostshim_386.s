@@ -0,0 +1,167 @@
+//go:build linux && !cgo && 386
+
+#include "textflag.h"
+
+// C-ABI host shims (i386 cdecl). Arguments are on the stack; BX, SI, DI and
+// BP are callee-saved and restored before returning.
+
+TEXT ·hostShimAddrs(SB), NOSPLIT, $0-4
+	MOVL funcs+0(FP), DX
+	LEAL hostShimWrite<>(SB), AX
+	MOVL AX, 0(DX)
+	LEAL hostShimMmap<>(SB), AX
+	MOVL AX, 4(DX)
+	LEAL hostShimMunmap<>(SB), AX
+	MOVL AX, 8(DX)
+	LEAL hostShimGetenv<>(SB), AX
+	MOVL AX, 12(DX)
+	LEAL hostShimMalloc<>(SB), AX
+	MOVL AX, 16(DX)
+	LEAL hostShimCalloc<>(SB), AX
+	MOVL AX, 20(DX)
+	LEAL hostShimFree<>(SB), AX
+	MOVL AX, 24(DX)
+	LEAL hostShimErrnoLocation<>(SB), AX
+	MOVL AX, 28(DX)
+	RET
+
+// ssize_t write(int fd, const void *buf, size_t count)
+TEXT hostShimWrite<>(SB), NOSPLIT, $0
+	PUSHL BX
+	MOVL 8(SP), BX
+	MOVL 12(SP), CX
+	MOVL 16(SP), DX
+	MOVL $4, AX // SYS_write
+	INT $0x80
+	POPL BX
+	JMP hostShimSyscallResult<>(SB)
+
+// void *mmap(void *addr, size_t length, int prot, int flags, int fd, off_t offset)
+TEXT hostShimMmap<>(SB), NOSPLIT, $0
+	PUSHL BX
+	PUSHL SI
+	PUSHL DI
+	PUSHL BP
+	MOVL 20(SP), BX
+	MOVL 24(SP), CX
+	MOVL 28(SP), DX
+	MOVL 32(SP), SI
+	MOVL 36(SP), DI
+	MOVL 40(SP), BP
+	SHRL $12, BP // mmap2 takes the offset in pages
+	MOVL $192, AX // SYS_mmap2
+	INT $0x80
+	POPL BP
+	POPL DI
+	POPL SI
+	POPL BX
+	JMP hostShimSyscallResult<>(SB)
+
+// int munmap(void *addr, size_t length)
+TEXT hostShimMunmap<>(SB), NOSPLIT, $0
+	PUSHL BX
+	MOVL 8(SP), BX
+	MOVL 12(SP), CX
+	MOVL $91, AX // SYS_munmap
+	INT $0x80
+	POPL BX
+	JMP hostShimSyscallResult<>(SB)
+
+// hostShimSyscallResult converts a raw syscall return in AX to the C
+// convention: -1 with errno set on failure.
+TEXT hostShimSyscallResult<>(SB), NOSPLIT, $0
+	CMPL AX, $-4095
+	JCS ok
+	NEGL AX
+	MOVL AX, ·hostShimErrno(SB)
+	MOVL $-1, AX
+ok:
+	RET
+
+// char *getenv(const char *name)
+TEXT hostShimGetenv<>(SB), NOSPLIT, $0
+	PUSHL SI
+	PUSHL DI
+	MOVL ·hostShimEnviron(SB), DX
+	TESTL DX, DX
+	JZ notfound
+next:
+	MOVL 0(DX), SI
+	TESTL SI, SI
+	JZ notfound
+	MOVL 12(SP), DI
+compare:
+	MOVBLZX 0(DI), AX
+	TESTB AL, AL
+	JZ endname
+	MOVBLZX 0(SI), CX
+	CMPB AL, CL
+	JNE skip
+	INCL DI
+	INCL SI
+	JMP compare
+endname:
+	CMPB 0(SI), $0x3d // '='
+	JNE skip
+	LEAL 1(SI), AX
+	JMP done
+skip:
+	ADDL $4, DX
+	JMP next
+notfound:
+	XORL AX, AX
+done:
+	POPL DI
+	POPL SI
+	RET
+
+// void *malloc(size_t size)
+TEXT hostShimMalloc<>(SB), NOSPLIT, $0
+	MOVL 4(SP), AX
+	JMP hostShimAlloc<>(SB)
+
+// void *calloc(size_t nmemb, size_t size)
+//
+// Arena memory is zeroed by the Go allocator and never reused.
+TEXT hostShimCalloc<>(SB), NOSPLIT, $0
+	MOVL 4(SP), AX
+	MULL 8(SP)
+	JCS overflow
+	JMP hostShimAlloc<>(SB)
+overflow:
+	JMP hostShimOutOfMemory<>(SB)
+
+// void free(void *ptr)
+TEXT hostShimFree<>(SB), NOSPLIT, $0
+	RET
+
+// int *__errno_location(void)
+TEXT hostShimErrnoLocation<>(SB), NOSPLIT, $0
+	LEAL ·hostShimErrno(SB), AX
+	RET
+
+// hostShimAlloc returns AX bytes, rounded up to 16, from the arena.
+TEXT hostShimAlloc<>(SB), NOSPLIT, $0
+	CMPL AX, ·hostShimHeapSize(SB)
+	JHI oom
+	TESTL AX, AX
+	JNZ sized
+	MOVL $1, AX
+sized:
+	ADDL $15, AX
+	ANDL $~15, AX
+	MOVL AX, CX
+	LEAL ·hostShimHeapNext(SB), DX
+	LOCK
+	XADDL AX, 0(DX)
+	LEAL 0(AX)(CX*1), DX
+	CMPL DX, ·hostShimHeapEnd(SB)
+	JHI oom
+	RET
+oom:
+	JMP hostShimOutOfMemory<>(SB)
+
+TEXT hostShimOutOfMemory<>(SB), NOSPLIT, $0
+	MOVL $12, ·hostShimErrno(SB) // ENOMEM
+	XORL AX, AX
+	RET
@@ -0,0 +1,139 @@
+//go:build linux && !cgo && amd64
+
+#include "textflag.h"
+
+// C-ABI host shims (System V AMD64). They run on the payload's stack and touch
+// only caller-saved registers and the shared state in memmod_linux_hostshim.go.
+
+TEXT ·hostShimAddrs(SB), NOSPLIT, $0-8
+	MOVQ funcs+0(FP), DI
+	LEAQ hostShimWrite<>(SB), AX
+	MOVQ AX, 0(DI)
+	LEAQ hostShimMmap<>(SB), AX
+	MOVQ AX, 8(DI)
+	LEAQ hostShimMunmap<>(SB), AX
+	MOVQ AX, 16(DI)
+	LEAQ hostShimGetenv<>(SB), AX
+	MOVQ AX, 24(DI)
+	LEAQ hostShimMalloc<>(SB), AX
+	MOVQ AX, 32(DI)
+	LEAQ hostShimCalloc<>(SB), AX
+	MOVQ AX, 40(DI)
+	LEAQ hostShimFree<>(SB), AX
+	MOVQ AX, 48(DI)
+	LEAQ hostShimErrnoLocation<>(SB), AX
+	MOVQ AX, 56(DI)
+	RET
+
+// ssize_t write(int fd, const void *buf, size_t count)
+TEXT hostShimWrite<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ $1, AX // SYS_write
+	SYSCALL
+	JMP hostShimSyscallResult<>(SB)
+
+// void *mmap(void *addr, size_t length, int prot, int flags, int fd, off_t offset)
+TEXT hostShimMmap<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ CX, R10
+	MOVQ $9, AX // SYS_mmap
+	SYSCALL
+	JMP hostShimSyscallResult<>(SB)
+
+// int munmap(void *addr, size_t length)
+TEXT hostShimMunmap<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ $11, AX // SYS_munmap
+	SYSCALL
+	JMP hostShimSyscallResult<>(SB)
+
+// hostShimSyscallResult converts a raw syscall return in AX to the C
+// convention: -1 with errno set on failure.
+TEXT hostShimSyscallResult<>(SB), NOSPLIT|NOFRAME, $0
+	CMPQ AX, $-4095
+	JCS ok
+	NEGQ AX
+	MOVL AX, ·hostShimErrno(SB)
+	MOVQ $-1, AX
+ok:
+	RET
+
+// char *getenv(const char *name)
+TEXT hostShimGetenv<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ ·hostShimEnviron(SB), R8
+	TESTQ R8, R8
+	JZ notfound
+next:
+	MOVQ 0(R8), R9
+	TESTQ R9, R9
+	JZ notfound
+	MOVQ DI, R10
+compare:
+	MOVBLZX 0(R10), AX
+	TESTB AL, AL
+	JZ endname
+	MOVBLZX 0(R9), CX
+	CMPB AL, CL
+	JNE skip
+	INCQ R10
+	INCQ R9
+	JMP compare
+endname:
+	CMPB 0(R9), $0x3d // '='
+	JNE skip
+	LEAQ 1(R9), AX
+	RET
+skip:
+	ADDQ $8, R8
+	JMP next
+notfound:
+	XORL AX, AX
+	RET
+
+// void *malloc(size_t size)
+TEXT hostShimMalloc<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ DI, AX
+	JMP hostShimAlloc<>(SB)
+
+// void *calloc(size_t nmemb, size_t size)
+//
+// Arena memory is zeroed by the Go allocator and never reused.
+TEXT hostShimCalloc<>(SB), NOSPLIT|NOFRAME, $0
+	MOVQ DI, AX
+	MULQ SI
+	JCS overflow
+	JMP hostShimAlloc<>(SB)
+overflow:
+	JMP hostShimOutOfMemory<>(SB)
+
+// void free(void *ptr)
+TEXT hostShimFree<>(SB), NOSPLIT|NOFRAME, $0
+	RET
+
+// int *__errno_location(void)
+TEXT hostShimErrnoLocation<>(SB), NOSPLIT|NOFRAME, $0
+	LEAQ ·hostShimErrno(SB), AX
+	RET
+
+// hostShimAlloc returns AX bytes, rounded up to 16, from the arena.
+TEXT hostShimAlloc<>(SB), NOSPLIT|NOFRAME, $0
+	CMPQ AX, ·hostShimHeapSize(SB)
+	JHI oom
+	TESTQ AX, AX
+	JNZ sized
+	MOVQ $1, AX
+sized:
+	ADDQ $15, AX
+	ANDQ $~15, AX
+	MOVQ AX, CX
+	LEAQ ·hostShimHeapNext(SB), DX
+	LOCK
+	XADDQ AX, 0(DX)
+	LEAQ 0(AX)(CX*1), DX
+	CMPQ DX, ·hostShimHeapEnd(SB)
+	JHI oom
+	RET
+oom:
+	JMP hostShimOutOfMemory<>(SB)
+
+TEXT hostShimOutOfMemory<>(SB), NOSPLIT|NOFRAME, $0
+	MOVL $12, ·hostShimErrno(SB) // ENOMEM
+	XORL AX, AX
+	RET
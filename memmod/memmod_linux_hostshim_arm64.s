@@ -0,0 +1,141 @@
+//go:build linux && !cgo && arm64
+
+#include "textflag.h"
+
+// C-ABI host shims (AAPCS64). They run on the payload's stack and touch only
+// argument and temporary registers (R0-R15) and the shared state in
+// memmod_linux_hostshim.go.
+
+TEXT ·hostShimAddrs(SB), NOSPLIT, $0-8
+	MOVD funcs+0(FP), R1
+	MOVD $hostShimWrite<>(SB), R0
+	MOVD R0, 0(R1)
+	MOVD $hostShimMmap<>(SB), R0
+	MOVD R0, 8(R1)
+	MOVD $hostShimMunmap<>(SB), R0
+	MOVD R0, 16(R1)
+	MOVD $hostShimGetenv<>(SB), R0
+	MOVD R0, 24(R1)
+	MOVD $hostShimMalloc<>(SB), R0
+	MOVD R0, 32(R1)
+	MOVD $hostShimCalloc<>(SB), R0
+	MOVD R0, 40(R1)
+	MOVD $hostShimFree<>(SB), R0
+	MOVD R0, 48(R1)
+	MOVD $hostShimErrnoLocation<>(SB), R0
+	MOVD R0, 56(R1)
+	RET
+
+// ssize_t write(int fd, const void *buf, size_t count)
+TEXT hostShimWrite<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $64, R8 // SYS_write
+	SVC
+	B hostShimSyscallResult<>(SB)
+
+// void *mmap(void *addr, size_t length, int prot, int flags, int fd, off_t offset)
+TEXT hostShimMmap<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $222, R8 // SYS_mmap
+	SVC
+	B hostShimSyscallResult<>(SB)
+
+// int munmap(void *addr, size_t length)
+TEXT hostShimMunmap<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $215, R8 // SYS_munmap
+	SVC
+	B hostShimSyscallResult<>(SB)
+
+// hostShimSyscallResult converts a raw syscall return in R0 to the C
+// convention: -1 with errno set on failure.
+TEXT hostShimSyscallResult<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $-4095, R9
+	CMP R9, R0
+	BLO ok
+	NEG R0, R0
+	MOVW R0, ·hostShimErrno(SB)
+	MOVD $-1, R0
+ok:
+	RET
+
+// char *getenv(const char *name)
+TEXT hostShimGetenv<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD ·hostShimEnviron(SB), R9
+	CBZ R9, notfound
+next:
+	MOVD (R9), R10
+	CBZ R10, notfound
+	MOVD R0, R11
+compare:
+	MOVBU (R11), R12
+	CBZ R12, endname
+	MOVBU (R10), R13
+	CMP R12, R13
+	BNE skip
+	ADD $1, R11
+	ADD $1, R10
+	B compare
+endname:
+	MOVBU (R10), R13
+	CMP $0x3d, R13 // '='
+	BNE skip
+	ADD $1, R10, R0
+	RET
+skip:
+	ADD $8, R9
+	B next
+notfound:
+	MOVD ZR, R0
+	RET
+
+// void *malloc(size_t size)
+TEXT hostShimMalloc<>(SB), NOSPLIT|NOFRAME, $0
+	B hostShimAlloc<>(SB)
+
+// void *calloc(size_t nmemb, size_t size)
+//
+// Arena memory is zeroed by the Go allocator and never reused.
+TEXT hostShimCalloc<>(SB), NOSPLIT|NOFRAME, $0
+	UMULH R1, R0, R9
+	CBNZ R9, overflow
+	MUL R1, R0, R0
+	B hostShimAlloc<>(SB)
+overflow:
+	B hostShimOutOfMemory<>(SB)
+
+// void free(void *ptr)
+TEXT hostShimFree<>(SB), NOSPLIT|NOFRAME, $0
+	RET
+
+// int *__errno_location(void)
+TEXT hostShimErrnoLocation<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $·hostShimErrno(SB), R0
+	RET
+
+// hostShimAlloc returns R0 bytes, rounded up to 16, from the arena.
+TEXT hostShimAlloc<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD ·hostShimHeapSize(SB), R9
+	CMP R9, R0
+	BHI oom
+	CBNZ R0, sized
+	MOVD $1, R0
+sized:
+	ADD $15, R0
+	AND $~15, R0
+	MOVD $·hostShimHeapNext(SB), R9
+retry:
+	LDAXR (R9), R10
+	ADD R0, R10, R11
+	STLXR R11, (R9), R12
+	CBNZ R12, retry
+	MOVD ·hostShimHeapEnd(SB), R13
+	CMP R13, R11
+	BHI oom
+	MOVD R10, R0
+	RET
+oom:
+	B hostShimOutOfMemory<>(SB)
+
+TEXT hostShimOutOfMemory<>(SB), NOSPLIT|NOFRAME, $0
+	MOVD $12, R9 // ENOMEM
+	MOVW R9, ·hostShimErrno(SB)
+	MOVD ZR, R0
+	RET
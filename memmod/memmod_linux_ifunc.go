@@ -0,0 +1,141 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"debug/elf"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+const (
+	atHWCAP  = 16
+	atHWCAP2 = 26
+	atHWCAP3 = 29
+
+	// ifuncArgHWCAP tells an aarch64 resolver that its second argument
+	// points to an __ifunc_arg_t.
+	ifuncArgHWCAP = uint64(1) << 62
+)
+
+// ifuncReloc is a relocation against a GNU indirect function the image
+// defines itself, or an IRELATIVE relocation naming its resolver, waiting for
+// the resolver to be callable. For IRELATIVE, relocValue stores the selected
+// implementation as is.
+type ifuncReloc struct {
+	machine   elf.Machine
+	place     uintptr
+	relocType uint32
+	resolver  uintptr
+	addend    int64
+}
+
+// ifuncTable resolves the GNU indirect functions (STT_GNU_IFUNC) an image
+// defines. A resolver is code in the image, so it can only run once the
+// segments are executable; relocations against indirect functions are queued
+// until then.
+type ifuncTable struct {
+	resolved map[uintptr]uintptr
+	pending  []ifuncReloc
+}
+
+// resolve calls the resolver at addr once and returns the implementation it
+// selects.
+func (table *ifuncTable) resolve(addr uintptr) (uintptr, error) {
+	if impl, ok := table.resolved[addr]; ok {
+		return impl, nil
+	}
+	arena := getArena()
+	defer arena.release()
+	args, err := resolverArgs(arena)
+	if err != nil {
+		return 0, err
+	}
+	impl := callNative(addr, args).Value
+	if impl == 0 {
+		return 0, fmt.Errorf("ifunc resolver at %#x returned nil", addr)
+	}
+	if table.resolved == nil {
+		table.resolved = make(map[uintptr]uintptr)
+	}
+	table.resolved[addr] = impl
+	return impl, nil
+}
+
+// resolverArgs returns the arguments glibc passes to resolvers: AT_HWCAP,
+// and on aarch64 a pointer to an __ifunc_arg_t, allocated from arena, holding
+// the remaining hardware capability words.
+func resolverArgs(arena *nativeArena) ([MaxCallArgs]uintptr, error) {
+	var hwcap, hwcap2, hwcap3 uintptr
+	for _, pair := range hostAuxv() {
+		switch pair[0] {
+		case atHWCAP:
+			hwcap = pair[1]
+		case atHWCAP2:
+			hwcap2 = pair[1]
+		case atHWCAP3:
+			hwcap3 = pair[1]
+		}
+	}
+	if runtime.GOARCH != "arm64" {
+		return [MaxCallArgs]uintptr{hwcap}, nil
+	}
+	addr, err := arena.alloc(unsafe.Sizeof([3]uint64{}), unsafe.Alignof(uint64(0)))
+	if err != nil {
+		return [MaxCallArgs]uintptr{}, err
+	}
+	arg := (*[3]uint64)(unsafe.Pointer(addr))
+	*arg = [3]uint64{uint64(unsafe.Sizeof(*arg)), uint64(hwcap2), uint64(hwcap3)}
+	return [MaxCallArgs]uintptr{uintptr(uint64(hwcap) | ifuncArgHWCAP), addr}, nil
+}
+
+// applyPending applies the queued relocations. It must run after
+// applySegmentProtections and before the image's initializers.
+func (table *ifuncTable) applyPending(mapped mappedELF) error {
+	for _, rel := range table.pending {
+		if !placeIsWritable(mapped, rel.place) {
+			return fmt.Errorf("ifunc relocation target %#x is not in a writable segment", rel.place-mapped.loadBias)
+		}
+		impl, err := table.resolve(rel.resolver)
+		if err != nil {
+			return err
+		}
+		err = applyReloc(rel.machine, rel.relocType, rel.place, mapped.loadBias, impl, rel.addend)
+		if err != nil {
+			return err
+		}
+	}
+	table.pending = nil
+	return nil
+}
+
+// placeIsWritable reports whether place lies in a PT_LOAD segment that stays
+// writable after applySegmentProtections.
+func placeIsWritable(mapped mappedELF, place uintptr) bool {
+	vaddr := uint64(place - mapped.loadBias)
+	for _, p := range mapped.progs {
+		if p.Flags&elf.PF_W != 0 && vaddr >= p.Vaddr && vaddr < p.Vaddr+p.Memsz {
+			return true
+		}
+	}
+	return false
+}
+
+func isLocalIFunc(sym elf.Symbol) bool {
+	return elf.ST_TYPE(sym.Info) == elf.STT_GNU_IFUNC && sym.Section != elf.SHN_UNDEF && sym.Value != 0
+}
+
+// isIRelative reports whether relocType is an IRELATIVE relocation, which
+// stores what the resolver at load bias plus addend returns.
+func isIRelative(machine elf.Machine, relocType uint32) bool {
+	switch machine {
+	case elf.EM_X86_64:
+		return elf.R_X86_64(relocType) == elf.R_X86_64_IRELATIVE
+	case elf.EM_386:
+		return elf.R_386(relocType) == elf.R_386_IRELATIVE
+	case elf.EM_AARCH64:
+		return elf.R_AARCH64(relocType) == elf.R_AARCH64_IRELATIVE
+	}
+	return false
+}
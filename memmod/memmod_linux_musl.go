@@ -0,0 +1,112 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// musl keeps the whole C library, its dynamic linker included, in one module
+// (ld-musl-<arch>.so.1, which libc.so links to) and has no symbol versions.
+// Images built against glibc still name libc.so.6, libpthread.so.0,
+// ld-linux-x86-64.so.2 and the rest, and pin symbol versions, so in musl mode
+// those dependencies are satisfied by the one libc and versions are dropped.
+
+// muslLibraries are the dependency name prefixes musl's libc stands in for.
+var muslLibraries = []string{
+	"libc.so",
+	"libc.musl-",
+	"ld-musl-",
+	"ld-linux",
+	"libdl.so",
+	"libpthread.so",
+	"libm.so",
+	"librt.so",
+	"libutil.so",
+	"libresolv.so",
+	"libcrypt.so",
+	"libxnet.so",
+}
+
+// hostMuslCache holds hostMusl's answer until ResetHostSymbols drops it.
+var hostMuslCache atomic.Pointer[bool]
+
+// hostMusl reports whether musl's dynamic linker is mapped in the process.
+func hostMusl() bool {
+	if musl := hostMuslCache.Load(); musl != nil {
+		return *musl
+	}
+	entries, err := readProcMaps()
+	musl := err == nil && mapsHaveMusl(entries)
+	hostMuslCache.Store(&musl)
+	return musl
+}
+
+// useMusl reports whether imports resolve against musl under mode.
+func useMusl(mode LibcMode) bool {
+	switch mode {
+	case LibcMusl:
+		return true
+	case LibcGlibc:
+		return false
+	default:
+		return hostMusl()
+	}
+}
+
+// mapsHaveMusl reports whether entries include musl's dynamic linker.
+func mapsHaveMusl(entries []procMapEntry) bool {
+	for _, entry := range entries {
+		if isMuslLibc(entry.path) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMuslLibc(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, "ld-musl-") || strings.HasPrefix(base, "libc.musl-")
+}
+
+// muslProvides reports whether musl's libc stands in for the dependency
+// name.
+func muslProvides(name string) bool {
+	base := filepath.Base(name)
+	for _, prefix := range muslLibraries {
+		if strings.HasPrefix(base, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMuslLibc reports whether musl's libc is among the resolver's modules.
+func (resolver *symbolResolver) hasMuslLibc() bool {
+	for _, module := range resolver.modules {
+		if isMuslLibc(module.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonDependencies returns the libraries imports may resolve against
+// whatever the image lists: the C library and its dynamic linker.
+func (resolver *symbolResolver) commonDependencies() []string {
+	if !resolver.musl {
+		return commonLinuxDependencies()
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		return []string{"ld-musl-x86_64.so.1"}
+	case "386":
+		return []string{"ld-musl-i386.so.1"}
+	case "arm64":
+		return []string{"ld-musl-aarch64.so.1"}
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// The loader oracle loads an image once with the system's dlopen and once
+// with memmod, each in a scratch child process running this test binary, and
+// compares what the two produced: where each export resolves and the
+// contents of every writable segment once the constructors have run. A
+// relocation the loader gets wrong, or a constructor it fails to run, shows
+// up as a divergence.
+//
+// Set REFLEKTOR_ORACLE_IMAGES to a list of shared objects, separated like
+// PATH, to check them alongside the fixtures.
+const (
+	oracleLoaderEnv = "REFLEKTOR_ORACLE_LOADER"
+	oracleImageEnv  = "REFLEKTOR_ORACLE_IMAGE"
+	oracleOutputEnv = "REFLEKTOR_ORACLE_OUTPUT"
+	oracleImagesEnv = "REFLEKTOR_ORACLE_IMAGES"
+)
+
+// oracleFixtures are the fixtures that build as standalone shared objects.
+var oracleFixtures = []string{
+	"basic.c",
+	"callresult.c",
+	"ifunc_export.c",
+	"imports.c",
+	"initializers.c",
+	"mangled.c",
+	"symbols.c",
+	"reloc/basic.c",
+}
+
+// oracleReport is what a child process observed. Addresses are normalized so
+// they compare across processes: "image+0x..." inside the image,
+// "path+0x..." inside a file mapping, "anon" inside any other mapping, and
+// the plain value otherwise.
+type oracleReport struct {
+	Exports map[string]string `json:"exports"`
+	// Data maps the address of each non-zero word of a writable segment,
+	// relative to the image, to its normalized value.
+	Data map[uint64]string `json:"data"`
+}
+
+func TestLoaderOracle_Linux(t *testing.T) {
+	if loader := os.Getenv(oracleLoaderEnv); loader != "" {
+		runOracleChild(t, loader, os.Getenv(oracleImageEnv), os.Getenv(oracleOutputEnv))
+		return
+	}
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	images := map[string]string{}
+	for _, fixture := range oracleFixtures {
+		name := strings.TrimSuffix(strings.ReplaceAll(fixture, "/", "_"), ".c")
+		soPath := filepath.Join(t.TempDir(), fmt.Sprintf("oracle_%s_linux-%s.so", name, runtime.GOARCH))
+		buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", fixture), soPath)
+		images[fixture] = soPath
+	}
+	for _, path := range filepath.SplitList(os.Getenv(oracleImagesEnv)) {
+		if path != "" {
+			images[path] = path
+		}
+	}
+
+	for _, name := range sortedKeys(images) {
+		path := images[name]
+		t.Run(name, func(t *testing.T) {
+			want := oracleLoad(t, "dlopen", path)
+			got := oracleLoad(t, "memmod", path)
+			for _, divergence := range oracleDivergences(want, got) {
+				t.Error(divergence)
+			}
+		})
+	}
+}
+
+// oracleLoad runs a child process that loads path with loader and returns
+// its report.
+func oracleLoad(t *testing.T, loader, path string) oracleReport {
+	t.Helper()
+
+	output := filepath.Join(t.TempDir(), loader+".json")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoaderOracle_Linux$")
+	cmd.Env = append(os.Environ(),
+		oracleLoaderEnv+"="+loader,
+		oracleImageEnv+"="+path,
+		oracleOutputEnv+"="+output,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s child: %v\n%s", loader, err, out)
+	}
+	raw, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read %s report: %v", loader, err)
+	}
+	var report oracleReport
+	if err := json.Unmarshal(raw, &report); err != nil {
+		t.Fatalf("decode %s report: %v", loader, err)
+	}
+	return report
+}
+
+func oracleDivergences(want, got oracleReport) []string {
+	var out []string
+	for _, name := range sortedKeys(want.Exports) {
+		if got.Exports[name] != want.Exports[name] {
+			out = append(out, fmt.Sprintf("export %s: memmod %q, dlopen %q", name, got.Exports[name], want.Exports[name]))
+		}
+	}
+	for _, name := range sortedKeys(got.Exports) {
+		if _, ok := want.Exports[name]; !ok {
+			out = append(out, fmt.Sprintf("export %s: memmod %q, dlopen has none", name, got.Exports[name]))
+		}
+	}
+	addrs := map[uint64]struct{}{}
+	for addr := range want.Data {
+		addrs[addr] = struct{}{}
+	}
+	for addr := range got.Data {
+		addrs[addr] = struct{}{}
+	}
+	for _, addr := range sortedKeys(addrs) {
+		if got.Data[addr] != want.Data[addr] {
+			out = append(out, fmt.Sprintf("word at image+%#x: memmod %q, dlopen %q", addr, orZero(got.Data[addr]), orZero(want.Data[addr])))
+		}
+	}
+	return out
+}
+
+func orZero(value string) string {
+	if value == "" {
+		return "0"
+	}
+	return value
+}
+
+func sortedKeys[K string | uint64, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// runOracleChild loads path with loader and writes the report to output.
+func runOracleChild(t *testing.T, loader, path, output string) {
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var (
+		loadBias uintptr
+		lookup   func(name string) (uintptr, bool)
+	)
+	switch loader {
+	case "dlopen":
+		api, err := getLinuxDynAPI()
+		if err != nil {
+			t.Fatalf("resolve dl API: %v", err)
+		}
+		handle, err := openWithDlopen(api, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// glibc's handle is its struct link_map, which starts with l_addr.
+		loadBias = *(*uintptr)(unsafe.Pointer(handle))
+		lookup = func(name string) (uintptr, bool) {
+			arena := getArena()
+			defer arena.release()
+			cName, err := arena.cString(name)
+			if err != nil {
+				return 0, false
+			}
+			addr := cCall2(api.dlsym, handle, cName)
+			return addr, addr != 0
+		}
+	case "memmod":
+		module, err := LoadLibraryWithOptions(payload, LoadOptions{Symbols: SymbolsObjects})
+		if err != nil {
+			t.Fatalf("LoadLibraryWithOptions: %v", err)
+		}
+		loadBias = module.loadBias
+		lookup = func(name string) (uintptr, bool) {
+			addr, err := module.ProcAddressByName(name)
+			return addr, err == nil
+		}
+	default:
+		t.Fatalf("unknown oracle loader %q", loader)
+	}
+
+	var low, high uint64 = ^uint64(0), 0
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			low, high = min(low, p.Vaddr), max(high, p.Vaddr+p.Memsz)
+		}
+	}
+	normalize := oracleNormalizer(t, loadBias+uintptr(low), loadBias+uintptr(high), loadBias)
+
+	report := oracleReport{Exports: map[string]string{}, Data: map[uint64]string{}}
+	dynSyms, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatalf("read dynamic symbols: %v", err)
+	}
+	for _, sym := range dynSyms {
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT, elf.STT_GNU_IFUNC:
+		default:
+			continue
+		}
+		if sym.Section == elf.SHN_UNDEF || sym.Value == 0 || elf.ST_BIND(sym.Info) == elf.STB_LOCAL {
+			continue
+		}
+		if addr, ok := lookup(sym.Name); ok {
+			report.Exports[sym.Name] = normalize(addr)
+		}
+	}
+
+	skip := oracleLoaderPrivateWords(f)
+	word := uint64(unsafe.Sizeof(uintptr(0)))
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Flags&elf.PF_W == 0 {
+			continue
+		}
+		for vaddr := (p.Vaddr + word - 1) &^ (word - 1); vaddr+word <= p.Vaddr+p.Memsz; vaddr += word {
+			if _, ok := skip[vaddr]; ok {
+				continue
+			}
+			if value := *(*uintptr)(unsafe.Pointer(loadBias + uintptr(vaddr))); value != 0 {
+				report.Data[vaddr] = normalize(value)
+			}
+		}
+	}
+
+	raw, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("encode report: %v", err)
+	}
+	if err := os.WriteFile(output, raw, 0o600); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+}
+
+// oracleLoaderPrivateWords returns the words each loader is free to fill in
+// its own way: the dynamic section, which glibc rebases in place, and the
+// reserved GOT entries that hold ld.so's lazy-binding state.
+func oracleLoaderPrivateWords(f *elf.File) map[uint64]struct{} {
+	word := uint64(unsafe.Sizeof(uintptr(0)))
+	out := map[uint64]struct{}{}
+	add := func(start, size uint64) {
+		for vaddr := start &^ (word - 1); vaddr < start+size; vaddr += word {
+			out[vaddr] = struct{}{}
+		}
+	}
+	if sec := f.Section(".dynamic"); sec != nil {
+		add(sec.Addr, sec.Size)
+	}
+	if sec := f.Section(".got.plt"); sec != nil {
+		add(sec.Addr, 3*word)
+	} else if sec := f.Section(".got"); sec != nil && f.Machine == elf.EM_AARCH64 {
+		add(sec.Addr, 3*word)
+	}
+	return out
+}
+
+// oracleNormalizer returns a function that describes an address in terms
+// that do not depend on where this process mapped things.
+func oracleNormalizer(t *testing.T, low, high, loadBias uintptr) func(uintptr) string {
+	t.Helper()
+
+	type mapping struct {
+		start, end, offset uintptr
+		path               string
+	}
+	raw, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatalf("read /proc/self/maps: %v", err)
+	}
+	var mappings []mapping
+	for _, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			continue
+		}
+		m := mapping{}
+		var errs [3]error
+		m.start, errs[0] = parseHexUintptr(start)
+		m.end, errs[1] = parseHexUintptr(end)
+		m.offset, errs[2] = parseHexUintptr(fields[2])
+		if errs[0] != nil || errs[1] != nil || errs[2] != nil {
+			continue
+		}
+		if len(fields) >= 6 && strings.HasPrefix(fields[5], "/") {
+			m.path = fields[5]
+		}
+		mappings = append(mappings, m)
+	}
+
+	return func(addr uintptr) string {
+		if addr >= low && addr < high {
+			return fmt.Sprintf("image+%#x", addr-loadBias)
+		}
+		for _, m := range mappings {
+			if addr < m.start || addr >= m.end {
+				continue
+			}
+			if m.path == "" {
+				return "anon"
+			}
+			return fmt.Sprintf("%s+%#x", m.path, addr-m.start+m.offset)
+		}
+		return fmt.Sprintf("%#x", addr)
+	}
+}
@@ -0,0 +1,743 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+type relocKind string
+
+const (
+	relocRelative relocKind = "RELATIVE"
+	relocAbs      relocKind = "ABS"
+	relocGlobDat  relocKind = "GLOB_DAT"
+	relocJumpSlot relocKind = "JUMP_SLOT"
+	relocPC32     relocKind = "PC32"
+	relocIRel     relocKind = "IRELATIVE"
+	relocCopy     relocKind = "COPY"
+	relocTPOff    relocKind = "TPOFF"
+	relocDTPMod   relocKind = "DTPMOD"
+	relocDTPOff   relocKind = "DTPOFF"
+	relocTLSDesc  relocKind = "TLSDESC"
+)
+
+// relocKindTypes maps a relocation kind to the concrete r_type values used by
+// each supported machine.
+var relocKindTypes = map[relocKind]map[elf.Machine][]uint32{
+	relocRelative: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_RELATIVE)},
+		elf.EM_386:     {uint32(elf.R_386_RELATIVE)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_RELATIVE)},
+	},
+	relocAbs: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_64)},
+		elf.EM_386:     {uint32(elf.R_386_32)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_ABS64)},
+	},
+	relocGlobDat: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_GLOB_DAT)},
+		elf.EM_386:     {uint32(elf.R_386_GLOB_DAT)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_GLOB_DAT)},
+	},
+	relocJumpSlot: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_JMP_SLOT)},
+		elf.EM_386:     {uint32(elf.R_386_JMP_SLOT)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_JUMP_SLOT)},
+	},
+	relocPC32: {
+		elf.EM_386: {uint32(elf.R_386_PC32)},
+	},
+	relocIRel: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_IRELATIVE)},
+		elf.EM_386:     {uint32(elf.R_386_IRELATIVE)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_IRELATIVE)},
+	},
+	relocCopy: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_COPY)},
+		elf.EM_386:     {uint32(elf.R_386_COPY)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_COPY)},
+	},
+	relocTPOff: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_TPOFF64)},
+		elf.EM_386:     {uint32(elf.R_386_TLS_TPOFF), uint32(elf.R_386_TLS_TPOFF32)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_TLS_TPREL64)},
+	},
+	relocDTPMod: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_DTPMOD64)},
+		elf.EM_386:     {uint32(elf.R_386_TLS_DTPMOD32)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_TLS_DTPMOD64)},
+	},
+	relocDTPOff: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_DTPOFF64)},
+		elf.EM_386:     {uint32(elf.R_386_TLS_DTPOFF32)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_TLS_DTPREL64)},
+	},
+	relocTLSDesc: {
+		elf.EM_X86_64:  {uint32(elf.R_X86_64_TLSDESC)},
+		elf.EM_386:     {uint32(elf.R_386_TLS_DESC)},
+		elf.EM_AARCH64: {uint32(elf.R_AARCH64_TLSDESC)},
+	},
+}
+
+type relocCoverageCase struct {
+	name     string
+	source   string
+	cflags   []string
+	machines []elf.Machine
+	kinds    []relocKind
+	// pending is set while the loader does not support a relocation kind the
+	// fixture emits; the case is skipped with this reason.
+	pending string
+	// loadErr is the error the loader must refuse the fixture with.
+	loadErr error
+	check   func(t *testing.T, module *Module)
+}
+
+var relocCoverageCases = []relocCoverageCase{
+	{
+		name:   "basic",
+		source: "basic.c",
+		kinds:  []relocKind{relocRelative, relocAbs, relocGlobDat, relocJumpSlot},
+		check: func(t *testing.T, module *Module) {
+			if got := int32(callFixtureExport(t, module, "reloc_relative_value")); got != 7 {
+				t.Fatalf("reloc_relative_value() = %d, want 7", got)
+			}
+
+			api, err := getLinuxDynAPI()
+			if err != nil {
+				t.Fatalf("resolve dl API: %v", err)
+			}
+			want, err := resolveWithDLSym(api, "getenv")
+			if err != nil {
+				t.Fatalf("dlsym(getenv): %v", err)
+			}
+			if got := callFixtureExport(t, module, "reloc_glob_dat_getenv"); got != want {
+				t.Fatalf("reloc_glob_dat_getenv() = %#x, want %#x", got, want)
+			}
+
+			arena := getArena()
+			defer arena.release()
+			arg, err := arena.cString("1337")
+			if err != nil {
+				t.Fatalf("build C string: %v", err)
+			}
+			got := int32(callFixtureExport(t, module, "reloc_jump_slot_atoi", arg))
+			if got != 1337 {
+				t.Fatalf("reloc_jump_slot_atoi(\"1337\") = %d, want 1337", got)
+			}
+		},
+	},
+	{
+		name:     "textrel",
+		source:   "textrel.c",
+		cflags:   []string{"-fno-PIC", "-Wl,-z,notext"},
+		machines: []elf.Machine{elf.EM_386},
+		kinds:    []relocKind{relocPC32},
+		check: func(t *testing.T, module *Module) {
+			arena := getArena()
+			defer arena.release()
+			arg, err := arena.cString("reflektor")
+			if err != nil {
+				t.Fatalf("build C string: %v", err)
+			}
+			got := callFixtureExport(t, module, "reloc_pc32_strlen", arg)
+			if got != 9 {
+				t.Fatalf("reloc_pc32_strlen(\"reflektor\") = %d, want 9", got)
+			}
+		},
+	},
+	{
+		name:     "tls_ie",
+		source:   "tls_ie.c",
+		machines: []elf.Machine{elf.EM_X86_64, elf.EM_AARCH64},
+		kinds:    []relocKind{relocTPOff},
+		check: func(t *testing.T, module *Module) {
+			checkTLSCounter(t, module, "reloc_tls_ie_next", 6)
+		},
+	},
+	{
+		name:     "tls_ie_386",
+		source:   "tls_ie.c",
+		machines: []elf.Machine{elf.EM_386},
+		kinds:    []relocKind{relocTPOff},
+		loadErr:  ErrTLSUnsupported,
+	},
+	{
+		name:     "tls_gd",
+		source:   "tls_gd.c",
+		machines: []elf.Machine{elf.EM_X86_64},
+		kinds:    []relocKind{relocDTPMod, relocDTPOff},
+		check: func(t *testing.T, module *Module) {
+			checkTLSCounter(t, module, "reloc_tls_gd_next", 10)
+		},
+	},
+	{
+		name:     "tls_gd_386",
+		source:   "tls_gd.c",
+		machines: []elf.Machine{elf.EM_386},
+		kinds:    []relocKind{relocDTPMod, relocDTPOff},
+		loadErr:  ErrTLSUnsupported,
+	},
+	{
+		// aarch64 toolchains default to TLS descriptors for general-dynamic.
+		name:     "tls_desc",
+		source:   "tls_gd.c",
+		machines: []elf.Machine{elf.EM_AARCH64},
+		kinds:    []relocKind{relocTLSDesc},
+		check: func(t *testing.T, module *Module) {
+			checkTLSCounter(t, module, "reloc_tls_gd_next", 10)
+		},
+	},
+	{
+		name:     "tls_desc_amd64",
+		source:   "tls_gd.c",
+		cflags:   []string{"-mtls-dialect=gnu2"},
+		machines: []elf.Machine{elf.EM_X86_64},
+		kinds:    []relocKind{relocTLSDesc},
+		loadErr:  ErrTLSUnsupported,
+	},
+	{
+		name:   "ifunc",
+		source: "ifunc.c",
+		kinds:  []relocKind{relocIRel},
+		check: func(t *testing.T, module *Module) {
+			if got := int32(callFixtureExport(t, module, "reloc_ifunc_call")); got != 0x1f {
+				t.Fatalf("reloc_ifunc_call() = %#x, want 0x1f", got)
+			}
+		},
+	},
+}
+
+func TestRelocationCoverage_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	machine, err := currentELFMachine()
+	if err != nil {
+		t.Fatalf("current ELF machine: %v", err)
+	}
+
+	for _, tc := range relocCoverageCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !relocCaseAppliesTo(tc, machine) {
+				t.Skipf("fixture %s does not apply to %s", tc.source, machine)
+			}
+			if tc.pending != "" {
+				t.Skip(tc.pending)
+			}
+
+			soPath := filepath.Join(t.TempDir(), fmt.Sprintf("reloc_%s_linux-%s.so", tc.name, runtime.GOARCH))
+			buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "reloc", tc.source), soPath, tc.cflags...)
+
+			payload, err := os.ReadFile(soPath)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+			f, err := elf.NewFile(bytes.NewReader(payload))
+			if err != nil {
+				t.Fatalf("parse fixture: %v", err)
+			}
+			defer f.Close()
+
+			relocs := readFixtureRelocations(t, f, payload)
+			for _, kind := range tc.kinds {
+				if countRelocKind(relocs, machine, kind) == 0 {
+					t.Fatalf("fixture %s does not contain any %s relocations", tc.source, kind)
+				}
+			}
+
+			module, err := LoadLibraryWithOptions(payload, LoadOptions{VerifyRelocations: true})
+			if tc.loadErr != nil {
+				if !errors.Is(err, tc.loadErr) {
+					t.Fatalf("LoadLibrary: err = %v, want %v", err, tc.loadErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLibrary: %v", err)
+			}
+			t.Cleanup(module.Free)
+
+			verifyAppliedRelocations(t, f, module, machine, relocs)
+			if check := module.Relocations(); check == nil || check.Checked == 0 || len(check.Mismatches) != 0 {
+				t.Fatalf("Relocations() = %+v, want checked slots and no mismatches", check)
+			}
+			if tc.check != nil {
+				tc.check(t, module)
+			}
+		})
+	}
+}
+
+// Copy relocations only appear in executables, so the fixture is a PIE
+// rather than a shared object. Whether a PIE gets them for extern data is up
+// to the toolchain.
+func TestCopyRelocation_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	machine, err := currentELFMachine()
+	if err != nil {
+		t.Fatalf("current ELF machine: %v", err)
+	}
+
+	exePath := filepath.Join(t.TempDir(), fmt.Sprintf("reloc_copy_linux-%s", runtime.GOARCH))
+	source := filepath.Join("..", "testdata", "c", "reloc", "copy.c")
+	if err := runLinuxZigCC(linuxZigTarget(t, "gnu"), exePath, source, "-fPIE", "-pie", "-rdynamic", "-O2", "-g0"); err != nil {
+		t.Fatalf("build fixture: %v", err)
+	}
+	payload, err := os.ReadFile(exePath)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("parse fixture: %v", err)
+	}
+	defer f.Close()
+	if countRelocKind(readFixtureRelocations(t, f, payload), machine, relocCopy) == 0 {
+		t.Skip("toolchain did not emit copy relocations for the PIE fixture")
+	}
+
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{VerifyRelocations: true})
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+	if check := module.Relocations(); check == nil || len(check.Mismatches) != 0 {
+		t.Fatalf("Relocations() = %+v, want no mismatches", check)
+	}
+
+	api, err := getLinuxDynAPI()
+	if err != nil {
+		t.Fatalf("resolve dl API: %v", err)
+	}
+	libcStdout, err := resolveWithDLSym(api, "stdout")
+	if err != nil {
+		t.Fatalf("dlsym(stdout): %v", err)
+	}
+	want := uintptr(readU64(libcStdout))
+	if f.Class == elf.ELFCLASS32 {
+		want = uintptr(readU32(libcStdout))
+	}
+	if got := callFixtureExport(t, module, "reloc_copy_stdout"); got != want {
+		t.Fatalf("reloc_copy_stdout() = %#x, want libc's stdout %#x", got, want)
+	}
+}
+
+func TestVerifyRelocationsReportsCorruptedSlot_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("reloc_verify_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "reloc", "basic.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("parse fixture: %v", err)
+	}
+	defer f.Close()
+
+	mapped, err := mapELFImage(payload, f, LoadOptions{})
+	if err != nil {
+		t.Fatalf("map fixture: %v", err)
+	}
+	defer unmapImage(mapped.mapping)
+	resolver := newSymbolResolver(f, LoadOptions{})
+	if err := applyDynamicRelocations(mapped, f, resolver); err != nil {
+		t.Fatalf("apply relocations: %v", err)
+	}
+
+	machine, err := currentELFMachine()
+	if err != nil {
+		t.Fatalf("current ELF machine: %v", err)
+	}
+	var target fixtureReloc
+	for _, rel := range readFixtureRelocations(t, f, payload) {
+		if kind, ok := relocKindOf(machine, rel.typ); ok && kind == relocGlobDat {
+			target = rel
+			break
+		}
+	}
+	if target.offset == 0 {
+		t.Fatal("fixture has no GLOB_DAT relocation")
+	}
+	place := mapped.loadBias + uintptr(target.offset)
+	if f.Class == elf.ELFCLASS64 {
+		writeU64(place, 0x4141414141414141)
+	} else {
+		writeU32(place, 0x41414141)
+	}
+
+	check, err := verifyRelocations(payload, mapped, f, resolver)
+	if err != nil {
+		t.Fatalf("verifyRelocations: %v", err)
+	}
+	if len(check.Mismatches) != 1 {
+		t.Fatalf("Mismatches = %+v, want exactly the corrupted slot", check.Mismatches)
+	}
+	mismatch := check.Mismatches[0]
+	if mismatch.Offset != target.offset || mismatch.Got&0xffffffff != 0x41414141 || mismatch.Want == mismatch.Got {
+		t.Fatalf("mismatch = %+v, want offset %#x holding 0x41414141", mismatch, target.offset)
+	}
+	if mismatch.Symbol == "" || !strings.Contains(mismatch.Type, "GLOB_DAT") {
+		t.Fatalf("mismatch = %+v, want a named GLOB_DAT relocation", mismatch)
+	}
+}
+
+type fixtureReloc struct {
+	section string
+	offset  uint64
+	symbol  uint32
+	typ     uint32
+	addend  int64
+}
+
+func relocCaseAppliesTo(tc relocCoverageCase, machine elf.Machine) bool {
+	if len(tc.machines) == 0 {
+		return true
+	}
+	for _, m := range tc.machines {
+		if m == machine {
+			return true
+		}
+	}
+	return false
+}
+
+func relocKindOf(machine elf.Machine, typ uint32) (relocKind, bool) {
+	for kind, byMachine := range relocKindTypes {
+		for _, candidate := range byMachine[machine] {
+			if candidate == typ {
+				return kind, true
+			}
+		}
+	}
+	return "", false
+}
+
+func countRelocKind(relocs []fixtureReloc, machine elf.Machine, kind relocKind) int {
+	n := 0
+	for _, rel := range relocs {
+		if got, ok := relocKindOf(machine, rel.typ); ok && got == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// readFixtureRelocations decodes the dynamic relocation sections straight from
+// the file, recovering REL implicit addends from the original segment bytes.
+func readFixtureRelocations(t *testing.T, f *elf.File, raw []byte) []fixtureReloc {
+	t.Helper()
+
+	var out []fixtureReloc
+	for _, sec := range relocationSections(f) {
+		data, err := sec.Data()
+		if err != nil {
+			t.Fatalf("read %s: %v", sec.Name, err)
+		}
+		switch {
+		case sec.Type == elf.SHT_RELA && f.Class == elf.ELFCLASS64:
+			for i := 0; i+24 <= len(data); i += 24 {
+				info := binary.LittleEndian.Uint64(data[i+8 : i+16])
+				out = append(out, fixtureReloc{
+					section: sec.Name,
+					offset:  binary.LittleEndian.Uint64(data[i : i+8]),
+					symbol:  elf.R_SYM64(info),
+					typ:     elf.R_TYPE64(info),
+					addend:  int64(binary.LittleEndian.Uint64(data[i+16 : i+24])),
+				})
+			}
+		case sec.Type == elf.SHT_RELA && f.Class == elf.ELFCLASS32:
+			for i := 0; i+12 <= len(data); i += 12 {
+				info := binary.LittleEndian.Uint32(data[i+4 : i+8])
+				out = append(out, fixtureReloc{
+					section: sec.Name,
+					offset:  uint64(binary.LittleEndian.Uint32(data[i : i+4])),
+					symbol:  elf.R_SYM32(info),
+					typ:     elf.R_TYPE32(info),
+					addend:  int64(int32(binary.LittleEndian.Uint32(data[i+8 : i+12]))),
+				})
+			}
+		case sec.Type == elf.SHT_REL && f.Class == elf.ELFCLASS32:
+			for i := 0; i+8 <= len(data); i += 8 {
+				off := uint64(binary.LittleEndian.Uint32(data[i : i+4]))
+				info := binary.LittleEndian.Uint32(data[i+4 : i+8])
+				out = append(out, fixtureReloc{
+					section: sec.Name,
+					offset:  off,
+					symbol:  elf.R_SYM32(info),
+					typ:     elf.R_TYPE32(info),
+					addend:  int64(int32(binary.LittleEndian.Uint32(fileBytesAtVAddr(t, f, raw, off, 4)))),
+				})
+			}
+		default:
+			t.Fatalf("unexpected relocation section %s (%s, %s)", sec.Name, sec.Type, f.Class)
+		}
+	}
+	return out
+}
+
+func fileBytesAtVAddr(t *testing.T, f *elf.File, raw []byte, vaddr uint64, size uint64) []byte {
+	t.Helper()
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || vaddr < p.Vaddr || vaddr+size > p.Vaddr+p.Filesz {
+			continue
+		}
+		off := p.Off + (vaddr - p.Vaddr)
+		return raw[off : off+size]
+	}
+	t.Fatalf("vaddr %#x is not backed by file data", vaddr)
+	return nil
+}
+
+// verifyAppliedRelocations checks every relocation slot whose semantics are
+// independent of loader-internal state against an expected value computed
+// from the original image and the host's dlsym.
+func verifyAppliedRelocations(t *testing.T, f *elf.File, module *Module, machine elf.Machine, relocs []fixtureReloc) {
+	t.Helper()
+
+	dynSyms, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatalf("read dynamic symbols: %v", err)
+	}
+	api, err := getLinuxDynAPI()
+	if err != nil {
+		t.Fatalf("resolve dl API: %v", err)
+	}
+
+	wordSize := 8
+	if f.Class == elf.ELFCLASS32 {
+		wordSize = 4
+	}
+
+	for _, rel := range relocs {
+		kind, ok := relocKindOf(machine, rel.typ)
+		if !ok {
+			continue
+		}
+
+		place := module.loadBias + uintptr(rel.offset)
+		var want uintptr
+		switch kind {
+		case relocRelative:
+			want = uintptr(int64(module.loadBias) + rel.addend)
+		case relocAbs, relocGlobDat, relocJumpSlot:
+			sym, ok := dynSymbolByIndex(dynSyms, rel.symbol)
+			if !ok {
+				t.Fatalf("%s@%#x: invalid symbol index %d", rel.section, rel.offset, rel.symbol)
+			}
+			s := expectedSymbolValue(t, api, module, sym)
+			want = s
+			if machine != elf.EM_386 || kind == relocAbs {
+				want = uintptr(int64(s) + rel.addend)
+			}
+		case relocPC32:
+			sym, ok := dynSymbolByIndex(dynSyms, rel.symbol)
+			if !ok {
+				t.Fatalf("%s@%#x: invalid symbol index %d", rel.section, rel.offset, rel.symbol)
+			}
+			s := expectedSymbolValue(t, api, module, sym)
+			if got := readU32(place); got != uint32(int64(s)+rel.addend-int64(place)) {
+				t.Fatalf("%s %s@%#x (%s): got %#x want %#x", kind, rel.section, rel.offset, sym.Name, got, uint32(int64(s)+rel.addend-int64(place)))
+			}
+			continue
+		default:
+			continue
+		}
+
+		var got uintptr
+		if wordSize == 8 {
+			got = uintptr(readU64(place))
+		} else {
+			got = uintptr(readU32(place))
+		}
+		if got != want {
+			t.Fatalf("%s %s@%#x: got %#x want %#x", kind, rel.section, rel.offset, got, want)
+		}
+	}
+}
+
+func expectedSymbolValue(t *testing.T, api *linuxDynAPI, module *Module, sym elf.Symbol) uintptr {
+	t.Helper()
+
+	if sym.Section != elf.SHN_UNDEF {
+		return module.loadBias + uintptr(sym.Value)
+	}
+	name := sym.Name
+	if at := strings.IndexByte(name, '@'); at > 0 {
+		name = name[:at]
+	}
+	if name == "__tls_get_addr" && module.tls != nil {
+		return module.tls.getAddr()
+	}
+	addr, err := resolveWithDLSym(api, name)
+	if err != nil {
+		// Undefined weak symbols nothing defines are zero.
+		if elf.ST_BIND(sym.Info) == elf.STB_WEAK {
+			return 0
+		}
+		t.Fatalf("dlsym(%s): %v", name, err)
+	}
+	return addr
+}
+
+func callFixtureExport(t *testing.T, module *Module, name string, args ...uintptr) uintptr {
+	t.Helper()
+
+	addr, err := module.ProcAddressByName(name)
+	if err != nil {
+		t.Fatalf("ProcAddressByName(%s): %v", name, err)
+	}
+	switch len(args) {
+	case 0:
+		return cCall0(addr)
+	case 1:
+		return cCall1(addr, args[0])
+	default:
+		t.Fatalf("callFixtureExport(%s): unsupported argument count %d", name, len(args))
+		return 0
+	}
+}
+
+// checkTLSCounter calls an export that increments a TLS variable and returns
+// it, twice on one thread and then on a new one, which must start from its
+// own copy of the initial value.
+func checkTLSCounter(t *testing.T, module *Module, name string, first int32) {
+	t.Helper()
+
+	call := func() (int32, error) {
+		result, err := module.CallExportArgs(name)
+		return int32(result.Value), err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for want := first; want < first+2; want++ {
+		got, err := call()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("%s() = %d, want %d", name, got, want)
+		}
+	}
+
+	type outcome struct {
+		got int32
+		err error
+	}
+	done := make(chan outcome)
+	go func() {
+		// Stay locked so the thread exits with the goroutine and its block
+		// is released.
+		runtime.LockOSThread()
+		got, err := call()
+		done <- outcome{got, err}
+	}()
+	if out := <-done; out.err != nil || out.got != first {
+		t.Fatalf("%s() on a new thread = %d, %v; want %d", name, out.got, out.err, first)
+	}
+}
+
+// Linkers resolve GOT-relative relocations themselves, so no fixture build
+// leaves them in .rela.dyn; the test applies them to a synthetic image.
+func TestGOTRelativeRelocations_Linux(t *testing.T) {
+	image, err := unix.Mmap(-1, 0, 2*os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		t.Fatalf("map image: %v", err)
+	}
+	defer unix.Munmap(image)
+	mapped := mappedELF{mapping: image, loadBias: uintptr(unsafe.Pointer(&image[0]))}
+	dynSyms := []elf.Symbol{
+		{Name: "first", Section: 1, Value: 0x800},
+		{Name: "second", Section: 1, Value: 0x900},
+	}
+	entries := []relocEntry{
+		{offset: 0x10, symIndex: 1, relocType: uint32(elf.R_X86_64_GOTPCREL), addend: -4, hasAddend: true},
+		{offset: 0x20, symIndex: 1, relocType: uint32(elf.R_X86_64_REX_GOTPCRELX), addend: -4, hasAddend: true},
+		{offset: 0x30, symIndex: 2, relocType: uint32(elf.R_X86_64_GOTPCRELX), addend: -4, hasAddend: true},
+		{offset: 0x40, symIndex: 2, relocType: uint32(elf.R_X86_64_GOTPCREL64), hasAddend: true},
+	}
+	got, err := newGOTTable(image, len(entries), 0)
+	if err != nil {
+		t.Fatalf("newGOTTable: %v", err)
+	}
+	defer got.free()
+	resolver := &symbolResolver{got: got}
+	for i, entry := range entries {
+		if err := applyOneRelocation(elf.EM_X86_64, elf.ELFCLASS64, mapped, dynSyms, resolver, entry); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+	}
+	if err := got.seal(); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+
+	// slotAt follows the displacement entry stored to its GOT entry.
+	slotAt := func(entry relocEntry) uintptr {
+		place := mapped.loadBias + uintptr(entry.offset)
+		disp := int64(int32(readU32(place)))
+		if elf.R_X86_64(entry.relocType) == elf.R_X86_64_GOTPCREL64 {
+			disp = int64(readU64(place))
+		}
+		return uintptr(int64(place) + disp - entry.addend)
+	}
+	for i, entry := range entries {
+		want := mapped.loadBias + uintptr(dynSyms[entry.symIndex-1].Value)
+		if got := uintptr(readU64(slotAt(entry))); got != want {
+			t.Fatalf("GOT entry of relocation %d holds %#x, want %#x", i, got, want)
+		}
+	}
+	if slotAt(entries[0]) != slotAt(entries[1]) || slotAt(entries[2]) != slotAt(entries[3]) {
+		t.Fatal("relocations against one symbol got separate GOT entries")
+	}
+}
+
+func TestGOTTableReleasesMapping_Linux(t *testing.T) {
+	image, err := unix.Mmap(-1, 0, os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		t.Fatalf("map image: %v", err)
+	}
+	defer unix.Munmap(image)
+
+	before := MappedBytes()
+	if _, err := newGOTTable(image, 1, before+1); !errors.Is(err, ErrMappingBudget) {
+		t.Fatalf("newGOTTable over budget = %v, want ErrMappingBudget", err)
+	}
+	got, err := newGOTTable(image, 1, 0)
+	if err != nil {
+		t.Fatalf("newGOTTable: %v", err)
+	}
+	mapping := got.mapping
+	if MappedBytes() != before+uint64(len(mapping)) {
+		t.Fatalf("MappedBytes with a GOT = %#x, want %#x", MappedBytes(), before+uint64(len(mapping)))
+	}
+	got.free()
+	if MappedBytes() != before {
+		t.Fatalf("MappedBytes after free = %#x, want %#x", MappedBytes(), before)
+	}
+	// msync fails with ENOMEM for pages that are not mapped.
+	if err := unix.Msync(mapping, unix.MS_ASYNC); !errors.Is(err, unix.ENOMEM) {
+		t.Fatalf("msync on the freed GOT = %v, want ENOMEM", err)
+	}
+}
@@ -0,0 +1,452 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"crypto/rand"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Static-PIE executables carry their own startup code, which applies the
+// image's relocations, sets up libc and TLS, and runs main. The loader only
+// maps them; StartEntry then runs the entry point on a fresh native thread
+// with a kernel-style initial stack.
+
+const (
+	entryStackBytes = 8 << 20
+	// entryTCBBytes covers the tcbhead_t fields compilers address directly
+	// through the thread pointer, such as the x86-64 stack guard at %fs:0x28.
+	entryTCBBytes = 0x100
+
+	entryCloneFlags = unix.CLONE_VM | unix.CLONE_FS | unix.CLONE_FILES | unix.CLONE_SIGHAND |
+		unix.CLONE_THREAD | unix.CLONE_SYSVSEM | unix.CLONE_PARENT_SETTID
+
+	atPHDR   = 3
+	atPHENT  = 4
+	atPHNUM  = 5
+	atPAGESZ = 6
+	atBASE   = 7
+	atENTRY  = 9
+	atRANDOM = 25
+	atEXECFN = 31
+)
+
+// staticPIEImage describes what StartEntry needs about a static-PIE image.
+type staticPIEImage struct {
+	entry uintptr
+	phdr  uintptr
+	phent uintptr
+	phnum uintptr
+	// tls is the PT_TLS header with Vaddr already relocated, or nil.
+	tls *elf.ProgHeader
+}
+
+// isStaticPIE reports whether f is a position-independent executable with no
+// interpreter and no shared-library dependencies.
+func isStaticPIE(f *elf.File) bool {
+	if f.Type != elf.ET_DYN || f.Entry == 0 {
+		return false
+	}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			return false
+		}
+	}
+	if libs, err := f.ImportedLibraries(); err != nil || len(libs) != 0 {
+		return false
+	}
+	flags, err := f.DynValue(elf.DT_FLAGS_1)
+	if err != nil {
+		return false
+	}
+	for _, v := range flags {
+		if elf.DynFlag1(v)&elf.DF_1_PIE != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// loadStaticPIE maps a static-PIE executable without relocating it or running
+// its initializers; its own startup code does both.
+func loadStaticPIE(raw []byte, f *elf.File, opts LoadOptions) (*Module, error) {
+	mapped, err := mapELFImage(raw, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	image, err := describeStaticPIE(raw, f, mapped)
+	if err == nil {
+		err = applySegmentProtections(mapped)
+	}
+	var symbols map[string]Symbol
+	if err == nil {
+		// Indirect function resolvers in a static executable depend on state
+		// its own startup code sets up, so they are not called here.
+		symbols, err = buildExportedSymbolTable(f, mapped.loadBias, nil, opts.Symbols)
+	}
+	if err != nil {
+		unmapImage(mapped.mapping)
+		return nil, err
+	}
+
+	return &Module{
+		mapping:   mapped.mapping,
+		loadBias:  mapped.loadBias,
+		symbols:   symbols,
+		segments:  mapped.segments(),
+		staticPIE: image,
+	}, nil
+}
+
+func describeStaticPIE(raw []byte, f *elf.File, mapped mappedELF) (*staticPIEImage, error) {
+	var phoff uint64
+	image := &staticPIEImage{
+		entry: mapped.loadBias + uintptr(f.Entry),
+		phnum: uintptr(len(f.Progs)),
+	}
+	switch f.Class {
+	case elf.ELFCLASS64:
+		if len(raw) < 64 {
+			return nil, errors.New("truncated ELF header")
+		}
+		phoff = binary.LittleEndian.Uint64(raw[32:])
+		image.phent = uintptr(binary.LittleEndian.Uint16(raw[54:]))
+	default:
+		if len(raw) < 52 {
+			return nil, errors.New("truncated ELF header")
+		}
+		phoff = uint64(binary.LittleEndian.Uint32(raw[28:]))
+		image.phent = uintptr(binary.LittleEndian.Uint16(raw[42:]))
+	}
+
+	for _, p := range f.Progs {
+		switch p.Type {
+		case elf.PT_PHDR:
+			image.phdr = mapped.loadBias + uintptr(p.Vaddr)
+		case elf.PT_TLS:
+			tls := p.ProgHeader
+			tls.Vaddr += uint64(mapped.loadBias)
+			image.tls = &tls
+		case elf.PT_LOAD:
+			if image.phdr == 0 && phoff >= p.Off && phoff < p.Off+p.Filesz {
+				image.phdr = mapped.loadBias + uintptr(p.Vaddr+(phoff-p.Off))
+			}
+		}
+	}
+	if image.phdr == 0 {
+		return nil, errors.New("program headers are not mapped by any PT_LOAD segment")
+	}
+	return image, nil
+}
+
+// StartEntry runs a static-PIE executable's entry point on a new native thread
+// with argv, the current environment, and an auxiliary vector describing the
+// image. argv[0] defaults to the host's program name. The returned wait
+// function blocks until that thread exits and must be called exactly once;
+// Free blocks until then.
+//
+// Startup code that returns from main calls exit, which terminates the whole
+// process. Payloads that should leave the host running must end their thread
+// with the exit system call instead.
+func (module *Module) StartEntry(argv []string) (func(), error) {
+	module.mu.RLock()
+	if module.closed {
+		module.mu.RUnlock()
+		return nil, errors.New("library is closed")
+	}
+	if module.staticPIE == nil {
+		module.mu.RUnlock()
+		return nil, errors.New("image has no entry point to start; only static-PIE executables are supported")
+	}
+
+	thread, err := startEntryThread(module.staticPIE, argv)
+	if err != nil {
+		module.mu.RUnlock()
+		return nil, fmt.Errorf("start entry: %w", err)
+	}
+	return func() {
+		defer module.mu.RUnlock()
+		thread.wait()
+		thread.release()
+	}, nil
+}
+
+// entryThread owns the memory handed to a raw entry thread.
+type entryThread struct {
+	// tid is set by the kernel before clone returns. Startup code replaces
+	// the clear-child-tid address with its own, so exit is detected by
+	// probing the thread rather than with a futex.
+	tid   int32
+	stack []byte
+	tls   []byte
+}
+
+func startEntryThread(image *staticPIEImage, argv []string) (*entryThread, error) {
+	if len(argv) == 0 {
+		argv = []string{os.Args[0]}
+	}
+
+	stack, err := unix.Mmap(-1, 0, entryStackBytes, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON|unix.MAP_STACK)
+	if err != nil {
+		return nil, fmt.Errorf("mmap entry stack: %w", err)
+	}
+	thread := &entryThread{stack: stack}
+	// Leave a guard page below the stack.
+	if err := unix.Mprotect(stack[:unix.Getpagesize()], unix.PROT_NONE); err != nil {
+		thread.release()
+		return nil, fmt.Errorf("mprotect entry stack guard: %w", err)
+	}
+
+	var tp uintptr
+	if image.tls != nil {
+		tp, err = thread.setupTLS(image.tls)
+		if err != nil {
+			thread.release()
+			return nil, err
+		}
+	}
+	sp, err := thread.buildStack(image, argv)
+	if err != nil {
+		thread.release()
+		return nil, err
+	}
+
+	flags := uintptr(entryCloneFlags)
+	if tp != 0 {
+		flags |= unix.CLONE_SETTLS
+	}
+
+	// The new thread inherits this thread's signal mask. Block everything so
+	// the Go runtime's handlers never run on a thread it does not know about;
+	// the payload's startup code can unblock what it needs.
+	runtime.LockOSThread()
+	var all, old unix.Sigset_t
+	for i := range all.Val {
+		all.Val[i] = ^all.Val[i]
+	}
+	_ = unix.PthreadSigmask(unix.SIG_SETMASK, &all, &old)
+	ret := rawClone(flags, sp, &thread.tid, tp, image.entry)
+	_ = unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+	runtime.UnlockOSThread()
+
+	if ret < 0 {
+		thread.release()
+		return nil, fmt.Errorf("clone: %w", unix.Errno(-ret))
+	}
+	return thread, nil
+}
+
+// setupTLS builds the initial TLS block and thread control block from PT_TLS
+// and returns the thread pointer. On 386 the payload's startup code must set
+// up TLS itself, because CLONE_SETTLS needs a GDT entry there.
+func (thread *entryThread) setupTLS(tls *elf.ProgHeader) (uintptr, error) {
+	if runtime.GOARCH == "386" {
+		return 0, nil
+	}
+
+	align := uintptr(tls.Align)
+	if align < 16 {
+		align = 16
+	}
+	memsz := uintptr(tls.Memsz)
+	blockSize := alignUpUintptr(memsz, align)
+	tcbSize := alignUpUintptr(16, align)
+	size := blockSize + tcbSize + entryTCBBytes + align
+
+	region, err := unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return 0, fmt.Errorf("mmap TLS block: %w", err)
+	}
+	thread.tls = region
+	base := alignUpUintptr(uintptr(unsafe.Pointer(&region[0])), align)
+
+	var block, tp uintptr
+	switch runtime.GOARCH {
+	case "amd64":
+		// Variant II: the block sits below the thread pointer, which points
+		// at a self-referencing TCB.
+		block = base
+		tp = base + blockSize
+		*(*uintptr)(unsafe.Pointer(tp)) = tp
+		*(*uintptr)(unsafe.Pointer(tp + 16)) = tp
+		var guard [8]byte
+		if _, err := rand.Read(guard[1:]); err == nil {
+			*(*uint64)(unsafe.Pointer(tp + 0x28)) = binary.LittleEndian.Uint64(guard[:])
+		}
+	case "arm64":
+		// Variant I: the thread pointer points at a 16-byte TCB followed by
+		// the block.
+		tp = base
+		block = base + tcbSize
+	}
+
+	if tls.Filesz > 0 {
+		src := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(tls.Vaddr))), tls.Filesz)
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(block)), memsz), src)
+	}
+	return tp, nil
+}
+
+// buildStack lays out argc, argv, envp, and auxv at the top of the thread's
+// stack the way the kernel does for a new process and returns the initial
+// stack pointer.
+func (thread *entryThread) buildStack(image *staticPIEImage, argv []string) (uintptr, error) {
+	stack := thread.stack
+	base := uintptr(unsafe.Pointer(&stack[0]))
+	top := len(stack)
+
+	pushBytes := func(b []byte) uintptr {
+		top -= len(b)
+		copy(stack[top:], b)
+		return base + uintptr(top)
+	}
+	pushString := func(s string) uintptr {
+		top--
+		stack[top] = 0
+		return pushBytes([]byte(s))
+	}
+
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return 0, fmt.Errorf("read AT_RANDOM bytes: %w", err)
+	}
+	randomPtr := pushBytes(random[:])
+
+	argvPtrs := make([]uintptr, 0, len(argv))
+	for _, arg := range argv {
+		argvPtrs = append(argvPtrs, pushString(arg))
+	}
+	env := os.Environ()
+	envPtrs := make([]uintptr, 0, len(env))
+	for _, kv := range env {
+		envPtrs = append(envPtrs, pushString(kv))
+	}
+
+	auxv := entryAuxv(image, randomPtr, argvPtrs[0])
+
+	words := make([]uintptr, 0, 3+len(argvPtrs)+len(envPtrs)+len(auxv))
+	words = append(words, uintptr(len(argvPtrs)))
+	words = append(words, argvPtrs...)
+	words = append(words, 0)
+	words = append(words, envPtrs...)
+	words = append(words, 0)
+	words = append(words, auxv...)
+
+	wordSize := int(unsafe.Sizeof(uintptr(0)))
+	top -= len(words) * wordSize
+	top &^= 15
+	if top < unix.Getpagesize() {
+		return 0, errors.New("arguments and environment do not fit on the entry stack")
+	}
+	sp := base + uintptr(top)
+	for i, word := range words {
+		*(*uintptr)(unsafe.Pointer(sp + uintptr(i*wordSize))) = word
+	}
+	return sp, nil
+}
+
+// entryAuxv returns the host's auxiliary vector with the entries that
+// describe the executable replaced, terminated by AT_NULL.
+func entryAuxv(image *staticPIEImage, random uintptr, execfn uintptr) []uintptr {
+	overrides := map[uintptr]uintptr{
+		atPHDR:   image.phdr,
+		atPHENT:  image.phent,
+		atPHNUM:  image.phnum,
+		atBASE:   0,
+		atENTRY:  image.entry,
+		atRANDOM: random,
+		atEXECFN: execfn,
+	}
+
+	var auxv []uintptr
+	for _, pair := range hostAuxv() {
+		if value, ok := overrides[pair[0]]; ok {
+			auxv = append(auxv, pair[0], value)
+			delete(overrides, pair[0])
+			continue
+		}
+		auxv = append(auxv, pair[0], pair[1])
+	}
+	if _, ok := overrides[atPAGESZ]; !ok && !hasAuxvEntry(auxv, atPAGESZ) {
+		auxv = append(auxv, atPAGESZ, uintptr(unix.Getpagesize()))
+	}
+	for _, tag := range []uintptr{atPHDR, atPHENT, atPHNUM, atBASE, atENTRY, atRANDOM, atEXECFN} {
+		if value, ok := overrides[tag]; ok {
+			auxv = append(auxv, tag, value)
+		}
+	}
+	return append(auxv, 0, 0)
+}
+
+func hasAuxvEntry(auxv []uintptr, tag uintptr) bool {
+	for i := 0; i+1 < len(auxv); i += 2 {
+		if auxv[i] == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAuxv returns the process's auxiliary vector without the AT_NULL
+// terminator, or nil if it cannot be read.
+func hostAuxv() [][2]uintptr {
+	raw, err := os.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return nil
+	}
+	wordSize := int(unsafe.Sizeof(uintptr(0)))
+	word := func(b []byte) uintptr {
+		if wordSize == 8 {
+			return uintptr(binary.LittleEndian.Uint64(b))
+		}
+		return uintptr(binary.LittleEndian.Uint32(b))
+	}
+
+	var out [][2]uintptr
+	for off := 0; off+2*wordSize <= len(raw); off += 2 * wordSize {
+		tag := word(raw[off:])
+		if tag == 0 {
+			break
+		}
+		out = append(out, [2]uintptr{tag, word(raw[off+wordSize:])})
+	}
+	return out
+}
+
+// wait blocks until the thread exits.
+func (thread *entryThread) wait() {
+	pid := unix.Getpid()
+	tid := int(atomic.LoadInt32(&thread.tid))
+	delay := time.Millisecond
+	for unix.Tgkill(pid, tid, 0) != unix.ESRCH {
+		time.Sleep(delay)
+		if delay < 50*time.Millisecond {
+			delay *= 2
+		}
+	}
+}
+
+func (thread *entryThread) release() {
+	if thread.stack != nil {
+		_ = unix.Munmap(thread.stack)
+		thread.stack = nil
+	}
+	if thread.tls != nil {
+		_ = unix.Munmap(thread.tls)
+		thread.tls = nil
+	}
+}
+
+func alignUpUintptr(v, a uintptr) uintptr {
+	return (v + a - 1) &^ (a - 1)
+}
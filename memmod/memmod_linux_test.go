@@ -4,12 +4,20 @@ package memmod
 
 import (
 	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestLoadLibraryAndCallExport_Linux(t *testing.T) {
@@ -63,27 +71,40 @@ func TestLoadLibraryAndCallExport_Linux(t *testing.T) {
 
 func buildLinuxTestSO(t *testing.T, output string) {
 	t.Helper()
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "basic.c"), output)
+}
+
+func buildLinuxTestSOFrom(t *testing.T, source string, output string, extraFlags ...string) {
+	t.Helper()
+
+	args := []string{"-shared", "-fPIC", "-O2", "-g0"}
+	args = append(args, extraFlags...)
+	if err := runLinuxZigCC(linuxZigTarget(t, "gnu"), output, source, args...); err != nil {
+		t.Fatalf("build linux test shared object: %v", err)
+	}
+}
+
+func linuxZigTarget(t *testing.T, abi string) string {
+	t.Helper()
 
-	var zigTarget string
 	switch runtime.GOARCH {
 	case "386":
-		zigTarget = "x86-linux-gnu"
+		return "x86-linux-" + abi
 	case "amd64":
-		zigTarget = "x86_64-linux-gnu"
+		return "x86_64-linux-" + abi
 	case "arm64":
-		zigTarget = "aarch64-linux-gnu"
+		return "aarch64-linux-" + abi
 	default:
 		t.Fatalf("unsupported GOARCH for linux test: %s", runtime.GOARCH)
+		return ""
 	}
+}
 
-	source := filepath.Join("..", "testdata", "c", "basic.c")
-	cmd := exec.Command("zig", "cc",
-		"-target", zigTarget,
-		"-shared", "-fPIC",
-		"-O2", "-g0",
-		"-o", output,
-		source,
-	)
+func runLinuxZigCC(target string, output string, source string, flags ...string) error {
+	args := []string{"cc", "-target", target}
+	args = append(args, flags...)
+	args = append(args, "-o", output, source)
+	cmd := exec.Command("zig", args...)
 	cmd.Env = append(
 		os.Environ(),
 		"ZIG_GLOBAL_CACHE_DIR="+filepath.Join(os.TempDir(), "reflektor-zig-global-cache"),
@@ -91,6 +112,766 @@ func buildLinuxTestSO(t *testing.T, output string) {
 	)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		t.Fatalf("build linux test shared object: %v\n%s", err, out)
+		return fmt.Errorf("%w\n%s", err, out)
+	}
+	return nil
+}
+
+func TestSignalStateRestoresPayloadHandlers_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("signals_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "signals.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	state, err := SaveSignalState()
+	if err != nil {
+		t.Fatalf("SaveSignalState: %v", err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+	if err := module.CallExport("StartW"); err != nil {
+		t.Fatalf("CallExport(StartW): %v", err)
+	}
+
+	for _, sig := range []unix.Signal{unix.SIGPIPE, unix.SIGUSR2} {
+		var current kernelSigaction
+		if err := rtSigaction(int(sig), nil, &current); err != nil {
+			t.Fatalf("rt_sigaction(%v): %v", sig, err)
+		}
+		if current.handler == state.actions[sig].handler {
+			t.Fatalf("payload did not replace the %v handler", sig)
+		}
+	}
+
+	if err := state.Restore(); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for _, sig := range []unix.Signal{unix.SIGPIPE, unix.SIGUSR2} {
+		var current kernelSigaction
+		if err := rtSigaction(int(sig), nil, &current); err != nil {
+			t.Fatalf("rt_sigaction(%v): %v", sig, err)
+		}
+		if current != state.actions[sig] {
+			t.Fatalf("%v handler not restored: got=%+v want=%+v", sig, current, state.actions[sig])
+		}
+	}
+}
+
+var fpDividend, fpDivisor = 1.0, 10.0
+
+func TestCallExportResultErrnoAndFPEnv_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("callresult_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "callresult.c"), soPath, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	result, err := module.CallExportResult("reflektor_set_errno")
+	if err != nil {
+		t.Fatalf("CallExportResult(reflektor_set_errno): %v", err)
+	}
+	if int32(result.Value) != 42 || result.Errno != unix.ENOENT {
+		t.Fatalf("unexpected result: value=%d errno=%v", int32(result.Value), result.Errno)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	result, err = module.CallExportResult("reflektor_round_down")
+	if err != nil {
+		t.Fatalf("CallExportResult(reflektor_round_down): %v", err)
+	}
+	if int32(result.Value) != 0 {
+		t.Fatalf("fesetround failed: %d", int32(result.Value))
+	}
+	// 1/10 rounds up under round-to-nearest, so a leaked FE_DOWNWARD changes
+	// the quotient.
+	if got := fpDividend / fpDivisor; got != 0.1 {
+		t.Fatalf("floating-point environment leaked from payload: 1/10 = %v", got)
+	}
+}
+
+func TestCallThreadPthread_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	if !pthreadCallsSupported {
+		t.Skip("CallThreadPthread requires cgo")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("threadaffine_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "threadaffine.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	self := uintptr(unix.Gettid())
+
+	threadOf := func(module *Module, name string) uintptr {
+		t.Helper()
+		result, err := module.CallExportResult(name)
+		if err != nil {
+			t.Fatalf("CallExportResult(%s): %v", name, err)
+		}
+		return result.Value
+	}
+
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{CallThread: CallThreadPthread})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions(CallThreadPthread): %v", err)
+	}
+	defer module.Free()
+	if tid := threadOf(module, "reflektor_constructor_thread"); tid == 0 || tid == self {
+		t.Fatalf("constructor ran on thread %d, want a pthread other than the caller %d", tid, self)
+	}
+	if tid := threadOf(module, "reflektor_thread"); tid == self {
+		t.Fatalf("export ran on the calling thread %d", self)
+	}
+
+	caller, err := LoadLibraryWithOptions(payload, LoadOptions{CallThread: CallThreadCaller})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions(CallThreadCaller): %v", err)
+	}
+	defer caller.Free()
+	if tid := threadOf(caller, "reflektor_constructor_thread"); tid != self {
+		t.Fatalf("constructor ran on thread %d, want the caller %d", tid, self)
+	}
+	if tid := threadOf(caller, "reflektor_thread"); tid != self {
+		t.Fatalf("export ran on thread %d, want the caller %d", tid, self)
+	}
+}
+
+func TestRunFinalizers_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("finalizers_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "finalizers.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts LoadOptions
+		want int32
+	}{
+		{name: "default", want: 0},
+		{name: "run", opts: LoadOptions{RunFinalizers: true}, want: 3},
+		{name: "skip initializers", opts: LoadOptions{RunFinalizers: true, SkipInitializers: true}, want: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			module, err := LoadLibraryWithOptions(payload, tc.opts)
+			if err != nil {
+				t.Fatalf("LoadLibraryWithOptions: %v", err)
+			}
+			flags := new(int32)
+			if _, err := module.CallExportArgs("reflektor_watch", uintptr(unsafe.Pointer(flags))); err != nil {
+				module.Free()
+				t.Fatalf("CallExportArgs(reflektor_watch): %v", err)
+			}
+			module.Free()
+			if *flags != tc.want {
+				t.Fatalf("teardown flags = %#x, want %#x", *flags, tc.want)
+			}
+		})
+	}
+}
+
+func TestHostShimsWithoutLibc_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	if _, err := getLinuxDynAPI(); err == nil {
+		t.Skip("host libc is available; shims only bind in static processes (run with CGO_ENABLED=0)")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("hostshim_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "hostshim.c"), soPath, "-fno-stack-protector")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	t.Setenv("REFLEKTOR_HOSTSHIM", "ok")
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	result, err := module.CallExportResult("hostshim_run")
+	if err != nil {
+		t.Fatalf("CallExportResult(hostshim_run): %v", err)
+	}
+	if int32(result.Value) != 0 {
+		t.Fatalf("hostshim_run failed at step %d", int32(result.Value))
+	}
+	if result.Errno != unix.EBADF {
+		t.Fatalf("unexpected errno: got=%v want=%v", result.Errno, unix.EBADF)
+	}
+}
+
+// The shims are bound once per process, so the environment getenv searches
+// must follow os.Environ rather than the first payload's snapshot.
+func TestHostShimsSeeEnvironmentChanges_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	if _, err := getLinuxDynAPI(); err == nil {
+		t.Skip("host libc is available; shims only bind in static processes (run with CGO_ENABLED=0)")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("hostshim_env_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "hostshim.c"), soPath, "-fno-stack-protector")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	arena := getArena()
+	defer arena.release()
+	name, err := arena.cString("REFLEKTOR_HOSTSHIM_ENV")
+	if err != nil {
+		t.Fatalf("build C string: %v", err)
+	}
+	getenv := func(module *Module) string {
+		t.Helper()
+		result, err := module.CallExportArgs("hostshim_getenv", name)
+		if err != nil {
+			t.Fatalf("CallExportArgs(hostshim_getenv): %v", err)
+		}
+		if result.Value == 0 {
+			return ""
+		}
+		return cStringFromPtr(result.Value)
+	}
+
+	t.Setenv("REFLEKTOR_HOSTSHIM_ENV", "first")
+	first, err := LoadLibrary(bytes.Clone(payload))
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer first.Free()
+	if got := getenv(first); got != "first" {
+		t.Fatalf("first payload getenv = %q, want %q", got, "first")
+	}
+
+	t.Setenv("REFLEKTOR_HOSTSHIM_ENV", "second")
+	second, err := LoadLibrary(bytes.Clone(payload))
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer second.Free()
+	if got := getenv(second); got != "second" {
+		t.Fatalf("second payload getenv = %q, want %q", got, "second")
+	}
+	if got := getenv(first); got != "second" {
+		t.Fatalf("first payload getenv after the change = %q, want %q", got, "second")
+	}
+}
+
+func TestStartEntryStaticPIE_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	if runtime.GOARCH == "386" {
+		t.Skip("static-PIE TLS setup is left to the payload on 386")
+	}
+
+	dir := t.TempDir()
+	exePath := filepath.Join(dir, fmt.Sprintf("staticpie_linux-%s", runtime.GOARCH))
+	source := filepath.Join("..", "testdata", "c", "staticpie.c")
+	if err := runLinuxZigCC(linuxZigTarget(t, "musl"), exePath, source, "-static-pie", "-fPIE", "-O2", "-g0"); err != nil {
+		t.Skipf("toolchain cannot build static-PIE executables: %v", err)
+	}
+	payload, err := os.ReadFile(exePath)
+	if err != nil {
+		t.Fatalf("read %s: %v", exePath, err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	t.Setenv("REFLEKTOR_STATICPIE", "env")
+	markerPath := filepath.Join(dir, "marker.txt")
+	wait, err := module.StartEntry([]string{"staticpie", markerPath})
+	if err != nil {
+		t.Fatalf("StartEntry: %v", err)
+	}
+	wait()
+
+	got, err := os.ReadFile(markerPath)
+	if err != nil {
+		t.Fatalf("read marker: %v", err)
+	}
+	if want := "ok 2 42 env"; string(got) != want {
+		t.Fatalf("unexpected marker content: got=%q want=%q", got, want)
+	}
+}
+
+func TestLazyCommitLeavesSegmentGapsInaccessible_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	tmp := t.TempDir()
+	soPath := filepath.Join(tmp, fmt.Sprintf("basic_gaps_linux-%s.so", runtime.GOARCH))
+	// A large max-page-size leaves unmapped gaps between PT_LOAD segments.
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "basic.c"), soPath, "-Wl,-z,max-page-size=0x10000")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read built shared library: %v", err)
+	}
+
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{LazyCommit: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	t.Cleanup(module.Free)
+
+	gap := module.loadBias + uintptr(unix.Getpagesize())
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatalf("read /proc/self/maps: %v", err)
+	}
+	var perms string
+	for _, line := range strings.Split(string(maps), "\n") {
+		var start, end uintptr
+		var p string
+		if _, err := fmt.Sscanf(line, "%x-%x %s", &start, &end, &p); err == nil && gap >= start && gap < end {
+			perms = p
+			break
+		}
+	}
+	if perms != "---p" {
+		t.Fatalf("gap page %#x has permissions %q, want ---p", gap, perms)
+	}
+
+	marker := filepath.Join(tmp, "lazy_marker.txt")
+	t.Setenv("REFLEKTOR_MARKER", marker)
+	if err := module.CallExport("StartW"); err != nil {
+		t.Fatalf("CallExport(StartW): %v", err)
+	}
+	if got, err := os.ReadFile(marker); err != nil || string(got) != "ok" {
+		t.Fatalf("unexpected marker: %q, %v", got, err)
+	}
+}
+
+// TestCheckpointFriendlyMappings_Linux checks that an image is mapped only
+// privately, which checkpoint/restore tools dump as ordinary memory, and that
+// loading and calling still work after ResetHostSymbols drops the cached host
+// symbols, as after a restore.
+func TestCheckpointFriendlyMappings_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	tmp := t.TempDir()
+	soPath := filepath.Join(tmp, fmt.Sprintf("basic_checkpoint_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSO(t, soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read built shared library: %v", err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	t.Cleanup(module.Free)
+
+	first := uintptr(unsafe.Pointer(&module.mapping[0]))
+	last := first + uintptr(len(module.mapping))
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatalf("read /proc/self/maps: %v", err)
+	}
+	for _, line := range strings.Split(string(maps), "\n") {
+		var start, end uintptr
+		var perms string
+		if _, err := fmt.Sscanf(line, "%x-%x %s", &start, &end, &perms); err != nil || end <= first || start >= last {
+			continue
+		}
+		if !strings.HasSuffix(perms, "p") {
+			t.Fatalf("image mapping %q is not private", line)
+		}
+	}
+
+	ResetHostSymbols()
+	reloaded, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary after ResetHostSymbols: %v", err)
+	}
+	t.Cleanup(reloaded.Free)
+	marker := filepath.Join(tmp, "checkpoint_marker.txt")
+	t.Setenv("REFLEKTOR_MARKER", marker)
+	if err := reloaded.CallExport("StartW"); err != nil {
+		t.Fatalf("CallExport(StartW): %v", err)
+	}
+	if got, err := os.ReadFile(marker); err != nil || string(got) != "ok" {
+		t.Fatalf("unexpected marker: %q, %v", got, err)
+	}
+}
+
+func TestLoadLibraryRejectsOverlappingSegments_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	tmp := t.TempDir()
+	soPath := filepath.Join(tmp, fmt.Sprintf("basic_overlap_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSO(t, soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read built shared library: %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("parse built shared library: %v", err)
+	}
+
+	// Move the second PT_LOAD onto the first, keeping vaddr congruent with
+	// its file offset so only the overlap is wrong.
+	var loads []int
+	for i, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			loads = append(loads, i)
+		}
+	}
+	if len(loads) < 2 {
+		t.Skipf("shared library has %d PT_LOAD segments", len(loads))
+	}
+	first, second := f.Progs[loads[0]], f.Progs[loads[1]]
+	vaddr := alignDown64(first.Vaddr, second.Align) + second.Off%second.Align
+	if vaddr >= first.Vaddr+first.Memsz {
+		t.Skip("first PT_LOAD too small to overlap")
+	}
+
+	var phoff, phentsize, vaddrField uint64
+	if f.Class == elf.ELFCLASS64 {
+		phoff, phentsize, vaddrField = binary.LittleEndian.Uint64(payload[0x20:]), uint64(binary.LittleEndian.Uint16(payload[0x36:])), 16
+		binary.LittleEndian.PutUint64(payload[phoff+uint64(loads[1])*phentsize+vaddrField:], vaddr)
+	} else {
+		phoff, phentsize, vaddrField = uint64(binary.LittleEndian.Uint32(payload[0x1c:])), uint64(binary.LittleEndian.Uint16(payload[0x2a:])), 8
+		binary.LittleEndian.PutUint32(payload[phoff+uint64(loads[1])*phentsize+vaddrField:], uint32(vaddr))
+	}
+
+	module, err := LoadLibrary(payload)
+	if err == nil {
+		module.Free()
+		t.Fatal("LoadLibrary accepted overlapping PT_LOAD segments")
+	}
+	var layoutErr *LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Problem != LayoutOverlap {
+		t.Fatalf("LoadLibrary = %v, want overlapping-segment *LayoutError", err)
+	}
+	if err := ValidateImage(payload); !errors.As(err, &layoutErr) {
+		t.Fatalf("ValidateImage = %v, want *LayoutError", err)
+	}
+}
+
+func TestIFuncExportsResolveToImplementation_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("ifunc_export_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "ifunc_export.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	target, err := module.CallExportResult("reflektor_ifunc_target")
+	if err != nil {
+		t.Fatalf("CallExportResult(reflektor_ifunc_target): %v", err)
+	}
+	addr, err := module.ProcAddressByName("reflektor_ifunc")
+	if err != nil {
+		t.Fatalf("ProcAddressByName(reflektor_ifunc): %v", err)
+	}
+	if addr != target.Value {
+		t.Fatalf("ProcAddressByName(reflektor_ifunc) = %#x, want implementation %#x", addr, target.Value)
+	}
+
+	for _, name := range []string{"reflektor_ifunc", "reflektor_ifunc_call"} {
+		result, err := module.CallExportResult(name)
+		if err != nil {
+			t.Fatalf("CallExportResult(%s): %v", name, err)
+		}
+		if int32(result.Value) != 0x2a {
+			t.Fatalf("%s() = %#x, want 0x2a", name, int32(result.Value))
+		}
+	}
+}
+
+func TestSymbolFilter_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("symbols_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "symbols.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	for _, name := range []string{"reflektor_static_fn", "reflektor_hidden_fn", "reflektor_counter"} {
+		if _, err := module.ProcAddressByName(name); err == nil {
+			t.Errorf("default filter exposes %s", name)
+		}
+	}
+	module.Free()
+
+	module, err = LoadLibraryWithOptions(payload, LoadOptions{Symbols: SymbolsLocal | SymbolsHidden | SymbolsObjects})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer module.Free()
+
+	symbols, err := module.Symbols()
+	if err != nil {
+		t.Fatalf("Symbols: %v", err)
+	}
+	byName := make(map[string]Symbol, len(symbols))
+	for _, sym := range symbols {
+		byName[sym.Name] = sym
+	}
+	for _, want := range []Symbol{
+		{Name: "reflektor_static_fn", Kind: SymbolFunc, Local: true},
+		{Name: "reflektor_hidden_fn", Kind: SymbolFunc, Local: true},
+		{Name: "reflektor_visible_fn", Kind: SymbolFunc},
+		{Name: "reflektor_counter", Kind: SymbolObject, Size: 4},
+	} {
+		got, ok := byName[want.Name]
+		if !ok {
+			t.Fatalf("Symbols is missing %s", want.Name)
+		}
+		if got.Kind != want.Kind || got.Local != want.Local || (want.Size != 0 && got.Size != want.Size) || got.Address == 0 {
+			t.Fatalf("Symbols[%s] = %+v, want %+v", want.Name, got, want)
+		}
+	}
+
+	for name, want := range map[string]int32{"reflektor_static_fn": 7, "reflektor_hidden_fn": 11} {
+		result, err := module.CallExportResult(name)
+		if err != nil {
+			t.Fatalf("CallExportResult(%s): %v", name, err)
+		}
+		if int32(result.Value) != want {
+			t.Fatalf("%s() = %d, want %d", name, int32(result.Value), want)
+		}
+	}
+	if got := *(*int32)(unsafe.Pointer(byName["reflektor_counter"].Address)); got != 0x1234 {
+		t.Fatalf("reflektor_counter = %#x, want 0x1234", got)
+	}
+}
+
+func TestFindSymbolSearchesDependencies_Linux(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("test1_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSO(t, soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	own, err := module.ProcAddressByName("StartW")
+	if err != nil {
+		t.Fatalf("ProcAddressByName(StartW): %v", err)
+	}
+	if got, err := module.FindSymbol("StartW"); err != nil || got != own {
+		t.Fatalf("FindSymbol(StartW) = %#x, %v; want %#x", got, err, own)
+	}
+
+	want, err := newSymbolResolver(nil, LoadOptions{}).Resolve("getenv")
+	if err != nil {
+		t.Fatalf("resolve getenv: %v", err)
+	}
+	if got, err := module.FindSymbol("getenv"); err != nil || got != want {
+		t.Fatalf("FindSymbol(getenv) = %#x, %v; want %#x", got, err, want)
+	}
+	if _, err := module.FindSymbol("reflektor_no_such_symbol"); err == nil {
+		t.Fatal("FindSymbol found a missing symbol")
+	}
+}
+
+func TestMuslMode_Linux(t *testing.T) {
+	alpine := []procMapEntry{
+		{path: "/usr/bin/host"},
+		{path: "/lib/ld-musl-x86_64.so.1"},
+	}
+	if !mapsHaveMusl(alpine) {
+		t.Fatal("mapsHaveMusl missed ld-musl")
+	}
+	if mapsHaveMusl([]procMapEntry{{path: "/usr/lib/x86_64-linux-gnu/libc.so.6"}, {path: "/usr/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2"}}) {
+		t.Fatal("mapsHaveMusl reported musl for glibc")
+	}
+	for _, name := range []string{"libc.so.6", "libc.so", "libpthread.so.0", "/lib/libm.so.6", "ld-linux-x86-64.so.2", "ld-musl-aarch64.so.1"} {
+		if !muslProvides(name) {
+			t.Errorf("muslProvides(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"libstdc++.so.6", "libz.so.1", "libcurl.so.4"} {
+		if muslProvides(name) {
+			t.Errorf("muslProvides(%q) = true", name)
+		}
+	}
+	if !useMusl(LibcMusl) || useMusl(LibcGlibc) {
+		t.Fatal("explicit LibcMode not honored")
+	}
+
+	// glibc dependencies fall back to the usual search when no musl libc
+	// is mapped, and versions are dropped, so a glibc image still loads.
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+	soPath := filepath.Join(t.TempDir(), fmt.Sprintf("musl_mode_linux-%s.so", runtime.GOARCH))
+	buildLinuxTestSOFrom(t, filepath.Join("..", "testdata", "c", "reloc", "basic.c"), soPath)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	module, err := LoadLibraryWithOptions(payload, LoadOptions{Libc: LibcMusl})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions(LibcMusl): %v", err)
+	}
+	defer module.Free()
+	api, err := getLinuxDynAPI()
+	if err != nil {
+		t.Fatalf("resolve dl API: %v", err)
+	}
+	want, err := resolveWithDLSym(api, "getenv")
+	if err != nil {
+		t.Fatalf("dlsym(getenv): %v", err)
+	}
+	if got := callFixtureExport(t, module, "reloc_glob_dat_getenv"); got != want {
+		t.Fatalf("reloc_glob_dat_getenv() = %#x, want %#x", got, want)
+	}
+}
+
+func TestDlopenCandidatesSearchPathsFirst_Linux(t *testing.T) {
+	got := dlopenCandidates("libfoo.so.1", []string{"/opt/payload/lib", "vendor"})
+	want := []string{"/opt/payload/lib/libfoo.so.1", "vendor/libfoo.so.1", "libfoo.so.1"}
+	if len(got) < len(want) || !slices.Equal(got[:len(want)], want) {
+		t.Fatalf("dlopenCandidates = %q, want prefix %q", got, want)
+	}
+
+	got = dlopenCandidates("/usr/lib/libfoo.so.1", []string{"/opt/payload/lib"})
+	if got[0] != "/usr/lib/libfoo.so.1" || slices.Contains(got, "/opt/payload/lib/libfoo.so.1") {
+		t.Fatalf("search paths applied to a path dependency: %q", got)
+	}
+}
+
+func TestDeterministicResolverOrdersNeededFirst_Linux(t *testing.T) {
+	modules := []runtimeELFModule{
+		{path: "/usr/lib/libc.so.6", score: 3},
+		{path: "/usr/lib/libz.so.1"},
+		{path: "/opt/app/libfoo.so"},
+		{path: "/usr/lib/libm.so.6"},
+	}
+	resolver := &symbolResolver{needed: []string{"libm.so.6", "libfoo.so"}, deterministic: true}
+	resolver.setModules(slices.Clone(modules))
+
+	var got []string
+	for _, module := range resolver.modules {
+		got = append(got, module.path)
+	}
+	want := []string{"/usr/lib/libm.so.6", "/opt/app/libfoo.so", "/usr/lib/libc.so.6", "/usr/lib/libz.so.1"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("module order = %q, want %q", got, want)
+	}
+
+	resolver = &symbolResolver{needed: resolver.needed}
+	resolver.setModules(slices.Clone(modules))
+	if resolver.modules[0].path != "/usr/lib/libc.so.6" {
+		t.Fatalf("default resolver reordered modules: %v", resolver.modules)
+	}
+}
+
+func TestMappedSymbolLookup_Linux(t *testing.T) {
+	if got := gnuHash("printf"); got != 0x156b2bb8 {
+		t.Fatalf("gnuHash(printf) = %#x, want 0x156b2bb8", got)
+	}
+	if got := sysvHash("printf"); got != 0x077905a6 {
+		t.Fatalf("sysvHash(printf) = %#x, want 0x077905a6", got)
+	}
+	for symbol, want := range map[string][2]string{
+		"getenv":             {"getenv", ""},
+		"memcpy@GLIBC_2.2.5": {"memcpy", "GLIBC_2.2.5"},
+		"memcpy@@GLIBC_2.14": {"memcpy", "GLIBC_2.14"},
+		"@GLIBC_2.2.5":       {"@GLIBC_2.2.5", ""},
+	} {
+		if name, version := splitSymbolVersion(symbol); name != want[0] || version != want[1] {
+			t.Fatalf("splitSymbolVersion(%q) = %q, %q; want %q, %q", symbol, name, version, want[0], want[1])
+		}
+	}
+
+	api, err := getLinuxDynAPI()
+	if err != nil {
+		t.Skipf("no libc in this process: %v", err)
+	}
+	modules, err := runtimeModules()
+	if err != nil {
+		t.Fatalf("runtimeModules: %v", err)
+	}
+	want, err := resolveWithDLSym(api, "getenv")
+	if err != nil {
+		t.Fatalf("dlsym(getenv): %v", err)
+	}
+	if got, err := resolveFromRuntimeModules(modules, "getenv", ""); err != nil || got != want {
+		t.Fatalf("getenv = %#x, %v; want dlsym's %#x", got, err, want)
+	}
+	if _, err := resolveFromRuntimeModules(modules, "getenv", "GLIBC_0.0"); err == nil {
+		t.Fatal("getenv bound to a version libc does not define")
 	}
 }
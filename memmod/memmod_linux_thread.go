@@ -0,0 +1,13 @@
+//go:build linux && !cgo && (386 || amd64 || arm64)
+
+package memmod
+
+import "errors"
+
+// pthreadCallsSupported reports whether CallThreadPthread is available.
+const pthreadCallsSupported = false
+
+func startNativeThread(fn uintptr, args [MaxCallArgs]uintptr, prologue threadPrologue, opts ThreadOptions) (func() CallResult, error) {
+	_, _, _, _ = fn, args, prologue, opts
+	return nil, errors.New("native export threads require cgo on linux")
+}
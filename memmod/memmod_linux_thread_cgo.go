@@ -0,0 +1,184 @@
+//go:build linux && cgo && (386 || amd64 || arm64)
+
+package memmod
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <pthread.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/syscall.h>
+#include <time.h>
+#include <unistd.h>
+
+typedef struct {
+	uintptr_t fn;
+	uintptr_t args[6];
+	uintptr_t prologue;
+	uintptr_t prologue_arg;
+	char name[16];
+	uintptr_t ret;
+	int err;
+	long tid;
+	int64_t ns;
+} reflektor_thread_start;
+
+static int64_t reflektor_monotonic_ns(void) {
+	struct timespec ts;
+	clock_gettime(CLOCK_MONOTONIC, &ts);
+	return (int64_t)ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+// reflektor_thread_main runs the export and records its results. The export
+// may change the thread's signal mask; the mask the thread started with is
+// put back before the thread returns, and so before the join sees it finish.
+static void *reflektor_thread_main(void *arg) {
+	reflektor_thread_start *start = (reflektor_thread_start *)arg;
+	int64_t begin = reflektor_monotonic_ns();
+	sigset_t mask;
+	pthread_sigmask(SIG_SETMASK, NULL, &mask);
+	start->tid = syscall(SYS_gettid);
+	if (start->name[0] != '\0') {
+		pthread_setname_np(pthread_self(), start->name);
+	}
+	if (start->prologue != 0) {
+		((void (*)(uintptr_t))start->prologue)(start->prologue_arg);
+	}
+	errno = 0;
+	uintptr_t *a = start->args;
+	start->ret = ((uintptr_t (*)(uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t))start->fn)(a[0], a[1], a[2], a[3], a[4], a[5]);
+	start->err = errno;
+	start->ns = reflektor_monotonic_ns() - begin;
+	pthread_sigmask(SIG_SETMASK, &mask, NULL);
+	return NULL;
+}
+
+static int reflektor_thread_create(uintptr_t fn, const uintptr_t *args, uintptr_t prologue, uintptr_t prologue_arg, size_t stack_size, const char *name, const int *cpus, int ncpus, uintptr_t *out, reflektor_thread_start **out_start) {
+	pthread_attr_t attr;
+	int rc = pthread_attr_init(&attr);
+	if (rc != 0) {
+		return rc;
+	}
+	if (stack_size != 0) {
+		rc = pthread_attr_setstacksize(&attr, stack_size);
+		if (rc != 0) {
+			pthread_attr_destroy(&attr);
+			return rc;
+		}
+	}
+	if (ncpus > 0) {
+		cpu_set_t set;
+		CPU_ZERO(&set);
+		for (int i = 0; i < ncpus; i++) {
+			if (cpus[i] < 0 || cpus[i] >= CPU_SETSIZE) {
+				pthread_attr_destroy(&attr);
+				return EINVAL;
+			}
+			CPU_SET(cpus[i], &set);
+		}
+		rc = pthread_attr_setaffinity_np(&attr, sizeof(set), &set);
+		if (rc != 0) {
+			pthread_attr_destroy(&attr);
+			return rc;
+		}
+	}
+
+	reflektor_thread_start *start = (reflektor_thread_start *)calloc(1, sizeof(*start));
+	if (start == NULL) {
+		pthread_attr_destroy(&attr);
+		return ENOMEM;
+	}
+	start->fn = fn;
+	memcpy(start->args, args, sizeof(start->args));
+	start->prologue = prologue;
+	start->prologue_arg = prologue_arg;
+	if (name != NULL) {
+		strncpy(start->name, name, sizeof(start->name) - 1);
+	}
+
+	pthread_t thread;
+	rc = pthread_create(&thread, &attr, reflektor_thread_main, start);
+	pthread_attr_destroy(&attr);
+	if (rc != 0) {
+		free(start);
+		return rc;
+	}
+	*out = (uintptr_t)thread;
+	*out_start = start;
+	return 0;
+}
+
+// reflektor_thread_join waits for the thread and collects the export's return
+// value, errno, thread id, and run time, which the thread recorded before
+// exiting.
+static void reflektor_thread_join(uintptr_t thread, reflektor_thread_start *start, uintptr_t *ret, int *err, long *tid, int64_t *ns) {
+	pthread_join((pthread_t)thread, NULL);
+	*ret = start->ret;
+	*err = start->err;
+	*tid = start->tid;
+	*ns = start->ns;
+	free(start);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// pthreadCallsSupported reports whether CallThreadPthread is available.
+const pthreadCallsSupported = true
+
+// startNativeThread calls fn with args on a new pthread configured by opts,
+// after running prologue on it.
+func startNativeThread(fn uintptr, args [MaxCallArgs]uintptr, prologue threadPrologue, opts ThreadOptions) (func() CallResult, error) {
+	if opts.StackSize < 0 {
+		return nil, fmt.Errorf("invalid stack size %d", opts.StackSize)
+	}
+
+	var name *C.char
+	if opts.Name != "" {
+		name = C.CString(opts.Name)
+		defer C.free(unsafe.Pointer(name))
+	}
+
+	var cpus *C.int
+	if len(opts.CPUAffinity) > 0 {
+		set := make([]C.int, len(opts.CPUAffinity))
+		for i, cpu := range opts.CPUAffinity {
+			set[i] = C.int(cpu)
+		}
+		cpus = &set[0]
+	}
+
+	var cargs [MaxCallArgs]C.uintptr_t
+	for i, arg := range args {
+		cargs[i] = C.uintptr_t(arg)
+	}
+
+	var (
+		thread C.uintptr_t
+		start  *C.reflektor_thread_start
+	)
+	rc := C.reflektor_thread_create(C.uintptr_t(fn), &cargs[0], C.uintptr_t(prologue.fn), C.uintptr_t(prologue.arg), C.size_t(opts.StackSize), name, cpus, C.int(len(opts.CPUAffinity)), &thread, &start)
+	if rc != 0 {
+		return nil, fmt.Errorf("pthread_create: %w", syscall.Errno(rc))
+	}
+	return func() CallResult {
+		var (
+			ret   C.uintptr_t
+			errno C.int
+			tid   C.long
+			ns    C.int64_t
+		)
+		C.reflektor_thread_join(thread, start, &ret, &errno, &tid, &ns)
+		return CallResult{Value: uintptr(ret), Errno: syscall.Errno(errno), ThreadID: uint64(tid), Duration: time.Duration(ns)}
+	}, nil
+}
@@ -0,0 +1,118 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// The loader provisions thread-local storage for an image itself, since
+// glibc only knows about modules it loaded. Every TLS relocation of the
+// image refers to one block laid out by its PT_TLS segment:
+//
+//   - General- and local-dynamic accesses (DTPMOD/DTPOFF, and TLS
+//     descriptors on arm64) go through a loader-owned __tls_get_addr. Each
+//     thread's block is allocated and initialized from the template on its
+//     first access and freed when the thread exits.
+//   - Initial-exec accesses (TPOFF/TPREL) need the block at the same offset
+//     from every thread pointer. It is carved from the far end of the surplus
+//     glibc reserves in each thread's static TLS area for libraries loaded
+//     later, and once a module has one, dynamic accesses use it too.
+//
+// The per-module state lives in memmod_linux_tls_cgo.go: blocks are tracked
+// with a pthread key, so provisioning needs cgo, where Go threads are
+// glibc threads.
+
+// tlsTemplate is an image's PT_TLS segment: the initialization image copied
+// into each thread's block, followed by memsz-filesz zero bytes.
+type tlsTemplate struct {
+	vaddr  uint64
+	filesz uint64
+	memsz  uint64
+	align  uint64
+}
+
+// readTLSTemplate returns the image's PT_TLS segment, if it has a non-empty
+// one.
+func readTLSTemplate(f *elf.File) (tlsTemplate, bool) {
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_TLS || p.Memsz == 0 {
+			continue
+		}
+		return tlsTemplate{vaddr: p.Vaddr, filesz: p.Filesz, memsz: p.Memsz, align: max(p.Align, 1)}, true
+	}
+	return tlsTemplate{}, false
+}
+
+// isTLSRelocation reports whether relocType addresses thread-local storage
+// on amd64 or arm64; linux/386 refuses these in i386RelocValue.
+func isTLSRelocation(machine elf.Machine, relocType uint32) bool {
+	switch machine {
+	case elf.EM_X86_64:
+		switch elf.R_X86_64(relocType) {
+		case elf.R_X86_64_DTPMOD64, elf.R_X86_64_DTPOFF64, elf.R_X86_64_TPOFF64, elf.R_X86_64_TLSDESC:
+			return true
+		}
+	case elf.EM_AARCH64:
+		switch elf.R_AARCH64(relocType) {
+		case elf.R_AARCH64_TLS_DTPMOD64, elf.R_AARCH64_TLS_DTPREL64, elf.R_AARCH64_TLS_TPREL64, elf.R_AARCH64_TLSDESC:
+			return true
+		}
+	}
+	return false
+}
+
+// tlsRelocation computes the words a TLS relocation stores at place, one
+// 8-byte word each; TLS descriptors take two. The symbol must be a TLS
+// variable of the image itself: the loader cannot reach the blocks glibc
+// keeps for other libraries.
+func tlsRelocation(machine elf.Machine, relocType uint32, place uintptr, dynSyms []elf.Symbol, symIndex uint32, addend int64, tls *moduleTLS) ([]uint64, error) {
+	var symOffset uint64
+	if symIndex != 0 {
+		sym, ok := dynSymbolByIndex(dynSyms, symIndex)
+		if !ok {
+			return nil, fmt.Errorf("relocation references invalid symbol index %d", symIndex)
+		}
+		if sym.Section == elf.SHN_UNDEF {
+			return nil, fmt.Errorf("%w: %s refers to TLS variable %q of another library", ErrTLSUnsupported, relocTypeName(machine, relocType), sym.Name)
+		}
+		symOffset = sym.Value
+	}
+	if tls == nil {
+		return nil, fmt.Errorf("%s relocation in an image without a PT_TLS segment", relocTypeName(machine, relocType))
+	}
+	offset := symOffset + uint64(addend)
+
+	switch {
+	case relocType == uint32(elf.R_X86_64_DTPMOD64) && machine == elf.EM_X86_64,
+		relocType == uint32(elf.R_AARCH64_TLS_DTPMOD64) && machine == elf.EM_AARCH64:
+		return []uint64{uint64(tls.moduleID())}, nil
+	case relocType == uint32(elf.R_X86_64_DTPOFF64) && machine == elf.EM_X86_64,
+		relocType == uint32(elf.R_AARCH64_TLS_DTPREL64) && machine == elf.EM_AARCH64:
+		return []uint64{offset}, nil
+	case relocType == uint32(elf.R_X86_64_TPOFF64) && machine == elf.EM_X86_64,
+		relocType == uint32(elf.R_AARCH64_TLS_TPREL64) && machine == elf.EM_AARCH64:
+		block, err := tls.staticOffset()
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{uint64(block) + offset}, nil
+	case relocType == uint32(elf.R_AARCH64_TLSDESC) && machine == elf.EM_AARCH64:
+		fn, arg, err := tls.descriptor(place, offset)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{uint64(fn), uint64(arg)}, nil
+	default:
+		// amd64 descriptors (-mtls-dialect=gnu2) need a resolver that
+		// preserves the full vector register state.
+		return nil, fmt.Errorf("%w: %s relocation", ErrTLSUnsupported, relocTypeName(machine, relocType))
+	}
+}
+
+// alignUpInt64 rounds v up to a multiple of align, which must be a power of
+// two. Negative values round toward zero.
+func alignUpInt64(v int64, align int64) int64 {
+	return (v + align - 1) &^ (align - 1)
+}
@@ -0,0 +1,386 @@
+//go:build linux && cgo && (amd64 || arm64)
+
+package memmod
+
+/*
+#include <pthread.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+// reflektor_tls_module is what a DTPMOD relocation points the image at. The
+// key holds each thread's block: a malloc'ed copy of the template, or, once
+// the module has a static block, a marker that the static block at
+// static_offset from the thread pointer has been initialized.
+typedef struct {
+	pthread_key_t key;
+	int key_created;
+	int is_static;
+	intptr_t static_offset;
+	uintptr_t image;
+	size_t image_size;
+	size_t size;
+	size_t align;
+} reflektor_tls_module;
+
+// reflektor_tls_index is the tls_index __tls_get_addr takes.
+typedef struct {
+	reflektor_tls_module *module;
+	uintptr_t offset;
+} reflektor_tls_index;
+
+static char *reflektor_thread_pointer(void) {
+	char *tp;
+#if defined(__x86_64__)
+	__asm__ ("mov %%fs:0, %0" : "=r" (tp));
+#else
+	__asm__ ("mrs %0, tpidr_el0" : "=r" (tp));
+#endif
+	return tp;
+}
+
+static char *reflektor_tls_block(reflektor_tls_module *m) {
+	char *block = pthread_getspecific(m->key);
+	if (block != NULL) {
+		return block;
+	}
+	if (m->is_static) {
+		block = reflektor_thread_pointer() + m->static_offset;
+	} else if ((block = aligned_alloc(m->align, m->size)) == NULL) {
+		abort();
+	}
+	memcpy(block, (const void *)m->image, m->image_size);
+	memset(block + m->image_size, 0, m->size - m->image_size);
+	if (pthread_setspecific(m->key, block) != 0) {
+		abort();
+	}
+	return block;
+}
+
+void *reflektor_tls_get_addr(reflektor_tls_index *ti) {
+	return reflektor_tls_block(ti->module) + ti->offset;
+}
+
+#if defined(__aarch64__)
+// reflektor_tlsdesc_dynamic resolves a TLS descriptor whose argument is a
+// reflektor_tls_index. Descriptor calls may only clobber x0 and the flags,
+// so everything the C call could touch is saved around it.
+extern char reflektor_tlsdesc_dynamic[];
+__asm__(
+	".text\n"
+	".p2align 2\n"
+	".type reflektor_tlsdesc_dynamic, %function\n"
+	"reflektor_tlsdesc_dynamic:\n"
+	"	sub sp, sp, #672\n"
+	"	stp x29, x30, [sp]\n"
+	"	mov x29, sp\n"
+	"	stp x1, x2, [sp, #16]\n"
+	"	stp x3, x4, [sp, #32]\n"
+	"	stp x5, x6, [sp, #48]\n"
+	"	stp x7, x8, [sp, #64]\n"
+	"	stp x9, x10, [sp, #80]\n"
+	"	stp x11, x12, [sp, #96]\n"
+	"	stp x13, x14, [sp, #112]\n"
+	"	stp x15, x16, [sp, #128]\n"
+	"	stp x17, x18, [sp, #144]\n"
+	"	stp q0, q1, [sp, #160]\n"
+	"	stp q2, q3, [sp, #192]\n"
+	"	stp q4, q5, [sp, #224]\n"
+	"	stp q6, q7, [sp, #256]\n"
+	"	stp q8, q9, [sp, #288]\n"
+	"	stp q10, q11, [sp, #320]\n"
+	"	stp q12, q13, [sp, #352]\n"
+	"	stp q14, q15, [sp, #384]\n"
+	"	stp q16, q17, [sp, #416]\n"
+	"	stp q18, q19, [sp, #448]\n"
+	"	stp q20, q21, [sp, #480]\n"
+	"	stp q22, q23, [sp, #512]\n"
+	"	stp q24, q25, [sp, #544]\n"
+	"	stp q26, q27, [sp, #576]\n"
+	"	stp q28, q29, [sp, #608]\n"
+	"	stp q30, q31, [sp, #640]\n"
+	"	ldr x0, [x0, #8]\n"
+	"	bl reflektor_tls_get_addr\n"
+	"	mrs x1, tpidr_el0\n"
+	"	sub x0, x0, x1\n"
+	"	ldp q30, q31, [sp, #640]\n"
+	"	ldp q28, q29, [sp, #608]\n"
+	"	ldp q26, q27, [sp, #576]\n"
+	"	ldp q24, q25, [sp, #544]\n"
+	"	ldp q22, q23, [sp, #512]\n"
+	"	ldp q20, q21, [sp, #480]\n"
+	"	ldp q18, q19, [sp, #448]\n"
+	"	ldp q16, q17, [sp, #416]\n"
+	"	ldp q14, q15, [sp, #384]\n"
+	"	ldp q12, q13, [sp, #352]\n"
+	"	ldp q10, q11, [sp, #320]\n"
+	"	ldp q8, q9, [sp, #288]\n"
+	"	ldp q6, q7, [sp, #256]\n"
+	"	ldp q4, q5, [sp, #224]\n"
+	"	ldp q2, q3, [sp, #192]\n"
+	"	ldp q0, q1, [sp, #160]\n"
+	"	ldp x17, x18, [sp, #144]\n"
+	"	ldp x15, x16, [sp, #128]\n"
+	"	ldp x13, x14, [sp, #112]\n"
+	"	ldp x11, x12, [sp, #96]\n"
+	"	ldp x9, x10, [sp, #80]\n"
+	"	ldp x7, x8, [sp, #64]\n"
+	"	ldp x5, x6, [sp, #48]\n"
+	"	ldp x3, x4, [sp, #32]\n"
+	"	ldp x1, x2, [sp, #16]\n"
+	"	ldp x29, x30, [sp]\n"
+	"	add sp, sp, #672\n"
+	"	ret\n"
+	".size reflektor_tlsdesc_dynamic, .-reflektor_tlsdesc_dynamic\n"
+);
+
+static uintptr_t reflektor_tls_desc_fn(void) {
+	return (uintptr_t)reflektor_tlsdesc_dynamic;
+}
+#else
+static uintptr_t reflektor_tls_desc_fn(void) {
+	return 0;
+}
+#endif
+
+static uintptr_t reflektor_tls_get_addr_fn(void) {
+	return (uintptr_t)reflektor_tls_get_addr;
+}
+
+static int reflektor_tls_start(reflektor_tls_module *m) {
+	int rc = pthread_key_create(&m->key, m->is_static ? NULL : free);
+	m->key_created = rc == 0;
+	return rc;
+}
+
+static void reflektor_tls_enter(reflektor_tls_module *m) {
+	reflektor_tls_block(m);
+}
+
+static uintptr_t reflektor_tls_enter_fn(void) {
+	return (uintptr_t)reflektor_tls_enter;
+}
+
+// reflektor_tls_stop deletes the key. Blocks of threads still running are
+// leaked: they may be in use, and their destructors no longer run.
+static void reflektor_tls_stop(reflektor_tls_module *m) {
+	if (m->key_created) {
+		pthread_key_delete(m->key);
+		m->key_created = 0;
+	}
+}
+
+static void reflektor_tls_static_info(uintptr_t fn, size_t *size, size_t *align) {
+	((void (*)(size_t *, size_t *))fn)(size, align);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// staticTLSReserve is how much of glibc's static TLS surplus the loader
+// claims for initial-exec blocks, from the end glibc allocates from last.
+// It matches glibc's rtld.optional_static_tls default, which leaves the rest
+// of the surplus to libraries dlopen'ed later.
+const staticTLSReserve = 512
+
+// tlsRange is a static TLS block, as offsets from the thread pointer.
+type tlsRange struct {
+	start, end int64
+}
+
+func (r tlsRange) overlaps(other tlsRange) bool {
+	return r.start < other.end && other.start < r.end
+}
+
+// staticTLSUsed holds the static blocks of every loaded module.
+var staticTLSUsed struct {
+	sync.Mutex
+	blocks []tlsRange
+}
+
+// moduleTLS is the TLS state the loader keeps for one image.
+type moduleTLS struct {
+	native   *C.reflektor_tls_module
+	template tlsTemplate
+	resolver *symbolResolver
+	// static is the module's static block once an initial-exec relocation
+	// asked for one.
+	static *tlsRange
+	// descriptors holds each TLS descriptor's argument, by place.
+	descriptors map[uintptr]*C.reflektor_tls_index
+}
+
+// newModuleTLS sets up TLS for an image mapped at loadBias. Nothing is
+// allocated per thread until the image first touches its TLS there.
+func newModuleTLS(template tlsTemplate, loadBias uintptr, resolver *symbolResolver) (*moduleTLS, error) {
+	if template.filesz > template.memsz {
+		return nil, fmt.Errorf("PT_TLS segment file size %d exceeds its memory size %d", template.filesz, template.memsz)
+	}
+	native := (*C.reflektor_tls_module)(C.calloc(1, C.size_t(unsafe.Sizeof(C.reflektor_tls_module{}))))
+	if native == nil {
+		return nil, fmt.Errorf("allocate TLS module: %w", syscall.ENOMEM)
+	}
+	align := max(template.align, uint64(unsafe.Sizeof(uintptr(0))))
+	native.image = C.uintptr_t(loadBias + uintptr(template.vaddr))
+	native.image_size = C.size_t(template.filesz)
+	// aligned_alloc wants a multiple of the alignment.
+	native.size = C.size_t((template.memsz + align - 1) &^ (align - 1))
+	native.align = C.size_t(align)
+	return &moduleTLS{native: native, template: template, resolver: resolver}, nil
+}
+
+// moduleID is the tls_index module a DTPMOD relocation stores.
+func (tls *moduleTLS) moduleID() uintptr {
+	return uintptr(unsafe.Pointer(tls.native))
+}
+
+// getAddr is what the image's __tls_get_addr import binds to.
+func (tls *moduleTLS) getAddr() uintptr {
+	return uintptr(C.reflektor_tls_get_addr_fn())
+}
+
+// descriptor returns the resolver and argument of the TLS descriptor at
+// place, for the variable at offset in the module's block.
+func (tls *moduleTLS) descriptor(place uintptr, offset uint64) (uintptr, uintptr, error) {
+	fn := uintptr(C.reflektor_tls_desc_fn())
+	if fn == 0 {
+		return 0, 0, fmt.Errorf("%w: TLS descriptors on %s", ErrTLSUnsupported, runtime.GOARCH)
+	}
+	if index, ok := tls.descriptors[place]; ok {
+		return fn, uintptr(unsafe.Pointer(index)), nil
+	}
+	index := (*C.reflektor_tls_index)(C.calloc(1, C.size_t(unsafe.Sizeof(C.reflektor_tls_index{}))))
+	if index == nil {
+		return 0, 0, fmt.Errorf("allocate TLS descriptor: %w", syscall.ENOMEM)
+	}
+	index.module = tls.native
+	index.offset = C.uintptr_t(offset)
+	if tls.descriptors == nil {
+		tls.descriptors = make(map[uintptr]*C.reflektor_tls_index)
+	}
+	tls.descriptors[place] = index
+	return fn, uintptr(unsafe.Pointer(index)), nil
+}
+
+// staticOffset returns the offset of the module's static block from the
+// thread pointer, claiming the block on first use.
+func (tls *moduleTLS) staticOffset() (int64, error) {
+	if tls.static != nil {
+		return tls.static.start, nil
+	}
+	lo, hi, align, err := staticTLSArea(tls.resolver)
+	if err != nil {
+		return 0, err
+	}
+	if tls.template.align > align {
+		return 0, fmt.Errorf("%w: PT_TLS alignment %d exceeds the static TLS alignment %d", ErrTLSUnsupported, tls.template.align, align)
+	}
+	size := int64(tls.native.size)
+
+	staticTLSUsed.Lock()
+	defer staticTLSUsed.Unlock()
+	for start := alignUpInt64(lo, int64(tls.template.align)); start+size <= hi; start += int64(tls.template.align) {
+		block := tlsRange{start: start, end: start + size}
+		if slices.ContainsFunc(staticTLSUsed.blocks, block.overlaps) {
+			continue
+		}
+		staticTLSUsed.blocks = append(staticTLSUsed.blocks, block)
+		tls.static = &block
+		tls.native.is_static = 1
+		tls.native.static_offset = C.intptr_t(start)
+		return start, nil
+	}
+	return 0, fmt.Errorf("%w: no room for a %d-byte initial-exec TLS block in the %d bytes reserved for them", ErrTLSUnsupported, size, staticTLSReserve)
+}
+
+// staticTLSArea returns the part of every thread's static TLS area the
+// loader hands out, as offsets from the thread pointer, and the alignment of
+// the thread pointer. glibc reports the area's size, which on amd64 includes
+// the struct pthread that the thread pointer points at and the area ends
+// at, and on arm64 starts at the thread pointer.
+func staticTLSArea(resolver *symbolResolver) (int64, int64, uint64, error) {
+	info, err := resolver.Resolve("_dl_get_tls_static_info")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: static TLS needs glibc: %v", ErrTLSUnsupported, err)
+	}
+	var size, align C.size_t
+	C.reflektor_tls_static_info(C.uintptr_t(info), &size, &align)
+
+	area := int64(size)
+	if runtime.GOARCH == "amd64" {
+		sizeofPthread, err := resolver.Resolve("_thread_db_sizeof_pthread")
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("%w: static TLS needs glibc: %v", ErrTLSUnsupported, err)
+		}
+		area -= int64(readU32(sizeofPthread))
+	}
+	if area < 2*staticTLSReserve {
+		return 0, 0, 0, fmt.Errorf("%w: the %d-byte static TLS area has no room to spare", ErrTLSUnsupported, area)
+	}
+	if runtime.GOARCH == "amd64" {
+		return -area, -area + staticTLSReserve, uint64(align), nil
+	}
+	return area - staticTLSReserve, area, uint64(align), nil
+}
+
+// usesStaticBlock reports whether calls into the module must enter its
+// static block first. tls may be nil.
+func (tls *moduleTLS) usesStaticBlock() bool {
+	return tls != nil && tls.static != nil
+}
+
+// start creates the key that tracks per-thread blocks, once relocation has
+// settled whether the module has a static block.
+func (tls *moduleTLS) start() error {
+	if rc := C.reflektor_tls_start(tls.native); rc != 0 {
+		return fmt.Errorf("pthread_key_create: %w", syscall.Errno(rc))
+	}
+	return nil
+}
+
+// enterThread initializes the static block on the calling thread, if the
+// module has one and the thread has not touched it yet. Initial-exec code
+// reads the block without asking the loader, so every thread must be
+// entered before it runs the image; the caller keeps the goroutine locked
+// to its thread until the image returns.
+func (tls *moduleTLS) enterThread() {
+	if tls.static != nil {
+		C.reflektor_tls_enter(tls.native)
+	}
+}
+
+// threadPrologue returns what a native thread must run before the image so
+// its static block is initialized, or the zero prologue. tls may be nil.
+func (tls *moduleTLS) threadPrologue() threadPrologue {
+	if !tls.usesStaticBlock() {
+		return threadPrologue{}
+	}
+	return threadPrologue{fn: uintptr(C.reflektor_tls_enter_fn()), arg: uintptr(unsafe.Pointer(tls.native))}
+}
+
+// free releases the module's TLS state once the image is unmapped.
+func (tls *moduleTLS) free() {
+	C.reflektor_tls_stop(tls.native)
+	for _, index := range tls.descriptors {
+		C.free(unsafe.Pointer(index))
+	}
+	tls.descriptors = nil
+	if tls.static != nil {
+		staticTLSUsed.Lock()
+		staticTLSUsed.blocks = slices.DeleteFunc(staticTLSUsed.blocks, func(block tlsRange) bool {
+			return block == *tls.static
+		})
+		staticTLSUsed.Unlock()
+		tls.static = nil
+	}
+	C.free(unsafe.Pointer(tls.native))
+	tls.native = nil
+}
@@ -0,0 +1,35 @@
+//go:build linux && (386 || (!cgo && (amd64 || arm64)))
+
+package memmod
+
+import "fmt"
+
+// moduleTLS is never created here: linux/386 images with a PT_TLS segment
+// are refused by checkTLSSupport, and without cgo the threads that call into
+// an image are not glibc threads, so there is no pthread key to track their
+// blocks with.
+type moduleTLS struct{}
+
+func newModuleTLS(template tlsTemplate, loadBias uintptr, resolver *symbolResolver) (*moduleTLS, error) {
+	return nil, fmt.Errorf("%w without cgo", ErrTLSUnsupported)
+}
+
+func (tls *moduleTLS) moduleID() uintptr { return 0 }
+
+func (tls *moduleTLS) getAddr() uintptr { return 0 }
+
+func (tls *moduleTLS) descriptor(place uintptr, offset uint64) (uintptr, uintptr, error) {
+	return 0, 0, ErrTLSUnsupported
+}
+
+func (tls *moduleTLS) staticOffset() (int64, error) { return 0, ErrTLSUnsupported }
+
+func (tls *moduleTLS) usesStaticBlock() bool { return false }
+
+func (tls *moduleTLS) start() error { return ErrTLSUnsupported }
+
+func (tls *moduleTLS) enterThread() {}
+
+func (tls *moduleTLS) threadPrologue() threadPrologue { return threadPrologue{} }
+
+func (tls *moduleTLS) free() {}
@@ -0,0 +1,181 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// verifyRelocations re-walks the image's dynamic relocations after they and
+// the queued ifunc relocations have been applied, and compares each slot
+// with the value it should hold. Symbol values are recomputed from the image
+// for symbols it defines; external symbols are checked against what the
+// resolver bound them to. REL addends come from the image file, since the
+// slot that held them has been overwritten.
+func verifyRelocations(data []byte, mapped mappedELF, f *elf.File, resolver *symbolResolver) (*RelocationCheck, error) {
+	dynSyms, err := f.DynamicSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("read dynamic symbol table: %w", err)
+	}
+	check := &RelocationCheck{}
+	for _, sec := range relocationSections(f) {
+		entries, err := readRelocations(sec, f.Class)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			if err := verifyOneRelocation(check, data, f, mapped, dynSyms, resolver, entry); err != nil {
+				return nil, fmt.Errorf("verify %s[%d]: %w", sec.Name, i, err)
+			}
+		}
+	}
+	return check, nil
+}
+
+func verifyOneRelocation(check *RelocationCheck, data []byte, f *elf.File, mapped mappedELF, dynSyms []elf.Symbol, resolver *symbolResolver, entry relocEntry) error {
+	place := mapped.loadBias + uintptr(entry.offset)
+
+	addend := entry.addend
+	if !entry.hasAddend {
+		size := 8
+		if f.Class == elf.ELFCLASS32 {
+			size = 4
+		}
+		word, ok := imageWord(data, mapped.progs, entry.offset, size)
+		if !ok {
+			return fmt.Errorf("relocation target %#x out of mapped image", entry.offset)
+		}
+		if size == 4 {
+			addend = int64(int32(word))
+		} else {
+			addend = int64(word)
+		}
+	}
+
+	if isTLSRelocation(f.Machine, entry.relocType) {
+		words, err := tlsRelocation(f.Machine, entry.relocType, place, dynSyms, entry.symIndex, addend, resolver.tls)
+		if err != nil {
+			return err
+		}
+		var name string
+		if sym, ok := dynSymbolByIndex(dynSyms, entry.symIndex); ok {
+			name = sym.Name
+		}
+		for i, want := range words {
+			check.Checked++
+			if got := readU64(place + uintptr(8*i)); got != want {
+				check.Mismatches = append(check.Mismatches, RelocationMismatch{
+					Offset: entry.offset + uint64(8*i),
+					Type:   relocTypeName(f.Machine, entry.relocType),
+					Symbol: name,
+					Want:   want,
+					Got:    got,
+				})
+			}
+		}
+		return nil
+	}
+
+	var (
+		sym      elf.Symbol
+		symValue uintptr
+	)
+	if isIRelative(f.Machine, entry.relocType) {
+		var ok bool
+		if symValue, ok = resolver.ifuncs.resolved[uintptr(int64(mapped.loadBias)+addend)]; !ok {
+			return fmt.Errorf("IRELATIVE resolver at %#x never ran", addend)
+		}
+	} else if entry.symIndex != 0 {
+		var ok bool
+		sym, ok = dynSymbolByIndex(dynSyms, entry.symIndex)
+		if !ok {
+			return fmt.Errorf("relocation references invalid symbol index %d", entry.symIndex)
+		}
+		if isLocalIFunc(sym) {
+			symValue, ok = resolver.ifuncs.resolved[mapped.loadBias+uintptr(sym.Value)]
+		} else if symValue, ok = imageSymbolValue(sym, mapped.loadBias); !ok {
+			symValue, ok = resolver.bound[entry.symIndex]
+		}
+		if !ok {
+			return fmt.Errorf("symbol %q was never bound", sym.Name)
+		}
+	}
+
+	var (
+		want relocWrite
+		err  error
+	)
+	if isGOTRelative(f.Machine, entry.relocType) {
+		var slot uintptr
+		if resolver.got != nil {
+			slot = resolver.got.slots[symValue]
+		}
+		if slot == 0 {
+			return fmt.Errorf("no GOT entry was made for %q", sym.Name)
+		}
+		want, err = gotRelocValue(entry.relocType, place, slot, addend)
+	} else {
+		want, err = relocValue(f.Machine, entry.relocType, place, mapped.loadBias, symValue, addend)
+	}
+	if err != nil {
+		return err
+	}
+	var got uint64
+	switch want.size {
+	case 0:
+		return nil
+	case 4:
+		got = uint64(readU32(place))
+	case 8:
+		got = readU64(place)
+	}
+	check.Checked++
+	if got != want.value {
+		check.Mismatches = append(check.Mismatches, RelocationMismatch{
+			Offset: entry.offset,
+			Type:   relocTypeName(f.Machine, entry.relocType),
+			Symbol: sym.Name,
+			Want:   want.value,
+			Got:    got,
+		})
+	}
+	return nil
+}
+
+// imageWord reads the size-byte word at vaddr as the image file holds it.
+// Bytes past a segment's p_filesz are zero, as they are once mapped.
+func imageWord(data []byte, progs []*elf.Prog, vaddr uint64, size int) (uint64, bool) {
+	for _, p := range progs {
+		if vaddr < p.Vaddr || vaddr+uint64(size) > p.Vaddr+p.Memsz {
+			continue
+		}
+		var word [8]byte
+		if rel := vaddr - p.Vaddr; rel < p.Filesz {
+			n := min(uint64(size), p.Filesz-rel)
+			off := p.Off + rel
+			if off+n > uint64(len(data)) {
+				return 0, false
+			}
+			copy(word[:], data[off:off+n])
+		}
+		if size == 4 {
+			return uint64(binary.LittleEndian.Uint32(word[:])), true
+		}
+		return binary.LittleEndian.Uint64(word[:]), true
+	}
+	return 0, false
+}
+
+func relocTypeName(machine elf.Machine, relocType uint32) string {
+	switch machine {
+	case elf.EM_X86_64:
+		return elf.R_X86_64(relocType).String()
+	case elf.EM_386:
+		return elf.R_386(relocType).String()
+	case elf.EM_AARCH64:
+		return elf.R_AARCH64(relocType).String()
+	}
+	return fmt.Sprintf("%d", relocType)
+}
@@ -0,0 +1,144 @@
+//go:build darwin && (amd64 || arm64)
+
+package memmod
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// numSignals is NSIG - 1 on darwin.
+const numSignals = 31
+
+const (
+	darwinSIGKILL    = 9
+	darwinSIGSTOP    = 17
+	darwinSIGSETMASK = 3
+)
+
+// userSigaction mirrors libc's struct sigaction on darwin.
+type userSigaction struct {
+	handler uintptr
+	mask    uint32
+	flags   int32
+}
+
+// SignalState is a snapshot of the process signal dispositions and the signal
+// mask of the thread that captured it.
+type SignalState struct {
+	actions [numSignals + 1]userSigaction
+	saved   [numSignals + 1]bool
+	mask    uint32
+}
+
+var errSignalAPIsUnavailable = errors.New("sigaction/pthread_sigmask not found in the shared cache")
+
+// SaveSignalState captures every signal disposition and the calling thread's
+// signal mask. Callers that rely on the mask must lock the OS thread.
+func SaveSignalState() (*SignalState, error) {
+	sigaction, sigmask := resolveSignalAPIs()
+	if sigaction == 0 || sigmask == 0 {
+		return nil, errSignalAPIsUnavailable
+	}
+
+	arena := getArena()
+	defer arena.release()
+	action, mask, err := signalScratch(arena)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &SignalState{}
+	for sig := 1; sig <= numSignals; sig++ {
+		if sig == darwinSIGKILL || sig == darwinSIGSTOP {
+			continue
+		}
+		if call4(sigaction, uintptr(sig), 0, uintptr(unsafe.Pointer(action)), 0) != 0 {
+			continue
+		}
+		state.actions[sig] = *action
+		state.saved[sig] = true
+	}
+	if rc := call4(sigmask, darwinSIGSETMASK, 0, uintptr(unsafe.Pointer(mask)), 0); rc != 0 {
+		return nil, fmt.Errorf("save signal mask: pthread_sigmask returned %d", int32(rc))
+	}
+	state.mask = *mask
+	return state, nil
+}
+
+// Restore reinstalls the captured signal dispositions and applies the
+// captured signal mask to the calling thread.
+func (state *SignalState) Restore() error {
+	if err := state.RestoreHandlers(); err != nil {
+		return err
+	}
+	_, sigmask := resolveSignalAPIs()
+
+	arena := getArena()
+	defer arena.release()
+	_, mask, err := signalScratch(arena)
+	if err != nil {
+		return err
+	}
+	*mask = state.mask
+	if rc := call4(sigmask, darwinSIGSETMASK, uintptr(unsafe.Pointer(mask)), 0, 0); rc != 0 {
+		return fmt.Errorf("restore signal mask: pthread_sigmask returned %d", int32(rc))
+	}
+	return nil
+}
+
+// RestoreHandlers reinstalls the captured signal dispositions, which are
+// process-wide, and leaves the calling thread's signal mask alone. It suits
+// callers that are not on the thread whose mask the payload changed.
+func (state *SignalState) RestoreHandlers() error {
+	sigaction, sigmask := resolveSignalAPIs()
+	if sigaction == 0 || sigmask == 0 {
+		return errSignalAPIsUnavailable
+	}
+
+	arena := getArena()
+	defer arena.release()
+	action, _, err := signalScratch(arena)
+	if err != nil {
+		return err
+	}
+
+	for sig := 1; sig <= numSignals; sig++ {
+		if !state.saved[sig] {
+			continue
+		}
+		*action = state.actions[sig]
+		if call4(sigaction, uintptr(sig), uintptr(unsafe.Pointer(action)), 0, 0) != 0 {
+			return fmt.Errorf("restore handler for signal %d", sig)
+		}
+	}
+	return nil
+}
+
+// signalScratch allocates the struct sigaction and sigset_t libc reads and
+// writes, from arena.
+func signalScratch(arena *nativeArena) (*userSigaction, *uint32, error) {
+	action, err := arena.alloc(unsafe.Sizeof(userSigaction{}), unsafe.Alignof(userSigaction{}))
+	if err != nil {
+		return nil, nil, err
+	}
+	mask, err := arena.alloc(unsafe.Sizeof(uint32(0)), unsafe.Alignof(uint32(0)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return (*userSigaction)(unsafe.Pointer(action)), (*uint32)(unsafe.Pointer(mask)), nil
+}
+
+// resolveSignalAPIs looks up libc's sigaction and pthread_sigmask.
+func resolveSignalAPIs() (uintptr, uintptr) {
+	sigaction := resolveLibSystemSymbol("_sigaction",
+		"/usr/lib/system/libsystem_c.dylib",
+		"/usr/lib/system/libsystem_kernel.dylib",
+	)
+	sigmask := resolveLibSystemSymbol("_pthread_sigmask",
+		"/usr/lib/system/libsystem_pthread.dylib",
+		"/usr/lib/system/libsystem_c.dylib",
+	)
+	return sigaction, sigmask
+}
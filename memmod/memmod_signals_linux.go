@@ -0,0 +1,84 @@
+//go:build linux && (386 || amd64 || arm64)
+
+package memmod
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// numSignals is the kernel's _NSIG on every supported architecture.
+const numSignals = 64
+
+// kernelSigaction mirrors the kernel's struct sigaction used by rt_sigaction.
+type kernelSigaction struct {
+	handler  uintptr
+	flags    uintptr
+	restorer uintptr
+	mask     uint64
+}
+
+// SignalState is a snapshot of the process signal dispositions and the signal
+// mask of the thread that captured it.
+type SignalState struct {
+	actions [numSignals + 1]kernelSigaction
+	saved   [numSignals + 1]bool
+	mask    unix.Sigset_t
+}
+
+// SaveSignalState captures every signal disposition and the calling thread's
+// signal mask. Callers that rely on the mask must lock the OS thread.
+func SaveSignalState() (*SignalState, error) {
+	state := &SignalState{}
+	for sig := 1; sig <= numSignals; sig++ {
+		if sig == int(unix.SIGKILL) || sig == int(unix.SIGSTOP) {
+			continue
+		}
+		if err := rtSigaction(sig, nil, &state.actions[sig]); err != nil {
+			// Signals reserved by the kernel or libc are skipped.
+			continue
+		}
+		state.saved[sig] = true
+	}
+	if err := unix.PthreadSigmask(unix.SIG_SETMASK, nil, &state.mask); err != nil {
+		return nil, fmt.Errorf("save signal mask: %w", err)
+	}
+	return state, nil
+}
+
+// Restore reinstalls the captured signal dispositions and applies the
+// captured signal mask to the calling thread.
+func (state *SignalState) Restore() error {
+	if err := state.RestoreHandlers(); err != nil {
+		return err
+	}
+	if err := unix.PthreadSigmask(unix.SIG_SETMASK, &state.mask, nil); err != nil {
+		return fmt.Errorf("restore signal mask: %w", err)
+	}
+	return nil
+}
+
+// RestoreHandlers reinstalls the captured signal dispositions, which are
+// process-wide, and leaves the calling thread's signal mask alone. It suits
+// callers that are not on the thread whose mask the payload changed.
+func (state *SignalState) RestoreHandlers() error {
+	for sig := 1; sig <= numSignals; sig++ {
+		if !state.saved[sig] {
+			continue
+		}
+		if err := rtSigaction(sig, &state.actions[sig], nil); err != nil {
+			return fmt.Errorf("restore handler for signal %d: %w", sig, err)
+		}
+	}
+	return nil
+}
+
+func rtSigaction(sig int, act, oldact *kernelSigaction) error {
+	_, _, errno := unix.RawSyscall6(unix.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(act)), uintptr(unsafe.Pointer(oldact)), unsafe.Sizeof(kernelSigaction{}.mask), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
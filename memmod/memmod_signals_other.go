@@ -0,0 +1,24 @@
+//go:build !(darwin && (amd64 || arm64)) && !(linux && (386 || amd64 || arm64))
+
+package memmod
+
+import "errors"
+
+// SignalState is a snapshot of the process signal dispositions and the signal
+// mask of the thread that captured it.
+type SignalState struct{}
+
+// SaveSignalState is only supported on linux and darwin.
+func SaveSignalState() (*SignalState, error) {
+	return nil, errors.New("signal state preservation is only supported on linux and darwin")
+}
+
+// Restore is only supported on linux and darwin.
+func (state *SignalState) Restore() error {
+	return errors.New("signal state preservation is only supported on linux and darwin")
+}
+
+// RestoreHandlers is only supported on linux and darwin.
+func (state *SignalState) RestoreHandlers() error {
+	return errors.New("signal state preservation is only supported on linux and darwin")
+}
@@ -1,9 +1,13 @@
-//go:build !windows && !darwin && !linux
+//go:build !windows && !(darwin && (amd64 || arm64)) && !(linux && (386 || amd64 || arm64))
 
 package memmod
 
 import "errors"
 
+// Supported reports whether this package can map images on the GOOS and
+// GOARCH it was built for.
+const Supported = false
+
 type Module struct{}
 
 func LoadLibrary(data []byte) (*Module, error) {
@@ -11,6 +15,11 @@ func LoadLibrary(data []byte) (*Module, error) {
 	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
 }
 
+func LoadLibraryWithOptions(data []byte, opts LoadOptions) (*Module, error) {
+	_, _ = data, opts
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
 func (module *Module) Free() {}
 
 func (module *Module) CallExport(name string) error {
@@ -18,6 +27,16 @@ func (module *Module) CallExport(name string) error {
 	return errors.New("memmod is only supported on windows, darwin, and linux")
 }
 
+func (module *Module) CallExportResult(name string) (CallResult, error) {
+	_ = name
+	return CallResult{}, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) CallExportArgs(name string, args ...uintptr) (CallResult, error) {
+	_, _ = name, args
+	return CallResult{}, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
 func (module *Module) ProcAddressByName(name string) (uintptr, error) {
 	_ = name
 	return 0, errors.New("memmod is only supported on windows, darwin, and linux")
@@ -27,3 +46,68 @@ func (module *Module) ProcAddressByOrdinal(ordinal uint16) (uintptr, error) {
 	_ = ordinal
 	return 0, errors.New("memmod is only supported on windows, darwin, and linux")
 }
+
+func (module *Module) StartExportThread(name string, opts ThreadOptions) (func() CallResult, error) {
+	_, _ = name, opts
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) StartEntry(argv []string) (func(), error) {
+	_ = argv
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) Exports() ([]string, error) {
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) FindSymbol(name string) (uintptr, error) {
+	_ = name
+	return 0, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) Symbols() ([]Symbol, error) {
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) Resource(resType, name string) ([]byte, error) {
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) Relocations() *RelocationCheck {
+	return nil
+}
+
+func (module *Module) ResourceUsage() *ResourceUsage {
+	return nil
+}
+
+func (module *Module) LockMemory() error {
+	return errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func (module *Module) Base() uintptr {
+	return 0
+}
+
+func currentThreadID() uint64 {
+	return 0
+}
+
+func mapCode(data []byte, size uintptr) (uintptr, error) {
+	_, _ = data, size
+	return 0, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func unmapCode(base, size uintptr) {}
+
+func callCode(fn, arg uintptr) CallResult {
+	return CallResult{}
+}
+
+func mapArenaChunk(size uintptr) ([]byte, error) {
+	_ = size
+	return nil, errors.New("memmod is only supported on windows, darwin, and linux")
+}
+
+func unmapArenaChunk(chunk []byte) {}
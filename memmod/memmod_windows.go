@@ -6,10 +6,15 @@
 package memmod
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
@@ -37,6 +42,24 @@ type Module struct {
 	nameExports   map[string]uint16
 	entry         uintptr
 	blockedMemory *addressList
+	lazyCommit    bool
+	reasons       DllMainReasons
+	// functionTable is the registered exception directory, if any.
+	functionTable uintptr
+	// pinned is set once the image pins itself with GetModuleHandleExW, after
+	// which Free leaves it loaded.
+	pinned atomic.Bool
+	// reserved is the span charged against the mapping budget.
+	reserved uint64
+	// searchPaths are the directories tried for dependencies before
+	// System32.
+	searchPaths []string
+	// resolver is consulted for each import before its DLL is loaded.
+	resolver ImportResolver
+	// tracker is set with LoadOptions.TrackResources.
+	tracker *resourceTracker
+	// logger is LoadOptions.Logger.
+	logger *slog.Logger
 }
 
 func (module *Module) headerDirectory(idx int) *IMAGE_DATA_DIRECTORY {
@@ -49,6 +72,9 @@ func (module *Module) copySections(address uintptr, size uintptr, oldHeaders *IM
 		if sections[i].SizeOfRawData == 0 {
 			// Section doesn't contain data in the dll itself, but may define uninitialized data.
 			sectionSize := oldHeaders.OptionalHeader.SectionAlignment
+			if module.lazyCommit && sections[i].VirtualSize() > sectionSize {
+				sectionSize = sections[i].VirtualSize()
+			}
 			if sectionSize == 0 {
 				continue
 			}
@@ -76,9 +102,14 @@ func (module *Module) copySections(address uintptr, size uintptr, oldHeaders *IM
 			return errors.New("Incomplete section")
 		}
 
-		// Commit memory block and copy data from dll.
+		// Commit memory block and copy data from dll. With LazyCommit nothing
+		// else is committed, so include uninitialized data past the raw size.
+		commitSize := sections[i].SizeOfRawData
+		if module.lazyCommit && sections[i].VirtualSize() > commitSize {
+			commitSize = sections[i].VirtualSize()
+		}
 		dest, err := windows.VirtualAlloc(module.codeBase+uintptr(sections[i].VirtualAddress),
-			uintptr(sections[i].SizeOfRawData),
+			uintptr(commitSize),
 			windows.MEM_COMMIT,
 			windows.PAGE_READWRITE)
 		if err != nil {
@@ -161,14 +192,24 @@ func (module *Module) finalizeSection(sectionData *sectionFinalizeData) error {
 	return nil
 }
 
-var rtlAddFunctionTable = windows.NewLazySystemDLL("ntdll.dll").NewProc("RtlAddFunctionTable")
+var (
+	rtlAddFunctionTable    = windows.NewLazySystemDLL("ntdll.dll").NewProc("RtlAddFunctionTable")
+	rtlDeleteFunctionTable = windows.NewLazySystemDLL("ntdll.dll").NewProc("RtlDeleteFunctionTable")
+)
 
+// registerExceptionHandlers registers the exception directory's
+// RUNTIME_FUNCTION entries so the unwinder can walk through the image: the
+// .pdata entries and, through them, the .xdata unwind records on ARM64. x86
+// has no such table and its ntdll lacks the API.
 func (module *Module) registerExceptionHandlers() {
 	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_EXCEPTION)
-	if directory.Size == 0 || directory.VirtualAddress == 0 {
+	if directory.Size == 0 || directory.VirtualAddress == 0 || rtlAddFunctionTable.Find() != nil {
 		return
 	}
-	rtlAddFunctionTable.Call(module.codeBase+uintptr(directory.VirtualAddress), uintptr(directory.Size)/unsafe.Sizeof(IMAGE_RUNTIME_FUNCTION_ENTRY{}), module.codeBase)
+	table := module.codeBase + uintptr(directory.VirtualAddress)
+	if r0, _, _ := rtlAddFunctionTable.Call(table, uintptr(directory.Size)/unsafe.Sizeof(runtimeFunction{}), module.codeBase); r0 != 0 {
+		module.functionTable = table
+	}
 }
 
 func (module *Module) finalizeSections() error {
@@ -217,7 +258,8 @@ func (module *Module) finalizeSections() error {
 	return nil
 }
 
-func (module *Module) executeTLS() {
+// executeTLS calls the image's TLS callbacks with reason.
+func (module *Module) executeTLS(reason uint32) {
 	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_TLS)
 	if directory.VirtualAddress == 0 {
 		return
@@ -231,7 +273,7 @@ func (module *Module) executeTLS() {
 			if f == 0 {
 				break
 			}
-			syscall.Syscall(f, 3, module.codeBase, uintptr(DLL_PROCESS_ATTACH), uintptr(0))
+			syscall.Syscall(f, 3, module.codeBase, uintptr(reason), uintptr(0))
 			callback += unsafe.Sizeof(f)
 		}
 	}
@@ -243,6 +285,7 @@ func (module *Module) performBaseRelocation(delta uintptr) (relocated bool, err
 		return delta == 0, nil
 	}
 
+	applied := 0
 	relocationHdr := (*IMAGE_BASE_RELOCATION)(a2p(module.codeBase + uintptr(directory.VirtualAddress)))
 	for relocationHdr.VirtualAddress > 0 {
 		dest := module.codeBase + uintptr(relocationHdr.VirtualAddress)
@@ -257,6 +300,9 @@ func (module *Module) performBaseRelocation(delta uintptr) (relocated bool, err
 			relType := relInfo >> 12
 			// The lower 12 bits define the offset.
 			relOffset := uintptr(relInfo & 0xfff)
+			if relType != IMAGE_REL_BASED_ABSOLUTE {
+				applied++
+			}
 
 			switch relType {
 			case IMAGE_REL_BASED_ABSOLUTE:
@@ -277,6 +323,10 @@ func (module *Module) performBaseRelocation(delta uintptr) (relocated bool, err
 				*(*uint64)(a2p(dest + relOffset)) += uint64(delta)
 
 			case IMAGE_REL_BASED_THUMB_MOV32:
+				// The type means something else on other machines.
+				if imageFileProcess != IMAGE_FILE_MACHINE_ARMNT {
+					return false, fmt.Errorf("Unsupported relocation: %v", relType)
+				}
 				inst := *(*uint32)(a2p(dest + relOffset))
 				imm16 := ((inst << 1) & 0x0800) + ((inst << 12) & 0xf000) +
 					((inst >> 20) & 0x0700) + ((inst >> 16) & 0x00ff)
@@ -315,6 +365,7 @@ func (module *Module) performBaseRelocation(delta uintptr) (relocated bool, err
 		// Advance to next relocation block.
 		relocationHdr = (*IMAGE_BASE_RELOCATION)(a2p(uintptr(unsafe.Pointer(relocationHdr)) + uintptr(relocationHdr.SizeOfBlock)))
 	}
+	trace(module.logger, stageRelocate, "applied base relocations", "relocations", applied, addrAttr("delta", delta))
 	return true, nil
 }
 
@@ -327,10 +378,8 @@ func (module *Module) buildImportTable() error {
 	module.modules = make([]windows.Handle, 0, 16)
 	importDesc := (*IMAGE_IMPORT_DESCRIPTOR)(a2p(module.codeBase + uintptr(directory.VirtualAddress)))
 	for importDesc.Name != 0 {
-		handle, err := windows.LoadLibraryEx(windows.BytePtrToString((*byte)(a2p(module.codeBase+uintptr(importDesc.Name)))), 0, windows.LOAD_LIBRARY_SEARCH_SYSTEM32)
-		if err != nil {
-			return fmt.Errorf("Error loading module: %w", err)
-		}
+		dllName := windows.BytePtrToString((*byte)(a2p(module.codeBase + uintptr(importDesc.Name))))
+		var handle windows.Handle
 		var thunkRef, funcRef *uintptr
 		if importDesc.OriginalFirstThunk() != 0 {
 			thunkRef = (*uintptr)(a2p(module.codeBase + uintptr(importDesc.OriginalFirstThunk())))
@@ -341,25 +390,114 @@ func (module *Module) buildImportTable() error {
 			funcRef = (*uintptr)(a2p(module.codeBase + uintptr(importDesc.FirstThunk)))
 		}
 		for *thunkRef != 0 {
-			if IMAGE_SNAP_BY_ORDINAL(*thunkRef) {
-				*funcRef, err = windows.GetProcAddressByOrdinal(handle, IMAGE_ORDINAL(*thunkRef))
-			} else {
-				thunkData := (*IMAGE_IMPORT_BY_NAME)(a2p(module.codeBase + *thunkRef))
-				*funcRef, err = windows.GetProcAddress(handle, windows.BytePtrToString(&thunkData.Name[0]))
-			}
+			var err error
+			*funcRef, err = module.resolveImport(dllName, &handle, *thunkRef)
 			if err != nil {
-				windows.FreeLibrary(handle)
 				return fmt.Errorf("Error getting function address: %w", err)
 			}
 			thunkRef = (*uintptr)(a2p(uintptr(unsafe.Pointer(thunkRef)) + unsafe.Sizeof(*thunkRef)))
 			funcRef = (*uintptr)(a2p(uintptr(unsafe.Pointer(funcRef)) + unsafe.Sizeof(*funcRef)))
 		}
-		module.modules = append(module.modules, handle)
 		importDesc = (*IMAGE_IMPORT_DESCRIPTOR)(a2p(uintptr(unsafe.Pointer(importDesc)) + unsafe.Sizeof(*importDesc)))
 	}
 	return nil
 }
 
+// resolveImport returns the address to bind one import thunk of dll to. The
+// caller's ImportResolver is asked first; otherwise dll is loaded on first use,
+// its handle stored in *handle and kept for Free, and the export looked up.
+func (module *Module) resolveImport(dll string, handle *windows.Handle, thunk uintptr) (uintptr, error) {
+	var name string
+	if IMAGE_SNAP_BY_ORDINAL(thunk) {
+		name = fmt.Sprintf("#%d", IMAGE_ORDINAL(thunk))
+	} else {
+		thunkData := (*IMAGE_IMPORT_BY_NAME)(a2p(module.codeBase + thunk))
+		name = windows.BytePtrToString(&thunkData.Name[0])
+	}
+	if module.resolver != nil {
+		if addr, ok := module.resolver(dll, name); ok {
+			return addr, nil
+		}
+	}
+
+	if *handle == 0 {
+		loaded, err := module.loadDependency(dll)
+		if err != nil {
+			return 0, fmt.Errorf("Error loading module %s: %w", dll, err)
+		}
+		trace(module.logger, stageDependency, "loaded dependency", "library", dll, addrAttr("handle", uintptr(loaded)))
+		module.modules = append(module.modules, loaded)
+		*handle = loaded
+	}
+	if IMAGE_SNAP_BY_ORDINAL(thunk) {
+		addr, err := windows.GetProcAddressByOrdinal(*handle, IMAGE_ORDINAL(thunk))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s!%s: %w", ErrSymbolNotFound, dll, name, err)
+		}
+		return addr, nil
+	}
+	addr, err := windows.GetProcAddress(*handle, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s!%s: %w", ErrSymbolNotFound, dll, name, err)
+	}
+	return moduleHandleImport(dll, name, addr), nil
+}
+
+// loadDependency loads the DLL an import descriptor names, trying each search
+// path before System32. A DLL found in a search path may load its own
+// dependencies from the same directory.
+func (module *Module) loadDependency(name string) (windows.Handle, error) {
+	if !strings.ContainsAny(name, `\/`) {
+		for _, dir := range module.searchPaths {
+			path, err := filepath.Abs(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			handle, err := windows.LoadLibraryEx(path, 0, windows.LOAD_LIBRARY_SEARCH_DLL_LOAD_DIR|windows.LOAD_LIBRARY_SEARCH_SYSTEM32)
+			if err == nil {
+				return handle, nil
+			}
+		}
+	}
+	return windows.LoadLibraryEx(name, 0, windows.LOAD_LIBRARY_SEARCH_SYSTEM32)
+}
+
+// bindDelayImports resolves every delay-load import the way buildImportTable
+// resolves ordinary ones, and stores each DLL's handle where the image's
+// delay-load helper looks for it so the helper never loads it again.
+func (module *Module) bindDelayImports() error {
+	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_DELAY_IMPORT)
+	if directory.Size == 0 || directory.VirtualAddress == 0 {
+		return nil
+	}
+
+	const dlattrRva = 0x1
+	desc := (*IMAGE_DELAYLOAD_DESCRIPTOR)(a2p(module.codeBase + uintptr(directory.VirtualAddress)))
+	for ; desc.DllNameRVA != 0; desc = (*IMAGE_DELAYLOAD_DESCRIPTOR)(a2p(uintptr(unsafe.Pointer(desc)) + unsafe.Sizeof(*desc))) {
+		if desc.Attributes&dlattrRva == 0 {
+			return errors.New("Delay-load descriptor uses virtual addresses")
+		}
+		name := windows.BytePtrToString((*byte)(a2p(module.codeBase + uintptr(desc.DllNameRVA))))
+		var handle windows.Handle
+
+		thunkRef := (*uintptr)(a2p(module.codeBase + uintptr(desc.ImportNameTableRVA)))
+		funcRef := (*uintptr)(a2p(module.codeBase + uintptr(desc.ImportAddressTableRVA)))
+		for *thunkRef != 0 {
+			var err error
+			*funcRef, err = module.resolveImport(name, &handle, *thunkRef)
+			if err != nil {
+				return fmt.Errorf("Error getting delay-load function address from %s: %w", name, err)
+			}
+			thunkRef = (*uintptr)(a2p(uintptr(unsafe.Pointer(thunkRef)) + unsafe.Sizeof(*thunkRef)))
+			funcRef = (*uintptr)(a2p(uintptr(unsafe.Pointer(funcRef)) + unsafe.Sizeof(*funcRef)))
+		}
+		if desc.ModuleHandleRVA != 0 && handle != 0 {
+			*(*windows.Handle)(a2p(module.codeBase + uintptr(desc.ModuleHandleRVA))) = handle
+		}
+	}
+	return nil
+}
+
 func (module *Module) buildNameExports() error {
 	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_EXPORT)
 	if directory.Size == 0 {
@@ -385,8 +523,9 @@ func (module *Module) buildNameExports() error {
 }
 
 type addressRange struct {
-	start uintptr
-	end   uintptr
+	start  uintptr
+	end    uintptr
+	module *Module
 }
 
 var loadedAddressRanges []addressRange
@@ -456,6 +595,18 @@ func hookRtlPcToFileHeader() error {
 
 // LoadLibrary loads module image to memory.
 func LoadLibrary(data []byte) (module *Module, err error) {
+	return LoadLibraryWithOptions(data, LoadOptions{})
+}
+
+// LoadLibraryWithOptions loads module image to memory according to opts.
+func LoadLibraryWithOptions(data []byte, opts LoadOptions) (module *Module, err error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty PE image")
+	}
+	if data, err = depackImage(data, opts); err != nil {
+		return nil, err
+	}
+	opts = opts.forImage(data)
 	addr := uintptr(unsafe.Pointer(&data[0]))
 	size := uintptr(len(data))
 	if size < unsafe.Sizeof(IMAGE_DOS_HEADER{}) {
@@ -473,13 +624,35 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 		return nil, fmt.Errorf("Not an NT binary (provided: %x, expected: %x)", oldHeader.Signature, IMAGE_NT_SIGNATURE)
 	}
 	if oldHeader.FileHeader.Machine != imageFileProcess {
-		return nil, fmt.Errorf("Foreign platform (provided: %x, expected: %x)", oldHeader.FileHeader.Machine, imageFileProcess)
+		if imageFileProcess == IMAGE_FILE_MACHINE_ARM64 && oldHeader.FileHeader.Machine == IMAGE_FILE_MACHINE_AMD64 {
+			// ARM64EC images carry the x64 machine type too; both need an
+			// emulation-compatible process. ARM64X images load natively.
+			return nil, fmt.Errorf("%w (provided: %x, expected: %x): x64 and ARM64EC images do not run in a native ARM64 process", ErrForeignPlatform, oldHeader.FileHeader.Machine, imageFileProcess)
+		}
+		return nil, fmt.Errorf("%w (provided: %x, expected: %x)", ErrForeignPlatform, oldHeader.FileHeader.Machine, imageFileProcess)
 	}
 	if (oldHeader.OptionalHeader.SectionAlignment & 1) != 0 {
 		return nil, errors.New("Unaligned section")
 	}
+	sectionTable := uintptr(dosHeader.E_lfanew) + unsafe.Offsetof(oldHeader.OptionalHeader) + uintptr(oldHeader.FileHeader.SizeOfOptionalHeader)
+	if size < sectionTable+uintptr(oldHeader.FileHeader.NumberOfSections)*unsafe.Sizeof(IMAGE_SECTION_HEADER{}) {
+		return nil, errors.New("Incomplete section table")
+	}
 	lastSectionEnd := uintptr(0)
 	sections := oldHeader.Sections()
+	layout := make([]layoutSegment, len(sections))
+	for i := range sections {
+		layout[i] = layoutSegment{
+			index:    i,
+			fileOff:  uint64(sections[i].PointerToRawData),
+			fileSize: uint64(sections[i].SizeOfRawData),
+			addr:     uint64(sections[i].VirtualAddress),
+			memSize:  uint64(max(sections[i].VirtualSize(), sections[i].SizeOfRawData)),
+		}
+	}
+	if err := checkLayout("pe", layout, uint64(size), uint64(oldHeader.OptionalHeader.SizeOfHeaders), 0); err != nil {
+		return nil, err
+	}
 	optionalSectionSize := oldHeader.OptionalHeader.SectionAlignment
 	for i := range sections {
 		var endOfSection uintptr
@@ -498,25 +671,55 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 		return nil, errors.New("Section is not page-aligned")
 	}
 
-	module = &Module{isDLL: (oldHeader.FileHeader.Characteristics & IMAGE_FILE_DLL) != 0}
+	if err := reserveMapping(uint64(alignedImageSize), opts); err != nil {
+		return nil, err
+	}
+
+	module = &Module{
+		isDLL:       (oldHeader.FileHeader.Characteristics & IMAGE_FILE_DLL) != 0,
+		lazyCommit:  opts.LazyCommit,
+		reserved:    uint64(alignedImageSize),
+		reasons:     opts.dllMainReasons(),
+		searchPaths: opts.SearchPaths,
+		resolver:    opts.ImportResolver,
+		logger:      opts.Logger,
+	}
 	defer func() {
 		if err != nil {
 			module.Free()
 			module = nil
 		}
 	}()
+	if opts.TrackResources {
+		if module.tracker, err = newResourceTracker(); err != nil {
+			err = fmt.Errorf("track resources: %w", err)
+			return
+		}
+	}
 
 	// Reserve memory for image of library.
-	// TODO: Is it correct to commit the complete memory region at once? Calling DllEntry raises an exception if we don't.
-	module.codeBase, err = windows.VirtualAlloc(oldHeader.OptionalHeader.ImageBase,
-		alignedImageSize,
-		windows.MEM_RESERVE|windows.MEM_COMMIT,
-		windows.PAGE_READWRITE)
+	// Committing the complete region at once is the default: DllEntry raises
+	// an exception when uninitialized data past a section's raw size is not
+	// committed. LazyCommit reserves only and copySections commits each
+	// section's full virtual size instead.
+	allocType := uint32(windows.MEM_RESERVE | windows.MEM_COMMIT)
+	if opts.LazyCommit {
+		allocType = windows.MEM_RESERVE
+	}
+	if hint := opts.baseHint(); hint != 0 {
+		module.codeBase, err = windows.VirtualAlloc(hint, alignedImageSize, allocType, windows.PAGE_READWRITE)
+	}
+	if module.codeBase == 0 {
+		module.codeBase, err = windows.VirtualAlloc(oldHeader.OptionalHeader.ImageBase,
+			alignedImageSize,
+			allocType,
+			windows.PAGE_READWRITE)
+	}
 	if err != nil {
 		// Try to allocate memory at arbitrary position.
 		module.codeBase, err = windows.VirtualAlloc(0,
 			alignedImageSize,
-			windows.MEM_RESERVE|windows.MEM_COMMIT,
+			allocType,
 			windows.PAGE_READWRITE)
 		if err != nil {
 			err = fmt.Errorf("Error allocating code: %w", err)
@@ -556,6 +759,8 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 		return
 	}
 
+	trace(opts.Logger, stageMap, "mapped image", addrAttr("base", module.codeBase), "size", alignedImageSize, "sections", len(sections))
+
 	// Adjust base address of imported data.
 	locationDelta := module.headers.OptionalHeader.ImageBase - oldHeader.OptionalHeader.ImageBase
 	if locationDelta != 0 {
@@ -574,6 +779,13 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 		err = fmt.Errorf("Error building import table: %w", err)
 		return
 	}
+	if opts.BindDelayImports {
+		err = module.bindDelayImports()
+		if err != nil {
+			err = fmt.Errorf("Error binding delay-load imports: %w", err)
+			return
+		}
+	}
 
 	// Mark memory pages depending on section headers and release sections that are marked as "discardable".
 	err = module.finalizeSections()
@@ -587,7 +799,7 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 
 	// Register function PCs.
 	loadedAddressRangesMu.Lock()
-	loadedAddressRanges = append(loadedAddressRanges, addressRange{module.codeBase, module.codeBase + alignedImageSize})
+	loadedAddressRanges = append(loadedAddressRanges, addressRange{module.codeBase, module.codeBase + alignedImageSize, module})
 	loadedAddressRangesMu.Unlock()
 	haveHookedRtlPcToFileHeader.Do(func() {
 		hookRtlPcToFileHeaderResult = hookRtlPcToFileHeader()
@@ -597,13 +809,15 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 		return
 	}
 
-	// TLS callbacks are executed BEFORE the main loading.
-	module.executeTLS()
-
 	// Get entry point of loaded module.
 	if module.headers.OptionalHeader.AddressOfEntryPoint != 0 {
 		module.entry = module.codeBase + uintptr(module.headers.OptionalHeader.AddressOfEntryPoint)
-		if module.isDLL {
+	}
+	if module.reasons&DllMainAttach != 0 {
+		trace(opts.Logger, stageInitialize, "running TLS callbacks and DllMain")
+		// TLS callbacks are executed BEFORE the main loading.
+		module.executeTLS(DLL_PROCESS_ATTACH)
+		if module.entry != 0 && module.isDLL {
 			// Notify library about attaching to process.
 			r0, _, _ := syscall.Syscall(module.entry, 3, module.codeBase, uintptr(DLL_PROCESS_ATTACH), 0)
 			successful := r0 != 0
@@ -619,12 +833,78 @@ func LoadLibrary(data []byte) (module *Module, err error) {
 	return
 }
 
-// Free releases module resources and unloads it.
+// CallDllMain sends reason (DLL_PROCESS_ATTACH, DLL_THREAD_ATTACH, and so on)
+// to the image's TLS callbacks and then its entry point with reserved as
+// lpReserved, and reports the entry point's result. A successful
+// DLL_PROCESS_ATTACH marks the image initialized, so Free detaches it when
+// LoadOptions.DllMain includes DllMainDetach; a DLL_PROCESS_DETACH clears it.
+func (module *Module) CallDllMain(reason uint32, reserved uintptr) (bool, error) {
+	if module.codeBase == 0 {
+		return false, errors.New("library is closed")
+	}
+	if !module.isDLL || module.entry == 0 {
+		return false, errors.New("image has no DllMain")
+	}
+	module.executeTLS(reason)
+	r0, _, _ := syscall.Syscall(module.entry, 3, module.codeBase, uintptr(reason), reserved)
+	switch {
+	case reason == DLL_PROCESS_ATTACH && r0 != 0:
+		module.initialized = true
+	case reason == DLL_PROCESS_DETACH:
+		module.initialized = false
+	}
+	return r0 != 0, nil
+}
+
+// Base returns the address the image was mapped at, or zero once it is freed.
+func (module *Module) Base() uintptr {
+	return module.codeBase
+}
+
+// LockMemory locks every committed, accessible page of the image into the
+// working set so it is never paged out. VirtualLock is bounded by the
+// process's minimum working set size. The lock is released when the image is
+// freed.
+func (module *Module) LockMemory() error {
+	if module.codeBase == 0 {
+		return errors.New("library is closed")
+	}
+	end := module.codeBase + uintptr(module.headers.OptionalHeader.SizeOfImage)
+	for addr := module.codeBase; addr < end; {
+		var info windows.MemoryBasicInformation
+		if err := windows.VirtualQuery(addr, &info, unsafe.Sizeof(info)); err != nil {
+			return fmt.Errorf("VirtualQuery %#x: %w", addr, err)
+		}
+		if info.RegionSize == 0 {
+			break
+		}
+		size := min(info.RegionSize, end-info.BaseAddress)
+		if info.State == windows.MEM_COMMIT && info.Protect&(windows.PAGE_NOACCESS|windows.PAGE_GUARD) == 0 {
+			if err := windows.VirtualLock(info.BaseAddress, size); err != nil {
+				return fmt.Errorf("VirtualLock %#x+%#x: %w", info.BaseAddress, size, err)
+			}
+		}
+		addr = info.BaseAddress + info.RegionSize
+	}
+	return nil
+}
+
+// Free releases module resources and unloads it. An image that pinned itself
+// with GetModuleHandleExW stays loaded, as the system loader would keep it
+// until the process exits.
 func (module *Module) Free() {
-	if module.initialized {
+	if module.pinned.Load() {
+		return
+	}
+	if module.initialized && module.reasons&DllMainDetach != 0 {
 		// Notify library about detaching from process.
+		module.executeTLS(DLL_PROCESS_DETACH)
 		syscall.Syscall(module.entry, 3, module.codeBase, uintptr(DLL_PROCESS_DETACH), 0)
-		module.initialized = false
+	}
+	module.initialized = false
+	if module.functionTable != 0 {
+		rtlDeleteFunctionTable.Call(module.functionTable)
+		module.functionTable = 0
 	}
 	if module.modules != nil {
 		// Free previously opened libraries.
@@ -633,16 +913,41 @@ func (module *Module) Free() {
 		}
 		module.modules = nil
 	}
+	if module.tracker != nil && module.tracker.final == nil {
+		module.tracker.final = module.measureResources()
+	}
 	if module.codeBase != 0 {
+		loadedAddressRangesMu.Lock()
+		for i := range loadedAddressRanges {
+			if loadedAddressRanges[i].module == module {
+				loadedAddressRanges = append(loadedAddressRanges[:i], loadedAddressRanges[i+1:]...)
+				break
+			}
+		}
+		loadedAddressRangesMu.Unlock()
 		windows.VirtualFree(module.codeBase, 0, windows.MEM_RELEASE)
 		module.codeBase = 0
 	}
+	if module.reserved != 0 {
+		releaseMapping(module.reserved)
+		module.reserved = 0
+	}
 	if module.blockedMemory != nil {
 		module.blockedMemory.free()
 		module.blockedMemory = nil
 	}
 }
 
+// Exports returns the sorted names in the export table.
+func (module *Module) Exports() ([]string, error) {
+	names := make([]string, 0, len(module.nameExports))
+	for name := range module.nameExports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // ProcAddressByName returns function address by exported name.
 func (module *Module) ProcAddressByName(name string) (uintptr, error) {
 	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_EXPORT)
@@ -660,7 +965,51 @@ func (module *Module) ProcAddressByName(name string) (uintptr, error) {
 		// AddressOfFunctions contains the RVAs to the "real" functions.
 		return module.codeBase + uintptr(*(*uint32)(a2p(module.codeBase + uintptr(exports.AddressOfFunctions) + uintptr(idx)*4))), nil
 	}
-	return 0, errors.New("Function not found by name")
+	return 0, fmt.Errorf("%w: %q", ErrSymbolNotFound, name)
+}
+
+// FindSymbol looks name up in the image's own exports, then in each DLL its
+// import directory loaded, in import order. Windows has no process-wide
+// symbol scope to fall back to.
+func (module *Module) FindSymbol(name string) (uintptr, error) {
+	if addr, err := module.ProcAddressByName(name); err == nil {
+		return addr, nil
+	}
+	for _, handle := range module.modules {
+		if addr, err := windows.GetProcAddress(handle, name); err == nil && addr != 0 {
+			return addr, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q is not in the image or its imports", ErrSymbolNotFound, name)
+}
+
+// Resource returns a copy of the image's resource of type resType named
+// name. Types and names are strings, or "#n" for numeric IDs, as FindResource
+// takes them; ResourceTypeRCData is the type packers usually store nested
+// payloads under. It returns ErrResourceNotFound when there is no such
+// resource.
+func (module *Module) Resource(resType, name string) ([]byte, error) {
+	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_RESOURCE)
+	if directory.Size == 0 {
+		return nil, fmt.Errorf("%w: image has no resource directory", ErrResourceNotFound)
+	}
+	image := unsafe.Slice((*byte)(a2p(module.codeBase)), module.headers.OptionalHeader.SizeOfImage)
+	data, err := peResource(image, directory.VirtualAddress, resType, name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+// Relocations returns nil: LoadOptions.VerifyRelocations is linux only.
+func (module *Module) Relocations() *RelocationCheck {
+	return nil
+}
+
+// Symbols is not supported by the windows loader, whose export table does not
+// say which exports are functions; use Exports.
+func (module *Module) Symbols() ([]Symbol, error) {
+	return nil, errors.New("Symbols is not supported on windows; use Exports")
 }
 
 // ProcAddressByOrdinal returns function address by exported ordinal.
@@ -670,15 +1019,17 @@ func (module *Module) ProcAddressByOrdinal(ordinal uint16) (uintptr, error) {
 		return 0, errors.New("No export table found")
 	}
 	exports := (*IMAGE_EXPORT_DIRECTORY)(a2p(module.codeBase + uintptr(directory.VirtualAddress)))
-	if uint32(ordinal) < exports.Base {
-		return 0, errors.New("Ordinal number too low")
+	if uint32(ordinal) < exports.Base || uint32(ordinal)-exports.Base >= exports.NumberOfFunctions {
+		return 0, fmt.Errorf("%w: ordinal %d is outside the export table", ErrSymbolNotFound, ordinal)
 	}
-	idx := ordinal - uint16(exports.Base)
-	if uint32(idx) > exports.NumberOfFunctions {
-		return 0, errors.New("Ordinal number too high")
+	idx := uint32(ordinal) - exports.Base
+	// AddressOfFunctions contains the RVAs to the "real" functions. Ordinals
+	// skipped by the export table have an RVA of zero.
+	rva := *(*uint32)(a2p(module.codeBase + uintptr(exports.AddressOfFunctions) + uintptr(idx)*4))
+	if rva == 0 {
+		return 0, fmt.Errorf("%w: ordinal %d is not exported", ErrSymbolNotFound, ordinal)
 	}
-	// AddressOfFunctions contains the RVAs to the "real" functions.
-	return module.codeBase + uintptr(*(*uint32)(a2p(module.codeBase + uintptr(exports.AddressOfFunctions) + uintptr(idx)*4))), nil
+	return module.codeBase + uintptr(rva), nil
 }
 
 func alignDown(value, alignment uintptr) uintptr {
@@ -6,3 +6,6 @@
 package memmod
 
 const imageFileProcess = IMAGE_FILE_MACHINE_I386
+
+// runtimeFunction is an entry of the exception directory.
+type runtimeFunction = IMAGE_RUNTIME_FUNCTION_ENTRY
@@ -6,3 +6,6 @@
 package memmod
 
 const imageFileProcess = IMAGE_FILE_MACHINE_ARMNT
+
+// runtimeFunction is an entry of the exception directory.
+type runtimeFunction = IMAGE_ARM64_RUNTIME_FUNCTION_ENTRY
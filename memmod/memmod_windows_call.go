@@ -5,15 +5,223 @@ package memmod
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                  = windows.NewLazySystemDLL("kernel32.dll")
+	procCreateThread          = kernel32.NewProc("CreateThread")
+	procGetExitCodeThread     = kernel32.NewProc("GetExitCodeThread")
+	procGetThreadTimes        = kernel32.NewProc("GetThreadTimes")
+	procSetThreadAffinityMask = kernel32.NewProc("SetThreadAffinityMask")
+	procSetThreadDescription  = kernel32.NewProc("SetThreadDescription")
+	procTerminateThread       = kernel32.NewProc("TerminateThread")
+)
+
+const (
+	createSuspended              = 0x00000004
+	stackSizeParamIsAReservation = 0x00010000
 )
 
 // CallExport resolves and calls an exported zero-argument function.
 func (module *Module) CallExport(name string) error {
+	_, err := module.CallExportResult(name)
+	return err
+}
+
+// CallExportResult calls an exported zero-argument function and returns its
+// raw return value and GetLastError. The runtime clears the last error before
+// the call. The x64 and arm64 ABIs already require callees to preserve the
+// floating-point control state.
+func (module *Module) CallExportResult(name string) (CallResult, error) {
+	return module.CallExportArgs(name)
+}
+
+// CallExportArgs calls an exported function with up to MaxCallArgs integer or
+// pointer arguments and returns its raw return value and GetLastError.
+func (module *Module) CallExportArgs(name string, args ...uintptr) (CallResult, error) {
+	if _, err := callArgs(args); err != nil {
+		return CallResult{}, err
+	}
+	addr, err := module.exportAddress(name)
+	if err != nil {
+		return CallResult{}, err
+	}
+
+	return measureCall(func() CallResult {
+		value, _, lastErr := syscall.SyscallN(addr, args...)
+		return CallResult{Value: value, Errno: lastErr}
+	}), nil
+}
+
+// currentThreadID returns the calling thread's id.
+func currentThreadID() uint64 {
+	return uint64(windows.GetCurrentThreadId())
+}
+
+// StartExportThread calls an exported zero-argument function on a new native
+// thread configured by opts. The returned wait function blocks until the
+// export returns and must be called exactly once. It reports the thread's
+// 32-bit exit code as the return value; the last error of another thread is
+// not observable, so Errno is always zero.
+func (module *Module) StartExportThread(name string, opts ThreadOptions) (func() CallResult, error) {
+	wait, _, err := module.StartKillableExportThread(name, opts)
+	return wait, err
+}
+
+// StartKillableExportThread is like StartExportThread but also returns a
+// function that ends the thread with TerminateThread. Terminating a thread
+// leaves whatever it held (locks, heap state, the loader lock) as it was, so
+// it is a last resort for exports that never return. The wait function must
+// still be called.
+func (module *Module) StartKillableExportThread(name string, opts ThreadOptions) (wait func() CallResult, kill func() error, err error) {
+	addr, err := module.exportAddress(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.StackSize < 0 {
+		return nil, nil, fmt.Errorf("invalid stack size %d", opts.StackSize)
+	}
+
+	var mask uintptr
+	for _, cpu := range opts.CPUAffinity {
+		if cpu < 0 || cpu >= int(unsafe.Sizeof(mask))*8 {
+			return nil, nil, fmt.Errorf("cpu %d is outside the current processor group", cpu)
+		}
+		mask |= 1 << uint(cpu)
+	}
+
+	var flags uintptr = createSuspended
+	if opts.StackSize > 0 {
+		flags |= stackSizeParamIsAReservation
+	}
+	var id uint32
+	r1, _, e1 := procCreateThread.Call(0, uintptr(opts.StackSize), addr, 0, flags, uintptr(unsafe.Pointer(&id)))
+	if r1 == 0 {
+		return nil, nil, fmt.Errorf("start export %q: CreateThread: %w", name, e1)
+	}
+	thread := windows.Handle(r1)
+
+	// The guard is in place before the thread runs any payload code.
+	exception := new(uint32)
+	if opts.CatchExceptions {
+		if err := guardThread(id, exception); err != nil {
+			procTerminateThread.Call(uintptr(thread), 1)
+			windows.CloseHandle(thread)
+			return nil, nil, fmt.Errorf("start export %q: guard exceptions: %w", name, err)
+		}
+	}
+
+	// The thread is created suspended so the export never runs with a
+	// partially applied configuration.
+	if err := configureThread(thread, opts.Name, mask); err != nil {
+		procTerminateThread.Call(uintptr(thread), 1)
+		windows.CloseHandle(thread)
+		unguardThread(id)
+		return nil, nil, fmt.Errorf("start export %q: %w", name, err)
+	}
+	if _, err := windows.ResumeThread(thread); err != nil {
+		procTerminateThread.Call(uintptr(thread), 1)
+		windows.CloseHandle(thread)
+		unguardThread(id)
+		return nil, nil, fmt.Errorf("start export %q: ResumeThread: %w", name, err)
+	}
+	// The handle is closed once the thread has been waited for; the mutex
+	// keeps kill from using it afterwards.
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	wait = func() CallResult {
+		windows.WaitForSingleObject(thread, windows.INFINITE)
+		var exitCode uint32
+		procGetExitCodeThread.Call(uintptr(thread), uintptr(unsafe.Pointer(&exitCode)))
+		duration := threadLifetime(thread)
+		mu.Lock()
+		windows.CloseHandle(thread)
+		closed = true
+		mu.Unlock()
+		unguardThread(id)
+		return CallResult{Value: uintptr(exitCode), Exception: *exception, ThreadID: uint64(id), Duration: duration}
+	}
+	kill = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return nil
+		}
+		if r1, _, e1 := procTerminateThread.Call(uintptr(thread), 1); r1 == 0 {
+			return fmt.Errorf("TerminateThread: %w", e1)
+		}
+		return nil
+	}
+	return wait, kill, nil
+}
+
+// threadLifetime returns how long an exited thread existed, or zero when the
+// system does not say.
+func threadLifetime(thread windows.Handle) time.Duration {
+	var creation, exit, kernel, user windows.Filetime
+	r1, _, _ := procGetThreadTimes.Call(uintptr(thread), uintptr(unsafe.Pointer(&creation)), uintptr(unsafe.Pointer(&exit)), uintptr(unsafe.Pointer(&kernel)), uintptr(unsafe.Pointer(&user)))
+	if r1 == 0 {
+		return 0
+	}
+	return time.Duration(exit.Nanoseconds() - creation.Nanoseconds())
+}
+
+func configureThread(thread windows.Handle, name string, mask uintptr) error {
+	if mask != 0 {
+		if r1, _, e1 := procSetThreadAffinityMask.Call(uintptr(thread), mask); r1 == 0 {
+			return fmt.Errorf("SetThreadAffinityMask: %w", e1)
+		}
+	}
+	if name != "" {
+		if err := procSetThreadDescription.Find(); err != nil {
+			return fmt.Errorf("thread names are not supported: %w", err)
+		}
+		description, err := windows.UTF16PtrFromString(name)
+		if err != nil {
+			return err
+		}
+		// SetThreadDescription returns an HRESULT.
+		if hr, _, _ := procSetThreadDescription.Call(uintptr(thread), uintptr(unsafe.Pointer(description))); int32(hr) < 0 {
+			return fmt.Errorf("SetThreadDescription: HRESULT 0x%08x", uint32(hr))
+		}
+	}
+	return nil
+}
+
+// StartEntry is not supported for PE images; executables are started by
+// calling their exports.
+func (module *Module) StartEntry(argv []string) (func(), error) {
+	_ = argv
+	return nil, errors.New("StartEntry is only supported for static-PIE executables on linux")
+}
+
+// exportAddress resolves an export by name, or by ordinal when name is "#N"
+// so exports without a name can be called.
+func (module *Module) exportAddress(name string) (uintptr, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
-		return errors.New("export name cannot be empty")
+		return 0, errors.New("export name cannot be empty")
+	}
+	if ordinal, found := strings.CutPrefix(name, "#"); found {
+		n, err := strconv.ParseUint(ordinal, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid export ordinal %q", name)
+		}
+		addr, err := module.ProcAddressByOrdinal(uint16(n))
+		if err != nil {
+			return 0, fmt.Errorf("resolve export %q: %w", name, err)
+		}
+		return addr, nil
 	}
 
 	candidates := []string{name}
@@ -30,13 +238,8 @@ func (module *Module) CallExport(name string) error {
 	for _, candidate := range candidates {
 		addr, err = module.ProcAddressByName(candidate)
 		if err == nil {
-			break
+			return addr, nil
 		}
 	}
-	if err != nil {
-		return fmt.Errorf("resolve export %q: %w", name, err)
-	}
-
-	_, _, _ = syscall.SyscallN(addr)
-	return nil
+	return 0, fmt.Errorf("resolve export %q: %w", name, err)
 }
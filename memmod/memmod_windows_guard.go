@@ -0,0 +1,91 @@
+//go:build windows
+
+package memmod
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procAddVectoredContinueHandler = kernel32.NewProc("AddVectoredContinueHandler")
+	procExitThread                 = kernel32.NewProc("ExitThread")
+)
+
+const (
+	exceptionContinueSearch    = 0
+	exceptionContinueExecution = ^uintptr(0)
+)
+
+type exceptionRecord struct {
+	ExceptionCode uint32
+}
+
+type exceptionPointers struct {
+	ExceptionRecord *exceptionRecord
+	ContextRecord   unsafe.Pointer
+}
+
+// exceptionGuard tracks the threads started with CatchExceptions. Windows
+// runs vectored continue handlers for an exception no frame on the thread
+// handled, which is the point where it would otherwise end the process, so a
+// continue handler registered ahead of the Go runtime's acts as the
+// outermost exception frame of every guarded thread.
+var exceptionGuard struct {
+	once sync.Once
+	err  error
+
+	mu sync.Mutex
+	// threads maps a guarded thread's ID to where its exception code goes.
+	threads map[uint32]*uint32
+}
+
+// guardThread makes an exception left unhandled on thread id end the thread
+// with the exception code, which is stored in code.
+func guardThread(id uint32, code *uint32) error {
+	exceptionGuard.once.Do(func() {
+		if err := procAddVectoredContinueHandler.Find(); err != nil {
+			exceptionGuard.err = err
+			return
+		}
+		if r1, _, e1 := procAddVectoredContinueHandler.Call(1, windows.NewCallback(guardException)); r1 == 0 {
+			exceptionGuard.err = fmt.Errorf("AddVectoredContinueHandler: %w", e1)
+			return
+		}
+		exceptionGuard.threads = make(map[uint32]*uint32)
+	})
+	if exceptionGuard.err != nil {
+		return exceptionGuard.err
+	}
+	exceptionGuard.mu.Lock()
+	exceptionGuard.threads[id] = code
+	exceptionGuard.mu.Unlock()
+	return nil
+}
+
+// unguardThread forgets a thread once it has exited.
+func unguardThread(id uint32) {
+	exceptionGuard.mu.Lock()
+	delete(exceptionGuard.threads, id)
+	exceptionGuard.mu.Unlock()
+}
+
+// guardException ends a guarded thread that raised an exception nothing
+// handled by resuming it in ExitThread with the exception code. The thread is
+// abandoned rather than unwound, so locks it held stay held, as with
+// TerminateThread. Every other exception is left to the handlers after it.
+func guardException(pointers *exceptionPointers) uintptr {
+	id := windows.GetCurrentThreadId()
+	exceptionGuard.mu.Lock()
+	code, ok := exceptionGuard.threads[id]
+	exceptionGuard.mu.Unlock()
+	if !ok {
+		return exceptionContinueSearch
+	}
+	*code = pointers.ExceptionRecord.ExceptionCode
+	resumeInExitThread(pointers.ContextRecord, procExitThread.Addr(), uintptr(*code))
+	return exceptionContinueExecution
+}
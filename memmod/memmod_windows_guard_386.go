@@ -0,0 +1,20 @@
+//go:build windows
+
+package memmod
+
+import "unsafe"
+
+// resumeInExitThread points an x86 CONTEXT at exitThread(code). The new stack
+// pointer sits above the faulting one, in frames that are being abandoned,
+// so the exception dispatcher's own frames below it stay intact.
+func resumeInExitThread(context unsafe.Pointer, exitThread, code uintptr) {
+	const (
+		eip = 0xb8
+		esp = 0xc4
+	)
+	sp := (*uint32)(unsafe.Add(context, esp))
+	*sp = *sp&^15 + 0x10
+	// stdcall: the argument follows the (unused) return address.
+	*(*uint32)(unsafe.Pointer(uintptr(*sp) + 4)) = uint32(code)
+	*(*uint32)(unsafe.Add(context, eip)) = uint32(exitThread)
+}
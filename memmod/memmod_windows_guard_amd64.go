@@ -0,0 +1,20 @@
+//go:build windows
+
+package memmod
+
+import "unsafe"
+
+// resumeInExitThread points an x64 CONTEXT at exitThread(code). The new stack
+// pointer sits above the faulting one, in frames that are being abandoned,
+// so the exception dispatcher's own frames below it stay intact.
+func resumeInExitThread(context unsafe.Pointer, exitThread, code uintptr) {
+	const (
+		rcx = 0x80
+		rsp = 0x98
+		rip = 0xf8
+	)
+	sp := (*uint64)(unsafe.Add(context, rsp))
+	*sp = *sp&^15 + 0x28
+	*(*uint64)(unsafe.Add(context, rcx)) = uint64(code)
+	*(*uint64)(unsafe.Add(context, rip)) = uint64(exitThread)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package memmod
+
+import "unsafe"
+
+// resumeInExitThread points an ARM CONTEXT at exitThread(code). Windows on
+// ARM runs Thumb-2 code, so the T bit of Cpsr is set and the Thumb bit of the
+// address cleared.
+func resumeInExitThread(context unsafe.Pointer, exitThread, code uintptr) {
+	const (
+		r0    = 0x04
+		lr    = 0x3c
+		pc    = 0x40
+		cpsr  = 0x44
+		thumb = 0x20
+	)
+	*(*uint32)(unsafe.Add(context, r0)) = uint32(code)
+	*(*uint32)(unsafe.Add(context, lr)) = 0
+	*(*uint32)(unsafe.Add(context, pc)) = uint32(exitThread) &^ 1
+	*(*uint32)(unsafe.Add(context, cpsr)) |= thumb
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package memmod
+
+import "unsafe"
+
+// resumeInExitThread points an ARM64 CONTEXT at exitThread(code).
+func resumeInExitThread(context unsafe.Pointer, exitThread, code uintptr) {
+	const (
+		x0 = 0x08
+		lr = 0xf8
+		pc = 0x108
+	)
+	*(*uint64)(unsafe.Add(context, x0)) = uint64(code)
+	*(*uint64)(unsafe.Add(context, lr)) = 0
+	*(*uint64)(unsafe.Add(context, pc)) = uint64(exitThread)
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package memmod
+
+import (
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Libraries pin themselves with GetModuleHandleExW(FROM_ADDRESS|PIN) on one of
+// their own functions so they are never unloaded underneath a running thread.
+// The system loader does not know about memory modules, so the real call
+// fails and such libraries treat it as fatal. The import is redirected to
+// getModuleHandleExWHook, which answers for addresses inside a memory module
+// and forwards everything else.
+var (
+	getModuleHandleExWOnce     sync.Once
+	getModuleHandleExWCallback uintptr
+	getModuleHandleExWOriginal uintptr
+)
+
+// Handle returns the module's pseudo-HMODULE: its image base, which is what an
+// HMODULE is for an image the system loader mapped. It is what the module's
+// own GetModuleHandleExW calls return, but system APIs that consult the
+// loader's module list, such as GetModuleFileNameW and FreeLibrary, reject it.
+func (module *Module) Handle() windows.Handle {
+	return windows.Handle(module.codeBase)
+}
+
+// moduleHandleImport returns the address the module's import of dll!name
+// should be bound to instead of procAddr, or procAddr itself.
+func moduleHandleImport(dll string, name string, procAddr uintptr) uintptr {
+	if name != "GetModuleHandleExW" || !isLibraryLoaderDLL(dll) {
+		return procAddr
+	}
+	getModuleHandleExWOnce.Do(func() {
+		getModuleHandleExWOriginal = procAddr
+		getModuleHandleExWCallback = windows.NewCallback(getModuleHandleExWHook)
+	})
+	return getModuleHandleExWCallback
+}
+
+// isLibraryLoaderDLL reports whether dll exports the library loader API,
+// directly or through an API set.
+func isLibraryLoaderDLL(dll string) bool {
+	dll = strings.ToLower(dll)
+	return dll == "kernel32.dll" || dll == "kernelbase.dll" || strings.HasPrefix(dll, "api-ms-win-core-libraryloader-")
+}
+
+func getModuleHandleExWHook(flags uintptr, name uintptr, handleOut uintptr) uintptr {
+	if flags&windows.GET_MODULE_HANDLE_EX_FLAG_FROM_ADDRESS != 0 && handleOut != 0 {
+		if module := moduleContaining(name); module != nil {
+			*(*windows.Handle)(unsafe.Pointer(handleOut)) = module.Handle()
+			if flags&windows.GET_MODULE_HANDLE_EX_FLAG_PIN != 0 {
+				module.pinned.Store(true)
+			}
+			return 1
+		}
+	}
+	ret, _, _ := syscall.SyscallN(getModuleHandleExWOriginal, flags, name, handleOut)
+	return ret
+}
+
+// moduleContaining returns the memory module whose image holds addr.
+func moduleContaining(addr uintptr) *Module {
+	loadedAddressRangesMu.RLock()
+	defer loadedAddressRangesMu.RUnlock()
+	for i := range loadedAddressRanges {
+		if addr >= loadedAddressRanges[i].start && addr < loadedAddressRanges[i].end {
+			return loadedAddressRanges[i].module
+		}
+	}
+	return nil
+}
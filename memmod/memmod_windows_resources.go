@@ -0,0 +1,134 @@
+//go:build windows
+
+package memmod
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetProcessHeaps          = kernel32.NewProc("GetProcessHeaps")
+	procGetProcessHandleCount    = kernel32.NewProc("GetProcessHandleCount")
+	procNtQueryInformationThread = windows.NewLazySystemDLL("ntdll.dll").NewProc("NtQueryInformationThread")
+)
+
+// threadQuerySetWin32StartAddress is the THREADINFOCLASS for a thread's
+// start address as passed to CreateThread.
+const threadQuerySetWin32StartAddress = 9
+
+// resourceTracker holds the process state from before a load, for
+// LoadOptions.TrackResources.
+type resourceTracker struct {
+	heaps   map[uintptr]struct{}
+	handles uint32
+	// final is the usage measured when the module was freed.
+	final *ResourceUsage
+}
+
+func newResourceTracker() (*resourceTracker, error) {
+	heaps, err := processHeaps()
+	if err != nil {
+		return nil, err
+	}
+	handles, err := processHandleCount()
+	if err != nil {
+		return nil, err
+	}
+	tracker := &resourceTracker{heaps: make(map[uintptr]struct{}, len(heaps)), handles: handles}
+	for _, heap := range heaps {
+		tracker.heaps[heap] = struct{}{}
+	}
+	return tracker, nil
+}
+
+// ResourceUsage reports the threads, heaps, and handles the payload holds,
+// or nil without LoadOptions.TrackResources. After Free it reports what was
+// still held once DllMain had seen the detach, just before the image was
+// unmapped.
+func (module *Module) ResourceUsage() *ResourceUsage {
+	if module.tracker == nil {
+		return nil
+	}
+	if module.tracker.final != nil {
+		return module.tracker.final
+	}
+	return module.measureResources()
+}
+
+// measureResources compares the process against the tracker's snapshot.
+// Whatever cannot be measured is left out rather than failing the report.
+func (module *Module) measureResources() *ResourceUsage {
+	usage := &ResourceUsage{}
+	if heaps, err := processHeaps(); err == nil {
+		for _, heap := range heaps {
+			if _, existed := module.tracker.heaps[heap]; !existed {
+				usage.Heaps = append(usage.Heaps, heap)
+			}
+		}
+	}
+	if handles, err := processHandleCount(); err == nil {
+		usage.Handles = int(handles) - int(module.tracker.handles)
+	}
+	if module.codeBase != 0 && module.headers != nil {
+		start, end := module.codeBase, module.codeBase+uintptr(module.headers.OptionalHeader.SizeOfImage)
+		usage.Threads, _ = threadsStartedIn(start, end)
+	}
+	return usage
+}
+
+func processHeaps() ([]uintptr, error) {
+	for size := 64; ; size *= 2 {
+		heaps := make([]uintptr, size)
+		r1, _, e1 := procGetProcessHeaps.Call(uintptr(len(heaps)), uintptr(unsafe.Pointer(&heaps[0])))
+		if r1 == 0 {
+			return nil, fmt.Errorf("GetProcessHeaps: %w", e1)
+		}
+		if int(r1) <= len(heaps) {
+			return heaps[:r1], nil
+		}
+	}
+}
+
+func processHandleCount() (uint32, error) {
+	var count uint32
+	if r1, _, e1 := procGetProcessHandleCount.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&count))); r1 == 0 {
+		return 0, fmt.Errorf("GetProcessHandleCount: %w", e1)
+	}
+	return count, nil
+}
+
+// threadsStartedIn lists the threads of this process whose start address is
+// in [start, end).
+func threadsStartedIn(start, end uintptr) ([]uint32, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return nil, fmt.Errorf("CreateToolhelp32Snapshot: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	pid := windows.GetCurrentProcessId()
+	var (
+		ids   []uint32
+		entry = windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	)
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != pid {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_QUERY_INFORMATION, false, entry.ThreadID)
+		if err != nil {
+			continue
+		}
+		var addr uintptr
+		status, _, _ := procNtQueryInformationThread.Call(uintptr(thread), threadQuerySetWin32StartAddress,
+			uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr), 0)
+		windows.CloseHandle(thread)
+		if status == 0 && addr >= start && addr < end {
+			ids = append(ids, entry.ThreadID)
+		}
+	}
+	return ids, nil
+}
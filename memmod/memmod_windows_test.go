@@ -0,0 +1,79 @@
+//go:build windows && (amd64 || arm64)
+
+package memmod
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var rtlLookupFunctionEntry = windows.NewLazySystemDLL("kernel32.dll").NewProc("RtlLookupFunctionEntry")
+
+// TestExceptionDirectoryRegistered_Windows checks that the unwinder finds
+// every entry of a loaded image's exception directory, which on arm64 means
+// the table was registered with its 8-byte entries rather than x64's 12.
+func TestExceptionDirectoryRegistered_Windows(t *testing.T) {
+	if _, err := exec.LookPath("zig"); err != nil {
+		t.Skip("zig not found in PATH")
+	}
+
+	dllPath := filepath.Join(t.TempDir(), fmt.Sprintf("callresult_windows-%s.dll", runtime.GOARCH))
+	buildWindowsTestDLL(t, filepath.Join("..", "testdata", "c", "callresult.c"), dllPath)
+	payload, err := os.ReadFile(dllPath)
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	module, err := LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer module.Free()
+
+	directory := module.headerDirectory(IMAGE_DIRECTORY_ENTRY_EXCEPTION)
+	if directory.Size == 0 {
+		t.Skip("toolchain did not emit an exception directory")
+	}
+	if module.functionTable == 0 {
+		t.Fatal("exception directory was not registered")
+	}
+	count := uintptr(directory.Size) / unsafe.Sizeof(runtimeFunction{})
+	for i := uintptr(0); i < count; i++ {
+		entry := module.functionTable + i*unsafe.Sizeof(runtimeFunction{})
+		begin := (*runtimeFunction)(unsafe.Pointer(entry)).BeginAddress
+		var imageBase uintptr
+		found, _, _ := rtlLookupFunctionEntry.Call(module.codeBase+uintptr(begin), uintptr(unsafe.Pointer(&imageBase)), 0)
+		if found != entry || imageBase != module.codeBase {
+			t.Fatalf("RtlLookupFunctionEntry(rva %#x) = %#x (image base %#x), want entry %d at %#x (image base %#x)", begin, found, imageBase, i, entry, module.codeBase)
+		}
+	}
+}
+
+func buildWindowsTestDLL(t *testing.T, source string, output string) {
+	t.Helper()
+
+	var target string
+	switch runtime.GOARCH {
+	case "amd64":
+		target = "x86_64-windows-gnu"
+	case "arm64":
+		target = "aarch64-windows-gnu"
+	default:
+		t.Fatalf("unsupported GOARCH for windows test: %s", runtime.GOARCH)
+	}
+	cmd := exec.Command("zig", "cc", "-target", target, "-shared", "-O2", "-g0", "-o", output, source)
+	cmd.Env = append(
+		os.Environ(),
+		"ZIG_GLOBAL_CACHE_DIR="+filepath.Join(os.TempDir(), "reflektor-zig-global-cache"),
+		"ZIG_LOCAL_CACHE_DIR="+filepath.Join(os.TempDir(), "reflektor-zig-local-cache"),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build windows test DLL: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,272 @@
+package memmod
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"unsafe"
+)
+
+// LoadOptions tunes how LoadLibraryWithOptions maps an image. The zero value
+// matches LoadLibrary.
+type LoadOptions struct {
+	// LazyCommit reserves the image's full address span but commits only the
+	// ranges its segments or sections occupy, and only as they are touched.
+	// On linux the span is reserved with MAP_NORESERVE and the gaps between
+	// PT_LOAD segments stay inaccessible; on windows the span is reserved with
+	// MEM_RESERVE and each section is committed separately. Very large
+	// payloads that execute a fraction of their image then count only the
+	// pages they use against the host's commit limit. The darwin loader
+	// ignores it.
+	LazyCommit bool
+
+	// PreferredBase asks for the image to be mapped at this address. It is a
+	// hint: when the range is taken the loader falls back to its default
+	// placement. Check Module.Base for where the image landed.
+	PreferredBase uintptr
+
+	// BaseSeed, when non-zero and PreferredBase is zero, derives the
+	// preferred base deterministically from the seed so a layout can be
+	// reproduced across runs. Zero keeps the default randomized placement.
+	BaseSeed uint64
+
+	// MaxImageSize, when non-zero, rejects images whose address span is
+	// larger than this many bytes with ErrImageTooLarge before anything is
+	// mapped. The span comes straight from the image headers, so this bounds
+	// what a malformed or hostile payload can make the loader reserve.
+	MaxImageSize uint64
+
+	// MaxTotalMappedBytes, when non-zero, rejects an image with
+	// ErrMappingBudget if mapping it would take the address space held by
+	// all images loaded through this package, as reported by MappedBytes,
+	// past this many bytes.
+	MaxTotalMappedBytes uint64
+
+	// Symbols widens which symbols the module exposes through Exports,
+	// Symbols, and ProcAddressByName. The zero value keeps global and weak
+	// functions only. Linux only.
+	Symbols SymbolFilter
+
+	// BindDelayImports resolves a windows image's delay-load imports while
+	// it is loaded, like ordinary imports, instead of leaving them to the
+	// image's delay-load helper on first call. Use it for payloads whose
+	// helper was stripped or must not call LoadLibrary later.
+	BindDelayImports bool
+
+	// DllMain selects the notifications the windows loader sends to the
+	// image's TLS callbacks and entry point. The zero value sends
+	// DLL_PROCESS_ATTACH at load and DLL_PROCESS_DETACH from Free, as the
+	// system loader does.
+	DllMain DllMainReasons
+
+	// ImagePath is the path the darwin loader registers the image under with
+	// dyld, which is what dladdr reports as dli_fname for addresses in the
+	// image and what _dyld_get_image_name returns. Empty uses the dylib's
+	// install name (LC_ID_DYLIB), or a name derived from the image's address
+	// for images without one.
+	ImagePath string
+
+	// SkipInitializers maps and links the image without running its
+	// initializers: DT_PREINIT_ARRAY, DT_INIT, and DT_INIT_ARRAY on linux,
+	// the TLS callbacks and DllMain attach on windows, and dyld's
+	// initializers on darwin. The image's terminators are skipped on unload
+	// too, since they would tear down state that was never set up, unless
+	// they are run later: windows callers can attach with
+	// Module.CallDllMain, darwin callers with Module.RunInitializers.
+	SkipInitializers bool
+
+	// RunFinalizers makes Free run a linux image's termination functions,
+	// DT_FINI_ARRAY in reverse and then DT_FINI, before it is unmapped, as
+	// dlclose would. C++ global destructors and atexit handlers the image
+	// registered run with them. It has no effect with SkipInitializers.
+	// Other platforms ignore it; darwin and windows always run their
+	// terminators.
+	RunFinalizers bool
+
+	// SearchPaths lists directories searched, in order, for the image's
+	// dependencies before the platform's default locations. Dependencies
+	// named by path are loaded as named. The darwin loader ignores it; dyld
+	// resolves dependencies itself.
+	SearchPaths []string
+
+	// ImportResolver, when non-nil, is asked for each symbol the image
+	// imports before the loader's own resolution, so imports can be bound to
+	// dependencies loaded from memory instead of from disk. On windows a DLL
+	// whose imports it answers in full is never loaded. Linux and windows
+	// only; dyld binds darwin images itself.
+	ImportResolver ImportResolver
+
+	// Deterministic removes run-to-run variation from the load so
+	// differential tests can compare loads across runs and hosts. Without
+	// PreferredBase or BaseSeed the base is derived from a hash of the
+	// image; linux resolves imports against the image's DT_NEEDED libraries
+	// in the order it lists them before any other library in the process;
+	// and darwin names images that have no ImagePath or install name after
+	// their hash and a per-process load count instead of their address.
+	Deterministic bool
+
+	// VerifyRelocations re-walks the image's dynamic relocations once they
+	// are applied and checks that each slot holds the value it should,
+	// catching partial writes and resolver bugs. Module.Relocations reports
+	// the result; mismatches do not fail the load. The check runs before the
+	// image's initializers, so with SkipInitializers the caller can inspect
+	// it before any payload code runs. Linux only.
+	VerifyRelocations bool
+
+	// TrackResources snapshots the process's heaps, handle count, and
+	// threads before the load so Module.ResourceUsage can report what the
+	// payload's DllMain and exports created and did not release, and
+	// whether threads it started still run. Plugin hosts can check it
+	// before deciding to unload. Windows only.
+	TrackResources bool
+
+	// CallThread selects the thread the linux loader runs the image's
+	// initializers and exports on. The zero value runs Go c-shared payloads
+	// on a fresh pthread in cgo builds and everything else on the calling
+	// goroutine's thread. Other platforms ignore it.
+	CallThread CallThreadMode
+
+	// Libc selects the C library the linux loader binds imports to. The
+	// zero value uses musl rules when musl's dynamic linker is mapped in the
+	// process, as in Alpine-based containers. Other platforms ignore it.
+	Libc LibcMode
+
+	// LoadMode selects how the darwin loader links the image. The zero value
+	// hands it to dyld. Other platforms ignore it.
+	LoadMode LoadMode
+
+	// Logger, when set, receives a debug-level record as the load reaches
+	// each stage, with a "stage" attribute naming it: "unpack", "arch" for
+	// the slice picked from a fat Mach-O, "map", "relocate" with relocation
+	// counts, "dependency" for each library loaded to satisfy imports, and
+	// "initialize" just before the image's initializers run, so the last
+	// record before a crash says where the load was.
+	Logger *slog.Logger
+}
+
+// ImportResolver returns the address to bind an import to and true, or false
+// to leave it to the loader. library is the DLL an import names on windows;
+// on linux it is the library a symbol version ties the import to, or empty,
+// since ELF imports do not otherwise name a library. Windows imports by
+// ordinal are passed as "#N". It runs on the loading goroutine and may be
+// called more than once for the same symbol.
+type ImportResolver func(library, symbol string) (uintptr, bool)
+
+// DllMainReasons selects the DllMain notifications the windows loader sends.
+type DllMainReasons uint8
+
+const (
+	// DllMainAttach sends DLL_PROCESS_ATTACH once the image is mapped. A
+	// FALSE return fails the load.
+	DllMainAttach DllMainReasons = 1 << iota
+	// DllMainDetach sends DLL_PROCESS_DETACH from Free when the attach
+	// succeeded.
+	DllMainDetach
+	// DllMainNone sends no notifications, for callers that drive the entry
+	// point themselves with Module.CallDllMain.
+	DllMainNone
+)
+
+// CallThreadMode selects where a linux image's initializers and exports run.
+type CallThreadMode uint8
+
+const (
+	// CallThreadAuto picks CallThreadPthread for Go c-shared payloads when
+	// cgo is available and CallThreadCaller otherwise.
+	CallThreadAuto CallThreadMode = iota
+	// CallThreadPthread runs each initializer and export call on a pthread
+	// created for it and joined when it returns, so native code sees a
+	// thread glibc set up end to end: its own stack, guard page, TLS, and
+	// signal mask, with no Go scheduler state. It requires cgo.
+	CallThreadPthread
+	// CallThreadCaller runs them on the calling goroutine's thread.
+	CallThreadCaller
+)
+
+// LibcMode selects the C library the linux loader resolves imports against.
+type LibcMode uint8
+
+const (
+	// LibcAuto picks LibcMusl when musl's dynamic linker is mapped in the
+	// process and LibcGlibc otherwise.
+	LibcAuto LibcMode = iota
+	// LibcGlibc resolves imports as glibc's ld.so would, binding versioned
+	// symbols to the version the image was linked against.
+	LibcGlibc
+	// LibcMusl satisfies the glibc family of dependencies (libc.so.6,
+	// libpthread, libdl, libm, ld-linux, ...) with musl's single libc and
+	// ignores symbol versions, which musl does not have.
+	LibcMusl
+)
+
+// LoadMode selects how the darwin loader links an image.
+type LoadMode uint8
+
+const (
+	// DarwinDyld registers the image with dyld4 through its private
+	// JustInTimeLoader API, and dyld binds it, loads its dependencies, and
+	// runs its initializers as dlopen would.
+	DarwinDyld LoadMode = iota
+	// DarwinSelfFixup applies the image's LC_DYLD_CHAINED_FIXUPS rebases
+	// and binds in Go, binding imports with dlopen and dlsym, and runs its
+	// initializers itself, without relying on dyld's private symbols. An
+	// arm64e image's authenticated pointers are signed with this process's
+	// keys as they are written. dyld never learns of the image, so dladdr
+	// and _dyld_get_image_name do not report it. Images it cannot link fall
+	// back to DarwinDyld: those without chained fixups, and those using
+	// thread-local variables, Objective-C or Swift metadata, or C++
+	// exception tables, which need dyld's bookkeeping.
+	DarwinSelfFixup
+)
+
+// dllMainReasons returns the notifications opts asks for.
+func (opts LoadOptions) dllMainReasons() DllMainReasons {
+	reasons := opts.DllMain
+	switch {
+	case reasons == 0:
+		reasons = DllMainAttach | DllMainDetach
+	case reasons&DllMainNone != 0:
+		return 0
+	}
+	if opts.SkipInitializers {
+		reasons &^= DllMainAttach
+	}
+	return reasons
+}
+
+// forImage fills in the settings Deterministic implies for data.
+func (opts LoadOptions) forImage(data []byte) LoadOptions {
+	if opts.Deterministic && opts.PreferredBase == 0 && opts.BaseSeed == 0 {
+		opts.BaseSeed = max(imageDigest(data), 1)
+	}
+	return opts
+}
+
+// imageDigest is a stable 64-bit FNV-1a hash of an image.
+func imageDigest(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// baseHint returns the address to request for the image, or zero for the
+// default placement.
+func (opts LoadOptions) baseHint() uintptr {
+	if opts.PreferredBase != 0 || opts.BaseSeed == 0 {
+		return opts.PreferredBase
+	}
+
+	// splitmix64 spreads nearby seeds across the range.
+	x := opts.BaseSeed + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+
+	// Stay well inside the smallest common user address space (39-bit on
+	// some arm64 kernels, 2 GiB on 32-bit windows) and align to the 64 KiB
+	// windows allocation granularity.
+	low, span := uint64(0x10_0000_0000), uint64(0x60_0000_0000)
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		low, span = 0x1000_0000, 0x6000_0000
+	}
+	return uintptr((low + x%span) &^ 0xffff)
+}
@@ -0,0 +1,67 @@
+package memmod
+
+import "testing"
+
+func TestBaseHint(t *testing.T) {
+	if hint := (LoadOptions{}).baseHint(); hint != 0 {
+		t.Fatalf("zero options hint = %#x, want 0", hint)
+	}
+	if hint := (LoadOptions{PreferredBase: 0x40000000, BaseSeed: 1}).baseHint(); hint != 0x40000000 {
+		t.Fatalf("PreferredBase not preferred over BaseSeed: %#x", hint)
+	}
+
+	seen := make(map[uintptr]uint64)
+	for seed := uint64(1); seed <= 64; seed++ {
+		hint := LoadOptions{BaseSeed: seed}.baseHint()
+		if hint == 0 || hint&0xffff != 0 {
+			t.Fatalf("seed %d hint %#x is zero or not 64 KiB aligned", seed, hint)
+		}
+		if again := (LoadOptions{BaseSeed: seed}).baseHint(); again != hint {
+			t.Fatalf("seed %d is not deterministic: %#x then %#x", seed, hint, again)
+		}
+		if other, ok := seen[hint]; ok {
+			t.Fatalf("seeds %d and %d map to the same base %#x", other, seed, hint)
+		}
+		seen[hint] = seed
+	}
+}
+
+func TestDeterministicSeedsBaseFromImage(t *testing.T) {
+	image, other := []byte("\x7fELF image one"), []byte("\x7fELF image two")
+
+	seed := LoadOptions{Deterministic: true}.forImage(image).BaseSeed
+	if seed == 0 || seed != (LoadOptions{Deterministic: true}).forImage(image).BaseSeed {
+		t.Fatalf("image seed %#x is zero or not stable", seed)
+	}
+	if (LoadOptions{Deterministic: true}).forImage(other).BaseSeed == seed {
+		t.Fatal("different images derived the same seed")
+	}
+	if got := (LoadOptions{Deterministic: true, BaseSeed: 7}).forImage(image).BaseSeed; got != 7 {
+		t.Fatalf("explicit BaseSeed replaced: %#x", got)
+	}
+	if got := (LoadOptions{Deterministic: true, PreferredBase: 0x40000000}).forImage(image); got.BaseSeed != 0 || got.baseHint() != 0x40000000 {
+		t.Fatalf("PreferredBase not kept: seed %#x hint %#x", got.BaseSeed, got.baseHint())
+	}
+	if got := (LoadOptions{}).forImage(image).BaseSeed; got != 0 {
+		t.Fatalf("seed %#x derived without Deterministic", got)
+	}
+}
+
+func TestDllMainReasons(t *testing.T) {
+	for _, tc := range []struct {
+		in, want DllMainReasons
+	}{
+		{0, DllMainAttach | DllMainDetach},
+		{DllMainAttach, DllMainAttach},
+		{DllMainAttach | DllMainDetach, DllMainAttach | DllMainDetach},
+		{DllMainNone, 0},
+		{DllMainNone | DllMainAttach, 0},
+	} {
+		if got := (LoadOptions{DllMain: tc.in}).dllMainReasons(); got != tc.want {
+			t.Errorf("dllMainReasons(%#x) = %#x, want %#x", tc.in, got, tc.want)
+		}
+	}
+	if got := (LoadOptions{SkipInitializers: true}).dllMainReasons(); got != DllMainDetach {
+		t.Errorf("SkipInitializers reasons = %#x, want %#x", got, DllMainDetach)
+	}
+}
@@ -0,0 +1,37 @@
+package memmod
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ELF and Mach-O images have no export ordinals, so the linux and darwin
+// loaders emulate them for callers written against the windows API: ordinal
+// N is the Nth name, counting from 1, of the export list sorted by name, as
+// Module.Exports returns it. The numbering is stable for a given image and
+// set of LoadOptions; on linux, LoadOptions.Symbols changes the list and so
+// the numbering.
+
+// parseOrdinalName reports the ordinal a "#N" export name stands for. ok is
+// false for ordinary names.
+func parseOrdinalName(name string) (ordinal uint16, ok bool, err error) {
+	digits, found := strings.CutPrefix(strings.TrimSpace(name), "#")
+	if !found {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid export ordinal %q", name)
+	}
+	return uint16(n), true, nil
+}
+
+// emulatedOrdinalName returns the export that ordinal stands for among
+// sorted, the image's export names in sorted order.
+func emulatedOrdinalName(sorted []string, ordinal uint16) (string, error) {
+	if ordinal == 0 || int(ordinal) > len(sorted) {
+		return "", fmt.Errorf("%w: ordinal %d is outside the %d emulated export ordinals", ErrSymbolNotFound, ordinal, len(sorted))
+	}
+	return sorted[ordinal-1], nil
+}
@@ -0,0 +1,35 @@
+package memmod
+
+import "testing"
+
+func TestEmulatedOrdinals(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ordinal uint16
+		ok      bool
+		wantErr bool
+	}{
+		{"reflektor_start", 0, false, false},
+		{"#1", 1, true, false},
+		{" #65535 ", 65535, true, false},
+		{"#65536", 0, true, true},
+		{"#start", 0, true, true},
+	} {
+		ordinal, ok, err := parseOrdinalName(tc.name)
+		if ordinal != tc.ordinal || ok != tc.ok || (err != nil) != tc.wantErr {
+			t.Errorf("parseOrdinalName(%q) = %d, %v, %v; want %d, %v, error %v", tc.name, ordinal, ok, err, tc.ordinal, tc.ok, tc.wantErr)
+		}
+	}
+
+	sorted := []string{"alpha", "beta", "gamma"}
+	for ordinal, want := range map[uint16]string{1: "alpha", 3: "gamma"} {
+		if got, err := emulatedOrdinalName(sorted, ordinal); err != nil || got != want {
+			t.Errorf("emulatedOrdinalName(%d) = %q, %v; want %q", ordinal, got, err, want)
+		}
+	}
+	for _, ordinal := range []uint16{0, 4} {
+		if _, err := emulatedOrdinalName(sorted, ordinal); err == nil {
+			t.Errorf("emulatedOrdinalName(%d) succeeded for %d exports", ordinal, len(sorted))
+		}
+	}
+}
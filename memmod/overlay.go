@@ -0,0 +1,137 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+)
+
+// peDirectoryEntrySecurity is IMAGE_DIRECTORY_ENTRY_SECURITY, whose address
+// is a file offset rather than an RVA.
+const peDirectoryEntrySecurity = 4
+
+// ImageOverlay returns the data appended to an ELF, PE, or Mach-O image after
+// the last byte its headers account for, or nil when there is none. Builders
+// and packers use this overlay to carry configuration alongside a payload.
+// For PE images the COFF symbol table and the certificate table count as
+// part of the image; for fat Mach-O files every slice does. The result
+// aliases data.
+func ImageOverlay(data []byte) ([]byte, error) {
+	end, err := imageEnd(data)
+	if err != nil {
+		return nil, err
+	}
+	if end >= uint64(len(data)) {
+		return nil, nil
+	}
+	return data[end:], nil
+}
+
+// imageEnd returns the file offset just past the image's last accounted-for
+// byte.
+func imageEnd(data []byte) (uint64, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return elfEnd(data)
+	case bytes.HasPrefix(data, []byte("MZ")):
+		return peEnd(data)
+	}
+	if fat, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
+		defer fat.Close()
+		var end uint64
+		for _, arch := range fat.Arches {
+			end = max(end, uint64(arch.Offset)+uint64(arch.Size))
+		}
+		return end, nil
+	}
+	if f, err := macho.NewFile(bytes.NewReader(data)); err == nil {
+		defer f.Close()
+		var end uint64
+		for _, load := range f.Loads {
+			if seg, ok := load.(*macho.Segment); ok {
+				end = max(end, seg.Offset+seg.Filesz)
+			}
+		}
+		return end, nil
+	}
+	return 0, errors.New("unrecognized image format")
+}
+
+func elfEnd(data []byte) (uint64, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("parse ELF image: %w", err)
+	}
+	defer f.Close()
+
+	// debug/elf does not keep where the header tables are, so read the
+	// offsets and counts from the header itself.
+	order := f.ByteOrder
+	var end, phoff, shoff, phsize, shsize uint64
+	if f.Class == elf.ELFCLASS64 {
+		end = 64
+		phoff, shoff = order.Uint64(data[0x20:]), order.Uint64(data[0x28:])
+		phsize = uint64(order.Uint16(data[0x36:])) * uint64(order.Uint16(data[0x38:]))
+		shsize = uint64(order.Uint16(data[0x3a:])) * uint64(order.Uint16(data[0x3c:]))
+	} else {
+		end = 52
+		phoff, shoff = uint64(order.Uint32(data[0x1c:])), uint64(order.Uint32(data[0x20:]))
+		phsize = uint64(order.Uint16(data[0x2a:])) * uint64(order.Uint16(data[0x2c:]))
+		shsize = uint64(order.Uint16(data[0x2e:])) * uint64(order.Uint16(data[0x30:]))
+	}
+	if phoff != 0 {
+		end = max(end, phoff+phsize)
+	}
+	if shoff != 0 {
+		end = max(end, shoff+shsize)
+	}
+	for _, prog := range f.Progs {
+		end = max(end, prog.Off+prog.Filesz)
+	}
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_NOBITS {
+			end = max(end, section.Offset+section.FileSize)
+		}
+	}
+	return end, nil
+}
+
+func peEnd(data []byte) (uint64, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("parse PE image: %w", err)
+	}
+	defer f.Close()
+
+	var end uint64
+	var security pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		end = uint64(header.SizeOfHeaders)
+		if header.NumberOfRvaAndSizes > peDirectoryEntrySecurity {
+			security = header.DataDirectory[peDirectoryEntrySecurity]
+		}
+	case *pe.OptionalHeader64:
+		end = uint64(header.SizeOfHeaders)
+		if header.NumberOfRvaAndSizes > peDirectoryEntrySecurity {
+			security = header.DataDirectory[peDirectoryEntrySecurity]
+		}
+	}
+	for _, section := range f.Sections {
+		if section.Offset != 0 {
+			end = max(end, uint64(section.Offset)+uint64(section.Size))
+		}
+	}
+	if f.FileHeader.PointerToSymbolTable != 0 {
+		// The string table, with its 4-byte length, follows the symbols.
+		symbols := uint64(f.FileHeader.PointerToSymbolTable) + uint64(f.FileHeader.NumberOfSymbols)*peSymbolSize
+		end = max(end, symbols+4+uint64(len(f.StringTable)))
+	}
+	if security.VirtualAddress != 0 {
+		end = max(end, uint64(security.VirtualAddress)+uint64(security.Size))
+	}
+	return end, nil
+}
@@ -0,0 +1,43 @@
+package memmod
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestImageOverlay(t *testing.T) {
+	images := map[string][]byte{"pe": buildStrippedPE()}
+	if exe, err := os.Executable(); err == nil {
+		if data, err := os.ReadFile(exe); err == nil {
+			images["test binary"] = data
+		}
+	}
+
+	trailer := []byte("reflektor-config\x00\x01\x02")
+	for name, image := range images {
+		if overlay, err := ImageOverlay(image); err != nil || overlay != nil {
+			t.Fatalf("%s: ImageOverlay = %d bytes, %v; want none", name, len(overlay), err)
+		}
+
+		withOverlay := append(bytes.Clone(image), trailer...)
+		overlay, err := ImageOverlay(withOverlay)
+		if err != nil {
+			t.Fatalf("%s: ImageOverlay: %v", name, err)
+		}
+		if !bytes.Equal(overlay, trailer) {
+			t.Fatalf("%s: ImageOverlay = %q, want %q", name, overlay, trailer)
+		}
+		info, err := InspectImage(withOverlay)
+		if err != nil {
+			t.Fatalf("%s: InspectImage: %v", name, err)
+		}
+		if info.Overlay != len(trailer) || info.OverlayOffset != len(image) {
+			t.Fatalf("%s: InspectImage overlay = %d bytes at %#x, want %d at %#x", name, info.Overlay, info.OverlayOffset, len(trailer), len(image))
+		}
+	}
+
+	if _, err := ImageOverlay([]byte("not an image")); err == nil {
+		t.Fatal("ImageOverlay accepted garbage")
+	}
+}
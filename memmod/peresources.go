@@ -0,0 +1,134 @@
+package memmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrResourceNotFound is returned by Module.Resource when the image has no
+// resource of the requested type and name.
+var ErrResourceNotFound = errors.New("resource not found")
+
+// ResourceTypeRCData is RT_RCDATA, the type of raw data resources, under
+// which packers usually store nested payloads.
+const ResourceTypeRCData = "#10"
+
+const (
+	// resourceDirectorySize is sizeof(IMAGE_RESOURCE_DIRECTORY); its
+	// IMAGE_RESOURCE_DIRECTORY_ENTRY records follow it.
+	resourceDirectorySize = 16
+	resourceEntrySize     = 8
+	// resourceDataEntrySize is sizeof(IMAGE_RESOURCE_DATA_ENTRY).
+	resourceDataEntrySize = 16
+	// resourceHighBit marks an entry's name as a string offset and its
+	// target as a subdirectory.
+	resourceHighBit = 0x80000000
+)
+
+var errMalformedResources = errors.New("malformed resource directory")
+
+// peResource finds the resource of type resType named name in the resource
+// directory at RVA directory of the mapped image, and returns its data as a
+// subslice of image. Types and names are strings, compared without regard to
+// case, or "#n" for numeric IDs, as FindResource takes them. Of the
+// resource's languages the first is returned, which is the neutral one when
+// there is one.
+func peResource(image []byte, directory uint32, resType, name string) ([]byte, error) {
+	if directory == 0 || uint64(directory) >= uint64(len(image)) {
+		return nil, fmt.Errorf("%w: image has no resource directory", ErrResourceNotFound)
+	}
+	root := image[directory:]
+
+	offset := uint32(0)
+	for level, want := range []string{resType, name, ""} {
+		target, err := resourceDirectoryEntry(root, offset, want)
+		if errors.Is(err, ErrResourceNotFound) {
+			return nil, fmt.Errorf("%w: type %q, name %q", ErrResourceNotFound, resType, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Type and name entries lead to subdirectories, language entries
+		// to data.
+		if (target&resourceHighBit != 0) != (level < 2) {
+			return nil, errMalformedResources
+		}
+		offset = target &^ resourceHighBit
+	}
+
+	if uint64(offset)+resourceDataEntrySize > uint64(len(root)) {
+		return nil, errMalformedResources
+	}
+	rva := binary.LittleEndian.Uint32(root[offset:])
+	size := binary.LittleEndian.Uint32(root[offset+4:])
+	if uint64(rva)+uint64(size) > uint64(len(image)) {
+		return nil, fmt.Errorf("resource data at rva %#x size %#x is outside the image", rva, size)
+	}
+	return image[rva : rva+size], nil
+}
+
+// resourceDirectoryEntry returns the target of the entry matching want in the
+// directory at offset, or of its first entry when want is empty.
+func resourceDirectoryEntry(root []byte, offset uint32, want string) (uint32, error) {
+	if uint64(offset)+resourceDirectorySize > uint64(len(root)) {
+		return 0, errMalformedResources
+	}
+	count := uint64(binary.LittleEndian.Uint16(root[offset+12:])) + uint64(binary.LittleEndian.Uint16(root[offset+14:]))
+	entries := uint64(offset) + resourceDirectorySize
+	if entries+count*resourceEntrySize > uint64(len(root)) {
+		return 0, errMalformedResources
+	}
+
+	wantID, byID := parseResourceID(want)
+	for i := uint64(0); i < count; i++ {
+		entry := root[entries+i*resourceEntrySize:]
+		nameField, target := binary.LittleEndian.Uint32(entry), binary.LittleEndian.Uint32(entry[4:])
+		switch {
+		case want == "":
+			return target, nil
+		case nameField&resourceHighBit == 0:
+			if byID && nameField == uint32(wantID) {
+				return target, nil
+			}
+		case !byID:
+			entryName, ok := resourceString(root, nameField&^resourceHighBit)
+			if !ok {
+				return 0, errMalformedResources
+			}
+			if strings.EqualFold(entryName, want) {
+				return target, nil
+			}
+		}
+	}
+	return 0, ErrResourceNotFound
+}
+
+// parseResourceID reports the numeric ID a "#n" type or name stands for.
+func parseResourceID(s string) (uint16, bool) {
+	if !strings.HasPrefix(s, "#") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s[1:], 10, 16)
+	return uint16(id), err == nil
+}
+
+// resourceString decodes the IMAGE_RESOURCE_DIR_STRING_U at offset.
+func resourceString(root []byte, offset uint32) (string, bool) {
+	if uint64(offset)+2 > uint64(len(root)) {
+		return "", false
+	}
+	length := uint64(binary.LittleEndian.Uint16(root[offset:]))
+	start := uint64(offset) + 2
+	if start+2*length > uint64(len(root)) {
+		return "", false
+	}
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(root[start+2*uint64(i):])
+	}
+	return string(utf16.Decode(units)), true
+}
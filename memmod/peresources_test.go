@@ -0,0 +1,78 @@
+package memmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// buildResourceImage returns a mapped image whose resource directory, at RVA
+// 0x100, holds one RT_RCDATA resource named PAYLOAD in language 0x409, with
+// data at RVA 0x300.
+func buildResourceImage(data string) []byte {
+	le := binary.LittleEndian
+	image := make([]byte, 0x400)
+	root := image[0x100:]
+
+	// Type directory: one ID entry, RT_RCDATA.
+	le.PutUint16(root[0x0e:], 1)
+	le.PutUint32(root[0x10:], 10)
+	le.PutUint32(root[0x14:], resourceHighBit|0x18)
+	// Name directory: one named entry.
+	le.PutUint16(root[0x18+0x0c:], 1)
+	le.PutUint32(root[0x18+0x10:], resourceHighBit|0x70)
+	le.PutUint32(root[0x18+0x14:], resourceHighBit|0x30)
+	// Language directory: one ID entry pointing at the data entry.
+	le.PutUint16(root[0x30+0x0e:], 1)
+	le.PutUint32(root[0x30+0x10:], 0x409)
+	le.PutUint32(root[0x30+0x14:], 0x50)
+	// Data entry.
+	le.PutUint32(root[0x50:], 0x300)
+	le.PutUint32(root[0x54:], uint32(len(data)))
+	// Name string.
+	name := "PAYLOAD"
+	le.PutUint16(root[0x70:], uint16(len(name)))
+	for i, r := range name {
+		le.PutUint16(root[0x72+2*i:], uint16(r))
+	}
+
+	copy(image[0x300:], data)
+	return image
+}
+
+func TestPEResource(t *testing.T) {
+	image := buildResourceImage("nested")
+
+	got, err := peResource(image, 0x100, ResourceTypeRCData, "payload")
+	if err != nil {
+		t.Fatalf("peResource(RT_RCDATA, payload): %v", err)
+	}
+	if string(got) != "nested" {
+		t.Fatalf("peResource(RT_RCDATA, payload) = %q, want %q", got, "nested")
+	}
+
+	for _, tc := range []struct{ resType, name string }{
+		{ResourceTypeRCData, "other"},
+		{"#3", "PAYLOAD"},
+		{"RCDATA", "PAYLOAD"},
+		{ResourceTypeRCData, "#1"},
+	} {
+		if _, err := peResource(image, 0x100, tc.resType, tc.name); !errors.Is(err, ErrResourceNotFound) {
+			t.Errorf("peResource(%q, %q) error = %v, want ErrResourceNotFound", tc.resType, tc.name, err)
+		}
+	}
+	if _, err := peResource(image, 0, ResourceTypeRCData, "PAYLOAD"); !errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("peResource without a directory: error = %v, want ErrResourceNotFound", err)
+	}
+
+	// Data that runs past the image is an error, not a short read.
+	binary.LittleEndian.PutUint32(image[0x100+0x54:], 0x200)
+	if _, err := peResource(image, 0x100, ResourceTypeRCData, "PAYLOAD"); err == nil || errors.Is(err, ErrResourceNotFound) {
+		t.Errorf("peResource with out-of-bounds data: error = %v, want a malformed-directory error", err)
+	}
+	// A name directory that points straight at data is malformed.
+	binary.LittleEndian.PutUint32(image[0x100+0x14:], 0x50)
+	if _, err := peResource(image, 0x100, ResourceTypeRCData, "PAYLOAD"); !errors.Is(err, errMalformedResources) {
+		t.Errorf("peResource with a misplaced data entry: error = %v, want errMalformedResources", err)
+	}
+}
@@ -0,0 +1,23 @@
+package memmod
+
+// RelocationCheck is the result of LoadOptions.VerifyRelocations.
+type RelocationCheck struct {
+	// Checked counts the relocations whose slots were compared.
+	Checked int
+	// Mismatches lists the slots that do not hold the expected value.
+	Mismatches []RelocationMismatch
+}
+
+// RelocationMismatch is a relocation slot that does not hold the value the
+// loader should have stored there.
+type RelocationMismatch struct {
+	// Offset is the slot's virtual address in the image, before the load
+	// bias is added.
+	Offset uint64
+	// Type names the relocation type, such as R_X86_64_GLOB_DAT.
+	Type string
+	// Symbol is the symbol the relocation refers to, or empty.
+	Symbol string
+	Want   uint64
+	Got    uint64
+}
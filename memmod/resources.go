@@ -0,0 +1,21 @@
+package memmod
+
+// ResourceUsage is what a payload loaded with LoadOptions.TrackResources
+// holds that it did not hold before its load began. The process is measured
+// as a whole, so heaps and handles opened meanwhile by other code count too;
+// threads are attributed to the image by their start address.
+type ResourceUsage struct {
+	// Threads lists the IDs of running threads that started inside the
+	// image. Unloading while any remain leaves them executing unmapped code.
+	Threads []uint32
+	// Heaps lists the heaps created since the load began that still exist.
+	Heaps []uintptr
+	// Handles is how many more handles the process has open than it had when
+	// the load began.
+	Handles int
+}
+
+// Leaked reports whether the payload holds any threads, heaps, or handles.
+func (usage *ResourceUsage) Leaked() bool {
+	return usage != nil && (len(usage.Threads) != 0 || len(usage.Heaps) != 0 || usage.Handles > 0)
+}
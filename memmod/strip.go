@@ -0,0 +1,747 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	machOLoadCodeSignature = 0x1d
+	machOLoadSymtab        = 0x2
+	machOLoadDysymtab      = 0xb
+	machOLoadSegment       = 0x1
+	machOLoadSegment64     = 0x19
+)
+
+// StripImage returns a copy of an ELF, PE, or Mach-O image with content the
+// loader never reads removed:
+//
+//   - ELF: debug sections, .symtab/.strtab, and .comment.
+//   - PE: the COFF symbol table, trailing DWARF sections, the certificate
+//     table, and debug directory records. Trailing overlay data is dropped.
+//   - Mach-O: the code signature and local (including stab) symbols. Fat
+//     files are stripped slice by slice. The __DWARF segment the Go linker
+//     writes is left in place; link with -ldflags=-w to omit it.
+//
+// The result must pass ValidateImage and keep the same exports as the input,
+// otherwise an error is returned and no image is produced. Stripping a Mach-O
+// code signature means the image has to be re-signed before it can be loaded
+// by a loader that enforces signatures.
+func StripImage(data []byte) ([]byte, error) {
+	before, err := InspectHeaders(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	switch before.Format {
+	case "elf":
+		out, err = stripELF(data)
+	case "pe":
+		out, err = stripPE(data)
+	case "macho":
+		out, err = stripMachOFile(data)
+	default:
+		err = fmt.Errorf("unsupported image format %q", before.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateImage(out); err != nil {
+		return nil, fmt.Errorf("stripped image failed validation: %w", err)
+	}
+	after, err := InspectHeaders(out)
+	if err != nil {
+		return nil, fmt.Errorf("stripped image failed validation: %w", err)
+	}
+	if !sameExports(before.Exports, after.Exports) {
+		return nil, errors.New("stripped image failed validation: exports changed")
+	}
+	return out, nil
+}
+
+func sameExports(a, b []ExportInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// elfSection is a section header in a width-independent form.
+type elfSection struct {
+	name    uint32
+	typ     uint32
+	flags   uint64
+	addr    uint64
+	offset  uint64
+	size    uint64
+	link    uint32
+	info    uint32
+	align   uint64
+	entsize uint64
+}
+
+// elfStrippable reports whether a non-allocated section is only used by
+// debuggers and static tooling.
+func elfStrippable(name string) bool {
+	for _, prefix := range []string{".debug", ".zdebug", ".gnu.debug", ".stab"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	switch name {
+	case ".symtab", ".strtab", ".symtab_shndx", ".comment", ".gnu_debuglink", ".gnu_debugaltlink":
+		return true
+	}
+	return false
+}
+
+func stripELF(data []byte) ([]byte, error) {
+	if len(data) < 0x34 || data[elf.EI_DATA] != byte(elf.ELFDATA2LSB) {
+		return nil, errors.New("only little-endian ELF images can be stripped")
+	}
+	order := binary.LittleEndian
+	is64 := data[elf.EI_CLASS] == byte(elf.ELFCLASS64)
+	if is64 && len(data) < 0x40 {
+		return nil, errors.New("ELF header is truncated")
+	}
+
+	var phoff, shoff uint64
+	var phentsize, phnum, shentsize, shnum, shstrndx uint16
+	if is64 {
+		phoff, shoff = order.Uint64(data[0x20:]), order.Uint64(data[0x28:])
+		phentsize, phnum = order.Uint16(data[0x36:]), order.Uint16(data[0x38:])
+		shentsize, shnum, shstrndx = order.Uint16(data[0x3a:]), order.Uint16(data[0x3c:]), order.Uint16(data[0x3e:])
+	} else {
+		phoff, shoff = uint64(order.Uint32(data[0x1c:])), uint64(order.Uint32(data[0x20:]))
+		phentsize, phnum = order.Uint16(data[0x2a:]), order.Uint16(data[0x2c:])
+		shentsize, shnum, shstrndx = order.Uint16(data[0x2e:]), order.Uint16(data[0x30:]), order.Uint16(data[0x32:])
+	}
+	if shoff == 0 || shnum == 0 {
+		return append([]byte(nil), data...), nil
+	}
+	if shoff+uint64(shnum)*uint64(shentsize) > uint64(len(data)) || shstrndx >= shnum {
+		return nil, errors.New("ELF section header table is out of range")
+	}
+
+	sections := make([]elfSection, shnum)
+	for i := range sections {
+		raw := data[shoff+uint64(i)*uint64(shentsize):]
+		s := &sections[i]
+		s.name, s.typ = order.Uint32(raw[0:]), order.Uint32(raw[4:])
+		if is64 {
+			s.flags, s.addr, s.offset, s.size = order.Uint64(raw[8:]), order.Uint64(raw[16:]), order.Uint64(raw[24:]), order.Uint64(raw[32:])
+			s.link, s.info = order.Uint32(raw[40:]), order.Uint32(raw[44:])
+			s.align, s.entsize = order.Uint64(raw[48:]), order.Uint64(raw[56:])
+		} else {
+			s.flags, s.addr, s.offset, s.size = uint64(order.Uint32(raw[8:])), uint64(order.Uint32(raw[12:])), uint64(order.Uint32(raw[16:])), uint64(order.Uint32(raw[20:]))
+			s.link, s.info = order.Uint32(raw[24:]), order.Uint32(raw[28:])
+			s.align, s.entsize = uint64(order.Uint32(raw[32:])), uint64(order.Uint32(raw[36:]))
+		}
+		if s.typ != uint32(elf.SHT_NOBITS) && s.offset+s.size > uint64(len(data)) {
+			return nil, fmt.Errorf("ELF section %d is out of range", i)
+		}
+	}
+	shstr := sections[shstrndx]
+	names := data[shstr.offset : shstr.offset+shstr.size]
+	nameOf := func(s elfSection) string {
+		if uint64(s.name) >= uint64(len(names)) {
+			return ""
+		}
+		name := names[s.name:]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		return string(name)
+	}
+
+	alloc := func(s elfSection) bool { return s.flags&uint64(elf.SHF_ALLOC) != 0 }
+	keep := make([]bool, shnum)
+	for i, s := range sections {
+		keep[i] = i == 0 || i == int(shstrndx) || alloc(s) || !elfStrippable(nameOf(s))
+	}
+	// Drop non-allocated sections that point at removed ones, such as
+	// relocations for debug sections.
+	for changed := true; changed; {
+		changed = false
+		for i, s := range sections {
+			if !keep[i] || i == 0 {
+				continue
+			}
+			dangling := s.link != 0 && s.link < uint32(shnum) && !keep[s.link]
+			if s.typ == uint32(elf.SHT_REL) || s.typ == uint32(elf.SHT_RELA) {
+				dangling = dangling || (s.info != 0 && s.info < uint32(shnum) && !keep[s.info])
+			}
+			if !dangling {
+				continue
+			}
+			if alloc(s) {
+				return nil, fmt.Errorf("ELF section %s refers to a stripped section", nameOf(s))
+			}
+			keep[i] = false
+			changed = true
+		}
+	}
+
+	// Everything the program headers and allocated sections cover is copied
+	// byte for byte; only the non-allocated tail is rebuilt.
+	loadEnd := phoff + uint64(phnum)*uint64(phentsize)
+	for i := uint64(0); i < uint64(phnum); i++ {
+		raw := data[phoff+i*uint64(phentsize):]
+		var off, filesz uint64
+		if is64 {
+			off, filesz = order.Uint64(raw[8:]), order.Uint64(raw[32:])
+		} else {
+			off, filesz = uint64(order.Uint32(raw[4:])), uint64(order.Uint32(raw[16:]))
+		}
+		loadEnd = max(loadEnd, off+filesz)
+	}
+	for _, s := range sections {
+		if alloc(s) && s.typ != uint32(elf.SHT_NOBITS) {
+			loadEnd = max(loadEnd, s.offset+s.size)
+		}
+	}
+	if loadEnd > uint64(len(data)) {
+		return nil, errors.New("ELF program headers are out of range")
+	}
+
+	out := append([]byte(nil), data[:loadEnd]...)
+	align := func(n uint64) {
+		if n > 1 {
+			for uint64(len(out))%n != 0 {
+				out = append(out, 0)
+			}
+		}
+	}
+
+	newIndex := make([]uint32, shnum)
+	var kept []elfSection
+	newNames := []byte{0}
+	newShstrndx := uint16(0)
+	for i, s := range sections {
+		if !keep[i] {
+			continue
+		}
+		newIndex[i] = uint32(len(kept))
+		if i != 0 {
+			name := nameOf(s)
+			s.name = uint32(len(newNames))
+			newNames = append(append(newNames, name...), 0)
+		}
+		if i == int(shstrndx) {
+			newShstrndx = uint16(len(kept))
+		} else if i != 0 && !alloc(s) && s.typ != uint32(elf.SHT_NOBITS) && s.offset+s.size > loadEnd {
+			align(s.align)
+			content := data[s.offset : s.offset+s.size]
+			s.offset = uint64(len(out))
+			out = append(out, content...)
+		}
+		kept = append(kept, s)
+	}
+	for i := range kept {
+		s := &kept[i]
+		if s.link != 0 && s.link < uint32(shnum) {
+			s.link = newIndex[s.link]
+		}
+		if (s.typ == uint32(elf.SHT_REL) || s.typ == uint32(elf.SHT_RELA) || s.flags&uint64(elf.SHF_INFO_LINK) != 0) && s.info != 0 && s.info < uint32(shnum) {
+			s.info = newIndex[s.info]
+		}
+	}
+	kept[newShstrndx].offset = uint64(len(out))
+	kept[newShstrndx].size = uint64(len(newNames))
+	out = append(out, newNames...)
+
+	if is64 {
+		align(8)
+	} else {
+		align(4)
+	}
+	newShoff := uint64(len(out))
+	for _, s := range kept {
+		raw := make([]byte, shentsize)
+		order.PutUint32(raw[0:], s.name)
+		order.PutUint32(raw[4:], s.typ)
+		if is64 {
+			order.PutUint64(raw[8:], s.flags)
+			order.PutUint64(raw[16:], s.addr)
+			order.PutUint64(raw[24:], s.offset)
+			order.PutUint64(raw[32:], s.size)
+			order.PutUint32(raw[40:], s.link)
+			order.PutUint32(raw[44:], s.info)
+			order.PutUint64(raw[48:], s.align)
+			order.PutUint64(raw[56:], s.entsize)
+		} else {
+			order.PutUint32(raw[8:], uint32(s.flags))
+			order.PutUint32(raw[12:], uint32(s.addr))
+			order.PutUint32(raw[16:], uint32(s.offset))
+			order.PutUint32(raw[20:], uint32(s.size))
+			order.PutUint32(raw[24:], s.link)
+			order.PutUint32(raw[28:], s.info)
+			order.PutUint32(raw[32:], uint32(s.align))
+			order.PutUint32(raw[36:], uint32(s.entsize))
+		}
+		out = append(out, raw...)
+	}
+
+	if is64 {
+		order.PutUint64(out[0x28:], newShoff)
+		order.PutUint16(out[0x3c:], uint16(len(kept)))
+		order.PutUint16(out[0x3e:], newShstrndx)
+	} else {
+		order.PutUint32(out[0x20:], uint32(newShoff))
+		order.PutUint16(out[0x30:], uint16(len(kept)))
+		order.PutUint16(out[0x32:], newShstrndx)
+	}
+	return out, nil
+}
+
+const (
+	peDirectorySecurity = 4
+	peDirectoryDebug    = 6
+	peSectionHeaderSize = 40
+	peSymbolSize        = 18
+)
+
+func stripPE(data []byte) ([]byte, error) {
+	order := binary.LittleEndian
+	if len(data) < 0x40 {
+		return nil, errors.New("PE header is truncated")
+	}
+	image := append([]byte(nil), data...)
+
+	ntOffset := uint64(order.Uint32(image[0x3c:]))
+	fileHeader := ntOffset + 4
+	optional := fileHeader + 20
+	if optional+2 > uint64(len(image)) {
+		return nil, errors.New("PE header is truncated")
+	}
+	numSections := uint64(order.Uint16(image[fileHeader+2:]))
+	symbolTable := uint64(order.Uint32(image[fileHeader+8:]))
+	numSymbols := uint64(order.Uint32(image[fileHeader+12:]))
+	optionalSize := uint64(order.Uint16(image[fileHeader+16:]))
+	sectionTable := optional + optionalSize
+
+	var directories, numDirectories uint64
+	switch order.Uint16(image[optional:]) {
+	case 0x10b:
+		directories, numDirectories = optional+96, uint64(order.Uint32(image[optional+92:]))
+	case 0x20b:
+		directories, numDirectories = optional+112, uint64(order.Uint32(image[optional+108:]))
+	default:
+		return nil, errors.New("PE optional header has an unknown magic")
+	}
+	if sectionTable+numSections*peSectionHeaderSize > uint64(len(image)) || directories+numDirectories*8 > sectionTable {
+		return nil, errors.New("PE headers are out of range")
+	}
+	fileAlignment := max(uint64(order.Uint32(image[optional+36:])), 1)
+	sizeOfHeaders := uint64(order.Uint32(image[optional+60:]))
+	if sizeOfHeaders > uint64(len(image)) {
+		return nil, errors.New("PE headers are out of range")
+	}
+	header := func(i uint64) []byte {
+		return image[sectionTable+i*peSectionHeaderSize:][:peSectionHeaderSize]
+	}
+
+	var stringTable []byte
+	if symbolTable != 0 && symbolTable+numSymbols*peSymbolSize+4 <= uint64(len(image)) {
+		start := symbolTable + numSymbols*peSymbolSize
+		size := uint64(order.Uint32(image[start:]))
+		if start+size <= uint64(len(image)) {
+			stringTable = image[start : start+size]
+		}
+	}
+	sectionName := func(i uint64) string {
+		name := string(bytes.TrimRight(header(i)[:8], "\x00"))
+		if strings.HasPrefix(name, "/") {
+			var offset uint64
+			if _, err := fmt.Sscanf(name[1:], "%d", &offset); err == nil && offset < uint64(len(stringTable)) {
+				long := stringTable[offset:]
+				if end := bytes.IndexByte(long, 0); end >= 0 {
+					long = long[:end]
+				}
+				return string(long)
+			}
+		}
+		return name
+	}
+	strippable := func(i uint64) bool {
+		name := sectionName(i)
+		// The Go linker wraps the COFF symbol table in a .symtab section.
+		return isDebugSection(name) || (symbolTable != 0 && uint64(order.Uint32(header(i)[20:])) == symbolTable)
+	}
+
+	// Debug directory records point at file offsets, so clear them before
+	// sections move.
+	if numDirectories > peDirectoryDebug {
+		debug := directories + peDirectoryDebug*8
+		rva, size := order.Uint32(image[debug:]), order.Uint32(image[debug+4:])
+		if offset, ok := peRVAToOffset(image, sectionTable, numSections, rva); ok && offset+uint64(size) <= uint64(len(image)) {
+			for entry := offset; entry+28 <= offset+uint64(size); entry += 28 {
+				rawSize := uint64(order.Uint32(image[entry+16:]))
+				rawOffset := uint64(order.Uint32(image[entry+24:]))
+				if rawOffset != 0 && rawOffset+rawSize <= uint64(len(image)) {
+					clear(image[rawOffset : rawOffset+rawSize])
+				}
+			}
+			clear(image[offset : offset+uint64(size)])
+		}
+		clear(image[debug : debug+8])
+	}
+	if numDirectories > peDirectorySecurity {
+		clear(image[directories+peDirectorySecurity*8:][:8])
+	}
+
+	// Sections at the end of the address space can go entirely. Section
+	// addresses must stay adjacent, so stripped sections elsewhere keep
+	// their header and address range and only lose their file data.
+	originalSections := numSections
+	for numSections > 1 && strippable(numSections-1) {
+		clear(header(numSections - 1))
+		numSections--
+	}
+	var drop []bool
+	for i := uint64(0); i < numSections; i++ {
+		drop = append(drop, strippable(i))
+	}
+	keepSymbols := false
+	for i := uint64(0); i < numSections; i++ {
+		if drop[i] {
+			name := sectionName(i)
+			clear(header(i)[:8])
+			copy(header(i)[:8], name)
+			order.PutUint32(header(i)[16:], 0)
+			order.PutUint32(header(i)[20:], 0)
+		} else if header(i)[0] == '/' {
+			keepSymbols = true
+		}
+	}
+
+	// Lay the file out again: headers, then each section's raw data in its
+	// original order, then the symbol table if a section name needs it.
+	order.PutUint16(image[fileHeader+2:], uint16(numSections))
+	out := append([]byte(nil), image[:sizeOfHeaders]...)
+	var byOffset []uint64
+	for i := uint64(0); i < numSections; i++ {
+		if order.Uint32(header(i)[16:]) != 0 && order.Uint32(header(i)[20:]) != 0 {
+			byOffset = append(byOffset, i)
+		}
+	}
+	sort.Slice(byOffset, func(a, b int) bool {
+		return order.Uint32(header(byOffset[a])[20:]) < order.Uint32(header(byOffset[b])[20:])
+	})
+	for _, i := range byOffset {
+		rawSize, rawOffset := uint64(order.Uint32(header(i)[16:])), uint64(order.Uint32(header(i)[20:]))
+		if rawOffset+rawSize > uint64(len(image)) {
+			return nil, errors.New("PE section data is out of range")
+		}
+		for uint64(len(out))%fileAlignment != 0 {
+			out = append(out, 0)
+		}
+		order.PutUint32(out[sectionTable+i*peSectionHeaderSize+20:], uint32(len(out)))
+		out = append(out, image[rawOffset:rawOffset+rawSize]...)
+	}
+	if keepSymbols && stringTable != nil {
+		order.PutUint32(out[fileHeader+8:], uint32(len(out)))
+		out = append(out, image[symbolTable:symbolTable+numSymbols*peSymbolSize]...)
+		out = append(out, stringTable...)
+	} else {
+		order.PutUint32(out[fileHeader+8:], 0)
+		order.PutUint32(out[fileHeader+12:], 0)
+	}
+
+	if numSections != originalSections {
+		var imageEnd uint64
+		sectionAlignment := max(uint64(order.Uint32(out[optional+32:])), 1)
+		for i := uint64(0); i < numSections; i++ {
+			h := out[sectionTable+i*peSectionHeaderSize:]
+			virtualSize, va := uint64(order.Uint32(h[8:])), uint64(order.Uint32(h[12:]))
+			if virtualSize == 0 {
+				virtualSize = uint64(order.Uint32(h[16:]))
+			}
+			imageEnd = max(imageEnd, va+virtualSize)
+		}
+		order.PutUint32(out[optional+56:], uint32((imageEnd+sectionAlignment-1)&^(sectionAlignment-1)))
+	}
+	order.PutUint32(out[optional+64:], peChecksum(out, optional+64))
+	return out, nil
+}
+
+func peRVAToOffset(data []byte, sectionTable, numSections uint64, rva uint32) (uint64, bool) {
+	if rva == 0 {
+		return 0, false
+	}
+	for i := uint64(0); i < numSections; i++ {
+		header := data[sectionTable+i*peSectionHeaderSize:]
+		va, rawSize, rawOffset := binary.LittleEndian.Uint32(header[12:]), binary.LittleEndian.Uint32(header[16:]), binary.LittleEndian.Uint32(header[20:])
+		if rva >= va && rva < va+rawSize {
+			return uint64(rawOffset) + uint64(rva-va), true
+		}
+	}
+	return 0, false
+}
+
+// peChecksum computes the optional header CheckSum the way imagehlp does,
+// skipping the checksum field itself.
+func peChecksum(data []byte, checksumOffset uint64) uint32 {
+	var sum uint64
+	for i := uint64(0); i < uint64(len(data)); i += 2 {
+		if i == checksumOffset || i == checksumOffset+2 {
+			continue
+		}
+		word := uint64(data[i])
+		if i+1 < uint64(len(data)) {
+			word |= uint64(data[i+1]) << 8
+		}
+		sum += word
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	sum = (sum & 0xffff) + (sum >> 16)
+	return uint32(sum) + uint32(len(data))
+}
+
+func stripMachOFile(data []byte) ([]byte, error) {
+	fat, err := macho.NewFatFile(bytes.NewReader(data))
+	if err != nil {
+		return stripMachO(data)
+	}
+	defer fat.Close()
+
+	// Rebuild the fat container around the stripped slices, keeping each
+	// slice's alignment.
+	order := binary.BigEndian
+	header := 8 + len(fat.Arches)*20
+	out := make([]byte, header)
+	order.PutUint32(out[0:], macho.MagicFat)
+	order.PutUint32(out[4:], uint32(len(fat.Arches)))
+	for i, arch := range fat.Arches {
+		if uint64(arch.Offset)+uint64(arch.Size) > uint64(len(data)) {
+			return nil, fmt.Errorf("fat Mach-O slice %s is out of range", arch.Cpu)
+		}
+		slice, err := stripMachO(data[arch.Offset : arch.Offset+arch.Size])
+		if err != nil {
+			return nil, fmt.Errorf("fat Mach-O slice %s: %w", arch.Cpu, err)
+		}
+		align := uint64(1) << arch.Align
+		for uint64(len(out))%align != 0 {
+			out = append(out, 0)
+		}
+		entry := out[8+i*20:]
+		order.PutUint32(entry[0:], uint32(arch.Cpu))
+		order.PutUint32(entry[4:], arch.SubCpu)
+		order.PutUint32(entry[8:], uint32(len(out)))
+		order.PutUint32(entry[12:], uint32(len(slice)))
+		order.PutUint32(entry[16:], arch.Align)
+		out = append(out, slice...)
+	}
+	return out, nil
+}
+
+func machOHeaderSize(magic uint32) int {
+	if magic == macho.Magic64 {
+		return 32
+	}
+	return 28
+}
+
+func stripMachO(data []byte) ([]byte, error) {
+	if len(data) < 28 {
+		return nil, errors.New("Mach-O header is truncated")
+	}
+	order := binary.LittleEndian
+	magic := order.Uint32(data)
+	if magic != macho.Magic32 && magic != macho.Magic64 {
+		return nil, errors.New("only little-endian Mach-O images can be stripped")
+	}
+	out := append([]byte(nil), data...)
+	headerSize := uint64(machOHeaderSize(magic))
+	ncmds := order.Uint32(out[16:])
+	sizeofcmds := uint64(order.Uint32(out[20:]))
+	if headerSize+sizeofcmds > uint64(len(out)) {
+		return nil, errors.New("Mach-O load commands are out of range")
+	}
+
+	type command struct {
+		cmd    uint32
+		offset uint64
+		size   uint64
+	}
+	var commands []command
+	offset := headerSize
+	for i := uint32(0); i < ncmds; i++ {
+		if offset+8 > headerSize+sizeofcmds {
+			return nil, errors.New("Mach-O load commands are out of range")
+		}
+		size := uint64(order.Uint32(out[offset+4:]))
+		if size < 8 || offset+size > headerSize+sizeofcmds {
+			return nil, errors.New("Mach-O load command has an invalid size")
+		}
+		commands = append(commands, command{cmd: order.Uint32(out[offset:]), offset: offset, size: size})
+		offset += size
+	}
+	find := func(cmd uint32) *command {
+		for i := range commands {
+			if commands[i].cmd == cmd {
+				return &commands[i]
+			}
+		}
+		return nil
+	}
+
+	if symtab, dysymtab := find(machOLoadSymtab), find(machOLoadDysymtab); symtab != nil && dysymtab != nil {
+		if err := stripMachOLocals(out, magic, out[symtab.offset:symtab.offset+symtab.size], out[dysymtab.offset:dysymtab.offset+dysymtab.size]); err != nil {
+			return nil, err
+		}
+	}
+
+	signature := find(machOLoadCodeSignature)
+	if signature == nil {
+		return out, nil
+	}
+	dataoff := uint64(order.Uint32(out[signature.offset+8:]))
+	datasize := uint64(order.Uint32(out[signature.offset+12:]))
+
+	// Shift the following load commands over the signature command.
+	cmdsEnd := headerSize + sizeofcmds
+	copy(out[signature.offset:], out[signature.offset+signature.size:cmdsEnd])
+	clear(out[cmdsEnd-signature.size : cmdsEnd])
+	order.PutUint32(out[16:], ncmds-1)
+	order.PutUint32(out[20:], uint32(sizeofcmds-signature.size))
+
+	// The signature is the last thing in __LINKEDIT; drop it from the file
+	// and the segment when nothing follows it.
+	if dataoff+datasize == uint64(len(out)) {
+		out = out[:dataoff]
+		offset := headerSize
+		for i := uint32(0); i < ncmds-1; i++ {
+			cmd, size := order.Uint32(out[offset:]), uint64(order.Uint32(out[offset+4:]))
+			switch cmd {
+			case machOLoadSegment64:
+				if string(bytes.TrimRight(out[offset+8:offset+24], "\x00")) == "__LINKEDIT" {
+					fileoff := order.Uint64(out[offset+40:])
+					order.PutUint64(out[offset+48:], dataoff-fileoff)
+				}
+			case machOLoadSegment:
+				if string(bytes.TrimRight(out[offset+8:offset+24], "\x00")) == "__LINKEDIT" {
+					fileoff := uint64(order.Uint32(out[offset+32:]))
+					order.PutUint32(out[offset+36:], uint32(dataoff-fileoff))
+				}
+			}
+			offset += size
+		}
+	}
+	return out, nil
+}
+
+// stripMachOLocals removes the local symbol range described by LC_DYSYMTAB,
+// renumbers everything that indexes the symbol table, and rebuilds the
+// string table from the symbols that remain.
+func stripMachOLocals(out []byte, magic uint32, symtab, dysymtab []byte) error {
+	order := binary.LittleEndian
+	if len(symtab) < 24 || len(dysymtab) < 80 {
+		return errors.New("Mach-O symbol table command is truncated")
+	}
+	symoff, nsyms := uint64(order.Uint32(symtab[8:])), uint64(order.Uint32(symtab[12:]))
+	stroff, strsize := uint64(order.Uint32(symtab[16:])), uint64(order.Uint32(symtab[20:]))
+	ilocal, nlocal := uint64(order.Uint32(dysymtab[8:])), uint64(order.Uint32(dysymtab[12:]))
+	iextdef, iundef := uint64(order.Uint32(dysymtab[16:])), uint64(order.Uint32(dysymtab[24:]))
+	ntoc, nmodtab, nextref := order.Uint32(dysymtab[36:]), order.Uint32(dysymtab[44:]), order.Uint32(dysymtab[52:])
+	indirectoff, nindirect := uint64(order.Uint32(dysymtab[56:])), uint64(order.Uint32(dysymtab[60:]))
+	extreloff, nextrel := uint64(order.Uint32(dysymtab[64:])), uint64(order.Uint32(dysymtab[68:]))
+
+	symSize := uint64(16)
+	if magic == macho.Magic32 {
+		symSize = 12
+	}
+	if symoff+nsyms*symSize > uint64(len(out)) || stroff+strsize > uint64(len(out)) ||
+		indirectoff+nindirect*4 > uint64(len(out)) || extreloff+nextrel*8 > uint64(len(out)) {
+		return errors.New("Mach-O symbol tables are out of range")
+	}
+	if nlocal == 0 {
+		return nil
+	}
+	// Locals must come first for the renumbering below to be a plain
+	// subtraction. Old-style tables of contents are left untouched.
+	if ilocal != 0 || iextdef < nlocal || iundef < nlocal || nlocal > nsyms || ntoc != 0 || nmodtab != 0 || nextref != 0 {
+		return nil
+	}
+
+	const (
+		indirectLocal = 0x80000000
+		indirectAbs   = 0x40000000
+		relocExtern   = 1 << 27
+	)
+	for i := uint64(0); i < nindirect; i++ {
+		index := order.Uint32(out[indirectoff+i*4:])
+		if index&(indirectLocal|indirectAbs) == 0 && uint64(index) < nlocal {
+			return errors.New("Mach-O indirect symbol table refers to a local symbol")
+		}
+	}
+	for i := uint64(0); i < nextrel; i++ {
+		info := order.Uint32(out[extreloff+i*8+4:])
+		if info&relocExtern != 0 && uint64(info&0xffffff) < nlocal {
+			return errors.New("Mach-O external relocation refers to a local symbol")
+		}
+	}
+
+	symbols := out[symoff : symoff+nsyms*symSize]
+	kept := append([]byte(nil), symbols[nlocal*symSize:]...)
+	names := out[stroff : stroff+strsize]
+	table := []byte{' ', 0}
+	offsets := map[uint32]uint32{}
+	for i := uint64(0); i < uint64(len(kept)); i += symSize {
+		strx := order.Uint32(kept[i:])
+		if strx == 0 || uint64(strx) >= strsize {
+			continue
+		}
+		if _, ok := offsets[strx]; !ok {
+			name := names[strx:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			offsets[strx] = uint32(len(table))
+			table = append(append(table, name...), 0)
+		}
+		order.PutUint32(kept[i:], offsets[strx])
+	}
+	for len(table)%8 != 0 {
+		table = append(table, 0)
+	}
+	if uint64(len(table)) > strsize {
+		return nil
+	}
+
+	for i := uint64(0); i < nindirect; i++ {
+		entry := out[indirectoff+i*4:]
+		if index := order.Uint32(entry); index&(indirectLocal|indirectAbs) == 0 {
+			order.PutUint32(entry, index-uint32(nlocal))
+		}
+	}
+	for i := uint64(0); i < nextrel; i++ {
+		entry := out[extreloff+i*8+4:]
+		if info := order.Uint32(entry); info&relocExtern != 0 {
+			order.PutUint32(entry, info&^0xffffff|(info&0xffffff-uint32(nlocal)))
+		}
+	}
+	clear(symbols)
+	copy(symbols, kept)
+	clear(names)
+	copy(names, table)
+	order.PutUint32(symtab[12:], uint32(nsyms-nlocal))
+	order.PutUint32(symtab[20:], uint32(len(table)))
+	order.PutUint32(dysymtab[12:], 0)
+	order.PutUint32(dysymtab[16:], uint32(iextdef-nlocal))
+	order.PutUint32(dysymtab[24:], uint32(iundef-nlocal))
+	return nil
+}
@@ -0,0 +1,146 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripImageTestBinary(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("read %s: %v", exe, err)
+	}
+	if err := ValidateImage(data); err != nil {
+		t.Fatalf("ValidateImage(test binary): %v", err)
+	}
+
+	stripped, err := StripImage(data)
+	if err != nil {
+		t.Fatalf("StripImage: %v", err)
+	}
+	if len(stripped) > len(data) {
+		t.Fatalf("stripped image grew: %d -> %d bytes", len(data), len(stripped))
+	}
+	if err := ValidateImage(stripped); err != nil {
+		t.Fatalf("ValidateImage(stripped): %v", err)
+	}
+	if _, err := StripImage([]byte("not an image")); err == nil {
+		t.Fatal("StripImage accepted garbage")
+	}
+}
+
+// TestStripImageGoBinaries cross-builds a small program for each format and
+// checks that the stripped result drops the debug content but still parses.
+func TestStripImageGoBinaries(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not found in PATH")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module strip\n\ngo 1.22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { println(\"ok\") }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []struct{ goos, goarch string }{
+		{"linux", "386"},
+		{"linux", "amd64"},
+		{"windows", "386"},
+		{"windows", "amd64"},
+		{"darwin", "arm64"},
+	} {
+		t.Run(target.goos+"-"+target.goarch, func(t *testing.T) {
+			output := filepath.Join(dir, target.goos+"-"+target.goarch)
+			cmd := exec.Command("go", "build", "-o", output, ".")
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "GOOS="+target.goos, "GOARCH="+target.goarch, "CGO_ENABLED=0")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("go build: %v\n%s", err, out)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stripped, err := StripImage(data)
+			if err != nil {
+				t.Fatalf("StripImage: %v", err)
+			}
+			if len(stripped) >= len(data) {
+				t.Fatalf("stripped image did not shrink: %d -> %d bytes", len(data), len(stripped))
+			}
+			if before, err := InspectImage(data); err != nil || !before.Debug {
+				t.Fatalf("InspectImage(original) did not report debug content: %v", err)
+			}
+			// Go's Mach-O __DWARF segment is left in place; see StripImage.
+			if after, err := InspectImage(stripped); err != nil || (after.Debug && target.goos != "darwin") {
+				t.Fatalf("InspectImage(stripped) still reports debug content: %v", err)
+			}
+
+			switch target.goos {
+			case "linux":
+				f, err := elf.NewFile(bytes.NewReader(stripped))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range f.Sections {
+					if strings.Contains(s.Name, "debug") || s.Name == ".symtab" || s.Name == ".strtab" {
+						t.Fatalf("section %s survived stripping", s.Name)
+					}
+				}
+			case "windows":
+				f, err := pe.NewFile(bytes.NewReader(stripped))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.FileHeader.PointerToSymbolTable != 0 || len(f.Symbols) != 0 {
+					t.Fatal("COFF symbol table survived stripping")
+				}
+				for _, s := range f.Sections {
+					if strings.Contains(s.Name, "debug") && s.Size != 0 {
+						t.Fatalf("section %s kept %d bytes of data", s.Name, s.Size)
+					}
+				}
+			case "darwin":
+				original, err := macho.NewFile(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				f, err := macho.NewFile(bytes.NewReader(stripped))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Dysymtab.Nlocalsym != 0 {
+					t.Fatalf("%d local symbols survived stripping", f.Dysymtab.Nlocalsym)
+				}
+				for _, load := range f.Loads {
+					if raw := load.Raw(); len(raw) >= 4 && f.ByteOrder.Uint32(raw) == machOLoadCodeSignature {
+						t.Fatal("code signature survived stripping")
+					}
+				}
+				// Indirect symbol entries must still name the same symbols.
+				for i, index := range f.Dysymtab.IndirectSyms {
+					old := original.Dysymtab.IndirectSyms[i]
+					if index >= uint32(len(f.Symtab.Syms)) || old >= uint32(len(original.Symtab.Syms)) {
+						continue
+					}
+					if f.Symtab.Syms[index].Name != original.Symtab.Syms[old].Name {
+						t.Fatalf("indirect symbol %d changed: %s -> %s", i, original.Symtab.Syms[old].Name, f.Symtab.Syms[index].Name)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build windows || (darwin && (amd64 || arm64)) || (linux && (386 || amd64 || arm64))
+
+package memmod
+
+// Supported reports whether this package can map images on the GOOS and
+// GOARCH it was built for.
+const Supported = true
@@ -0,0 +1,49 @@
+package memmod
+
+import "errors"
+
+// ErrSymbolNotFound is returned, wrapped, when an export asked for does not
+// exist or an import the image needs cannot be bound.
+var ErrSymbolNotFound = errors.New("symbol not found")
+
+// SymbolFilter widens the set of symbols Exports, Symbols, and
+// ProcAddressByName expose beyond the global and weak functions an image
+// exports. Only the linux loader honours it; the other loaders always use
+// their platform's export table.
+type SymbolFilter uint8
+
+const (
+	// SymbolsLocal includes local (STB_LOCAL) symbols with default
+	// visibility, such as static functions. They come from the full symbol
+	// table, so stripped images have none.
+	SymbolsLocal SymbolFilter = 1 << iota
+	// SymbolsHidden includes symbols with hidden or internal visibility.
+	// Linkers demote them to local binding, so SymbolsLocal alone does not
+	// include them.
+	SymbolsHidden
+	// SymbolsObjects includes data objects (STT_OBJECT and STT_COMMON)
+	// alongside functions. Thread-local symbols are never included because
+	// their value is an offset into the TLS block, not an address.
+	SymbolsObjects
+)
+
+// SymbolKind says what a Symbol's address points at.
+type SymbolKind string
+
+const (
+	SymbolFunc   SymbolKind = "func"
+	SymbolObject SymbolKind = "object"
+)
+
+// Symbol is a symbol an image exposes under the module's SymbolFilter.
+type Symbol struct {
+	Name    string
+	Address uintptr
+	// Size is the symbol's size in bytes as recorded by the linker, or zero
+	// when unknown.
+	Size uint64
+	Kind SymbolKind
+	// Local is set for symbols that are not part of the image's dynamic
+	// export interface: local bindings and hidden or internal visibility.
+	Local bool
+}
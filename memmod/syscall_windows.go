@@ -99,6 +99,8 @@ const (
 	IMAGE_FILE_MACHINE_AMD64       = 0x8664 // AMD64 (K8)
 	IMAGE_FILE_MACHINE_M32R        = 0x9041 // M32R little-endian
 	IMAGE_FILE_MACHINE_ARM64       = 0xAA64 // ARM64 Little-Endian
+	IMAGE_FILE_MACHINE_ARM64EC     = 0xA641 // ARM64 code using the x64 calling convention
+	IMAGE_FILE_MACHINE_ARM64X      = 0xA64E // Hybrid ARM64 and ARM64EC
 	IMAGE_FILE_MACHINE_CEE         = 0xC0EE
 )
 
@@ -376,6 +378,14 @@ type IMAGE_RUNTIME_FUNCTION_ENTRY struct {
 	UnwindInfoAddress uint32
 }
 
+// IMAGE_ARM64_RUNTIME_FUNCTION_ENTRY is the exception directory entry of
+// ARM and ARM64 images. UnwindData is either the RVA of the .xdata record or,
+// with its low two bits set, packed unwind data.
+type IMAGE_ARM64_RUNTIME_FUNCTION_ENTRY struct {
+	BeginAddress uint32
+	UnwindData   uint32
+}
+
 const (
 	DLL_PROCESS_ATTACH = 1
 	DLL_THREAD_ATTACH  = 2
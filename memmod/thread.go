@@ -0,0 +1,21 @@
+package memmod
+
+// ThreadOptions configures the native thread created by StartExportThread.
+// The zero value uses the platform's default stack size, leaves the thread
+// unnamed, and lets the scheduler place it on any CPU.
+type ThreadOptions struct {
+	// StackSize is the stack reservation in bytes. Zero uses the platform
+	// default.
+	StackSize int
+	// Name is applied to the thread where the platform supports it. Linux
+	// truncates names to 15 bytes.
+	Name string
+	// CPUAffinity restricts the thread to the listed zero-based CPU indexes.
+	CPUAffinity []int
+	// CatchExceptions contains exceptions the export leaves unhandled on
+	// windows: instead of the process, the unhandled exception ends only the
+	// thread, and the wait function reports its code in CallResult.Exception.
+	// The payload's own exception handlers still run first. Other platforms
+	// ignore it.
+	CatchExceptions bool
+}
@@ -0,0 +1,221 @@
+package memmod
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+)
+
+// ErrEncryptedImage is returned for Mach-O images whose LC_ENCRYPTION_INFO
+// command marks them FairPlay-encrypted. Their encrypted pages only decrypt
+// when the kernel maps them from an installed app on the original device, so
+// no in-memory loader can use them.
+var ErrEncryptedImage = errors.New("Mach-O image is FairPlay-encrypted")
+
+// ErrForeignPlatform is returned, wrapped, for images built for another
+// architecture than the running process, or for another operating system.
+var ErrForeignPlatform = errors.New("foreign platform")
+
+// ErrTLSUnsupported is returned for linux images whose thread-local storage
+// the loader cannot provide rather than have them read and write someone
+// else's thread data: any TLS on linux/386, where i386 reaches it through the
+// %gs segment glibc owns; any TLS without cgo; TLS variables of other
+// libraries; amd64 TLS descriptors; and initial-exec blocks that do not fit
+// the static TLS space the loader reserves.
+var ErrTLSUnsupported = errors.New("thread-local storage is not supported")
+
+const (
+	machOLoadEncryptionInfo   = 0x21
+	machOLoadEncryptionInfo64 = 0x2c
+)
+
+// machOEncryption returns an ErrEncryptedImage-wrapping error when f has an
+// encryption command with a non-zero cryptid.
+func machOEncryption(f *macho.File) error {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 20 {
+			continue
+		}
+		cmd := f.ByteOrder.Uint32(raw)
+		if cmd != machOLoadEncryptionInfo && cmd != machOLoadEncryptionInfo64 {
+			continue
+		}
+		cryptoff, cryptsize, cryptid := f.ByteOrder.Uint32(raw[8:]), f.ByteOrder.Uint32(raw[12:]), f.ByteOrder.Uint32(raw[16:])
+		if cryptid != 0 {
+			return fmt.Errorf("%w: %s slice has cryptid %d over file range [%#x, %#x); decrypt it on a device where the app is installed or use an unencrypted build",
+				ErrEncryptedImage, f.Cpu, cryptid, cryptoff, uint64(cryptoff)+uint64(cryptsize))
+		}
+	}
+	return nil
+}
+
+// ValidateImage checks that an ELF, PE, or Mach-O image is structurally sound:
+// it parses, has loadable content, and every segment, section, and table it
+// references lies inside the file. FairPlay-encrypted Mach-O slices fail with
+// ErrEncryptedImage. It does not check that the image can be loaded on the
+// current host.
+func ValidateImage(data []byte) error {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return validateELF(data)
+	case bytes.HasPrefix(data, []byte("MZ")):
+		return validatePE(data)
+	}
+	if fat, err := macho.NewFatFile(bytes.NewReader(data)); err == nil {
+		defer fat.Close()
+		for _, arch := range fat.Arches {
+			if uint64(arch.Offset)+uint64(arch.Size) > uint64(len(data)) {
+				return fmt.Errorf("fat Mach-O slice %s extends past end of file", arch.Cpu)
+			}
+			if err := validateMachO(arch.File, data[arch.Offset:arch.Offset+arch.Size]); err != nil {
+				return fmt.Errorf("fat Mach-O slice %s: %w", arch.Cpu, err)
+			}
+		}
+		return nil
+	}
+	if f, err := macho.NewFile(bytes.NewReader(data)); err == nil {
+		defer f.Close()
+		return validateMachO(f, data)
+	}
+	return errors.New("unrecognized image format")
+}
+
+func validateELF(data []byte) error {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parse ELF image: %w", err)
+	}
+	defer f.Close()
+
+	// Validation is host-independent, so page sharing is judged at the
+	// smallest page size any supported host uses.
+	if err := checkELFLayout(f.Progs, uint64(len(data)), 4096); err != nil {
+		return err
+	}
+	loads := 0
+	for _, p := range f.Progs {
+		if p.Off+p.Filesz > uint64(len(data)) || p.Off+p.Filesz < p.Off {
+			return fmt.Errorf("ELF program header %s extends past end of file", p.Type)
+		}
+		if p.Type == elf.PT_LOAD {
+			loads++
+		}
+	}
+	if loads == 0 {
+		return errors.New("ELF image has no PT_LOAD segments")
+	}
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Type == elf.SHT_NULL {
+			continue
+		}
+		if s.Offset+s.FileSize > uint64(len(data)) || s.Offset+s.FileSize < s.Offset {
+			return fmt.Errorf("ELF section %s extends past end of file", s.Name)
+		}
+	}
+	if _, err := f.DynamicSymbols(); err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return fmt.Errorf("read ELF dynamic symbols: %w", err)
+	}
+	return nil
+}
+
+func validatePE(data []byte) error {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parse PE image: %w", err)
+	}
+	defer f.Close()
+
+	if len(f.Sections) == 0 {
+		return errors.New("PE image has no sections")
+	}
+	var sizeOfImage, sizeOfHeaders uint32
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		sizeOfImage, sizeOfHeaders = header.SizeOfImage, header.SizeOfHeaders
+	case *pe.OptionalHeader64:
+		sizeOfImage, sizeOfHeaders = header.SizeOfImage, header.SizeOfHeaders
+	default:
+		return errors.New("PE image has no optional header")
+	}
+	layout := make([]layoutSegment, len(f.Sections))
+	for i, s := range f.Sections {
+		layout[i] = layoutSegment{
+			index:    i,
+			fileOff:  uint64(s.Offset),
+			fileSize: uint64(s.Size),
+			addr:     uint64(s.VirtualAddress),
+			memSize:  uint64(max(s.VirtualSize, s.Size)),
+		}
+	}
+	if err := checkLayout("pe", layout, uint64(len(data)), uint64(sizeOfHeaders), 0); err != nil {
+		return err
+	}
+	for _, s := range f.Sections {
+		if uint64(s.Offset)+uint64(s.Size) > uint64(len(data)) {
+			return fmt.Errorf("PE section %s extends past end of file", s.Name)
+		}
+		if uint64(s.VirtualAddress)+uint64(s.VirtualSize) > uint64(sizeOfImage) {
+			return fmt.Errorf("PE section %s extends past SizeOfImage", s.Name)
+		}
+	}
+	if _, err := inspectPE(data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateMachO(f *macho.File, data []byte) error {
+	if err := machOEncryption(f); err != nil {
+		return err
+	}
+	if err := checkMachOLayout(f, uint64(len(data))); err != nil {
+		return err
+	}
+	segments := 0
+	for _, load := range f.Loads {
+		switch cmd := load.(type) {
+		case *macho.Segment:
+			segments++
+			if cmd.Offset+cmd.Filesz > uint64(len(data)) {
+				return fmt.Errorf("Mach-O segment %s extends past end of file", cmd.Name)
+			}
+		case *macho.Symtab:
+			symSize := uint64(16)
+			if f.Magic == macho.Magic32 {
+				symSize = 12
+			}
+			if uint64(cmd.Symoff)+uint64(len(cmd.Syms))*symSize > uint64(len(data)) || uint64(cmd.Stroff)+uint64(cmd.Strsize) > uint64(len(data)) {
+				return errors.New("Mach-O symbol table extends past end of file")
+			}
+		}
+	}
+	if segments == 0 {
+		return errors.New("Mach-O image has no segments")
+	}
+
+	order := f.ByteOrder
+	offset := uint64(machOHeaderSize(f.Magic))
+	for i := uint32(0); i < f.Ncmd; i++ {
+		if offset+8 > uint64(len(data)) {
+			return errors.New("Mach-O load commands extend past end of file")
+		}
+		cmd := order.Uint32(data[offset:])
+		size := uint64(order.Uint32(data[offset+4:]))
+		if size < 8 {
+			return fmt.Errorf("Mach-O load command %#x has invalid size %d", cmd, size)
+		}
+		if cmd == machOLoadCodeSignature {
+			dataoff := uint64(order.Uint32(data[offset+8:]))
+			datasize := uint64(order.Uint32(data[offset+12:]))
+			if dataoff+datasize > uint64(len(data)) {
+				return errors.New("Mach-O code signature extends past end of file")
+			}
+		}
+		offset += size
+	}
+	return nil
+}
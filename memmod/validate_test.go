@@ -0,0 +1,56 @@
+package memmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// buildEncryptedMachO returns a minimal arm64 dylib with one __TEXT segment
+// and an LC_ENCRYPTION_INFO_64 command carrying cryptid.
+func buildEncryptedMachO(cryptid uint32) []byte {
+	const size = 32 + 72 + 24
+	le := binary.LittleEndian
+	data := make([]byte, size)
+
+	le.PutUint32(data[0:], 0xfeedfacf)
+	le.PutUint32(data[4:], 0x0100000c)
+	le.PutUint32(data[12:], 6)
+	le.PutUint32(data[16:], 2)
+	le.PutUint32(data[20:], 72+24)
+
+	segment := data[32:]
+	le.PutUint32(segment[0:], machOLoadSegment64)
+	le.PutUint32(segment[4:], 72)
+	copy(segment[8:], "__TEXT")
+	le.PutUint64(segment[32:], 0x4000)
+	le.PutUint64(segment[48:], size)
+	le.PutUint32(segment[56:], 5)
+	le.PutUint32(segment[60:], 5)
+
+	encryption := data[32+72:]
+	le.PutUint32(encryption[0:], machOLoadEncryptionInfo64)
+	le.PutUint32(encryption[4:], 24)
+	le.PutUint32(encryption[8:], 0x20)
+	le.PutUint32(encryption[12:], 0x40)
+	le.PutUint32(encryption[16:], cryptid)
+	return data
+}
+
+func TestValidateImageRejectsEncryptedMachO(t *testing.T) {
+	if err := ValidateImage(buildEncryptedMachO(0)); err != nil {
+		t.Fatalf("ValidateImage(cryptid 0): %v", err)
+	}
+
+	encrypted := buildEncryptedMachO(1)
+	if err := ValidateImage(encrypted); !errors.Is(err, ErrEncryptedImage) {
+		t.Fatalf("ValidateImage(cryptid 1) = %v, want ErrEncryptedImage", err)
+	}
+	info, err := InspectImage(encrypted)
+	if err != nil {
+		t.Fatalf("InspectImage: %v", err)
+	}
+	if !info.Encrypted {
+		t.Fatal("InspectImage did not report the image as encrypted")
+	}
+}
@@ -0,0 +1,272 @@
+package reflektor
+
+import "github.com/sliverarmory/reflektor/memmod"
+
+// Options controls how a library is loaded and how its exports are invoked.
+// The zero value matches LoadLibrary.
+type Options struct {
+	// SingleThreaded serializes every export invocation for the library onto
+	// one dedicated, locked OS thread, which also loads the image, running
+	// its initializers, and unloads it. Use it for payloads that are not
+	// thread-safe or that rely on stable thread identity and TLS, such as
+	// COM DLLs and GUI or Objective-C runtimes that initialize per thread.
+	// For a custom stack size use Thread instead.
+	SingleThreaded bool
+
+	// Thread, when non-nil, runs each export invocation on a freshly created
+	// native thread configured by the given options instead of a Go runtime
+	// thread. It cannot be combined with SingleThreaded. Linux requires cgo
+	// and darwin does not support it.
+	Thread *ThreadOptions
+
+	// PreserveSignals snapshots the process signal handlers before the image
+	// is loaded and reinstalls them, along with the calling thread's signal
+	// mask, after the load and after every export call. Native constructors
+	// often install SIGSEGV or SIGPIPE handlers that break the Go runtime's
+	// own signal handling. Only linux and darwin support it.
+	PreserveSignals bool
+
+	// LockMemory faults in and locks the mapped image (mlock on unix,
+	// VirtualLock on windows) so payload bytes never reach swap and page-in
+	// latency cannot stall a call. Loading fails if the lock cannot be taken,
+	// typically because the image exceeds RLIMIT_MEMLOCK or the windows
+	// minimum working set.
+	LockMemory bool
+
+	// LazyCommit reserves the image's address span but commits only the
+	// ranges its segments or sections occupy, lowering peak commit for very
+	// large payloads that execute a fraction of their image. Linux and
+	// windows only; darwin ignores it.
+	LazyCommit bool
+
+	// PreferredBase asks for the image to be mapped at this address, for
+	// payloads with baked-in address assumptions. It is a hint; Info().Base
+	// reports where the image landed. Linux and windows only.
+	PreferredBase uintptr
+
+	// BaseSeed, when non-zero and PreferredBase is zero, derives the
+	// preferred base from the seed so the same seed reproduces the same
+	// layout, for example when chasing a crash. Zero keeps the default
+	// randomized placement.
+	BaseSeed uint64
+
+	// MaxImageSize, when non-zero, rejects a native image whose address span
+	// exceeds this many bytes with ErrImageTooLarge before anything is
+	// mapped, so a malformed header cannot make the loader reserve an
+	// absurd range.
+	MaxImageSize uint64
+
+	// MaxTotalMappedBytes, when non-zero, rejects a native image with
+	// ErrMappingBudget if loading it would take the address space held by
+	// every image loaded in the process (see MappedBytes) past this many
+	// bytes. Long-running hosts can use it to cap what payloads may hold.
+	MaxTotalMappedBytes uint64
+
+	// Symbols widens what Exports, Symbols, and Call can reach in a linux
+	// image beyond its global and weak functions: local symbols,
+	// hidden-visibility symbols, or data objects. Some payloads deliberately
+	// keep their entry points out of the dynamic export table. Other
+	// platforms ignore it.
+	Symbols SymbolFilter
+
+	// ImagePath is the path a darwin image is registered under with dyld,
+	// which dladdr reports for the payload's own functions. Swift and
+	// Objective-C runtimes and in-process crash reporters rely on it. Empty
+	// uses the dylib's install name. Other platforms ignore it.
+	ImagePath string
+
+	// SkipInitializers maps and links the image without running its
+	// constructors, TLS callbacks, or DllMain, for payloads whose setup the
+	// caller drives through an export. Terminators are skipped on Close to
+	// match. On darwin, Library.RunInitializers runs them later.
+	SkipInitializers bool
+
+	// RunFinalizers runs a linux image's destructors (DT_FINI_ARRAY and
+	// DT_FINI) on Close before it is unmapped, so C++ globals and atexit
+	// handlers the payload registered tear down as they would at dlclose.
+	// Darwin and windows always run their terminators; other platforms
+	// ignore it.
+	RunFinalizers bool
+
+	// SearchPaths lists directories searched, in order, for the image's
+	// dependencies before the platform defaults, for payloads shipped with
+	// private libraries. Darwin ignores it.
+	SearchPaths []string
+
+	// ZeroInput overwrites the caller's image buffer with zeros once the
+	// library has loaded, so the payload bytes do not linger in Go memory.
+	// The buffer is left untouched when loading fails. The loader's own
+	// unpacked copy of a packed image is always zeroed.
+	ZeroInput bool
+
+	// Snapshot keeps a copy of the prepared image, unpacked and decrypted,
+	// with the library so Library.Snapshot can hand it to another process.
+	// The copy stays in Go memory until Close, whatever ZeroInput says.
+	Snapshot bool
+
+	// ImportResolver, when non-nil, is asked for each symbol the image
+	// imports before the default resolution, so imports can be bound to
+	// dependencies the caller loaded from memory. Linux and windows only.
+	ImportResolver ImportResolver
+
+	// BindDelayImports resolves a windows image's delay-load imports at load
+	// time instead of leaving them to the image's delay-load helper. Other
+	// platforms ignore it.
+	BindDelayImports bool
+
+	// DllMain selects the notifications a windows image's TLS callbacks and
+	// DllMain receive. The zero value sends DLL_PROCESS_ATTACH at load and
+	// DLL_PROCESS_DETACH from Close. Other platforms ignore it.
+	DllMain DllMainReasons
+
+	// Deterministic makes loads reproducible across runs and hosts for
+	// differential testing: the base is derived from the image's hash unless
+	// PreferredBase or BaseSeed is set, linux resolves imports against the
+	// image's own dependencies in DT_NEEDED order first, and unnamed darwin
+	// images are named from their hash instead of their address.
+	Deterministic bool
+
+	// VerifyRelocations checks, once a linux image is linked, that every
+	// relocation slot holds the value it should, and reports the result in
+	// Info.Relocations. Mismatches do not fail the load; combine it with
+	// SkipInitializers to inspect the result before any payload code runs.
+	// Other platforms ignore it.
+	VerifyRelocations bool
+
+	// TrackResources records the process's heaps, handle count, and threads
+	// before a windows image is loaded, so Library.ResourceUsage can report
+	// what its DllMain and exports left behind and whether threads it
+	// started still run. Other platforms ignore it.
+	TrackResources bool
+
+	// CallThread selects where a linux image's constructors and exports
+	// run. The zero value gives Go c-shared payloads a fresh pthread per
+	// call in cgo builds, with the stack, guard page, and TLS glibc sets up
+	// for threads it creates, and runs everything else on a Go runtime
+	// thread. SingleThreaded keeps its dedicated thread unless CallThread is
+	// set explicitly. Other platforms ignore it.
+	CallThread CallThreadMode
+
+	// Libc selects the C library a linux image's imports bind to. The zero
+	// value follows musl rules when musl's dynamic linker is mapped in the
+	// process, as in Alpine-based containers: glibc dependencies such as
+	// libc.so.6 and libpthread.so.0 are satisfied by musl's single libc and
+	// symbol versions are ignored. Other platforms ignore it.
+	Libc LibcMode
+
+	// LoadMode selects how a darwin image is linked. The zero value hands
+	// it to dyld; DarwinSelfFixup applies its chained fixups in Go and falls
+	// back to dyld for images that need it. Other platforms ignore it.
+	LoadMode LoadMode
+
+	// CallPolicy, when non-nil, restricts when the library's exports may
+	// run: times of day, a total number of calls, and a cooldown between
+	// calls. Calls it refuses fail with ErrCallNotAllowed.
+	CallPolicy *CallPolicy
+}
+
+// LibcMode selects the C library Options.Libc resolves linux imports against.
+type LibcMode = memmod.LibcMode
+
+const (
+	// LibcAuto detects musl from the libraries mapped in the process.
+	LibcAuto = memmod.LibcAuto
+	// LibcGlibc binds imports as glibc's ld.so would.
+	LibcGlibc = memmod.LibcGlibc
+	// LibcMusl binds imports as musl's dynamic linker would.
+	LibcMusl = memmod.LibcMusl
+)
+
+// LoadMode selects how Options.LoadMode links darwin images.
+type LoadMode = memmod.LoadMode
+
+const (
+	// DarwinDyld links images through dyld4's loader.
+	DarwinDyld = memmod.DarwinDyld
+	// DarwinSelfFixup applies chained fixups in Go, falling back to
+	// DarwinDyld.
+	DarwinSelfFixup = memmod.DarwinSelfFixup
+)
+
+// DllMainReasons selects the DllMain notifications Options.DllMain sends.
+type DllMainReasons = memmod.DllMainReasons
+
+const (
+	// DllMainAttach sends DLL_PROCESS_ATTACH once the image is mapped.
+	DllMainAttach = memmod.DllMainAttach
+	// DllMainDetach sends DLL_PROCESS_DETACH from Close.
+	DllMainDetach = memmod.DllMainDetach
+	// DllMainNone sends no notifications.
+	DllMainNone = memmod.DllMainNone
+)
+
+// CallThreadMode selects where Options.CallThread runs a linux image's code.
+type CallThreadMode = memmod.CallThreadMode
+
+const (
+	// CallThreadAuto uses a pthread per call for Go c-shared payloads when
+	// cgo is available and the calling thread otherwise.
+	CallThreadAuto = memmod.CallThreadAuto
+	// CallThreadPthread runs every constructor and export call on a pthread
+	// created for it. Loading fails without cgo.
+	CallThreadPthread = memmod.CallThreadPthread
+	// CallThreadCaller runs them on the Go runtime thread making the call.
+	CallThreadCaller = memmod.CallThreadCaller
+)
+
+// RelocationCheck is the result of Options.VerifyRelocations.
+type RelocationCheck = memmod.RelocationCheck
+
+// RelocationMismatch is a relocation slot that does not hold its expected
+// value.
+type RelocationMismatch = memmod.RelocationMismatch
+
+// ResourceUsage is what a payload loaded with Options.TrackResources holds.
+type ResourceUsage = memmod.ResourceUsage
+
+// ImportResolver returns the address to bind an import to and true, or false
+// to fall back to the default resolution. See memmod.ImportResolver for what
+// library holds on each platform.
+type ImportResolver = memmod.ImportResolver
+
+// SymbolFilter selects the extra symbols Options.Symbols exposes. Combine the
+// Symbols* constants with |.
+type SymbolFilter = memmod.SymbolFilter
+
+const (
+	// SymbolsLocal includes local symbols with default visibility, such as
+	// static functions, from the image's full symbol table.
+	SymbolsLocal = memmod.SymbolsLocal
+	// SymbolsHidden includes hidden and internal visibility symbols.
+	SymbolsHidden = memmod.SymbolsHidden
+	// SymbolsObjects includes data objects alongside functions.
+	SymbolsObjects = memmod.SymbolsObjects
+)
+
+// ThreadOptions configures the native thread an export runs on.
+type ThreadOptions struct {
+	// StackSize is the stack size in bytes. Zero uses the platform default,
+	// which is often too small for payloads with deep recursion or large
+	// stack frames.
+	StackSize int
+
+	// Name is applied to the thread where supported. Linux truncates names
+	// to 15 bytes; windows requires 10 1607 or later.
+	Name string
+
+	// CPUAffinity pins the thread to the listed zero-based CPU indexes.
+	CPUAffinity []int
+
+	// Detached makes CallExport return as soon as the thread has started
+	// instead of waiting for the export to return. Close still waits for
+	// detached calls before unmapping the image.
+	Detached bool
+
+	// CatchExceptions makes an exception the export leaves unhandled end
+	// only its thread rather than the process, on windows. The call then
+	// fails with ErrExportException and CallResult.Exception holds the code.
+	// The payload's own __try handlers and SEH frames still run first; the
+	// thread is abandoned where it faulted, so locks it held stay held.
+	// Other platforms ignore it.
+	CatchExceptions bool
+}
@@ -0,0 +1,120 @@
+package reflektor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCallNotAllowed is returned when Options.CallPolicy refuses a call.
+var ErrCallNotAllowed = errors.New("reflektor: call not allowed by policy")
+
+// CallPolicy restricts when a library's exports may run, for hosts that let
+// operators they only partly trust drive a payload. A call it refuses fails
+// with ErrCallNotAllowed before reaching the payload. It covers every way of
+// running payload code: the Call and CallExport families, StartExport, and
+// StartEntry. The zero value allows every call.
+type CallPolicy struct {
+	// Windows lists the times of day calls are allowed in. Empty allows
+	// any time.
+	Windows []CallWindow
+
+	// Location is the time zone Windows are read in; nil uses time.Local.
+	Location *time.Location
+
+	// MaxCalls, when non-zero, caps how many calls the library runs over
+	// its lifetime. Refused calls do not count.
+	MaxCalls int
+
+	// Cooldown is the least time between the starts of two calls.
+	Cooldown time.Duration
+
+	// Now returns the current time; nil uses time.Now.
+	Now func() time.Time
+}
+
+// CallWindow is a daily range of time, as offsets from midnight, in which
+// calls are allowed: Start is included and End is not. A window whose End is
+// before its Start wraps past midnight, so {22 * time.Hour, 2 * time.Hour}
+// allows calls from 22:00 to 02:00.
+type CallWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// contains reports whether the time of day offset falls in the window.
+func (window CallWindow) contains(offset time.Duration) bool {
+	if window.Start <= window.End {
+		return offset >= window.Start && offset < window.End
+	}
+	return offset >= window.Start || offset < window.End
+}
+
+// callLimiter enforces a CallPolicy. The library's mutex guards it.
+type callLimiter struct {
+	policy CallPolicy
+	calls  int
+	last   time.Time
+}
+
+// newCallLimiter validates policy and returns a limiter enforcing a copy of
+// it, or nil when there is no policy.
+func newCallLimiter(policy *CallPolicy) (*callLimiter, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	if policy.MaxCalls < 0 {
+		return nil, fmt.Errorf("reflektor: call policy: negative MaxCalls %d", policy.MaxCalls)
+	}
+	if policy.Cooldown < 0 {
+		return nil, fmt.Errorf("reflektor: call policy: negative Cooldown %v", policy.Cooldown)
+	}
+	for _, window := range policy.Windows {
+		if window.Start < 0 || window.Start >= 24*time.Hour || window.End < 0 || window.End > 24*time.Hour || window.Start == window.End {
+			return nil, fmt.Errorf("reflektor: call policy: invalid window %v-%v", window.Start, window.End)
+		}
+	}
+	limiter := &callLimiter{policy: *policy}
+	limiter.policy.Windows = append([]CallWindow(nil), policy.Windows...)
+	return limiter, nil
+}
+
+// admit counts a call the policy allows now, or explains why it does not.
+func (limiter *callLimiter) admit(name string) error {
+	now := time.Now()
+	if limiter.policy.Now != nil {
+		now = limiter.policy.Now()
+	}
+	if limit := limiter.policy.MaxCalls; limit != 0 && limiter.calls >= limit {
+		return fmt.Errorf("%w: call %q: limit of %d calls reached", ErrCallNotAllowed, name, limit)
+	}
+	if cooldown := limiter.policy.Cooldown; cooldown > 0 && !limiter.last.IsZero() {
+		if wait := limiter.last.Add(cooldown).Sub(now); wait > 0 {
+			return fmt.Errorf("%w: call %q: cooling down for %v", ErrCallNotAllowed, name, wait)
+		}
+	}
+	if len(limiter.policy.Windows) != 0 {
+		location := limiter.policy.Location
+		if location == nil {
+			location = time.Local
+		}
+		// Wall clock time, so windows keep their meaning across DST
+		// changes.
+		local := now.In(location)
+		hour, minute, second := local.Clock()
+		offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second + time.Duration(local.Nanosecond())
+		allowed := false
+		for _, window := range limiter.policy.Windows {
+			if window.contains(offset) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: call %q: outside the allowed windows at %s", ErrCallNotAllowed, name, local.Format("15:04:05"))
+		}
+	}
+	limiter.calls++
+	limiter.last = now
+	return nil
+}
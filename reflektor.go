@@ -1,71 +1,844 @@
 package reflektor
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/sliverarmory/reflektor/compress"
 	"github.com/sliverarmory/reflektor/memmod"
 )
 
-var ErrLibraryClosed = errors.New("reflektor: library is closed")
+var (
+	ErrLibraryClosed = errors.New("reflektor: library is closed")
+	ErrCloseTimeout  = errors.New("reflektor: timed out waiting for in-flight calls")
+	// ErrEncryptedImage is returned when a Mach-O payload is FairPlay-encrypted,
+	// as dylibs copied out of App Store app bundles usually are.
+	ErrEncryptedImage = memmod.ErrEncryptedImage
+	// ErrImageTooLarge is returned when an image's address span exceeds
+	// Options.MaxImageSize.
+	ErrImageTooLarge = memmod.ErrImageTooLarge
+	// ErrMappingBudget is returned when loading an image would exceed
+	// Options.MaxTotalMappedBytes.
+	ErrMappingBudget = memmod.ErrMappingBudget
+	// ErrTLSUnsupported is returned when a linux payload uses thread-local
+	// storage the loader cannot provide, such as any TLS on linux/386.
+	ErrTLSUnsupported = memmod.ErrTLSUnsupported
+	// ErrResourceNotFound is returned by Library.Resource and
+	// Library.LoadResource when the image has no such resource.
+	ErrResourceNotFound = memmod.ErrResourceNotFound
+	// ErrForeignPlatform is returned when a payload is built for another
+	// architecture or operating system than the running process.
+	ErrForeignPlatform = memmod.ErrForeignPlatform
+	// ErrSymbolNotFound is returned when an export asked for does not exist
+	// or an import the payload needs cannot be bound.
+	ErrSymbolNotFound = memmod.ErrSymbolNotFound
+	// ErrExportException is returned when an export on a windows native
+	// thread started with ThreadOptions.CatchExceptions raised an exception
+	// it did not handle. CallResult.Exception holds the code.
+	ErrExportException = errors.New("reflektor: export raised an unhandled exception")
+)
+
+// MappedBytes returns the address space held by every native image currently
+// loaded in the process. Lua scripts map nothing and are not counted.
+func MappedBytes() uint64 {
+	return memmod.MappedBytes()
+}
+
+// ResetHostSymbols makes the linux loader look up the host's dynamic linker,
+// libc, and library symbol tables again on the next load or call, instead of
+// using the addresses it cached. Call it after a checkpointed process is
+// restored if the host's libraries may have moved. Libraries already loaded
+// keep their bound imports. Other platforms cache nothing and ignore it.
+func ResetHostSymbols() {
+	memmod.ResetHostSymbols()
+}
+
+// CallResult is the outcome of an export call.
+type CallResult struct {
+	// Value is the raw integer return register. Exports returning narrower
+	// types leave the upper bits undefined, so truncate before use.
+	Value uintptr
+	// Errno is errno on unix (cleared before the call) or GetLastError on
+	// windows, captured on the calling thread right after the export returns.
+	// It is zero where the platform cannot observe it, such as on windows
+	// native threads, where Value is the thread's exit code instead.
+	Errno syscall.Errno
+	// Exception is the code of the exception that ended a windows native
+	// thread call under ThreadOptions.CatchExceptions, or zero.
+	Exception uint32
+	// ThreadID is the operating system id of the thread the export ran on
+	// (the tid on linux, the mach thread id on darwin), or zero where it is
+	// not known, such as for scripts.
+	ThreadID uint64
+	// Duration is how long the export ran, wall clock. On native threads it
+	// covers the whole thread.
+	Duration time.Duration
+}
+
+// Crashed reports whether the export ended in an exception instead of
+// returning.
+func (result CallResult) Crashed() bool {
+	return result.Exception != 0
+}
+
+// payload is what a Library calls into: a mapped native image or a script.
+type payload interface {
+	CallExportArgs(name string, args ...uintptr) (memmod.CallResult, error)
+	StartExportThread(name string, opts memmod.ThreadOptions) (func() memmod.CallResult, error)
+	StartEntry(argv []string) (func(), error)
+	Exports() ([]string, error)
+	Free()
+}
 
 type Library struct {
-	mu     sync.RWMutex
-	module *memmod.Module
-	closed bool
+	mu       sync.Mutex
+	module   payload
+	info     Info
+	thread   *callThread
+	native   *memmod.ThreadOptions
+	detached bool
+	signals  *memmod.SignalState
+	closing  bool
+	closed   bool
+	inflight int
+	drained  chan struct{}
+	// resources is the payload's final ResourceUsage, kept from Close.
+	resources *ResourceUsage
+	// limiter enforces Options.CallPolicy; nil when there is none.
+	limiter *callLimiter
+	// digest is the payload's SHA-256 for audit events; empty when the
+	// load was not audited.
+	digest string
+	// snapshot is the prepared image kept for Snapshot, or nil.
+	snapshot []byte
+	// overlay is the data appended after the image, kept for Overlay.
+	overlay []byte
 }
 
 // LoadLibrary loads a shared library image from memory.
 func LoadLibrary(data []byte) (*Library, error) {
+	return LoadLibraryWithOptions(data, Options{})
+}
+
+// LoadLibraryWithOptions loads a shared library image from memory using opts.
+func LoadLibraryWithOptions(data []byte, opts Options) (*Library, error) {
+	return loadAudited(data, false, opts)
+}
+
+// loadAudited loads data as LoadLibraryWithOptions does. packed is set by
+// callers that unpacked data themselves, so Info still reports it.
+func loadAudited(data []byte, packed bool, opts Options) (*Library, error) {
+	digest := auditDigest(data)
+	library, err := loadLibrary(data, packed, opts)
+	if library != nil {
+		library.digest = digest
+	}
+	recordAudit(AuditEvent{Op: AuditLoad, Payload: digest}, err)
+	return library, err
+}
+
+func loadLibrary(data []byte, packed bool, opts Options) (*Library, error) {
 	if len(data) == 0 {
 		return nil, errors.New("reflektor: empty library image")
 	}
+	if opts.SingleThreaded && opts.Thread != nil {
+		return nil, errors.New("reflektor: SingleThreaded and Thread options are mutually exclusive")
+	}
+	limiter, err := newCallLimiter(opts.CallPolicy)
+	if err != nil {
+		return nil, err
+	}
+	image, err := compress.Unpack(data, opts.MaxImageSize)
+	if err != nil {
+		return nil, unpackError(err)
+	}
+	if compress.Detect(data) != compress.CodecNone {
+		// The unpacked copy belongs to the loader: it is zeroed once the
+		// image is mapped, or when the load fails, whatever ZeroInput says.
+		defer clear(image)
+		packed = true
+	}
+	if logger := currentLogger.Load(); logger != nil && len(image) != len(data) {
+		logger.Debug("unpacked image", "stage", "unpack", "packed", len(data), "size", len(image))
+	}
+	if goos := formatOS[DetectFormat(image)]; memmod.Supported && goos != "" && goos != runtime.GOOS {
+		return nil, fmt.Errorf("reflektor: %w: %s image needs %s, running on %s", ErrForeignPlatform, DetectFormat(image), goos, runtime.GOOS)
+	}
+
+	// With SingleThreaded the image is loaded, and its initializers run, on
+	// the thread that will call its exports, for payloads whose setup is
+	// tied to a thread (COM apartments, GUI and Objective-C runtimes).
+	var (
+		thread  *callThread
+		module  *memmod.Module
+		signals *memmod.SignalState
+	)
+	if opts.SingleThreaded {
+		thread = newCallThread()
+		thread.run(func() { module, signals, err = loadNative(image, opts) })
+		if err != nil {
+			thread.stop()
+		}
+	} else {
+		module, signals, err = loadNative(image, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.LockMemory {
+		if err := module.LockMemory(); err != nil {
+			if thread != nil {
+				thread.run(module.Free)
+				thread.stop()
+			} else {
+				module.Free()
+			}
+			return nil, fmt.Errorf("reflektor: lock memory: %w", err)
+		}
+	}
+	library := &Library{
+		module: module,
+		info: Info{
+			Format:      DetectFormat(image),
+			Backend:     BackendNative,
+			Base:        module.Base(),
+			Relocations: module.Relocations(),
+			Packed:      packed,
+		},
+		signals: signals,
+		thread:  thread,
+		limiter: limiter,
+	}
+	if opts.Thread != nil {
+		library.native = &memmod.ThreadOptions{
+			StackSize:       opts.Thread.StackSize,
+			Name:            opts.Thread.Name,
+			CPUAffinity:     append([]int(nil), opts.Thread.CPUAffinity...),
+			CatchExceptions: opts.Thread.CatchExceptions,
+		}
+		library.detached = opts.Thread.Detached
+	}
+	if opts.Snapshot {
+		library.snapshot = bytes.Clone(image)
+	}
+	if headers, err := memmod.InspectHeaders(image); err == nil {
+		library.info.Arch = headers.Arch
+		library.info.Imports = headers.Imports
+		library.info.Size = headers.ImageSize
+		if headers.Entry != 0 && library.info.Base != 0 {
+			library.info.Entry = library.info.Base + uintptr(headers.Entry)
+		}
+	}
+	if overlay, err := memmod.ImageOverlay(image); err == nil && len(overlay) != 0 {
+		library.overlay = bytes.Clone(overlay)
+	}
+	if opts.ZeroInput {
+		clear(data)
+	}
+	return library, nil
+}
+
+// callThreadMode returns the memmod call thread for opts: SingleThreaded
+// libraries keep image code on their dedicated thread unless the caller
+// chose otherwise.
+func callThreadMode(opts Options) memmod.CallThreadMode {
+	if opts.SingleThreaded && opts.CallThread == CallThreadAuto {
+		return memmod.CallThreadCaller
+	}
+	return opts.CallThread
+}
+
+// loadNative maps image with opts on the calling goroutine, saving and
+// restoring signal handlers around it when PreserveSignals is set.
+func loadNative(image []byte, opts Options) (*memmod.Module, *memmod.SignalState, error) {
+	var (
+		signals *memmod.SignalState
+		err     error
+	)
+	if opts.PreserveSignals {
+		// Constructors run during the load, so snapshot and restore on the
+		// same thread around it.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		signals, err = memmod.SaveSignalState()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reflektor: save signal state: %w", err)
+		}
+	}
+
+	module, err := memmod.LoadLibraryWithOptions(image, memmod.LoadOptions{
+		LazyCommit:          opts.LazyCommit,
+		PreferredBase:       opts.PreferredBase,
+		BaseSeed:            opts.BaseSeed,
+		MaxImageSize:        opts.MaxImageSize,
+		MaxTotalMappedBytes: opts.MaxTotalMappedBytes,
+		Symbols:             opts.Symbols,
+		ImagePath:           opts.ImagePath,
+		SkipInitializers:    opts.SkipInitializers,
+		RunFinalizers:       opts.RunFinalizers,
+		SearchPaths:         opts.SearchPaths,
+		ImportResolver:      opts.ImportResolver,
+		BindDelayImports:    opts.BindDelayImports,
+		DllMain:             opts.DllMain,
+		Deterministic:       opts.Deterministic,
+		VerifyRelocations:   opts.VerifyRelocations,
+		TrackResources:      opts.TrackResources,
+		CallThread:          callThreadMode(opts),
+		Libc:                opts.Libc,
+		LoadMode:            opts.LoadMode,
+		Logger:              currentLogger.Load(),
+	})
+	if signals != nil {
+		if restoreErr := signals.Restore(); restoreErr != nil {
+			if module != nil {
+				module.Free()
+			}
+			return nil, nil, fmt.Errorf("reflektor: restore signal state: %w", restoreErr)
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reflektor: load library: %w", err)
+	}
+	return module, signals, nil
+}
+
+// unpackError wraps an error from unpacking a payload, mapping the size limit
+// to ErrImageTooLarge and a missing codec to ErrNotBuilt.
+func unpackError(err error) error {
+	switch {
+	case errors.Is(err, compress.ErrSizeLimit):
+		return fmt.Errorf("reflektor: unpack library: %w: %w", ErrImageTooLarge, err)
+	case errors.Is(err, compress.ErrCodecNotBuilt):
+		return fmt.Errorf("reflektor: unpack library: %w: %w", ErrNotBuilt, err)
+	}
+	return fmt.Errorf("reflektor: unpack library: %w", err)
+}
+
+// LoadLibraryFromReader loads a shared library image read from r. zstd, XZ,
+// and LZMA payloads are unpacked as they are read, so a large packed image is
+// never held in memory both packed and unpacked. The staging buffer holding
+// the image is zeroed as soon as the image is mapped, or when the load fails.
+func LoadLibraryFromReader(r io.Reader) (*Library, error) {
+	return LoadLibraryFromReaderWithOptions(r, Options{})
+}
 
-	module, err := memmod.LoadLibrary(data)
+// LoadLibraryFromReaderWithOptions is like LoadLibraryFromReader but loads the
+// image using opts. ZeroInput has no effect: the staging buffer belongs to
+// the loader and is always zeroed.
+func LoadLibraryFromReaderWithOptions(r io.Reader, opts Options) (*Library, error) {
+	image, codec, err := compress.UnpackReaderCodec(r, readerSize(r), opts.MaxImageSize)
 	if err != nil {
-		return nil, fmt.Errorf("reflektor: load library: %w", err)
+		return nil, unpackError(err)
 	}
-	return &Library{module: module}, nil
+	defer clear(image)
+	opts.ZeroInput = false
+	return loadAudited(image, codec != compress.CodecNone, opts)
 }
 
-// LoadLibraryFile loads a shared library image from disk into memory.
+// readerSize returns how many bytes r will yield when it can tell, or zero.
+func readerSize(r io.Reader) int {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return r.Len()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			return int(info.Size())
+		}
+	}
+	return 0
+}
+
+// LoadLibraryFile loads a shared library image from disk into memory,
+// streaming it as LoadLibraryFromReader does. Files with a .lua extension are
+// loaded with LoadScript instead.
 func LoadLibraryFile(path string) (*Library, error) {
-	data, err := os.ReadFile(path)
+	if strings.EqualFold(filepath.Ext(path), ".lua") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reflektor: read library file: %w", err)
+		}
+		return LoadScript(data)
+	}
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("reflektor: read library file: %w", err)
 	}
-	return LoadLibrary(data)
+	defer f.Close()
+	return LoadLibraryFromReader(f)
 }
 
 // CallExport resolves and calls a zero-argument exported function.
 func (library *Library) CallExport(name string) error {
-	library.mu.RLock()
-	defer library.mu.RUnlock()
+	_, err := library.CallExportResult(name)
+	return err
+}
+
+// CallExportResult is like CallExport but also returns the export's raw return
+// value and the error state it left behind (errno on unix, GetLastError on
+// windows), which many C APIs use to report failure.
+func (library *Library) CallExportResult(name string) (CallResult, error) {
+	return library.call(name, nil)
+}
+
+// ExportStatusError is returned by CallExportStatus when an export reports
+// failure through a non-zero status.
+type ExportStatusError struct {
+	Name   string
+	Status int
+	// Errno is the error state the export left behind, as in CallResult.
+	Errno syscall.Errno
+}
+
+func (e *ExportStatusError) Error() string {
+	msg := fmt.Sprintf("reflektor: export %q returned status %d", e.Name, e.Status)
+	if e.Errno != 0 {
+		msg += fmt.Sprintf(" (%v)", e.Errno)
+	}
+	return msg
+}
+
+// CallExportStatus calls a zero-argument export that returns a C int status,
+// zero meaning success, and returns the status. A non-zero status is also
+// reported as an *ExportStatusError, so exports following that convention
+// fail like Go functions do.
+func (library *Library) CallExportStatus(name string) (int, error) {
+	result, err := library.CallExportResult(name)
+	if err != nil {
+		return 0, err
+	}
+	status := int(int32(result.Value))
+	if status != 0 {
+		return status, &ExportStatusError{Name: name, Status: status, Errno: result.Errno}
+	}
+	return 0, nil
+}
+
+// Call calls an exported function with up to memmod.MaxCallArgs integer or
+// pointer arguments and returns its raw return value. Pointers into Go memory
+// must stay reachable, and must not move, until the export returns. Libraries
+// loaded with Options.Thread only run zero-argument exports.
+func (library *Library) Call(name string, args ...uintptr) (uintptr, error) {
+	result, err := library.call(name, args)
+	return result.Value, err
+}
+
+// CallExportContext is like CallExportResult but runs the export on a separate
+// thread and returns early when ctx is done. running then reports that the
+// export has not returned yet; it keeps going, stays registered as in flight
+// so Close waits for it, and its result is discarded. Native code cannot be
+// interrupted, so cancelling ctx only stops the wait.
+func (library *Library) CallExportContext(ctx context.Context, name string) (result CallResult, running bool, err error) {
+	return library.CallContext(ctx, name)
+}
+
+// CallContext is like CallExportContext but passes up to memmod.MaxCallArgs
+// integer or pointer arguments, as Call does.
+func (library *Library) CallContext(ctx context.Context, name string, args ...uintptr) (result CallResult, running bool, err error) {
+	defer func() {
+		recordAudit(AuditEvent{Op: AuditCall, Payload: library.digest, Export: name, Args: args, Result: result}, err)
+	}()
+	if err := ctx.Err(); err != nil {
+		return CallResult{}, false, err
+	}
+	module, err := library.acquireCall(name)
+	if err != nil {
+		return CallResult{}, false, err
+	}
+
+	type outcome struct {
+		result CallResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := library.callAcquired(module, name, args)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case out := <-done:
+		return out.result, false, out.err
+	case <-ctx.Done():
+		return CallResult{}, true, fmt.Errorf("reflektor: call export %q: %w", name, ctx.Err())
+	}
+}
 
-	if library.closed || library.module == nil {
-		return ErrLibraryClosed
+func (library *Library) call(name string, args []uintptr) (result CallResult, err error) {
+	defer func() {
+		recordAudit(AuditEvent{Op: AuditCall, Payload: library.digest, Export: name, Args: args, Result: result}, err)
+	}()
+	module, err := library.acquireCall(name)
+	if err != nil {
+		return CallResult{}, err
+	}
+	return library.callAcquired(module, name, args)
+}
+
+// callAcquired calls the export on a module returned by acquire and releases
+// it once the call is done.
+func (library *Library) callAcquired(module payload, name string, args []uintptr) (CallResult, error) {
+	if library.native != nil {
+		if len(args) != 0 {
+			library.release()
+			return CallResult{}, fmt.Errorf("reflektor: call export %q: native thread calls take no arguments", name)
+		}
+		return library.callOnNativeThread(module, name)
+	}
+	defer library.release()
+
+	var (
+		result memmod.CallResult
+		err    error
+	)
+	library.invoke(func() {
+		result, err = module.CallExportArgs(name, args...)
+		if restoreErr := library.restoreSignals(); err == nil {
+			err = restoreErr
+		}
+	})
+	if err != nil {
+		return CallResult{}, fmt.Errorf("reflektor: call export %q: %w", name, err)
+	}
+	return CallResult(result), nil
+}
+
+// callOnNativeThread runs the export on a new native thread. Detached calls
+// stay registered as in flight until the export returns so Close waits for
+// them, and report a zero CallResult.
+func (library *Library) callOnNativeThread(module payload, name string) (CallResult, error) {
+	wait, err := module.StartExportThread(name, *library.native)
+	if err != nil {
+		library.release()
+		return CallResult{}, fmt.Errorf("reflektor: call export %q: %w", name, err)
+	}
+	// The native thread puts its own signal mask back before it finishes;
+	// only the process-wide handlers are restored from here.
+	if library.detached {
+		go func() {
+			defer library.release()
+			wait()
+			_ = library.restoreSignalHandlers()
+		}()
+		return CallResult{}, nil
 	}
-	if err := library.module.CallExport(name); err != nil {
-		return fmt.Errorf("reflektor: call export %q: %w", name, err)
+	defer library.release()
+	result := wait()
+	if err := library.restoreSignalHandlers(); err != nil {
+		return CallResult{}, fmt.Errorf("reflektor: call export %q: %w", name, err)
+	}
+	return nativeThreadResult(name, result)
+}
+
+// nativeThreadResult reports an export that a caught exception ended as an
+// error alongside its result.
+func nativeThreadResult(name string, result memmod.CallResult) (CallResult, error) {
+	if result.Exception != 0 {
+		return CallResult(result), fmt.Errorf("reflektor: call export %q: %w 0x%08x", name, ErrExportException, result.Exception)
+	}
+	return CallResult(result), nil
+}
+
+// StartEntry runs a static-PIE executable's entry point on a new native thread
+// (linux only) and returns once the thread has started. args become argv;
+// argv[0] defaults to the host's program name. The run stays registered as in
+// flight until the thread exits, so Close waits for it.
+//
+// A payload that returns from main or calls exit terminates the whole host
+// process; it must end its thread with the exit system call instead.
+func (library *Library) StartEntry(args ...string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Op: AuditEntry, Payload: library.digest}, err)
+	}()
+	module, err := library.acquireCall("entry point")
+	if err != nil {
+		return err
+	}
+	wait, err := module.StartEntry(args)
+	if err != nil {
+		library.release()
+		return fmt.Errorf("reflektor: start entry: %w", err)
+	}
+	go func() {
+		defer library.release()
+		wait()
+	}()
+	return nil
+}
+
+// invoke runs fn on the library's dedicated thread when SingleThreaded is set,
+// otherwise on the calling goroutine. With PreserveSignals the goroutine is
+// locked so the signal mask is restored on the thread that ran the payload.
+func (library *Library) invoke(fn func()) {
+	if library.thread != nil {
+		library.thread.run(fn)
+		return
+	}
+	if library.signals != nil {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+	fn()
+}
+
+// restoreSignals reinstalls the signal state captured before the load when
+// PreserveSignals is set.
+func (library *Library) restoreSignals() error {
+	if library.signals == nil {
+		return nil
+	}
+	if err := library.signals.Restore(); err != nil {
+		return fmt.Errorf("restore signal state: %w", err)
+	}
+	return nil
+}
+
+// restoreSignalHandlers is restoreSignals for callers off the thread the
+// export ran on: it leaves the calling thread's signal mask alone.
+func (library *Library) restoreSignalHandlers() error {
+	if library.signals == nil {
+		return nil
+	}
+	if err := library.signals.RestoreHandlers(); err != nil {
+		return fmt.Errorf("restore signal state: %w", err)
 	}
 	return nil
 }
 
-// Close releases library resources.
+// Close releases library resources. It waits for in-flight calls to return
+// before unmapping the image and is safe to call more than once.
 func (library *Library) Close() error {
+	return library.CloseContext(context.Background())
+}
+
+// CloseWithTimeout is like Close but gives up waiting for in-flight calls
+// after timeout (zero or negative waits indefinitely). On ErrCloseTimeout the
+// library stays mapped and rejects new calls; Close may be retried later.
+func (library *Library) CloseWithTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return library.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := library.CloseContext(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrCloseTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+// CloseContext is like Close but stops waiting for in-flight calls when ctx
+// is done and returns ctx's error. The library then stays mapped and rejects
+// new calls; Close may be retried later.
+func (library *Library) CloseContext(ctx context.Context) error {
 	library.mu.Lock()
-	defer library.mu.Unlock()
+	if library.closed {
+		library.mu.Unlock()
+		return nil
+	}
+	library.closing = true
+	var drained chan struct{}
+	if library.inflight > 0 {
+		if library.drained == nil {
+			library.drained = make(chan struct{})
+		}
+		drained = library.drained
+	}
+	library.mu.Unlock()
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
+	library.mu.Lock()
 	if library.closed {
+		library.mu.Unlock()
 		return nil
 	}
 	library.closed = true
+	module := library.module
+	library.module = nil
+	thread := library.thread
+	library.thread = nil
+	clear(library.snapshot)
+	library.snapshot = nil
+	clear(library.overlay)
+	library.overlay = nil
+	library.mu.Unlock()
+	recordAudit(AuditEvent{Op: AuditClose, Payload: library.digest}, nil)
 
-	if library.module != nil {
-		library.module.Free()
-		library.module = nil
+	free := func() {
+		if module != nil {
+			module.Free()
+			if reporter, ok := module.(resourceReporter); ok {
+				library.mu.Lock()
+				library.resources = reporter.ResourceUsage()
+				library.mu.Unlock()
+			}
+		}
+	}
+	if thread != nil {
+		// Unload on the same thread that ran the exports.
+		thread.run(free)
+		thread.stop()
+	} else {
+		free()
 	}
 	return nil
 }
+
+// resourceReporter is implemented by native modules; see
+// Options.TrackResources.
+type resourceReporter interface {
+	ResourceUsage() *memmod.ResourceUsage
+}
+
+// ResourceUsage reports the threads, heaps, and handles a windows payload
+// loaded with Options.TrackResources holds, so a host can tell whether
+// unloading it is safe: threads it started that still run would be left
+// executing unmapped code. After Close it reports what was still held just
+// before the image was unmapped. It is nil without TrackResources and on
+// other platforms.
+func (library *Library) ResourceUsage() *ResourceUsage {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+	if library.closed {
+		return library.resources
+	}
+	if reporter, ok := library.module.(resourceReporter); ok {
+		return reporter.ResourceUsage()
+	}
+	return nil
+}
+
+// ResourceTypeRCData is RT_RCDATA, the resource type packers usually store
+// nested payloads under.
+const ResourceTypeRCData = memmod.ResourceTypeRCData
+
+// resourceFinder is implemented by native modules.
+type resourceFinder interface {
+	Resource(resType, name string) ([]byte, error)
+}
+
+// Resource returns a copy of the resource of type resType named name in a
+// windows payload's resource directory. Types and names are strings, or "#n"
+// for numeric IDs, as FindResource takes them. Other images have no
+// resources.
+func (library *Library) Resource(resType, name string) ([]byte, error) {
+	module, err := library.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer library.release()
+
+	finder, ok := module.(resourceFinder)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s payloads have no resources", ErrResourceNotFound, library.info.Backend)
+	}
+	return finder.Resource(resType, name)
+}
+
+// LoadResource loads the payload a packer embedded as the resource of type
+// resType named name, as LoadLibraryWithOptions would load it from bytes, so
+// a nested payload takes one call. The extracted copy is cleared once it is
+// loaded. The nested library is independent of this one and must be closed
+// separately.
+func (library *Library) LoadResource(resType, name string, opts Options) (*Library, error) {
+	data, err := library.Resource(resType, name)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+	return LoadLibraryWithOptions(data, opts)
+}
+
+// initializerRunner is implemented by darwin modules.
+type initializerRunner interface {
+	RunInitializers() error
+}
+
+// RunInitializers runs the initializers of a darwin library loaded with
+// Options.SkipInitializers, for payloads that do their work in constructors
+// and export nothing to call. It fails if they already ran.
+func (library *Library) RunInitializers() error {
+	module, err := library.acquire()
+	if err != nil {
+		return err
+	}
+	defer library.release()
+
+	runner, ok := module.(initializerRunner)
+	if !ok {
+		return fmt.Errorf("reflektor: %s %s libraries cannot run deferred initializers", runtime.GOOS, library.info.Backend)
+	}
+	return runner.RunInitializers()
+}
+
+// Overlay returns a copy of the data appended after the native image's
+// formal end, which builders use to carry configuration alongside a payload.
+// It is nil when there is none, for scripts, and once the library is closed,
+// when the kept copy is cleared. See memmod.ImageOverlay for what counts as
+// part of the image.
+func (library *Library) Overlay() []byte {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+	return bytes.Clone(library.overlay)
+}
+
+// acquire registers an in-flight call and returns the module to call into.
+func (library *Library) acquire() (payload, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	if library.closing || library.module == nil {
+		return nil, ErrLibraryClosed
+	}
+	library.inflight++
+	return library.module, nil
+}
+
+// acquireCall is like acquire for a call that runs payload code, which
+// Options.CallPolicy must admit first.
+func (library *Library) acquireCall(name string) (payload, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	if library.closing || library.module == nil {
+		return nil, ErrLibraryClosed
+	}
+	if library.limiter != nil {
+		if err := library.limiter.admit(name); err != nil {
+			return nil, err
+		}
+	}
+	library.inflight++
+	return library.module, nil
+}
+
+func (library *Library) release() {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+
+	library.inflight--
+	if library.inflight == 0 && library.drained != nil {
+		close(library.drained)
+		library.drained = nil
+	}
+}
@@ -0,0 +1,379 @@
+package reflektor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/demangle"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// ErrUnsupportedFormat is returned by Open for payloads no backend can run.
+var ErrUnsupportedFormat = errors.New("reflektor: unsupported payload format")
+
+// Runner is the backend-neutral view of a loaded payload. Open returns one
+// for native images and Lua scripts, and OpenShellcode one for raw code;
+// *Library and *Code implement it. There is no Load method, since both return
+// a Runner already loaded, and no backend yet for CLR assemblies or WASM
+// modules.
+type Runner interface {
+	// CallExport calls a zero-argument export (or script function).
+	CallExport(name string) error
+	// Exports lists the names CallExport can call.
+	Exports() ([]string, error)
+	// Close waits for in-flight calls and releases the payload.
+	Close() error
+	// Info describes the payload and the backend running it.
+	Info() Info
+}
+
+var (
+	_ Runner = (*Library)(nil)
+	_ Runner = (*Code)(nil)
+)
+
+// Format identifies a payload's container format.
+type Format string
+
+const (
+	FormatUnknown Format = "unknown"
+	FormatELF     Format = "elf"
+	FormatPE      Format = "pe"
+	FormatMachO   Format = "macho"
+	// FormatCLR is a PE image carrying a .NET (CLI) header.
+	FormatCLR  Format = "clr"
+	FormatWASM Format = "wasm"
+	FormatLua  Format = "lua"
+	// FormatShellcode is raw position-independent code. It has no signature,
+	// so DetectFormat never returns it; OpenShellcode reports it.
+	FormatShellcode Format = "shellcode"
+)
+
+// formatOS maps each native image format to the operating system whose
+// loader maps it.
+var formatOS = map[Format]string{
+	FormatELF:   "linux",
+	FormatPE:    "windows",
+	FormatCLR:   "windows",
+	FormatMachO: "darwin",
+}
+
+// Backend names the loader that runs a payload.
+type Backend string
+
+const (
+	// BackendNative maps images with memmod.
+	BackendNative Backend = "memmod"
+	// BackendLua runs scripts with luamod.
+	BackendLua Backend = "luamod"
+	// BackendShellcode maps raw code with memmod.LoadCode.
+	BackendShellcode Backend = "shellcode"
+)
+
+// Info describes a loaded payload.
+type Info struct {
+	Format  Format
+	Backend Backend
+	// Arch is the native image's architecture in GOARCH terms; empty for
+	// scripts.
+	Arch string
+	// Imports lists the libraries a native image links against, as named in
+	// its headers.
+	Imports []string
+	// Base is where a native image was mapped and Size the address span it
+	// occupies; both are zero for scripts.
+	Base uintptr
+	Size uint64
+	// Entry is the address of the image's entry point, or zero when it has
+	// none, as is usual for shared libraries.
+	Entry uintptr
+	// Packed is set when the payload arrived compressed or otherwise
+	// transformed (see the compress package), and Encrypted when it was
+	// loaded with one of the LoadEncryptedLibrary functions.
+	Packed    bool
+	Encrypted bool
+	// Relocations is the result of Options.VerifyRelocations, or nil when
+	// the check was not asked for or does not apply.
+	Relocations *RelocationCheck
+}
+
+// Info reports the payload's format, the backend running it, and, for native
+// images, how the image is laid out and what it links against.
+func (library *Library) Info() Info {
+	return library.info
+}
+
+// Exports lists the payload's callable exports, sorted by name. Native images
+// report their exported symbols; scripts report their global functions.
+func (library *Library) Exports() ([]string, error) {
+	module, err := library.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer library.release()
+
+	names, err := module.Exports()
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: list exports: %w", err)
+	}
+	return names, nil
+}
+
+// Symbol describes a symbol a native image exposes: its address, size, and
+// whether it is a function or a data object.
+type Symbol = memmod.Symbol
+
+// symbolLister is implemented by native modules; scripts have no symbols.
+type symbolLister interface {
+	Symbols() ([]memmod.Symbol, error)
+}
+
+// Symbols is like Exports but describes each symbol, including the data
+// objects Options.Symbols can add. Only native linux images support it.
+func (library *Library) Symbols() ([]Symbol, error) {
+	module, err := library.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer library.release()
+
+	lister, ok := module.(symbolLister)
+	if !ok {
+		return nil, errors.New("reflektor: list symbols: not supported for scripts")
+	}
+	symbols, err := lister.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: list symbols: %w", err)
+	}
+	return symbols, nil
+}
+
+// symbolFinder is implemented by native modules.
+type symbolFinder interface {
+	FindSymbol(name string) (uintptr, error)
+}
+
+// FindSymbol returns the address name resolves to from the payload's point of
+// view: its own exports first, then its dependencies in the order the image
+// lists them, then (on linux) the process's global scope, as dlsym with
+// RTLD_DEFAULT would. Embedders can use it to reach functions a payload
+// re-exports from the libraries it links against.
+func (library *Library) FindSymbol(name string) (uintptr, error) {
+	module, err := library.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer library.release()
+
+	finder, ok := module.(symbolFinder)
+	if !ok {
+		return 0, errors.New("reflektor: find symbol: not supported for scripts")
+	}
+	addr, err := finder.FindSymbol(name)
+	if err != nil {
+		return 0, fmt.Errorf("reflektor: find symbol: %w", err)
+	}
+	return addr, nil
+}
+
+// procAddresser is implemented by native payloads.
+type procAddresser interface {
+	ProcAddressByName(name string) (uintptr, error)
+	ProcAddressByOrdinal(ordinal uint16) (uintptr, error)
+}
+
+// ProcAddress returns the address of one of the payload's own exports
+// without calling it. name may be "#N" for ordinal N, as ImportResolver
+// receives ordinal imports. Unlike FindSymbol, dependencies are not searched.
+func (library *Library) ProcAddress(name string) (uintptr, error) {
+	if ordinal, found := strings.CutPrefix(name, "#"); found {
+		n, err := strconv.ParseUint(ordinal, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("reflektor: invalid ordinal %q", name)
+		}
+		return library.ProcAddressOrdinal(uint16(n))
+	}
+	module, err := library.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer library.release()
+
+	native, ok := module.(procAddresser)
+	if !ok {
+		return 0, errors.New("reflektor: payload has no native exports")
+	}
+	addr, err := native.ProcAddressByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("reflektor: proc address %q: %w", name, err)
+	}
+	return addr, nil
+}
+
+// ProcAddressOrdinal returns the address of the export with the given
+// ordinal. PE exports that have no name, which Exports cannot list, are found
+// this way and can be called as "#N". ELF and Mach-O images have no ordinals,
+// so there ordinal N is the Nth name Exports lists, counting from 1; code
+// written against the windows API then works unchanged.
+func (library *Library) ProcAddressOrdinal(ordinal uint16) (uintptr, error) {
+	module, err := library.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer library.release()
+
+	native, ok := module.(procAddresser)
+	if !ok {
+		return 0, errors.New("reflektor: payload has no native exports")
+	}
+	addr, err := native.ProcAddressByOrdinal(ordinal)
+	if err != nil {
+		return 0, fmt.Errorf("reflektor: proc address #%d: %w", ordinal, err)
+	}
+	return addr, nil
+}
+
+// Export is an export FindExport matched.
+type Export struct {
+	// Name is the export as the payload names it, which is what CallExport
+	// and Call take.
+	Name string
+	// Demangled is Name with C++ or Swift mangling decoded (see the
+	// demangle package), or Name itself when it is not mangled.
+	Demangled string
+	// Address is where a native export was mapped; zero for scripts.
+	Address uintptr
+}
+
+// FindExport returns the exports whose name or demangled name matches the
+// regular expression pattern, sorted by name. It finds exports such as
+// _ZN5agent5startEv by the name their source uses, for example
+// FindExport(`^agent::start\(`). No match is not an error.
+func (library *Library) FindExport(pattern string) ([]Export, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: find export: %w", err)
+	}
+	module, err := library.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer library.release()
+
+	names, err := module.Exports()
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: find export: %w", err)
+	}
+	native, _ := module.(procAddresser)
+	var matches []Export
+	for _, name := range names {
+		demangled := demangle.Demangle(name)
+		if !re.MatchString(name) && !re.MatchString(demangled) {
+			continue
+		}
+		export := Export{Name: name, Demangled: demangled}
+		if native != nil {
+			if export.Address, err = native.ProcAddressByName(name); err != nil {
+				return nil, fmt.Errorf("reflektor: find export %s: %w", name, err)
+			}
+		}
+		matches = append(matches, export)
+	}
+	return matches, nil
+}
+
+// Open loads data with the backend for its detected format: native images go
+// to LoadLibrary and Lua scripts to LoadScript. Packed payloads (see the
+// compress package) are unpacked before detection. CLR assemblies, WASM modules,
+// and unrecognized data (including raw shellcode, which has no signature and
+// is opened with OpenShellcode) return ErrUnsupportedFormat.
+func Open(data []byte) (Runner, error) {
+	packed := compress.Detect(data) != compress.CodecNone
+	data, err := compress.Unpack(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: unpack payload: %w", err)
+	}
+	var library *Library
+	switch format := DetectFormat(data); format {
+	case FormatELF, FormatPE, FormatMachO:
+		library, err = loadAudited(data, packed, Options{})
+	case FormatLua:
+		library, err = LoadScript(data)
+		if err == nil {
+			library.info.Packed = packed
+		}
+	case FormatUnknown:
+		return nil, fmt.Errorf("%w: unrecognized payload", ErrUnsupportedFormat)
+	default:
+		return nil, fmt.Errorf("%w: no %s backend is available", ErrUnsupportedFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return library, nil
+}
+
+// DetectFormat identifies a payload from its leading bytes. Lua is recognized
+// only by the precompiled chunk signature or a "#!" first line naming lua;
+// other source is FormatUnknown and must be loaded with LoadScript.
+func DetectFormat(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x7fELF")):
+		return FormatELF
+	case bytes.HasPrefix(data, []byte("MZ")):
+		if isCLRImage(data) {
+			return FormatCLR
+		}
+		return FormatPE
+	case bytes.HasPrefix(data, []byte{0xcf, 0xfa, 0xed, 0xfe}),
+		bytes.HasPrefix(data, []byte{0xce, 0xfa, 0xed, 0xfe}),
+		bytes.HasPrefix(data, []byte{0xca, 0xfe, 0xba, 0xbe}):
+		return FormatMachO
+	case bytes.HasPrefix(data, []byte("\x00asm")):
+		return FormatWASM
+	case bytes.HasPrefix(data, []byte("\x1bLua")):
+		return FormatLua
+	case bytes.HasPrefix(data, []byte("#!")):
+		line, _, _ := bytes.Cut(data, []byte("\n"))
+		if bytes.Contains(line, []byte("lua")) {
+			return FormatLua
+		}
+	}
+	return FormatUnknown
+}
+
+// isCLRImage reports whether a PE image has a non-empty COM descriptor
+// (CLI header) data directory.
+func isCLRImage(data []byte) bool {
+	const comDescriptorDirectory = 14
+
+	if len(data) < 0x40 {
+		return false
+	}
+	ntHeaders := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	optionalHeader := ntHeaders + 24
+	if ntHeaders < 0 || optionalHeader+2 > len(data) || !bytes.Equal(data[ntHeaders:ntHeaders+4], []byte("PE\x00\x00")) {
+		return false
+	}
+
+	var directories int
+	switch binary.LittleEndian.Uint16(data[optionalHeader:]) {
+	case 0x10b: // PE32
+		directories = optionalHeader + 96
+	case 0x20b: // PE32+
+		directories = optionalHeader + 112
+	default:
+		return false
+	}
+	entry := directories + comDescriptorDirectory*8
+	if entry+8 > len(data) {
+		return false
+	}
+	return binary.LittleEndian.Uint32(data[entry:]) != 0 && binary.LittleEndian.Uint32(data[entry+4:]) != 0
+}
@@ -0,0 +1,123 @@
+package reflektor_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+)
+
+func TestDetectFormat(t *testing.T) {
+	clr := make([]byte, 0x200)
+	copy(clr, "MZ")
+	clr[0x3c] = 0x80
+	copy(clr[0x80:], "PE\x00\x00")
+	clr[0x80+24] = 0x0b // PE32+ optional header magic
+	clr[0x80+25] = 0x02
+	comDescriptor := 0x80 + 24 + 112 + 14*8
+	clr[comDescriptor] = 0x08
+	clr[comDescriptor+4] = 0x48
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want reflektor.Format
+	}{
+		{"elf", []byte("\x7fELF\x02\x01\x01"), reflektor.FormatELF},
+		{"pe", []byte("MZ\x90\x00"), reflektor.FormatPE},
+		{"clr", clr, reflektor.FormatCLR},
+		{"macho64", []byte{0xcf, 0xfa, 0xed, 0xfe, 0x07}, reflektor.FormatMachO},
+		{"fat", []byte{0xca, 0xfe, 0xba, 0xbe, 0x00}, reflektor.FormatMachO},
+		{"wasm", []byte("\x00asm\x01\x00\x00\x00"), reflektor.FormatWASM},
+		{"lua", []byte("#!/usr/bin/env lua\nfunction StartW() return 0 end\n"), reflektor.FormatLua},
+		{"luac", []byte("\x1bLuaQ\x00"), reflektor.FormatLua},
+		{"lua without shebang", []byte("function StartW() return 0 end\n"), reflektor.FormatUnknown},
+		{"shell", []byte("#!/bin/sh\necho hi\n"), reflektor.FormatUnknown},
+		{"shellcode", []byte{0xfc, 0x48, 0x83, 0xe4, 0xf0, 0x00}, reflektor.FormatUnknown},
+		{"empty", nil, reflektor.FormatUnknown},
+	} {
+		if got := reflektor.DetectFormat(tc.data); got != tc.want {
+			t.Errorf("DetectFormat(%s) = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOpenDispatchesByFormat(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "lua", "basic.lua"))
+	if err != nil {
+		t.Fatalf("read script: %v", err)
+	}
+	runner, err := reflektor.Open(source)
+	if !slices.Contains(reflektor.Features().Backends, reflektor.BackendLua) {
+		if !errors.Is(err, reflektor.ErrNotBuilt) {
+			t.Fatalf("Open(lua) without the Lua backend: err = %v, want ErrNotBuilt", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Open(lua): %v", err)
+	}
+	if info := runner.Info(); info.Format != reflektor.FormatLua || info.Backend != reflektor.BackendLua {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	exports, err := runner.Exports()
+	if err != nil {
+		t.Fatalf("Exports: %v", err)
+	}
+	if got := strings.Join(exports, ","); got != "Add,Answer,Fail,StartW" {
+		t.Fatalf("unexpected exports: %s", got)
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	packed, err := compress.PackAP32(source)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	if runner, err = reflektor.Open(packed); err != nil {
+		t.Fatalf("Open(packed lua): %v", err)
+	}
+	if info := runner.Info(); info.Format != reflektor.FormatLua || !info.Packed {
+		t.Fatalf("Open(packed lua).Info() = %+v, want a packed script", info)
+	}
+	_ = runner.Close()
+
+	for _, data := range [][]byte{[]byte("\x00asm\x01\x00\x00\x00"), {0xfc, 0x00, 0xe8}} {
+		if runner, err := reflektor.Open(data); !errors.Is(err, reflektor.ErrUnsupportedFormat) || runner != nil {
+			t.Fatalf("Open(%q) = %v, %v; want ErrUnsupportedFormat", data, runner, err)
+		}
+	}
+}
+
+func TestFeaturesReportsBuild(t *testing.T) {
+	features := reflektor.Features()
+	native := runtime.GOOS == "windows" ||
+		runtime.GOOS == "darwin" && (runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64") ||
+		runtime.GOOS == "linux" && (runtime.GOARCH == "386" || runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64")
+	if features.NativeLoader != native {
+		t.Fatalf("NativeLoader = %v on %s/%s, want %v", features.NativeLoader, runtime.GOOS, runtime.GOARCH, native)
+	}
+	if slices.Contains(features.Backends, reflektor.BackendNative) != native {
+		t.Fatalf("Backends = %v, want native=%v", features.Backends, native)
+	}
+	lib, err := reflektor.LoadScript([]byte("function StartW() return 0 end\n"))
+	if lua := slices.Contains(features.Backends, reflektor.BackendLua); lua != (err == nil) || !lua && !errors.Is(err, reflektor.ErrNotBuilt) {
+		t.Fatalf("Backends = %v but LoadScript err = %v", features.Backends, err)
+	}
+	if lib != nil {
+		_ = lib.Close()
+	}
+	if !slices.Contains(features.Compression, "ap32") {
+		t.Fatalf("Compression = %v, want ap32", features.Compression)
+	}
+	if features.NativeThreads && runtime.GOOS == "darwin" {
+		t.Fatal("NativeThreads reported on darwin")
+	}
+}
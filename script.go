@@ -0,0 +1,30 @@
+package reflektor
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// LoadScript loads a Lua script payload for hosts where native code cannot be
+// mapped. The script's top-level chunk runs during the load and its global
+// functions are called through CallExport like native exports; see luamod for
+// the host API available to scripts. Script calls are serialized, report a
+// zero Errno, and cannot run on native threads or be started with StartEntry.
+// The Lua backend is built only with the reflektor_lua or reflektor_full
+// build tag; other builds return ErrNotBuilt.
+func LoadScript(source []byte) (*Library, error) {
+	digest := auditDigest(source)
+	module, err := loadScript(source)
+	if err != nil {
+		err = fmt.Errorf("reflektor: load script: %w", err)
+		recordAudit(AuditEvent{Op: AuditLoad, Payload: digest}, err)
+		return nil, err
+	}
+	recordAudit(AuditEvent{Op: AuditLoad, Payload: digest}, nil)
+	return &Library{
+		module:   module,
+		info:     Info{Format: FormatLua, Backend: BackendLua},
+		digest:   digest,
+		snapshot: bytes.Clone(source),
+	}, nil
+}
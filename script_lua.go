@@ -0,0 +1,45 @@
+//go:build reflektor_lua || reflektor_full
+
+package reflektor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sliverarmory/reflektor/luamod"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+const luaBuilt = true
+
+func loadScript(source []byte) (payload, error) {
+	module, err := luamod.LoadScript(source)
+	if err != nil {
+		return nil, err
+	}
+	return scriptPayload{module}, nil
+}
+
+// scriptPayload adapts a luamod.Module to the payload interface.
+type scriptPayload struct {
+	*luamod.Module
+}
+
+func (script scriptPayload) CallExportArgs(name string, args ...uintptr) (memmod.CallResult, error) {
+	start := time.Now()
+	value, err := script.Call(name, args...)
+	if err != nil {
+		return memmod.CallResult{}, err
+	}
+	return memmod.CallResult{Value: value, Duration: time.Since(start)}, nil
+}
+
+func (script scriptPayload) StartExportThread(name string, opts memmod.ThreadOptions) (func() memmod.CallResult, error) {
+	_, _ = name, opts
+	return nil, errors.New("script payloads cannot run on native threads")
+}
+
+func (script scriptPayload) StartEntry(argv []string) (func(), error) {
+	_ = argv
+	return nil, errors.New("script payloads have no entry point")
+}
@@ -0,0 +1,12 @@
+//go:build !reflektor_lua && !reflektor_full
+
+package reflektor
+
+import "fmt"
+
+const luaBuilt = false
+
+func loadScript(source []byte) (payload, error) {
+	_ = source
+	return nil, fmt.Errorf("%w: Lua backend", ErrNotBuilt)
+}
@@ -0,0 +1,109 @@
+//go:build reflektor_lua || reflektor_full
+
+package reflektor_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sliverarmory/reflektor"
+)
+
+func TestLoadLibraryFileSelectsScriptBackend(t *testing.T) {
+	markerPath := filepath.Join(t.TempDir(), "marker.txt")
+	t.Setenv("REFLEKTOR_MARKER", markerPath)
+
+	lib, err := reflektor.LoadLibraryFile(filepath.Join("testdata", "lua", "basic.lua"))
+	if err != nil {
+		t.Fatalf("LoadLibraryFile: %v", err)
+	}
+	if err := lib.CallExport("StartW"); err != nil {
+		t.Fatalf("CallExport(StartW): %v", err)
+	}
+	if got, err := os.ReadFile(markerPath); err != nil || string(got) != "ok" {
+		t.Fatalf("unexpected marker: %q, %v", got, err)
+	}
+	result, err := lib.CallExportResult("Answer")
+	if err != nil || result.Value != 42 {
+		t.Fatalf("CallExportResult(Answer) = %+v, %v", result, err)
+	}
+	if value, err := lib.Call("Add", 40, 2); err != nil || value != 42 {
+		t.Fatalf("Call(Add, 40, 2) = %d, %v", value, err)
+	}
+
+	if err := lib.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := lib.CallExport("StartW"); !errors.Is(err, reflektor.ErrLibraryClosed) {
+		t.Fatalf("CallExport after Close: got %v, want ErrLibraryClosed", err)
+	}
+}
+
+func TestAuditLogRecordsChainedOperations(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "lua", "basic.lua"))
+	if err != nil {
+		t.Fatalf("read script: %v", err)
+	}
+	key := []byte("audit key")
+	var buf bytes.Buffer
+	writer := reflektor.NewAuditWriter(&buf)
+	reflektor.SetAuditLog(writer, key)
+	defer reflektor.SetAuditLog(nil, nil)
+
+	lib, err := reflektor.LoadScript(source)
+	if err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+	if value, err := lib.Call("Add", 40, 2); err != nil || value != 42 {
+		t.Fatalf("Call(Add, 40, 2) = %d, %v", value, err)
+	}
+	if err := lib.CallExport("Missing"); err == nil {
+		t.Fatal("CallExport(Missing) succeeded")
+	}
+	if err := lib.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := writer.Err(); err != nil {
+		t.Fatalf("AuditWriter: %v", err)
+	}
+
+	events, err := reflektor.ReadAuditLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadAuditLog: %v", err)
+	}
+	if err := reflektor.VerifyAuditChain(events, key); err != nil {
+		t.Fatalf("VerifyAuditChain: %v", err)
+	}
+	sum := sha256.Sum256(source)
+	digest := hex.EncodeToString(sum[:])
+	want := []reflektor.AuditOp{reflektor.AuditLoad, reflektor.AuditCall, reflektor.AuditCall, reflektor.AuditClose}
+	if len(events) != len(want) {
+		t.Fatalf("recorded %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.Op != want[i] || event.Payload != digest {
+			t.Fatalf("event %d = %+v, want %s of payload %s", i, event, want[i], digest)
+		}
+	}
+	if call := events[1]; call.Export != "Add" || !slices.Equal(call.Args, []uintptr{40, 2}) || call.Result.Value != 42 || call.Err != "" {
+		t.Fatalf("Add event = %+v", call)
+	}
+	if events[2].Export != "Missing" || events[2].Err == "" {
+		t.Fatalf("Missing event = %+v, want its error", events[2])
+	}
+
+	events[1].Result.Value = 41
+	if err := reflektor.VerifyAuditChain(events, key); !errors.Is(err, reflektor.ErrAuditChain) {
+		t.Fatalf("VerifyAuditChain of an altered event: err = %v, want ErrAuditChain", err)
+	}
+	events[1].Result.Value = 42
+	if err := reflektor.VerifyAuditChain(events[1:], key); !errors.Is(err, reflektor.ErrAuditChain) {
+		t.Fatalf("VerifyAuditChain without the first event: err = %v, want ErrAuditChain", err)
+	}
+}
@@ -0,0 +1,148 @@
+package reflektor
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// LoadLibrarySet loads a group of shared library images from memory, keyed by
+// the name other images in the set depend on them by (a DT_NEEDED soname or a
+// DLL name). An image's dependencies that are in the set are loaded first and
+// its imports bound to them, so they never have to exist on disk. It returns
+// the loaded libraries under the same keys.
+func LoadLibrarySet(images map[string][]byte) (map[string]*Library, error) {
+	return LoadLibrarySetWithOptions(images, Options{})
+}
+
+// LoadLibrarySetWithOptions is like LoadLibrarySet but loads every image with
+// opts. Imports the set cannot satisfy go to opts.ImportResolver and then to
+// the default resolution. Linux and windows only: dyld loads a darwin image's
+// dependencies itself, so darwin sets whose images depend on each other are
+// rejected.
+func LoadLibrarySetWithOptions(images map[string][]byte, opts Options) (map[string]*Library, error) {
+	loaded, _, err := loadLibrarySet(images, opts)
+	return loaded, err
+}
+
+// loadLibrarySet loads a set and also returns the order the images were
+// loaded in, dependencies first.
+func loadLibrarySet(images map[string][]byte, opts Options) (map[string]*Library, []string, error) {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deps := make(map[string][]string, len(images))
+	for _, name := range names {
+		imported, err := memmod.ImportedLibraries(images[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("reflektor: read dependencies of %s: %w", name, err)
+		}
+		for _, lib := range imported {
+			if member, ok := setMember(names, lib); ok && member != name {
+				deps[name] = append(deps[name], member)
+			}
+		}
+		if len(deps[name]) != 0 && runtime.GOOS == "darwin" {
+			return nil, nil, fmt.Errorf("reflektor: %s depends on %s in the set; dyld cannot bind darwin images to each other from memory", name, deps[name][0])
+		}
+	}
+
+	order, err := dependencyOrder(names, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loaded := make(map[string]*Library, len(images))
+	for i, name := range order {
+		imageOpts := opts
+		imageOpts.ImportResolver = setImportResolver(loaded, deps[name], opts.ImportResolver)
+		library, err := LoadLibraryWithOptions(images[name], imageOpts)
+		if err != nil {
+			for _, done := range slices.Backward(order[:i]) {
+				_ = loaded[done].Close()
+			}
+			return nil, nil, fmt.Errorf("reflektor: load %s: %w", name, err)
+		}
+		loaded[name] = library
+	}
+	return loaded, order, nil
+}
+
+// setMember returns the key in names a dependency refers to. Dependencies
+// match by base name, case-insensitively as windows does for DLLs.
+func setMember(names []string, lib string) (string, bool) {
+	base := filepath.Base(strings.ReplaceAll(lib, `\`, "/"))
+	for _, name := range names {
+		if strings.EqualFold(filepath.Base(strings.ReplaceAll(name, `\`, "/")), base) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// dependencyOrder orders names so every image comes after the set members it
+// depends on.
+func dependencyOrder(names []string, deps map[string][]string) ([]string, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("reflektor: dependency cycle in library set: %s", strings.Join(append(chain, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(chain, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// setImportResolver binds imports to the exports of the already loaded set
+// members in deps, in the order the image lists them, then asks fallback.
+func setImportResolver(loaded map[string]*Library, deps []string, fallback ImportResolver) ImportResolver {
+	if len(deps) == 0 {
+		return fallback
+	}
+	return func(library, symbol string) (uintptr, bool) {
+		for _, dep := range deps {
+			if library != "" {
+				if member, ok := setMember([]string{dep}, library); !ok || member != dep {
+					continue
+				}
+			}
+			if addr, err := loaded[dep].ProcAddress(symbol); err == nil && addr != 0 {
+				return addr, true
+			}
+		}
+		if fallback != nil {
+			return fallback(library, symbol)
+		}
+		return 0, false
+	}
+}
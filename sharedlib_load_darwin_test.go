@@ -5,9 +5,11 @@ package reflektor_test
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/sliverarmory/reflektor"
@@ -62,3 +64,73 @@ func TestLoadGeneratedCDylibAndCallStartW(t *testing.T) {
 		t.Fatalf("unexpected marker bytes: got=%q want=%q", got, []byte("ok"))
 	}
 }
+
+func TestRunInitializersAfterSkip(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dylibPath := buildNamedSharedLib(t, t.TempDir(), "initializers", "darwin", runtime.GOARCH)
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", dylibPath, err)
+	}
+
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{SkipInitializers: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions(SkipInitializers): %v", err)
+	}
+	defer lib.Close()
+
+	if got, err := lib.Call("reflektor_was_constructed"); err != nil || got != 0 {
+		t.Fatalf("reflektor_was_constructed() = %d, %v; want 0 before RunInitializers", got, err)
+	}
+	if err := lib.RunInitializers(); err != nil {
+		t.Fatalf("RunInitializers: %v", err)
+	}
+	if got, err := lib.Call("reflektor_was_constructed"); err != nil || got != 1 {
+		t.Fatalf("reflektor_was_constructed() = %d, %v; want 1 after RunInitializers", got, err)
+	}
+	if err := lib.RunInitializers(); err == nil {
+		t.Fatal("second RunInitializers succeeded, want an error")
+	}
+}
+
+// TestConcurrentLoadsCallsAndCloses_Darwin loads, calls, and closes images
+// from several goroutines at once, each image with its own dyld thread. Run
+// it with -race, as CI does.
+func TestConcurrentLoadsCallsAndCloses_Darwin(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dylibPath := buildNamedSharedLib(t, t.TempDir(), "initializers", "darwin", runtime.GOARCH)
+	payload, err := os.ReadFile(dylibPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", dylibPath, err)
+	}
+
+	const workers, iterations = 4, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{Deterministic: true})
+				if err != nil {
+					errs <- fmt.Errorf("LoadLibraryWithOptions: %w", err)
+					return
+				}
+				got, err := lib.Call("reflektor_was_constructed")
+				lib.Close()
+				if err != nil || got != 1 {
+					errs <- fmt.Errorf("reflektor_was_constructed() = %d, %v; want 1", got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -4,12 +4,34 @@ package reflektor_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/loadertest"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/unix"
 )
 
 func TestLoadGeneratedCLinuxSOAndCallStartW(t *testing.T) {
@@ -46,3 +68,1563 @@ func TestLoadGeneratedCLinuxSOAndCallStartW(t *testing.T) {
 		t.Fatalf("unexpected marker bytes: got=%q want=%q", got, []byte("ok"))
 	}
 }
+
+func TestCloseDrainsInFlightCallsAndIsIdempotent(t *testing.T) {
+	requireCommand(t, "zig")
+
+	outDir := t.TempDir()
+	soPath := buildOneSharedLib(t, outDir, "linux", runtime.GOARCH)
+	markerPath := filepath.Join(t.TempDir(), "reflektor_close_marker.txt")
+
+	if err := os.Setenv("REFLEKTOR_MARKER", markerPath); err != nil {
+		t.Fatalf("set env REFLEKTOR_MARKER: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Unsetenv("REFLEKTOR_MARKER")
+	})
+
+	lib, err := reflektor.LoadLibraryFile(soPath)
+	if err != nil {
+		t.Fatalf("LoadLibraryFile(%s): %v", soPath, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				if err := lib.CallExport("StartW"); err != nil {
+					if !errors.Is(err, reflektor.ErrLibraryClosed) {
+						t.Errorf("CallExport(StartW): %v", err)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	if err := lib.CloseWithTimeout(10 * time.Second); err != nil {
+		t.Fatalf("CloseWithTimeout: %v", err)
+	}
+	wg.Wait()
+
+	if err := lib.CallExport("StartW"); !errors.Is(err, reflektor.ErrLibraryClosed) {
+		t.Fatalf("CallExport after Close: got %v, want ErrLibraryClosed", err)
+	}
+	if err := lib.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestSingleThreadedLibraryCallsStartW(t *testing.T) {
+	requireCommand(t, "zig")
+
+	outDir := t.TempDir()
+	soPath := buildOneSharedLib(t, outDir, "linux", runtime.GOARCH)
+	markerPath := filepath.Join(t.TempDir(), "reflektor_single_thread_marker.txt")
+
+	if err := os.Setenv("REFLEKTOR_MARKER", markerPath); err != nil {
+		t.Fatalf("set env REFLEKTOR_MARKER: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Unsetenv("REFLEKTOR_MARKER")
+	})
+
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{SingleThreaded: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = lib.Close()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := lib.CallExport("StartW"); err != nil {
+				t.Errorf("CallExport(StartW): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(markerPath)
+	if err != nil {
+		t.Fatalf("read marker %s: %v", markerPath, err)
+	}
+	if !bytes.Equal(got, []byte("ok")) {
+		t.Fatalf("unexpected marker bytes: got=%q want=%q", got, []byte("ok"))
+	}
+}
+
+func TestSingleThreadedRunsInitializersOnCallThread(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "threadaffine", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	// Hold this goroutine's thread through the load and a first call, so
+	// the library's thread is another one and only a load there passes.
+	runtime.LockOSThread()
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{SingleThreaded: true})
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+	got, err := lib.Call("reflektor_on_constructor_thread")
+	runtime.UnlockOSThread()
+	if err != nil || got != 1 {
+		t.Fatalf("reflektor_on_constructor_thread() = %d, %v; want 1", got, err)
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := lib.Call("reflektor_on_constructor_thread"); err != nil || got != 1 {
+				t.Errorf("reflektor_on_constructor_thread() = %d, %v; want 1", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStartExportRunsInBackground(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "blocking", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer lib.Close()
+
+	handle, err := lib.StartExport("reflektor_block")
+	if err != nil {
+		t.Fatalf("StartExport: %v", err)
+	}
+	if !handle.Running() {
+		t.Fatal("Running() = false before the export was released")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := handle.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait on a blocked export: err = %v, want DeadlineExceeded", err)
+	}
+	if err := handle.Kill(); !errors.Is(err, reflektor.ErrKillUnsupported) {
+		t.Fatalf("Kill: err = %v, want ErrKillUnsupported", err)
+	}
+
+	if err := lib.CallExport("reflektor_release"); err != nil {
+		t.Fatalf("CallExport(reflektor_release): %v", err)
+	}
+	result, err := handle.Wait(context.Background())
+	if err != nil || result.Value != 7 {
+		t.Fatalf("Wait = %d, %v; want 7", result.Value, err)
+	}
+	if handle.Running() {
+		t.Fatal("Running() = true after Wait returned")
+	}
+	if err := handle.Kill(); err != nil {
+		t.Fatalf("Kill after the export returned: %v", err)
+	}
+}
+
+func TestNativeThreadLibraryCallsStartW(t *testing.T) {
+	requireCommand(t, "zig")
+
+	outDir := t.TempDir()
+	soPath := buildOneSharedLib(t, outDir, "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	for _, detached := range []bool{false, true} {
+		t.Run(fmt.Sprintf("detached=%v", detached), func(t *testing.T) {
+			markerPath := filepath.Join(t.TempDir(), "reflektor_native_thread_marker.txt")
+			if err := os.Setenv("REFLEKTOR_MARKER", markerPath); err != nil {
+				t.Fatalf("set env REFLEKTOR_MARKER: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = os.Unsetenv("REFLEKTOR_MARKER")
+			})
+
+			lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{
+				Thread: &reflektor.ThreadOptions{
+					StackSize:   16 << 20,
+					Name:        "reflektor-test",
+					CPUAffinity: []int{0},
+					Detached:    detached,
+				},
+			})
+			if err != nil {
+				t.Fatalf("LoadLibraryWithOptions: %v", err)
+			}
+
+			if err := lib.CallExport("StartW"); err != nil {
+				_ = lib.Close()
+				if strings.Contains(err.Error(), "require cgo") {
+					t.Skipf("native export threads unavailable: %v", err)
+				}
+				t.Fatalf("CallExport(StartW): %v", err)
+			}
+			// Close waits for detached calls, so the marker is complete after it.
+			if err := lib.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			got, err := os.ReadFile(markerPath)
+			if err != nil {
+				t.Fatalf("read marker %s: %v", markerPath, err)
+			}
+			if !bytes.Equal(got, []byte("ok")) {
+				t.Fatalf("unexpected marker bytes: got=%q want=%q", got, []byte("ok"))
+			}
+		})
+	}
+}
+
+func TestNativeThreadCallKeepsCallerSignalMask(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "signals", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{PreserveSignals: true, Thread: &reflektor.ThreadOptions{}})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	// Block a signal the mask saved at load time leaves unblocked; the call
+	// must not put that older mask on this thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var block, old, current unix.Sigset_t
+	block.Val[0] = 1 << (unix.SIGUSR1 - 1)
+	if err := unix.PthreadSigmask(unix.SIG_BLOCK, &block, &old); err != nil {
+		t.Fatalf("block SIGUSR1: %v", err)
+	}
+	defer unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+
+	if err := lib.CallExport("StartW"); err != nil {
+		if strings.Contains(err.Error(), "require cgo") {
+			t.Skip("native export threads require cgo")
+		}
+		t.Fatalf("CallExport(StartW): %v", err)
+	}
+	if err := unix.PthreadSigmask(unix.SIG_SETMASK, nil, &current); err != nil {
+		t.Fatalf("read signal mask: %v", err)
+	}
+	if current.Val[0]&block.Val[0] == 0 {
+		t.Fatal("calling an export on a native thread reset the caller's signal mask")
+	}
+}
+
+func TestCallExportResultReportsValueAndErrno(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	for _, opts := range []reflektor.Options{
+		{},
+		{SingleThreaded: true},
+		{Thread: &reflektor.ThreadOptions{}},
+	} {
+		lib, err := reflektor.LoadLibraryWithOptions(payload, opts)
+		if err != nil {
+			t.Fatalf("LoadLibraryWithOptions(%+v): %v", opts, err)
+		}
+
+		result, err := lib.CallExportResult("reflektor_set_errno")
+		_ = lib.Close()
+		if err != nil {
+			if opts.Thread != nil && strings.Contains(err.Error(), "require cgo") {
+				continue
+			}
+			t.Fatalf("CallExportResult(%+v): %v", opts, err)
+		}
+		if int32(result.Value) != 42 || result.Errno != syscall.ENOENT {
+			t.Fatalf("unexpected result with %+v: value=%d errno=%v", opts, int32(result.Value), result.Errno)
+		}
+		if result.ThreadID == 0 || result.Duration <= 0 || result.Crashed() {
+			t.Fatalf("unexpected result with %+v: thread=%d duration=%v crashed=%v", opts, result.ThreadID, result.Duration, result.Crashed())
+		}
+	}
+}
+
+func TestCallExportStatusReportsNonZeroStatus(t *testing.T) {
+	requireCommand(t, "zig")
+	t.Setenv("REFLEKTOR_MARKER", filepath.Join(t.TempDir(), "marker.txt"))
+
+	dir := t.TempDir()
+	basic, err := os.ReadFile(buildOneSharedLib(t, dir, "linux", runtime.GOARCH))
+	if err != nil {
+		t.Fatalf("read basic: %v", err)
+	}
+	lib, err := reflektor.LoadLibrary(basic)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer lib.Close()
+	status, err := lib.CallExportStatus("StartWStatus")
+	var statusErr *reflektor.ExportStatusError
+	if status != 1337 || !errors.As(err, &statusErr) || statusErr.Status != 1337 || statusErr.Name != "StartWStatus" {
+		t.Fatalf("CallExportStatus(StartWStatus) = %d, %v; want 1337 and an ExportStatusError", status, err)
+	}
+
+	blocking, err := os.ReadFile(buildNamedSharedLib(t, dir, "blocking", "linux", runtime.GOARCH))
+	if err != nil {
+		t.Fatalf("read blocking: %v", err)
+	}
+	zero, err := reflektor.LoadLibrary(blocking)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer zero.Close()
+	if status, err := zero.CallExportStatus("reflektor_release"); status != 0 || err != nil {
+		t.Fatalf("CallExportStatus(reflektor_release) = %d, %v; want 0, nil", status, err)
+	}
+}
+
+func TestCallPolicyRefusesCalls(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{
+		CallPolicy: &reflektor.CallPolicy{
+			Windows:  []reflektor.CallWindow{{Start: 8 * time.Hour, End: 17 * time.Hour}},
+			Location: time.UTC,
+			MaxCalls: 2,
+			Cooldown: time.Minute,
+			Now:      func() time.Time { return now },
+		},
+	})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	call := func() error {
+		_, err := lib.CallExportResult("reflektor_set_errno")
+		return err
+	}
+	if err := call(); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if err := call(); !errors.Is(err, reflektor.ErrCallNotAllowed) || !strings.Contains(err.Error(), "cooling down") {
+		t.Fatalf("call during cooldown: err = %v, want ErrCallNotAllowed", err)
+	}
+	now = time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	if err := call(); !errors.Is(err, reflektor.ErrCallNotAllowed) || !strings.Contains(err.Error(), "outside") {
+		t.Fatalf("call outside the window: err = %v, want ErrCallNotAllowed", err)
+	}
+	now = time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)
+	if _, err := lib.StartExport("reflektor_set_errno"); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := call(); !errors.Is(err, reflektor.ErrCallNotAllowed) || !strings.Contains(err.Error(), "limit") {
+		t.Fatalf("call past MaxCalls: err = %v, want ErrCallNotAllowed", err)
+	}
+
+	if _, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{
+		CallPolicy: &reflektor.CallPolicy{Windows: []reflektor.CallWindow{{Start: time.Hour, End: time.Hour}}},
+	}); err == nil {
+		t.Fatal("LoadLibraryWithOptions accepted an empty call window")
+	}
+}
+
+func TestCallPassesArguments(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	for _, opts := range []reflektor.Options{{}, {SingleThreaded: true}} {
+		lib, err := reflektor.LoadLibraryWithOptions(payload, opts)
+		if err != nil {
+			t.Fatalf("LoadLibraryWithOptions(%+v): %v", opts, err)
+		}
+
+		got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6)
+		if err != nil {
+			t.Fatalf("Call(%+v): %v", opts, err)
+		}
+		if got != 91 {
+			t.Fatalf("Call(%+v) = %d, want 91", opts, got)
+		}
+		if got, err := lib.Call("reflektor_weighted_sum", 7); err != nil || got != 7 {
+			t.Fatalf("Call with one argument = %d, %v; want 7", got, err)
+		}
+		if _, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6, 7); err == nil {
+			t.Fatal("Call accepted more than MaxCallArgs arguments")
+		}
+		_ = lib.Close()
+	}
+}
+
+func TestCallExportContextReturnsOnDeadline(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	lib, err := reflektor.LoadLibraryFile(soPath)
+	if err != nil {
+		t.Fatalf("LoadLibraryFile(%s): %v", soPath, err)
+	}
+
+	result, running, err := lib.CallExportContext(context.Background(), "reflektor_set_errno")
+	if err != nil || running {
+		t.Fatalf("CallExportContext(reflektor_set_errno) = running %v, %v", running, err)
+	}
+	if int32(result.Value) != 42 || result.Errno != syscall.ENOENT {
+		t.Fatalf("unexpected result: value=%d errno=%v", int32(result.Value), result.Errno)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, running, err = lib.CallExportContext(ctx, "reflektor_slow")
+	if !running || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CallExportContext(reflektor_slow) = running %v, %v; want running, DeadlineExceeded", running, err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("CallExportContext waited %v for a 20ms deadline", elapsed)
+	}
+	if err := lib.CloseWithTimeout(5 * time.Second); err != nil {
+		t.Fatalf("CloseWithTimeout with a running export: %v", err)
+	}
+
+	if _, running, err := lib.CallExportContext(context.Background(), "reflektor_slow"); running || !errors.Is(err, reflektor.ErrLibraryClosed) {
+		t.Fatalf("CallExportContext after Close = running %v, %v; want ErrLibraryClosed", running, err)
+	}
+}
+
+func TestOpenDispatchesNativeLinuxSO(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildOneSharedLib(t, t.TempDir(), "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	runner, err := reflektor.Open(payload)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer runner.Close()
+
+	if info := runner.Info(); info.Format != reflektor.FormatELF || info.Backend != reflektor.BackendNative || info.Packed {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	exports, err := runner.Exports()
+	if err != nil {
+		t.Fatalf("Exports: %v", err)
+	}
+	found := false
+	for _, name := range exports {
+		found = found || name == "StartW"
+	}
+	if !found {
+		t.Fatalf("StartW missing from exports: %v", exports)
+	}
+
+	packed, err := reflektor.Open(mustPack(t, payload))
+	if err != nil {
+		t.Fatalf("Open(packed): %v", err)
+	}
+	defer packed.Close()
+	if info := packed.Info(); info.Format != reflektor.FormatELF || !info.Packed {
+		t.Fatalf("Open(packed).Info() = %+v, want a packed ELF image", info)
+	}
+}
+
+func TestLockMemoryLocksMappedImage(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildOneSharedLib(t, t.TempDir(), "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	before := lockedKiB(t)
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{LockMemory: true})
+	if err != nil {
+		if errors.Is(err, syscall.ENOMEM) || errors.Is(err, syscall.EPERM) {
+			t.Skipf("mlock not permitted here: %v", err)
+		}
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	if after := lockedKiB(t); after <= before {
+		t.Fatalf("VmLck did not grow after LockMemory: before=%d kB after=%d kB", before, after)
+	}
+}
+
+// lockedKiB returns the process's locked memory from /proc/self/status.
+func lockedKiB(t *testing.T) int {
+	t.Helper()
+
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Fatalf("read /proc/self/status: %v", err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if rest, ok := strings.CutPrefix(line, "VmLck:"); ok {
+			var kib int
+			if _, err := fmt.Sscanf(strings.TrimSpace(rest), "%d kB", &kib); err != nil {
+				t.Fatalf("parse %q: %v", line, err)
+			}
+			return kib
+		}
+	}
+	t.Fatal("VmLck not found in /proc/self/status")
+	return 0
+}
+
+func TestBaseSeedReproducesImageBase(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildOneSharedLib(t, t.TempDir(), "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	loadBase := func(opts reflektor.Options) uintptr {
+		t.Helper()
+		lib, err := reflektor.LoadLibraryWithOptions(payload, opts)
+		if err != nil {
+			t.Fatalf("LoadLibraryWithOptions(%+v): %v", opts, err)
+		}
+		defer lib.Close()
+		return lib.Info().Base
+	}
+
+	first := loadBase(reflektor.Options{BaseSeed: 0x5eed})
+	if first == 0 {
+		t.Fatal("Info().Base is zero for a native image")
+	}
+	if again := loadBase(reflektor.Options{BaseSeed: 0x5eed}); again != first {
+		t.Fatalf("same seed mapped at different bases: %#x then %#x", first, again)
+	}
+	preferred := first + 0x100000
+	if got := loadBase(reflektor.Options{PreferredBase: preferred}); got != preferred {
+		t.Fatalf("PreferredBase %#x not honored: got %#x", preferred, got)
+	}
+
+	deterministic := loadBase(reflektor.Options{Deterministic: true})
+	if again := loadBase(reflektor.Options{Deterministic: true}); again != deterministic {
+		t.Fatalf("Deterministic loads mapped at different bases: %#x then %#x", deterministic, again)
+	}
+}
+
+func TestMappingLimits(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildOneSharedLib(t, t.TempDir(), "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	before := reflektor.MappedBytes()
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	span := reflektor.MappedBytes() - before
+	if span == 0 {
+		t.Fatal("MappedBytes did not grow after a load")
+	}
+
+	if _, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{MaxImageSize: span - 1}); !errors.Is(err, reflektor.ErrImageTooLarge) {
+		t.Fatalf("MaxImageSize below the span: err = %v, want ErrImageTooLarge", err)
+	}
+	budget := reflektor.MappedBytes() + span - 1
+	if _, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{MaxTotalMappedBytes: budget}); !errors.Is(err, reflektor.ErrMappingBudget) {
+		t.Fatalf("MaxTotalMappedBytes below the total: err = %v, want ErrMappingBudget", err)
+	}
+	if got := reflektor.MappedBytes(); got != before+span {
+		t.Fatalf("rejected loads changed MappedBytes: %d, want %d", got, before+span)
+	}
+
+	second, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{MaxImageSize: span, MaxTotalMappedBytes: budget + 1})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions at the limits: %v", err)
+	}
+	_ = second.Close()
+	_ = lib.Close()
+	if got := reflektor.MappedBytes(); got != before {
+		t.Fatalf("MappedBytes after Close = %d, want %d", got, before)
+	}
+}
+
+func TestSkipInitializersAndZeroInput(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "initializers", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	for _, tc := range []struct {
+		skip bool
+		want uintptr
+	}{
+		{false, 1},
+		{true, 0},
+	} {
+		data := append([]byte(nil), payload...)
+		lib, err := reflektor.LoadLibraryWithOptions(data, reflektor.Options{SkipInitializers: tc.skip, ZeroInput: true})
+		if err != nil {
+			t.Fatalf("LoadLibraryWithOptions(SkipInitializers: %v): %v", tc.skip, err)
+		}
+		got, err := lib.Call("reflektor_was_constructed")
+		_ = lib.Close()
+		if err != nil {
+			t.Fatalf("Call: %v", err)
+		}
+		if got != tc.want {
+			t.Fatalf("SkipInitializers %v: constructor ran = %d, want %d", tc.skip, got, tc.want)
+		}
+		if !bytes.Equal(data, make([]byte, len(data))) {
+			t.Fatal("ZeroInput left image bytes in the input buffer")
+		}
+	}
+}
+
+func TestVerifyRelocationsReportsBeforeInitializers(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "initializers", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{VerifyRelocations: true, SkipInitializers: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+	check := lib.Info().Relocations
+	if check == nil || check.Checked == 0 {
+		t.Fatalf("Info().Relocations = %+v, want checked relocations", check)
+	}
+	if len(check.Mismatches) != 0 {
+		t.Fatalf("Info().Relocations.Mismatches = %+v, want none", check.Mismatches)
+	}
+	if got, err := lib.Call("reflektor_was_constructed"); err != nil || got != 0 {
+		t.Fatalf("reflektor_was_constructed() = %d, %v; want 0 before initializers run", got, err)
+	}
+
+	plain, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer plain.Close()
+	if plain.Info().Relocations != nil {
+		t.Fatalf("Info().Relocations = %+v without VerifyRelocations, want nil", plain.Info().Relocations)
+	}
+}
+
+func TestImportResolverRedirectsImports(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "imports", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	plain, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	getppid, err := plain.FindSymbol("getppid")
+	_ = plain.Close()
+	if err != nil {
+		t.Fatalf("FindSymbol(getppid): %v", err)
+	}
+
+	var asked []string
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{
+		ImportResolver: func(library, symbol string) (uintptr, bool) {
+			asked = append(asked, symbol)
+			if symbol == "getpid" {
+				return getppid, true
+			}
+			return 0, false
+		},
+	})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	if !slices.Contains(asked, "getpid") {
+		t.Fatalf("ImportResolver was not asked for getpid; asked for %q", asked)
+	}
+	got, err := lib.Call("reflektor_pid")
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if int(got) != os.Getppid() {
+		t.Fatalf("redirected getpid returned %d, want parent pid %d", got, os.Getppid())
+	}
+}
+
+func TestImportedIndirectFunctionBindsImplementation(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "imports", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer lib.Close()
+
+	s := []byte("reflektor\x00")
+	got, err := lib.Call("reflektor_strlen", uintptr(unsafe.Pointer(&s[0])))
+	runtime.KeepAlive(s)
+	if err != nil || got != 9 {
+		t.Fatalf("reflektor_strlen = %d, %v; want 9", got, err)
+	}
+}
+
+func TestLoadLibrarySetBindsDependenciesFromMemory(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dir := t.TempDir()
+	depPath := buildNamedSharedLib(t, dir, "set_dep", "linux", runtime.GOARCH, "-Wl,-soname,libreflektor_set_dep.so")
+	mainPath := buildNamedSharedLib(t, dir, "set_main", "linux", runtime.GOARCH, "-Wl,--no-as-needed", depPath)
+	images := make(map[string][]byte)
+	for name, path := range map[string]string{"libreflektor_set_dep.so": depPath, "set_main.so": mainPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		images[name] = data
+		// The dependency must come from the set, not the build directory.
+		_ = os.Remove(path)
+	}
+
+	if _, err := reflektor.LoadLibrary(images["set_main.so"]); err == nil {
+		t.Fatal("LoadLibrary resolved reflektor_dep_value without the set")
+	}
+
+	libs, err := reflektor.LoadLibrarySet(images)
+	if err != nil {
+		t.Fatalf("LoadLibrarySet: %v", err)
+	}
+	defer func() {
+		for _, lib := range libs {
+			_ = lib.Close()
+		}
+	}()
+	got, err := libs["set_main.so"].Call("reflektor_set_value")
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if got != 43 {
+		t.Fatalf("reflektor_set_value() = %d, want 43", got)
+	}
+}
+
+func TestLoadGroupSharesLifetime(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dir := t.TempDir()
+	depPath := buildNamedSharedLib(t, dir, "set_dep", "linux", runtime.GOARCH, "-Wl,-soname,libreflektor_set_dep.so")
+	mainPath := buildNamedSharedLib(t, dir, "set_main", "linux", runtime.GOARCH, "-Wl,--no-as-needed", depPath)
+	images := make(map[string][]byte)
+	for name, path := range map[string]string{"libreflektor_set_dep.so": depPath, "set_main.so": mainPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		images[name] = data
+		_ = os.Remove(path)
+	}
+
+	group, err := reflektor.LoadGroup("bundle", images)
+	if err != nil {
+		t.Fatalf("LoadGroup: %v", err)
+	}
+	if got := group.Members(); !slices.Equal(got, []string{"libreflektor_set_dep.so", "set_main.so"}) {
+		t.Fatalf("Members() = %v, want the dependency first", got)
+	}
+	exports, err := group.Exports()
+	if err != nil {
+		t.Fatalf("Exports: %v", err)
+	}
+	for _, name := range []string{"reflektor_dep_value", "reflektor_set_value"} {
+		if !slices.Contains(exports, name) {
+			t.Errorf("Exports() = %v, missing %s", exports, name)
+		}
+	}
+	if owner, err := group.Owner("reflektor_dep_value"); err != nil || owner != "libreflektor_set_dep.so" {
+		t.Errorf("Owner(reflektor_dep_value) = %q, %v", owner, err)
+	}
+	for name, want := range map[string]uintptr{"reflektor_dep_value": 42, "reflektor_set_value": 43} {
+		if got, err := group.Call(name); err != nil || got != want {
+			t.Errorf("Call(%s) = %d, %v; want %d", name, got, err, want)
+		}
+	}
+	if _, err := group.Call("reflektor_missing"); err == nil {
+		t.Error("Call of an export no member has succeeded")
+	}
+
+	dep := group.Library("libreflektor_set_dep.so")
+	if err := group.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := group.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := dep.Call("reflektor_dep_value"); !errors.Is(err, reflektor.ErrLibraryClosed) {
+		t.Fatalf("member call after group Close: err = %v, want ErrLibraryClosed", err)
+	}
+}
+
+func TestSealedPackedLoadZeroesUnpackedImage(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	// A transform hands the loader the unpacked image in a buffer the test
+	// can look at afterwards.
+	var unpacked []byte
+	magic := []byte("RFKZ")
+	compress.RegisterTransform("test-zero-unpacked", magic, func(data []byte, limit uint64) ([]byte, error) {
+		unpacked = bytes.Clone(data)
+		return unpacked, nil
+	})
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	sealed, err := reflektor.SealLibrary(append(bytes.Clone(magic), payload...), key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("SealLibrary: %v", err)
+	}
+
+	lib, err := reflektor.LoadEncryptedLibrary(sealed, key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("LoadEncryptedLibrary: %v", err)
+	}
+	defer lib.Close()
+	if !lib.Info().Packed {
+		t.Fatal("Info().Packed = false for a packed sealed image")
+	}
+	if len(unpacked) != len(payload) || !bytes.Equal(unpacked, make([]byte, len(unpacked))) {
+		t.Fatal("the unpacked image was left in memory after the load")
+	}
+}
+
+func TestLoadEncryptedLibrary(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	key := bytes.Repeat([]byte{0x5a}, 32)
+
+	for _, scheme := range reflektor.Features().Ciphers {
+		var aead cipher.AEAD
+		if scheme == reflektor.CipherAES256GCM {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				t.Fatalf("aes.NewCipher: %v", err)
+			}
+			aead, err = cipher.NewGCM(block)
+			if err != nil {
+				t.Fatalf("cipher.NewGCM: %v", err)
+			}
+		} else {
+			aead, err = chacha20poly1305.New(key)
+			if err != nil {
+				t.Fatalf("chacha20poly1305.New: %v", err)
+			}
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatalf("read nonce: %v", err)
+		}
+		sealed := aead.Seal(nonce, nonce, payload, nil)
+
+		lib, err := reflektor.LoadEncryptedLibrary(sealed, key, scheme)
+		if err != nil {
+			t.Fatalf("LoadEncryptedLibrary(%s): %v", scheme, err)
+		}
+		got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6)
+		_ = lib.Close()
+		if err != nil || got != 91 {
+			t.Fatalf("%s: Call = %d, %v; want 91", scheme, got, err)
+		}
+
+		wrong := bytes.Repeat([]byte{0xa5}, 32)
+		if _, err := reflektor.LoadEncryptedLibrary(sealed, wrong, scheme); !errors.Is(err, reflektor.ErrDecrypt) {
+			t.Fatalf("%s with the wrong key: err = %v, want ErrDecrypt", scheme, err)
+		}
+
+		// SealLibrary produces the same container, and packed images inside
+		// it are unpacked once decrypted.
+		sealed, err = reflektor.SealLibrary(mustPack(t, payload), key, scheme)
+		if err != nil {
+			t.Fatalf("SealLibrary(%s): %v", scheme, err)
+		}
+		lib, err = reflektor.LoadEncryptedLibrary(sealed, key, scheme)
+		if err != nil {
+			t.Fatalf("LoadEncryptedLibrary(SealLibrary(%s)): %v", scheme, err)
+		}
+		got, err = lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6)
+		_ = lib.Close()
+		if err != nil || got != 91 {
+			t.Fatalf("%s sealed and packed: Call = %d, %v; want 91", scheme, got, err)
+		}
+	}
+}
+
+func TestLoadLibraryThroughSealedTransform(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	key := bytes.Repeat([]byte{0x3c}, 32)
+	open, err := reflektor.SealedTransform(key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("SealedTransform: %v", err)
+	}
+	magic := []byte("RFKTEST")
+	compress.RegisterTransform("test-sealed", magic, open)
+
+	sealed, err := reflektor.SealLibrary(mustPack(t, payload), key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("SealLibrary: %v", err)
+	}
+	lib, err := reflektor.LoadLibrary(append(magic, sealed...))
+	if err != nil {
+		t.Fatalf("LoadLibrary of a sealed, packed payload: %v", err)
+	}
+	got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6)
+	_ = lib.Close()
+	if err != nil || got != 91 {
+		t.Fatalf("Call = %d, %v; want 91", got, err)
+	}
+}
+
+func TestLibraryInfoDescribesImage(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	info := lib.Info()
+	_ = lib.Close()
+	if info.Arch != runtime.GOARCH {
+		t.Fatalf("Info().Arch = %q, want %q", info.Arch, runtime.GOARCH)
+	}
+	if !slices.ContainsFunc(info.Imports, func(name string) bool { return strings.HasPrefix(name, "libc.so") }) {
+		t.Fatalf("Info().Imports = %q, want libc among them", info.Imports)
+	}
+	if info.Size == 0 || info.Size%uint64(os.Getpagesize()) != 0 {
+		t.Fatalf("Info().Size = %#x, want a nonzero number of pages", info.Size)
+	}
+	if info.Entry != 0 && (info.Entry < info.Base || uint64(info.Entry-info.Base) >= info.Size) {
+		t.Fatalf("Info().Entry = %#x, outside the image at %#x size %#x", info.Entry, info.Base, info.Size)
+	}
+	if info.Packed || info.Encrypted {
+		t.Fatalf("plain payload: Info() Packed = %v, Encrypted = %v; want both false", info.Packed, info.Encrypted)
+	}
+
+	lib, err = reflektor.LoadLibrary(mustPack(t, payload))
+	if err != nil {
+		t.Fatalf("LoadLibrary(packed): %v", err)
+	}
+	info = lib.Info()
+	_ = lib.Close()
+	if !info.Packed || info.Encrypted || info.Arch != runtime.GOARCH {
+		t.Fatalf("packed payload: Info() Packed = %v, Encrypted = %v, Arch = %q", info.Packed, info.Encrypted, info.Arch)
+	}
+
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	sealed, err := reflektor.SealLibrary(payload, key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("SealLibrary: %v", err)
+	}
+	lib, err = reflektor.LoadEncryptedLibrary(sealed, key, reflektor.CipherAES256GCM)
+	if err != nil {
+		t.Fatalf("LoadEncryptedLibrary: %v", err)
+	}
+	info = lib.Info()
+	_ = lib.Close()
+	if info.Packed || !info.Encrypted {
+		t.Fatalf("sealed payload: Info() Packed = %v, Encrypted = %v; want false, true", info.Packed, info.Encrypted)
+	}
+}
+
+func TestLoadLibraryPicksBundleSlice(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	bundle, err := compress.Bundle([]compress.BundleSlice{
+		{Target: "windows/" + runtime.GOARCH, Image: []byte("MZ not this one")},
+		{Target: "linux/" + runtime.GOARCH, Image: mustPack(t, payload)},
+	})
+	if err != nil {
+		t.Fatalf("Bundle: %v", err)
+	}
+
+	for name, load := range map[string]func() (*reflektor.Library, error){
+		"LoadLibrary": func() (*reflektor.Library, error) { return reflektor.LoadLibrary(bundle) },
+		"LoadLibraryFromReader": func() (*reflektor.Library, error) {
+			return reflektor.LoadLibraryFromReader(bytes.NewReader(bundle))
+		},
+	} {
+		lib, err := load()
+		if err != nil {
+			t.Fatalf("%s(bundle): %v", name, err)
+		}
+		got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6)
+		_ = lib.Close()
+		if err != nil || got != 91 {
+			t.Fatalf("%s(bundle): Call = %d, %v; want 91", name, got, err)
+		}
+	}
+
+	elsewhere, err := compress.Bundle([]compress.BundleSlice{{Target: "windows/" + runtime.GOARCH, Image: []byte("MZ")}})
+	if err != nil {
+		t.Fatalf("Bundle: %v", err)
+	}
+	if _, err := reflektor.LoadLibrary(elsewhere); !errors.Is(err, compress.ErrNoBundleSlice) {
+		t.Fatalf("LoadLibrary without a linux slice: err = %v, want ErrNoBundleSlice", err)
+	}
+}
+
+func TestLoadErrorsAreClassified(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	_, err = lib.Call("reflektor_missing_export")
+	_ = lib.Close()
+	if !errors.Is(err, reflektor.ErrSymbolNotFound) {
+		t.Fatalf("Call of a missing export: err = %v, want ErrSymbolNotFound", err)
+	}
+
+	// Another architecture's e_machine.
+	foreign := bytes.Clone(payload)
+	machine := elf.EM_AARCH64
+	if runtime.GOARCH == "arm64" {
+		machine = elf.EM_X86_64
+	}
+	binary.LittleEndian.PutUint16(foreign[0x12:], uint16(machine))
+	if _, err := reflektor.LoadLibrary(foreign); !errors.Is(err, reflektor.ErrForeignPlatform) {
+		t.Fatalf("LoadLibrary of an %s image: err = %v, want ErrForeignPlatform", machine, err)
+	}
+	// Another operating system's format.
+	if _, err := reflektor.LoadLibrary(append([]byte("MZ"), make([]byte, 0x100)...)); !errors.Is(err, reflektor.ErrForeignPlatform) {
+		t.Fatalf("LoadLibrary of a PE image: err = %v, want ErrForeignPlatform", err)
+	}
+}
+
+func TestLoadPackedLibrary(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	packed := mustPack(t, payload)
+
+	lib, err := reflektor.LoadLibraryWithOptions(packed, reflektor.Options{ZeroInput: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions(packed): %v", err)
+	}
+	defer lib.Close()
+	if lib.Info().Format != reflektor.FormatELF {
+		t.Fatalf("Info().Format = %q, want %q", lib.Info().Format, reflektor.FormatELF)
+	}
+	if got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6); err != nil || got != 91 {
+		t.Fatalf("Call = %d, %v; want 91", got, err)
+	}
+	if bytes.ContainsFunc(packed, func(r rune) bool { return r != 0 }) {
+		t.Fatal("ZeroInput left the packed buffer intact")
+	}
+
+	if _, err := reflektor.LoadLibraryWithOptions(mustPack(t, payload), reflektor.Options{MaxImageSize: uint64(len(payload) - 1)}); !errors.Is(err, reflektor.ErrImageTooLarge) {
+		t.Fatalf("packed image over MaxImageSize: err = %v, want ErrImageTooLarge", err)
+	}
+
+	if !slices.Contains(reflektor.Features().Compression, string(compress.CodecZstd)) {
+		return
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter: %v", err)
+	}
+	runner, err := reflektor.Open(encoder.EncodeAll(payload, nil))
+	encoder.Close()
+	if err != nil {
+		t.Fatalf("Open(zstd): %v", err)
+	}
+	defer runner.Close()
+	if runner.Info().Format != reflektor.FormatELF || !runner.Info().Packed {
+		t.Fatalf("Open(zstd).Info() = %+v, want a packed ELF image", runner.Info())
+	}
+}
+
+func TestSnapshotReloadsPreparedImage(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	plain, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	if _, err := plain.Snapshot(); !errors.Is(err, reflektor.ErrNoSnapshot) {
+		t.Fatalf("Snapshot without Options.Snapshot: err = %v, want ErrNoSnapshot", err)
+	}
+	plain.Close()
+
+	lib, err := reflektor.LoadLibraryWithOptions(mustPack(t, payload), reflektor.Options{Snapshot: true, ZeroInput: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	snapshot, err := lib.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if !bytes.Equal(snapshot.Image, payload) {
+		t.Fatal("snapshot does not hold the unpacked image")
+	}
+	data, err := snapshot.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	base := lib.Info().Base
+	lib.Close()
+	if _, err := lib.Snapshot(); !errors.Is(err, reflektor.ErrLibraryClosed) {
+		t.Fatalf("Snapshot after Close: err = %v, want ErrLibraryClosed", err)
+	}
+
+	restored, err := reflektor.LoadSnapshot(data, reflektor.Options{})
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	defer restored.Close()
+	if got := restored.Info().Base; got != base {
+		t.Fatalf("restored base = %#x, want the snapshot's %#x", got, base)
+	}
+	if got, err := restored.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6); err != nil || got != 91 {
+		t.Fatalf("Call = %d, %v; want 91", got, err)
+	}
+
+	data[len(data)/2] ^= 0xff
+	if _, err := reflektor.LoadSnapshot(data, reflektor.Options{}); !errors.Is(err, reflektor.ErrBadSnapshot) {
+		t.Fatalf("LoadSnapshot of corrupted data: err = %v, want ErrBadSnapshot", err)
+	}
+	snapshot.GOARCH = "other"
+	if _, err := snapshot.Load(reflektor.Options{}); !errors.Is(err, reflektor.ErrBadSnapshot) {
+		t.Fatalf("Load of a foreign snapshot: err = %v, want ErrBadSnapshot", err)
+	}
+}
+
+func TestOverlayKeepsAppendedData(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	config := []byte(`{"callback":"https://example.invalid"}`)
+
+	lib, err := reflektor.LoadLibraryWithOptions(append(payload, config...), reflektor.Options{ZeroInput: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	if got := lib.Overlay(); !bytes.Equal(got, config) {
+		t.Fatalf("Overlay() = %q, want %q", got, config)
+	}
+	lib.Close()
+	if got := lib.Overlay(); got != nil {
+		t.Fatalf("Overlay() after Close = %q, want nil", got)
+	}
+
+	plain, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer plain.Close()
+	if got := plain.Overlay(); got != nil {
+		t.Fatalf("Overlay() of an image without one = %q, want nil", got)
+	}
+}
+
+func mustPack(t *testing.T, data []byte) []byte {
+	t.Helper()
+	packed, err := compress.PackAP32(data)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+	return packed
+}
+
+func TestLoadLibraryFromReader(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dir := t.TempDir()
+	soPath := buildNamedSharedLib(t, dir, "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	check := func(name string, lib *reflektor.Library, err error, packed bool) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer lib.Close()
+		if lib.Info().Packed != packed {
+			t.Fatalf("%s: Info().Packed = %v, want %v", name, lib.Info().Packed, packed)
+		}
+		if got, err := lib.Call("reflektor_weighted_sum", 1, 2, 3, 4, 5, 6); err != nil || got != 91 {
+			t.Fatalf("%s: Call = %d, %v; want 91", name, got, err)
+		}
+	}
+
+	lib, err := reflektor.LoadLibraryFromReader(bytes.NewReader(payload))
+	check("plain reader", lib, err, false)
+
+	packedPath := filepath.Join(dir, "callresult.so.ap32")
+	if err := os.WriteFile(packedPath, mustPack(t, payload), 0o600); err != nil {
+		t.Fatalf("write packed payload: %v", err)
+	}
+	lib, err = reflektor.LoadLibraryFile(packedPath)
+	check("packed file", lib, err, true)
+
+	if slices.Contains(reflektor.Features().Compression, string(compress.CodecZstd)) {
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatalf("zstd.NewWriter: %v", err)
+		}
+		packed := encoder.EncodeAll(payload, nil)
+		encoder.Close()
+		lib, err = reflektor.LoadLibraryFromReader(bytes.NewReader(packed))
+		check("zstd reader", lib, err, true)
+	}
+
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("cipher.NewGCM: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, payload, nil)
+	lib, err = reflektor.LoadEncryptedLibraryFromReader(bytes.NewReader(sealed), key, reflektor.CipherAES256GCM)
+	check("encrypted reader", lib, err, false)
+}
+
+func TestFindExportMatchesDemangledNames(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "mangled", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer lib.Close()
+
+	exports, err := lib.FindExport(`^agent::start\(`)
+	if err != nil {
+		t.Fatalf("FindExport: %v", err)
+	}
+	if len(exports) != 1 || exports[0].Name != "_ZN5agent5startEv" || exports[0].Demangled != "agent::start()" || exports[0].Address == 0 {
+		t.Fatalf("FindExport(agent::start) = %+v", exports)
+	}
+	if got, err := lib.Call(exports[0].Name); err != nil || got != 7 {
+		t.Fatalf("Call(%s) = %d, %v; want 7", exports[0].Name, got, err)
+	}
+	start := exports[0].Address
+
+	exports, err = lib.FindExport(`^agent::`)
+	if err != nil || len(exports) != 2 || exports[0].Demangled != "agent::stop(int)" {
+		t.Fatalf("FindExport(agent::) = %+v, %v; want stop and start sorted by name", exports, err)
+	}
+	exports, err = lib.FindExport(`^Agent\.Beacon\.run$`)
+	if err != nil || len(exports) != 1 || exports[0].Name != "$s5Agent6BeaconV3runyyF" {
+		t.Fatalf("FindExport(Agent.Beacon.run) = %+v, %v", exports, err)
+	}
+	if addr, err := lib.ProcAddress("_ZN5agent5startEv"); err != nil || addr != start {
+		t.Fatalf("ProcAddress = %#x, %v; want %#x", addr, err, start)
+	}
+	// ELF ordinals are emulated from the sorted export list.
+	names, err := lib.Exports()
+	if err != nil || len(names) == 0 {
+		t.Fatalf("Exports = %q, %v", names, err)
+	}
+	for i, name := range names {
+		byName, err := lib.ProcAddress(name)
+		if err != nil {
+			t.Fatalf("ProcAddress(%s): %v", name, err)
+		}
+		if addr, err := lib.ProcAddressOrdinal(uint16(i + 1)); err != nil || addr != byName {
+			t.Fatalf("ProcAddressOrdinal(%d) = %#x, %v; want %#x (%s)", i+1, addr, err, byName, name)
+		}
+		if addr, err := lib.ProcAddress(fmt.Sprintf("#%d", i+1)); err != nil || addr != byName {
+			t.Fatalf("ProcAddress(#%d) = %#x, %v; want %#x (%s)", i+1, addr, err, byName, name)
+		}
+	}
+	if _, err := lib.ProcAddressOrdinal(uint16(len(names) + 1)); err == nil {
+		t.Fatal("ProcAddressOrdinal accepted an ordinal past the export list")
+	}
+	if got, err := lib.Call(fmt.Sprintf("#%d", slices.Index(names, "_ZN5agent5startEv")+1)); err != nil || got != 7 {
+		t.Fatalf("Call(#N for agent::start) = %d, %v; want 7", got, err)
+	}
+	if exports, err := lib.FindExport(`^nothing$`); err != nil || len(exports) != 0 {
+		t.Fatalf("FindExport(nothing) = %+v, %v; want no match", exports, err)
+	}
+	if _, err := lib.FindExport(`(`); err == nil {
+		t.Fatal("FindExport accepted an invalid pattern")
+	}
+}
+
+func TestSetLoggerTracesLoadStages(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "initializers", "linux", runtime.GOARCH)
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+	packed, err := compress.PackAP32(payload)
+	if err != nil {
+		t.Fatalf("PackAP32: %v", err)
+	}
+
+	var logged bytes.Buffer
+	reflektor.SetLogger(slog.New(slog.NewJSONHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { reflektor.SetLogger(nil) })
+
+	lib, err := reflektor.LoadLibrary(packed)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	lib.Close()
+
+	var stages []string
+	dec := json.NewDecoder(&logged)
+	for dec.More() {
+		var record struct{ Stage string }
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("decode log record: %v", err)
+		}
+		stages = append(stages, record.Stage)
+	}
+	for _, want := range []string{"unpack", "map", "relocate", "initialize"} {
+		if !slices.Contains(stages, want) {
+			t.Errorf("logged stages %q, want %q among them", stages, want)
+		}
+	}
+	if i, j := slices.Index(stages, "map"), slices.Index(stages, "initialize"); i > j {
+		t.Errorf("logged stages %q, want map before initialize", stages)
+	}
+
+	reflektor.SetLogger(nil)
+	logged.Reset()
+	plain, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	plain.Close()
+	if logged.Len() != 0 {
+		t.Fatalf("logged %q after SetLogger(nil), want nothing", logged.String())
+	}
+}
+
+func TestSoakFreesWhatItLoads(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	report, err := loadertest.SoakWithOptions(payload, 200, loadertest.Options{
+		Export: "reflektor_weighted_sum",
+		Args:   []uintptr{1, 2, 3, 4, 5, 6},
+	})
+	if err != nil {
+		t.Fatalf("SoakWithOptions: %v", err)
+	}
+	if report.Before.RSS == 0 || report.Before.Handles == 0 || report.Before.Threads == 0 {
+		t.Fatalf("Before = %+v, want RSS, handles, and threads measured", report.Before)
+	}
+	if err := report.Check(loadertest.DefaultLimits); err != nil {
+		t.Fatalf("Check: %v (before %+v, after %+v)", err, report.Before, report.After)
+	}
+
+	leaky := *report
+	leaky.After.MappedBytes += 4096
+	if err := leaky.Check(loadertest.DefaultLimits); !errors.Is(err, loadertest.ErrLeak) {
+		t.Fatalf("Check with a mapping left behind = %v, want ErrLeak", err)
+	}
+}
+
+// TestConcurrentLoadsCallsAndCloses_Linux drives the loader's process-wide
+// state (the dynamic-linker API, host symbol tables, the mapping budget, and
+// the audit and trace hooks) from several goroutines at once. Run it with
+// -race, as CI does.
+func TestConcurrentLoadsCallsAndCloses_Linux(t *testing.T) {
+	requireCommand(t, "zig")
+
+	soPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "linux", runtime.GOARCH, "-lm")
+	payload, err := os.ReadFile(soPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", soPath, err)
+	}
+
+	reflektor.SetAuditLog(reflektor.AuditSinkFunc(func(reflektor.AuditEvent) {}), []byte("key"))
+	reflektor.SetLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		reflektor.SetAuditLog(nil, nil)
+		reflektor.SetLogger(nil)
+	})
+
+	shared, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+
+	const workers, iterations = 8, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers+1)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{Deterministic: i%2 == 0})
+				if err != nil {
+					errs <- fmt.Errorf("LoadLibraryWithOptions: %w", err)
+					return
+				}
+				got, err := lib.Call("reflektor_weighted_sum", 1, 1, 1, 1, 1, 1)
+				lib.Close()
+				if err != nil || got != 21 {
+					errs <- fmt.Errorf("reflektor_weighted_sum(1, ...) = %d, %v; want 21", got, err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				if got, err := shared.Call("reflektor_weighted_sum", uintptr(i), 0, 0, 0, 0, 0); err != nil || got != uintptr(i) {
+					errs <- fmt.Errorf("shared reflektor_weighted_sum(%d, ...) = %d, %v", i, got, err)
+					return
+				}
+				_ = shared.Info()
+				_ = reflektor.MappedBytes()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			reflektor.ResetHostSymbols()
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
@@ -4,10 +4,15 @@ package reflektor_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/memmod"
 )
 
 func TestLoadGeneratedCWindowsDLLAndCallStartW(t *testing.T) {
@@ -35,3 +40,109 @@ func TestLoadGeneratedCWindowsDLLAndCallStartW(t *testing.T) {
 		t.Fatalf("unexpected marker bytes: got=%q want=%q", got, []byte("ok"))
 	}
 }
+
+func TestProcAddressOrdinalCallsExportByOrdinal(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dllPath := buildNamedSharedLib(t, t.TempDir(), "callresult", "windows", runtime.GOARCH)
+	payload, err := os.ReadFile(dllPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", dllPath, err)
+	}
+	info, err := memmod.InspectImage(payload)
+	if err != nil {
+		t.Fatalf("InspectImage: %v", err)
+	}
+	lib, err := reflektor.LoadLibrary(payload)
+	if err != nil {
+		t.Fatalf("LoadLibrary: %v", err)
+	}
+	defer lib.Close()
+
+	var weightedSum uint16
+	for _, export := range info.Exports {
+		byName, err := lib.ProcAddress(export.Name)
+		if err != nil {
+			t.Fatalf("ProcAddress(%s): %v", export.Name, err)
+		}
+		byOrdinal, err := lib.ProcAddressOrdinal(export.Ordinal)
+		if err != nil || byOrdinal != byName {
+			t.Fatalf("ProcAddressOrdinal(%d) = %#x, %v; want %#x (%s)", export.Ordinal, byOrdinal, err, byName, export.Name)
+		}
+		if export.Name == "reflektor_weighted_sum" {
+			weightedSum = export.Ordinal
+		}
+	}
+	if weightedSum == 0 {
+		t.Fatal("reflektor_weighted_sum has no ordinal")
+	}
+	if got, err := lib.Call(fmt.Sprintf("#%d", weightedSum), 1, 2, 3, 4, 5, 6); err != nil || got != 91 {
+		t.Fatalf("Call by ordinal = %d, %v; want 91", got, err)
+	}
+	if _, err := lib.ProcAddressOrdinal(uint16(len(info.Exports)) + 100); err == nil {
+		t.Fatal("ProcAddressOrdinal accepted an ordinal past the export table")
+	}
+}
+
+func TestTrackResourcesReportsLeakedHeapsAndThreads(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dllPath := buildNamedSharedLib(t, t.TempDir(), "leaky", "windows", runtime.GOARCH)
+	payload, err := os.ReadFile(dllPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", dllPath, err)
+	}
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{TrackResources: true})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	if got, err := lib.Call("reflektor_leak"); err != nil || got != 1 {
+		t.Fatalf("reflektor_leak() = %d, %v; want 1", got, err)
+	}
+	usage := lib.ResourceUsage()
+	if usage == nil || len(usage.Heaps) != 1 || len(usage.Threads) != 1 || !usage.Leaked() {
+		t.Fatalf("ResourceUsage() = %+v, want one heap and one thread", usage)
+	}
+
+	if err := lib.CallExport("reflektor_release_leaks"); err != nil {
+		t.Fatalf("reflektor_release_leaks: %v", err)
+	}
+	if usage := lib.ResourceUsage(); len(usage.Heaps) != 0 || len(usage.Threads) != 0 {
+		t.Fatalf("ResourceUsage() after release = %+v, want no heaps or threads", usage)
+	}
+	if err := lib.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if usage := lib.ResourceUsage(); usage == nil || len(usage.Threads) != 0 {
+		t.Fatalf("ResourceUsage() after Close = %+v, want a report with no threads", usage)
+	}
+}
+
+func TestThreadCatchExceptionsContainsFault(t *testing.T) {
+	requireCommand(t, "zig")
+
+	dllPath := buildNamedSharedLib(t, t.TempDir(), "fault", "windows", runtime.GOARCH)
+	payload, err := os.ReadFile(dllPath)
+	if err != nil {
+		t.Fatalf("read %s: %v", dllPath, err)
+	}
+	lib, err := reflektor.LoadLibraryWithOptions(payload, reflektor.Options{
+		Thread: &reflektor.ThreadOptions{CatchExceptions: true},
+	})
+	if err != nil {
+		t.Fatalf("LoadLibraryWithOptions: %v", err)
+	}
+	defer lib.Close()
+
+	const accessViolation uint32 = 0xc0000005
+	result, err := lib.CallExportResult("reflektor_fault")
+	if !errors.Is(err, reflektor.ErrExportException) || result.Exception != accessViolation {
+		t.Fatalf("CallExportResult(reflektor_fault) = %+v, %v; want exception %#x", result, err, accessViolation)
+	}
+	// The process and the library outlive the fault.
+	if result, err := lib.CallExportResult("reflektor_ok"); err != nil || result.Value != 7 {
+		t.Fatalf("CallExportResult(reflektor_ok) = %+v, %v; want 7", result, err)
+	}
+}
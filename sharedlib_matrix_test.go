@@ -7,31 +7,31 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/sliverarmory/reflektor/memmod"
 )
 
 type sharedLibTarget struct {
-	goos      string
-	goarch    string
-	ext       string
-	fileProbe string
+	goos   string
+	goarch string
+	ext    string
+	format string
+	export string
 }
 
 var sharedLibTargets = []sharedLibTarget{
-	{goos: "darwin", goarch: "amd64", ext: "dylib", fileProbe: "x86_64"},
-	{goos: "darwin", goarch: "arm64", ext: "dylib", fileProbe: "arm64"},
-	{goos: "linux", goarch: "386", ext: "so", fileProbe: "Intel 80386"},
-	{goos: "linux", goarch: "amd64", ext: "so", fileProbe: "x86-64"},
-	{goos: "linux", goarch: "arm64", ext: "so", fileProbe: "ARM aarch64"},
-	{goos: "windows", goarch: "386", ext: "dll", fileProbe: "Intel 80386"},
-	{goos: "windows", goarch: "amd64", ext: "dll", fileProbe: "x86-64"},
-	{goos: "windows", goarch: "arm64", ext: "dll", fileProbe: "Aarch64"},
+	{goos: "darwin", goarch: "amd64", ext: "dylib", format: "macho", export: "_StartW"},
+	{goos: "darwin", goarch: "arm64", ext: "dylib", format: "macho", export: "_StartW"},
+	{goos: "linux", goarch: "386", ext: "so", format: "elf", export: "StartW"},
+	{goos: "linux", goarch: "amd64", ext: "so", format: "elf", export: "StartW"},
+	{goos: "linux", goarch: "arm64", ext: "so", format: "elf", export: "StartW"},
+	{goos: "windows", goarch: "386", ext: "dll", format: "pe", export: "StartW"},
+	{goos: "windows", goarch: "amd64", ext: "dll", format: "pe", export: "StartW"},
+	{goos: "windows", goarch: "arm64", ext: "dll", format: "pe", export: "StartW"},
 }
 
 func TestBuildCSharedLibraryMatrix(t *testing.T) {
 	requireCommand(t, "zig")
-	requireCommand(t, "file")
-	requireCommand(t, "nm")
-	requireCommand(t, "objdump")
 
 	outDir := t.TempDir()
 
@@ -39,35 +39,30 @@ func TestBuildCSharedLibraryMatrix(t *testing.T) {
 		target := target
 		t.Run(fmt.Sprintf("%s-%s", target.goos, target.goarch), func(t *testing.T) {
 			path := buildOneSharedLib(t, outDir, target.goos, target.goarch)
-			info, err := os.Stat(path)
+			data, err := os.ReadFile(path)
 			if err != nil {
-				t.Fatalf("stat %s: %v", path, err)
+				t.Fatalf("read %s: %v", path, err)
 			}
-			if info.Size() == 0 {
+			if len(data) == 0 {
 				t.Fatalf("empty output file: %s", path)
 			}
 
-			fileOut := runCmd(t, "file", path)
-			if !strings.Contains(fileOut, target.fileProbe) {
-				t.Fatalf("unexpected architecture probe for %s: want substring %q, got %q", path, target.fileProbe, fileOut)
+			info, err := memmod.InspectImage(data)
+			if err != nil {
+				t.Fatalf("inspect %s: %v", path, err)
 			}
-
-			switch target.goos {
-			case "windows":
-				exportOut := runCmd(t, "objdump", "-p", path)
-				if !strings.Contains(exportOut, "StartW") {
-					t.Fatalf("expected exported symbol StartW in %s", path)
-				}
-			case "darwin":
-				nmOut := runCmd(t, "nm", path)
-				if !strings.Contains(nmOut, "_StartW") {
-					t.Fatalf("expected exported symbol _StartW in %s", path)
-				}
-			default:
-				nmOut := runCmd(t, "nm", path)
-				if !strings.Contains(nmOut, " StartW") && !strings.Contains(nmOut, "\tStartW") {
-					t.Fatalf("expected exported symbol StartW in %s", path)
-				}
+			if info.Format != target.format || info.Arch != target.goarch {
+				t.Fatalf("unexpected image kind for %s: got %s/%s, want %s/%s", path, info.Format, info.Arch, target.format, target.goarch)
+			}
+			if len(info.Sections) == 0 {
+				t.Fatalf("no sections found in %s", path)
+			}
+			found := false
+			for _, export := range info.Exports {
+				found = found || export.Name == target.export
+			}
+			if !found {
+				t.Fatalf("expected exported symbol %s in %s", target.export, path)
 			}
 		})
 	}
@@ -75,6 +70,12 @@ func TestBuildCSharedLibraryMatrix(t *testing.T) {
 
 func buildOneSharedLib(t *testing.T, outDir string, goos string, goarch string) string {
 	t.Helper()
+	return buildNamedSharedLib(t, outDir, "basic", goos, goarch)
+}
+
+// buildNamedSharedLib builds testdata/c/<name>.c for goos/goarch.
+func buildNamedSharedLib(t *testing.T, outDir string, name string, goos string, goarch string, extraFlags ...string) string {
+	t.Helper()
 
 	var (
 		zigTarget string
@@ -102,8 +103,8 @@ func buildOneSharedLib(t *testing.T, outDir string, goos string, goarch string)
 		t.Fatalf("unsupported target %s/%s", goos, goarch)
 	}
 
-	outputPath := filepath.Join(outDir, fmt.Sprintf("basic_%s-%s.%s", goos, goarch, ext))
-	sourcePath := filepath.Join("testdata", "c", "basic.c")
+	outputPath := filepath.Join(outDir, fmt.Sprintf("%s_%s-%s.%s", name, goos, goarch, ext))
+	sourcePath := filepath.Join("testdata", "c", name+".c")
 
 	args := []string{"cc", "-target", zigTarget, "-O2", "-g0"}
 	switch goos {
@@ -116,6 +117,7 @@ func buildOneSharedLib(t *testing.T, outDir string, goos string, goarch string)
 	default:
 		t.Fatalf("unsupported target os %s", goos)
 	}
+	args = append(args, extraFlags...)
 	args = append(args, "-o", outputPath, sourcePath)
 
 	cmd := exec.Command("zig", args...)
@@ -133,22 +135,11 @@ func buildOneSharedLib(t *testing.T, outDir string, goos string, goarch string)
 	if goos == "windows" {
 		base := strings.TrimSuffix(outputPath, ".dll")
 		_ = os.Remove(base + ".pdb")
-		_ = os.Remove(filepath.Join(outDir, "basic.lib"))
+		_ = os.Remove(filepath.Join(outDir, name+".lib"))
 	}
 	return outputPath
 }
 
-func runCmd(t *testing.T, name string, args ...string) string {
-	t.Helper()
-
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		t.Fatalf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, output)
-	}
-	return string(output)
-}
-
 func requireCommand(t *testing.T, name string) {
 	t.Helper()
 	if _, err := exec.LookPath(name); err != nil {
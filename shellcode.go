@@ -0,0 +1,163 @@
+package reflektor
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/sliverarmory/reflektor/compress"
+	"github.com/sliverarmory/reflektor/memmod"
+)
+
+// ShellcodeOptions controls how LoadShellcodeWithOptions maps code. The zero
+// value matches LoadShellcode.
+type ShellcodeOptions struct {
+	// Entry is the offset from the start of the code, once unpacked, at
+	// which Call enters it.
+	Entry uintptr
+
+	// MaxImageSize, when non-zero, rejects packed code that unpacks to more
+	// than this many bytes with ErrImageTooLarge.
+	MaxImageSize uint64
+
+	// MaxTotalMappedBytes, when non-zero, rejects the code with
+	// ErrMappingBudget if mapping it would take MappedBytes past this many
+	// bytes.
+	MaxTotalMappedBytes uint64
+
+	// ZeroInput overwrites the caller's buffer, and the unpacked copy of
+	// packed code, with zeros once the code is mapped.
+	ZeroInput bool
+}
+
+// ShellcodeExport is the one name a *Code lists as a Runner: CallExport with
+// it calls the entry point with a zero argument.
+const ShellcodeExport = "entry"
+
+// Code is raw position-independent code, such as shellcode, mapped
+// read-execute by LoadShellcode. It has no exports: Call enters it at one
+// entry point with one argument. As a Runner it exposes that entry point as
+// ShellcodeExport.
+type Code struct {
+	code   *memmod.Code
+	digest string
+	info   Info
+}
+
+// LoadShellcode maps position-independent code from memory, for payloads
+// that are PIC blobs rather than shared-library images. Packed code is
+// unpacked first, and a bundle supplies the slice for the running platform.
+func LoadShellcode(data []byte) (*Code, error) {
+	return LoadShellcodeWithOptions(data, ShellcodeOptions{})
+}
+
+// OpenShellcode is LoadShellcodeWithOptions returning a Runner, so embedders
+// can drive shellcode through the same code path as Open's payloads.
+func OpenShellcode(data []byte, opts ShellcodeOptions) (Runner, error) {
+	code, err := LoadShellcodeWithOptions(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// LoadShellcodeWithOptions maps position-independent code from memory using
+// opts.
+func LoadShellcodeWithOptions(data []byte, opts ShellcodeOptions) (*Code, error) {
+	digest := auditDigest(data)
+	code, err := loadShellcode(data, opts)
+	if code != nil {
+		code.digest = digest
+	}
+	recordAudit(AuditEvent{Op: AuditLoad, Payload: digest}, err)
+	return code, err
+}
+
+func loadShellcode(data []byte, opts ShellcodeOptions) (*Code, error) {
+	if len(data) == 0 {
+		return nil, errors.New("reflektor: empty shellcode")
+	}
+	packed := compress.Detect(data) != compress.CodecNone
+	image, err := compress.Unpack(data, opts.MaxImageSize)
+	if err != nil {
+		return nil, unpackError(err)
+	}
+	mapped, err := memmod.LoadCode(image, memmod.CodeOptions{
+		Entry:               opts.Entry,
+		MaxTotalMappedBytes: opts.MaxTotalMappedBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reflektor: load shellcode: %w", err)
+	}
+	if opts.ZeroInput {
+		clear(data)
+		clear(image)
+	}
+	return &Code{
+		code: mapped,
+		info: Info{
+			Format:  FormatShellcode,
+			Backend: BackendShellcode,
+			Arch:    runtime.GOARCH,
+			Base:    mapped.Base(),
+			Size:    uint64(mapped.Size()),
+			Entry:   mapped.Entry(),
+			Packed:  packed,
+		},
+	}, nil
+}
+
+// Base returns the address the code was mapped at.
+func (code *Code) Base() uintptr {
+	return code.code.Base()
+}
+
+// Entry returns the address Call enters the code at: Base plus
+// ShellcodeOptions.Entry.
+func (code *Code) Entry() uintptr {
+	return code.code.Entry()
+}
+
+// Call runs the code from its entry point on the calling thread, passing arg
+// as its only integer or pointer argument, and returns once the code returns.
+// Code that never returns, or that ends its thread, takes the calling thread
+// with it; run such code from a goroutine the host can spare.
+func (code *Code) Call(arg uintptr) (result CallResult, err error) {
+	defer func() {
+		recordAudit(AuditEvent{Op: AuditCall, Payload: code.digest, Args: []uintptr{arg}, Result: result}, err)
+	}()
+	raw, err := code.code.Call(arg)
+	if err != nil {
+		return CallResult{}, fmt.Errorf("reflektor: call shellcode: %w", err)
+	}
+	return CallResult(raw), nil
+}
+
+// CallExport calls the entry point with a zero argument; name must be
+// ShellcodeExport. The return value is discarded, so use Call to see it.
+func (code *Code) CallExport(name string) error {
+	if name != ShellcodeExport {
+		return fmt.Errorf("reflektor: shellcode has no export %q, only %q", name, ShellcodeExport)
+	}
+	_, err := code.Call(0)
+	return err
+}
+
+// Exports lists ShellcodeExport, the code's only entry point.
+func (code *Code) Exports() ([]string, error) {
+	return []string{ShellcodeExport}, nil
+}
+
+// Info reports the code's layout. Format is FormatShellcode, Arch is the
+// running platform's, and there are no imports.
+func (code *Code) Info() Info {
+	return code.info
+}
+
+// Close unmaps the code once calls in progress have returned. Calls after
+// Close fail.
+func (code *Code) Close() error {
+	code.code.Free()
+	recordAudit(AuditEvent{Op: AuditClose, Payload: code.digest}, nil)
+	return nil
+}
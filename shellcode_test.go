@@ -0,0 +1,107 @@
+package reflektor_test
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/sliverarmory/reflektor"
+	"github.com/sliverarmory/reflektor/compress"
+)
+
+// addOne returns position-independent code that returns its first argument
+// plus one, for the running platform's calling convention, and a trap
+// instruction to put in front of it.
+func addOne(t *testing.T) (code, trap []byte) {
+	t.Helper()
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64", "darwin/amd64":
+		return []byte{0x48, 0x8d, 0x47, 0x01, 0xc3}, []byte{0xcc, 0xcc, 0xcc, 0xcc} // lea rax, [rdi+1]; ret
+	case "windows/amd64":
+		return []byte{0x48, 0x8d, 0x41, 0x01, 0xc3}, []byte{0xcc, 0xcc, 0xcc, 0xcc} // lea rax, [rcx+1]; ret
+	case "linux/arm64", "darwin/arm64", "windows/arm64":
+		return []byte{0x00, 0x04, 0x00, 0x91, 0xc0, 0x03, 0x5f, 0xd6}, []byte{0x00, 0x00, 0x00, 0x00} // add x0, x0, #1; ret
+	}
+	t.Skipf("no test shellcode for %s/%s", runtime.GOOS, runtime.GOARCH)
+	return nil, nil
+}
+
+func TestLoadShellcode(t *testing.T) {
+	fn, trap := addOne(t)
+	data := append(bytes.Clone(trap), fn...)
+	packed, err := compress.PackAP32(data)
+	if err != nil {
+		t.Fatalf("pack shellcode: %v", err)
+	}
+
+	code, err := reflektor.LoadShellcodeWithOptions(packed, reflektor.ShellcodeOptions{Entry: uintptr(len(trap)), ZeroInput: true})
+	if err != nil {
+		t.Fatalf("LoadShellcodeWithOptions: %v", err)
+	}
+	if code.Entry() != code.Base()+uintptr(len(trap)) {
+		t.Fatalf("entry %#x, want base %#x + %d", code.Entry(), code.Base(), len(trap))
+	}
+	if !bytes.Equal(packed, make([]byte, len(packed))) {
+		t.Fatal("ZeroInput left the packed shellcode in place")
+	}
+	result, err := code.Call(41)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if result.Value != 42 {
+		t.Fatalf("Call(41) = %d, want 42", result.Value)
+	}
+	if err := code.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := code.Call(41); err == nil {
+		t.Fatal("Call succeeded after Close")
+	}
+
+	if _, err := reflektor.LoadShellcodeWithOptions(fn, reflektor.ShellcodeOptions{Entry: uintptr(len(fn))}); err == nil {
+		t.Fatal("LoadShellcodeWithOptions accepted an entry past the end of the code")
+	}
+}
+
+func TestOpenShellcode(t *testing.T) {
+	fn, trap := addOne(t)
+	packed, err := compress.PackAP32(append(bytes.Clone(trap), fn...))
+	if err != nil {
+		t.Fatalf("pack shellcode: %v", err)
+	}
+
+	runner, err := reflektor.OpenShellcode(packed, reflektor.ShellcodeOptions{Entry: uintptr(len(trap))})
+	if err != nil {
+		t.Fatalf("OpenShellcode: %v", err)
+	}
+	info := runner.Info()
+	if info.Format != reflektor.FormatShellcode || info.Backend != reflektor.BackendShellcode {
+		t.Fatalf("Info() format %q backend %q, want %q %q", info.Format, info.Backend, reflektor.FormatShellcode, reflektor.BackendShellcode)
+	}
+	if !info.Packed || info.Base == 0 || info.Size == 0 || info.Entry != info.Base+uintptr(len(trap)) {
+		t.Fatalf("Info() = %+v", info)
+	}
+	exports, err := runner.Exports()
+	if err != nil {
+		t.Fatalf("Exports: %v", err)
+	}
+	if len(exports) != 1 || exports[0] != reflektor.ShellcodeExport {
+		t.Fatalf("Exports() = %q, want [%q]", exports, reflektor.ShellcodeExport)
+	}
+	if err := runner.CallExport(reflektor.ShellcodeExport); err != nil {
+		t.Fatalf("CallExport(%q): %v", reflektor.ShellcodeExport, err)
+	}
+	if err := runner.CallExport("main"); err == nil {
+		t.Fatal("CallExport accepted a name other than the entry point")
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := runner.CallExport(reflektor.ShellcodeExport); err == nil {
+		t.Fatal("CallExport succeeded after Close")
+	}
+
+	if _, err := reflektor.OpenShellcode(nil, reflektor.ShellcodeOptions{}); err == nil {
+		t.Fatal("OpenShellcode accepted empty code")
+	}
+}
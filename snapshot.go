@@ -0,0 +1,162 @@
+package reflektor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+var (
+	// ErrNoSnapshot is returned by Library.Snapshot for native libraries
+	// loaded without Options.Snapshot.
+	ErrNoSnapshot = errors.New("reflektor: library was loaded without Options.Snapshot")
+	// ErrBadSnapshot is returned for snapshot data that is corrupt or was
+	// taken on another platform.
+	ErrBadSnapshot = errors.New("reflektor: invalid snapshot")
+)
+
+// snapshotMagic starts every encoded Snapshot; the last byte is the format
+// version.
+var snapshotMagic = []byte("RFKSNAP\x01")
+
+// Snapshot is a loaded library in a form another process on the same OS and
+// architecture can load straight away, for fork-and-run hosts that hand a
+// payload to a child or peer: the prepared image, already unpacked and
+// decrypted, and the address it was mapped at. A process's own state does
+// not carry over — imports bind to the libraries of the loading process,
+// which has its own heap and threads — so the image is mapped, linked, and
+// initialized afresh there, at the same base when that range is free.
+type Snapshot struct {
+	GOOS    string
+	GOARCH  string
+	Format  Format
+	Backend Backend
+	// Base is where the image was mapped; zero for scripts.
+	Base uintptr
+	// Image is the image as the loader mapped it, or a script's source.
+	Image []byte
+}
+
+// Snapshot captures the library for LoadSnapshot. Native libraries must have
+// been loaded with Options.Snapshot; scripts always can be.
+func (library *Library) Snapshot() (*Snapshot, error) {
+	library.mu.Lock()
+	defer library.mu.Unlock()
+	if library.closed || library.closing {
+		return nil, ErrLibraryClosed
+	}
+	if library.snapshot == nil {
+		return nil, ErrNoSnapshot
+	}
+	return &Snapshot{
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+		Format:  library.info.Format,
+		Backend: library.info.Backend,
+		Base:    library.info.Base,
+		Image:   bytes.Clone(library.snapshot),
+	}, nil
+}
+
+// MarshalBinary encodes the snapshot, with a SHA-256 checksum, for sending to
+// another process. The encoding holds the plaintext image; seal it before it
+// leaves the host.
+func (snapshot *Snapshot) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte(nil), snapshotMagic...))
+	for _, field := range []string{snapshot.GOOS, snapshot.GOARCH, string(snapshot.Format), string(snapshot.Backend)} {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(field))))
+		buf.WriteString(field)
+	}
+	buf.Write(binary.AppendUvarint(nil, uint64(snapshot.Base)))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(snapshot.Image))))
+	buf.Write(snapshot.Image)
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a snapshot MarshalBinary encoded. Image is a copy,
+// so data can be reused.
+func (snapshot *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < len(snapshotMagic)+sha256.Size || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return fmt.Errorf("%w: not a snapshot", ErrBadSnapshot)
+	}
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if want := sha256.Sum256(body); !bytes.Equal(sum, want[:]) {
+		return fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	rest := body[len(snapshotMagic):]
+	next := func() ([]byte, bool) {
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || n > uint64(len(rest)-size) {
+			return nil, false
+		}
+		field := rest[size : size+int(n)]
+		rest = rest[size+int(n):]
+		return field, true
+	}
+	var fields [4][]byte
+	for i := range fields {
+		field, ok := next()
+		if !ok {
+			return fmt.Errorf("%w: truncated header", ErrBadSnapshot)
+		}
+		fields[i] = field
+	}
+	base, size := binary.Uvarint(rest)
+	if size <= 0 || base > uint64(^uintptr(0)) {
+		return fmt.Errorf("%w: bad base address", ErrBadSnapshot)
+	}
+	rest = rest[size:]
+	image, ok := next()
+	if !ok || len(rest) != 0 {
+		return fmt.Errorf("%w: truncated image", ErrBadSnapshot)
+	}
+
+	*snapshot = Snapshot{
+		GOOS:    string(fields[0]),
+		GOARCH:  string(fields[1]),
+		Format:  Format(fields[2]),
+		Backend: Backend(fields[3]),
+		Base:    uintptr(base),
+		Image:   bytes.Clone(image),
+	}
+	return nil
+}
+
+// LoadSnapshot decodes a snapshot another process encoded with
+// Snapshot.MarshalBinary and loads it with opts, as Snapshot.Load does.
+func LoadSnapshot(data []byte, opts Options) (*Library, error) {
+	var snapshot Snapshot
+	if err := snapshot.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	defer clear(snapshot.Image)
+	return snapshot.Load(opts)
+}
+
+// Load loads the snapshot's image with opts. Unless opts places the image
+// itself (PreferredBase, BaseSeed, or Deterministic), it asks for the base
+// the image had when the snapshot was taken, so a forked child usually maps
+// it at the same address. Scripts are loaded with LoadScript, which takes no
+// options.
+func (snapshot *Snapshot) Load(opts Options) (*Library, error) {
+	if snapshot.GOOS != runtime.GOOS || snapshot.GOARCH != runtime.GOARCH {
+		return nil, fmt.Errorf("%w: taken on %s/%s, this process is %s/%s", ErrBadSnapshot, snapshot.GOOS, snapshot.GOARCH, runtime.GOOS, runtime.GOARCH)
+	}
+	switch snapshot.Backend {
+	case BackendLua:
+		return LoadScript(snapshot.Image)
+	case BackendNative:
+	default:
+		return nil, fmt.Errorf("%w: unknown backend %q", ErrBadSnapshot, snapshot.Backend)
+	}
+	if opts.PreferredBase == 0 && opts.BaseSeed == 0 && !opts.Deterministic {
+		opts.PreferredBase = snapshot.Base
+	}
+	return LoadLibraryWithOptions(snapshot.Image, opts)
+}
@@ -4,6 +4,7 @@ set -euo pipefail
 
 SCRIPT_DIR="$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)"
 SOURCE_FILE="${SCRIPT_DIR}/c/basic.c"
+RELOC_DIR="${SCRIPT_DIR}/c/reloc"
 OUT_DIR="${1:-${SCRIPT_DIR}/generated}"
 
 if ! command -v zig >/dev/null 2>&1; then
@@ -59,3 +60,26 @@ build_one "linux"   "arm64" "aarch64-linux-gnu"  "so"
 build_one "windows" "386"   "x86-windows-gnu"    "dll"
 build_one "windows" "amd64" "x86_64-windows-gnu" "dll"
 build_one "windows" "arm64" "aarch64-windows-gnu" "dll"
+
+# Relocation coverage fixtures (linux only). Each source exercises a family of
+# dynamic relocations; see memmod/memmod_linux_reloc_test.go for the matrix.
+build_reloc() {
+  local arch="$1"
+  local target="$2"
+  local name="$3"
+  shift 3
+  local out="${OUT_DIR}/reloc_${name}_linux-${arch}.so"
+
+  zig cc -target "${target}" -shared -fPIC -O2 -g0 "$@" -o "${out}" "${RELOC_DIR}/${name}.c"
+  echo "${out}"
+}
+
+for spec in "386:x86-linux-gnu" "amd64:x86_64-linux-gnu" "arm64:aarch64-linux-gnu"; do
+  arch="${spec%%:*}"
+  target="${spec#*:}"
+  build_reloc "${arch}" "${target}" "basic"
+  build_reloc "${arch}" "${target}" "tls_ie"
+  build_reloc "${arch}" "${target}" "tls_gd"
+  build_reloc "${arch}" "${target}" "ifunc"
+done
+build_reloc "386" "x86-linux-gnu" "textrel" -fno-PIC -Wl,-z,notext
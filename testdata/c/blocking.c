@@ -0,0 +1,17 @@
+// An export that does not return until another export releases it, so the
+// loader tests can follow exports that run in the background.
+#include <unistd.h>
+
+static volatile int reflektor_released;
+
+__attribute__((visibility("default"))) int reflektor_block(void) {
+	while (!reflektor_released) {
+		usleep(1000);
+	}
+	return 7;
+}
+
+__attribute__((visibility("default"))) int reflektor_release(void) {
+	reflektor_released = 1;
+	return 0;
+}
@@ -0,0 +1,28 @@
+// Exercises the call shims' ABI hygiene: errno must be surfaced to the
+// caller and a modified floating-point environment must not leak into Go.
+#include <errno.h>
+#include <fenv.h>
+#include <stdint.h>
+#include <unistd.h>
+
+__attribute__((visibility("default"))) int reflektor_set_errno(void) {
+	errno = ENOENT;
+	return 42;
+}
+
+__attribute__((visibility("default"))) int reflektor_round_down(void) {
+	return fesetround(FE_DOWNWARD);
+}
+
+// Weights each argument by its position so swapped or dropped arguments
+// change the result.
+__attribute__((visibility("default"))) uintptr_t reflektor_weighted_sum(uintptr_t a, uintptr_t b, uintptr_t c, uintptr_t d, uintptr_t e, uintptr_t f) {
+	return a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f;
+}
+
+// Outlives short call deadlines so callers can observe an export that is
+// still running.
+__attribute__((visibility("default"))) int reflektor_slow(void) {
+	usleep(300000);
+	return 7;
+}
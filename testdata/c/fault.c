@@ -0,0 +1,12 @@
+// Faults in an export without handling it, so the windows loader tests can
+// check ThreadOptions.CatchExceptions. Windows only.
+#include <windows.h>
+
+__declspec(dllexport) int reflektor_fault(void) {
+	volatile int *null = NULL;
+	return *null;
+}
+
+__declspec(dllexport) int reflektor_ok(void) {
+	return 7;
+}
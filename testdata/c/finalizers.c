@@ -0,0 +1,26 @@
+// Reports its teardown through a caller-owned flag word, so the loader tests
+// can check that Free runs destructors and atexit handlers when asked.
+#include <stdint.h>
+#include <stdlib.h>
+
+static volatile int32_t *reflektor_flags;
+
+static void reflektor_at_exit(void) {
+	if (reflektor_flags != NULL) {
+		*reflektor_flags |= 2;
+	}
+}
+
+__attribute__((constructor)) static void reflektor_init(void) {
+	atexit(reflektor_at_exit);
+}
+
+__attribute__((destructor)) static void reflektor_fini(void) {
+	if (reflektor_flags != NULL) {
+		*reflektor_flags |= 1;
+	}
+}
+
+__attribute__((visibility("default"))) void reflektor_watch(int32_t *flags) {
+	reflektor_flags = flags;
+}
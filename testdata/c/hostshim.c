@@ -0,0 +1,58 @@
+// Uses only the libc subset provided by the loader's built-in host shims, so
+// it runs in a static CGO_ENABLED=0 process with no libc mapped.
+#include <errno.h>
+#include <stdlib.h>
+#include <sys/mman.h>
+#include <unistd.h>
+
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+REFLEKTOR_EXPORT void *volatile hostshim_block;
+
+REFLEKTOR_EXPORT int hostshim_run(void) {
+	const char *value = getenv("REFLEKTOR_HOSTSHIM");
+	if (value == NULL || value[0] != 'o' || value[1] != 'k' || value[2] != '\0') {
+		return 1;
+	}
+	if (getenv("REFLEKTOR_HOSTSHIM_UNSET") != NULL) {
+		return 2;
+	}
+
+	char *buf = malloc(64);
+	if (buf == NULL || ((unsigned long)buf & 15) != 0) {
+		return 3;
+	}
+	buf[0] = 'x';
+	hostshim_block = buf;
+
+	int *zeros = calloc(16, sizeof(int));
+	if (zeros == NULL) {
+		return 4;
+	}
+	for (int i = 0; i < 16; i++) {
+		if (zeros[i] != 0) {
+			return 5;
+		}
+	}
+	hostshim_block = zeros;
+	free(buf);
+	free(zeros);
+
+	volatile char *page = mmap(NULL, 4096, PROT_READ | PROT_WRITE, MAP_PRIVATE | MAP_ANONYMOUS, -1, 0);
+	if (page == MAP_FAILED) {
+		return 6;
+	}
+	page[0] = 1;
+	if (munmap((void *)page, 4096) != 0) {
+		return 7;
+	}
+
+	if (write(-1, "x", 1) != -1 || errno != EBADF) {
+		return 8;
+	}
+	return 0;
+}
+
+REFLEKTOR_EXPORT const char *hostshim_getenv(const char *name) {
+	return getenv(name);
+}
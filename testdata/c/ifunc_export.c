@@ -0,0 +1,21 @@
+// An exported GNU indirect function. Its symbol points at the resolver, so a
+// loader that does not resolve it hands callers the resolver instead of the
+// implementation.
+static int reflektor_ifunc_impl(void) {
+	return 0x2a;
+}
+
+static int (*reflektor_ifunc_resolver(void))(void) {
+	return reflektor_ifunc_impl;
+}
+
+__attribute__((visibility("default"))) int reflektor_ifunc(void) __attribute__((ifunc("reflektor_ifunc_resolver")));
+
+// Calls the indirect function through the image's own PLT.
+__attribute__((visibility("default"))) int reflektor_ifunc_call(void) {
+	return reflektor_ifunc();
+}
+
+__attribute__((visibility("default"))) void *reflektor_ifunc_target(void) {
+	return (void *)reflektor_ifunc_impl;
+}
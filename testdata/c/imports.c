@@ -0,0 +1,13 @@
+// Calls imported libc functions, so the loader tests can redirect an import
+// with an ImportResolver and check imports glibc defines as indirect
+// functions (strlen) bind to the implementation, not the resolver.
+#include <string.h>
+#include <unistd.h>
+
+__attribute__((visibility("default"))) long reflektor_pid(void) {
+	return (long)getpid();
+}
+
+__attribute__((visibility("default"))) size_t reflektor_strlen(const char *s) {
+	return strlen(s);
+}
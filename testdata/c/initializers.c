@@ -0,0 +1,11 @@
+// Records whether its constructor ran, so the loader tests can check that
+// SkipInitializers leaves constructors alone.
+static int reflektor_constructed;
+
+__attribute__((constructor)) static void reflektor_init(void) {
+	reflektor_constructed = 1;
+}
+
+__attribute__((visibility("default"))) int reflektor_was_constructed(void) {
+	return reflektor_constructed;
+}
@@ -0,0 +1,29 @@
+// Creates a heap and a thread that outlive the export that made them, so the
+// windows loader tests can check Options.TrackResources. Windows only.
+#include <windows.h>
+
+static HANDLE reflektor_heap;
+static HANDLE reflektor_thread;
+static HANDLE reflektor_stop;
+
+static DWORD WINAPI reflektor_worker(LPVOID arg) {
+	(void)arg;
+	WaitForSingleObject(reflektor_stop, INFINITE);
+	return 0;
+}
+
+__declspec(dllexport) int reflektor_leak(void) {
+	reflektor_heap = HeapCreate(0, 0, 0);
+	reflektor_stop = CreateEventW(NULL, TRUE, FALSE, NULL);
+	reflektor_thread = CreateThread(NULL, 0, reflektor_worker, NULL, 0, NULL);
+	return reflektor_heap != NULL && reflektor_stop != NULL && reflektor_thread != NULL;
+}
+
+__declspec(dllexport) int reflektor_release_leaks(void) {
+	SetEvent(reflektor_stop);
+	WaitForSingleObject(reflektor_thread, INFINITE);
+	CloseHandle(reflektor_thread);
+	CloseHandle(reflektor_stop);
+	HeapDestroy(reflektor_heap);
+	return 0;
+}
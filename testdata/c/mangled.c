@@ -0,0 +1,25 @@
+// Exports carrying C++ and Swift mangled names, as a C++ or Swift library
+// would, without needing either compiler to build the fixture.
+#ifdef __APPLE__
+#define MANGLED(name) __asm__("_" name)
+#else
+#define MANGLED(name) __asm__(name)
+#endif
+
+// agent::start()
+__attribute__((visibility("default"))) int agent_start(void) MANGLED("_ZN5agent5startEv");
+int agent_start(void) {
+	return 7;
+}
+
+// agent::stop(int)
+__attribute__((visibility("default"))) int agent_stop(int code) MANGLED("_ZN5agent4stopEi");
+int agent_stop(int code) {
+	return code;
+}
+
+// Agent.Beacon.run() in Swift.
+__attribute__((visibility("default"))) int beacon_run(void) MANGLED("$s5Agent6BeaconV3runyyF");
+int beacon_run(void) {
+	return 9;
+}
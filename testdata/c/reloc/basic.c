@@ -0,0 +1,33 @@
+#include <stdlib.h>
+
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/*
+ * Relocation coverage fixture: word-sized data and GOT/PLT relocations.
+ *
+ *   reloc_relative_ptr      -> R_*_RELATIVE
+ *   reloc_abs_ptr           -> R_X86_64_64 / R_AARCH64_ABS64 / R_386_32
+ *   reloc_abs_extern_ptr    -> absolute relocation against an imported symbol
+ *   reloc_glob_dat_getenv   -> R_*_GLOB_DAT
+ *   reloc_jump_slot_atoi    -> R_*_JUMP_SLOT
+ */
+
+static int local_value = 7;
+
+REFLEKTOR_EXPORT int reloc_target_value = 0x5a5a;
+
+REFLEKTOR_EXPORT int* reloc_relative_ptr = &local_value;
+REFLEKTOR_EXPORT int* reloc_abs_ptr = &reloc_target_value;
+REFLEKTOR_EXPORT void* reloc_abs_extern_ptr = (void*)&getenv;
+
+REFLEKTOR_EXPORT void* reloc_glob_dat_getenv(void) {
+  return (void*)&getenv;
+}
+
+REFLEKTOR_EXPORT int reloc_jump_slot_atoi(const char* s) {
+  return atoi(s);
+}
+
+REFLEKTOR_EXPORT int reloc_relative_value(void) {
+  return *reloc_relative_ptr;
+}
@@ -0,0 +1,14 @@
+#include <stdio.h>
+
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/* Built as a PIE executable: direct accesses to libc's stdout make the
+   linker reserve a copy of it in .bss, filled by R_*_COPY. */
+
+REFLEKTOR_EXPORT FILE *reloc_copy_stdout(void) {
+  return stdout;
+}
+
+int main(void) {
+  return 0;
+}
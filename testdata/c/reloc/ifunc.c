@@ -0,0 +1,17 @@
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/* Hidden GNU ifunc referenced locally: R_*_IRELATIVE. */
+
+static int reloc_ifunc_impl(void) {
+  return 0x1f;
+}
+
+static int (*reloc_ifunc_resolver(void))(void) {
+  return reloc_ifunc_impl;
+}
+
+__attribute__((visibility("hidden"))) int reloc_ifunc(void) __attribute__((ifunc("reloc_ifunc_resolver")));
+
+REFLEKTOR_EXPORT int reloc_ifunc_call(void) {
+  return reloc_ifunc();
+}
@@ -0,0 +1,13 @@
+#include <string.h>
+
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/*
+ * Text relocations (built without -fPIC and with -z notext): PC-relative
+ * relocations such as R_386_PC32 against imported functions end up in
+ * .rel.dyn. Only i386 toolchains accept this layout for shared objects.
+ */
+
+REFLEKTOR_EXPORT size_t reloc_pc32_strlen(const char* s) {
+  return strlen(s);
+}
@@ -0,0 +1,8 @@
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/* General-dynamic TLS: R_*_DTPMOD* / R_*_DTPOFF* (or TLSDESC on aarch64). */
+__attribute__((tls_model("global-dynamic"))) REFLEKTOR_EXPORT __thread int reloc_tls_gd = 9;
+
+REFLEKTOR_EXPORT int reloc_tls_gd_next(void) {
+  return ++reloc_tls_gd;
+}
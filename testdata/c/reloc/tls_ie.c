@@ -0,0 +1,8 @@
+#define REFLEKTOR_EXPORT __attribute__((visibility("default")))
+
+/* Initial-exec TLS: R_X86_64_TPOFF64 / R_386_TLS_TPOFF / R_AARCH64_TLS_TPREL64. */
+__attribute__((tls_model("initial-exec"))) REFLEKTOR_EXPORT __thread int reloc_tls_ie = 5;
+
+REFLEKTOR_EXPORT int reloc_tls_ie_next(void) {
+  return ++reloc_tls_ie;
+}
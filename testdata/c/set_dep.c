@@ -0,0 +1,4 @@
+// A dependency the loader tests provide from memory, never from disk.
+__attribute__((visibility("default"))) int reflektor_dep_value(void) {
+	return 42;
+}
@@ -0,0 +1,7 @@
+// Links against set_dep, so its DT_NEEDED entry must be satisfied by the
+// library set.
+int reflektor_dep_value(void);
+
+__attribute__((visibility("default"))) int reflektor_set_value(void) {
+	return reflektor_dep_value() + 1;
+}
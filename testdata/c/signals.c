@@ -0,0 +1,15 @@
+// Installs signal handlers the way many native libraries do, so the loader
+// tests can check that PreserveSignals puts the Go runtime's handlers back.
+#include <signal.h>
+
+static void reflektor_signal_handler(int sig) {
+	(void)sig;
+}
+
+__attribute__((constructor)) static void reflektor_signals_init(void) {
+	signal(SIGPIPE, reflektor_signal_handler);
+}
+
+__attribute__((visibility("default"))) void StartW(void) {
+	signal(SIGUSR2, SIG_IGN);
+}
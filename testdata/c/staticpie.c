@@ -0,0 +1,25 @@
+// Static-PIE executable started by StartEntry. It checks argv, envp, and TLS
+// initialization, then exits only its own thread: returning from main would
+// call exit and terminate the host process.
+#include <stdio.h>
+#include <stdlib.h>
+#include <sys/syscall.h>
+#include <unistd.h>
+
+static __thread int tls_counter = 41;
+
+int main(int argc, char **argv) {
+	if (argc < 2) {
+		syscall(SYS_exit, 2);
+	}
+	tls_counter++;
+
+	const char *env = getenv("REFLEKTOR_STATICPIE");
+	FILE *f = fopen(argv[1], "w");
+	if (f != NULL) {
+		fprintf(f, "ok %d %d %s", argc, tls_counter, env != NULL ? env : "");
+		fclose(f);
+	}
+	syscall(SYS_exit, 0);
+	return 0;
+}
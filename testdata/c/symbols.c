@@ -0,0 +1,15 @@
+// Symbols outside the dynamic export table, for LoadOptions.Symbols.
+
+__attribute__((used, noinline)) static int reflektor_static_fn(void) {
+	return 7;
+}
+
+__attribute__((visibility("hidden"), noinline)) int reflektor_hidden_fn(void) {
+	return 11;
+}
+
+__attribute__((visibility("default"))) int reflektor_counter = 0x1234;
+
+__attribute__((visibility("default"))) int reflektor_visible_fn(void) {
+	return reflektor_static_fn() + reflektor_hidden_fn();
+}
@@ -0,0 +1,23 @@
+// Records the thread its constructor ran on, so the loader tests can check
+// that SingleThreaded runs initializers on the thread that calls exports and
+// that CallThreadPthread runs each of them on a thread of its own.
+#include <sys/syscall.h>
+#include <unistd.h>
+
+static long reflektor_constructor_tid;
+
+__attribute__((constructor)) static void reflektor_init(void) {
+	reflektor_constructor_tid = syscall(SYS_gettid);
+}
+
+__attribute__((visibility("default"))) int reflektor_on_constructor_thread(void) {
+	return syscall(SYS_gettid) == reflektor_constructor_tid;
+}
+
+__attribute__((visibility("default"))) long reflektor_constructor_thread(void) {
+	return reflektor_constructor_tid;
+}
+
+__attribute__((visibility("default"))) long reflektor_thread(void) {
+	return syscall(SYS_gettid);
+}
@@ -17,7 +17,10 @@ export ZIG_GLOBAL_CACHE_DIR=/tmp/zig-global-cache
 export ZIG_LOCAL_CACHE_DIR=/tmp/zig-local-cache
 
 # Validate the linux memmod backend against the C shared library test case.
-go test ./memmod -run TestLoadLibraryAndCallExport_Linux -count=1 -v
+go test ./memmod -run 'TestLoadLibraryAndCallExport_Linux|TestRelocationCoverage_Linux|TestSignalStateRestoresPayloadHandlers_Linux|TestCallExportResultErrnoAndFPEnv_Linux|TestStartEntryStaticPIE_Linux' -count=1 -v
+
+# Validate the built-in host shims in a static binary with no libc mapped.
+CGO_ENABLED=0 go test ./memmod -run TestHostShimsWithoutLibc_Linux -count=1 -v
 
 # Validate the root package linux shared-library load case too.
 go test ./... -run TestLoadGeneratedCLinuxSOAndCallStartW -count=1 -v
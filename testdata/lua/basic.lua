@@ -0,0 +1,28 @@
+#!/usr/bin/env lua
+-- Script payload used by the Lua backend tests. StartW mirrors the native
+-- fixtures: it writes "ok" to the path in REFLEKTOR_MARKER.
+local marker = reflektor.getenv("REFLEKTOR_MARKER")
+
+function StartW()
+  if marker == nil then
+    return 1
+  end
+  local ok, err = reflektor.write_file(marker, "ok")
+  if not ok then
+    reflektor.log("write marker:", err)
+    return 2
+  end
+  return 0
+end
+
+function Answer()
+  return 42
+end
+
+function Add(a, b)
+  return a + b
+end
+
+function Fail()
+  error("payload failure")
+end
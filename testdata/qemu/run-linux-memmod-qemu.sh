@@ -0,0 +1,98 @@
+#!/usr/bin/env bash
+
+# Opt-in harness that cross-compiles the linux loader tests for foreign
+# architectures and executes them under qemu-user. Fixtures are still built
+# with zig on the host; the emulated test binaries exec the native zig/go
+# toolchains directly.
+#
+# Usage: run-linux-memmod-qemu.sh [arch...]   (default: 386 arm64 riscv64)
+#
+# QEMU_LD_PREFIX_<ARCH> (for example QEMU_LD_PREFIX_ARM64=/usr/aarch64-linux-gnu)
+# points qemu at a target sysroot when binfmt/sysroot packages are not
+# installed system-wide.
+
+set -euo pipefail
+
+SCRIPT_DIR="$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)"
+REPO_ROOT="$(cd -- "${SCRIPT_DIR}/../.." && pwd)"
+OUT_DIR="${REFLEKTOR_QEMU_OUT:-/tmp/reflektor-qemu}"
+
+for tool in go zig; do
+  if ! command -v "${tool}" >/dev/null 2>&1; then
+    echo "${tool} not found in PATH" >&2
+    exit 1
+  fi
+done
+
+if [[ -z "${ZIG_GLOBAL_CACHE_DIR:-}" ]]; then
+  export ZIG_GLOBAL_CACHE_DIR="/tmp/reflektor-zig-global-cache"
+fi
+if [[ -z "${ZIG_LOCAL_CACHE_DIR:-}" ]]; then
+  export ZIG_LOCAL_CACHE_DIR="/tmp/reflektor-zig-local-cache"
+fi
+
+arches=("$@")
+if [[ ${#arches[@]} -eq 0 ]]; then
+  arches=("386" "arm64" "riscv64")
+fi
+
+run_one() {
+  local arch="$1"
+  local zig_target qemu_bin
+
+  case "${arch}" in
+    386)     zig_target="x86-linux-gnu";     qemu_bin="qemu-i386" ;;
+    amd64)   zig_target="x86_64-linux-gnu";  qemu_bin="qemu-x86_64" ;;
+    arm64)   zig_target="aarch64-linux-gnu"; qemu_bin="qemu-aarch64" ;;
+    riscv64) zig_target="riscv64-linux-gnu"; qemu_bin="qemu-riscv64" ;;
+    *)
+      echo "unsupported arch: ${arch}" >&2
+      return 1
+      ;;
+  esac
+
+  if ! command -v "${qemu_bin}" >/dev/null 2>&1; then
+    echo "${qemu_bin} not found in PATH" >&2
+    return 1
+  fi
+
+  local prefix_var="QEMU_LD_PREFIX_${arch^^}"
+  local ld_prefix="${!prefix_var:-${QEMU_LD_PREFIX:-}}"
+
+  local bin_dir="${OUT_DIR}/${arch}"
+  mkdir -p "${bin_dir}"
+
+  echo "==> building linux/${arch} test binaries"
+  (
+    cd "${REPO_ROOT}"
+    export GOOS=linux GOARCH="${arch}" CGO_ENABLED=1
+    export CC="zig cc -target ${zig_target}" CXX="zig c++ -target ${zig_target}"
+    go test -c -o "${bin_dir}/memmod.test" ./memmod
+    go test -c -o "${bin_dir}/reflektor.test" .
+  )
+
+  local -a qemu=("${qemu_bin}")
+  if [[ -n "${ld_prefix}" ]]; then
+    qemu+=("-L" "${ld_prefix}")
+  fi
+
+  echo "==> running linux/${arch} memmod tests under ${qemu_bin}"
+  (
+    cd "${REPO_ROOT}/memmod"
+    "${qemu[@]}" "${bin_dir}/memmod.test" \
+      -test.run 'TestLoadLibraryAndCallExport_Linux|TestRelocationCoverage_Linux|TestSignalStateRestoresPayloadHandlers_Linux|TestCallExportResultErrnoAndFPEnv_Linux|TestStartEntryStaticPIE_Linux' \
+      -test.count=1 -test.v
+  )
+
+  echo "==> running linux/${arch} root package tests under ${qemu_bin}"
+  (
+    cd "${REPO_ROOT}"
+    "${qemu[@]}" "${bin_dir}/reflektor.test" \
+      -test.run 'TestLoadGeneratedCLinuxSOAndCallStartW|TestLoadGeneratedGoLinuxSOAndCallStartW' \
+      -test.count=1 -test.v
+  )
+}
+
+for arch in "${arches[@]}"; do
+  run_one "${arch}"
+done
@@ -0,0 +1,39 @@
+// Swift payload for the darwin loader tests. StartWStatus returns 1337 only
+// when the Swift runtime can see the image's protocol conformances and the
+// concurrency runtime it depends on can run a task.
+
+import Dispatch
+
+protocol Greeter {
+    func greet() -> Int32
+}
+
+struct Beacon: Greeter {
+    func greet() -> Int32 { 1337 }
+}
+
+// The cast looks the conformance up at run time, in the __swift5_proto
+// sections the runtime registered when the image was loaded.
+@inline(never)
+func greeting(_ value: Any) -> Int32 {
+    guard let greeter = value as? Greeter else {
+        return -1
+    }
+    return greeter.greet()
+}
+
+final class Box: @unchecked Sendable {
+    var value: Int32 = 0
+}
+
+@_cdecl("StartWStatus")
+public func startWStatus() -> Int32 {
+    let box = Box()
+    let done = DispatchSemaphore(value: 0)
+    Task.detached {
+        box.value = greeting(Beacon())
+        done.signal()
+    }
+    done.wait()
+    return box.value
+}
@@ -0,0 +1,47 @@
+package reflektor
+
+import "runtime"
+
+// callThread serializes submitted work onto a single locked OS thread so
+// payloads observe a stable thread identity and thread-local storage.
+type callThread struct {
+	work chan func()
+	done chan struct{}
+}
+
+func newCallThread() *callThread {
+	thread := &callThread{
+		work: make(chan func()),
+		done: make(chan struct{}),
+	}
+	go thread.loop()
+	return thread
+}
+
+func (thread *callThread) loop() {
+	// The thread is intentionally never unlocked: when the goroutine exits the
+	// runtime terminates the OS thread instead of handing payload-modified
+	// thread state to other goroutines.
+	runtime.LockOSThread()
+	defer close(thread.done)
+
+	for fn := range thread.work {
+		fn()
+	}
+}
+
+// run executes fn on the dedicated thread and waits for it to return.
+func (thread *callThread) run(fn func()) {
+	finished := make(chan struct{})
+	thread.work <- func() {
+		defer close(finished)
+		fn()
+	}
+	<-finished
+}
+
+// stop terminates the dedicated thread once queued work has completed.
+func (thread *callThread) stop() {
+	close(thread.work)
+	<-thread.done
+}